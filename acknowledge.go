@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/recovery"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// exitStartupBlocked is the exit code of a strict start refused because of
+// unacknowledged inconsistencies
+const exitStartupBlocked = 3
+
+// checkStartup scans for the inconsistencies an earlier run left and, unless
+// an operator acknowledged exactly those, records them, prints a report and
+// exits with exitStartupBlocked before anything repairs them
+func checkStartup(cfg *config.Config, store *storage.Storage, paths *layout.Resolver) {
+	window := cfg.StrictManifestWindow
+	// Only JSON Lines manifest files are compared with the database
+	if cfg.ManifestFormat != manifest.FormatJSONL || cfg.ManifestSink == config.ManifestSinkDB {
+		window = 0
+	}
+	report, err := recovery.Scan(store, recovery.Options{
+		Warehouse:      cfg.Destination,
+		Manifests:      cfg.ManifestsPath,
+		ManifestWindow: window,
+		Layout:         paths,
+	})
+	if err != nil {
+		slog.Error("failed to scan for startup inconsistencies", "error", err)
+		os.Exit(1)
+	}
+	if report.Clean() {
+		slog.Info("strict startup found no inconsistencies")
+		return
+	}
+
+	ack, err := store.StartupAck()
+	if err != nil {
+		slog.Error("failed to read startup acknowledgement", "error", err)
+		os.Exit(1)
+	}
+	if ack != nil && ack.Fingerprint == report.Fingerprint {
+		slog.Warn("proceeding with acknowledged startup inconsistencies",
+			"anomalies", len(report.Anomalies),
+			"fingerprint", report.Fingerprint,
+			"acknowledged_by", ack.By,
+			"acknowledged_at", ack.At,
+			"note", ack.Note,
+		)
+		return
+	}
+
+	if err := store.BlockStartup(storage.StartupBlock{
+		Fingerprint: report.Fingerprint,
+		Anomalies:   len(report.Anomalies),
+		FoundAt:     report.ScannedAt,
+	}); err != nil {
+		slog.Error("failed to record startup inconsistencies", "error", err)
+		os.Exit(1)
+	}
+	for _, a := range report.Anomalies {
+		slog.Error("startup inconsistency", "kind", a.Kind, "path", a.Path, "detail", a.Detail)
+	}
+	if err := recovery.WriteReport(os.Stderr, report); err != nil {
+		slog.Error("failed to write startup report", "error", err)
+	}
+	slog.Error("strict startup refused to run, acknowledge the inconsistencies to proceed",
+		"anomalies", len(report.Anomalies),
+		"fingerprint", report.Fingerprint,
+		"acknowledge", "atomic-ingestor acknowledge -state-path "+cfg.StatePath+" -by <name> -note <note>",
+	)
+	os.Exit(exitStartupBlocked)
+}
+
+// runAcknowledge implements the acknowledge subcommand, which records an
+// operator's sign-off of the inconsistencies that blocked a strict start so
+// the next start proceeds and repairs them
+func runAcknowledge(args []string) {
+	fs := flag.NewFlagSet("acknowledge", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	by := fs.String("by", os.Getenv("USER"), "Who acknowledges the inconsistencies")
+	note := fs.String("note", "", "Why the inconsistencies may be repaired (required)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *by == "" || *note == "" {
+		slog.Error("acknowledge requires -by and -note", "by", *by, "note", *note)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+
+	store := openStorage(*statePath, os.Stdout)
+	ack, err := store.AcknowledgeStartup(*by, *note, time.Now())
+	if errors.Is(err, storage.ErrNoStartupBlock) {
+		slog.Error("no blocked startup to acknowledge", "state_path", *statePath)
+		os.Exit(1)
+	}
+	if err != nil {
+		slog.Error("acknowledge failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("startup inconsistencies acknowledged",
+		"anomalies", ack.Anomalies,
+		"fingerprint", ack.Fingerprint,
+		"by", ack.By,
+		"at", ack.At,
+		"note", ack.Note,
+	)
+}
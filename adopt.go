@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/adopt"
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// runAdopt implements the adopt subcommand, which seeds the state database
+// with files already present in the warehouse
+func runAdopt(args []string) {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+
+	warehouse := fs.String("warehouse", config.DefaultWarehousePath, "Warehouse directory to adopt")
+	list := fs.String("list", "", "File with one path per line to adopt instead of walking the warehouse")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+	concurrency := fs.Int("concurrency", config.DefaultConcurrency, "Number of concurrent hashing workers")
+	checkpoint := fs.String("checkpoint", "", "Checkpoint file for resuming (default <state-path>.adopt-checkpoint)")
+	checkpointEvery := fs.Int("checkpoint-every", adopt.DefaultCheckpointEvery, "Number of files between checkpoints")
+	dryRun := fs.Bool("dry-run", false, "Report how many records would be added without writing them")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *checkpoint == "" {
+		*checkpoint = *statePath + ".adopt-checkpoint"
+	}
+
+	slog.Info("starting adopt",
+		"warehouse", *warehouse,
+		"list", *list,
+		"state_path", *statePath,
+		"concurrency", *concurrency,
+		"checkpoint", *checkpoint,
+		"dry_run", *dryRun,
+	)
+
+	store := openStorage(*statePath, os.Stdout)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a := adopt.New(store, adopt.Options{
+		Root:            *warehouse,
+		ListPath:        *list,
+		Concurrency:     *concurrency,
+		CheckpointPath:  *checkpoint,
+		CheckpointEvery: *checkpointEvery,
+		DryRun:          *dryRun,
+	})
+
+	res, err := a.Run(ctx)
+	if err != nil {
+		slog.Error("adopt failed, rerun to resume from checkpoint",
+			"error", err,
+			"scanned", res.Scanned,
+			"added", res.Added,
+			"skipped", res.Skipped,
+			"failed", res.Failed,
+		)
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		slog.Info("dry run: adopt would add records",
+			"would_add", res.Added,
+			"already_present", res.Skipped,
+			"failed", res.Failed,
+		)
+		return
+	}
+
+	slog.Info("adopt finished",
+		"resumed", res.Resumed,
+		"scanned", res.Scanned,
+		"added", res.Added,
+		"skipped", res.Skipped,
+		"failed", res.Failed,
+	)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// runBackup implements the backup subcommand. It holds the maintenance lock
+// while copying the state database so the daemon pauses writes meanwhile.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	out := fs.String("out", "", "Destination file for the backup (required)")
+	lockTTL := fs.Duration("lock-ttl", time.Hour, "Maximum time the maintenance lock is held")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *out == "" {
+		slog.Error("backup destination is required", "flag", "out")
+		os.Exit(1)
+	}
+
+	store := openStorage(*statePath, os.Stdout)
+
+	m, err := store.BeginMaintenance("backup", *lockTTL)
+	if err != nil {
+		slog.Error("failed to enter maintenance mode", "error", err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	backupErr := store.Backup(*out)
+
+	if err := m.End(); err != nil {
+		slog.Error("failed to leave maintenance mode", "error", err)
+	}
+	if backupErr != nil {
+		slog.Error("backup failed", "error", backupErr)
+		os.Exit(1)
+	}
+
+	slog.Info("backup finished", "state_path", *statePath, "out", *out, "duration", time.Since(start))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/digest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// digestDate is the layout of -from and -to dates, taken as UTC midnight
+const digestDate = "2006-01-02"
+
+// runDigest implements the digest subcommand, which reports the most
+// duplicated originals and the sources that resent the most bytes in a
+// period, with the trend against the period before it
+func runDigest(args []string) {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	from := fs.String("from", "", "Start of the period, a date or RFC 3339 time (default 7 days before -to)")
+	to := fs.String("to", "", "End of the period, exclusive, a date or RFC 3339 time (default now)")
+	top := fs.Int("top", config.DefaultDigestTop, "How many originals and sources to list")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if *top <= 0 {
+		slog.Error("invalid top", "top", *top)
+		os.Exit(1)
+	}
+	end := time.Now().UTC()
+	if *to != "" {
+		var err error
+		if end, err = parseDigestTime(*to); err != nil {
+			slog.Error("invalid end of period", "to", *to, "error", err)
+			os.Exit(1)
+		}
+	}
+	start := end.AddDate(0, 0, -7)
+	if *from != "" {
+		var err error
+		if start, err = parseDigestTime(*from); err != nil {
+			slog.Error("invalid start of period", "from", *from, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	report, err := digest.Build(store, start, end, *top)
+	if err != nil {
+		slog.Error("digest failed", "error", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		err = digest.WriteJSON(os.Stdout, report)
+	} else {
+		err = digest.WriteTable(os.Stdout, report)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// parseDigestTime parses a date as UTC midnight, or an RFC 3339 time
+func parseDigestTime(s string) (time.Time, error) {
+	if t, err := time.Parse(digestDate, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want %s or RFC 3339: %w", digestDate, err)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// configDriftKind is the outbox kind of the alert sent when a run starts
+// with a configuration differing from the previous run's
+const configDriftKind = "config_drift"
+
+// configDriftEvent is the notification of a configuration drift
+type configDriftEvent struct {
+	Kind string    `json:"kind"`
+	At   time.Time `json:"at"`
+	// PreviousAt is when the previous run recorded its configuration
+	PreviousAt time.Time       `json:"previous_at"`
+	Changes    []config.Change `json:"changes"`
+}
+
+// recordConfig stores the effective configuration of this run and logs
+// every field changed since the previous run, alerting through the outbox
+// with ConfigDriftAlert. Failing to record it does not stop the run.
+func recordConfig(cfg *config.Config, store *storage.Storage) {
+	host, _ := os.Hostname()
+	rec := storage.ConfigRecord{
+		RecordedAt: time.Now(),
+		Host:       host,
+		PID:        os.Getpid(),
+		Config:     config.Effective(cfg),
+	}
+	previous, err := store.RecordConfig(rec, config.DefaultConfigHistory)
+	if err != nil {
+		slog.Error("failed to record configuration", "error", err)
+		return
+	}
+	if previous == nil {
+		slog.Info("configuration recorded for the first run")
+		return
+	}
+	changes := config.Diff(previous.Config, rec.Config)
+	if len(changes) == 0 {
+		slog.Info("configuration unchanged since the previous run", "previous_at", previous.RecordedAt)
+		return
+	}
+
+	fields := make([]string, 0, len(changes))
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+		slog.Warn("configuration drift", "field", c.Field, "old", c.Old, "new", c.New)
+	}
+	slog.Warn("configuration changed since the previous run",
+		"changed", len(changes),
+		"fields", fields,
+		"previous_at", previous.RecordedAt,
+		"previous_host", previous.Host,
+	)
+
+	if !cfg.ConfigDriftAlert {
+		return
+	}
+	payload, err := json.Marshal(configDriftEvent{Kind: configDriftKind, At: rec.RecordedAt, PreviousAt: previous.RecordedAt, Changes: changes})
+	if err != nil {
+		slog.Error("failed to encode configuration drift alert", "error", err)
+		return
+	}
+	err = store.EnqueueOutbox(&storage.OutboxMessage{
+		CreatedAt: rec.RecordedAt,
+		Kind:      configDriftKind,
+		Payload:   string(payload),
+		Ready:     true,
+	})
+	if err != nil {
+		slog.Error("failed to enqueue configuration drift alert", "error", err)
+	}
+}
+
+// runConfigHistory implements the config-history subcommand, which prints
+// the configurations of the latest runs, each with what changed since the
+// run before it
+func runConfigHistory(args []string) {
+	fs := flag.NewFlagSet("config-history", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	limit := fs.Int("limit", 10, "How many of the latest runs to show (0 shows all recorded)")
+	full := fs.Bool("full", false, "Print every field of each configuration instead of what changed")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	history, err := store.ConfigHistory()
+	if err != nil {
+		slog.Error("config history failed", "error", err)
+		os.Exit(1)
+	}
+	runs := configRuns(history, *limit)
+	if *format == "json" {
+		err = writeHistoryJSON(os.Stdout, runs)
+	} else {
+		err = writeConfigTable(os.Stdout, runs, *full)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// configRun is a recorded configuration with its changes since the run
+// before it, nil for the oldest one recorded
+type configRun struct {
+	storage.ConfigRecord
+	Changes []config.Change `json:"changes"`
+}
+
+// configRuns returns the latest limit runs of history, newest first
+func configRuns(history []storage.ConfigRecord, limit int) []configRun {
+	runs := make([]configRun, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		if limit > 0 && len(runs) == limit {
+			break
+		}
+		run := configRun{ConfigRecord: history[i]}
+		if i > 0 {
+			run.Changes = config.Diff(history[i-1].Config, history[i].Config)
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// writeConfigTable writes each run with its changes, or every field when
+// full is set or there is no earlier run to compare with
+func writeConfigTable(w io.Writer, runs []configRun, full bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, run := range runs {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Recorded:\t%s\n", run.RecordedAt.UTC().Format(time.RFC3339))
+		fmt.Fprintf(tw, "Host:\t%s (pid %d)\n", run.Host, run.PID)
+		if full || i == len(runs)-1 && run.Changes == nil {
+			fields := make([]string, 0, len(run.Config))
+			for field := range run.Config {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			fmt.Fprintln(tw, "FIELD\tVALUE")
+			for _, field := range fields {
+				fmt.Fprintf(tw, "%s\t%s\n", field, run.Config[field])
+			}
+			continue
+		}
+		if len(run.Changes) == 0 {
+			fmt.Fprintln(tw, "No changes since the previous run")
+			continue
+		}
+		fmt.Fprintln(tw, "FIELD\tOLD\tNEW")
+		for _, c := range run.Changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Field, c.Old, c.New)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write config history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,710 @@
+// Package e2e runs the built binary against temporary directories, covering
+// flag parsing, signal handling and the main loop that in-process tests of
+// the components skip
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// binary is the ingestor built once by TestMain
+var binary string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "atomic-ingestor-e2e-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "create build directory:", err)
+		return 1
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	binary = filepath.Join(dir, "atomic-ingestor")
+	build := exec.Command("go", "build", "-o", binary, "..")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "build binary:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// env is the directories of one run of the binary
+type env struct {
+	input, warehouse, manifests, quarantine, state string
+}
+
+func newEnv(t *testing.T) env {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	root := t.TempDir()
+	e := env{
+		input:      filepath.Join(root, "input"),
+		warehouse:  filepath.Join(root, "warehouse"),
+		manifests:  filepath.Join(root, "manifests"),
+		quarantine: filepath.Join(root, "quarantine"),
+		state:      filepath.Join(root, "state.db"),
+	}
+	for _, dir := range []string{e.input, e.warehouse, e.manifests} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	return e
+}
+
+// args returns the directory flags of e followed by extra
+func (e env) args(extra ...string) []string {
+	return append([]string{
+		"-input", e.input,
+		"-warehouse", e.warehouse,
+		"-manifests", e.manifests,
+		"-quarantine", e.quarantine,
+		"-state-path", e.state,
+	}, extra...)
+}
+
+// syncBuffer collects the output of the binary while tests read it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// process is a running binary
+type process struct {
+	cmd  *exec.Cmd
+	out  *syncBuffer
+	done chan struct{}
+	err  error
+}
+
+// start launches the binary with args
+func start(t *testing.T, args ...string) *process {
+	t.Helper()
+	p := &process{out: &syncBuffer{}, done: make(chan struct{})}
+	p.cmd = exec.Command(binary, args...)
+	p.cmd.Stdout = p.out
+	p.cmd.Stderr = p.out
+	if err := p.cmd.Start(); err != nil {
+		t.Fatalf("failed to start binary: %v", err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.done)
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-p.done:
+		default:
+			_ = p.cmd.Process.Kill()
+			<-p.done
+		}
+		if t.Failed() {
+			t.Logf("binary output:\n%s", p.out.String())
+		}
+	})
+	return p
+}
+
+// execute runs the binary with args to completion and returns its exit code
+func execute(t *testing.T, args ...string) (int, *process) {
+	t.Helper()
+	p := start(t, args...)
+	return p.wait(t), p
+}
+
+// wait waits for the binary to exit and returns its exit code
+func (p *process) wait(t *testing.T) int {
+	t.Helper()
+	select {
+	case <-p.done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("binary did not exit")
+	}
+	var exit *exec.ExitError
+	if errors.As(p.err, &exit) {
+		return exit.ExitCode()
+	}
+	if p.err != nil {
+		t.Fatalf("binary failed: %v", p.err)
+	}
+	return 0
+}
+
+// stop sends SIGTERM and returns the exit code
+func (p *process) stop(t *testing.T) int {
+	t.Helper()
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to signal binary: %v", err)
+	}
+	return p.wait(t)
+}
+
+// logs parses the structured log lines written so far, skipping any that
+// are not JSON
+func (p *process) logs() []map[string]any {
+	var lines []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(p.out.String()))
+	for scanner.Scan() {
+		var line map[string]any
+		if json.Unmarshal(scanner.Bytes(), &line) == nil {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// logged returns the first log line with msg whose attributes include attrs
+func (p *process) logged(msg string, attrs map[string]any) map[string]any {
+	for _, line := range p.logs() {
+		if line["msg"] != msg {
+			continue
+		}
+		match := true
+		for k, v := range attrs {
+			if line[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return line
+		}
+	}
+	return nil
+}
+
+// waitLogged waits for a log line as logged finds it
+func (p *process) waitLogged(t *testing.T, msg string, attrs map[string]any) map[string]any {
+	t.Helper()
+	var line map[string]any
+	eventually(t, fmt.Sprintf("log line %q %v", msg, attrs), func() bool {
+		line = p.logged(msg, attrs)
+		return line != nil
+	})
+	return line
+}
+
+// eventually polls cond until it holds or the deadline passes
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// write creates a file below the input directory
+func (e env) write(t *testing.T, rel, content string) string {
+	t.Helper()
+	path := filepath.Join(e.input, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", rel, err)
+	}
+	return path
+}
+
+// manifestEntries reads every entry of the JSONL manifest files
+func (e env) manifestEntries(t *testing.T) []manifest.Entry {
+	t.Helper()
+	var entries []manifest.Entry
+	err := filepath.WalkDir(e.manifests, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var entry manifest.Entry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return fmt.Errorf("decode %s: %w", path, err)
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read manifests: %v", err)
+	}
+	return entries
+}
+
+// files returns the records of the state database
+func (e env) files(t *testing.T) []storage.File {
+	t.Helper()
+	store, err := storage.OpenReadOnly(e.state)
+	if err != nil {
+		t.Fatalf("failed to open state database: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	files, err := store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	return files
+}
+
+// regularFiles lists the regular files below dir
+func regularFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err == nil && d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to walk %s: %v", dir, err)
+	}
+	return files
+}
+
+// exists reports whether path exists
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestStabilityWindow_IngestsAndShutsDown(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	e.write(t, "acme/orders.csv", "id,total\n1,10\n")
+	dest := filepath.Join(e.warehouse, "acme", "orders.csv")
+	eventually(t, "file in the warehouse", func() bool { return exists(dest) })
+
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+	p.waitLogged(t, "received shutdown signal", map[string]any{"signal": float64(syscall.SIGTERM)})
+	p.waitLogged(t, "shutting down gracefully", nil)
+
+	if exists(filepath.Join(e.input, "acme", "orders.csv")) {
+		t.Error("source still in the input directory")
+	}
+	entries := e.manifestEntries(t)
+	if len(entries) != 1 || entries[0].Status != status.Ingested || entries[0].DestPath != dest {
+		t.Errorf("manifest entries = %+v, want one ingested to %s", entries, dest)
+	}
+	files := e.files(t)
+	if len(files) != 1 || files[0].DestPath != dest || files[0].Status != status.Ingested || files[0].Source != "acme" {
+		t.Errorf("file records = %+v, want one ingested from acme", files)
+	}
+}
+
+func TestSidecar_WaitsForMarker(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "sidecar")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	e.write(t, "data.csv", "a,b\n")
+	time.Sleep(2 * time.Second)
+	if exists(filepath.Join(e.warehouse, "data.csv")) {
+		t.Fatal("file ingested before its sidecar arrived")
+	}
+
+	e.write(t, "data.csv.ok", "")
+	eventually(t, "file in the warehouse", func() bool { return exists(filepath.Join(e.warehouse, "data.csv")) })
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+}
+
+func TestRename_IngestsOnArrival(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "rename")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	// Written aside under a temporary name, then renamed into place
+	tmp := e.write(t, "data.csv.tmp", "a,b\n")
+	if err := os.Rename(tmp, filepath.Join(e.input, "data.csv")); err != nil {
+		t.Fatalf("failed to rename into place: %v", err)
+	}
+	eventually(t, "file in the warehouse", func() bool { return exists(filepath.Join(e.warehouse, "data.csv")) })
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+}
+
+func TestDuplicate_Golden(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	content := "id\n1\n"
+	e.write(t, "first.csv", content)
+	eventually(t, "first file in the warehouse", func() bool { return exists(filepath.Join(e.warehouse, "first.csv")) })
+	e.write(t, "again.csv", content)
+	line := p.waitLogged(t, "file already processed, skipping", map[string]any{"dedup": manifest.DedupHash})
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	original := filepath.Join(e.warehouse, "first.csv")
+	if line["original_destination"] != original {
+		t.Errorf("duplicate log line = %v, want the original destination %s", line, original)
+	}
+	if exists(filepath.Join(e.warehouse, "again.csv")) {
+		t.Error("duplicate copied into the warehouse")
+	}
+	var statuses []string
+	for _, entry := range e.manifestEntries(t) {
+		statuses = append(statuses, string(entry.Status)+":"+filepath.Base(entry.SourcePath)+"->"+filepath.Base(entry.DestPath))
+	}
+	if want := "ingested:first.csv->first.csv,duplicate:again.csv->first.csv"; strings.Join(statuses, ",") != want {
+		t.Errorf("manifest = %v, want %s", statuses, want)
+	}
+	if files := e.files(t); len(files) != 1 {
+		t.Errorf("file records = %+v, want only the original", files)
+	}
+}
+
+func TestQuarantine_Golden(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1",
+		"-max-name-bytes", "32", "-shorten-long-paths=false")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	src := e.write(t, "a-name-well-beyond-the-thirty-two-byte-limit.csv", "x\n")
+	eventually(t, "file quarantined", func() bool { return !exists(src) })
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	records, err := filepath.Glob(filepath.Join(e.quarantine, "*.reason.json"))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("quarantine records = %v, %v, want one", records, err)
+	}
+	data, err := os.ReadFile(records[0])
+	if err != nil {
+		t.Fatalf("failed to read quarantine record: %v", err)
+	}
+	var record struct {
+		Reason     string `json:"reason"`
+		SourcePath string `json:"source_path"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil || record.Reason != "path_too_long" || record.SourcePath != src {
+		t.Errorf("quarantine record = %s, %v, want path_too_long for %s", data, err, src)
+	}
+	if !exists(strings.TrimSuffix(records[0], ".reason.json")) {
+		t.Error("quarantined file missing next to its record")
+	}
+	entries := e.manifestEntries(t)
+	if len(entries) != 1 || entries[0].Status != status.Quarantined || entries[0].Reason != "path_too_long" {
+		t.Errorf("manifest entries = %+v, want one quarantined", entries)
+	}
+	if files := e.files(t); len(files) != 0 {
+		t.Errorf("file records = %+v, want none", files)
+	}
+}
+
+func TestDryRun_ChangesNothing(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1", "-dry-run")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	src := e.write(t, "data.csv", "a\n")
+	p.waitLogged(t, "dry run: would process file", map[string]any{"path": src})
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	if !exists(src) || exists(filepath.Join(e.warehouse, "data.csv")) {
+		t.Error("dry run moved the file")
+	}
+	if entries := e.manifestEntries(t); len(entries) != 0 {
+		t.Errorf("dry run wrote manifest entries: %+v", entries)
+	}
+}
+
+// The binary has no one-shot ingest mode, so -check-config covers a run that
+// exits on its own
+func TestCheckConfig_Exits(t *testing.T) {
+	e := newEnv(t)
+	code, p := execute(t, e.args("-check-config")...)
+	if code != 0 {
+		t.Errorf("exit code = %d for a valid configuration, want 0", code)
+	}
+	if p.logged("atomic ingestor started, waiting for files", nil) != nil {
+		t.Error("-check-config entered the main loop")
+	}
+}
+
+func TestBadConfig_ExitsNonZero(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		msg  string
+	}{
+		{"unknown mode", []string{"-mode", "bogus"}, "invalid method name"},
+		{"missing config file", []string{"-config", "/nonexistent/config.yaml"}, ""},
+		{"negative digest interval", []string{"-digest-interval", "-1h"}, "invalid duplicate digest options"},
+		{"unknown flag", []string{"-no-such-flag"}, ""},
+		{"versioned multi-part files", []string{"-version-on-name-conflict", "sequence", "-part-pattern", `^(.+)\.(\d+)$`}, "versioning does not support multi-part files or batches"},
+		{"versioned batches", []string{"-version-on-name-conflict", "timestamp", "-batch-dirs", "batch_*"}, "versioning does not support multi-part files or batches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t)
+			code, p := execute(t, e.args(tt.args...)...)
+			if code == 0 {
+				t.Errorf("exit code = 0, want a failure")
+			}
+			if tt.msg != "" {
+				if line := p.logged(tt.msg, nil); line == nil || line["level"] != "ERROR" {
+					t.Errorf("no error log line %q in:\n%s", tt.msg, p.out.String())
+				}
+			}
+			if files := regularFiles(t, e.warehouse); len(files) != 0 {
+				t.Errorf("warehouse written despite the bad configuration: %v", files)
+			}
+		})
+	}
+}
+
+func TestStrictStartup_BlocksUntilAcknowledged(t *testing.T) {
+	e := newEnv(t)
+	args := e.args("-mode", "stability_window", "-stability-seconds", "1", "-strict-startup")
+
+	// A clean start proceeds
+	p := start(t, args...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	orphan := filepath.Join(e.warehouse, "acme", "orders.csv.tmp")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(orphan, []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, p := execute(t, args...)
+	if code != 3 {
+		t.Fatalf("exit code = %d with an orphan temp file, want 3", code)
+	}
+	if p.logged("startup inconsistency", map[string]any{"kind": "orphan_temp", "path": orphan}) == nil {
+		t.Error("orphan temp file missing from the startup report")
+	}
+	if p.logged("atomic ingestor started, waiting for files", nil) != nil {
+		t.Error("blocked start entered the main loop")
+	}
+
+	if code, _ := execute(t, "acknowledge", "-state-path", e.state, "-by", "alice", "-note", "crash at 3am"); code != 0 {
+		t.Fatalf("acknowledge exit code = %d, want 0", code)
+	}
+	if code, _ := execute(t, "acknowledge", "-state-path", e.state, "-by", "alice", "-note", "again"); code == 0 {
+		t.Error("acknowledge succeeded with nothing blocked")
+	}
+
+	p = start(t, args...)
+	p.waitLogged(t, "proceeding with acknowledged startup inconsistencies", map[string]any{"acknowledged_by": "alice", "note": "crash at 3am"})
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+}
+
+func TestConfigDrift_LoggedOnRestart(t *testing.T) {
+	e := newEnv(t)
+
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "configuration recorded for the first run", nil)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	p = start(t, e.args("-mode", "stability_window", "-stability-seconds", "2")...)
+	p.waitLogged(t, "configuration drift", map[string]any{"field": "stability_seconds", "old": "1", "new": "2"})
+	p.waitLogged(t, "configuration changed since the previous run", map[string]any{"changed": float64(1)})
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	code, h := execute(t, "config-history", "-state-path", e.state)
+	if code != 0 {
+		t.Fatalf("config-history exit code = %d, want 0", code)
+	}
+	if !strings.Contains(h.out.String(), "stability_seconds") {
+		t.Errorf("config-history output lacks the changed field:\n%s", h.out.String())
+	}
+}
+
+func TestVerify_CrossChecksXattrs(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1", "-set-xattrs")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	e.write(t, "acme/orders.csv", "id,total\n1,10\n")
+	dest := filepath.Join(e.warehouse, "acme", "orders.csv")
+	eventually(t, "file in the warehouse", func() bool { return exists(dest) })
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	if code, v := execute(t, "verify", "-state-path", e.state, "-hash"); code != 0 {
+		t.Fatalf("verify exit code = %d for an intact warehouse, want 0:\n%s", code, v.out.String())
+	}
+
+	err := fileops.SetXattrs(dest, map[string]string{processor.XattrSource: "globex"})
+	if errors.Is(err, fileops.ErrXattrUnsupported) {
+		t.Skip("filesystem does not support extended attributes")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, v := execute(t, "verify", "-state-path", e.state)
+	if code == 0 {
+		t.Error("verify succeeded with a tampered extended attribute")
+	}
+	if !strings.Contains(v.out.String(), "xattr_mismatch") {
+		t.Errorf("verify output lacks the mismatch:\n%s", v.out.String())
+	}
+}
+
+func TestManifestLock_RefusesSecondInstance(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+
+	// Everything but the manifests directory is its own
+	other := newEnv(t)
+	other.manifests = e.manifests
+	code, second := execute(t, other.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	if code == 0 {
+		t.Error("second instance on the same manifests exited 0")
+	}
+	if second.logged("manifests directory is in use", nil) == nil {
+		t.Errorf("second instance did not report the lock:\n%s", second.out.String())
+	}
+
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+	p = start(t, other.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+}
+
+func TestWarehouseMarker_RefusesOtherConfiguration(t *testing.T) {
+	e := newEnv(t)
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+	marker, err := destination.ReadMarker(e.warehouse)
+	if err != nil || marker == nil {
+		t.Fatalf("ReadMarker() = %+v, %v, want the marker of the run", marker, err)
+	}
+	if marker.Tool != "atomic-ingestor" || marker.Manifests != e.manifests || marker.State != e.state || marker.Layout.Warehouse != "{rel_path}" || marker.StartedAt.IsZero() {
+		t.Errorf("marker = %+v", marker)
+	}
+
+	// A second ingestor with its own manifests onto the same warehouse
+	other := newEnv(t)
+	other.warehouse = e.warehouse
+	code, refused := execute(t, other.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	if code == 0 {
+		t.Error("ingestor with other manifests on a marked warehouse exited 0")
+	}
+	line := refused.logged("warehouse is marked by another configuration; pass -force-adopt-warehouse to take it over", nil)
+	if line == nil || fmt.Sprint(line["differ"]) != "[manifests]" {
+		t.Errorf("refusal not logged:\n%s", refused.out.String())
+	}
+	if again, _ := destination.ReadMarker(e.warehouse); again == nil || again.Manifests != e.manifests {
+		t.Errorf("refused start rewrote the marker: %+v", again)
+	}
+
+	p = start(t, other.args("-mode", "stability_window", "-stability-seconds", "1", "-force-adopt-warehouse")...)
+	p.waitLogged(t, "taking over a warehouse marked by another configuration", nil)
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Fatalf("exit code = %d after SIGTERM, want 0", code)
+	}
+	if taken, _ := destination.ReadMarker(e.warehouse); taken == nil || taken.Manifests != other.manifests {
+		t.Errorf("marker after the takeover = %+v, want the manifests %s", taken, other.manifests)
+	}
+}
+
+func TestScratch_ClearedNotPromotedOnStart(t *testing.T) {
+	e := newEnv(t)
+	// A copy-first working copy orphaned by a crash, under the key its
+	// record would have
+	orphan := filepath.Join(e.warehouse, "_scratch", "dead", "acme", "orders.csv")
+	if err := os.MkdirAll(filepath.Dir(orphan), 0o755); err != nil {
+		t.Fatalf("failed to create scratch directory: %v", err)
+	}
+	if err := os.WriteFile(orphan, []byte("id,total\n1,"), 0o644); err != nil {
+		t.Fatalf("failed to write scratch copy: %v", err)
+	}
+
+	p := start(t, e.args("-mode", "stability_window", "-stability-seconds", "1")...)
+	p.waitLogged(t, "deleted scratch copies", map[string]any{"count": float64(1)})
+	p.waitLogged(t, "atomic ingestor started, waiting for files", nil)
+	if code := p.stop(t); code != 0 {
+		t.Errorf("exit code = %d after SIGTERM, want 0", code)
+	}
+
+	if exists(filepath.Dir(orphan)) {
+		t.Error("scratch copy left in place")
+	}
+	if exists(filepath.Join(e.warehouse, "acme", "orders.csv")) {
+		t.Error("scratch copy promoted into the warehouse")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// runForceIngest implements the force-ingest subcommand, which lifts the
+// hold of a file held for shrinking so the running daemon ingests it on its
+// next pass. Without -id or -path it lists the held files.
+func runForceIngest(args []string) {
+	fs := flag.NewFlagSet("force-ingest", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	id := fs.Uint("id", 0, "ID of the hold to lift, as the suspicious_shrink alert reports it")
+	path := fs.String("path", "", "Source path of the held file, instead of -id")
+	by := fs.String("by", os.Getenv("USER"), "Who forces the file in")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store := openStorage(*statePath, os.Stdout)
+
+	if *id == 0 && *path == "" {
+		holds, err := store.HeldFiles()
+		if err != nil {
+			slog.Error("failed to list held files", "error", err)
+			os.Exit(1)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(holds)
+		return
+	}
+	if *by == "" {
+		slog.Error("force-ingest requires -by")
+		os.Exit(1)
+	}
+	if *path != "" {
+		hold, err := store.FindHold(*path)
+		if err != nil {
+			slog.Error("failed to look up held file", "path", *path, "error", err)
+			os.Exit(1)
+		}
+		if hold == nil {
+			slog.Error("file is not held", "path", *path)
+			os.Exit(1)
+		}
+		*id = hold.ID
+	}
+
+	hold, err := store.ReleaseHold(*id, *by, time.Now())
+	if errors.Is(err, storage.ErrNoHold) {
+		slog.Error("no such held file", "id", *id)
+		os.Exit(1)
+	}
+	if err != nil {
+		slog.Error("force-ingest failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("held file forced in, the daemon ingests it on its next pass",
+		"id", hold.ID,
+		"path", hold.Path,
+		"sha256", hold.SHA256,
+		"size", hold.Size,
+		"previous_size", hold.PreviousSize,
+		"by", hold.ReleasedBy,
+	)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// historyReport is the per-file report of the history subcommand
+type historyReport struct {
+	Query string `json:"query"`
+	// File is the record of the ingested file, nil when it never was
+	File     *storage.File     `json:"file"`
+	Attempts []storage.Attempt `json:"attempts"`
+}
+
+// runHistory implements the history subcommand, which prints the attempt
+// timeline of one file, or with -retried the files that needed more than
+// one attempt
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: atomic-ingestor history [flags] <source path or sha256>")
+		fmt.Fprintln(fs.Output(), "       atomic-ingestor history -retried [flags]")
+		fs.PrintDefaults()
+	}
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	retried := fs.Bool("retried", false, "List the files that needed more than one attempt instead")
+	since := fs.Duration("since", 24*time.Hour, "With -retried, how far back to look")
+	limit := fs.Int("limit", 50, "With -retried, how many files to list (0 lists all)")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if *retried == (fs.NArg() == 1) || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	if *retried {
+		files, err := store.RetriedFiles(time.Now().Add(-*since), *limit)
+		if err != nil {
+			slog.Error("history failed", "error", err)
+			os.Exit(1)
+		}
+		if *format == "json" {
+			err = writeHistoryJSON(os.Stdout, files)
+		} else {
+			err = writeRetriedTable(os.Stdout, files)
+		}
+		if err != nil {
+			slog.Error("failed to write report", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	report := historyReport{Query: fs.Arg(0)}
+	if report.Attempts, err = store.Attempts(report.Query); err != nil {
+		slog.Error("history failed", "error", err)
+		os.Exit(1)
+	}
+	// Source paths are recorded absolute
+	if abs, err := filepath.Abs(report.Query); len(report.Attempts) == 0 && err == nil && abs != report.Query {
+		if report.Attempts, err = store.Attempts(abs); err != nil {
+			slog.Error("history failed", "error", err)
+			os.Exit(1)
+		}
+	}
+	sha := report.Query
+	for _, a := range report.Attempts {
+		if a.SHA256 != "" {
+			sha = a.SHA256
+		}
+	}
+	if report.File, err = store.FindBySHA256(sha); err != nil {
+		slog.Error("history failed", "error", err)
+		os.Exit(1)
+	}
+	if report.File == nil && len(report.Attempts) == 0 {
+		slog.Error("no history for file", "query", report.Query)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		err = writeHistoryJSON(os.Stdout, report)
+	} else {
+		err = writeHistoryTable(os.Stdout, report)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// writeHistoryJSON writes v as indented JSON
+func writeHistoryJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encode history: %w", err)
+	}
+	return nil
+}
+
+// writeHistoryTable writes the record and attempt timeline of one file
+func writeHistoryTable(w io.Writer, report historyReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if f := report.File; f != nil {
+		fmt.Fprintf(tw, "File:\t%s\n", f.DestPath)
+		fmt.Fprintf(tw, "SHA256:\t%s\n", f.SHA256)
+		fmt.Fprintf(tw, "Status:\t%s\n", f.Status)
+		fmt.Fprintf(tw, "Processed:\t%s\n", f.ProcessedAt.UTC().Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(tw, "File:\t%s (not ingested)\n", report.Query)
+	}
+
+	fmt.Fprintln(tw, "\nAttempts")
+	fmt.Fprintln(tw, "#\tSTARTED\tDURATION\tOUTCOME\tINSTANCE\tPATH\tERROR")
+	for _, a := range report.Attempts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.Number, a.StartedAt.UTC().Format(time.RFC3339), a.EndedAt.Sub(a.StartedAt).Round(time.Millisecond),
+			a.Outcome, a.Instance, a.Path, a.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// writeRetriedTable writes the files that needed retries
+func writeRetriedTable(w io.Writer, files []storage.RetriedFile) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ATTEMPTS\tFIRST STARTED\tLAST ENDED\tOUTCOME\tPATH\tERROR")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			f.Attempts, f.StartedAt.UTC().Format(time.RFC3339), f.EndedAt.UTC().Format(time.RFC3339),
+			f.Outcome, f.Path, f.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write retried files: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,637 @@
+// Package admin serves the operational HTTP API and the embedded status page
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/dbguard"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/inputspace"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// shutdownTimeout bounds how long Run waits for in-flight requests
+const shutdownTimeout = 5 * time.Second
+
+// The overview lists up to retriedLimit files that needed retries in the
+// last retriedWindow
+const (
+	retriedWindow = 24 * time.Hour
+	retriedLimit  = 20
+)
+
+// Options configures a Server
+type Options struct {
+	Processor *processor.Processor
+	Watcher   *watcher.Watcher
+	Storage   *storage.Storage
+	// QuarantinePath is listed by /api/quarantine
+	QuarantinePath string
+	// Token authorizes mutating endpoints as "Authorization: Bearer <token>".
+	// Mutating endpoints are refused when it is empty.
+	Token string
+	// Redactor pseudonymizes sensitive names in responses. File events,
+	// and so recent files and stats, are redacted by the processor.
+	Redactor *redact.Redactor
+	// Outbox delivers notifications, nil when they are disabled
+	Outbox *outbox.Dispatcher
+	// Supervisor runs the background loops /api/health reports on, nil
+	// when none is supervised
+	Supervisor *supervisor.Supervisor
+	// InputSpace checks the input filesystem, nil when disabled
+	InputSpace *inputspace.Monitor
+	// Topology is how the directories share filesystems, reported by
+	// /api/health; nil when not checked
+	Topology *config.Topology
+	// Database guards the state database; the daemon is not ready while it
+	// is not healthy. Nil when not guarded.
+	Database *dbguard.Guard
+}
+
+// Server is the admin HTTP API
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+}
+
+// New creates a Server and registers its routes
+func New(opts Options) *Server {
+	s := &Server{opts: opts, mux: http.NewServeMux()}
+
+	ui, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		panic(fmt.Sprintf("embedded ui: %v", err))
+	}
+
+	s.mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
+	s.mux.Handle("GET /ui/", http.StripPrefix("/ui/", http.FileServerFS(ui)))
+	s.mux.HandleFunc("GET /ui", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
+	})
+
+	s.mux.HandleFunc("GET /api/health", s.health)
+	s.mux.HandleFunc("GET /api/overview", s.overview)
+	s.mux.HandleFunc("GET /api/tracked", s.tracked)
+	s.mux.HandleFunc("GET /api/recent", s.recent)
+	s.mux.HandleFunc("GET /api/stats", s.stats)
+	s.mux.HandleFunc("GET /api/watermark", s.watermark)
+	s.mux.HandleFunc("GET /api/quarantine", s.quarantine)
+	s.mux.HandleFunc("GET /api/storms", s.storms)
+	s.mux.HandleFunc("GET /api/reservations", s.reservations)
+	s.mux.HandleFunc("GET /api/holds", s.holds)
+	s.mux.HandleFunc("POST /api/pause", s.authorized(s.pause))
+	s.mux.HandleFunc("POST /api/resume", s.authorized(s.resume))
+	s.mux.HandleFunc("POST /api/storms/{source}/resume", s.authorized(s.resumeSource))
+	s.mux.HandleFunc("POST /api/holds/{id}/force-ingest", s.authorized(s.forceIngest))
+	s.mux.HandleFunc("POST /api/rescan", s.authorized(s.rescan))
+	s.mux.HandleFunc("POST /api/self-test", s.authorized(s.selfTest))
+	s.mux.HandleFunc("POST /api/flush-outbox", s.authorized(s.flushOutbox))
+
+	return s
+}
+
+// Handler returns the HTTP handler serving every route
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Run serves on addr until ctx is canceled
+func (s *Server) Run(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("serve admin api on %s: %w", addr, err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shut down admin api: %w", err)
+		}
+		return nil
+	}
+}
+
+// authorized rejects requests without the configured bearer token
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Token == "" {
+			writeError(w, http.StatusForbidden, "admin token not configured")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Overview combines the watcher, processor and database state the status
+// page needs in a single response
+type Overview struct {
+	Paused      bool                             `json:"paused"`
+	Maintenance *storage.MaintenanceLock         `json:"maintenance"`
+	Tracked     []watcher.TrackedFile            `json:"tracked"`
+	Events      watcher.EventStats               `json:"events"`
+	Scan        watcher.ScanProgress             `json:"scan"`
+	Hashing     map[string]int64                 `json:"hashing"`
+	Stats       processor.Stats                  `json:"stats"`
+	Pipeline    map[string]processor.StageStats  `json:"pipeline"`
+	Steps       map[string]processor.StepStats   `json:"steps"`
+	Tenants     map[string]processor.TenantStats `json:"tenants"`
+	// Priorities are the gauges of each priority class, empty when none
+	// are configured
+	Priorities map[string]processor.ClassStats `json:"priorities"`
+	// FilesByStatus counts the file records by status metric label, every
+	// status a record can hold included at zero
+	FilesByStatus map[string]int64    `json:"files_by_status"`
+	Quarantined   int                 `json:"quarantined"`
+	Watermark     processor.Watermark `json:"watermark"`
+	// Retried are the files that needed more than one attempt recently
+	Retried []storage.RetriedFile `json:"retried"`
+	// Storms are the sources in a resend storm or paused by one
+	Storms []processor.StormStatus `json:"storms"`
+	// Outbox is omitted when notifications are disabled
+	Outbox *outbox.Stats `json:"outbox,omitempty"`
+	// InputSpace is omitted when the input filesystem is not checked or
+	// before the first check
+	InputSpace *inputspace.Status `json:"input_space,omitempty"`
+	// InputUsage is omitted until the input tree was walked
+	InputUsage *watcher.InputUsage `json:"input_usage,omitempty"`
+	// Destination is omitted when the warehouse is not probed
+	Destination *destination.HealthStatus `json:"destination,omitempty"`
+}
+
+// recordStatus reports whether a file record can hold s. The others are
+// outcomes of attempts, never stored on a record.
+func recordStatus(s status.Status) bool {
+	switch s {
+	case status.Ingested, status.Adopted, status.Suspect, status.MissingRestored, status.Superseded:
+		return true
+	case status.Duplicate, status.DuplicateSummary, status.Quarantined, status.Vanished, status.Failed:
+	}
+	return false
+}
+
+// filesByStatus keys counts by metric label, so a status this version does
+// not know, written by a newer one, is counted as unknown
+func filesByStatus(counts map[status.Status]int64) map[string]int64 {
+	labels := make(map[string]int64, len(counts))
+	for _, st := range status.All() {
+		if recordStatus(st) {
+			labels[st.Label()] = 0
+		}
+	}
+	for st, n := range counts {
+		labels[st.Label()] += n
+	}
+	return labels
+}
+
+func (s *Server) overview(w http.ResponseWriter, _ *http.Request) {
+	lock, err := s.opts.Storage.MaintenanceStatus()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	counts, err := s.opts.Storage.CountByStatus()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	items, err := listQuarantine(s.opts.QuarantinePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	retried, err := s.opts.Storage.RetriedFiles(time.Now().Add(-retriedWindow), retriedLimit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range retried {
+		retried[i].Path = s.opts.Redactor.Text(retried[i].Path)
+		retried[i].Error = s.opts.Redactor.Text(retried[i].Error)
+	}
+
+	var outboxStats *outbox.Stats
+	if s.opts.Outbox != nil {
+		stats, err := s.opts.Outbox.Stats()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		outboxStats = &stats
+	}
+
+	writeJSON(w, Overview{
+		Paused:        s.opts.Processor.Paused(),
+		Maintenance:   lock,
+		Tracked:       s.trackedFiles(),
+		Events:        s.opts.Watcher.EventStats(),
+		Scan:          s.scanProgress(),
+		Hashing:       s.redactKeys(s.opts.Processor.HashProgress()),
+		Stats:         s.opts.Processor.Stats(),
+		Pipeline:      s.opts.Processor.PipelineStats(),
+		Steps:         s.opts.Processor.StepStats(),
+		Tenants:       s.redactTenants(s.opts.Processor.TenantStats()),
+		Priorities:    s.opts.Processor.PriorityStats(),
+		FilesByStatus: filesByStatus(counts),
+		Quarantined:   len(items),
+		Watermark:     s.opts.Processor.CurrentWatermark(),
+		Retried:       retried,
+		Storms:        s.opts.Processor.Storms(),
+		Outbox:        outboxStats,
+		InputSpace:    s.inputSpace(),
+		InputUsage:    s.inputUsage(),
+		Destination:   s.opts.Processor.DestinationHealth(),
+	})
+}
+
+// inputSpace returns the last check of the input filesystem with its path
+// redacted
+func (s *Server) inputSpace() *inputspace.Status {
+	if s.opts.InputSpace == nil {
+		return nil
+	}
+	status := s.opts.InputSpace.Status()
+	if status != nil {
+		status.Path = s.opts.Redactor.Text(status.Path)
+	}
+	return status
+}
+
+// inputUsage returns the input tree usage with the untracked paths redacted
+func (s *Server) inputUsage() *watcher.InputUsage {
+	usage := s.opts.Watcher.InputUsage()
+	if usage != nil {
+		for i := range usage.Largest {
+			usage.Largest[i].Path = s.opts.Redactor.Text(usage.Largest[i].Path)
+		}
+	}
+	return usage
+}
+
+// Health is the readiness of the daemon and the state of each background
+// loop
+type Health struct {
+	Ready bool                    `json:"ready"`
+	Loops []supervisor.LoopStatus `json:"loops"`
+	// Topology is omitted when it was not checked
+	Topology *config.Topology `json:"topology,omitempty"`
+	// Database is omitted when the state database is not guarded
+	Database *dbguard.Status `json:"database,omitempty"`
+	// Destination is omitted when the warehouse is not probed; the daemon
+	// is not ready while dispatch is held by a failed probe
+	Destination *destination.HealthStatus `json:"destination,omitempty"`
+}
+
+// health answers 503 Service Unavailable while a background loop failed or
+// stalled, so that probes take the daemon out of rotation
+func (s *Server) health(w http.ResponseWriter, _ *http.Request) {
+	h := Health{Ready: true, Loops: []supervisor.LoopStatus{}}
+	if s.opts.Supervisor != nil {
+		h.Ready = s.opts.Supervisor.Ready()
+		h.Loops = s.opts.Supervisor.Status()
+	}
+	if s.opts.Topology != nil {
+		topology := s.opts.Topology.Redacted(s.opts.Redactor.Text)
+		h.Topology = &topology
+	}
+	if s.opts.Database != nil {
+		status := s.opts.Database.Status()
+		status.Error = s.opts.Redactor.Text(status.Error)
+		status.MovedTo = s.opts.Redactor.Text(status.MovedTo)
+		h.Database = &status
+		h.Ready = h.Ready && status.State == dbguard.StateHealthy
+	}
+	if status := s.opts.Processor.DestinationHealth(); status != nil {
+		h.Destination = status
+		h.Ready = h.Ready && !s.opts.Processor.Degraded()
+	}
+	if !h.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(h)
+		return
+	}
+	writeJSON(w, h)
+}
+
+func (s *Server) tracked(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.trackedFiles())
+}
+
+// trackedFiles returns the watcher's tracked files with redacted paths
+func (s *Server) trackedFiles() []watcher.TrackedFile {
+	tracked := s.opts.Watcher.Tracked()
+	for i := range tracked {
+		tracked[i].Path = s.opts.Redactor.Text(tracked[i].Path)
+	}
+	return tracked
+}
+
+// scanProgress returns the progress of the initial scan with its cursor
+// redacted
+func (s *Server) scanProgress() watcher.ScanProgress {
+	progress := s.opts.Watcher.ScanProgress()
+	progress.Cursor = s.opts.Redactor.Text(progress.Cursor)
+	return progress
+}
+
+// redactKeys returns m keyed by redacted paths
+func (s *Server) redactKeys(m map[string]int64) map[string]int64 {
+	redacted := make(map[string]int64, len(m))
+	for k, v := range m {
+		redacted[s.opts.Redactor.Text(k)] = v
+	}
+	return redacted
+}
+
+// redactTenants returns m keyed by redacted tenant names
+func (s *Server) redactTenants(m map[string]processor.TenantStats) map[string]processor.TenantStats {
+	redacted := make(map[string]processor.TenantStats, len(m))
+	for k, v := range m {
+		redacted[s.opts.Redactor.Text(k)] = v
+	}
+	return redacted
+}
+
+func (s *Server) recent(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.opts.Processor.Recent())
+}
+
+func (s *Server) stats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.opts.Processor.Stats())
+}
+
+func (s *Server) watermark(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.opts.Processor.CurrentWatermark())
+}
+
+func (s *Server) quarantine(w http.ResponseWriter, _ *http.Request) {
+	items, err := listQuarantine(s.opts.QuarantinePath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, s.opts.Redactor.Text(err.Error()))
+		return
+	}
+	for i := range items {
+		items[i].Path = s.opts.Redactor.Text(items[i].Path)
+		items[i].SourcePath = s.opts.Redactor.Text(items[i].SourcePath)
+	}
+	writeJSON(w, items)
+}
+
+func (s *Server) pause(w http.ResponseWriter, _ *http.Request) {
+	s.opts.Processor.Pause()
+	slog.Info("processing paused through admin api")
+	writeJSON(w, map[string]bool{"paused": true})
+}
+
+func (s *Server) resume(w http.ResponseWriter, _ *http.Request) {
+	s.opts.Processor.Resume()
+	slog.Info("processing resumed through admin api")
+	writeJSON(w, map[string]bool{"paused": false})
+}
+
+func (s *Server) storms(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.opts.Processor.Storms())
+}
+
+func (s *Server) reservations(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.opts.Processor.SpaceReservations())
+}
+
+// resumeSource resumes a source paused by a resend storm, named as
+// /api/storms reports it
+func (s *Server) resumeSource(w http.ResponseWriter, r *http.Request) {
+	source := r.PathValue("source")
+	if !s.opts.Processor.ResumeSource(source) {
+		writeError(w, http.StatusNotFound, "source not paused by a resend storm")
+		return
+	}
+	slog.Info("source resumed through admin api", "source", source)
+	writeJSON(w, map[string]any{"source": source, "paused": false})
+}
+
+func (s *Server) holds(w http.ResponseWriter, _ *http.Request) {
+	holds, err := s.opts.Processor.HeldFiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, s.opts.Redactor.Text(err.Error()))
+		return
+	}
+	writeJSON(w, holds)
+}
+
+// forceIngest lifts the hold of a file held for shrinking, named by its ID
+// as /api/holds reports it, so it is ingested on the next pass. The by query
+// parameter names who forced it in.
+func (s *Server) forceIngest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid hold id")
+		return
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "admin api"
+	}
+	hold, err := s.opts.Processor.ForceIngest(uint(id), by)
+	if errors.Is(err, storage.ErrNoHold) {
+		writeError(w, http.StatusNotFound, "no such held file")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, s.opts.Redactor.Text(err.Error()))
+		return
+	}
+	slog.Info("held file forced in through admin api", "id", hold.ID, "path", hold.Path, "by", by)
+	writeJSON(w, map[string]any{"id": hold.ID, "released_at": hold.ReleasedAt, "released_by": hold.ReleasedBy})
+}
+
+// rescan walks the directory named by the path query parameter, relative
+// to the input directory, and tracks the files the watcher missed there.
+// With force_ready=true they are ready on the next pass regardless of their
+// stability window.
+func (s *Server) rescan(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	forceReady := false
+	if v := r.URL.Query().Get("force_ready"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid force_ready")
+			return
+		}
+		forceReady = b
+	}
+	res, err := s.opts.Processor.Rescan(r.Context(), path, forceReady)
+	switch {
+	case errors.Is(err, watcher.ErrOutsideRoot):
+		writeError(w, http.StatusBadRequest, "path outside the input directory")
+		return
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, http.StatusNotFound, "no such directory")
+		return
+	case errors.Is(err, watcher.ErrRescanRunning):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, s.opts.Redactor.Text(err.Error()))
+		return
+	}
+	slog.Info("directory rescanned through admin api", "path", res.Path, "added", res.Added, "force_ready", forceReady)
+	res.Path = s.opts.Redactor.Text(res.Path)
+	writeJSON(w, res)
+}
+
+// selfTest runs a deep health check ingesting a probe end to end. A failed
+// check answers 503 with the report.
+func (s *Server) selfTest(w http.ResponseWriter, r *http.Request) {
+	report := s.opts.Processor.SelfTest(r.Context())
+	if !report.OK {
+		slog.Warn("self test through admin api failed", "probe", report.Probe)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// flushOutbox retries every undelivered notification now, skipping their
+// backoff
+func (s *Server) flushOutbox(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Outbox == nil {
+		writeError(w, http.StatusNotFound, "notifications are disabled")
+		return
+	}
+	res, err := s.opts.Outbox.Flush(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slog.Info("outbox flushed through admin api", "delivered", res.Delivered, "remaining", res.Remaining)
+	writeJSON(w, res)
+}
+
+// QuarantineItem is a file in the quarantine directory with the reason
+// recorded next to it
+type QuarantineItem struct {
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	Reason        string    `json:"reason,omitempty"`
+	SourcePath    string    `json:"source_path,omitempty"`
+	QuarantinedAt time.Time `json:"quarantined_at,omitzero"`
+}
+
+// quarantineRecord is the subset of the processor's reason files read here
+type quarantineRecord struct {
+	Reason        string    `json:"reason"`
+	SourcePath    string    `json:"source_path"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// listQuarantine returns the quarantined files under root. Single files have
+// a <name>.reason.json record; file set directories share one reason.json.
+func listQuarantine(root string) ([]QuarantineItem, error) {
+	items := make([]QuarantineItem, 0)
+	if root == "" {
+		return items, nil
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || d.Name() == "reason.json" || strings.HasSuffix(d.Name(), ".reason.json") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		item := QuarantineItem{Path: filepath.ToSlash(rel), Size: info.Size()}
+
+		for _, candidate := range []string{path + ".reason.json", filepath.Join(filepath.Dir(path), "reason.json")} {
+			data, err := os.ReadFile(candidate)
+			if err != nil {
+				continue
+			}
+			var record quarantineRecord
+			if err := json.Unmarshal(data, &record); err == nil {
+				item.Reason = record.Reason
+				item.SourcePath = record.SourcePath
+				item.QuarantinedAt = record.QuarantinedAt
+			}
+			break
+		}
+
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list quarantine %s: %w", root, err)
+	}
+	return items, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write admin response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,659 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/dbguard"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/inputspace"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testToken = "secret"
+
+func setupTestServer(t *testing.T) (*httptest.Server, *processor.Processor, string) {
+	t.Helper()
+	return setupTestServerWith(t, func(*Options) {})
+}
+
+// setupTestServerWith is setupTestServer with the options adjusted by
+// configure
+func setupTestServerWith(t *testing.T, configure func(*Options)) (*httptest.Server, *processor.Processor, string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	quarantineDir := filepath.Join(tmpDir, "quarantine")
+
+	db, err := gorm.Open(sqlite.Open(storage.DSN(filepath.Join(tmpDir, "test.db"))), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	w, err := watcher.New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+
+	proc := processor.New(&config.Config{
+		Path:           tmpDir,
+		Destination:    filepath.Join(tmpDir, "warehouse"),
+		ManifestsPath:  filepath.Join(tmpDir, "manifests"),
+		QuarantinePath: quarantineDir,
+		Concurrency:    1,
+	}, store, w)
+
+	opts := Options{
+		Processor:      proc,
+		Watcher:        w,
+		Storage:        store,
+		QuarantinePath: quarantineDir,
+		Token:          testToken,
+	}
+	configure(&opts)
+	srv := httptest.NewServer(New(opts).Handler())
+
+	t.Cleanup(func() {
+		srv.Close()
+		_ = w.Close()
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return srv, proc, quarantineDir
+}
+
+func TestPauseResume_RequiresToken(t *testing.T) {
+	srv, proc, _ := setupTestServer(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+		paused bool
+	}{
+		{"no token", "/api/pause", "", http.StatusUnauthorized, false},
+		{"wrong token", "/api/pause", "Bearer nope", http.StatusUnauthorized, false},
+		{"pause", "/api/pause", "Bearer " + testToken, http.StatusOK, true},
+		{"resume", "/api/resume", "Bearer " + testToken, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("NewRequest failed: %v", err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if proc.Paused() != tt.paused {
+				t.Errorf("Paused() = %v, want %v", proc.Paused(), tt.paused)
+			}
+		})
+	}
+}
+
+func TestPause_NoTokenConfigured(t *testing.T) {
+	srv := httptest.NewServer(New(Options{}).Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/pause", "", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestStorms(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	var storms []processor.StormStatus
+	getJSON(t, srv.URL+"/api/storms", &storms)
+	if storms == nil || len(storms) != 0 {
+		t.Errorf("storms = %+v, want an empty list", storms)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/storms/acme/resume", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("resume of a source not paused = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	var holds []storage.HeldFile
+	getJSON(t, srv.URL+"/api/holds", &holds)
+	if holds == nil || len(holds) != 0 {
+		t.Errorf("holds = %+v, want an empty list", holds)
+	}
+
+	for id, want := range map[string]int{"7": http.StatusNotFound, "seven": http.StatusBadRequest} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/holds/"+id+"/force-ingest", nil)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("force-ingest of hold %s = %d, want %d", id, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRescan(t *testing.T) {
+	srv, _, quarantineDir := setupTestServer(t)
+
+	// The watcher watches the directory holding the quarantine
+	root := filepath.Dir(quarantineDir)
+	dir := filepath.Join(root, "vendorB", "2024-06-14")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.csv"), []byte("1,alice\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(query string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/rescan?"+query, nil)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	for query, want := range map[string]int{
+		"":                                 http.StatusBadRequest,
+		"path=..":                          http.StatusBadRequest,
+		"path=vendorB&force_ready=perhaps": http.StatusBadRequest,
+		"path=vendorC":                     http.StatusNotFound,
+	} {
+		resp := post(query)
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("rescan ?%s = %d, want %d", query, resp.StatusCode, want)
+		}
+	}
+
+	resp := post("path=vendorB/2024-06-14&force_ready=true")
+	defer func() { _ = resp.Body.Close() }()
+	var res watcher.RescanResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || res.Added != 1 || res.ForcedReady != 1 {
+		t.Errorf("rescan = %d %+v, want the file added and forced ready", resp.StatusCode, res)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	var ledger processor.SpaceLedger
+	getJSON(t, srv.URL+"/api/reservations", &ledger)
+	if ledger.Reservations == nil || len(ledger.Reservations) != 0 || ledger.Reserved != 0 {
+		t.Errorf("reservations = %+v, want an empty ledger", ledger)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	resp, err := http.Post(srv.URL+"/api/self-test", "", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status without token = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/self-test", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var report processor.SelfTestReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !report.OK || len(report.Stages) == 0 {
+		t.Errorf("self-test = %d %+v, want a passing report", resp.StatusCode, report)
+	}
+}
+
+func TestOverview(t *testing.T) {
+	srv, proc, quarantineDir := setupTestServer(t)
+	proc.Pause()
+
+	if err := os.MkdirAll(quarantineDir, 0o755); err != nil {
+		t.Fatalf("failed to create quarantine: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(quarantineDir, "abc.csv"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to write quarantined file: %v", err)
+	}
+	record := `{"reason":"path_too_long","source_path":"/input/long.csv","quarantined_at":"2026-01-02T03:04:05Z"}`
+	if err := os.WriteFile(filepath.Join(quarantineDir, "abc.csv.reason.json"), []byte(record), 0o644); err != nil {
+		t.Fatalf("failed to write reason: %v", err)
+	}
+
+	var overview Overview
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if !overview.Paused {
+		t.Error("overview should report paused")
+	}
+	if overview.Quarantined != 1 {
+		t.Errorf("Quarantined = %d, want 1", overview.Quarantined)
+	}
+	if overview.Tracked == nil || overview.FilesByStatus == nil || overview.Pipeline == nil || overview.Retried == nil {
+		t.Errorf("overview should include empty tracked, status, pipeline and retried lists: %+v", overview)
+	}
+	if n, ok := overview.FilesByStatus[string(status.Ingested)]; !ok || n != 0 {
+		t.Errorf("FilesByStatus = %v, want ingested reported at zero", overview.FilesByStatus)
+	}
+
+	var items []QuarantineItem
+	getJSON(t, srv.URL+"/api/quarantine", &items)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 quarantine item, got %d", len(items))
+	}
+	want := QuarantineItem{
+		Path:          "abc.csv",
+		Size:          1,
+		Reason:        "path_too_long",
+		SourcePath:    "/input/long.csv",
+		QuarantinedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if items[0] != want {
+		t.Errorf("quarantine item = %+v, want %+v", items[0], want)
+	}
+}
+
+func TestOverview_Input(t *testing.T) {
+	var monitor *inputspace.Monitor
+	srv, _, quarantineDir := setupTestServerWith(t, func(o *Options) {
+		monitor = inputspace.New(filepath.Dir(o.QuarantinePath), inputspace.Thresholds{MinFreePercent: 5}, o.Storage, nil, nil)
+		o.InputSpace = monitor
+	})
+
+	var overview Overview
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if overview.InputSpace != nil || overview.InputUsage != nil {
+		t.Errorf("overview input = %+v, %+v, want neither before a check and a walk", overview.InputSpace, overview.InputUsage)
+	}
+
+	root := filepath.Dir(quarantineDir)
+	if err := os.WriteFile(filepath.Join(root, ".hidden"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_ = monitor.Check()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/rescan?path=.", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if overview.InputSpace == nil || overview.InputSpace.Path != root {
+		t.Errorf("overview input space = %+v, want the check of %s", overview.InputSpace, root)
+	}
+	if u := overview.InputUsage; u == nil || u.Ignored.Files != 1 || len(u.Largest) != 1 {
+		t.Errorf("overview input usage = %+v, want the hidden file ignored", u)
+	}
+}
+
+// acceptAll is an outbox sender accepting every message
+type acceptAll struct{}
+
+func (acceptAll) Send(context.Context, storage.OutboxMessage) error { return nil }
+
+func TestFlushOutbox(t *testing.T) {
+	var store *storage.Storage
+	srv, _, _ := setupTestServerWith(t, func(opts *Options) {
+		store = opts.Storage
+		opts.Outbox = outbox.New(opts.Storage, acceptAll{}, 0)
+	})
+	msg := &storage.OutboxMessage{Kind: processor.EventFileIngested, Payload: "{}", Ready: true}
+	if err := store.EnqueueOutbox(msg); err != nil {
+		t.Fatalf("EnqueueOutbox failed: %v", err)
+	}
+	if err := store.DeferOutbox(msg.ID, time.Now().Add(time.Hour), "down"); err != nil {
+		t.Fatalf("DeferOutbox failed: %v", err)
+	}
+
+	var overview Overview
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if overview.Outbox == nil || overview.Outbox.Depth != 1 || overview.Outbox.OldestPendingAge <= 0 {
+		t.Fatalf("overview outbox = %+v, want one pending message", overview.Outbox)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/flush-outbox", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var res outbox.FlushResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || res.Delivered != 1 || res.Remaining != 0 {
+		t.Errorf("flush = %d %+v, want the deferred message delivered", resp.StatusCode, res)
+	}
+}
+
+func TestFlushOutbox_Disabled(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/flush-outbox", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestQuarantine_Redacted(t *testing.T) {
+	quarantineDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(quarantineDir, "Jane Doe.csv"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to write quarantined file: %v", err)
+	}
+	record := `{"reason":"path_too_long","source_path":"/input/Jane Doe.csv"}`
+	if err := os.WriteFile(filepath.Join(quarantineDir, "Jane Doe.csv.reason.json"), []byte(record), 0o644); err != nil {
+		t.Fatalf("failed to write reason: %v", err)
+	}
+	r, err := redact.New([]string{"Jane*"}, nil, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("redact.New() error = %v", err)
+	}
+	srv := httptest.NewServer(New(Options{QuarantinePath: quarantineDir, Redactor: r}).Handler())
+	defer srv.Close()
+
+	var items []QuarantineItem
+	getJSON(t, srv.URL+"/api/quarantine", &items)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 quarantine item, got %d", len(items))
+	}
+	pseudonym := r.Pseudonym("Jane Doe.csv")
+	if items[0].Path != pseudonym || items[0].SourcePath != "/input/"+pseudonym {
+		t.Errorf("quarantine item = %+v, want %s in place of the name", items[0], pseudonym)
+	}
+}
+
+func TestUI(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/ui")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s status = %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", url, err)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	srv, proc, _ := setupTestServer(t)
+
+	var mark processor.Watermark
+	getJSON(t, srv.URL+"/api/watermark", &mark)
+	if !mark.At.IsZero() {
+		t.Errorf("watermark before the first tick = %v, want zero", mark.At)
+	}
+
+	advanced, err := proc.AdvanceWatermark()
+	if err != nil {
+		t.Fatalf("AdvanceWatermark() error = %v", err)
+	}
+	getJSON(t, srv.URL+"/api/watermark", &mark)
+	if !mark.At.Equal(advanced.At) {
+		t.Errorf("watermark = %v, want %v", mark.At, advanced.At)
+	}
+
+	var overview Overview
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if !overview.Watermark.At.Equal(advanced.At) {
+		t.Errorf("overview watermark = %v, want %v", overview.Watermark.At, advanced.At)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv, _, _ := setupTestServer(t)
+	var health Health
+	getJSON(t, srv.URL+"/api/health", &health)
+	if !health.Ready || health.Loops == nil {
+		t.Errorf("health without a supervisor = %+v, want ready with no loops", health)
+	}
+	if health.Topology != nil {
+		t.Errorf("health topology = %+v, want none when not checked", health.Topology)
+	}
+
+	topology := config.Topology{Filesystems: []config.Filesystem{{Role: "input", Path: "/input", Device: 1}}, Warnings: []string{}}
+	srv, _, _ = setupTestServerWith(t, func(o *Options) { o.Topology = &topology })
+	getJSON(t, srv.URL+"/api/health", &health)
+	if health.Topology == nil || len(health.Topology.Filesystems) != 1 || health.Topology.Filesystems[0].Device != 1 {
+		t.Errorf("health topology = %+v, want the checked one", health.Topology)
+	}
+
+	sup := supervisor.New()
+	sup.Add(supervisor.Loop{Name: "watcher", Run: func(context.Context) error {
+		return errors.New("event source closed")
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sup.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	srv, _, _ = setupTestServerWith(t, func(o *Options) { o.Supervisor = sup })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for sup.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("loop did not fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	resp, err := http.Get(srv.URL + "/api/health")
+	if err != nil {
+		t.Fatalf("GET /api/health failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	if health.Ready || len(health.Loops) != 1 || health.Loops[0].State != supervisor.StateFailed {
+		t.Errorf("health = %+v, want not ready with the failed watcher", health)
+	}
+}
+
+func TestHealth_DatabaseCorrupt(t *testing.T) {
+	guard := dbguard.New(dbguard.Options{Path: "state.db"})
+	srv, proc, _ := setupTestServerWith(t, func(o *Options) { o.Database = guard })
+	guard.SetProcessing(proc.Pause, proc.Resume)
+
+	var health Health
+	getJSON(t, srv.URL+"/api/health", &health)
+	if !health.Ready || health.Database == nil || health.Database.State != dbguard.StateHealthy {
+		t.Fatalf("health = %+v, want ready with a healthy database", health)
+	}
+
+	guard.Observe(errors.New("database disk image is malformed"))
+	resp, err := http.Get(srv.URL + "/api/health")
+	if err != nil {
+		t.Fatalf("GET /api/health failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || health.Ready || health.Database.State != dbguard.StateCorrupt {
+		t.Errorf("health = %d %+v, want not ready with a corrupt database", resp.StatusCode, health)
+	}
+	if !proc.Paused() {
+		t.Error("processing not paused on a corrupt database")
+	}
+}
+
+func TestHealth_DestinationDegraded(t *testing.T) {
+	dest := destination.NewMemory()
+	dest.SetPingError(errors.New("warehouse unreachable"))
+	var w *watcher.Watcher
+	srv, proc, quarantineDir := setupTestServerWith(t, func(o *Options) { w = o.Watcher })
+	proc.SetDestinationHealth(destination.NewHealth(dest, destination.HealthOptions{Cache: time.Hour, MaxBackoff: time.Hour}))
+
+	// A ready file makes the pass probe the warehouse before dispatching
+	src := filepath.Join(filepath.Dir(quarantineDir), "a.csv")
+	if err := os.WriteFile(src, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	proc.ProcessFiles()
+
+	resp, err := http.Get(srv.URL + "/api/health")
+	if err != nil {
+		t.Fatalf("GET /api/health failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var health Health
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || health.Ready || health.Destination == nil || health.Destination.Healthy {
+		t.Errorf("health = %d %+v, want not ready with an unhealthy destination", resp.StatusCode, health)
+	}
+
+	var overview Overview
+	getJSON(t, srv.URL+"/api/overview", &overview)
+	if overview.Destination == nil || overview.Destination.Error != "warehouse unreachable" || overview.Destination.Failures != 1 {
+		t.Errorf("overview destination = %+v, want the failed probe", overview.Destination)
+	}
+}
+
+func TestFilesByStatus_BoundsLabels(t *testing.T) {
+	got := filesByStatus(map[status.Status]int64{status.Ingested: 3, "reprocessed": 2, "": 1})
+	want := map[string]int64{
+		string(status.Ingested):        3,
+		string(status.Adopted):         0,
+		string(status.Suspect):         0,
+		string(status.MissingRestored): 0,
+		string(status.Superseded):      0,
+		status.UnknownLabel:            3,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("filesByStatus() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,369 @@
+package adopt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// DefaultCheckpointEvery is the number of completed files between checkpoints
+const DefaultCheckpointEvery = 1000
+
+// Options controls an adopt run
+type Options struct {
+	// Root is the warehouse directory to walk
+	Root string
+	// ListPath, when set, names a file with one path per line to adopt
+	// instead of walking Root
+	ListPath string
+	// Concurrency is the number of hashing workers
+	Concurrency int
+	// CheckpointPath is where progress is persisted so a restart can resume
+	CheckpointPath string
+	// CheckpointEvery is the number of completed files between checkpoints
+	CheckpointEvery int
+	// DryRun reports what would be added without writing anything
+	DryRun bool
+}
+
+// Result summarizes an adopt run
+type Result struct {
+	Resumed int64 `json:"resumed"`
+	Scanned int64 `json:"scanned"`
+	Added   int64 `json:"added"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+}
+
+// checkpoint is the on-disk progress record. Position is the number of
+// candidates, in enumeration order, that are fully handled.
+type checkpoint struct {
+	Position int64  `json:"position"`
+	Result   Result `json:"result"`
+}
+
+type candidate struct {
+	index int64
+	path  string
+}
+
+type outcome struct {
+	index int64
+	added bool
+	err   error
+}
+
+// Adopter records existing warehouse files in the state database
+type Adopter struct {
+	store *storage.Storage
+	opts  Options
+
+	// seen holds hashes counted during a dry run so that identical files
+	// inside the warehouse are only reported once
+	seenMu sync.Mutex
+	seen   map[string]struct{}
+}
+
+// New creates a new Adopter
+func New(store *storage.Storage, opts Options) *Adopter {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.CheckpointEvery < 1 {
+		opts.CheckpointEvery = DefaultCheckpointEvery
+	}
+	return &Adopter{
+		store: store,
+		opts:  opts,
+		seen:  make(map[string]struct{}),
+	}
+}
+
+// Run walks the warehouse (or the provided list), hashes each file and
+// inserts an adopted record for every hash not yet present. On a clean finish
+// the checkpoint is removed so the next run starts over.
+func (a *Adopter) Run(ctx context.Context) (Result, error) {
+	cp, err := a.loadCheckpoint()
+	if err != nil {
+		return Result{}, err
+	}
+	res := cp.Result
+	res.Resumed = cp.Position
+
+	if cp.Position > 0 {
+		slog.Info("resuming adopt from checkpoint", "position", cp.Position)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	candidates := make(chan candidate, a.opts.Concurrency)
+	outcomes := make(chan outcome, a.opts.Concurrency)
+
+	var enumErr error
+	go func() {
+		defer close(candidates)
+		enumErr = a.enumerate(ctx, cp.Position, candidates)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < a.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range candidates {
+				added, err := a.adoptOne(c.path)
+				outcomes <- outcome{index: c.index, added: added, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// Outcomes arrive out of order; the checkpoint only advances over the
+	// contiguous prefix of finished candidates.
+	position := cp.Position
+	done := make(map[int64]bool)
+	lastSaved := position
+	for o := range outcomes {
+		res.Scanned++
+		switch {
+		case o.err != nil:
+			res.Failed++
+		case o.added:
+			res.Added++
+		default:
+			res.Skipped++
+		}
+
+		done[o.index] = true
+		for done[position] {
+			delete(done, position)
+			position++
+		}
+
+		if position-lastSaved >= int64(a.opts.CheckpointEvery) {
+			lastSaved = position
+			if err := a.saveCheckpoint(checkpoint{Position: position, Result: res}); err != nil {
+				cancel()
+				go func() {
+					for range outcomes {
+					}
+				}()
+				return res, err
+			}
+			slog.Info("adopt progress",
+				"position", position,
+				"scanned", res.Scanned,
+				"added", res.Added,
+				"skipped", res.Skipped,
+				"failed", res.Failed,
+			)
+		}
+	}
+
+	if enumErr != nil {
+		if err := a.saveCheckpoint(checkpoint{Position: position, Result: res}); err != nil {
+			slog.Error("failed to save adopt checkpoint", "error", err)
+		}
+		return res, enumErr
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	if err := a.removeCheckpoint(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// adoptOne hashes a single file and records it. It returns true when the
+// file was (or in dry run would be) added.
+func (a *Adopter) adoptOne(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("failed to stat file for adoption", "path", path, "error", err)
+		return false, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	hash, err := fileops.CalculateSHA256(path)
+	if err != nil {
+		slog.Warn("failed to hash file for adoption", "path", path, "error", err)
+		return false, fmt.Errorf("hash %s: %w", path, err)
+	}
+
+	if a.opts.DryRun {
+		exists, err := a.store.FileExistsSince(hash, time.Time{})
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return false, nil
+		}
+		a.seenMu.Lock()
+		defer a.seenMu.Unlock()
+		if _, ok := a.seen[hash]; ok {
+			return false, nil
+		}
+		a.seen[hash] = struct{}{}
+		return true, nil
+	}
+
+	added, _, err := a.store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256:      hash,
+		Name:        info.Name(),
+		Size:        info.Size(),
+		Status:      status.Adopted,
+		DestPath:    path,
+		ProcessedAt: info.ModTime(),
+	})
+	if err != nil {
+		slog.Error("failed to adopt file", "path", path, "error", err)
+		return false, err
+	}
+	slog.Debug("file adopted", "path", path, "sha256", hash, "added", added)
+	return added, nil
+}
+
+// enumerate sends every candidate after the first skip entries
+func (a *Adopter) enumerate(ctx context.Context, skip int64, out chan<- candidate) error {
+	var index int64
+	emit := func(path string) error {
+		defer func() { index++ }()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if index < skip {
+			return nil
+		}
+		select {
+		case out <- candidate{index: index, path: path}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if a.opts.ListPath != "" {
+		return a.enumerateList(emit)
+	}
+
+	marker := filepath.Join(a.opts.Root, destination.MarkerName)
+	// Uploads in flight, working copies and trashed sources are not
+	// warehouse files
+	skipped := make(map[string]bool)
+	for _, name := range []string{destination.StagingPrefix, destination.ScratchPrefix, config.TrashDirName} {
+		skipped[filepath.Join(a.opts.Root, filepath.FromSlash(strings.TrimSuffix(name, "/")))] = true
+	}
+	err := filepath.WalkDir(a.opts.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && skipped[path] {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() || path == marker {
+			return nil
+		}
+		return emit(path)
+	})
+	if err != nil {
+		return fmt.Errorf("walk warehouse %s: %w", a.opts.Root, err)
+	}
+	return nil
+}
+
+func (a *Adopter) enumerateList(emit func(string) error) error {
+	file, err := os.Open(a.opts.ListPath)
+	if err != nil {
+		return fmt.Errorf("open file list: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := emit(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read file list: %w", err)
+	}
+	return nil
+}
+
+func (a *Adopter) loadCheckpoint() (checkpoint, error) {
+	var cp checkpoint
+	if a.opts.CheckpointPath == "" {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(a.opts.CheckpointPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("decode checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint atomically replaces the checkpoint file. Dry runs never
+// persist progress.
+func (a *Adopter) saveCheckpoint(cp checkpoint) error {
+	if a.opts.DryRun || a.opts.CheckpointPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+
+	tmp := a.opts.CheckpointPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, a.opts.CheckpointPath); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("rename checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (a *Adopter) removeCheckpoint() error {
+	if a.opts.DryRun || a.opts.CheckpointPath == "" {
+		return nil
+	}
+	if err := os.Remove(a.opts.CheckpointPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package adopt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupStore(t *testing.T) *storage.Storage {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		if sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// setupWarehouse creates n files with distinct content plus one duplicate
+func setupWarehouse(t *testing.T, n int) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("ingest_date=2024-01-%02d", i%3+1))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("file%d.csv", i))
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("content %d", i)), 0o644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "dup.csv"), []byte("content 0"), 0o644); err != nil {
+		t.Fatalf("failed to create duplicate: %v", err)
+	}
+	return root
+}
+
+func TestRun(t *testing.T) {
+	store := setupStore(t)
+	root := setupWarehouse(t, 10)
+
+	mtime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := filepath.Join(root, "ingest_date=2024-01-01", "file0.csv")
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	// The marker describes the warehouse and is not adopted
+	if err := destination.WriteMarker(root, destination.Marker{Tool: "atomic-ingestor"}); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+
+	// Nor are uploads in flight and working copies
+	for _, dir := range []string{"_staging/upload-1", "_scratch/copy-1"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(root, dir, "half.csv"), []byte("half written"), 0o644); err != nil {
+			t.Fatalf("failed to write under %s: %v", dir, err)
+		}
+	}
+
+	a := New(store, Options{Root: root, Concurrency: 4})
+	res, err := a.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if res.Scanned != 11 {
+		t.Errorf("Scanned = %d, want 11", res.Scanned)
+	}
+	if res.Added != 10 {
+		t.Errorf("Added = %d, want 10", res.Added)
+	}
+	if res.Skipped != 1 {
+		t.Errorf("Skipped = %d, want 1", res.Skipped)
+	}
+
+	hash, err := fileops.CalculateSHA256(path)
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+	exists, err := store.FileExistsSince(hash, time.Time{})
+	if err != nil {
+		t.Fatalf("FileExistsSince failed: %v", err)
+	}
+	if !exists {
+		t.Error("adopted hash should exist")
+	}
+
+	// A second run must not add anything
+	res, err = New(store, Options{Root: root}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if res.Added != 0 || res.Skipped != 11 {
+		t.Errorf("second run added %d skipped %d, want 0 and 11", res.Added, res.Skipped)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	store := setupStore(t)
+	root := setupWarehouse(t, 5)
+	checkpointPath := filepath.Join(t.TempDir(), "adopt.checkpoint")
+
+	a := New(store, Options{Root: root, DryRun: true, CheckpointPath: checkpointPath, CheckpointEvery: 1})
+	res, err := a.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.Added != 5 {
+		t.Errorf("Added = %d, want 5", res.Added)
+	}
+	if res.Skipped != 1 {
+		t.Errorf("Skipped = %d, want 1", res.Skipped)
+	}
+
+	// Nothing should have been written
+	res, err = New(store, Options{Root: root, DryRun: true}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if res.Added != 5 {
+		t.Errorf("dry run wrote records: second run Added = %d, want 5", res.Added)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Error("dry run should not write a checkpoint")
+	}
+}
+
+func TestRun_ResumeFromCheckpoint(t *testing.T) {
+	store := setupStore(t)
+	root := setupWarehouse(t, 6)
+	checkpointPath := filepath.Join(t.TempDir(), "adopt.checkpoint")
+
+	// Pretend a previous run handled the first 4 candidates
+	data, err := json.Marshal(checkpoint{Position: 4, Result: Result{Scanned: 4, Added: 4}})
+	if err != nil {
+		t.Fatalf("failed to encode checkpoint: %v", err)
+	}
+	if err := os.WriteFile(checkpointPath, data, 0o644); err != nil {
+		t.Fatalf("failed to write checkpoint: %v", err)
+	}
+
+	res, err := New(store, Options{Root: root, CheckpointPath: checkpointPath}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if res.Resumed != 4 {
+		t.Errorf("Resumed = %d, want 4", res.Resumed)
+	}
+	// 7 candidates in total, only the last 3 are hashed in this run
+	if res.Scanned != 7 {
+		t.Errorf("Scanned = %d, want 7 (4 carried over + 3 new)", res.Scanned)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Error("checkpoint should be removed after a complete run")
+	}
+}
+
+func TestRun_Checkpoints(t *testing.T) {
+	store := setupStore(t)
+	root := setupWarehouse(t, 6)
+	checkpointPath := filepath.Join(t.TempDir(), "adopt.checkpoint")
+
+	a := New(store, Options{Root: root, CheckpointPath: checkpointPath, CheckpointEvery: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := a.Run(ctx); err == nil {
+		t.Fatal("expected error from cancelled run")
+	}
+
+	// Cancelled before anything ran: the checkpoint records position 0
+	cp, err := a.loadCheckpoint()
+	if err != nil {
+		t.Fatalf("loadCheckpoint failed: %v", err)
+	}
+	if cp.Position != 0 {
+		t.Errorf("Position = %d, want 0", cp.Position)
+	}
+}
+
+func TestRun_FileList(t *testing.T) {
+	store := setupStore(t)
+	root := setupWarehouse(t, 4)
+
+	listPath := filepath.Join(t.TempDir(), "files.txt")
+	list := filepath.Join(root, "dup.csv") + "\n\n" + filepath.Join(root, "ingest_date=2024-01-02", "file1.csv") + "\n"
+	if err := os.WriteFile(listPath, []byte(list), 0o644); err != nil {
+		t.Fatalf("failed to write list: %v", err)
+	}
+
+	res, err := New(store, Options{Root: root, ListPath: listPath}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if res.Scanned != 2 || res.Added != 2 {
+		t.Errorf("Scanned = %d Added = %d, want 2 and 2", res.Scanned, res.Added)
+	}
+}
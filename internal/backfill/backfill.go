@@ -0,0 +1,165 @@
+// Package backfill writes the manifest entries of file records that have
+// none, such as those recorded while only the state database was written
+package backfill
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Store lists the file records a backfill covers
+type Store interface {
+	ProcessedBetween(from, to time.Time, statuses ...status.Status) ([]storage.File, error)
+}
+
+// Options controls a backfill run
+type Options struct {
+	// From and To bound, inclusive, when the records were processed
+	From, To time.Time
+	// Statuses selects the records by status, every status when empty
+	Statuses []status.Status
+	// DryRun counts the entries that would be written without writing them
+	DryRun bool
+}
+
+// Result summarizes a backfill run
+type Result struct {
+	// Days counts the days with records
+	Days int `json:"days"`
+	// Records counts the records of those days, Covered those that already
+	// had a manifest entry
+	Records int `json:"records"`
+	Covered int `json:"covered"`
+	// Backfilled counts the entries written, or that would be in a dry run
+	Backfilled int `json:"backfilled"`
+	// Refinalized counts the finalized days whose marker was rewritten
+	Refinalized int `json:"refinalized"`
+}
+
+// Run appends, through w, an entry for every record of store processed
+// between opts.From and opts.To that the manifests read back by w do not
+// cover, marked Backfilled. The writer places each entry in the manifest
+// file of the period it was processed in, creating past directories as
+// needed, so w should not finalize days itself: the completion marker of
+// every finalized day written to is rewritten instead. Days are handled one
+// at a time, oldest first, so a run that fails leaves the days before it
+// complete, and running it again only writes what is still missing.
+func Run(store Store, w *manifest.Writer, opts Options) (Result, error) {
+	var res Result
+	for day := startOfDay(opts.From.Local()); !day.After(opts.To); day = day.AddDate(0, 0, 1) {
+		from := maxTime(day, opts.From)
+		to := minTime(day.AddDate(0, 0, 1).Add(-time.Nanosecond), opts.To)
+		if err := backfillDay(store, w, opts, from, to, &res); err != nil {
+			return res, fmt.Errorf("backfill %s: %w", day.Format(time.DateOnly), err)
+		}
+	}
+	return res, nil
+}
+
+// backfillDay backfills the records processed between from and to, within
+// one day
+func backfillDay(store Store, w *manifest.Writer, opts Options, from, to time.Time, res *Result) error {
+	files, err := store.ProcessedBetween(from, to, opts.Statuses...)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	res.Days++
+	res.Records += len(files)
+
+	entries, err := w.ManifestEntries(from, to)
+	if err != nil {
+		return fmt.Errorf("read manifest entries: %w", err)
+	}
+	covered := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		covered[coverageKey(e.SHA256, e.ProcessedAt)] = true
+	}
+
+	written := 0
+	for _, f := range files {
+		if covered[coverageKey(f.ContentSHA256(), f.ProcessedAt)] {
+			res.Covered++
+			continue
+		}
+		if opts.DryRun {
+			slog.Info("dry run: would backfill manifest entry", "sha256", f.ContentSHA256(), "dest", f.DestPath, "processed_at", f.ProcessedAt)
+			res.Backfilled++
+			continue
+		}
+		if err := w.Append(entry(f)); err != nil {
+			return err
+		}
+		res.Backfilled++
+		written++
+	}
+	if written == 0 {
+		return nil
+	}
+
+	refinalized, err := w.RefinalizeDay(from)
+	if err != nil {
+		return err
+	}
+	if refinalized {
+		res.Refinalized++
+	}
+	slog.Info("manifest day backfilled", "day", from.Format(time.DateOnly), "records", len(files), "backfilled", written, "refinalized", refinalized)
+	return nil
+}
+
+// entry synthesizes the manifest entry of f from its columns. Processing
+// times are local, as the daemon stamps them, so the entry lands in the
+// period file the daemon would have written it to.
+func entry(f storage.File) manifest.Entry {
+	e := manifest.Entry{
+		SHA256:         f.ContentSHA256(),
+		Name:           f.Name,
+		OriginalName:   f.OriginalName,
+		SourcePath:     f.Path,
+		DestPath:       f.DestPath,
+		Size:           f.Size,
+		ProcessedAt:    f.ProcessedAt.Local(),
+		Status:         f.Status,
+		Tags:           f.Tags,
+		Version:        f.Version,
+		PreviousSHA256: f.PreviousSHA256,
+		AllocatedSize:  f.AllocatedSize,
+		Sequence:       f.Sequence,
+		Backfilled:     true,
+	}
+	if f.IdempotencyKey != nil {
+		e.IdempotencyKey = *f.IdempotencyKey
+	}
+	return e
+}
+
+// coverageKey identifies the manifest entry of a record by its content and
+// when it was processed, which the record and its entry share
+func coverageKey(sha256 string, processedAt time.Time) string {
+	return sha256 + " " + processedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// startOfDay returns the start of the day containing t, in t's time zone
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
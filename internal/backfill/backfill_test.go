@@ -0,0 +1,155 @@
+package backfill
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupStore(t *testing.T) *storage.Storage {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		if sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// seed records a file for every processing time, with status ingested
+// unless given
+func seed(t *testing.T, store *storage.Storage, st status.Status, times ...time.Time) []storage.FileRecord {
+	t.Helper()
+	var records []storage.FileRecord
+	for _, at := range times {
+		rec := storage.FileRecord{
+			SHA256:      fmt.Sprintf("%s-%d", st, at.UnixNano()),
+			Name:        fmt.Sprintf("file-%d.csv", at.Unix()),
+			Path:        fmt.Sprintf("/input/file-%d.csv", at.Unix()),
+			Size:        10,
+			Status:      st,
+			DestPath:    fmt.Sprintf("/warehouse/file-%d.csv", at.Unix()),
+			ProcessedAt: at,
+		}
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("failed to seed file: %v", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestRun_BackfillsOnceIntoTheirPeriods(t *testing.T) {
+	store := setupStore(t)
+	base := t.TempDir()
+	day := func(d, hour int) time.Time { return time.Date(2025, 3, d, hour, 15, 0, 0, time.Local) }
+
+	records := seed(t, store, status.Ingested, day(1, 9), day(1, 17), day(2, 8), day(3, 23))
+	seed(t, store, status.Adopted, day(2, 12))
+
+	// The last record of the first day already has its entry, and the day
+	// was finalized with it
+	live := manifest.NewWriter(base)
+	live.EnableDayFinalization(0)
+	covered := records[1]
+	if err := live.Append(manifest.Entry{SHA256: covered.SHA256, Name: covered.Name, DestPath: covered.DestPath, Size: covered.Size, ProcessedAt: covered.ProcessedAt, Status: status.Ingested}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if n, err := live.FinalizeDays(); err != nil || n != 1 {
+		t.Fatalf("FinalizeDays() = %d, %v, want the first day finalized", n, err)
+	}
+	if err := live.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	opts := Options{From: day(1, 0), To: day(4, 0), Statuses: []status.Status{status.Ingested}}
+	for run, want := range []Result{
+		{Days: 3, Records: 4, Covered: 1, Backfilled: 3, Refinalized: 1},
+		{Days: 3, Records: 4, Covered: 4},
+	} {
+		w := manifest.NewWriter(base)
+		res, err := Run(store, w, opts)
+		if err != nil {
+			t.Fatalf("run %d: Run() error = %v", run+1, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if res != want {
+			t.Errorf("run %d: result = %+v, want %+v", run+1, res, want)
+		}
+	}
+
+	entries, err := manifest.NewWriter(base).ManifestEntries(opts.From, opts.To)
+	if err != nil {
+		t.Fatalf("ManifestEntries() error = %v", err)
+	}
+	count := make(map[string]int)
+	for _, e := range entries {
+		count[e.SHA256]++
+		if e.Backfilled == (e.SHA256 == covered.SHA256) {
+			t.Errorf("entry %s backfilled = %v", e.SHA256, e.Backfilled)
+		}
+	}
+	if len(count) != len(records) {
+		t.Errorf("entries cover %d files, want the %d ingested", len(count), len(records))
+	}
+	for _, rec := range records {
+		if count[rec.SHA256] != 1 {
+			t.Errorf("file %s has %d entries, want exactly one", rec.SHA256, count[rec.SHA256])
+		}
+		path := filepath.Join(base, rec.ProcessedAt.Format("2006/01/02/15"), "manifest.jsonl")
+		found, err := manifest.ReadFile(path)
+		if err != nil || len(found) != 1 || found[0].SHA256 != rec.SHA256 {
+			t.Errorf("%s = %+v, %v, want the entry of %s", path, found, err, rec.SHA256)
+		}
+	}
+
+	if _, err := manifest.VerifyDay(filepath.Join(base, "2025", "03", "01")); err != nil {
+		t.Errorf("VerifyDay() of the refinalized day error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "2025", "03", "02", manifest.CompleteMarker)); !os.IsNotExist(err) {
+		t.Errorf("a day that was never finalized got a marker: %v", err)
+	}
+}
+
+func TestRun_DryRunWritesNothing(t *testing.T) {
+	store := setupStore(t)
+	base := t.TempDir()
+	at := time.Date(2025, 3, 1, 9, 0, 0, 0, time.Local)
+	seed(t, store, status.Ingested, at)
+
+	w := manifest.NewWriter(base)
+	res, err := Run(store, w, Options{From: at.Add(-time.Hour), To: at.Add(time.Hour), DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.Backfilled != 1 {
+		t.Errorf("result = %+v, want one entry to backfill", res)
+	}
+	if files, _ := os.ReadDir(base); len(files) != 0 {
+		t.Errorf("dry run wrote %d files", len(files))
+	}
+}
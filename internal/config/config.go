@@ -1,5 +1,10 @@
 package config
 
+import (
+	"io/fs"
+	"time"
+)
+
 type Config struct {
 	Path             string
 	Method           string
@@ -9,14 +14,367 @@ type Config struct {
 	StatePath        string
 	LogLevel         string
 	Concurrency      int
+	HashWorkers      int
+	CopyWorkers      int
 	DryRun           bool
+	SyncPolicy       string
+	DirectThreshold  int64
+	QuarantinePath   string
+	MaxNameBytes     int
+	MaxPathBytes     int
+	ShortenPaths     bool
+	RulesPath        string
+	KeepSource       bool
+	SourceGrace      time.Duration
+	PartPattern      string
+	PartTimeout      time.Duration
+	ConfigPath       string
+	AdminAddr        string
+	AdminToken       string
+	ManifestFormat   string
+	ManifestPeriod   string
+	WriteReceipts    bool
+	ReceiptsDir      string
+	ReceiptRetention time.Duration
+	BatchDirs        string
+	BatchMarker      string
+	LogDedupWindow   time.Duration
+	// VersionOnNameConflict keeps every file arriving under an already
+	// ingested name, suffixed by VersionTimestamp or VersionSequence. It
+	// cannot be combined with PartPattern or BatchDirs
+	VersionOnNameConflict string
+	// VersionLatestLink stores every version suffixed and points a symlink
+	// with the unversioned name at the newest one
+	VersionLatestLink bool
+	// ParanoidDedup compares a duplicate byte by byte with its warehouse
+	// copy, read at up to ParanoidDedupRate bytes per second (0 unlimited)
+	ParanoidDedup     bool
+	ParanoidDedupRate int64
+	// UnicodeNormalization is the form warehouse names, database names and
+	// manifest entries are normalized to; the source spelling is kept as
+	// the original name
+	UnicodeNormalization string
+	// CopyFirst copies each source into warehouse staging with a single read
+	// and hashes and ingests the copy, so the recorded hash always matches
+	// the stored bytes
+	CopyFirst bool
+	// TenantMaxWorkers caps the files of one top-level input directory
+	// processed at a time (0 unlimited)
+	TenantMaxWorkers int
+	// IdempotencyKeyPattern extracts a producer-supplied idempotency key
+	// from file names, and IdempotencyKeyField from sidecar JSON
+	IdempotencyKeyPattern string
+	IdempotencyKeyField   string
+	// SelfTest ingests a probe file end to end, reports each stage and
+	// exits instead of running the daemon
+	SelfTest bool
+	// ManifestRedaction is ManifestRedactionNone or ManifestRedactionPseudonym
+	// and selects whether manifests get the redacted names logs get
+	ManifestRedaction string
+	// SizeAccounting is SizeAccountingLogical or SizeAccountingAllocated and
+	// selects which size of a file space checks count
+	SizeAccounting string
+	// BatchTar packages each directory batch into one deterministic tar
+	// instead of ingesting its files one by one
+	BatchTar bool
+	// BatchTarSymlinks is SymlinksSkip or SymlinksFollow and selects how
+	// symlinks inside a packaged batch are handled
+	BatchTarSymlinks string
+	// BatchTarMtimes keeps member modification times in batch tars, which
+	// then differ between re-sends of the same content
+	BatchTarMtimes bool
+	// ManifestSink is ManifestSinkFile, ManifestSinkDB or ManifestSinkBoth
+	// and selects where manifest entries are written
+	ManifestSink string
+	// ManifestStrictOrder writes manifest entries in the order of their
+	// sequence numbers, holding back an entry until every entry stamped
+	// before it is written or its file failed
+	ManifestStrictOrder bool
+	// WarehouseSharding is ShardingCount or ShardingHash and spreads files
+	// over subdirectories of their warehouse directory; empty disables
+	WarehouseSharding string
+	// ShardFanout is the most entries a warehouse directory holds with count
+	// sharding
+	ShardFanout int
+	// NotifyURL receives a JSON POST for every processor event, delivered
+	// through the outbox of the state database; empty disables
+	NotifyURL string
+	// OutboxLimit is how many undelivered notifications are kept before the
+	// oldest are dropped (0 is unlimited)
+	OutboxLimit int
+	// CreateInput lets Prepare create a missing input directory
+	CreateInput bool
+	// DirMode is the mode of directories Prepare creates; 0 uses
+	// DefaultDirMode
+	DirMode fs.FileMode
+	// CheckConfig validates the configuration and prepares directories,
+	// then exits
+	CheckConfig bool
+	// ClockTolerance is how far the clock may fall behind the latest
+	// ProcessedAt before it counts as a regression
+	ClockTolerance time.Duration
+	// OnClockRegression is ClockRegressionContinue or ClockRegressionHold
+	OnClockRegression string
+	// FinalizeDays writes the _COMPLETE marker of every day of manifests
+	// once the day is over and FinalizeGrace has passed
+	FinalizeDays  bool
+	FinalizeGrace time.Duration
+	// WatchBackend is WatchBackendAuto, WatchBackendFsnotify or
+	// WatchBackendPoll
+	WatchBackend string
+	// PollInterval is how often the polling backend scans the input
+	PollInterval time.Duration
+	// WatchProbeInterval is how often fsnotify is probed for events after
+	// startup; 0 probes only at startup
+	WatchProbeInterval time.Duration
+	// TombstoneWindow is how long events for a file are ignored once it
+	// was ingested, rejected as a duplicate or quarantined; 0 ignores none
+	TombstoneWindow time.Duration
+	// VerifyDuplicates checks that the warehouse copy of a duplicate's
+	// original still exists, ingesting the duplicate to restore it when it
+	// does not. Copies found present are trusted for DuplicateCheckCache.
+	VerifyDuplicates    bool
+	DuplicateCheckCache time.Duration
+	// DestinationProbe checks that the warehouse accepts writes before each
+	// pass dispatches its files, holding them while it does not. A success
+	// is trusted for DestinationProbeCache; failed probes are retried with
+	// a backoff capped at DestinationProbeMaxBackoff.
+	DestinationProbe           bool
+	DestinationProbeCache      time.Duration
+	DestinationProbeMaxBackoff time.Duration
+	// DigestInterval is how often a duplicate digest of the past interval
+	// is sent through the outbox, listing DigestTop originals and sources;
+	// 0 disables
+	DigestInterval time.Duration
+	DigestTop      int
+	// AttemptRetention is how long the attempt history of files is kept;
+	// 0 keeps it forever
+	AttemptRetention time.Duration
+	// TimingSampleRate is the fraction of files whose per-stage timing
+	// breakdown is recorded, kept as long as the attempt history; 0
+	// records none
+	TimingSampleRate float64
+	// DBRecovery moves a corrupt state database aside, rebuilds it from the
+	// manifests and resumes processing, instead of stopping until an
+	// operator acts. Incidents are recorded in AuditLog.
+	DBRecovery bool
+	AuditLog   string
+	// InputSpaceInterval is how often the free space and inodes of the
+	// input filesystem are checked, alerting when either drops below
+	// InputMinFreePercent or InputMinFreeInodesPercent; 0 disables
+	InputSpaceInterval        time.Duration
+	InputMinFreePercent       float64
+	InputMinFreeInodesPercent float64
+	// StrictStartup refuses to start while inconsistencies left by an
+	// earlier run are not acknowledged, instead of repairing them. Files
+	// ingested within StrictManifestWindow are compared with the manifest.
+	StrictStartup        bool
+	StrictManifestWindow time.Duration
+	// ForceAdoptWarehouse starts even though the marker of the warehouse
+	// was written by another configuration, taking the warehouse over
+	ForceAdoptWarehouse bool
+	// DedupWindow limits duplicate detection to content recorded within
+	// it: content last recorded earlier is ingested again, superseding that
+	// record. 0 detects duplicates forever.
+	DedupWindow time.Duration
+	// ReportSchedule is the cron expression, evaluated in ReportTimezone,
+	// at which the ingestion report of the period since its previous firing
+	// is delivered through the outbox and written to ReportDir, listing
+	// ReportTop sources; empty disables
+	ReportSchedule string
+	ReportTimezone string
+	ReportDir      string
+	ReportTop      int
+	// AllowHardlink lets a kept source be hard-linked into the warehouse
+	// instead of reflinked or copied. The copy then shares the source's
+	// data, so a producer writing the source in place changes it too.
+	AllowHardlink bool
+	// ImmutableWarehouse makes every ingested file read-only, so that an
+	// in-place write to a warehouse copy, or to a source hard-linked to
+	// one, fails instead of silently changing it
+	ImmutableWarehouse bool
+	// SetXattrs records the hash, ingest ID, processing time and source of
+	// every ingested file in extended attributes of its warehouse copy,
+	// where the filesystem supports them
+	SetXattrs bool
+	// StormRatio is the share of duplicates among the files a source sent
+	// within StormWindow, once at least StormMinFiles arrived, at which the
+	// source is in a resend storm: its files already recorded under the
+	// same path and size are rejected before hashing, and its duplicates
+	// are summarized every StormSummaryInterval instead of recorded one by
+	// one. With StormPause the source is also paused until an operator
+	// resumes it. 0 disables.
+	StormRatio           float64
+	StormWindow          time.Duration
+	StormMinFiles        int
+	StormSummaryInterval time.Duration
+	StormPause           bool
+	// Priorities are the priority classes of ready files, written as
+	// "name:pattern=priority" separated by commas: files of a higher
+	// priority are dispatched first, the longest waiting first, and the
+	// PriorityReserve fraction of the pipeline's slots is kept free of
+	// files below the highest priority present. Patterns without a slash
+	// match the file name.
+	Priorities      string
+	PriorityReserve float64
+	// ExcludeDirs are globs of directories below the input directory that
+	// are never watched, scanned or tracked, separated by commas
+	ExcludeDirs string
+	// ScanMaxTracked makes the initial scan of the input directory run in
+	// the background, processing the files it finds as it goes, with at
+	// most this many files tracked at a time. Where it got to is saved, so
+	// a restart resumes it. Zero scans the whole tree before watching.
+	ScanMaxTracked int
+	// URLLists downloads the files listed in a ready URLListSuffix file
+	// and ingests them instead of the list: up to URLConcurrency at a
+	// time, each URLTimeout without progress and at most URLMaxBytes,
+	// resumed up to URLRetries times. The list is consumed once every
+	// listed file is ingested. Only https URLs are downloaded unless
+	// URLAllowHTTP is set.
+	URLLists       bool
+	URLConcurrency int
+	URLMaxBytes    int64
+	URLTimeout     time.Duration
+	URLRetries     int
+	URLAllowHTTP   bool
+	// ShrinkPercent holds a file arriving more than this percentage smaller
+	// than the file last ingested from the same path, with other content,
+	// until an operator forces it in or ShrinkRelease passes. A zero
+	// ShrinkRelease holds it until forced; a zero ShrinkPercent disables.
+	ShrinkPercent float64
+	ShrinkRelease time.Duration
+	// ConfigDriftAlert sends an alert through the outbox when a run starts
+	// with a configuration differing from the previous run's
+	ConfigDriftAlert bool
 }
 
 const (
 	MethodStabilityWindow = "stability_window"
 	MethodSidecar         = "sidecar"
+	// MethodRename treats a file as complete as soon as it appears, for
+	// producers that write to a temporary name and rename into place
+	MethodRename = "rename"
+)
+
+// Versioning modes for files whose warehouse name is already taken
+const (
+	// VersionTimestamp names versions by ingest time, e.g. latest.2024-06-15T14:00:00Z.csv
+	VersionTimestamp = "timestamp"
+	// VersionSequence names versions by a per-path counter, e.g. latest.v3.csv
+	VersionSequence = "sequence"
 )
 
+// Manifest redaction settings
+const (
+	// ManifestRedactionNone keeps full names in manifests
+	ManifestRedactionNone = "none"
+	// ManifestRedactionPseudonym writes the pseudonyms of redacted names
+	ManifestRedactionPseudonym = "pseudonym"
+)
+
+// Reactions to the clock falling behind the latest ProcessedAt
+const (
+	// ClockRegressionContinue keeps processing; entries stay ordered by
+	// their sequence number
+	ClockRegressionContinue = "continue"
+	// ClockRegressionHold stops processing until the clock catches up
+	ClockRegressionHold = "hold"
+)
+
+// Watch backends
+const (
+	// WatchBackendAuto uses fsnotify and falls back to polling when a probe
+	// file raises no event, at startup or on a later probe
+	WatchBackendAuto = "auto"
+	// WatchBackendFsnotify uses fsnotify only and fails at startup when a
+	// probe file raises no event
+	WatchBackendFsnotify = "fsnotify"
+	// WatchBackendPoll scans the input for changes, for filesystems such as
+	// NFS and FUSE mounts that deliver no events
+	WatchBackendPoll = "poll"
+)
+
+// Manifest sinks
+const (
+	// ManifestSinkFile writes entries to the manifests directory
+	ManifestSinkFile = "file"
+	// ManifestSinkDB writes entries to the manifest_entries table of the
+	// state database
+	ManifestSinkDB = "db"
+	// ManifestSinkBoth writes entries to both
+	ManifestSinkBoth = "both"
+)
+
+// Warehouse sharding modes
+const (
+	// ShardingCount moves new files into shard-NN subdirectories once their
+	// directory holds ShardFanout entries
+	ShardingCount = "count"
+	// ShardingHash places every file under the first two hex characters of
+	// its hash
+	ShardingHash = "hash"
+)
+
+// Size accounting settings
+const (
+	// SizeAccountingLogical counts the bytes a file holds
+	SizeAccountingLogical = "logical"
+	// SizeAccountingAllocated counts the bytes the filesystem allocated for
+	// a file, far less than its logical size for sparse images
+	SizeAccountingAllocated = "allocated"
+)
+
+// Symlink handling of batch tars
+const (
+	// SymlinksSkip leaves symlinks out of the tar
+	SymlinksSkip = "skip"
+	// SymlinksFollow archives the file a symlink points to under the
+	// symlink's name; symlinks to directories are still skipped
+	SymlinksFollow = "follow"
+)
+
+// SidecarSuffix is appended to a data file name to signal its completion in sidecar mode
+const SidecarSuffix = ".ok"
+
+// TempSuffix ends the temporary names producers write files under before
+// renaming them into place
+const TempSuffix = ".tmp"
+
+// TempFileSuffixes lists file extensions that indicate temporary or
+// incomplete files, which are never watched or ingested
+var TempFileSuffixes = []string{
+	TempSuffix,
+	".part",
+	".swp",
+	".crdownload",
+	".partial",
+	".download",
+	"~",
+}
+
+// FileSetMarkerSuffix is appended to a multi-part file's combined name to
+// signal that all of its parts have been written
+const FileSetMarkerSuffix = ".complete"
+
+// ReceiptSuffix is appended to a source file name to name its ingestion
+// receipt. Receipts are never watched or ingested.
+const ReceiptSuffix = ".receipt.json"
+
+// URLListSuffix ends the name of a URL list, a JSON file listing files to
+// download and ingest in its place when URLLists is enabled
+const URLListSuffix = ".urls.json"
+
+// TrashDirName is the directory under the input root that holds ingested
+// sources during the grace period. It is never watched or ingested.
+const TrashDirName = ".ingested-trash"
+
+// SelfTestDirPrefix starts the name of the directory under the input root
+// holding a self-test probe. Being hidden, it is never watched.
+const SelfTestDirPrefix = ".atomic-ingestor-self-test-"
+
+// ProcessInterval is how often the daemon looks for files ready to process
+const ProcessInterval = time.Second
+
 // Default values
 const (
 	DefaultInputPath        = "files"
@@ -27,4 +385,51 @@ const (
 	DefaultStatePath        = "gorm.db"
 	DefaultLogLevel         = "info"
 	DefaultConcurrency      = 1
+	DefaultSyncPolicy       = "always"
+	DefaultDirectThreshold  = 0
+	DefaultQuarantinePath   = "quarantine"
+	DefaultMaxNameBytes     = 255
+	DefaultMaxPathBytes     = 4096
+	DefaultSweepInterval    = 10 * time.Minute
+	DefaultPartTimeout      = time.Hour
+	DefaultManifestFormat   = "jsonl"
+	DefaultManifestPeriod   = "hourly"
+	DefaultReceiptRetention = 7 * 24 * time.Hour
+	DefaultBatchMarker      = "_SUCCESS"
+	DefaultLogDedupWindow   = time.Minute
+	DefaultJanitorInterval  = time.Hour
+	DefaultJanitorMaxFiles  = 100
+	DefaultJanitorMaxPct    = 10
+	DefaultJanitorAuditLog  = "janitor-audit.jsonl"
+	DefaultNormalization    = "nfc"
+	DefaultShardFanout      = 10000
+	DefaultOutboxLimit      = 100000
+	DefaultClockTolerance   = 2 * time.Second
+	DefaultFinalizeGrace    = time.Hour
+	DefaultPollInterval     = 2 * time.Second
+	DefaultProbeInterval    = 5 * time.Minute
+	DefaultTombstoneWindow  = 30 * time.Second
+	DefaultDuplicateCache   = time.Minute
+	DefaultProbeCache       = 5 * time.Second
+	DefaultProbeMaxBackoff  = 30 * time.Second
+	DefaultDigestTop        = 10
+	DefaultReportTop        = 10
+	DefaultAttemptRetention = 30 * 24 * time.Hour
+	DefaultTimingSampleRate = 0.01
+	DefaultInputSpaceCheck  = time.Minute
+	DefaultInputMinFree     = 5.0
+	DefaultStrictWindow     = 24 * time.Hour
+	DefaultStormWindow      = 10 * time.Minute
+	DefaultStormMinFiles    = 100
+	DefaultStormSummary     = time.Minute
+	DefaultConfigHistory    = 20
+	DefaultPriorityReserve  = 0.25
+	DefaultURLConcurrency   = 4
+	DefaultURLMaxBytes      = 4 << 30
+	DefaultURLTimeout       = 30 * time.Second
+	DefaultURLRetries       = 3
 )
+
+// MinNameBytes is the smallest name limit that still leaves room for the
+// hash suffix added when shortening
+const MinNameBytes = 32
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// redactedPrefix starts the fingerprint recorded instead of a secret, which
+// tells a changed secret apart without revealing it
+const redactedPrefix = "redacted:"
+
+// sensitive redacts the fields whose values must not be stored or shown
+var sensitive = map[string]func(string) string{
+	"AdminToken": redactSecret,
+	"NotifyURL":  redactURL,
+}
+
+// Change is a field whose value differs between two configurations
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Effective returns every field of c by snake_case name, formatted as text,
+// with sensitive values redacted
+func Effective(c *Config) map[string]string {
+	fields := make(map[string]string)
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		value := fmt.Sprint(v.Field(i).Interface())
+		if redact, ok := sensitive[f.Name]; ok {
+			value = redact(value)
+		}
+		fields[snakeCase(f.Name)] = value
+	}
+	return fields
+}
+
+// Diff returns the fields that changed from previous to current by name,
+// including fields one of them lacks, as across upgrades adding options
+func Diff(previous, current map[string]string) []Change {
+	var changes []Change
+	for field, value := range current {
+		if old, ok := previous[field]; !ok || old != value {
+			changes = append(changes, Change{Field: field, Old: old, New: value})
+		}
+	}
+	for field, old := range previous {
+		if _, ok := current[field]; !ok {
+			changes = append(changes, Change{Field: field, Old: old})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// redactSecret replaces a secret by a short fingerprint of it
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return redactedPrefix + hex.EncodeToString(sum[:4])
+}
+
+// redactURL hides the password and query of a URL, either of which may
+// carry credentials, keeping a fingerprint of the whole URL
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactSecret(s)
+	}
+	if _, ok := u.User.Password(); !ok && u.RawQuery == "" {
+		return s
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.Redacted() + " (" + redactSecret(s) + ")"
+}
+
+// snakeCase converts a Go field name to snake_case, keeping acronyms
+// together: NotifyURL becomes notify_url
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the optional YAML configuration file for settings that do not fit
+// command-line flags
+type File struct {
+	// Completion selects the completion method per file pattern. Rules are
+	// matched in order; files matching none use the global -mode.
+	Completion []CompletionRule `yaml:"completion"`
+	// Pipelines select the post-processing steps per file pattern. Rules
+	// are matched in order; files matching none run the default steps.
+	Pipelines []PipelineRule `yaml:"pipelines"`
+	// Janitor cleans up files in the input tree that are never ingested.
+	// Files matching a completion or pipeline pattern are never touched.
+	Janitor *JanitorConfig `yaml:"janitor"`
+	// Redaction hides sensitive file names from logs, events and, with
+	// -manifest-redaction, manifests. The state database keeps them.
+	Redaction *RedactionConfig `yaml:"redaction"`
+	// Rename rewrites the warehouse path of ingested files. Rules are
+	// matched in order against the path relative to the input directory
+	// and the first match applies; the source name is kept as the original
+	// name.
+	Rename []RenameRule `yaml:"rename"`
+	// Sources set options of single sources, the top-level directories of
+	// the input tree
+	Sources []SourceConfig `yaml:"sources"`
+	// SLA expects files at deadlines and alerts when one passes without
+	SLA []SLARule `yaml:"sla"`
+	// Paths maps artifacts (warehouse, manifest, quarantine,
+	// quarantine_set, receipt) to the path template they are written at,
+	// relative to their base directory; see package layout. Artifacts left
+	// out keep today's layout.
+	Paths map[string]string `yaml:"paths"`
+}
+
+// SLARule expects a file matching Pattern to be ingested before every
+// firing of Schedule, a cron expression evaluated in Timezone, UTC when
+// empty. Pattern is matched against the path relative to the directory of
+// Source, or to the input directory when Source is empty. A file counts for
+// a deadline when ingested within Window before it, since the previous
+// firing when zero. A missed deadline alerts as a warning at once and as
+// critical once Grace is over, at once when Grace is zero.
+type SLARule struct {
+	Name     string        `yaml:"name"`
+	Source   string        `yaml:"source"`
+	Pattern  string        `yaml:"pattern"`
+	Schedule string        `yaml:"schedule"`
+	Timezone string        `yaml:"timezone"`
+	Window   time.Duration `yaml:"window"`
+	Grace    time.Duration `yaml:"grace"`
+}
+
+// SourceConfig sets the options of the source Name
+type SourceConfig struct {
+	Name string `yaml:"name"`
+	// ReadOnly copies the files of a source the ingestor cannot write, such
+	// as a read-only snapshot mount, and leaves them in place: no receipts,
+	// trash or source removal. Restarts skip the files ingested before
+	// without hashing them.
+	ReadOnly bool `yaml:"read_only"`
+	// Paths overrides the path templates of Paths in the configuration
+	// file for the files of the source
+	Paths map[string]string `yaml:"paths"`
+}
+
+// RenameRule replaces the leftmost match of the regular expression Match in
+// a file's relative path with Replace, in which $name, ${name} and $1 refer
+// to capture groups. Renamed paths are normalized and checked for
+// collisions like any other.
+type RenameRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// RedactionConfig selects the path components replaced by pseudonyms
+type RedactionConfig struct {
+	// Patterns are globs matched against single path components
+	Patterns []string `yaml:"patterns"`
+	// Tenants are top-level input directories whose every name below is
+	// redacted
+	Tenants []string `yaml:"tenants"`
+	// KeyFile holds the secret HMAC key pseudonyms are derived from. Keep it
+	// stable: a new key changes every pseudonym.
+	KeyFile string `yaml:"key_file"`
+}
+
+// JanitorConfig configures the periodic cleanup of the input tree
+type JanitorConfig struct {
+	// Interval between sweeps, DefaultJanitorInterval when zero
+	Interval time.Duration `yaml:"interval"`
+	Rules    []JanitorRule `yaml:"rules"`
+	// MaxFiles and MaxPercent cap how many files, in total and as a share
+	// of the files in the input tree, one sweep may remove. A sweep over
+	// either cap removes nothing unless OverrideSafetyCap is set.
+	MaxFiles          int     `yaml:"max_files"`
+	MaxPercent        float64 `yaml:"max_percent"`
+	OverrideSafetyCap bool    `yaml:"override_safety_cap"`
+	// AuditLog is the JSON Lines file recording every removal,
+	// DefaultJanitorAuditLog when empty
+	AuditLog string `yaml:"audit_log"`
+	// DryRun only logs what would be removed; -dry-run implies it
+	DryRun bool `yaml:"dry_run"`
+	// EmptyDirs removes the directories left empty in the input tree, such
+	// as those of ingested batches, by the daemon's sweeps
+	EmptyDirs *JanitorEmptyDirs `yaml:"empty_dirs"`
+}
+
+// JanitorEmptyDirs removes the directories below the input directory whose
+// mtime, which moves whenever an entry is added or removed, is older than
+// OlderThan while they are empty. The input directory itself and the
+// directories matching a Keep pattern are never removed.
+type JanitorEmptyDirs struct {
+	OlderThan time.Duration `yaml:"older_than"`
+	// Keep lists the patterns of directories to keep, relative to the input
+	// directory, such as those producers create ahead of time
+	Keep []string `yaml:"keep"`
+}
+
+// JanitorRule removes files whose path relative to the input directory
+// matches Pattern once their mtime is older than OlderThan
+type JanitorRule struct {
+	Pattern   string        `yaml:"pattern"`
+	OlderThan time.Duration `yaml:"older_than"`
+	// Action is JanitorDelete or JanitorTrash, the default
+	Action string `yaml:"action"`
+}
+
+// Janitor actions
+const (
+	// JanitorDelete removes matching files permanently
+	JanitorDelete = "delete"
+	// JanitorTrash moves matching files into the input trash, from where
+	// the trash sweeper deletes them after -source-grace
+	JanitorTrash = "trash"
+)
+
+// PipelineRule runs Steps, in order, for files whose path relative to the
+// input directory matches Pattern
+type PipelineRule struct {
+	Pattern string   `yaml:"pattern"`
+	Steps   []string `yaml:"steps"`
+}
+
+// CompletionRule routes files whose path relative to the input directory
+// matches Pattern to Method
+type CompletionRule struct {
+	Pattern string           `yaml:"pattern"`
+	Method  string           `yaml:"method"`
+	Params  CompletionParams `yaml:"params"`
+}
+
+// CompletionParams are method-specific settings of a CompletionRule
+type CompletionParams struct {
+	// StabilitySeconds overrides -stability-seconds for stability_window rules
+	StabilitySeconds int `yaml:"stability_seconds"`
+}
+
+// LoadFile reads a YAML configuration file
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode config file %s: %w", path, err)
+	}
+	return &f, nil
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`
+completion:
+  - pattern: "vendorA/*"
+    method: sidecar
+  - pattern: "vendorB/*.csv"
+    method: stability_window
+    params:
+      stability_seconds: 5
+`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(f.Completion) != 2 {
+		t.Fatalf("got %d completion rules, want 2", len(f.Completion))
+	}
+	if f.Completion[1].Params.StabilitySeconds != 5 {
+		t.Errorf("stability_seconds = %d, want 5", f.Completion[1].Params.StabilitySeconds)
+	}
+}
+
+func TestLoadFile_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(f.Completion) != 0 {
+		t.Errorf("got %d completion rules, want 0", len(f.Completion))
+	}
+}
+
+func TestLoadFile_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("completions: []\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile() succeeded, want error for unknown field")
+	}
+}
+
+func TestLoadFile_Pipelines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`
+pipelines:
+  - pattern: "exports/**/*.csv"
+    steps: [dedup, resolve, validate-csv, compress, claim, move, manifest]
+`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(f.Pipelines) != 1 {
+		t.Fatalf("got %d pipeline rules, want 1", len(f.Pipelines))
+	}
+	if got := f.Pipelines[0].Steps; len(got) != 7 || got[2] != "validate-csv" {
+		t.Errorf("steps = %v", got)
+	}
+}
+
+func TestLoadFile_Sources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`
+paths:
+  quarantine: "{yyyy}/{sha256}{ext}"
+sources:
+  - name: snapshot
+    read_only: true
+  - name: partners
+    paths:
+      warehouse: "partners/{yyyy}/{name}"
+`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	want := []SourceConfig{
+		{Name: "snapshot", ReadOnly: true},
+		{Name: "partners", Paths: map[string]string{"warehouse": "partners/{yyyy}/{name}"}},
+	}
+	if !reflect.DeepEqual(f.Sources, want) {
+		t.Errorf("sources = %+v, want %+v", f.Sources, want)
+	}
+	if got := f.Paths["quarantine"]; got != "{yyyy}/{sha256}{ext}" {
+		t.Errorf("quarantine path = %q, want the configured template", got)
+	}
+}
+
+func TestLoadFile_SLA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`
+sla:
+  - name: acme-daily
+    source: acme
+    pattern: "orders-*.csv"
+    schedule: "0 7 * * *"
+    timezone: Europe/Berlin
+    grace: 2h
+`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	want := SLARule{Name: "acme-daily", Source: "acme", Pattern: "orders-*.csv", Schedule: "0 7 * * *", Timezone: "Europe/Berlin", Grace: 2 * time.Hour}
+	if len(f.SLA) != 1 || f.SLA[0] != want {
+		t.Errorf("sla = %+v, want %+v", f.SLA, want)
+	}
+}
+
+func TestLoadFile_Janitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte(`
+janitor:
+  interval: 30m
+  max_files: 50
+  rules:
+    - pattern: "**/*.log"
+      older_than: 168h
+      action: delete
+  empty_dirs:
+    older_than: 24h
+    keep: ["incoming/*"]
+`)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	j := f.Janitor
+	if j == nil || j.Interval != 30*time.Minute || j.MaxFiles != 50 || len(j.Rules) != 1 {
+		t.Fatalf("janitor = %+v", j)
+	}
+	if r := j.Rules[0]; r.OlderThan != 7*24*time.Hour || r.Action != JanitorDelete {
+		t.Errorf("rule = %+v", r)
+	}
+	if d := j.EmptyDirs; d == nil || d.OlderThan != 24*time.Hour || len(d.Keep) != 1 || d.Keep[0] != "incoming/*" {
+		t.Errorf("empty_dirs = %+v", d)
+	}
+}
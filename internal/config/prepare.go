@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+)
+
+// DefaultDirMode is the mode of directories created at startup
+const DefaultDirMode fs.FileMode = 0o755
+
+// probePattern names the file created and removed to check a directory is
+// writable. It is hidden and temporary, so the watcher never tracks it.
+const probePattern = ".write-probe-*" + TempSuffix
+
+// ErrPrepare is wrapped by every error of Prepare
+var ErrPrepare = errors.New("prepare directories")
+
+// PreparedDir is a directory verified by Prepare
+type PreparedDir struct {
+	// Role names the directory by its flag, e.g. "warehouse"
+	Role string
+	// Path is absolute
+	Path string
+	// Created is set when Prepare created the directory rather than
+	// finding it
+	Created bool
+}
+
+// dirSpec is a directory Prepare verifies: whether it may be created when
+// missing and whether it must be writable
+type dirSpec struct {
+	role   string
+	path   string
+	create bool
+	write  bool
+}
+
+// Prepare creates the directories the ingestor writes to when missing, with
+// mode DirMode, and verifies that each is a writable directory: the
+// warehouse with its staging directory, the manifests and quarantine
+// directories and, when sources are kept for a grace period, the input
+// trash. The input directory is only created when CreateInput is set, and is
+// only probed for writes when sources are deleted. The first failure
+// returns an error naming the path and the remedy.
+func (c *Config) Prepare() ([]PreparedDir, error) {
+	mode := c.DirMode
+	if mode == 0 {
+		mode = DefaultDirMode
+	}
+	quarantine := c.QuarantinePath
+	if quarantine == "" {
+		quarantine = DefaultQuarantinePath
+	}
+
+	dirs := []dirSpec{
+		{"input", c.Path, c.CreateInput, !c.KeepSource},
+		{"warehouse", c.Destination, true, true},
+		{"staging", filepath.Join(c.Destination, filepath.FromSlash(destination.StagingPrefix)), true, true},
+		{"scratch", filepath.Join(c.Destination, filepath.FromSlash(destination.ScratchPrefix)), true, true},
+		{"manifests", c.ManifestsPath, true, true},
+		{"quarantine", quarantine, true, true},
+	}
+	if c.SourceGrace > 0 {
+		dirs = append(dirs, dirSpec{"trash", filepath.Join(c.Path, TrashDirName), true, true})
+	}
+
+	prepared := make([]PreparedDir, 0, len(dirs))
+	for _, d := range dirs {
+		if d.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(d.path)
+		if err != nil {
+			return prepared, fmt.Errorf("%w: absolute path of %s: %w", ErrPrepare, d.path, err)
+		}
+		created, err := prepareDir(d.role, path, mode, d.create, d.write)
+		if err != nil {
+			return prepared, err
+		}
+		prepared = append(prepared, PreparedDir{Role: d.role, Path: path, Created: created})
+	}
+	return prepared, nil
+}
+
+// prepareDir makes sure path is a directory, creating it when create is set,
+// and probes it for writes when write is set. It reports whether it created
+// the directory.
+func prepareDir(role, path string, mode fs.FileMode, create, write bool) (bool, error) {
+	created := false
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && !create:
+		return false, fmt.Errorf("%w: %s directory %s does not exist; create it or pass -create-input", ErrPrepare, role, path)
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.MkdirAll(path, mode); err != nil {
+			return false, fmt.Errorf("%w: create %s directory %s: %w; create it by hand or make its parent writable", ErrPrepare, role, path, err)
+		}
+		created = true
+	case err != nil:
+		return false, fmt.Errorf("%w: %s directory %s: %w; check the permissions of its parent", ErrPrepare, role, path, err)
+	case !info.IsDir():
+		return false, fmt.Errorf("%w: %s path %s is not a directory; remove it or choose another path", ErrPrepare, role, path)
+	}
+
+	if write {
+		probe, err := os.CreateTemp(path, probePattern)
+		if err != nil {
+			return created, fmt.Errorf("%w: %s directory %s is not writable: %w; fix its ownership or permissions for this user", ErrPrepare, role, path, err)
+		}
+		_ = probe.Close()
+		if err := os.Remove(probe.Name()); err != nil {
+			return created, fmt.Errorf("%w: remove write probe %s: %w", ErrPrepare, probe.Name(), err)
+		}
+	}
+	return created, nil
+}
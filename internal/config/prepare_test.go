@@ -0,0 +1,157 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prepareConfig returns a config with every directory under root
+func prepareConfig(root string) *Config {
+	return &Config{
+		Path:           filepath.Join(root, "input"),
+		Destination:    filepath.Join(root, "warehouse"),
+		ManifestsPath:  filepath.Join(root, "manifests"),
+		QuarantinePath: filepath.Join(root, "quarantine"),
+	}
+}
+
+// skipIfRoot skips permission tests, which root passes regardless
+func skipIfRoot(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+}
+
+func TestPrepare_CreatesMissingOutputs(t *testing.T) {
+	root := t.TempDir()
+	cfg := prepareConfig(root)
+	cfg.SourceGrace = time.Hour
+	cfg.DirMode = 0o700
+	if err := os.Mkdir(cfg.Path, 0o755); err != nil {
+		t.Fatalf("failed to create input: %v", err)
+	}
+
+	prepared, err := cfg.Prepare()
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	created := map[string]bool{}
+	for _, d := range prepared {
+		created[d.Role] = d.Created
+		info, err := os.Stat(d.Path)
+		if err != nil || !info.IsDir() {
+			t.Errorf("%s %s is not a directory: %v", d.Role, d.Path, err)
+			continue
+		}
+		if d.Created && info.Mode().Perm() != 0o700 {
+			t.Errorf("%s created with mode %v, want 0700", d.Role, info.Mode().Perm())
+		}
+	}
+	want := map[string]bool{"input": false, "warehouse": true, "staging": true, "scratch": true, "manifests": true, "quarantine": true, "trash": true}
+	for role, c := range want {
+		if got, ok := created[role]; !ok || got != c {
+			t.Errorf("%s created = %v (prepared %v), want %v", role, got, ok, c)
+		}
+	}
+
+	// A second start finds every directory
+	prepared, err = cfg.Prepare()
+	if err != nil {
+		t.Fatalf("Prepare() again error = %v", err)
+	}
+	for _, d := range prepared {
+		if d.Created {
+			t.Errorf("%s created again", d.Role)
+		}
+	}
+	// The probes are gone
+	if entries, _ := os.ReadDir(cfg.Destination); len(entries) != 2 {
+		t.Errorf("warehouse holds %v, want only the staging and scratch directories", entries)
+	}
+}
+
+func TestPrepare_MissingInput(t *testing.T) {
+	cfg := prepareConfig(t.TempDir())
+
+	_, err := cfg.Prepare()
+	if !errors.Is(err, ErrPrepare) || !strings.Contains(err.Error(), cfg.Path) || !strings.Contains(err.Error(), "-create-input") {
+		t.Fatalf("Prepare() error = %v, want the missing input and the remedy", err)
+	}
+
+	cfg.CreateInput = true
+	prepared, err := cfg.Prepare()
+	if err != nil {
+		t.Fatalf("Prepare() with CreateInput error = %v", err)
+	}
+	if prepared[0].Role != "input" || !prepared[0].Created {
+		t.Errorf("prepared input = %+v, want it created", prepared[0])
+	}
+}
+
+func TestPrepare_NotADirectory(t *testing.T) {
+	root := t.TempDir()
+	cfg := prepareConfig(root)
+	cfg.CreateInput = true
+	if err := os.WriteFile(cfg.ManifestsPath, []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	_, err := cfg.Prepare()
+	if !errors.Is(err, ErrPrepare) || !strings.Contains(err.Error(), "manifests path "+cfg.ManifestsPath+" is not a directory") {
+		t.Errorf("Prepare() error = %v, want the manifests path named as not a directory", err)
+	}
+}
+
+func TestPrepare_Unwritable(t *testing.T) {
+	skipIfRoot(t)
+	root := t.TempDir()
+	cfg := prepareConfig(root)
+	cfg.CreateInput = true
+	if err := os.Mkdir(cfg.Destination, 0o555); err != nil {
+		t.Fatalf("failed to create warehouse: %v", err)
+	}
+
+	_, err := cfg.Prepare()
+	if !errors.Is(err, ErrPrepare) || !strings.Contains(err.Error(), "warehouse directory "+cfg.Destination+" is not writable") {
+		t.Errorf("Prepare() error = %v, want the warehouse named as not writable", err)
+	}
+}
+
+func TestPrepare_UncreatableBelowReadOnlyParent(t *testing.T) {
+	skipIfRoot(t)
+	root := t.TempDir()
+	cfg := prepareConfig(root)
+	cfg.CreateInput = true
+	parent := filepath.Join(root, "readonly")
+	if err := os.Mkdir(parent, 0o555); err != nil {
+		t.Fatalf("failed to create parent: %v", err)
+	}
+	cfg.QuarantinePath = filepath.Join(parent, "quarantine")
+
+	_, err := cfg.Prepare()
+	if !errors.Is(err, ErrPrepare) || !strings.Contains(err.Error(), "create quarantine directory "+cfg.QuarantinePath) {
+		t.Errorf("Prepare() error = %v, want the quarantine directory named as not creatable", err)
+	}
+}
+
+func TestPrepare_ReadOnlyInputWhenSourcesKept(t *testing.T) {
+	skipIfRoot(t)
+	root := t.TempDir()
+	cfg := prepareConfig(root)
+	if err := os.Mkdir(cfg.Path, 0o555); err != nil {
+		t.Fatalf("failed to create input: %v", err)
+	}
+
+	if _, err := cfg.Prepare(); err == nil || !strings.Contains(err.Error(), "input directory") {
+		t.Errorf("Prepare() error = %v, want the read-only input refused while sources are deleted", err)
+	}
+	cfg.KeepSource = true
+	if _, err := cfg.Prepare(); err != nil {
+		t.Errorf("Prepare() with sources kept error = %v, want a read-only input accepted", err)
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// ErrStagingDevice is returned by Topology when the staging directory is
+// not on the filesystem of the warehouse: staged objects are committed by
+// renaming them into place, which is only atomic within one filesystem
+var ErrStagingDevice = errors.New("staging and warehouse on different filesystems")
+
+// Filesystem is the filesystem a directory of the ingestor is on
+type Filesystem struct {
+	Role   string `json:"role"`
+	Path   string `json:"path"`
+	Device uint64 `json:"device"`
+	FSType string `json:"fs_type,omitempty"`
+	// SharedWith lists the roles of the other directories on the same
+	// filesystem
+	SharedWith []string `json:"shared_with"`
+	// Error is why the filesystem could not be identified; such a
+	// directory is left out of the checks
+	Error string `json:"error,omitempty"`
+}
+
+// Topology is how the directories of the ingestor share filesystems
+type Topology struct {
+	Filesystems []Filesystem `json:"filesystems"`
+	// Warnings are the placements that work but cost I/O or resilience
+	Warnings []string `json:"warnings"`
+}
+
+// Topology identifies the filesystem of each of dirs with stat, which is
+// fileops.DeviceOf outside tests. It warns when sources are moved from an
+// input on another filesystem than the warehouse, since every move then
+// copies the file and is no longer an atomic rename, and fails with
+// ErrStagingDevice, along with the topology, when staging or scratch and the
+// warehouse are apart.
+func (c *Config) Topology(dirs []PreparedDir, stat func(path string) (fileops.Device, error)) (Topology, error) {
+	t := Topology{Filesystems: make([]Filesystem, 0, len(dirs)), Warnings: make([]string, 0)}
+	for _, d := range dirs {
+		fs := Filesystem{Role: d.Role, Path: d.Path, SharedWith: make([]string, 0)}
+		dev, err := stat(d.Path)
+		if err != nil {
+			fs.Error = err.Error()
+		} else {
+			fs.Device, fs.FSType = dev.ID, dev.FSType
+		}
+		t.Filesystems = append(t.Filesystems, fs)
+	}
+	for i := range t.Filesystems {
+		a := &t.Filesystems[i]
+		for _, b := range t.Filesystems {
+			if a.Role != b.Role && a.Error == "" && b.Error == "" && a.Device == b.Device && !slices.Contains(a.SharedWith, b.Role) {
+				a.SharedWith = append(a.SharedWith, b.Role)
+			}
+		}
+	}
+
+	input, inputOK := t.find("input")
+	warehouse, warehouseOK := t.find("warehouse")
+	if inputOK && warehouseOK && input.Device != warehouse.Device && !c.KeepSource {
+		t.Warnings = append(t.Warnings, fmt.Sprintf(
+			"input %s and warehouse %s are on different filesystems: every move copies the file, doubling the I/O, and is not atomic",
+			input.Path, warehouse.Path))
+	}
+	for _, role := range []string{"staging", "scratch"} {
+		if d, ok := t.find(role); warehouseOK && ok && d.Device != warehouse.Device {
+			return t, fmt.Errorf("%w: %s %s is not on the filesystem of warehouse %s; mount it with the warehouse", ErrStagingDevice, role, d.Path, warehouse.Path)
+		}
+	}
+	return t, nil
+}
+
+// find returns the identified filesystem of role
+func (t Topology) find(role string) (Filesystem, bool) {
+	for _, fs := range t.Filesystems {
+		if fs.Role == role {
+			return fs, fs.Error == ""
+		}
+	}
+	return Filesystem{}, false
+}
+
+// Redacted returns a copy of the topology with its paths passed through
+// redact
+func (t Topology) Redacted(redact func(string) string) Topology {
+	r := Topology{Filesystems: slices.Clone(t.Filesystems), Warnings: make([]string, 0, len(t.Warnings))}
+	for i := range r.Filesystems {
+		r.Filesystems[i].Path = redact(r.Filesystems[i].Path)
+		r.Filesystems[i].Error = redact(r.Filesystems[i].Error)
+	}
+	for _, w := range t.Warnings {
+		r.Warnings = append(r.Warnings, redact(w))
+	}
+	return r
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// topologyDirs are the directories of a deployment, each named by its role
+var topologyDirs = []PreparedDir{
+	{Role: "input", Path: "/input"},
+	{Role: "warehouse", Path: "/warehouse"},
+	{Role: "staging", Path: "/warehouse/_staging"},
+	{Role: "manifests", Path: "/manifests"},
+	{Role: "state", Path: "/state"},
+}
+
+// devices returns a stat reporting the device of each path, failing for
+// paths it does not know
+func devices(devs map[string]uint64) func(string) (fileops.Device, error) {
+	return func(path string) (fileops.Device, error) {
+		dev, ok := devs[path]
+		if !ok {
+			return fileops.Device{}, errors.New("no such device")
+		}
+		return fileops.Device{ID: dev, FSType: "ext4"}, nil
+	}
+}
+
+func TestTopology(t *testing.T) {
+	tests := map[string]struct {
+		devs       map[string]uint64
+		keepSource bool
+		warnings   int
+		err        error
+	}{
+		"one filesystem": {
+			devs: map[string]uint64{"/input": 1, "/warehouse": 1, "/warehouse/_staging": 1, "/manifests": 1, "/state": 1},
+		},
+		"warehouse apart from input": {
+			devs:     map[string]uint64{"/input": 1, "/warehouse": 2, "/warehouse/_staging": 2, "/manifests": 1, "/state": 1},
+			warnings: 1,
+		},
+		"warehouse apart from kept sources": {
+			devs:       map[string]uint64{"/input": 1, "/warehouse": 2, "/warehouse/_staging": 2, "/manifests": 1, "/state": 1},
+			keepSource: true,
+		},
+		"staging apart from warehouse": {
+			devs: map[string]uint64{"/input": 1, "/warehouse": 1, "/warehouse/_staging": 2, "/manifests": 1, "/state": 1},
+			err:  ErrStagingDevice,
+		},
+		"staging and warehouse apart from input and each other": {
+			devs:     map[string]uint64{"/input": 1, "/warehouse": 2, "/warehouse/_staging": 3, "/manifests": 1, "/state": 1},
+			warnings: 1,
+			err:      ErrStagingDevice,
+		},
+		"unidentified warehouse": {
+			devs: map[string]uint64{"/input": 1, "/warehouse/_staging": 2, "/manifests": 1, "/state": 1},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &Config{KeepSource: tt.keepSource}
+			topology, err := cfg.Topology(topologyDirs, devices(tt.devs))
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("Topology() error = %v, want %v", err, tt.err)
+			}
+			if len(topology.Warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", topology.Warnings, tt.warnings)
+			}
+			if len(topology.Filesystems) != len(topologyDirs) {
+				t.Errorf("filesystems = %+v, want one per directory", topology.Filesystems)
+			}
+		})
+	}
+}
+
+func TestTopology_SharedWith(t *testing.T) {
+	devs := map[string]uint64{"/input": 1, "/warehouse": 2, "/warehouse/_staging": 2, "/manifests": 2}
+	topology, err := (&Config{}).Topology(topologyDirs, devices(devs))
+	if err != nil {
+		t.Fatalf("Topology() error = %v", err)
+	}
+	byRole := make(map[string]Filesystem)
+	for _, fs := range topology.Filesystems {
+		byRole[fs.Role] = fs
+	}
+	if got := byRole["warehouse"].SharedWith; !slices.Equal(got, []string{"staging", "manifests"}) {
+		t.Errorf("warehouse shared with %q, want staging and manifests", got)
+	}
+	if got := byRole["input"].SharedWith; len(got) != 0 {
+		t.Errorf("input shared with %q, want none", got)
+	}
+	if state := byRole["state"]; state.Error == "" || len(state.SharedWith) != 0 {
+		t.Errorf("state = %+v, want it unidentified and left out", state)
+	}
+	if len(topology.Warnings) != 1 || !strings.Contains(topology.Warnings[0], "/input") {
+		t.Errorf("warnings = %q, want the cross-filesystem move", topology.Warnings)
+	}
+
+	redacted := topology.Redacted(func(s string) string { return strings.ReplaceAll(s, "/input", "/x") })
+	if redacted.Filesystems[0].Path != "/x" || strings.Contains(redacted.Warnings[0], "/input") || topology.Filesystems[0].Path != "/input" {
+		t.Errorf("Redacted() = %+v, want a redacted copy", redacted)
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathOverlap is returned when the input directory and an output
+// directory contain one another
+var ErrPathOverlap = errors.New("paths overlap")
+
+// Validate checks the configuration for mistakes that would make the
+// ingestor process its own output. The input directory must neither contain
+// nor sit inside the warehouse, manifests or quarantine directory, or every
+// ingested file would reappear as a new input. Paths are compared after
+// resolving symlinks, so equivalent spellings of one directory are caught.
+func (c *Config) Validate() error {
+	input, err := ResolvePath(c.Path)
+	if err != nil {
+		return fmt.Errorf("resolve input path: %w", err)
+	}
+
+	quarantine := c.QuarantinePath
+	if quarantine == "" {
+		quarantine = DefaultQuarantinePath
+	}
+	outputs := []struct {
+		flag string
+		path string
+	}{
+		{"warehouse", c.Destination},
+		{"manifests", c.ManifestsPath},
+		{"quarantine", quarantine},
+	}
+
+	for _, out := range outputs {
+		if out.path == "" {
+			continue
+		}
+		resolved, err := ResolvePath(out.path)
+		if err != nil {
+			return fmt.Errorf("resolve %s path: %w", out.flag, err)
+		}
+		switch {
+		case Contains(input, resolved):
+			return fmt.Errorf("%w: %s %s is inside input %s", ErrPathOverlap, out.flag, out.path, c.Path)
+		case Contains(resolved, input):
+			return fmt.Errorf("%w: input %s is inside %s %s", ErrPathOverlap, c.Path, out.flag, out.path)
+		}
+	}
+	return nil
+}
+
+// ResolvePath returns the cleaned absolute form of path with symlinks
+// resolved. Components that do not exist yet are appended unresolved to the
+// deepest existing ancestor, so directories created later still compare
+// correctly.
+func ResolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("absolute path of %s: %w", path, err)
+	}
+
+	existing, rest := abs, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("resolve symlinks of %s: %w", existing, err)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+// Contains reports whether path is dir or lies below it. Both must be
+// cleaned absolute paths.
+func Contains(dir, path string) bool {
+	if dir == path {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate_PathOverlap(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"input", "input2", "data/warehouse"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+	// link is another name for the input directory, data/in a name for data
+	if err := os.Symlink(filepath.Join(root, "input"), filepath.Join(root, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "data"), filepath.Join(root, "input", "data")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	at := func(p string) string { return filepath.Join(root, p) }
+	tests := []struct {
+		name      string
+		input     string
+		warehouse string
+		manifests string
+		overlap   bool
+	}{
+		{"separate", at("input"), at("data/warehouse"), at("manifests"), false},
+		{"sibling with common prefix", at("input"), at("input2"), at("manifests"), false},
+		{"warehouse inside input", at("input"), at("input/warehouse"), at("manifests"), true},
+		{"input inside warehouse", at("data/warehouse/in"), at("data/warehouse"), at("manifests"), true},
+		{"same directory", at("input"), at("input"), at("manifests"), true},
+		{"manifests inside input", at("input"), at("data/warehouse"), at("input/manifests"), true},
+		{"warehouse inside symlinked input", at("link"), at("input/wh"), at("manifests"), true},
+		{"symlinked warehouse inside input", at("input"), at("link/new/wh"), at("manifests"), true},
+		{"input contains warehouse through symlink", at("data"), at("input/data/warehouse"), at("manifests"), true},
+		{"relative dot segments", at("input"), at("data/../input/./wh"), at("manifests"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Path:           tt.input,
+				Destination:    tt.warehouse,
+				ManifestsPath:  tt.manifests,
+				QuarantinePath: at("quarantine"),
+			}
+			err := cfg.Validate()
+			if got := errors.Is(err, ErrPathOverlap); got != tt.overlap {
+				t.Errorf("Validate() error = %v, want overlap %v", err, tt.overlap)
+			}
+		})
+	}
+}
+
+func TestValidate_DefaultQuarantineInsideInput(t *testing.T) {
+	root := t.TempDir()
+	t.Chdir(root)
+
+	cfg := &Config{Path: ".", Destination: filepath.Join(t.TempDir(), "warehouse")}
+	if err := cfg.Validate(); !errors.Is(err, ErrPathOverlap) {
+		t.Errorf("Validate() error = %v, want overlap with the default quarantine", err)
+	}
+}
+
+func TestResolvePath_Missing(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Symlink(root, filepath.Join(root, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	got, err := ResolvePath(filepath.Join(root, "link", "a", "b"))
+	if err != nil {
+		t.Fatalf("ResolvePath() error = %v", err)
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatalf("EvalSymlinks() error = %v", err)
+	}
+	if want := filepath.Join(real, "a", "b"); got != want {
+		t.Errorf("ResolvePath() = %q, want %q", got, want)
+	}
+}
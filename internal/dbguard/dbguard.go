@@ -0,0 +1,252 @@
+// Package dbguard stops the ingestor when its state database is corrupt.
+// Nothing ingests without the database, so instead of failing every file on
+// every tick, the first corruption error pauses processing, takes the daemon
+// out of readiness and sends one critical alert. Processing then stays
+// stopped for an operator, or, when recovery is enabled, resumes once the
+// database was moved aside and rebuilt from the manifests. Every step is
+// recorded in the maintenance audit log.
+package dbguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// States of the state database
+const (
+	StateHealthy = "healthy"
+	// StateCorrupt stops processing until an operator acts
+	StateCorrupt = "corrupt"
+	// StateRecovering moves the database aside and rebuilds it
+	StateRecovering = "recovering"
+	// StateRecoveryFailed stops processing until an operator acts
+	StateRecoveryFailed = "recovery_failed"
+)
+
+// Kinds of the alerts
+const (
+	EventCorrupt        = "database_corrupt"
+	EventRecovered      = "database_recovered"
+	EventRecoveryFailed = "database_recovery_failed"
+)
+
+// auditOperation names the incidents in the audit log
+const auditOperation = "database-recovery"
+
+// beatInterval is how often the guard beats while it waits
+const beatInterval = time.Minute
+
+// Status is the state of the database and of its last incident
+type Status struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// Error is the corruption error of the last incident, or why its
+	// recovery failed
+	Error string `json:"error,omitempty"`
+	// Incidents counts the corruptions detected since startup
+	Incidents int `json:"incidents"`
+	// MovedTo is where the last recovery moved the corrupt database
+	MovedTo string `json:"moved_to,omitempty"`
+	// Rebuilt counts the records the last recovery rebuilt
+	Rebuilt int `json:"rebuilt,omitempty"`
+}
+
+// Event is the payload of an alert
+type Event struct {
+	Kind   string    `json:"kind"`
+	At     time.Time `json:"at"`
+	Path   string    `json:"path"`
+	Status Status    `json:"status"`
+}
+
+// Options configures a Guard
+type Options struct {
+	// Path is the state database file
+	Path string
+	// Alerts receives the alerts directly, since the outbox is in the
+	// corrupt database; nil only logs them
+	Alerts outbox.Sender
+	// Recover moves the corrupt database aside and rebuilds it; nil
+	// leaves processing stopped for an operator
+	Recover func(ctx context.Context) (Recovered, error)
+	// AuditLog is the JSON Lines file the incidents are recorded in
+	AuditLog string
+	// Redact passes the paths and errors of alerts through
+	Redact func(string) string
+}
+
+// Recovered is what a recovery did
+type Recovered struct {
+	MovedTo string
+	Rebuilt int
+}
+
+// Guard is the state machine of the database health
+type Guard struct {
+	opts Options
+	now  func() time.Time
+	// detected hands an incident to Run
+	detected chan error
+
+	mu     sync.Mutex
+	status Status
+	// pause stops processing; resume starts it again after a recovery
+	pause  func()
+	resume func()
+}
+
+// New returns a healthy guard
+func New(opts Options) *Guard {
+	if opts.Redact == nil {
+		opts.Redact = func(s string) string { return s }
+	}
+	g := &Guard{opts: opts, now: time.Now, detected: make(chan error, 1)}
+	g.status = Status{State: StateHealthy, Since: g.now()}
+	return g
+}
+
+// SetProcessing sets how processing is paused on an incident and resumed
+// after its recovery. The guard observes the storage before the processor
+// exists, so it is set once it does.
+func (g *Guard) SetProcessing(pause, resume func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pause, g.resume = pause, resume
+}
+
+// Observe reports a corruption error of the storage layer, as passed to
+// storage.OnCorrupt. The first of an incident pauses processing at once and
+// hands the incident to Run; the errors of the queries failing on after it
+// are ignored.
+func (g *Guard) Observe(err error) {
+	g.mu.Lock()
+	if g.status.State != StateHealthy {
+		g.mu.Unlock()
+		return
+	}
+	g.status = Status{State: StateCorrupt, Since: g.now(), Error: err.Error(), Incidents: g.status.Incidents + 1}
+	pause := g.pause
+	g.mu.Unlock()
+
+	if pause != nil {
+		pause()
+	}
+	select {
+	case g.detected <- err:
+	default:
+	}
+}
+
+// Status returns the state of the database
+func (g *Guard) Status() Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// Run handles the incidents Observe detects until ctx is done
+func (g *Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(beatInterval)
+	defer ticker.Stop()
+	for {
+		supervisor.Beat(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case err := <-g.detected:
+			g.handle(ctx, err)
+		}
+	}
+}
+
+// handle alerts on the incident of err and, when enabled, recovers from it
+func (g *Guard) handle(ctx context.Context, err error) {
+	g.audit("detect", err.Error())
+	g.alert(ctx, EventCorrupt)
+	if g.opts.Recover == nil {
+		slog.Error("state database corrupt, processing stopped until an operator restores it or restarts with -db-recovery",
+			"path", g.opts.Path, "error", err)
+		return
+	}
+	slog.Error("state database corrupt, processing stopped to move it aside and rebuild it from the manifests",
+		"path", g.opts.Path, "error", err)
+
+	g.setState(func(s *Status) { s.State = StateRecovering })
+	g.audit("recover", "")
+	res, err := g.opts.Recover(ctx)
+	if err != nil {
+		g.setState(func(s *Status) { s.State, s.Error, s.MovedTo = StateRecoveryFailed, err.Error(), res.MovedTo })
+		g.audit("recovery-failed", err.Error())
+		g.alert(ctx, EventRecoveryFailed)
+		slog.Error("state database recovery failed, processing stopped until an operator acts",
+			"path", g.opts.Path, "moved_to", res.MovedTo, "error", err)
+		return
+	}
+
+	g.setState(func(s *Status) {
+		s.State, s.Error, s.MovedTo, s.Rebuilt = StateHealthy, "", res.MovedTo, res.Rebuilt
+	})
+	g.audit("resume", fmt.Sprintf("moved to %s, %d records rebuilt", res.MovedTo, res.Rebuilt))
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume != nil {
+		resume()
+	}
+	g.alert(ctx, EventRecovered)
+	slog.Info("state database recovered, processing resumed", "path", g.opts.Path, "moved_to", res.MovedTo, "rebuilt", res.Rebuilt)
+}
+
+// setState updates the status, entering its state now
+func (g *Guard) setState(update func(*Status)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	update(&g.status)
+	g.status.Since = g.now()
+}
+
+// alert sends an alert of kind with the current status
+func (g *Guard) alert(ctx context.Context, kind string) {
+	if g.opts.Alerts == nil {
+		return
+	}
+	status := g.Status()
+	status.Error = g.opts.Redact(status.Error)
+	status.MovedTo = g.opts.Redact(status.MovedTo)
+	now := g.now().UTC()
+	payload, err := json.Marshal(Event{Kind: kind, At: now, Path: g.opts.Redact(g.opts.Path), Status: status})
+	if err != nil {
+		slog.Error("failed to encode database alert", "kind", kind, "error", err)
+		return
+	}
+	msg := storage.OutboxMessage{CreatedAt: now, Kind: kind, Payload: string(payload)}
+	if err := g.opts.Alerts.Send(ctx, msg); err != nil {
+		slog.Error("failed to send database alert", "kind", kind, "error", err)
+	}
+}
+
+// audit records a step of the incident
+func (g *Guard) audit(action, detail string) {
+	if g.opts.AuditLog == "" {
+		return
+	}
+	rec := maintenance.AuditRecord{
+		Time:       g.now(),
+		Operation:  auditOperation,
+		Invocation: maintenance.CurrentInvocation(),
+		Item:       maintenance.Item{Path: g.opts.Path, Action: action, Detail: detail},
+	}
+	if err := maintenance.AppendAudit(g.opts.AuditLog, rec); err != nil {
+		slog.Error("failed to audit database incident", "action", action, "audit_log", g.opts.AuditLog, "error", err)
+	}
+}
@@ -0,0 +1,262 @@
+package dbguard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var errMalformed = errors.New("database disk image is malformed")
+
+// sender records the alerts sent
+type sender struct {
+	mu    sync.Mutex
+	kinds []string
+}
+
+func (s *sender) Send(_ context.Context, msg storage.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kinds = append(s.kinds, msg.Kind)
+	return nil
+}
+
+func (s *sender) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.kinds)
+}
+
+// processing counts the pauses and resumes of processing
+type processing struct {
+	mu              sync.Mutex
+	pauses, resumes int
+}
+
+func (p *processing) pause()  { p.mu.Lock(); p.pauses++; p.mu.Unlock() }
+func (p *processing) resume() { p.mu.Lock(); p.resumes++; p.mu.Unlock() }
+
+func (p *processing) counts() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pauses, p.resumes
+}
+
+// run runs a guard of opts until the test ends
+func run(t *testing.T, opts Options) (*Guard, *sender, *processing) {
+	t.Helper()
+	alerts := &sender{}
+	opts.Alerts = alerts
+	opts.Path = "/state/state.db"
+	opts.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	g := New(opts)
+	proc := &processing{}
+	g.SetProcessing(proc.pause, proc.resume)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		g.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return g, alerts, proc
+}
+
+// waitState waits for the guard to enter state with n alerts sent
+func waitState(t *testing.T, g *Guard, alerts *sender, state string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for g.Status().State != state || len(alerts.get()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %+v with alerts %v, want %s with %d alerts", g.Status(), alerts.get(), state, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// auditActions returns the actions of the audit log of g
+func auditActions(t *testing.T, g *Guard) []string {
+	t.Helper()
+	f, err := os.Open(g.opts.AuditLog)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	var actions []string
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var rec maintenance.AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit record: %v", err)
+		}
+		if rec.Operation != auditOperation || rec.Path != "/state/state.db" {
+			t.Errorf("audit record = %+v, want one of the database", rec)
+		}
+		actions = append(actions, rec.Action)
+	}
+	return actions
+}
+
+func TestGuard_StopsAwaitingOperator(t *testing.T) {
+	g, alerts, proc := run(t, Options{})
+	if s := g.Status(); s.State != StateHealthy {
+		t.Fatalf("Status() = %+v, want healthy", s)
+	}
+
+	// Every query of every tick fails alike
+	for range 50 {
+		g.Observe(errMalformed)
+	}
+	waitState(t, g, alerts, StateCorrupt, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	if got := alerts.get(); !slices.Equal(got, []string{EventCorrupt}) {
+		t.Errorf("alerts = %v, want a single corruption alert", got)
+	}
+	if pauses, resumes := proc.counts(); pauses != 1 || resumes != 0 {
+		t.Errorf("paused %d and resumed %d times, want one pause", pauses, resumes)
+	}
+	if s := g.Status(); s.Incidents != 1 || s.Error != errMalformed.Error() {
+		t.Errorf("Status() = %+v, want one incident with its error", s)
+	}
+	if got := auditActions(t, g); !slices.Equal(got, []string{"detect"}) {
+		t.Errorf("audited %v, want the detection", got)
+	}
+}
+
+func TestGuard_Recovers(t *testing.T) {
+	recovered := Recovered{MovedTo: "/state/state.db.corrupt", Rebuilt: 3}
+	g, alerts, proc := run(t, Options{Recover: func(context.Context) (Recovered, error) { return recovered, nil }})
+
+	g.Observe(errMalformed)
+	g.Observe(errMalformed)
+	waitState(t, g, alerts, StateHealthy, 2)
+
+	if got := alerts.get(); !slices.Equal(got, []string{EventCorrupt, EventRecovered}) {
+		t.Errorf("alerts = %v, want the corruption and the recovery", got)
+	}
+	if pauses, resumes := proc.counts(); pauses != 1 || resumes != 1 {
+		t.Errorf("paused %d and resumed %d times, want once each", pauses, resumes)
+	}
+	if s := g.Status(); s.MovedTo != recovered.MovedTo || s.Rebuilt != 3 || s.Error != "" {
+		t.Errorf("Status() = %+v, want the recovery", s)
+	}
+	if got := auditActions(t, g); !slices.Equal(got, []string{"detect", "recover", "resume"}) {
+		t.Errorf("audited %v, want the whole incident", got)
+	}
+
+	// A later incident is detected again
+	g.Observe(errMalformed)
+	waitState(t, g, alerts, StateHealthy, 4)
+	if s := g.Status(); s.Incidents != 2 {
+		t.Errorf("Status() = %+v, want a second incident", s)
+	}
+}
+
+func TestGuard_RecoveryFails(t *testing.T) {
+	g, alerts, proc := run(t, Options{Recover: func(context.Context) (Recovered, error) {
+		return Recovered{}, ErrNoManifests
+	}})
+
+	g.Observe(errMalformed)
+	waitState(t, g, alerts, StateRecoveryFailed, 2)
+	g.Observe(errMalformed)
+	time.Sleep(20 * time.Millisecond)
+
+	if got := alerts.get(); !slices.Equal(got, []string{EventCorrupt, EventRecoveryFailed}) {
+		t.Errorf("alerts = %v, want the corruption and the failed recovery", got)
+	}
+	if pauses, resumes := proc.counts(); pauses != 1 || resumes != 0 {
+		t.Errorf("paused %d and resumed %d times, want processing left stopped", pauses, resumes)
+	}
+	if s := g.Status(); s.Error != ErrNoManifests.Error() || s.Incidents != 1 {
+		t.Errorf("Status() = %+v, want the recovery error", s)
+	}
+	if got := auditActions(t, g); !slices.Equal(got, []string{"detect", "recover", "recovery-failed"}) {
+		t.Errorf("audited %v, want the failed recovery", got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.db")
+	db, err := gorm.Open(sqlite.Open(storage.DSN(path)), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	manifests := filepath.Join(dir, "manifests")
+	r := Recovery{Store: store, Path: path, ManifestsPath: manifests}
+	if _, err := r.Run(context.Background()); !errors.Is(err, ErrNoManifests) {
+		t.Fatalf("Run() error = %v, want ErrNoManifests", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database without manifests moved aside: %v", err)
+	}
+
+	at := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	entries := []manifest.Entry{
+		{SHA256: "a", Name: "a.csv", SourcePath: "/in/a.csv", DestPath: "/wh/a.csv", ProcessedAt: at, Status: status.Ingested},
+		{SHA256: "b", Name: "b.csv", SourcePath: "/in/b.csv", DestPath: "/wh/b.csv", ProcessedAt: at},
+		{SHA256: "a", Name: "copy.csv", SourcePath: "/in/copy.csv", ProcessedAt: at, Status: status.Duplicate},
+		{SHA256: "q", Name: "bad.csv", SourcePath: "/in/bad.csv", ProcessedAt: at, Status: status.Quarantined},
+	}
+	hour := filepath.Join(manifests, "2024", "06", "10", "12")
+	if err := os.MkdirAll(hour, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var lines []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(hour, "manifest.jsonl"), lines, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if res.Rebuilt != 2 {
+		t.Errorf("rebuilt %d records, want the 2 ingested files", res.Rebuilt)
+	}
+	if _, err := os.Stat(res.MovedTo); err != nil {
+		t.Errorf("database not moved to %s: %v", res.MovedTo, err)
+	}
+	for sha, want := range map[string]bool{"a": true, "b": true, "q": false} {
+		if exists, err := store.FileExistsSince(sha, time.Time{}); err != nil || exists != want {
+			t.Errorf("FileExistsSince(%s) = %v, %v, want %v", sha, exists, err, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package dbguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// ErrNoManifests is returned by a recovery finding no manifest files to
+// rebuild the database from; the corrupt database is left in place
+var ErrNoManifests = errors.New("no manifest files to rebuild from")
+
+// manifestSuffixes are the manifest files rebuilt from: JSON Lines, parquet
+// and the write-ahead companions of unfinalized parquet periods
+var manifestSuffixes = []string{".jsonl", ".parquet", ".parquet.wal"}
+
+// Recovery moves a corrupt state database aside and rebuilds the records of
+// the ingested files from the manifest files, so that their content is not
+// ingested again. The history the manifests do not carry, such as attempts
+// and the outbox, starts over.
+type Recovery struct {
+	Store *storage.Storage
+	// Path is the state database file
+	Path          string
+	ManifestsPath string
+}
+
+// Run implements Options.Recover. The database is moved to
+// <path>.corrupt-<time>.
+func (r Recovery) Run(ctx context.Context) (Recovered, error) {
+	files, err := manifestFiles(r.ManifestsPath)
+	if err != nil {
+		return Recovered{}, err
+	}
+	if len(files) == 0 {
+		return Recovered{}, fmt.Errorf("%w in %s", ErrNoManifests, r.ManifestsPath)
+	}
+
+	res := Recovered{MovedTo: fmt.Sprintf("%s.corrupt-%s", r.Path, time.Now().UTC().Format("20060102T150405Z"))}
+	if err := r.Store.MoveAside(r.Path, res.MovedTo); err != nil {
+		return res, err
+	}
+	res.Rebuilt, err = Rebuild(ctx, r.Store, files)
+	return res, err
+}
+
+// manifestFiles lists the manifest files below root in path order, which
+// is the order of their periods for the default layouts
+func manifestFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && hasSuffix(path, manifestSuffixes) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list manifests in %s: %w", root, err)
+	}
+	return files, nil
+}
+
+func hasSuffix(path string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(path, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuild records the files the ingested entries of the manifest files
+// name, the first entry of each content winning, and returns how many
+// records it created
+func Rebuild(ctx context.Context, store *storage.Storage, files []string) (int, error) {
+	rebuilt := 0
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return rebuilt, err
+		}
+		entries, err := manifest.ReadFile(path)
+		if err != nil {
+			return rebuilt, fmt.Errorf("read manifest %s: %w", path, err)
+		}
+		for _, e := range entries {
+			if (e.Status != "" && e.Status != status.Ingested) || e.SelfTest {
+				continue
+			}
+			created, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+				SHA256:         e.SHA256,
+				Name:           e.Name,
+				OriginalName:   e.OriginalName,
+				Path:           e.SourcePath,
+				Size:           e.Size,
+				Status:         status.Ingested,
+				DestPath:       e.DestPath,
+				ProcessedAt:    e.ProcessedAt,
+				Tags:           e.Tags,
+				RelPath:        e.SourceRelPath,
+				Version:        e.Version,
+				PreviousSHA256: e.PreviousSHA256,
+				IdempotencyKey: e.IdempotencyKey,
+				AllocatedSize:  e.AllocatedSize,
+				Sequence:       e.Sequence,
+				CreatedAt:      e.ProcessedAt,
+			})
+			if err != nil {
+				return rebuilt, fmt.Errorf("rebuild record of %s: %w", e.SHA256, err)
+			}
+			if created {
+				rebuilt++
+			}
+		}
+	}
+	return rebuilt, nil
+}
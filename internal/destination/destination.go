@@ -0,0 +1,27 @@
+package destination
+
+import (
+	"context"
+	"io"
+)
+
+// Destination is a key/value object store the warehouse can be written to.
+// Keys are slash-separated regardless of the backend.
+type Destination interface {
+	// Put stores the content of r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the content of the object at key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Copy duplicates the object at src to dst server-side
+	Copy(ctx context.Context, src, dst string) error
+	// Rename moves the object at src to dst. Backends without a native
+	// rename implement it as Copy followed by Delete.
+	Rename(ctx context.Context, src, dst string) error
+	// Delete removes the object at key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// ListPrefix returns every key that starts with prefix, sorted
+	ListPrefix(ctx context.Context, prefix string) ([]string, error)
+	// Ping checks cheaply that the backend accepts writes, such as a
+	// statfs and a probe file for a directory
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,121 @@
+package destination
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Health probe defaults
+const (
+	// DefaultHealthCache is how long a successful probe is trusted
+	DefaultHealthCache = 5 * time.Second
+	// DefaultHealthMinBackoff and DefaultHealthMaxBackoff bound the delay
+	// between the probes of an unhealthy destination, doubling from the
+	// first to the second on every failure
+	DefaultHealthMinBackoff = time.Second
+	DefaultHealthMaxBackoff = 30 * time.Second
+)
+
+// HealthOptions tune a Health. Zero fields take the defaults.
+type HealthOptions struct {
+	Cache      time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// HealthStatus is a snapshot of a Health
+type HealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// Since is when the destination entered its current state, zero
+	// before the first probe
+	Since     time.Time `json:"since,omitzero"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	// Error is the failure of the last probe while unhealthy
+	Error string `json:"error,omitempty"`
+	// Failures counts the consecutive failed probes
+	Failures int `json:"failures"`
+	// NextProbe is when Check pings the destination again
+	NextProbe time.Time `json:"next_probe,omitzero"`
+	Probes    int64     `json:"probes"`
+}
+
+// Health probes a destination with Ping on behalf of its callers. The
+// result of a probe is reused until it expires, Cache after a success and
+// after an exponential backoff after a failure, so frequent callers, such
+// as every tick of the processor, do not hammer a remote endpoint. A
+// destination is healthy until a probe fails.
+type Health struct {
+	dest Destination
+	opts HealthOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	err     error
+	backoff time.Duration
+	status  HealthStatus
+}
+
+// NewHealth creates a Health probing dest
+func NewHealth(dest Destination, opts HealthOptions) *Health {
+	if opts.Cache <= 0 {
+		opts.Cache = DefaultHealthCache
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultHealthMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultHealthMaxBackoff
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.MinBackoff)
+	return &Health{dest: dest, opts: opts, now: time.Now, status: HealthStatus{Healthy: true}}
+}
+
+// Check returns the error of the latest probe, pinging the destination
+// first when that result expired. Concurrent callers share one probe.
+func (h *Health) Check(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	if now.Before(h.status.NextProbe) {
+		return h.err
+	}
+
+	err := h.dest.Ping(ctx)
+	now = h.now()
+	h.err = err
+	h.status.Probes++
+	h.status.CheckedAt = now
+	if err == nil {
+		if !h.status.Healthy || h.status.Since.IsZero() {
+			h.status.Since = now
+		}
+		h.status.Healthy = true
+		h.status.Error = ""
+		h.status.Failures = 0
+		h.backoff = 0
+		h.status.NextProbe = now.Add(h.opts.Cache)
+		return nil
+	}
+
+	if h.status.Healthy || h.status.Since.IsZero() {
+		h.status.Since = now
+	}
+	h.status.Healthy = false
+	h.status.Error = err.Error()
+	h.status.Failures++
+	if h.backoff == 0 {
+		h.backoff = h.opts.MinBackoff
+	} else {
+		h.backoff = min(2*h.backoff, h.opts.MaxBackoff)
+	}
+	h.status.NextProbe = now.Add(h.backoff)
+	return err
+}
+
+// Status returns the state the latest probe left
+func (h *Health) Status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
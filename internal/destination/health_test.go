@@ -0,0 +1,86 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose sleeps advance it at once
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestHealth_CachesAndBacksOff(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	dest := NewMemory()
+	h := NewHealth(dest, HealthOptions{Cache: 5 * time.Second, MinBackoff: time.Second, MaxBackoff: 4 * time.Second})
+	h.now = clock.Now
+
+	if err := h.Check(ctx); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	_ = clock.Sleep(ctx, 4*time.Second)
+	if err := h.Check(ctx); err != nil || dest.Pings() != 1 {
+		t.Fatalf("Check() = %v after %d pings, want the cached success", err, dest.Pings())
+	}
+
+	down := errors.New("connection refused")
+	dest.SetPingError(down)
+	_ = clock.Sleep(ctx, time.Second)
+	if err := h.Check(ctx); !errors.Is(err, down) {
+		t.Fatalf("Check() error = %v, want the probe failure once the cache expired", err)
+	}
+	since := clock.Now()
+
+	// Probes of an unhealthy destination back off: 1s, 2s, then 4s at most
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		pings := dest.Pings()
+		_ = clock.Sleep(ctx, wait-time.Millisecond)
+		if err := h.Check(ctx); !errors.Is(err, down) || dest.Pings() != pings {
+			t.Fatalf("Check() = %v with %d pings, want the cached failure within the %v backoff", err, dest.Pings(), wait)
+		}
+		_ = clock.Sleep(ctx, time.Millisecond)
+		if err := h.Check(ctx); !errors.Is(err, down) || dest.Pings() != pings+1 {
+			t.Fatalf("Check() = %v with %d pings, want a probe after the %v backoff", err, dest.Pings(), wait)
+		}
+	}
+	status := h.Status()
+	if status.Healthy || status.Failures != 5 || status.Error != down.Error() || !status.Since.Equal(since) {
+		t.Errorf("Status() = %+v, want 5 failures since %v", status, since)
+	}
+
+	dest.SetPingError(nil)
+	_ = clock.Sleep(ctx, 4*time.Second)
+	if err := h.Check(ctx); err != nil {
+		t.Fatalf("Check() error = %v, want the recovery", err)
+	}
+	if status := h.Status(); !status.Healthy || status.Failures != 0 || status.Error != "" || !status.Since.Equal(clock.Now()) {
+		t.Errorf("Status() = %+v, want healthy since the recovery", status)
+	}
+
+	// The backoff starts over on the next failure
+	dest.SetPingError(down)
+	_ = clock.Sleep(ctx, 5*time.Second)
+	_ = h.Check(ctx)
+	if next := h.Status().NextProbe; !next.Equal(clock.Now().Add(time.Second)) {
+		t.Errorf("next probe at %v, want the minimum backoff", next)
+	}
+}
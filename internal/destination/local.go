@@ -0,0 +1,175 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// Local is a Destination backed by a directory on the local filesystem
+type Local struct {
+	root string
+}
+
+// NewLocal creates a Destination rooted at dir
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file and renames it into place so readers never
+// see a partial object
+func (l *Local) Put(_ context.Context, key string, r io.Reader) (err error) {
+	dst := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("create directory for %s: %w", key, err)
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", key, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", key, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", key, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("rename %s: %w", key, err)
+	}
+	return nil
+}
+
+// Open opens the file of key
+func (l *Local) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// Copy duplicates src to dst, hard-linking when possible
+func (l *Local) Copy(_ context.Context, src, dst string) error {
+	dstPath := l.path(dst)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return fmt.Errorf("create directory for %s: %w", dst, err)
+	}
+	// Both are warehouse objects, so sharing their inode exposes nothing
+	opts := fileops.DefaultCopyOptions()
+	opts.AllowHardlink = true
+	if _, err := fileops.CopyFileWithOptions(l.path(src), dstPath, opts); err != nil {
+		return fmt.Errorf("copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// Rename moves src to dst
+func (l *Local) Rename(_ context.Context, src, dst string) error {
+	dstPath := l.path(dst)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return fmt.Errorf("create directory for %s: %w", dst, err)
+	}
+	if err := fileops.MoveFile(l.path(src), dstPath); err != nil {
+		return fmt.Errorf("rename %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// Delete removes key
+func (l *Local) Delete(_ context.Context, key string) error {
+	if err := os.Remove(l.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// ListPrefix returns the keys starting with prefix. Only the directory named
+// by the prefix is walked, so listing a staging area stays cheap in a large
+// warehouse.
+func (l *Local) ListPrefix(ctx context.Context, prefix string) ([]string, error) {
+	start := l.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = l.path(prefix[:i])
+	}
+
+	keys := make([]string, 0)
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == start {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list prefix %s: %w", prefix, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Ping checks that the root is a reachable filesystem and writes, syncs and
+// removes a hidden probe file in it
+func (l *Local) Ping(ctx context.Context) error {
+	if _, err := fileops.StatDisk(l.root); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(l.root, ".ping-*")
+	if err != nil {
+		return fmt.Errorf("create probe file: %w", err)
+	}
+	defer func() { _ = os.Remove(probe.Name()) }()
+	_, err = probe.WriteString("ping\n")
+	if err == nil {
+		err = probe.Sync()
+	}
+	if cerr := probe.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write probe file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package destination
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocal_Operations(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	l := NewLocal(root)
+
+	if err := l.Put(ctx, "_staging/id/sub/a.csv", strings.NewReader("data")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := l.Put(ctx, "other/b.csv", strings.NewReader("other")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	keys, err := l.ListPrefix(ctx, StagingPrefix)
+	if err != nil {
+		t.Fatalf("ListPrefix() error = %v", err)
+	}
+	if want := []string{"_staging/id/sub/a.csv"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("ListPrefix() = %v, want %v", keys, want)
+	}
+
+	if err := l.Copy(ctx, "_staging/id/sub/a.csv", "sub/a.csv"); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if err := l.Rename(ctx, "other/b.csv", "moved/b.csv"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if err := l.Delete(ctx, "_staging/id/sub/a.csv"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := l.Delete(ctx, "_staging/id/sub/a.csv"); err != nil {
+		t.Errorf("Delete() of missing key error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "sub", "a.csv"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("copied object = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "other", "b.csv")); !os.IsNotExist(err) {
+		t.Errorf("renamed source still exists: %v", err)
+	}
+
+	keys, err = l.ListPrefix(ctx, StagingPrefix)
+	if err != nil {
+		t.Fatalf("ListPrefix() error = %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("ListPrefix() after delete = %v, want empty", keys)
+	}
+}
+
+func TestLocal_ListPrefixMissingRoot(t *testing.T) {
+	l := NewLocal(filepath.Join(t.TempDir(), "missing"))
+	keys, err := l.ListPrefix(context.Background(), StagingPrefix)
+	if err != nil {
+		t.Fatalf("ListPrefix() error = %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("ListPrefix() = %v, want empty", keys)
+	}
+}
+
+func TestLocal_Ping(t *testing.T) {
+	root := t.TempDir()
+	if err := NewLocal(root).Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("root has %d entries, %v, want the probe file removed", len(entries), err)
+	}
+	if err := NewLocal(filepath.Join(root, "missing")).Ping(context.Background()); err == nil {
+		t.Error("Ping() of a missing root succeeded")
+	}
+}
@@ -0,0 +1,141 @@
+package destination
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MarkerName is the file at the warehouse root that tells whoever finds the
+// directory what writes to it and where its records live. It is not an
+// ingested file: walks of the warehouse skip it.
+const MarkerName = "_INGESTOR.json"
+
+// ErrMarkerConflict is matched by the error of a marker left by a
+// configuration other than the running one
+var ErrMarkerConflict = errors.New("warehouse owned by another configuration")
+
+// Marker is the content of the marker file
+type Marker struct {
+	Tool    string `json:"tool"`
+	Version string `json:"version"`
+	// Instance is the host the ingestor runs on, the {instance} of path
+	// templates
+	Instance string       `json:"instance"`
+	Layout   MarkerLayout `json:"layout"`
+	// Manifests is the absolute manifests directory
+	Manifests    string `json:"manifests"`
+	ManifestSink string `json:"manifest_sink"`
+	// State locates the state database, without the options of its DSN
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// MarkerLayout is the warehouse path template, and those of the sources
+// configuring their own
+type MarkerLayout struct {
+	Warehouse string            `json:"warehouse"`
+	Sources   map[string]string `json:"sources,omitempty"`
+}
+
+// MarkerConflictError lists what differs between the configuration that
+// wrote a marker and the running one
+type MarkerConflictError struct {
+	Path   string
+	Fields []string
+}
+
+func (e *MarkerConflictError) Error() string {
+	return fmt.Sprintf("%s: %s: %v differ", e.Path, ErrMarkerConflict, e.Fields)
+}
+
+func (e *MarkerConflictError) Is(target error) bool { return target == ErrMarkerConflict }
+
+// ReadMarker returns the marker of the warehouse at root, nil when it has
+// none
+func ReadMarker(root string) (*Marker, error) {
+	path := filepath.Join(root, MarkerName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read warehouse marker: %w", err)
+	}
+	var m Marker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse warehouse marker %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// CheckMarker returns a MarkerConflictError when the marker of the
+// warehouse at root was written with another layout or manifests directory
+// than current, as by a second ingestor configured onto the same warehouse
+func CheckMarker(root string, current Marker) error {
+	existing, err := ReadMarker(root)
+	if err != nil || existing == nil {
+		return err
+	}
+	var fields []string
+	if !sameLayout(existing.Layout, current.Layout) {
+		fields = append(fields, "layout")
+	}
+	if existing.Manifests != current.Manifests {
+		fields = append(fields, "manifests")
+	}
+	if len(fields) > 0 {
+		return &MarkerConflictError{Path: filepath.Join(root, MarkerName), Fields: fields}
+	}
+	return nil
+}
+
+// sameLayout reports whether a and b place files alike
+func sameLayout(a, b MarkerLayout) bool {
+	if a.Warehouse != b.Warehouse || len(a.Sources) != len(b.Sources) {
+		return false
+	}
+	for source, t := range a.Sources {
+		if other, ok := b.Sources[source]; !ok || other != t {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteMarker replaces the marker of the warehouse at root with m. It is
+// written to a temporary file, synced and renamed in place, so readers
+// never see a partial marker.
+func WriteMarker(root string, m Marker) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal warehouse marker: %w", err)
+	}
+	f, err := os.CreateTemp(root, "."+MarkerName+"-*")
+	if err != nil {
+		return fmt.Errorf("create warehouse marker: %w", err)
+	}
+	tmp := f.Name()
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(root, MarkerName))
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write warehouse marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package destination
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteMarker_RoundTrip(t *testing.T) {
+	root := t.TempDir()
+	if m, err := ReadMarker(root); m != nil || err != nil {
+		t.Fatalf("ReadMarker() of an unmarked warehouse = %+v, %v, want nil", m, err)
+	}
+
+	want := Marker{
+		Tool:         "atomic-ingestor",
+		Version:      "v1.2.3",
+		Instance:     "host",
+		Layout:       MarkerLayout{Warehouse: "{rel_path}", Sources: map[string]string{"acme": "{yyyy}/{name}"}},
+		Manifests:    "/srv/manifests",
+		ManifestSink: "file",
+		State:        "/srv/state.db",
+		StartedAt:    time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC),
+	}
+	if err := WriteMarker(root, want); err != nil {
+		t.Fatalf("WriteMarker() error = %v", err)
+	}
+	want.Version = "v1.2.4"
+	if err := WriteMarker(root, want); err != nil {
+		t.Fatalf("WriteMarker() over a marker error = %v", err)
+	}
+	got, err := ReadMarker(root)
+	if err != nil || got == nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("ReadMarker() = %+v, %v, want %+v", got, err, want)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != MarkerName {
+		t.Errorf("warehouse holds %v, want only the marker", entries)
+	}
+	if info, err := entries[0].Info(); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("marker mode = %v, %v, want 0644", info.Mode(), err)
+	}
+}
+
+func TestCheckMarker(t *testing.T) {
+	root := t.TempDir()
+	owner := Marker{
+		Layout:    MarkerLayout{Warehouse: "{rel_path}"},
+		Manifests: "/srv/manifests",
+		StartedAt: time.Now(),
+	}
+	if err := CheckMarker(root, owner); err != nil {
+		t.Errorf("CheckMarker() of an unmarked warehouse error = %v", err)
+	}
+	if err := WriteMarker(root, owner); err != nil {
+		t.Fatalf("WriteMarker() error = %v", err)
+	}
+
+	restarted := owner
+	restarted.Version, restarted.StartedAt, restarted.Instance = "v2", time.Now().Add(time.Hour), "other-host"
+	if err := CheckMarker(root, restarted); err != nil {
+		t.Errorf("CheckMarker() of the same configuration restarted error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		edit   func(*Marker)
+		fields []string
+	}{
+		{"manifests", func(m *Marker) { m.Manifests = "/srv/other" }, []string{"manifests"}},
+		{"layout", func(m *Marker) { m.Layout.Warehouse = "{yyyy}/{rel_path}" }, []string{"layout"}},
+		{"source layout", func(m *Marker) { m.Layout.Sources = map[string]string{"acme": "{name}"} }, []string{"layout"}},
+		{"both", func(m *Marker) { m.Manifests = "/srv/other"; m.Layout.Warehouse = "{name}" }, []string{"layout", "manifests"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := owner
+			tt.edit(&other)
+			err := CheckMarker(root, other)
+			var conflict *MarkerConflictError
+			if !errors.Is(err, ErrMarkerConflict) || !errors.As(err, &conflict) {
+				t.Fatalf("CheckMarker() error = %v, want ErrMarkerConflict", err)
+			}
+			if !reflect.DeepEqual(conflict.Fields, tt.fields) || conflict.Path != filepath.Join(root, MarkerName) {
+				t.Errorf("conflict = %+v, want fields %v", conflict, tt.fields)
+			}
+		})
+	}
+
+	if err := os.WriteFile(filepath.Join(root, MarkerName), []byte("not json"), 0o644); err != nil {
+		t.Fatalf("failed to corrupt marker: %v", err)
+	}
+	if err := CheckMarker(root, owner); err == nil || errors.Is(err, ErrMarkerConflict) {
+		t.Errorf("CheckMarker() of an unreadable marker error = %v, want a parse error", err)
+	}
+}
@@ -0,0 +1,121 @@
+package destination
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is an in-memory Destination for tests. Like an object store it has
+// no native rename, so Rename is Copy followed by Delete.
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	pingErr error
+	pings   int
+}
+
+// NewMemory creates an empty in-memory Destination
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string][]byte)}
+}
+
+// Put stores the content of r under key
+func (m *Memory) Put(_ context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read content for %s: %w", key, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+// Open returns a reader over the object at key
+func (m *Memory) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(data))), nil
+}
+
+// Copy duplicates src to dst
+func (m *Memory) Copy(_ context.Context, src, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[src]
+	if !ok {
+		return fmt.Errorf("copy %s: %w", src, fs.ErrNotExist)
+	}
+	m.objects[dst] = bytes.Clone(data)
+	return nil
+}
+
+// Rename copies src to dst and deletes src
+func (m *Memory) Rename(ctx context.Context, src, dst string) error {
+	if err := m.Copy(ctx, src, dst); err != nil {
+		return err
+	}
+	return m.Delete(ctx, src)
+}
+
+// Delete removes key
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// ListPrefix returns the keys starting with prefix
+func (m *Memory) ListPrefix(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0)
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get returns the content stored under key
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	return bytes.Clone(data), ok
+}
+
+// Ping returns the error set by SetPingError
+func (m *Memory) Ping(context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pings++
+	return m.pingErr
+}
+
+// SetPingError makes Ping fail with err, or succeed again when err is nil
+func (m *Memory) SetPingError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pingErr = err
+}
+
+// Pings returns how many times Ping was called
+func (m *Memory) Pings() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pings
+}
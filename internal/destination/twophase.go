@@ -0,0 +1,172 @@
+package destination
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// StagingPrefix is where objects are uploaded before they are committed.
+// Downstream readers must ignore keys under it.
+const StagingPrefix = "_staging/"
+
+// ScratchPrefix is where working copies of sources are kept while they are
+// ingested, beside the staging area so they move into place by rename.
+// Unlike staging objects they are never promoted: whatever a crash leaves
+// there is deleted on the next start. Downstream readers must ignore keys
+// under it.
+const ScratchPrefix = "_scratch/"
+
+// CommittedFunc returns the content hash recorded for the final key, with ok
+// false when there is no record. Recovery uses it to decide whether an
+// orphaned staging object is completed or deleted.
+type CommittedFunc func(key string) (sha256 string, ok bool, err error)
+
+// RecoveryResult summarizes a Recover run
+type RecoveryResult struct {
+	Completed int
+	Deleted   int
+	// Mismatched counts the staging objects left in place because their
+	// content is not the one recorded for their final key
+	Mismatched int
+}
+
+// TwoPhase resolves the objects of uploads that follow the two-phase
+// convention, so that nothing appears at its final key until it is fully
+// uploaded and recorded:
+//
+//  1. upload to StagingKey(id, key)
+//  2. commit the record
+//  3. move the staging object to <key>
+//
+// A crash at any point leaves at most a staging object behind, which Recover
+// resolves on the next start. Batches and batch tarballs are written this
+// way, as all their members must appear together; single files are claimed
+// first and renamed into place from a temporary file instead, and never
+// staged.
+type TwoPhase struct {
+	dest Destination
+}
+
+// NewTwoPhase creates a TwoPhase for the staging objects of dest
+func NewTwoPhase(dest Destination) *TwoPhase {
+	return &TwoPhase{dest: dest}
+}
+
+// StagingKey returns the staging key for an upload of key with the given id
+func StagingKey(id, key string) string {
+	return StagingPrefix + id + "/" + key
+}
+
+// parseStagingKey returns the final key encoded in a staging key
+func parseStagingKey(staged string) (string, bool) {
+	rest, ok := strings.CutPrefix(staged, StagingPrefix)
+	if !ok {
+		return "", false
+	}
+	_, key, ok := strings.Cut(rest, "/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// Recover resolves staging objects left behind by a crash. Objects whose
+// final key has a record are completed when they hold the recorded content
+// and left for an operator when they do not; the rest are deleted.
+func (t *TwoPhase) Recover(ctx context.Context, committed CommittedFunc) (RecoveryResult, error) {
+	var res RecoveryResult
+
+	staged, err := t.dest.ListPrefix(ctx, StagingPrefix)
+	if err != nil {
+		return res, fmt.Errorf("list staging objects: %w", err)
+	}
+
+	for _, s := range staged {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		key, ok := parseStagingKey(s)
+		if !ok {
+			slog.Warn("ignoring malformed staging key", "key", s)
+			continue
+		}
+
+		sum, ok, err := committed(key)
+		if err != nil {
+			return res, fmt.Errorf("check record for %s: %w", key, err)
+		}
+
+		if ok {
+			got, err := t.hash(ctx, s)
+			if err != nil {
+				return res, err
+			}
+			if got != sum {
+				slog.Error("staging object differs from its record, leaving it in place",
+					"staging", s,
+					"key", key,
+					"sha256", got,
+					"recorded_sha256", sum,
+				)
+				res.Mismatched++
+				continue
+			}
+			if err := t.promote(ctx, s, key); err != nil {
+				return res, err
+			}
+			slog.Info("completed orphaned staging object", "staging", s, "key", key)
+			res.Completed++
+			continue
+		}
+
+		if err := t.dest.Delete(ctx, s); err != nil {
+			return res, fmt.Errorf("delete staging object %s: %w", s, err)
+		}
+		slog.Info("deleted uncommitted staging object", "staging", s, "key", key)
+		res.Deleted++
+	}
+
+	return res, nil
+}
+
+// hash returns the SHA-256 of the staging object at staged
+func (t *TwoPhase) hash(ctx context.Context, staged string) (string, error) {
+	r, err := t.dest.Open(ctx, staged)
+	if err != nil {
+		return "", fmt.Errorf("read staging object: %w", err)
+	}
+	defer func() { _ = r.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("hash staging object %s: %w", staged, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// promote makes a committed staging object visible at its final key. Copy
+// is idempotent, so repeating a promote interrupted after the copy is safe.
+func (t *TwoPhase) promote(ctx context.Context, staged, key string) error {
+	if err := t.dest.Copy(ctx, staged, key); err != nil {
+		return fmt.Errorf("copy %s to %s: %w", staged, key, err)
+	}
+	if err := t.dest.Delete(ctx, staged); err != nil {
+		return fmt.Errorf("delete staging object %s: %w", staged, err)
+	}
+	return nil
+}
+
+// NewUploadID returns a random id for a staging upload
+func NewUploadID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate upload id: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,185 @@
+package destination
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// records is a fake state database keyed by final object key, holding the
+// content hash of each record
+type records struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newRecords() *records {
+	return &records{keys: make(map[string]string)}
+}
+
+func (r *records) commit(key, content string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sum := sha256.Sum256([]byte(content))
+	r.keys[key] = hex.EncodeToString(sum[:])
+	return nil
+}
+
+func (r *records) committed(key string) (string, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sum, ok := r.keys[key]
+	return sum, ok, nil
+}
+
+func assertVisible(t *testing.T, mem *Memory, key, want string) {
+	t.Helper()
+	data, ok := mem.Get(key)
+	if !ok {
+		t.Fatalf("object %s not visible", key)
+	}
+	if string(data) != want {
+		t.Errorf("object %s = %q, want %q", key, data, want)
+	}
+}
+
+func assertNoStaging(t *testing.T, mem *Memory) {
+	t.Helper()
+	staged, err := mem.ListPrefix(context.Background(), StagingPrefix)
+	if err != nil {
+		t.Fatalf("ListPrefix() error = %v", err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("staging objects left behind: %v", staged)
+	}
+}
+
+func TestTwoPhase_RecoverAfterCrash(t *testing.T) {
+	tests := []struct {
+		name string
+		// crash simulates the process dying at a phase boundary
+		crash func(ctx context.Context, mem *Memory, db *records) error
+		// visible reports whether the object must be at its final key after recovery
+		visible bool
+	}{
+		{
+			name: "after upload, before commit",
+			crash: func(ctx context.Context, mem *Memory, _ *records) error {
+				return mem.Put(ctx, StagingKey("dead", "a.csv"), strings.NewReader("data"))
+			},
+			visible: false,
+		},
+		{
+			name: "after commit, before copy",
+			crash: func(ctx context.Context, mem *Memory, db *records) error {
+				if err := mem.Put(ctx, StagingKey("dead", "a.csv"), strings.NewReader("data")); err != nil {
+					return err
+				}
+				return db.commit("a.csv", "data")
+			},
+			visible: true,
+		},
+		{
+			name: "after copy, before staging delete",
+			crash: func(ctx context.Context, mem *Memory, db *records) error {
+				if err := mem.Put(ctx, StagingKey("dead", "a.csv"), strings.NewReader("data")); err != nil {
+					return err
+				}
+				if err := db.commit("a.csv", "data"); err != nil {
+					return err
+				}
+				return mem.Copy(ctx, StagingKey("dead", "a.csv"), "a.csv")
+			},
+			visible: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mem := NewMemory()
+			db := newRecords()
+
+			_ = tt.crash(ctx, mem, db)
+
+			res, err := NewTwoPhase(mem).Recover(ctx, db.committed)
+			if err != nil {
+				t.Fatalf("Recover() error = %v", err)
+			}
+
+			assertNoStaging(t, mem)
+			if tt.visible {
+				assertVisible(t, mem, "a.csv", "data")
+				if res.Completed != 1 {
+					t.Errorf("Completed = %d, want 1", res.Completed)
+				}
+			} else {
+				if _, ok := mem.Get("a.csv"); ok {
+					t.Error("uncommitted object became visible")
+				}
+				if res.Deleted != 1 {
+					t.Errorf("Deleted = %d, want 1", res.Deleted)
+				}
+			}
+
+			// Recovery is idempotent
+			res, err = NewTwoPhase(mem).Recover(ctx, db.committed)
+			if err != nil {
+				t.Fatalf("second Recover() error = %v", err)
+			}
+			if res.Completed != 0 || res.Deleted != 0 {
+				t.Errorf("second Recover() = %+v, want no work", res)
+			}
+		})
+	}
+}
+
+func TestTwoPhase_RecoverKeepsMismatchedObject(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemory()
+	db := newRecords()
+
+	// A staging object committed under a key recorded with other content, as
+	// a working copy sharing the staging area would be
+	staged := StagingKey("dead", "a.csv")
+	if err := mem.Put(ctx, staged, strings.NewReader("partial")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := db.commit("a.csv", "data"); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+
+	res, err := NewTwoPhase(mem).Recover(ctx, db.committed)
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if res.Mismatched != 1 || res.Completed != 0 || res.Deleted != 0 {
+		t.Errorf("Recover() = %+v, want the object mismatched", res)
+	}
+	if _, ok := mem.Get("a.csv"); ok {
+		t.Error("mismatched object promoted to its final key")
+	}
+	assertVisible(t, mem, staged, "partial")
+}
+
+func TestParseStagingKey(t *testing.T) {
+	tests := []struct {
+		staged string
+		want   string
+		ok     bool
+	}{
+		{StagingKey("id", "a/b.csv"), "a/b.csv", true},
+		{"_staging/id", "", false},
+		{"_staging/id/", "", false},
+		{"other/id/a.csv", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseStagingKey(tt.staged)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseStagingKey(%q) = %q, %v; want %q, %v", tt.staged, got, ok, tt.want, tt.ok)
+		}
+	}
+}
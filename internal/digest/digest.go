@@ -0,0 +1,168 @@
+// Package digest reports which originals and sources the duplicates of a
+// period came from, compared with the period before it
+package digest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Report is the duplicate digest of [From, To)
+type Report struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Duplicates int64     `json:"duplicates"`
+	Bytes      int64     `json:"bytes"`
+	// Previous totals the period of the same length ending at From
+	Previous Period `json:"previous"`
+	// DuplicatesChange and BytesChange are the percentage change from the
+	// previous period, nil when it had no duplicates
+	DuplicatesChange *float64 `json:"duplicates_change_percent"`
+	BytesChange      *float64 `json:"bytes_change_percent"`
+	// Originals are the top originals by duplicate count
+	Originals []storage.OriginalDuplicates `json:"originals"`
+	// Sources are the top sources by duplicated bytes, sources with no
+	// duplicates last
+	Sources []Source `json:"sources"`
+}
+
+// Period totals the duplicates of a period
+type Period struct {
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Duplicates int64     `json:"duplicates"`
+	Bytes      int64     `json:"bytes"`
+}
+
+// Source is the duplicates of one source with its trend
+type Source struct {
+	storage.SourceDuplicates
+	PreviousBytes int64    `json:"previous_bytes"`
+	BytesChange   *float64 `json:"bytes_change_percent"`
+}
+
+// Build aggregates the duplicates of [from, to) and of the period of the same
+// length before it, listing at most top originals and sources
+func Build(store storage.Reader, from, to time.Time, top int) (*Report, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("digest period %s to %s is empty", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if top <= 0 {
+		top = config.DefaultDigestTop
+	}
+	current, err := store.DuplicateStats(from, to, top)
+	if err != nil {
+		return nil, err
+	}
+	prevFrom := from.Add(-to.Sub(from))
+	previous, err := store.DuplicateStats(prevFrom, from, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		From:       current.From,
+		To:         current.To,
+		Duplicates: current.Duplicates,
+		Bytes:      current.Bytes,
+		Previous: Period{
+			From:       previous.From,
+			To:         previous.To,
+			Duplicates: previous.Duplicates,
+			Bytes:      previous.Bytes,
+		},
+		DuplicatesChange: change(current.Duplicates, previous.Duplicates),
+		BytesChange:      change(current.Bytes, previous.Bytes),
+		Originals:        current.Originals,
+		Sources:          []Source{},
+	}
+
+	previousBytes := make(map[string]int64, len(previous.Sources))
+	for _, s := range previous.Sources {
+		previousBytes[s.Source] = s.Bytes
+	}
+	for _, s := range current.Sources[:min(top, len(current.Sources))] {
+		report.Sources = append(report.Sources, Source{
+			SourceDuplicates: s,
+			PreviousBytes:    previousBytes[s.Source],
+			BytesChange:      change(s.Bytes, previousBytes[s.Source]),
+		})
+	}
+	return report, nil
+}
+
+// Redacted returns a copy of the report with the original paths and the
+// sources passed through fn
+func (r *Report) Redacted(fn func(string) string) *Report {
+	c := *r
+	c.Originals = make([]storage.OriginalDuplicates, len(r.Originals))
+	for i, o := range r.Originals {
+		o.DestPath = fn(o.DestPath)
+		c.Originals[i] = o
+	}
+	c.Sources = make([]Source, len(r.Sources))
+	for i, s := range r.Sources {
+		s.Source = fn(s.Source)
+		c.Sources[i] = s
+	}
+	return &c
+}
+
+// change returns the percentage change from previous to current, or nil
+// when previous is zero and no percentage exists
+func change(current, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	pct := float64(current-previous) / float64(previous) * 100
+	return &pct
+}
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encode digest: %w", err)
+	}
+	return nil
+}
+
+// WriteTable writes the report as aligned tables for people to read
+func WriteTable(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Duplicate digest %s to %s\n", report.From.Format(time.RFC3339), report.To.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Duplicates:\t%d\t(previous %d, %s)\n", report.Duplicates, report.Previous.Duplicates, percent(report.DuplicatesChange))
+	fmt.Fprintf(tw, "Duplicated bytes:\t%d\t(previous %d, %s)\n", report.Bytes, report.Previous.Bytes, percent(report.BytesChange))
+
+	fmt.Fprintln(tw, "\nTop originals by duplicate count")
+	fmt.Fprintln(tw, "DUPLICATES\tBYTES\tORIGINAL\tSHA256")
+	for _, o := range report.Originals {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", o.Duplicates, o.Bytes, o.DestPath, o.SHA256)
+	}
+
+	fmt.Fprintln(tw, "\nTop sources by duplicated bytes")
+	fmt.Fprintln(tw, "SOURCE\tDUPLICATES\tBYTES\tPREVIOUS BYTES\tCHANGE\tINGESTED\tINGESTED BYTES")
+	for _, s := range report.Sources {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\t%d\n",
+			s.Source, s.Duplicates, s.Bytes, s.PreviousBytes, percent(s.BytesChange), s.Ingested, s.IngestedBytes)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write digest: %w", err)
+	}
+	return nil
+}
+
+// percent formats a change, n/a when the previous value was zero
+func percent(change *float64) string {
+	if change == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", *change)
+}
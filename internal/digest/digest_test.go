@@ -0,0 +1,168 @@
+package digest
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openStore opens a migrated state database at path
+func openStore(t *testing.T, path string) *storage.Storage {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// weekStart starts the week the synthetic data is reported for
+var weekStart = time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// seed ingests one original per source the week before weekStart and
+// records n duplicates of size bytes of the original of each source at at
+func seed(t *testing.T, store *storage.Storage, at time.Time, dups map[string]int, size int64) {
+	t.Helper()
+	for source, n := range dups {
+		sha := "sha-" + source
+		original, err := store.FindBySHA256(sha)
+		if err != nil {
+			t.Fatalf("FindBySHA256() error = %v", err)
+		}
+		if original == nil {
+			_, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+				SHA256: sha, Size: size, Status: status.Ingested,
+				DestPath:    "/warehouse/" + source + "/data.csv",
+				ProcessedAt: weekStart.AddDate(0, 0, -7), RelPath: source + "/data.csv",
+			})
+			if err != nil {
+				t.Fatalf("CreateFileIfAbsent() error = %v", err)
+			}
+			original, _ = store.FindBySHA256(sha)
+		}
+		for range n {
+			err := store.RecordDuplicate(storage.DuplicateRecord{
+				DetectedAt: at, Source: source, SHA256: sha, Size: size, Dedup: "hash", Original: original,
+			})
+			if err != nil {
+				t.Fatalf("RecordDuplicate() error = %v", err)
+			}
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	// The previous week: acme resent 2 files, globex 4
+	seed(t, store, weekStart.AddDate(0, 0, -3), map[string]int{"acme": 2, "globex": 4}, 100)
+	// This week: acme resent 6, globex 1, and initech, which only ingested
+	// a file, none
+	seed(t, store, weekStart.AddDate(0, 0, 2), map[string]int{"acme": 6, "globex": 1}, 100)
+	if _, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256: "fresh", Size: 50, ProcessedAt: weekStart.Add(time.Hour), RelPath: "initech/new.csv",
+	}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+
+	report, err := Build(store, weekStart, weekStart.AddDate(0, 0, 7), 10)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if report.Duplicates != 7 || report.Bytes != 700 || report.Previous.Duplicates != 6 || report.Previous.Bytes != 600 {
+		t.Errorf("totals = %+v, previous %+v; want 7 (700 bytes) against 6 (600)", report, report.Previous)
+	}
+	if !report.Previous.To.Equal(weekStart) || !report.Previous.From.Equal(weekStart.AddDate(0, 0, -7)) {
+		t.Errorf("previous period = %s to %s, want the week before", report.Previous.From, report.Previous.To)
+	}
+	if c := report.DuplicatesChange; c == nil || *c < 16.66 || *c > 16.67 {
+		t.Errorf("DuplicatesChange = %v, want +16.7%%", c)
+	}
+	if len(report.Originals) != 2 || report.Originals[0].SHA256 != "sha-acme" || report.Originals[0].Duplicates != 6 {
+		t.Errorf("Originals = %+v, want acme's original first with 6", report.Originals)
+	}
+
+	type row struct {
+		source                 string
+		bytes, previous        int64
+		change                 float64
+		ingested, ingestedSize int64
+	}
+	want := []row{
+		{"acme", 600, 200, 200, 0, 0},
+		{"globex", 100, 400, -75, 0, 0},
+		{"initech", 0, 0, 0, 1, 50},
+	}
+	if len(report.Sources) != len(want) {
+		t.Fatalf("Sources = %+v, want %d", report.Sources, len(want))
+	}
+	for i, w := range want {
+		s := report.Sources[i]
+		if s.Source != w.source || s.Bytes != w.bytes || s.PreviousBytes != w.previous ||
+			s.Ingested != w.ingested || s.IngestedBytes != w.ingestedSize {
+			t.Errorf("Sources[%d] = %+v, want %+v", i, s, w)
+		}
+		if w.previous == 0 {
+			if s.BytesChange != nil {
+				t.Errorf("Sources[%d].BytesChange = %v, want none without a previous value", i, *s.BytesChange)
+			}
+		} else if s.BytesChange == nil || *s.BytesChange != w.change {
+			t.Errorf("Sources[%d].BytesChange = %v, want %v", i, s.BytesChange, w.change)
+		}
+	}
+
+	// top limits both lists
+	if report, err = Build(store, weekStart, weekStart.AddDate(0, 0, 7), 1); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(report.Originals) != 1 || len(report.Sources) != 1 || report.Sources[0].Source != "acme" {
+		t.Errorf("Build(top 1) = %+v, want only acme", report)
+	}
+
+	if _, err := Build(store, weekStart, weekStart, 10); err == nil {
+		t.Error("Build() of an empty period succeeded")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	seed(t, store, weekStart.Add(time.Hour), map[string]int{"acme": 3}, 10)
+
+	report, err := Build(store, weekStart, weekStart.AddDate(0, 0, 7), 10)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, report); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "(previous 0, n/a)") || !strings.Contains(out, "/warehouse/acme/data.csv") {
+		t.Errorf("table missing the totals or the original:\n%s", out)
+	}
+	found := false
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Join(strings.Fields(line), " ") == "acme 3 30 0 n/a 0 0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("table missing the acme row:\n%s", out)
+	}
+}
@@ -0,0 +1,103 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// EventKind is the outbox kind of a scheduled digest
+const EventKind = "duplicate_digest"
+
+// checkInterval bounds how late a due digest is sent
+const checkInterval = time.Minute
+
+// Event is the notification payload of a scheduled digest
+type Event struct {
+	Kind   string    `json:"kind"`
+	At     time.Time `json:"at"`
+	Digest *Report   `json:"digest"`
+}
+
+// Scheduler sends the digest of the past interval through the outbox once
+// every interval. When the last one was sent is kept in the state database,
+// so restarts do not delay or repeat it.
+type Scheduler struct {
+	store    *storage.Storage
+	interval time.Duration
+	top      int
+	redact   func(string) string
+	notify   func()
+	now      func() time.Time
+}
+
+// NewScheduler returns a scheduler of digests listing top originals and
+// sources, with paths and sources passed through redact. notify is called
+// after a digest is written to the outbox.
+func NewScheduler(store *storage.Storage, interval time.Duration, top int, redact func(string) string, notify func()) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		interval: interval,
+		top:      top,
+		redact:   redact,
+		notify:   notify,
+		now:      time.Now,
+	}
+}
+
+// Run sends digests as they fall due until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(s.interval, checkInterval))
+	defer ticker.Stop()
+	for {
+		supervisor.Beat(ctx)
+		if _, err := s.Emit(); err != nil {
+			slog.Error("failed to send duplicate digest", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Emit writes the digest of the past interval to the outbox when one is
+// due: an interval after the last was sent, or at once when none was. It
+// reports whether it wrote one.
+func (s *Scheduler) Emit() (bool, error) {
+	now := s.now().UTC()
+	last, err := s.store.LastDigest()
+	if err != nil {
+		return false, err
+	}
+	if !last.IsZero() && now.Before(last.Add(s.interval)) {
+		return false, nil
+	}
+
+	report, err := Build(s.store, now.Add(-s.interval), now, s.top)
+	if err != nil {
+		return false, err
+	}
+	payload, err := json.Marshal(Event{Kind: EventKind, At: now, Digest: report.Redacted(s.redact)})
+	if err != nil {
+		return false, fmt.Errorf("encode duplicate digest: %w", err)
+	}
+	err = s.store.EnqueueDigest(&storage.OutboxMessage{
+		CreatedAt: now,
+		Kind:      EventKind,
+		Payload:   string(payload),
+		Ready:     true,
+	}, now)
+	if err != nil {
+		return false, err
+	}
+	slog.Info("duplicate digest sent", "from", report.From, "to", report.To, "duplicates", report.Duplicates, "bytes", report.Bytes)
+	s.notify()
+	return true, nil
+}
@@ -0,0 +1,58 @@
+package digest
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestScheduler_EmitsOncePerInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store := openStore(t, path)
+	seed(t, store, weekStart.Add(time.Hour), map[string]int{"acme": 2}, 10)
+
+	now := weekStart.AddDate(0, 0, 7)
+	notified := 0
+	newScheduler := func(store *storage.Storage) *Scheduler {
+		s := NewScheduler(store, 7*24*time.Hour, 10, strings.ToUpper, func() { notified++ })
+		s.now = func() time.Time { return now }
+		return s
+	}
+	s := newScheduler(store)
+
+	// The first digest goes out at once
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v, want the first digest sent", sent, err)
+	}
+	// Not again within the interval, even after a restart
+	now = now.AddDate(0, 0, 6)
+	if sent, err := newScheduler(openStore(t, path)).Emit(); err != nil || sent {
+		t.Fatalf("Emit() = %v, %v within the interval, want nothing sent", sent, err)
+	}
+	now = now.AddDate(0, 0, 1)
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v after the interval, want a digest sent", sent, err)
+	}
+	if notified != 2 {
+		t.Errorf("dispatcher notified %d times, want 2", notified)
+	}
+
+	msgs, err := store.PendingOutbox(10)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("PendingOutbox() = %+v, %v, want 2 digests", msgs, err)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(msgs[0].Payload), &e); err != nil {
+		t.Fatalf("decode digest payload: %v", err)
+	}
+	if msgs[0].Kind != EventKind || e.Kind != EventKind || e.Digest.Duplicates != 2 {
+		t.Errorf("first digest = %+v, want the 2 duplicates of the first week", e)
+	}
+	if len(e.Digest.Sources) != 1 || e.Digest.Sources[0].Source != "ACME" || e.Digest.Originals[0].DestPath != "/WAREHOUSE/ACME/DATA.CSV" {
+		t.Errorf("digest = %+v, want sources and paths redacted", e.Digest)
+	}
+}
@@ -0,0 +1,299 @@
+// Package fetch downloads files over HTTPS, resuming interrupted downloads
+// with range requests.
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of Options
+const (
+	DefaultTimeout = 30 * time.Second
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+)
+
+// ErrTooLarge is returned when a download exceeds the size cap
+var ErrTooLarge = errors.New("download exceeds the size cap")
+
+// ErrInsecure is returned for a plain http URL, or a redirect to one, unless
+// Options.AllowHTTP is set
+var ErrInsecure = errors.New("plain http is not allowed")
+
+// errStalled is the cause of a download whose body sends nothing for the
+// timeout
+var errStalled = errors.New("download stalled")
+
+// StatusError is a response whose status is no success
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.Code, http.StatusText(e.Code))
+}
+
+// temporary reports whether a request failing with e may succeed later
+func (e *StatusError) temporary() bool {
+	return e.Code >= 500 || e.Code == http.StatusRequestTimeout || e.Code == http.StatusTooManyRequests
+}
+
+// Options tunes a Client
+type Options struct {
+	// Timeout bounds connecting, waiting for the response headers and a
+	// body sending nothing; DefaultTimeout when zero
+	Timeout time.Duration
+	// Retries is how many times a failed download is resumed
+	Retries int
+	// Backoff is the wait before the first retry, doubled after each;
+	// DefaultBackoff when zero
+	Backoff time.Duration
+	// MaxBytes caps the size of a download (0 unlimited)
+	MaxBytes int64
+	// AllowHTTP also downloads plain http URLs; only https is by default
+	AllowHTTP bool
+}
+
+// Result is a completed download
+type Result struct {
+	SHA256 string
+	Size   int64
+}
+
+// Client downloads files. Proxies are taken from the environment, as
+// HTTPS_PROXY and NO_PROXY set them.
+type Client struct {
+	http *http.Client
+	opts Options
+}
+
+// New returns a Client with opts
+func New(opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: opts.Timeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+	c := &Client{opts: opts}
+	c.http = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return c.checkScheme(req.URL)
+		},
+	}
+	return c
+}
+
+// checkScheme refuses u unless it is https, or http with AllowHTTP
+func (c *Client) checkScheme(u *neturl.URL) error {
+	switch {
+	case u.Scheme == "https", u.Scheme == "http" && c.opts.AllowHTTP:
+		return nil
+	case u.Scheme == "http":
+		return ErrInsecure
+	}
+	return fmt.Errorf("unsupported scheme %q", u.Scheme)
+}
+
+// Download streams url into the file at path and returns the hash and size
+// of what it wrote. A failed attempt is resumed from the bytes already
+// written with a range request, up to Retries times; a server ignoring the
+// range sends the whole file again. Invalid requests, client errors other
+// than timeouts and throttling, ErrTooLarge and ErrInsecure are not retried.
+func (c *Client) Download(ctx context.Context, url, path string) (Result, error) {
+	u, err := neturl.Parse(url)
+	if err == nil {
+		err = c.checkScheme(u)
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("GET %s: %w", url, err)
+	}
+	backoff := c.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.attempt(ctx, url, path)
+		if err == nil {
+			return res, nil
+		}
+		if attempt >= c.opts.Retries || !retryable(ctx, err) {
+			return Result{}, err
+		}
+		slog.Warn("download failed, resuming", "url", url, "attempt", attempt+1, "retry_in", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable reports whether a download failing with err may succeed when
+// resumed
+func retryable(ctx context.Context, err error) bool {
+	var status *StatusError
+	var permanent *permanentError
+	switch {
+	case ctx.Err() != nil, errors.Is(err, ErrTooLarge), errors.As(err, &permanent):
+		return false
+	case errors.As(err, &status):
+		return status.temporary()
+	}
+	return true
+}
+
+// permanentError is a failure no retry fixes, such as an invalid URL
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// attempt downloads url into path, resuming after the bytes already there
+func (c *Client) attempt(ctx context.Context, url, path string) (Result, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return Result{}, &permanentError{fmt.Errorf("open download %s: %w", path, err)}
+	}
+	defer func() { _ = file.Close() }()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Result{}, fmt.Errorf("seek download %s: %w", path, err)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Result{}, &permanentError{fmt.Errorf("GET %s: %w", url, err)}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := c.http.Do(req)
+	if errors.Is(err, ErrInsecure) {
+		return Result{}, &permanentError{err}
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && rangeStart(resp) == offset:
+	case resp.StatusCode == http.StatusOK:
+		// The whole file, from the start
+		if offset, err = 0, file.Truncate(0); err != nil {
+			return Result{}, fmt.Errorf("truncate download %s: %w", path, err)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// What was written cannot be resumed; start over on the next try
+		if err := file.Truncate(0); err != nil {
+			return Result{}, fmt.Errorf("truncate download %s: %w", path, err)
+		}
+		return Result{}, &StatusError{URL: url, Code: http.StatusServiceUnavailable}
+	default:
+		return Result{}, &StatusError{URL: url, Code: resp.StatusCode}
+	}
+	if c.opts.MaxBytes > 0 && resp.ContentLength >= 0 && offset+resp.ContentLength > c.opts.MaxBytes {
+		return Result{}, fmt.Errorf("GET %s: %w: %d bytes over %d", url, ErrTooLarge, offset+resp.ContentLength, c.opts.MaxBytes)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, offset)); err != nil {
+		return Result{}, fmt.Errorf("hash download %s: %w", path, err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return Result{}, fmt.Errorf("seek download %s: %w", path, err)
+	}
+	size, err := c.copyBody(file, h, resp.Body, offset, cancel)
+	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, errStalled) {
+			err = cause
+		}
+		return Result{}, fmt.Errorf("GET %s: %w", url, err)
+	}
+	if err := file.Sync(); err != nil {
+		return Result{}, fmt.Errorf("sync download %s: %w", path, err)
+	}
+	return Result{SHA256: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+// copyBody appends body to file and h, cancelling the request when the body
+// stalls for the timeout. It returns the size of the file.
+func (c *Client) copyBody(file *os.File, h hash.Hash, body io.Reader, offset int64, cancel context.CancelCauseFunc) (int64, error) {
+	stall := time.AfterFunc(c.opts.Timeout, func() { cancel(errStalled) })
+	defer stall.Stop()
+	limit := int64(-1)
+	if c.opts.MaxBytes > 0 {
+		limit = c.opts.MaxBytes - offset
+	}
+	buf := make([]byte, 256*1024)
+	size := offset
+	w := io.MultiWriter(file, h)
+	for {
+		n, err := body.Read(buf)
+		stall.Reset(c.opts.Timeout)
+		if n > 0 {
+			if limit >= 0 && int64(n) > limit {
+				return size, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, c.opts.MaxBytes)
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return size, werr
+			}
+			size += int64(n)
+			if limit >= 0 {
+				limit -= int64(n)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return size, nil
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+}
+
+// rangeStart returns the first byte of a partial response, -1 when its
+// Content-Range cannot be parsed
+func rangeStart(resp *http.Response) int64 {
+	spec, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,183 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// content is what the test servers send
+var content = bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+
+func contentHash() string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// flaky serves content, aborting the first response halfway through. With
+// ranges it honors Range requests.
+func flaky(t *testing.T, ranges bool) (*httptest.Server, *atomic.Int32, *atomic.Value) {
+	t.Helper()
+	var requests atomic.Int32
+	var lastRange atomic.Value
+	lastRange.Store("")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastRange.Store(r.Header.Get("Range"))
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Length", "1048576")
+			_, _ = w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests, &lastRange
+}
+
+func TestDownload_ResumesAfterInterruption(t *testing.T) {
+	srv, requests, lastRange := flaky(t, true)
+	path := filepath.Join(t.TempDir(), "data.bin")
+
+	res, err := New(Options{Retries: 2, Backoff: time.Millisecond, AllowHTTP: true}).Download(context.Background(), srv.URL+"/data.bin", path)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if res.SHA256 != contentHash() || res.Size != int64(len(content)) {
+		t.Errorf("Download() = %+v, want the hash and size of the content", res)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+	if r := lastRange.Load().(string); !strings.HasPrefix(r, "bytes=") || r == "bytes=0-" {
+		t.Errorf("retry Range = %q, want it to resume after the bytes received", r)
+	}
+}
+
+func TestDownload_RestartsWhenRangeIgnored(t *testing.T) {
+	srv, _, _ := flaky(t, false)
+	path := filepath.Join(t.TempDir(), "data.bin")
+
+	res, err := New(Options{Retries: 2, Backoff: time.Millisecond, AllowHTTP: true}).Download(context.Background(), srv.URL, path)
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if res.SHA256 != contentHash() || res.Size != int64(len(content)) {
+		t.Errorf("Download() = %+v, want the hash and size of the content", res)
+	}
+}
+
+func TestDownload_Failures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   []int
+		maxBytes int64
+		wantErr  error
+		requests int32
+	}{
+		{"server errors retried", []int{503, 502, 200}, 0, nil, 3},
+		{"retries exhausted", []int{503, 503, 503, 503}, 0, &StatusError{}, 3},
+		{"not found not retried", []int{404}, 0, &StatusError{}, 1},
+		{"size cap not retried", []int{200}, 1024, ErrTooLarge, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				if code := tt.status[min(n, len(tt.status))-1]; code != http.StatusOK {
+					w.WriteHeader(code)
+					return
+				}
+				_, _ = w.Write(content)
+			}))
+			defer srv.Close()
+
+			client := New(Options{Retries: 2, Backoff: time.Millisecond, MaxBytes: tt.maxBytes, AllowHTTP: true})
+			_, err := client.Download(context.Background(), srv.URL, filepath.Join(t.TempDir(), "data.bin"))
+			var status *StatusError
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("Download() error = %v, want success", err)
+				}
+			case *StatusError:
+				if !errors.As(err, &status) {
+					t.Errorf("Download() error = %v, want a StatusError", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("Download() error = %v, want %v", err, want)
+				}
+			}
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("requests = %d, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDownload_Stalled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		_, _ = w.Write(content[:10])
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	_, err := New(Options{Timeout: 50 * time.Millisecond, AllowHTTP: true}).Download(context.Background(), srv.URL, filepath.Join(t.TempDir(), "data.bin"))
+	if !errors.Is(err, errStalled) {
+		t.Errorf("Download() error = %v, want a stall", err)
+	}
+}
+
+func TestDownload_RefusesPlainHTTP(t *testing.T) {
+	var requests atomic.Int32
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write(content)
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL, http.StatusFound)
+	}))
+	defer secure.Close()
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"http url", plain.URL},
+		{"redirect to http", secure.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := New(Options{Retries: 2, Backoff: time.Millisecond})
+			client.http.Transport.(*http.Transport).TLSClientConfig = secure.Client().Transport.(*http.Transport).TLSClientConfig
+
+			_, err := client.Download(context.Background(), tt.url, filepath.Join(t.TempDir(), "data.bin"))
+			if !errors.Is(err, ErrInsecure) {
+				t.Errorf("Download() error = %v, want ErrInsecure", err)
+			}
+		})
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("plain http requests = %d, want 0", n)
+	}
+}
@@ -0,0 +1,33 @@
+package fileops
+
+import (
+	"fmt"
+	"os"
+)
+
+// Thresholds above which logical and allocated sizes differ materially
+const (
+	allocationSlackBytes   = 1 << 20
+	allocationSlackPercent = 10
+)
+
+// AllocatedSize returns the bytes the filesystem allocated for path
+func AllocatedSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return Allocated(info), nil
+}
+
+// AllocationDiffers reports whether the allocated size of a file differs
+// materially from its logical size: by more than a megabyte and more than
+// a tenth of the logical size. Block rounding and filesystem metadata make
+// small differences the norm.
+func AllocationDiffers(logical, allocated int64) bool {
+	diff := allocated - logical
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff > allocationSlackBytes && diff*100 > logical*allocationSlackPercent
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package fileops
+
+import "os"
+
+// Allocated returns the size of the file described by info; allocation is
+// only known on Unix
+func Allocated(info os.FileInfo) int64 {
+	return info.Size()
+}
@@ -0,0 +1,49 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocationDiffers(t *testing.T) {
+	tests := []struct {
+		name               string
+		logical, allocated int64
+		want               bool
+	}{
+		{"equal", 4096, 4096, false},
+		{"block rounding", 10, 4096, false},
+		{"sparse", 1 << 30, 4096, true},
+		{"preallocated", 0, 8 << 20, true},
+		{"large but relatively small", 1 << 40, 1<<40 - 2<<20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllocationDiffers(tt.logical, tt.allocated); got != tt.want {
+				t.Errorf("AllocationDiffers(%d, %d) = %v, want %v", tt.logical, tt.allocated, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocatedSize_Missing(t *testing.T) {
+	if _, err := AllocatedSize(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("AllocatedSize() of a missing file should fail")
+	}
+}
+
+func TestAllocatedSize_Regular(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	data := make([]byte, 64<<10)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	got, err := AllocatedSize(path)
+	if err != nil {
+		t.Fatalf("AllocatedSize() error = %v", err)
+	}
+	if AllocationDiffers(int64(len(data)), got) {
+		t.Errorf("AllocatedSize() = %d, want about %d", got, len(data))
+	}
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedBlockSize is the unit of stat's block count, 512 bytes whatever
+// the filesystem block size
+const allocatedBlockSize = 512
+
+// Allocated returns the bytes the filesystem allocated for the file
+// described by info, which may be far less than its size for sparse files
+// or more for preallocated ones. It falls back to the size when info does
+// not come from stat.
+func Allocated(info os.FileInfo) int64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size()
+	}
+	return int64(st.Blocks) * allocatedBlockSize
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocatedSize_Sparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse.img")
+	const size = 1 << 30
+	if err := os.WriteFile(path, []byte("header"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatalf("failed to extend file: %v", err)
+	}
+
+	got, err := AllocatedSize(path)
+	if err != nil {
+		t.Fatalf("AllocatedSize() error = %v", err)
+	}
+	if got >= size {
+		t.Skipf("filesystem does not support sparse files: allocated %d of %d", got, size)
+	}
+	if !AllocationDiffers(size, got) {
+		t.Errorf("AllocatedSize() = %d, want far below %d", got, size)
+	}
+}
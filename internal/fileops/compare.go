@@ -0,0 +1,91 @@
+package fileops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// compareBufferSize is the chunk size FilesEqual reads from each file
+const compareBufferSize = 256 * 1024
+
+// CompareOptions tunes FilesEqualWithOptions
+type CompareOptions struct {
+	// BytesPerSecond bounds how fast each file is read, so a comparison of
+	// large files does not starve ingestion of disk bandwidth. 0 is
+	// unlimited.
+	BytesPerSecond int64
+}
+
+// FilesEqual reports whether a and b have identical contents. Files of
+// different sizes are unequal without being read, and reading stops at the
+// first differing chunk.
+func FilesEqual(a, b string) (bool, error) {
+	return FilesEqualWithOptions(a, b, CompareOptions{})
+}
+
+// FilesEqualWithOptions is FilesEqual with a bounded read rate
+func FilesEqualWithOptions(a, b string, opts CompareOptions) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", a, err)
+	}
+	defer func() { _ = fa.Close() }()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", b, err)
+	}
+	defer func() { _ = fb.Close() }()
+
+	infoA, err := fa.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", a, err)
+	}
+	infoB, err := fb.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", b, err)
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, compareBufferSize)
+	bufB := make([]byte, compareBufferSize)
+	start := time.Now()
+	var read int64
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		if errA != nil && !isEOF(errA) {
+			return false, fmt.Errorf("read %s: %w", a, errA)
+		}
+		nb, errB := io.ReadFull(fb, bufB)
+		if errB != nil && !isEOF(errB) {
+			return false, fmt.Errorf("read %s: %w", b, errB)
+		}
+
+		// Either file may have changed size since the stat
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) || isEOF(errA) != isEOF(errB) {
+			return false, nil
+		}
+		if isEOF(errA) {
+			return true, nil
+		}
+
+		read += int64(na)
+		if opts.BytesPerSecond > 0 {
+			due := time.Duration(float64(read) / float64(opts.BytesPerSecond) * float64(time.Second))
+			if wait := due - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+	}
+}
+
+// isEOF reports whether err from io.ReadFull marks the end of the file
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,83 @@
+package fileops
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesEqual(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), compareBufferSize/8)
+	lateDiff := bytes.Clone(big)
+	lateDiff[len(lateDiff)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		a, b []byte
+		want bool
+	}{
+		{"identical", []byte("hello"), []byte("hello"), true},
+		{"both empty", nil, nil, true},
+		{"different content", []byte("hello"), []byte("hellp"), false},
+		{"different size", []byte("hello"), []byte("hello!"), false},
+		{"multi-chunk identical", big, bytes.Clone(big), true},
+		{"differs in last chunk", big, lateDiff, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := filepath.Join(dir, "a")
+			b := filepath.Join(dir, "b")
+			if err := os.WriteFile(a, tt.a, 0o644); err != nil {
+				t.Fatalf("failed to create test file: %v", err)
+			}
+			if err := os.WriteFile(b, tt.b, 0o644); err != nil {
+				t.Fatalf("failed to create test file: %v", err)
+			}
+
+			got, err := FilesEqual(a, b)
+			if err != nil {
+				t.Fatalf("FilesEqual() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FilesEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilesEqual_NonExistentFile(t *testing.T) {
+	a := filepath.Join(t.TempDir(), "a")
+	if err := os.WriteFile(a, []byte("hello"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if _, err := FilesEqual(a, "/nonexistent/file.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FilesEqual() error = %v, want not exist", err)
+	}
+}
+
+func TestFilesEqualWithOptions_RateLimit(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 2*compareBufferSize)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+	}
+
+	// Two chunks at four chunks per second take at least half a second
+	start := time.Now()
+	equal, err := FilesEqualWithOptions(a, b, CompareOptions{BytesPerSecond: 4 * compareBufferSize})
+	if err != nil || !equal {
+		t.Fatalf("FilesEqualWithOptions() = %v, %v", equal, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("comparison took %v, want the read rate bounded", elapsed)
+	}
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// directAlignment satisfies the O_DIRECT buffer and offset alignment of
+	// common Linux filesystems
+	directAlignment = 4096
+	// directChunkSize is the unit read from the source and evicted from the
+	// page cache after it is written
+	directChunkSize = 4 << 20
+)
+
+// openDirect opens path with O_DIRECT so reads bypass the page cache. When the
+// filesystem rejects O_DIRECT (e.g. tmpfs) it falls back to a regular open;
+// copyUncached then evicts the consumed pages with posix_fadvise instead.
+func openDirect(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT, 0)
+	if err == nil {
+		return f, nil
+	}
+	if errors.Is(err, unix.EINVAL) {
+		return os.Open(path)
+	}
+	return nil, err
+}
+
+// copyUncached copies in to out chunk by chunk, writing each chunk back and
+// dropping both sides from the page cache so large copies do not evict the
+// rest of the working set
+func copyUncached(out, in *os.File, src string) error {
+	buf := alignedBuffer(directChunkSize, directAlignment)
+
+	var reopened *os.File
+	defer func() {
+		if reopened != nil {
+			_ = reopened.Close()
+		}
+	}()
+
+	var offset int64
+	for {
+		n, err := in.Read(buf)
+		if err != nil && errors.Is(err, unix.EINVAL) && reopened == nil {
+			// The filesystem accepted O_DIRECT at open time but rejects the
+			// read; continue from the same offset through the page cache
+			if reopened, err = reopenBuffered(src, offset); err != nil {
+				return err
+			}
+			in = reopened
+			continue
+		}
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			dropCache(in, offset, int64(n))
+			flushAndDrop(out, offset, int64(n))
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// reopenBuffered returns a regular handle on src positioned at offset. The
+// original handle stays open and is closed by its owner.
+func reopenBuffered(src string, offset int64) (*os.File, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("reopen source without O_DIRECT: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("seek source: %w", err)
+	}
+	return f, nil
+}
+
+// dropCache advises the kernel that a clean range is no longer needed.
+// Errors are ignored; the advice is an optimization only.
+func dropCache(f *os.File, offset, length int64) {
+	_ = unix.Fadvise(int(f.Fd()), offset, length, unix.FADV_DONTNEED)
+}
+
+// flushAndDrop writes a dirty range back and then evicts it. Dirty pages
+// cannot be dropped, so writeback has to complete first.
+func flushAndDrop(f *os.File, offset, length int64) {
+	flags := unix.SYNC_FILE_RANGE_WAIT_BEFORE | unix.SYNC_FILE_RANGE_WRITE | unix.SYNC_FILE_RANGE_WAIT_AFTER
+	if err := unix.SyncFileRange(int(f.Fd()), offset, length, flags); err != nil {
+		return
+	}
+	dropCache(f, offset, length)
+}
+
+// alignedBuffer returns a size-byte slice whose first element is aligned to
+// align bytes, as required for O_DIRECT reads
+func alignedBuffer(size, align int) []byte {
+	buf := make([]byte, size+align)
+	shift := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & uintptr(align-1)); rem != 0 {
+		shift = align - rem
+	}
+	return buf[shift : shift+size]
+}
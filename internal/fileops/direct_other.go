@@ -0,0 +1,19 @@
+//go:build !linux
+
+package fileops
+
+import (
+	"io"
+	"os"
+)
+
+// openDirect opens path normally; page-cache bypass is only implemented on Linux
+func openDirect(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// copyUncached falls back to a plain copy outside Linux
+func copyUncached(out, in *os.File, _ string) error {
+	_, err := io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,184 @@
+// Package faultfs provides a fileops.FS failing chosen calls, so tests can
+// exercise what copies and moves do on a full disk, an I/O error or a rename
+// across devices
+package faultfs
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// Op names a call a Fault fails
+type Op string
+
+// Calls of an FS and of the files it opens
+const (
+	Open     Op = "open"
+	Create   Op = "create"
+	Rename   Op = "rename"
+	Remove   Op = "remove"
+	Stat     Op = "stat"
+	Link     Op = "link"
+	MkdirAll Op = "mkdir"
+	Write    Op = "write"
+	Sync     Op = "sync"
+)
+
+// Fault fails every call of Op on a path ending in Suffix, any path when
+// empty, with Err. A rename or link matches on either path. A Write fault
+// lets After bytes of the file through first.
+type Fault struct {
+	Op     Op
+	Suffix string
+	Err    error
+	After  int64
+}
+
+// FS runs calls on Base, fileops.OS when nil, failing those an injected
+// fault matches. Its files are never *os.File, so copies on it take the
+// plain read and write path.
+type FS struct {
+	Base fileops.FS
+
+	mu     sync.Mutex
+	faults []*Fault
+	calls  map[Op]int
+}
+
+var _ fileops.FS = (*FS)(nil)
+
+// Inject adds fault, which fails matching calls from now on
+func (f *FS) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// Calls returns how many calls of op were made, failed ones included
+func (f *FS) Calls(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// match counts a call of op on paths and returns the fault failing it
+func (f *FS) match(op Op, paths ...string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[Op]int)
+	}
+	f.calls[op]++
+	for _, fault := range f.faults {
+		if fault.Op == op && matches(fault.Suffix, paths) {
+			return fault
+		}
+	}
+	return nil
+}
+
+func matches(suffix string, paths []string) bool {
+	for _, path := range paths {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FS) base() fileops.FS {
+	if f.Base == nil {
+		return fileops.OS
+	}
+	return f.Base
+}
+
+func (f *FS) Open(name string) (fileops.SyncableFile, error) {
+	if fault := f.match(Open, name); fault != nil {
+		return nil, &os.PathError{Op: string(Open), Path: name, Err: fault.Err}
+	}
+	opened, err := f.base().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{SyncableFile: opened, fs: f}, nil
+}
+
+func (f *FS) Create(name string) (fileops.SyncableFile, error) {
+	if fault := f.match(Create, name); fault != nil {
+		return nil, &os.PathError{Op: string(Create), Path: name, Err: fault.Err}
+	}
+	created, err := f.base().Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{SyncableFile: created, fs: f}, nil
+}
+
+func (f *FS) Rename(oldpath, newpath string) error {
+	if fault := f.match(Rename, oldpath, newpath); fault != nil {
+		return &os.LinkError{Op: string(Rename), Old: oldpath, New: newpath, Err: fault.Err}
+	}
+	return f.base().Rename(oldpath, newpath)
+}
+
+func (f *FS) Remove(name string) error {
+	if fault := f.match(Remove, name); fault != nil {
+		return &os.PathError{Op: string(Remove), Path: name, Err: fault.Err}
+	}
+	return f.base().Remove(name)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	if fault := f.match(Stat, name); fault != nil {
+		return nil, &os.PathError{Op: string(Stat), Path: name, Err: fault.Err}
+	}
+	return f.base().Stat(name)
+}
+
+func (f *FS) Link(oldname, newname string) error {
+	if fault := f.match(Link, oldname, newname); fault != nil {
+		return &os.LinkError{Op: string(Link), Old: oldname, New: newname, Err: fault.Err}
+	}
+	return f.base().Link(oldname, newname)
+}
+
+func (f *FS) MkdirAll(path string, perm os.FileMode) error {
+	if fault := f.match(MkdirAll, path); fault != nil {
+		return &os.PathError{Op: string(MkdirAll), Path: path, Err: fault.Err}
+	}
+	return f.base().MkdirAll(path, perm)
+}
+
+// file fails the writes and syncs of an open file
+type file struct {
+	fileops.SyncableFile
+	fs      *FS
+	written int64
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	fault := f.fs.match(Write, f.Name())
+	if fault == nil || f.written+int64(len(p)) <= fault.After {
+		n, err := f.SyncableFile.Write(p)
+		f.written += int64(n)
+		return n, err
+	}
+	allowed := max(fault.After-f.written, 0)
+	n, err := f.SyncableFile.Write(p[:allowed])
+	f.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, &os.PathError{Op: string(Write), Path: f.Name(), Err: fault.Err}
+}
+
+func (f *file) Sync() error {
+	if fault := f.fs.match(Sync, f.Name()); fault != nil {
+		return &os.PathError{Op: string(Sync), Path: f.Name(), Err: fault.Err}
+	}
+	return f.SyncableFile.Sync()
+}
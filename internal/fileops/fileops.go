@@ -1,16 +1,156 @@
 package fileops
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
+// SyncPolicy controls when copied destination files are fsynced
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs every destination before it is renamed into place
+	SyncAlways SyncPolicy = "always"
+	// SyncBatch defers fsync to SyncBatch.Flush, typically once per tick
+	SyncBatch SyncPolicy = "batch"
+	// SyncNever leaves flushing to the kernel
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy validates a sync policy name
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncAlways, SyncBatch, SyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy: %s", s)
+	}
+}
+
+// CopyOptions tunes how file contents are copied
+type CopyOptions struct {
+	// Sync selects the fsync behavior for destination files
+	Sync SyncPolicy
+	// Batch collects destinations whose fsync is deferred under SyncBatch.
+	// When nil, SyncBatch behaves like SyncAlways.
+	Batch *Batch
+	// DirectThreshold enables the page-cache friendly copy loop for files
+	// of at least this many bytes. Zero disables it.
+	DirectThreshold int64
+	// AllowHardlink lets CopyFileWithOptions hard-link the destination to
+	// the source. A hard link shares its data blocks with the source, so a
+	// later in-place write to either changes both; without it, copies are
+	// reflinked when the filesystem supports it and copied otherwise.
+	AllowHardlink bool
+	// FS is the filesystem copies and moves work on, OS when nil. Clones,
+	// preallocation and uncached copies are only tried on OS.
+	FS FS
+	// OnSync, when set, is called with how long each fsync of a
+	// destination took
+	OnSync func(time.Duration)
+}
+
+// CopyMethod is how CopyFileWithOptions produced the destination
+type CopyMethod string
+
+const (
+	// CopyHardlink marks a destination sharing the inode of its source
+	CopyHardlink CopyMethod = "hardlink"
+	// CopyReflink marks a copy-on-write clone: it shares data blocks with
+	// the source until either is written, which copies the blocks written
+	CopyReflink CopyMethod = "reflink"
+	// CopyContents marks a byte-by-byte copy
+	CopyContents CopyMethod = "copy"
+)
+
+// DefaultCopyOptions fsyncs every file and always copies through the page cache
+func DefaultCopyOptions() CopyOptions {
+	return CopyOptions{Sync: SyncAlways}
+}
+
+// Batch records files whose fsync was deferred so they can be flushed together
+type Batch struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// Add registers a file to be fsynced on the next Flush
+func (b *Batch) Add(path string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paths = append(b.paths, path)
+}
+
+// Flush fsyncs every registered file. Files that no longer exist are skipped.
+func (b *Batch) Flush() error {
+	b.mu.Lock()
+	paths := b.paths
+	b.paths = nil
+	b.mu.Unlock()
+
+	var errs []error
+	for _, path := range paths {
+		if err := syncPath(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("sync %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return f.Sync()
+}
+
+// DefaultProgressEvery is the number of bytes between progress callbacks
+const DefaultProgressEvery = 64 << 20
+
+// hashChunkSize is the read size of the hashing loop; cancellation is
+// checked once per chunk
+const hashChunkSize = 1 << 20
+
+// HashOptions tunes CalculateSHA256WithOptions
+type HashOptions struct {
+	// Context cancels a long hash between chunks. Nil means never canceled.
+	Context context.Context
+	// Progress, when set, is called with the total bytes hashed so far every
+	// ProgressEvery bytes and once more at the end
+	Progress func(done int64)
+	// ProgressEvery defaults to DefaultProgressEvery
+	ProgressEvery int64
+}
+
 // CalculateSHA256 calculates the SHA256 hash of a file
 func CalculateSHA256(filePath string) (string, error) {
+	return CalculateSHA256WithOptions(filePath, HashOptions{})
+}
+
+// CalculateSHA256WithOptions is CalculateSHA256 with progress reporting and
+// cooperative cancellation
+func CalculateSHA256WithOptions(filePath string, opts HashOptions) (string, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	every := opts.ProgressEvery
+	if every <= 0 {
+		every = DefaultProgressEvery
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("open file: %w", err)
@@ -20,60 +160,125 @@ func CalculateSHA256(filePath string) (string, error) {
 	}()
 
 	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", fmt.Errorf("read file for hash: %w", err)
+	buf := make([]byte, hashChunkSize)
+	var done, reported int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("hash canceled after %d bytes: %w", done, err)
+		}
+
+		n, err := file.Read(buf)
+		if n > 0 {
+			hash.Write(buf[:n])
+			done += int64(n)
+			if opts.Progress != nil && done-reported >= every {
+				reported = done
+				opts.Progress(done)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read file for hash: %w", err)
+		}
+	}
+	if opts.Progress != nil && done != reported {
+		opts.Progress(done)
 	}
 
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // CopyFile copies a file from src to dst. If src and dst files exist, and are
-// the same, then return success. Otherwise, attempt to create a hard link
-// between the two files. If that fails, copy the file contents from src to dst.
+// the same, then return success. Otherwise, attempt to clone the file with a
+// reflink. If that fails, copy the file contents from src to dst.
 func CopyFile(src, dst string) error {
+	_, err := CopyFileWithOptions(src, dst, DefaultCopyOptions())
+	return err
+}
+
+// CopyFileWithOptions is CopyFile with explicit copy options. It returns how
+// the destination was produced.
+func CopyFileWithOptions(src, dst string, opts CopyOptions) (CopyMethod, error) {
 	// Clean paths
 	src = filepath.Clean(src)
 	dst = filepath.Clean(dst)
+	fsys := opts.FileSystem()
 
-	sfi, err := os.Stat(src)
+	sfi, err := fsys.Stat(src)
 	if err != nil {
-		return fmt.Errorf("stat source: %w", err)
+		return "", fmt.Errorf("stat source: %w", err)
 	}
 	if !sfi.Mode().IsRegular() {
 		// cannot copy non-regular files (e.g., directories,
 		// symlinks, devices, etc.)
-		return fmt.Errorf("non-regular source file %s (%q)", filepath.Base(src), sfi.Mode().String())
+		return "", fmt.Errorf("non-regular source file %s (%q)", filepath.Base(src), sfi.Mode().String())
 	}
-	dfi, err := os.Stat(dst)
+	dfi, err := fsys.Stat(dst)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			return fmt.Errorf("stat destination: %w", err)
+			return "", fmt.Errorf("stat destination: %w", err)
 		}
 	} else {
 		if !(dfi.Mode().IsRegular()) {
-			return fmt.Errorf("non-regular destination file %s (%q)", filepath.Base(dst), dfi.Mode().String())
+			return "", fmt.Errorf("non-regular destination file %s (%q)", filepath.Base(dst), dfi.Mode().String())
 		}
 		if os.SameFile(sfi, dfi) {
-			return nil
+			if opts.AllowHardlink {
+				return CopyHardlink, nil
+			}
+			// Copying over the shared inode would truncate the source, so
+			// the destination's link is removed for the copy to get its
+			// own. A single link means both names are one directory entry.
+			if src == dst || LinkCount(sfi) < 2 {
+				return "", fmt.Errorf("destination %s is the source", filepath.Base(dst))
+			}
+			if err := fsys.Remove(dst); err != nil {
+				return "", fmt.Errorf("unlink destination from source: %w", err)
+			}
 		}
 	}
-	// Try hard link first (efficient for same filesystem)
-	if err = os.Link(src, dst); err == nil {
-		return nil
+	// Try hard link first when allowed (efficient for same filesystem)
+	if opts.AllowHardlink {
+		if err = fsys.Link(src, dst); err == nil {
+			return CopyHardlink, nil
+		}
+	}
+	// Then a copy-on-write clone, which writes to either side cannot leak
+	// through
+	if fsys == OS {
+		if err := reflink(src, dst, opts); err == nil {
+			opts.DeferSync(dst)
+			return CopyReflink, nil
+		}
 	}
 	// Fall back to content copy
-	if err := copyFileContents(src, dst); err != nil {
-		return fmt.Errorf("copy file contents: %w", err)
+	if err := copyFileContents(src, dst, opts); err != nil {
+		return "", fmt.Errorf("copy file contents: %w", err)
 	}
-	return nil
+	opts.DeferSync(dst)
+	return CopyContents, nil
 }
 
 // copyFileContents copies the contents of the file named src to the file named
 // by dst. The file will be created if it does not already exist. If the
 // destination file exists, all its contents will be replaced by the contents
 // of the source file.
-func copyFileContents(src, dst string) error {
-	in, err := os.Open(src)
+func copyFileContents(src, dst string, opts CopyOptions) (err error) {
+	fsys := opts.FileSystem()
+	sfi, err := fsys.Stat(src)
+	if err != nil {
+		return fmt.Errorf("stat source: %w", err)
+	}
+	direct := fsys == OS && opts.DirectThreshold > 0 && sfi.Size() >= opts.DirectThreshold
+
+	var in SyncableFile
+	if direct {
+		in, err = openDirect(src)
+	} else {
+		in, err = fsys.Open(src)
+	}
 	if err != nil {
 		return fmt.Errorf("open source: %w", err)
 	}
@@ -81,34 +286,99 @@ func copyFileContents(src, dst string) error {
 		_ = in.Close()
 	}()
 
-	out, err := os.Create(dst)
+	out, err := fsys.Create(dst)
 	if err != nil {
 		return fmt.Errorf("create destination: %w", err)
 	}
 	defer func() {
-		if cerr := out.Close(); err == nil {
-			err = cerr
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close destination: %w", cerr)
 		}
 	}()
+	osOut, native := out.(*os.File)
+	if native {
+		if err := Preallocate(osOut, sfi.Size()); err != nil {
+			return err
+		}
+	}
 
-	if _, err = io.Copy(out, in); err != nil {
+	if osIn, ok := in.(*os.File); direct && ok && native {
+		err = copyUncached(osOut, osIn, src)
+	} else {
+		_, err = io.Copy(out, in)
+	}
+	if err != nil {
 		return fmt.Errorf("copy contents: %w", err)
 	}
-	if err := out.Sync(); err != nil {
-		return fmt.Errorf("sync destination: %w", err)
+
+	if opts.SyncNow() {
+		if err := opts.sync(out); err != nil {
+			return fmt.Errorf("sync destination: %w", err)
+		}
 	}
 	return nil
 }
 
+// sync fsyncs f, reporting how long it took to OnSync
+func (o CopyOptions) sync(f SyncableFile) error {
+	if o.OnSync == nil {
+		return f.Sync()
+	}
+	start := time.Now()
+	err := f.Sync()
+	o.OnSync(time.Since(start))
+	return err
+}
+
+// syncDir fsyncs dir so the entries renamed into it survive a crash
+func syncDir(fsys FS, dir string, opts CopyOptions) error {
+	d, err := fsys.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	return opts.sync(d)
+}
+
+// SyncNow reports whether a copy must be fsynced before it is returned
+func (o CopyOptions) SyncNow() bool {
+	switch o.Sync {
+	case SyncNever:
+		return false
+	case SyncBatch:
+		return o.Batch == nil
+	default:
+		return true
+	}
+}
+
+// DeferSync registers the final path of a copy with the batch when its fsync
+// was deferred. It must be called after any rename into place.
+func (o CopyOptions) DeferSync(path string) {
+	if o.Sync == SyncBatch && o.Batch != nil {
+		o.Batch.Add(path)
+	}
+}
+
 // MoveFile moves a file from src to dst atomically when possible.
 // It first attempts os.Rename for atomic moves on the same filesystem.
 // If that fails (cross-filesystem), it falls back to copy+sync+remove.
 func MoveFile(src, dst string) error {
+	return MoveFileWithOptions(src, dst, DefaultCopyOptions())
+}
+
+// MoveFileWithOptions is MoveFile with explicit options for the copy fallback.
+// The copy and its directory are fsynced before the source is removed, even
+// when opts.Sync defers or skips the fsync of plain copies.
+func MoveFileWithOptions(src, dst string, opts CopyOptions) error {
 	src = filepath.Clean(src)
 	dst = filepath.Clean(dst)
+	fsys := opts.FileSystem()
 
 	// Validate source file
-	sfi, err := os.Stat(src)
+	sfi, err := fsys.Stat(src)
 	if err != nil {
 		return fmt.Errorf("stat source: %w", err)
 	}
@@ -117,27 +387,33 @@ func MoveFile(src, dst string) error {
 	}
 
 	// Try atomic rename first (works on same filesystem)
-	if err := os.Rename(src, dst); err == nil {
+	if err := fsys.Rename(src, dst); err == nil {
 		return nil
 	}
 
 	// Rename failed (likely cross-filesystem), fall back to copy+remove
-	// First copy to a temp file, then rename for atomicity
+	// First copy to a temp file, then rename for atomicity. The source is
+	// removed afterwards, so the copy is fsynced whatever the sync policy.
 	tmpDst := dst + ".tmp"
-	if err := copyFileContents(src, tmpDst); err != nil {
+	durable := opts
+	durable.Sync = SyncAlways
+	if err := copyFileContents(src, tmpDst, durable); err != nil {
 		// Clean up temp file on error (best effort)
-		_ = os.Remove(tmpDst)
+		_ = fsys.Remove(tmpDst)
 		return fmt.Errorf("copy file contents: %w", err)
 	}
 
 	// Atomic rename of temp file to final destination
-	if err := os.Rename(tmpDst, dst); err != nil {
-		_ = os.Remove(tmpDst)
+	if err := fsys.Rename(tmpDst, dst); err != nil {
+		_ = fsys.Remove(tmpDst)
 		return fmt.Errorf("rename temp to destination: %w", err)
 	}
+	if err := syncDir(fsys, filepath.Dir(dst), opts); err != nil {
+		return fmt.Errorf("sync destination directory: %w", err)
+	}
 
 	// Remove source file after successful copy
-	if err := os.Remove(src); err != nil {
+	if err := fsys.Remove(src); err != nil {
 		// Log but don't fail - the file was successfully copied
 		return fmt.Errorf("remove source after copy (destination is safe): %w", err)
 	}
@@ -1,9 +1,14 @@
 package fileops
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCalculateSHA256(t *testing.T) {
@@ -97,9 +102,60 @@ func TestCopyFile_SameFile(t *testing.T) {
 		t.Skipf("hard links not supported: %v", err)
 	}
 
-	// CopyFile should return nil for same file
+	// Hard links allowed, the link already is the copy
+	method, err := CopyFileWithOptions(srcFile, linkFile, CopyOptions{AllowHardlink: true})
+	if err != nil || method != CopyHardlink {
+		t.Errorf("CopyFileWithOptions(AllowHardlink: true) = %s, %v, want the link kept", method, err)
+	}
+
+	// Otherwise the link is replaced by a copy of its own
 	if err := CopyFile(srcFile, linkFile); err != nil {
-		t.Errorf("CopyFile failed for same file: %v", err)
+		t.Fatalf("CopyFile failed for same file: %v", err)
+	}
+	sfi, _ := os.Stat(srcFile)
+	dfi, _ := os.Stat(linkFile)
+	if os.SameFile(sfi, dfi) {
+		t.Error("destination still shares the source's inode without AllowHardlink")
+	}
+	for _, path := range []string{srcFile, linkFile} {
+		if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(path), got, err, content)
+		}
+	}
+
+	// A file is never copied over itself
+	if err := CopyFile(srcFile, srcFile); err == nil {
+		t.Error("CopyFile of a file onto itself succeeded, want an error")
+	}
+	if got, err := os.ReadFile(srcFile); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("source = %q, %v after copying it onto itself, want %q", got, err, content)
+	}
+}
+
+func TestCopyFileWithOptions_Hardlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcFile := filepath.Join(tmpDir, "source.txt")
+	if err := os.WriteFile(srcFile, []byte("test content"), 0o644); err != nil {
+		t.Fatalf("failed to create source file: %v", err)
+	}
+	sfi, _ := os.Stat(srcFile)
+
+	for _, allow := range []bool{false, true} {
+		dstFile := filepath.Join(tmpDir, fmt.Sprintf("dest-%v.txt", allow))
+		method, err := CopyFileWithOptions(srcFile, dstFile, CopyOptions{AllowHardlink: allow})
+		if err != nil {
+			t.Fatalf("CopyFileWithOptions(AllowHardlink: %v) error = %v", allow, err)
+		}
+		dfi, err := os.Stat(dstFile)
+		if err != nil {
+			t.Fatalf("stat destination: %v", err)
+		}
+		if linked := os.SameFile(sfi, dfi); linked != allow || (method == CopyHardlink) != allow {
+			t.Errorf("AllowHardlink: %v gave method %s, same inode %v", allow, method, linked)
+		}
+		if allow && LinkCount(dfi) != 2 {
+			t.Errorf("LinkCount() = %d for a hard link, want 2", LinkCount(dfi))
+		}
 	}
 }
 
@@ -219,3 +275,180 @@ func TestMoveFile_NonRegularSource(t *testing.T) {
 		t.Error("expected error when moving directory, got nil")
 	}
 }
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, name := range []string{"always", "batch", "never"} {
+		p, err := ParseSyncPolicy(name)
+		if err != nil {
+			t.Errorf("ParseSyncPolicy(%q) failed: %v", name, err)
+		}
+		if string(p) != name {
+			t.Errorf("ParseSyncPolicy(%q) = %q", name, p)
+		}
+	}
+
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("expected error for unknown sync policy, got nil")
+	}
+}
+
+func TestCopyFileContents_AllModes(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcFile := filepath.Join(tmpDir, "source.bin")
+
+	// Not a multiple of the O_DIRECT alignment so the short tail read is exercised
+	content := make([]byte, 3*1024*1024+123)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(srcFile, content, 0o644); err != nil {
+		t.Fatalf("failed to create source file: %v", err)
+	}
+
+	want, err := CalculateSHA256(srcFile)
+	if err != nil {
+		t.Fatalf("CalculateSHA256 failed: %v", err)
+	}
+
+	for _, policy := range []SyncPolicy{SyncAlways, SyncBatch, SyncNever} {
+		for _, threshold := range []int64{0, 1} {
+			name := fmt.Sprintf("%s/direct_threshold=%d", policy, threshold)
+			t.Run(name, func(t *testing.T) {
+				batch := &Batch{}
+				syncs := 0
+				opts := CopyOptions{Sync: policy, Batch: batch, DirectThreshold: threshold, OnSync: func(time.Duration) { syncs++ }}
+
+				dstFile := filepath.Join(t.TempDir(), "dest.bin")
+				if err := copyFileContents(srcFile, dstFile, opts); err != nil {
+					t.Fatalf("copyFileContents failed: %v", err)
+				}
+				if want := policy == SyncAlways; (syncs == 1) != want || syncs > 1 {
+					t.Errorf("OnSync called %d times, want once only when synced now (%v)", syncs, want)
+				}
+				if err := batch.Flush(); err != nil {
+					t.Fatalf("Flush failed: %v", err)
+				}
+
+				got, err := CalculateSHA256(dstFile)
+				if err != nil {
+					t.Fatalf("CalculateSHA256 failed: %v", err)
+				}
+				if got != want {
+					t.Errorf("hash mismatch: got %s, want %s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCopyOptions_DeferSync(t *testing.T) {
+	batch := &Batch{}
+
+	tests := []struct {
+		name     string
+		opts     CopyOptions
+		syncNow  bool
+		deferred bool
+	}{
+		{"always", CopyOptions{Sync: SyncAlways, Batch: batch}, true, false},
+		{"never", CopyOptions{Sync: SyncNever, Batch: batch}, false, false},
+		{"batch", CopyOptions{Sync: SyncBatch, Batch: batch}, false, true},
+		{"batch without collector", CopyOptions{Sync: SyncBatch}, true, false},
+		{"zero value", CopyOptions{}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.SyncNow(); got != tt.syncNow {
+				t.Errorf("SyncNow() = %v, want %v", got, tt.syncNow)
+			}
+
+			tt.opts.DeferSync("/warehouse/file.csv")
+			if got := len(batch.paths) == 1; got != tt.deferred {
+				t.Errorf("deferred = %v, want %v", got, tt.deferred)
+			}
+			batch.paths = nil
+		})
+	}
+}
+
+func TestBatch_FlushSkipsMissingFiles(t *testing.T) {
+	batch := &Batch{}
+	batch.Add(filepath.Join(t.TempDir(), "gone.txt"))
+
+	if err := batch.Flush(); err != nil {
+		t.Errorf("Flush should ignore missing files, got %v", err)
+	}
+}
+
+// sparseFile creates a file of the given size without allocating its blocks
+func sparseFile(t *testing.T, size int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sparse.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create sparse file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("failed to extend sparse file: %v", err)
+	}
+	return path
+}
+
+func TestCalculateSHA256WithOptions_Progress(t *testing.T) {
+	const size = 64 << 20
+	path := sparseFile(t, size)
+
+	var calls []int64
+	hash, err := CalculateSHA256WithOptions(path, HashOptions{
+		Progress:      func(done int64) { calls = append(calls, done) },
+		ProgressEvery: 8 << 20,
+	})
+	if err != nil {
+		t.Fatalf("CalculateSHA256WithOptions() error = %v", err)
+	}
+
+	plain, err := CalculateSHA256(path)
+	if err != nil {
+		t.Fatalf("CalculateSHA256() error = %v", err)
+	}
+	if hash != plain {
+		t.Errorf("hash with progress = %s, want %s", hash, plain)
+	}
+
+	if len(calls) != 8 {
+		t.Errorf("progress called %d times, want 8: %v", len(calls), calls)
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i] <= calls[i-1] {
+			t.Errorf("progress not increasing: %v", calls)
+		}
+	}
+	if len(calls) > 0 && calls[len(calls)-1] != size {
+		t.Errorf("final progress = %d, want %d", calls[len(calls)-1], size)
+	}
+}
+
+func TestCalculateSHA256WithOptions_Cancel(t *testing.T) {
+	path := sparseFile(t, 64<<20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var last int64
+	_, err := CalculateSHA256WithOptions(path, HashOptions{
+		Context: ctx,
+		Progress: func(done int64) {
+			last = done
+			cancel()
+		},
+		ProgressEvery: 4 << 20,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CalculateSHA256WithOptions() error = %v, want context.Canceled", err)
+	}
+	if last != 4<<20 {
+		t.Errorf("hashing continued after cancel: last progress %d", last)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !unix
+
+package fileops
+
+import (
+	"errors"
+	"math"
+)
+
+// FreeSpace reports unlimited space; it is only implemented on Unix
+func FreeSpace(string) (int64, error) {
+	return math.MaxInt64, nil
+}
+
+// DiskStats is what statfs reports of the space and inodes of a
+// filesystem, as available to unprivileged users
+type DiskStats struct {
+	FreeBytes   int64 `json:"free_bytes"`
+	TotalBytes  int64 `json:"total_bytes"`
+	FreeInodes  int64 `json:"free_inodes"`
+	TotalInodes int64 `json:"total_inodes"`
+}
+
+// StatDisk is only implemented on Unix
+func StatDisk(string) (DiskStats, error) {
+	return DiskStats{}, errors.ErrUnsupported
+}
+
+// SameFilesystem reports false, so callers account for a copy; it is only
+// implemented on Unix
+func SameFilesystem(string, string) (bool, error) {
+	return false, nil
+}
+
+// Device identifies the filesystem holding a path
+type Device struct {
+	ID uint64 `json:"id"`
+	// FSType names the filesystem type, such as ext4, empty when unknown
+	FSType string `json:"fs_type,omitempty"`
+}
+
+// DeviceOf is only implemented on Unix
+func DeviceOf(string) (Device, error) {
+	return Device{}, errors.ErrUnsupported
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package fileops
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path
+func FreeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// DiskStats is what statfs reports of the space and inodes of a
+// filesystem, as available to unprivileged users
+type DiskStats struct {
+	FreeBytes   int64 `json:"free_bytes"`
+	TotalBytes  int64 `json:"total_bytes"`
+	FreeInodes  int64 `json:"free_inodes"`
+	TotalInodes int64 `json:"total_inodes"`
+}
+
+// StatDisk returns the space and inodes of the filesystem holding path.
+// Filesystems without a fixed inode table report zero inodes.
+func StatDisk(path string) (DiskStats, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskStats{}, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return DiskStats{
+		FreeBytes:   int64(st.Bavail) * int64(st.Bsize),
+		TotalBytes:  int64(st.Blocks) * int64(st.Bsize),
+		FreeInodes:  int64(st.Ffree),
+		TotalInodes: int64(st.Files),
+	}, nil
+}
+
+// SameFilesystem reports whether a and b are on the same filesystem, so
+// that renaming between them moves no data
+func SameFilesystem(a, b string) (bool, error) {
+	var sa, sb unix.Stat_t
+	if err := unix.Stat(a, &sa); err != nil {
+		return false, fmt.Errorf("stat %s: %w", a, err)
+	}
+	if err := unix.Stat(b, &sb); err != nil {
+		return false, fmt.Errorf("stat %s: %w", b, err)
+	}
+	return sa.Dev == sb.Dev, nil
+}
+
+// Device identifies the filesystem holding a path
+type Device struct {
+	ID uint64 `json:"id"`
+	// FSType names the filesystem type, such as ext4, empty when unknown
+	FSType string `json:"fs_type,omitempty"`
+}
+
+// DeviceOf returns the device of the filesystem holding path
+func DeviceOf(path string) (Device, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return Device{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	return Device{ID: uint64(st.Dev), FSType: fsType(path)}, nil
+}
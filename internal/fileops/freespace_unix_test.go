@@ -0,0 +1,60 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(t.TempDir())
+	if err != nil {
+		t.Fatalf("FreeSpace() error = %v", err)
+	}
+	if free <= 0 {
+		t.Errorf("FreeSpace() = %d, want positive", free)
+	}
+
+	if _, err := FreeSpace(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("FreeSpace() of a missing path should fail")
+	}
+}
+
+func TestStatDisk(t *testing.T) {
+	st, err := StatDisk(t.TempDir())
+	if err != nil {
+		t.Fatalf("StatDisk() error = %v", err)
+	}
+	if st.TotalBytes <= 0 || st.FreeBytes <= 0 || st.FreeBytes > st.TotalBytes || st.FreeInodes > st.TotalInodes {
+		t.Errorf("StatDisk() = %+v, want free within total", st)
+	}
+
+	if _, err := StatDisk(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("StatDisk() of a missing path should fail")
+	}
+}
+
+func TestDeviceOf(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a, err := DeviceOf(dir)
+	if err != nil {
+		t.Fatalf("DeviceOf() error = %v", err)
+	}
+	b, err := DeviceOf(sub)
+	if err != nil {
+		t.Fatalf("DeviceOf() error = %v", err)
+	}
+	if a != b {
+		t.Errorf("DeviceOf() = %+v and %+v, want the same device for a subdirectory", a, b)
+	}
+
+	if _, err := DeviceOf(filepath.Join(dir, "missing")); err == nil {
+		t.Error("DeviceOf() of a missing path should fail")
+	}
+}
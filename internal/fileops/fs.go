@@ -0,0 +1,61 @@
+package fileops
+
+import (
+	"io"
+	"os"
+)
+
+// FS is the filesystem files are copied and moved on. OS, the default, is
+// the os package; tests substitute one injecting faults.
+type FS interface {
+	Open(name string) (SyncableFile, error)
+	Create(name string) (SyncableFile, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Link(oldname, newname string) error
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// SyncableFile is an open file of an FS. Files of OS are *os.File, which
+// also lets copies clone, preallocate and bypass the page cache.
+type SyncableFile interface {
+	io.ReadWriteCloser
+	Sync() error
+	Name() string
+}
+
+// OS is the FS of the operating system
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (SyncableFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Create(name string) (SyncableFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) Link(oldname, newname string) error           { return os.Link(oldname, newname) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+// FileSystem returns the FS of o, OS when none is set
+func (o CopyOptions) FileSystem() FS {
+	if o.FS == nil {
+		return OS
+	}
+	return o.FS
+}
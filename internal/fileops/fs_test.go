@@ -0,0 +1,137 @@
+package fileops_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops/faultfs"
+)
+
+// moveEnv writes a source file and returns it, the destination next to it
+// and options moving on a fault injecting FS
+func moveEnv(t *testing.T, content string) (src, dst string, fsys *faultfs.FS, opts fileops.CopyOptions) {
+	t.Helper()
+	dir := t.TempDir()
+	src = filepath.Join(dir, "src.csv")
+	dst = filepath.Join(dir, "dst.csv")
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write source: %v", err)
+	}
+	fsys = &faultfs.FS{}
+	opts = fileops.DefaultCopyOptions()
+	opts.FS = fsys
+	return src, dst, fsys, opts
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", filepath.Base(path), err)
+	}
+	if string(got) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), got, want)
+	}
+}
+
+func assertMissing(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists, want it removed (stat error: %v)", filepath.Base(path), err)
+	}
+}
+
+func TestMoveFile_CrossDeviceFallsBackToCopy(t *testing.T) {
+	src, dst, fsys, opts := moveEnv(t, "id,name\n1,alice\n")
+	fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "src.csv", Err: syscall.EXDEV})
+
+	if err := fileops.MoveFileWithOptions(src, dst, opts); err != nil {
+		t.Fatalf("MoveFileWithOptions() error = %v", err)
+	}
+	assertContent(t, dst, "id,name\n1,alice\n")
+	assertMissing(t, src)
+	assertMissing(t, dst+".tmp")
+	if n := fsys.Calls(faultfs.Sync); n != 2 {
+		t.Errorf("destination and its directory synced %d times, want 2", n)
+	}
+}
+
+func TestMoveFile_CrossDeviceSyncsBeforeRemovingSource(t *testing.T) {
+	for _, policy := range []fileops.SyncPolicy{fileops.SyncBatch, fileops.SyncNever} {
+		t.Run(string(policy), func(t *testing.T) {
+			src, dst, fsys, opts := moveEnv(t, "id,name\n1,alice\n")
+			opts.Sync = policy
+			opts.Batch = &fileops.Batch{}
+			fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "src.csv", Err: syscall.EXDEV})
+			fsys.Inject(faultfs.Fault{Op: faultfs.Sync, Suffix: filepath.Base(filepath.Dir(dst)), Err: syscall.EIO})
+
+			err := fileops.MoveFileWithOptions(src, dst, opts)
+			if !errors.Is(err, syscall.EIO) {
+				t.Fatalf("MoveFileWithOptions() error = %v, want EIO from the directory sync", err)
+			}
+			assertContent(t, src, "id,name\n1,alice\n")
+			if n := fsys.Calls(faultfs.Sync); n != 2 {
+				t.Errorf("destination and its directory synced %d times, want 2", n)
+			}
+		})
+	}
+}
+
+func TestMoveFile_SyncFailureRemovesTemp(t *testing.T) {
+	src, dst, fsys, opts := moveEnv(t, "id,name\n1,alice\n")
+	fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "src.csv", Err: syscall.EXDEV})
+	fsys.Inject(faultfs.Fault{Op: faultfs.Sync, Suffix: ".tmp", Err: syscall.EIO})
+
+	err := fileops.MoveFileWithOptions(src, dst, opts)
+	if !errors.Is(err, syscall.EIO) {
+		t.Fatalf("MoveFileWithOptions() error = %v, want EIO", err)
+	}
+	assertMissing(t, dst+".tmp")
+	assertMissing(t, dst)
+	assertContent(t, src, "id,name\n1,alice\n")
+}
+
+func TestMoveFile_RemoveFailureKeepsDestination(t *testing.T) {
+	src, dst, fsys, opts := moveEnv(t, "id,name\n1,alice\n")
+	fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "src.csv", Err: syscall.EXDEV})
+	fsys.Inject(faultfs.Fault{Op: faultfs.Remove, Suffix: "src.csv", Err: syscall.EACCES})
+
+	err := fileops.MoveFileWithOptions(src, dst, opts)
+	if !errors.Is(err, syscall.EACCES) || !strings.Contains(err.Error(), "destination is safe") {
+		t.Fatalf("MoveFileWithOptions() error = %v, want EACCES saying the destination is safe", err)
+	}
+	assertContent(t, dst, "id,name\n1,alice\n")
+	assertContent(t, src, "id,name\n1,alice\n")
+	assertMissing(t, dst+".tmp")
+}
+
+func TestMoveFile_DiskFullPartwayRemovesTemp(t *testing.T) {
+	src, dst, fsys, opts := moveEnv(t, strings.Repeat("1,alice\n", 1024))
+	fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "src.csv", Err: syscall.EXDEV})
+	fsys.Inject(faultfs.Fault{Op: faultfs.Write, Suffix: ".tmp", Err: syscall.ENOSPC, After: 1000})
+
+	err := fileops.MoveFileWithOptions(src, dst, opts)
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("MoveFileWithOptions() error = %v, want ENOSPC", err)
+	}
+	assertMissing(t, dst+".tmp")
+	assertMissing(t, dst)
+	assertContent(t, src, strings.Repeat("1,alice\n", 1024))
+}
+
+func TestCopyFile_CreateFailure(t *testing.T) {
+	src, dst, fsys, opts := moveEnv(t, "id,name\n1,alice\n")
+	fsys.Inject(faultfs.Fault{Op: faultfs.Create, Suffix: "dst.csv", Err: syscall.ENOSPC})
+
+	method, err := fileops.CopyFileWithOptions(src, dst, opts)
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("CopyFileWithOptions() = %q, %v, want ENOSPC", method, err)
+	}
+	assertMissing(t, dst)
+	assertContent(t, src, "id,name\n1,alice\n")
+}
@@ -0,0 +1,26 @@
+package fileops
+
+import "golang.org/x/sys/unix"
+
+// fsTypes names the filesystem magic numbers statfs reports
+var fsTypes = map[int64]string{
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.CIFS_SUPER_MAGIC:      "cifs",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.SMB2_SUPER_MAGIC:      "smb2",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	0x2fc12fc1:                 "zfs",
+}
+
+// fsType names the type of the filesystem holding path, empty when unknown
+func fsType(path string) string {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return ""
+	}
+	return fsTypes[int64(st.Type)]
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fileops
+
+// fsType is only implemented on Linux
+func fsType(string) string {
+	return ""
+}
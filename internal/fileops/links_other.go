@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileops
+
+import "os"
+
+// LinkCount returns 1; link counts are only known on Unix
+func LinkCount(os.FileInfo) int {
+	return 1
+}
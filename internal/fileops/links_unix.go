@@ -0,0 +1,18 @@
+//go:build unix
+
+package fileops
+
+import (
+	"os"
+	"syscall"
+)
+
+// LinkCount returns the number of hard links to the file described by info,
+// 1 when info does not come from stat
+func LinkCount(info os.FileInfo) int {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return int(st.Nlink)
+}
@@ -0,0 +1,41 @@
+package fileops
+
+import (
+	"fmt"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode normalization forms applied to file names
+const (
+	// NormalizeNFC composes names, the form Linux tools and most consumers
+	// expect
+	NormalizeNFC = "nfc"
+	// NormalizeNone keeps names byte for byte
+	NormalizeNone = "none"
+)
+
+// ParseNormalization validates a normalization form name
+func ParseNormalization(form string) (string, error) {
+	switch form {
+	case NormalizeNFC, NormalizeNone:
+		return form, nil
+	default:
+		return "", fmt.Errorf("unknown unicode normalization %q (want %s or %s)", form, NormalizeNFC, NormalizeNone)
+	}
+}
+
+// NormalizeName returns name in the given normalization form. macOS writes
+// names decomposed (NFD), so the same visible name can arrive as different
+// byte sequences; unknown forms and NormalizeNone leave name unchanged.
+func NormalizeName(form, name string) string {
+	if form == NormalizeNFC {
+		return norm.NFC.String(name)
+	}
+	return name
+}
+
+// SameName reports whether a and b are the same name once normalized to NFC
+func SameName(a, b string) bool {
+	return a == b || norm.NFC.String(a) == norm.NFC.String(b)
+}
@@ -0,0 +1,56 @@
+package fileops
+
+import "testing"
+
+const (
+	// composedName spells é as U+00E9
+	composedName = "caf\u00e9.csv"
+	// decomposedName spells é as e followed by U+0301
+	decomposedName = "cafe\u0301.csv"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		form string
+		in   string
+		want string
+	}{
+		{"nfc composes", NormalizeNFC, decomposedName, composedName},
+		{"nfc keeps composed", NormalizeNFC, composedName, composedName},
+		{"nfc keeps ascii", NormalizeNFC, "plain.csv", "plain.csv"},
+		{"none keeps bytes", NormalizeNone, decomposedName, decomposedName},
+		{"empty form keeps bytes", "", decomposedName, decomposedName},
+		{"nfc path", NormalizeNFC, "vendor/" + decomposedName, "vendor/" + composedName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeName(tt.form, tt.in); got != tt.want {
+				t.Errorf("NormalizeName(%q, %q) = %q, want %q", tt.form, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameName(t *testing.T) {
+	if composedName == decomposedName {
+		t.Fatal("test names should differ byte for byte")
+	}
+	if !SameName(composedName, decomposedName) {
+		t.Error("composed and decomposed forms should be the same name")
+	}
+	if SameName(composedName, "cafe.csv") {
+		t.Error("names differing by an accent should not be the same name")
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	for _, form := range []string{NormalizeNFC, NormalizeNone} {
+		if _, err := ParseNormalization(form); err != nil {
+			t.Errorf("ParseNormalization(%q) error = %v", form, err)
+		}
+	}
+	if _, err := ParseNormalization("nfkd"); err == nil {
+		t.Error("ParseNormalization should reject unsupported forms")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocate reserves size bytes of disk for f with fallocate, keeping its
+// apparent size, so that a copy runs out of space before it starts rather
+// than halfway through. Filesystems without fallocate are left to allocate
+// as the copy writes.
+func Preallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("preallocate %d bytes for %s: %w", size, f.Name(), err)
+	}
+	return nil
+}
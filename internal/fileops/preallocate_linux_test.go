@@ -0,0 +1,28 @@
+//go:build linux
+
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreallocate_KeepsSize(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "dst"))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := Preallocate(f, 1<<20); err != nil {
+		t.Fatalf("Preallocate() error = %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("size = %d, want preallocation to keep the apparent size", info.Size())
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fileops
+
+import "os"
+
+// Preallocate does nothing; disk preallocation is only implemented on Linux
+func Preallocate(*os.File, int64) error {
+	return nil
+}
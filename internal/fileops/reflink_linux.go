@@ -0,0 +1,42 @@
+//go:build linux
+
+package fileops
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones src to dst with FICLONE, sharing data blocks copy-on-write
+// on filesystems that support it, such as Btrfs and XFS. dst is removed
+// again when the clone fails. It is fsynced as opts require.
+func reflink(src, dst string, opts CopyOptions) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		return fmt.Errorf("clone %s: %w", src, err)
+	}
+	if opts.SyncNow() {
+		return opts.sync(out)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fileops
+
+import "errors"
+
+// reflink is only implemented on Linux
+func reflink(_, _ string, _ CopyOptions) error {
+	return errors.ErrUnsupported
+}
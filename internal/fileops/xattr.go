@@ -0,0 +1,7 @@
+package fileops
+
+import "errors"
+
+// ErrXattrUnsupported is returned by SetXattrs when the filesystem, or the
+// platform, does not support extended attributes
+var ErrXattrUnsupported = errors.New("extended attributes not supported")
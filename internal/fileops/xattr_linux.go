@@ -0,0 +1,54 @@
+//go:build linux
+
+package fileops
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetXattrs sets the extended attributes of path, returning
+// ErrXattrUnsupported when its filesystem does not support them
+func SetXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		err := unix.Setxattr(path, name, []byte(value), 0)
+		if errors.Is(err, unix.ENOTSUP) {
+			return ErrXattrUnsupported
+		}
+		if err != nil {
+			return fmt.Errorf("set %s on %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
+
+// GetXattrs returns the extended attributes of path among names. Attributes
+// not set are left out, as are all of them when the filesystem does not
+// support extended attributes.
+func GetXattrs(path string, names []string) (map[string]string, error) {
+	attrs := make(map[string]string, len(names))
+	buf := make([]byte, 256)
+	for _, name := range names {
+		for {
+			n, err := unix.Getxattr(path, name, buf)
+			if errors.Is(err, unix.ERANGE) {
+				buf = make([]byte, 2*len(buf))
+				continue
+			}
+			if errors.Is(err, unix.ENODATA) {
+				break
+			}
+			if errors.Is(err, unix.ENOTSUP) {
+				return attrs, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("get %s of %s: %w", name, path, err)
+			}
+			attrs[name] = string(buf[:n])
+			break
+		}
+	}
+	return attrs, nil
+}
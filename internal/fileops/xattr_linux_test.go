@@ -0,0 +1,50 @@
+//go:build linux
+
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXattrs_RoundTrip(t *testing.T) {
+	dirs := map[string]string{"temp dir": t.TempDir()}
+	// tmpfs supports user attributes from Linux 6.6
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		shm, err := os.MkdirTemp("/dev/shm", "xattr-test-")
+		if err == nil {
+			t.Cleanup(func() { _ = os.RemoveAll(shm) })
+			dirs["tmpfs"] = shm
+		}
+	}
+	for name, dir := range dirs {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, "file")
+			if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			err := SetXattrs(path, map[string]string{"user.test.a": "one", "user.test.b": ""})
+			if errors.Is(err, ErrXattrUnsupported) {
+				t.Skip("filesystem does not support extended attributes")
+			}
+			if err != nil {
+				t.Fatalf("SetXattrs() error = %v", err)
+			}
+
+			got, err := GetXattrs(path, []string{"user.test.a", "user.test.b", "user.test.unset"})
+			if err != nil {
+				t.Fatalf("GetXattrs() error = %v", err)
+			}
+			want := map[string]string{"user.test.a": "one", "user.test.b": ""}
+			if len(got) != len(want) || got["user.test.a"] != want["user.test.a"] {
+				t.Errorf("GetXattrs() = %v, want %v", got, want)
+			}
+			if _, ok := got["user.test.b"]; !ok {
+				t.Error("empty attribute missing")
+			}
+		})
+	}
+}
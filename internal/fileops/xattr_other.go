@@ -0,0 +1,15 @@
+//go:build !linux
+
+package fileops
+
+// SetXattrs returns ErrXattrUnsupported; extended attributes are only
+// implemented on Linux
+func SetXattrs(string, map[string]string) error {
+	return ErrXattrUnsupported
+}
+
+// GetXattrs returns no attributes; extended attributes are only implemented
+// on Linux
+func GetXattrs(string, []string) (map[string]string, error) {
+	return map[string]string{}, nil
+}
@@ -0,0 +1,150 @@
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile converts a slash-separated path glob to an anchored regular
+// expression. "*" matches within one path component, "**" across
+// components, "**/" also matches zero directories and "?" matches one
+// character other than "/".
+func Compile(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				// "**/" also matches zero directories
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+type tokenKind int
+
+const (
+	literal tokenKind = iota
+	anyChar
+	star
+	globstar
+)
+
+type token struct {
+	kind tokenKind
+	c    byte
+	// optional marks a "**" followed by "/" that may match nothing at all,
+	// skipping both this token and the slash after it
+	optional bool
+}
+
+func tokenize(pattern string) []token {
+	tokens := make([]token, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				optional := i+1 < len(pattern) && pattern[i+1] == '/'
+				tokens = append(tokens, token{kind: globstar, optional: optional})
+			} else {
+				tokens = append(tokens, token{kind: star})
+			}
+		case '?':
+			tokens = append(tokens, token{kind: anyChar})
+		default:
+			tokens = append(tokens, token{kind: literal, c: c})
+		}
+	}
+	return tokens
+}
+
+// Overlap reports whether some path matches both patterns
+func Overlap(a, b string) bool {
+	ta, tb := tokenize(a), tokenize(b)
+	memo := make(map[[2]int]bool)
+	seen := make(map[[2]int]bool)
+
+	var intersect func(i, j int) bool
+	intersect = func(i, j int) bool {
+		key := [2]int{i, j}
+		if seen[key] {
+			return memo[key]
+		}
+		seen[key] = true
+
+		result := func() bool {
+			if i == len(ta) && j == len(tb) {
+				return true
+			}
+			// A wildcard on either side may match the empty string
+			if i < len(ta) && ta[i].kind >= star {
+				if intersect(i+1, j) || (ta[i].optional && intersect(i+2, j)) {
+					return true
+				}
+			}
+			if j < len(tb) && tb[j].kind >= star {
+				if intersect(i, j+1) || (tb[j].optional && intersect(i, j+2)) {
+					return true
+				}
+			}
+			if i == len(ta) || j == len(tb) {
+				return false
+			}
+
+			x, y := ta[i], tb[j]
+			switch {
+			case x.kind >= star && y.kind >= star:
+				// Both wildcards: let either absorb a character from the other
+				return intersect(i, j+1) || intersect(i+1, j)
+			case x.kind >= star:
+				if consumes(x, y) {
+					return intersect(i, j+1)
+				}
+				return false
+			case y.kind >= star:
+				if consumes(y, x) {
+					return intersect(i+1, j)
+				}
+				return false
+			case x.kind == literal && y.kind == literal:
+				return x.c == y.c && intersect(i+1, j+1)
+			case x.kind == literal:
+				return x.c != '/' && intersect(i+1, j+1)
+			case y.kind == literal:
+				return y.c != '/' && intersect(i+1, j+1)
+			default:
+				return intersect(i+1, j+1)
+			}
+		}()
+
+		memo[key] = result
+		return result
+	}
+
+	return intersect(0, 0)
+}
+
+// consumes reports whether wildcard w can match the single-character token t
+func consumes(w, t token) bool {
+	if w.kind == globstar {
+		return true
+	}
+	return t.kind != literal || t.c != '/'
+}
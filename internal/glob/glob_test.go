@@ -0,0 +1,60 @@
+package glob
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*.csv", "a.csv", true},
+		{"*.csv", "dir/a.csv", false},
+		{"**/*.csv", "a.csv", true},
+		{"**/*.csv", "x/y/a.csv", true},
+		{"finance/**", "finance/a/b", true},
+		{"finance/**", "financial/a", false},
+		{"a?.csv", "ab.csv", true},
+		{"a?.csv", "a/.csv", false},
+		{"data.v1.csv", "dataxv1.csv", false},
+	}
+
+	for _, tt := range tests {
+		re, err := Compile(tt.glob)
+		if err != nil {
+			t.Fatalf("Compile(%q) error = %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.match {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		overlap bool
+	}{
+		{"vendorA/*", "vendorB/*.csv", false},
+		{"vendorA/*", "vendorA/*.csv", true},
+		{"vendorA/**", "vendorA/x/y.csv", true},
+		{"**/*.csv", "vendorB/*.json", false},
+		{"**/*.csv", "a.csv", true},
+		{"*.csv", "*/a.csv", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"**/x", "x", true},
+		{"x/**", "y/**", false},
+		{"*", "**", true},
+		{"data-*.csv", "*-2024.csv", true},
+	}
+
+	for _, tt := range tests {
+		if got := Overlap(tt.a, tt.b); got != tt.overlap {
+			t.Errorf("Overlap(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.overlap)
+		}
+		if got := Overlap(tt.b, tt.a); got != tt.overlap {
+			t.Errorf("Overlap(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.overlap)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package inputspace watches the free space and inodes of the filesystem
+// the input directory is on. Producers' uploads fail once that filesystem
+// fills, so the monitor alerts before it does, when either drops below its
+// threshold.
+package inputspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// Outbox kinds of input space alerts
+const (
+	// EventLow alerts that the free space or inodes of the input
+	// filesystem dropped below their threshold, again whenever one more
+	// threshold is crossed
+	EventLow = "input_space_low"
+	// EventRecovered notices that both are above their thresholds again
+	EventRecovered = "input_space_recovered"
+)
+
+// Thresholds crossed, as listed by Status.Low
+const (
+	LowSpace  = "space"
+	LowInodes = "inodes"
+)
+
+// Thresholds are the free percentages below which the monitor alerts; 0
+// disables a threshold
+type Thresholds struct {
+	MinFreePercent       float64
+	MinFreeInodesPercent float64
+}
+
+// Status is the last check of the input filesystem
+type Status struct {
+	Path      string    `json:"path"`
+	CheckedAt time.Time `json:"checked_at"`
+	fileops.DiskStats
+	FreePercent float64 `json:"free_percent"`
+	// FreeInodesPercent is 100 on filesystems without a fixed inode table
+	FreeInodesPercent float64 `json:"free_inodes_percent"`
+	// Low lists the thresholds crossed: LowSpace and LowInodes
+	Low []string `json:"low"`
+	// Error is why the last check failed; the figures are of the one
+	// before
+	Error string `json:"error,omitempty"`
+}
+
+// Event is the notification payload of an input space alert
+type Event struct {
+	Kind   string    `json:"kind"`
+	At     time.Time `json:"at"`
+	Status Status    `json:"status"`
+}
+
+// Monitor checks the input filesystem periodically and alerts through the
+// outbox on the transitions of its thresholds. The state is kept in memory,
+// so a restart while low alerts once more.
+type Monitor struct {
+	root       string
+	thresholds Thresholds
+	store      *storage.Storage
+	redact     func(string) string
+	notify     func()
+	// statDisk is statfs; tests replace it to simulate a filling disk
+	statDisk func(path string) (fileops.DiskStats, error)
+	now      func() time.Time
+
+	mu     sync.Mutex
+	status *Status
+}
+
+// New returns a monitor of the filesystem holding root. Alerts are written
+// to the outbox of store with their paths passed through redact when notify
+// is set; notify is called after an alert is written. Without notify they
+// are only logged.
+func New(root string, thresholds Thresholds, store *storage.Storage, redact func(string) string, notify func()) *Monitor {
+	return &Monitor{
+		root:       root,
+		thresholds: thresholds,
+		store:      store,
+		redact:     redact,
+		notify:     notify,
+		statDisk:   fileops.StatDisk,
+		now:        time.Now,
+	}
+}
+
+// Run checks the filesystem every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		supervisor.Beat(ctx)
+		if err := m.Check(); err != nil {
+			slog.Error("failed to check input filesystem space", "path", m.root, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the last check, nil before the first
+func (m *Monitor) Status() *Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.status == nil {
+		return nil
+	}
+	s := *m.status
+	s.Low = slices.Clone(s.Low)
+	return &s
+}
+
+// Check statfs's the filesystem and alerts when a threshold was crossed
+// since the last check, or when all are clear again
+func (m *Monitor) Check() error {
+	now := m.now()
+	st, err := m.statDisk(m.root)
+
+	m.mu.Lock()
+	prev := m.status
+	if err != nil {
+		failed := Status{Path: m.root, Low: make([]string, 0)}
+		if prev != nil {
+			failed = *prev
+		}
+		failed.CheckedAt, failed.Error = now, err.Error()
+		m.status = &failed
+		m.mu.Unlock()
+		return err
+	}
+	status := m.evaluate(st, now)
+	m.status = &status
+	m.mu.Unlock()
+
+	var was []string
+	if prev != nil {
+		was = prev.Low
+	}
+	switch {
+	case slices.ContainsFunc(status.Low, func(low string) bool { return !slices.Contains(was, low) }):
+		slog.Error("input filesystem running out of space, producers' uploads will fail",
+			"path", m.root,
+			"low", status.Low,
+			"free_bytes", status.FreeBytes,
+			"free_percent", status.FreePercent,
+			"free_inodes", status.FreeInodes,
+			"free_inodes_percent", status.FreeInodesPercent,
+		)
+		return m.alert(EventLow, status)
+	case len(status.Low) == 0 && len(was) > 0:
+		slog.Info("input filesystem space recovered", "path", m.root, "free_percent", status.FreePercent, "free_inodes_percent", status.FreeInodesPercent)
+		return m.alert(EventRecovered, status)
+	}
+	return nil
+}
+
+// evaluate returns the status of st against the thresholds
+func (m *Monitor) evaluate(st fileops.DiskStats, now time.Time) Status {
+	s := Status{Path: m.root, CheckedAt: now, DiskStats: st, FreePercent: 100, FreeInodesPercent: 100, Low: make([]string, 0)}
+	if st.TotalBytes > 0 {
+		s.FreePercent = 100 * float64(st.FreeBytes) / float64(st.TotalBytes)
+	}
+	if st.TotalInodes > 0 {
+		s.FreeInodesPercent = 100 * float64(st.FreeInodes) / float64(st.TotalInodes)
+	}
+	if m.thresholds.MinFreePercent > 0 && s.FreePercent < m.thresholds.MinFreePercent {
+		s.Low = append(s.Low, LowSpace)
+	}
+	if m.thresholds.MinFreeInodesPercent > 0 && s.FreeInodesPercent < m.thresholds.MinFreeInodesPercent {
+		s.Low = append(s.Low, LowInodes)
+	}
+	return s
+}
+
+// alert writes an alert of kind to the outbox when notifications are on
+func (m *Monitor) alert(kind string, status Status) error {
+	if m.notify == nil || m.store == nil {
+		return nil
+	}
+	if m.redact != nil {
+		status.Path = m.redact(status.Path)
+	}
+	now := m.now().UTC()
+	payload, err := json.Marshal(Event{Kind: kind, At: now, Status: status})
+	if err != nil {
+		return fmt.Errorf("encode input space alert: %w", err)
+	}
+	if err := m.store.EnqueueOutbox(&storage.OutboxMessage{
+		CreatedAt: now,
+		Kind:      kind,
+		Payload:   string(payload),
+		Ready:     true,
+	}); err != nil {
+		return err
+	}
+	m.notify()
+	return nil
+}
@@ -0,0 +1,162 @@
+package inputspace
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openStore opens a migrated state database
+func openStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "state.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// alerts decodes the pending alerts of the outbox
+func alerts(t *testing.T, store *storage.Storage) []Event {
+	t.Helper()
+	msgs, err := store.PendingOutbox(100)
+	if err != nil {
+		t.Fatalf("PendingOutbox() error = %v", err)
+	}
+	events := make([]Event, 0, len(msgs))
+	for _, m := range msgs {
+		var e Event
+		if err := json.Unmarshal([]byte(m.Payload), &e); err != nil {
+			t.Fatalf("invalid alert payload %q: %v", m.Payload, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// disk is a filesystem of 1000 bytes and 100 inodes
+type disk struct {
+	freeBytes, freeInodes int64
+	err                   error
+}
+
+func (d *disk) stat(string) (fileops.DiskStats, error) {
+	return fileops.DiskStats{FreeBytes: d.freeBytes, TotalBytes: 1000, FreeInodes: d.freeInodes, TotalInodes: 100}, d.err
+}
+
+// newMonitor returns a monitor of d alerting into store
+func newMonitor(store *storage.Storage, d *disk, notify func()) *Monitor {
+	m := New("/in", Thresholds{MinFreePercent: 10, MinFreeInodesPercent: 5}, store, func(s string) string { return "redacted" + s }, notify)
+	m.statDisk = d.stat
+	return m
+}
+
+func TestMonitor_AlertsOnTransitions(t *testing.T) {
+	store := openStore(t)
+	notified := 0
+	d := &disk{freeBytes: 500, freeInodes: 50}
+	m := newMonitor(store, d, func() { notified++ })
+
+	if m.Status() != nil {
+		t.Error("Status() set before the first check")
+	}
+	steps := []struct {
+		freeBytes, freeInodes int64
+		want                  []string
+	}{
+		{500, 50, nil},
+		{90, 50, []string{EventLow}},
+		// Still low, no repeat
+		{80, 50, nil},
+		// One more threshold crossed
+		{80, 4, []string{EventLow}},
+		{200, 4, nil},
+		{200, 60, []string{EventRecovered}},
+		{200, 60, nil},
+	}
+	var want []string
+	for i, step := range steps {
+		d.freeBytes, d.freeInodes = step.freeBytes, step.freeInodes
+		if err := m.Check(); err != nil {
+			t.Fatalf("Check() %d error = %v", i, err)
+		}
+		want = append(want, step.want...)
+		events := alerts(t, store)
+		kinds := make([]string, 0, len(events))
+		for _, e := range events {
+			kinds = append(kinds, e.Kind)
+		}
+		if !slices.Equal(kinds, want) {
+			t.Fatalf("after check %d alerts = %v, want %v", i, kinds, want)
+		}
+	}
+	if notified != 3 {
+		t.Errorf("notified %d times, want once per alert", notified)
+	}
+
+	events := alerts(t, store)
+	if low := events[1].Status; !slices.Equal(low.Low, []string{LowSpace, LowInodes}) || low.FreeInodesPercent != 4 || low.FreePercent != 8 {
+		t.Errorf("second alert status = %+v, want both thresholds crossed", low)
+	}
+	if events[0].Status.Path != "redacted/in" {
+		t.Errorf("alert path = %q, want it redacted", events[0].Status.Path)
+	}
+	if s := m.Status(); s == nil || s.Path != "/in" || len(s.Low) != 0 || s.FreeBytes != 200 {
+		t.Errorf("Status() = %+v, want the last check", s)
+	}
+}
+
+func TestMonitor_StatFailure(t *testing.T) {
+	store := openStore(t)
+	d := &disk{freeBytes: 50, freeInodes: 50}
+	m := newMonitor(store, d, func() {})
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	d.err = errors.New("stale handle")
+	if err := m.Check(); err == nil {
+		t.Fatal("Check() succeeded on a failing statfs")
+	}
+	if s := m.Status(); s == nil || s.Error != "stale handle" || !slices.Equal(s.Low, []string{LowSpace}) {
+		t.Errorf("Status() = %+v, want the error with the figures of the last check", s)
+	}
+	// The failure does not clear the alert
+	d.err = nil
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if events := alerts(t, store); len(events) != 1 {
+		t.Errorf("%d alerts, want the one of the first check", len(events))
+	}
+}
+
+func TestMonitor_LogOnlyWithoutNotify(t *testing.T) {
+	store := openStore(t)
+	m := newMonitor(store, &disk{freeBytes: 10, freeInodes: 1}, nil)
+	if err := m.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if events := alerts(t, store); len(events) != 0 {
+		t.Errorf("alerts = %+v, want none without notifications", events)
+	}
+	if s := m.Status(); s == nil || len(s.Low) != 2 {
+		t.Errorf("Status() = %+v, want both thresholds crossed", s)
+	}
+}
@@ -0,0 +1,592 @@
+package janitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
+)
+
+// ErrSafetyCap is returned by Sweep when it would remove more files than the
+// configured caps allow
+var ErrSafetyCap = errors.New("janitor safety cap exceeded")
+
+// OperationSweep names the janitor as a maintenance.Operation
+const OperationSweep = "janitor"
+
+// Audit actions
+const (
+	ActionDeleted = "deleted"
+	ActionTrashed = "trashed"
+	// ActionRemovedDir records an empty directory removed from the input
+	// tree
+	ActionRemovedDir = "removed_dir"
+)
+
+// Tracker reports files the watcher is waiting on; the janitor never
+// touches them
+type Tracker interface {
+	IsTracked(path string) bool
+}
+
+// Unwatcher is implemented by a Tracker watching the directories of the
+// input tree; the janitor stops the watch of every directory it removes
+type Unwatcher interface {
+	Unwatch(path string) error
+}
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Pattern string    `json:"pattern"`
+	// TrashPath is where a trashed file was moved
+	TrashPath string `json:"trash_path,omitempty"`
+}
+
+// Stats summarizes one sweep
+type Stats struct {
+	// Scanned counts the regular files in the input tree
+	Scanned int
+	// Matched counts the files old enough to match a rule
+	Matched      int
+	Deleted      int
+	Trashed      int
+	RemovedBytes int64
+	// RemovedDirs counts the empty directories removed
+	RemovedDirs int
+}
+
+// rule is a compiled config.JanitorRule
+type rule struct {
+	pattern   string
+	re        *regexp.Regexp
+	olderThan time.Duration
+	action    string
+}
+
+// Janitor removes junk files that are never ingested from the input tree
+type Janitor struct {
+	root    string
+	rules   []rule
+	protect []*regexp.Regexp
+	tracker Tracker
+
+	maxFiles   int
+	maxPercent float64
+	override   bool
+	auditLog   string
+	dryRun     bool
+
+	// emptyDirs is how long a directory stays empty before it is removed,
+	// zero leaving directories alone
+	emptyDirs time.Duration
+	keepDirs  []*regexp.Regexp
+}
+
+// candidate is a file a sweep is about to remove
+type candidate struct {
+	path string
+	info fs.FileInfo
+	rule *rule
+}
+
+// New creates a janitor for the input tree at root. Files matching one of
+// the protect patterns, the ingestion patterns of completion and pipeline
+// rules, and files tracked by tracker are never removed. When tracker is
+// an Unwatcher, the directories removed are unwatched.
+func New(root string, cfg config.JanitorConfig, protect []string, tracker Tracker, dryRun bool) (*Janitor, error) {
+	j := &Janitor{
+		root:       root,
+		tracker:    tracker,
+		maxFiles:   cfg.MaxFiles,
+		maxPercent: cfg.MaxPercent,
+		override:   cfg.OverrideSafetyCap,
+		auditLog:   cfg.AuditLog,
+		dryRun:     dryRun || cfg.DryRun,
+	}
+	if j.maxFiles == 0 {
+		j.maxFiles = config.DefaultJanitorMaxFiles
+	}
+	if j.maxPercent == 0 {
+		j.maxPercent = config.DefaultJanitorMaxPct
+	}
+	if j.maxFiles < 0 || j.maxPercent < 0 || j.maxPercent > 100 {
+		return nil, fmt.Errorf("invalid safety cap: max_files %d, max_percent %g", cfg.MaxFiles, cfg.MaxPercent)
+	}
+	if j.auditLog == "" {
+		j.auditLog = config.DefaultJanitorAuditLog
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("janitor rule %d: empty pattern", i+1)
+		}
+		if r.OlderThan <= 0 {
+			return nil, fmt.Errorf("janitor rule %d: older_than must be positive", i+1)
+		}
+		action := r.Action
+		switch action {
+		case "":
+			action = config.JanitorTrash
+		case config.JanitorTrash, config.JanitorDelete:
+		default:
+			return nil, fmt.Errorf("janitor rule %d: unknown action %q", i+1, r.Action)
+		}
+		re, err := glob.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("janitor rule %d: pattern %q: %w", i+1, r.Pattern, err)
+		}
+		j.rules = append(j.rules, rule{pattern: r.Pattern, re: re, olderThan: r.OlderThan, action: action})
+	}
+
+	for _, pattern := range protect {
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("protected pattern %q: %w", pattern, err)
+		}
+		j.protect = append(j.protect, re)
+	}
+
+	if d := cfg.EmptyDirs; d != nil {
+		if d.OlderThan <= 0 {
+			return nil, errors.New("janitor empty_dirs: older_than must be positive")
+		}
+		j.emptyDirs = d.OlderThan
+		for _, pattern := range d.Keep {
+			re, err := glob.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("janitor empty_dirs: keep pattern %q: %w", pattern, err)
+			}
+			j.keepDirs = append(j.keepDirs, re)
+		}
+	}
+	return j, nil
+}
+
+// Run sweeps every interval until ctx is canceled
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			supervisor.Beat(ctx)
+			stats, err := j.Sweep(time.Now())
+			if err != nil {
+				slog.Error("failed to sweep input directory", "path", j.root, "error", err)
+				continue
+			}
+			slog.Info("input directory swept",
+				"path", j.root,
+				"scanned", stats.Scanned,
+				"matched", stats.Matched,
+				"deleted", stats.Deleted,
+				"trashed", stats.Trashed,
+				"removed_bytes", stats.RemovedBytes,
+				"removed_dirs", stats.RemovedDirs,
+				"dry_run", j.dryRun,
+			)
+		}
+	}
+}
+
+// Sweep removes the files matching a rule whose mtime is older than the
+// rule's age, then the directories left empty when configured. The whole
+// sweep is refused when it would exceed the safety cap. Tracked files are
+// checked again just before each removal, since the watcher may have
+// picked them up during the walk.
+func (j *Janitor) Sweep(now time.Time) (Stats, error) {
+	candidates, stats, err := j.candidates(now)
+	if err != nil || (len(candidates) == 0 && j.emptyDirs == 0) {
+		return stats, err
+	}
+
+	var audit *os.File
+	if !j.dryRun {
+		audit, err = os.OpenFile(j.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return stats, fmt.Errorf("open janitor audit log: %w", err)
+		}
+		defer func() { _ = audit.Close() }()
+	}
+
+	for _, c := range candidates {
+		if j.dryRun {
+			if j.tracker == nil || !j.tracker.IsTracked(c.path) {
+				slog.Info("dry run: janitor would remove file", "path", c.path, "action", c.rule.action, "pattern", c.rule.pattern, "size", c.info.Size(), "mod_time", c.info.ModTime())
+			}
+			continue
+		}
+		rec, err := j.remove(c.path, c.info.Size(), c.info.ModTime(), c.rule)
+		if err != nil {
+			return stats, err
+		}
+		switch rec.Action {
+		case "":
+			continue
+		case ActionDeleted:
+			stats.Deleted++
+		default:
+			stats.Trashed++
+		}
+		stats.RemovedBytes += rec.Size
+		if err := writeAudit(audit, rec); err != nil {
+			return stats, err
+		}
+	}
+
+	if j.emptyDirs > 0 {
+		if err := j.pruneDirs(now, audit, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// emptyDir is a directory of the input tree as a sweep walked it
+type emptyDir struct {
+	path    string
+	modTime time.Time
+}
+
+// pruneDirs removes the directories below the root, outside the trash,
+// that have been empty for emptyDirs at now. They go deepest first, so a
+// tree left empty goes in one sweep; a dry run only reports the deepest.
+func (j *Janitor) pruneDirs(now time.Time, audit *os.File, stats *Stats) error {
+	trashDir := trash.Dir(j.root)
+	var dirs []emptyDir
+
+	err := filepath.WalkDir(j.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == j.root {
+			return nil
+		}
+		if path == trashDir {
+			return fs.SkipDir
+		}
+		rel, err := filepath.Rel(j.root, path)
+		if err != nil || j.kept(filepath.ToSlash(rel)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+		// A directory whose entries changed recently is in use, empty or not
+		if now.Sub(info.ModTime()) >= j.emptyDirs {
+			dirs = append(dirs, emptyDir{path: path, modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan %s for empty directories: %w", j.root, err)
+	}
+
+	// The walk lists every directory before the directories below it
+	emptied := make(map[string]bool)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rec, err := j.removeDir(dirs[i], emptied[dirs[i].path])
+		if err != nil {
+			return err
+		}
+		if rec.Action == "" {
+			continue
+		}
+		emptied[filepath.Dir(rec.Path)] = true
+		stats.RemovedDirs++
+		if err := writeAudit(audit, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDir removes the directory d when it is still empty and its mtime
+// did not move since the walk, but by the removal of the directories below
+// it when emptied, returning its audit record. A directory a producer
+// writes into meanwhile is left alone, with no action in the record.
+func (j *Janitor) removeDir(d emptyDir, emptied bool) (AuditRecord, error) {
+	rec := AuditRecord{Time: time.Now(), Path: d.path, ModTime: d.modTime}
+	entries, err := os.ReadDir(d.path)
+	if errors.Is(err, fs.ErrNotExist) || len(entries) > 0 {
+		return rec, nil
+	}
+	if err != nil {
+		return rec, fmt.Errorf("read directory %s: %w", d.path, err)
+	}
+	info, err := os.Lstat(d.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, nil
+	}
+	if err != nil {
+		return rec, fmt.Errorf("stat %s: %w", d.path, err)
+	}
+	if !info.ModTime().Equal(d.modTime) && !emptied {
+		return rec, nil
+	}
+	if j.dryRun {
+		slog.Info("dry run: janitor would remove empty directory", "path", d.path, "mod_time", d.modTime)
+		return rec, nil
+	}
+
+	if err := os.Remove(d.path); err != nil {
+		// Filled or removed since the check
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) || errors.Is(err, fs.ErrNotExist) {
+			return rec, nil
+		}
+		return rec, fmt.Errorf("remove directory %s: %w", d.path, err)
+	}
+	if u, ok := j.tracker.(Unwatcher); ok {
+		if err := u.Unwatch(d.path); err != nil {
+			slog.Warn("failed to stop watching removed directory", "path", d.path, "error", err)
+		}
+	}
+	rec.Action = ActionRemovedDir
+	slog.Info("janitor removed empty directory", "path", d.path, "mod_time", d.modTime)
+	return rec, nil
+}
+
+// remove deletes or trashes the file at path by r, returning its audit
+// record. A file tracked meanwhile or already gone is left alone, with no
+// action in the record.
+func (j *Janitor) remove(path string, size int64, modTime time.Time, r *rule) (AuditRecord, error) {
+	rec := AuditRecord{Time: time.Now(), Path: path, Size: size, ModTime: modTime, Pattern: r.pattern}
+	if j.tracker != nil && j.tracker.IsTracked(path) {
+		return rec, nil
+	}
+	switch r.action {
+	case config.JanitorDelete:
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return rec, nil
+			}
+			return rec, fmt.Errorf("delete %s: %w", path, err)
+		}
+		rec.Action = ActionDeleted
+	default:
+		dst, err := trash.Move(j.root, path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return rec, nil
+			}
+			return rec, err
+		}
+		rec.Action, rec.TrashPath = ActionTrashed, dst
+	}
+	slog.Info("janitor removed file", "path", path, "action", rec.Action, "pattern", r.pattern, "size", size)
+	return rec, nil
+}
+
+// Name returns OperationSweep
+func (j *Janitor) Name() string { return OperationSweep }
+
+// Plan lists the files a Sweep at now removes, as items whose action is the
+// rule's and whose detail is its pattern. It fails with ErrSafetyCap like
+// Sweep.
+func (j *Janitor) Plan(now time.Time) ([]maintenance.Item, error) {
+	candidates, _, err := j.candidates(now)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]maintenance.Item, 0, len(candidates))
+	for _, c := range candidates {
+		items = append(items, maintenance.Item{
+			Path:    c.path,
+			Size:    c.info.Size(),
+			ModTime: c.info.ModTime(),
+			Action:  c.rule.action,
+			Detail:  c.rule.pattern,
+		})
+	}
+	return items, nil
+}
+
+// Apply removes the file of a planned item and records it in the audit
+// log. The item must still match its rule, by its pattern, and not be
+// protected or tracked.
+func (j *Janitor) Apply(item maintenance.Item) error {
+	rel, err := filepath.Rel(j.root, item.Path)
+	if err != nil || !filepath.IsLocal(rel) || config.Contains(trash.Dir(j.root), item.Path) {
+		return fmt.Errorf("%s is not in the input tree %s", item.Path, j.root)
+	}
+	rel = filepath.ToSlash(rel)
+	r := j.match(rel)
+	if r == nil || r.pattern != item.Detail || r.action != item.Action || j.protected(rel) {
+		return fmt.Errorf("%s no longer matches janitor rule %q", item.Path, item.Detail)
+	}
+	rec, err := j.remove(item.Path, item.Size, item.ModTime, r)
+	if err != nil || rec.Action == "" {
+		return err
+	}
+
+	audit, err := os.OpenFile(j.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open janitor audit log: %w", err)
+	}
+	defer func() { _ = audit.Close() }()
+	return writeAudit(audit, rec)
+}
+
+// candidates returns the files due for removal at now, failing with
+// ErrSafetyCap when they exceed the caps and the caps are not overridden
+func (j *Janitor) candidates(now time.Time) ([]candidate, Stats, error) {
+	var stats Stats
+	candidates, err := j.scan(now, &stats)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Matched = len(candidates)
+	if len(candidates) == 0 {
+		return nil, stats, nil
+	}
+	if err := j.checkCap(len(candidates), stats.Scanned); err != nil {
+		if !j.override {
+			return nil, stats, err
+		}
+		slog.Warn("janitor safety cap overridden", "error", err)
+	}
+	return candidates, stats, nil
+}
+
+// scan walks the input tree, outside the trash, for files due for removal
+func (j *Janitor) scan(now time.Time, stats *Stats) ([]candidate, error) {
+	trashDir := trash.Dir(j.root)
+	var candidates []candidate
+
+	err := filepath.WalkDir(j.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == trashDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		stats.Scanned++
+
+		rel, err := filepath.Rel(j.root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if j.protected(rel) {
+			return nil
+		}
+		r := j.match(rel)
+		if r == nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+		if now.Sub(info.ModTime()) < r.olderThan {
+			return nil
+		}
+		if j.tracker != nil && j.tracker.IsTracked(path) {
+			return nil
+		}
+		candidates = append(candidates, candidate{path: path, info: info, rule: r})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", j.root, err)
+	}
+	return candidates, nil
+}
+
+// protected reports whether rel matches an ingestion pattern
+func (j *Janitor) protected(rel string) bool {
+	for _, re := range j.protect {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// kept reports whether rel matches a pattern of directories to keep
+func (j *Janitor) kept(rel string) bool {
+	for _, re := range j.keepDirs {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the first rule whose pattern matches rel
+func (j *Janitor) match(rel string) *rule {
+	for i := range j.rules {
+		if j.rules[i].re.MatchString(rel) {
+			return &j.rules[i]
+		}
+	}
+	return nil
+}
+
+// checkCap returns ErrSafetyCap when removing n of total files exceeds the
+// caps
+func (j *Janitor) checkCap(n, total int) error {
+	if n > j.maxFiles {
+		return fmt.Errorf("%w: %d files to remove, max_files is %d", ErrSafetyCap, n, j.maxFiles)
+	}
+	if pct := float64(n) / float64(total) * 100; pct > j.maxPercent {
+		return fmt.Errorf("%w: %d of %d files (%.1f%%) to remove, max_percent is %g", ErrSafetyCap, n, total, pct, j.maxPercent)
+	}
+	return nil
+}
+
+// writeAudit appends rec to the audit log and syncs it
+func writeAudit(f *os.File, rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,488 @@
+package janitor
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
+)
+
+// trackedSet is a Tracker over a fixed set of paths
+type trackedSet map[string]bool
+
+func (s trackedSet) IsTracked(path string) bool { return s[path] }
+
+// writeOld creates the named files under root with an mtime of age ago
+func writeOld(t *testing.T, root string, age time.Duration, names ...string) {
+	t.Helper()
+	mtime := time.Now().Add(-age)
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+}
+
+// readAudit decodes the audit log at path
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("failed to decode audit record: %v", err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestSweep_RemovesOldMatches(t *testing.T) {
+	root := t.TempDir()
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeOld(t, root, 48*time.Hour, "producer.log", "sub/.hidden", "data.csv", "a.csv", "b.csv", "c.csv", "d.csv", "e.csv", "f.csv", "g.csv")
+	writeOld(t, root, time.Minute, "fresh.log")
+
+	j, err := New(root, config.JanitorConfig{
+		Rules: []config.JanitorRule{
+			{Pattern: "**/*.log", OlderThan: 24 * time.Hour, Action: config.JanitorDelete},
+			{Pattern: "**/.*", OlderThan: 24 * time.Hour},
+		},
+		MaxPercent: 50,
+		AuditLog:   audit,
+	}, nil, nil, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	stats, err := j.Sweep(time.Now())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if stats.Deleted != 1 || stats.Trashed != 1 || stats.Scanned != 11 {
+		t.Errorf("stats = %+v, want 1 deleted and 1 trashed of 11", stats)
+	}
+	if exists(filepath.Join(root, "producer.log")) {
+		t.Error("old log should be deleted")
+	}
+	if !exists(filepath.Join(root, "fresh.log")) {
+		t.Error("log younger than older_than should stay")
+	}
+	if !exists(filepath.Join(trash.Dir(root), "sub", ".hidden")) {
+		t.Error("hidden file should be moved to the trash")
+	}
+	if !exists(filepath.Join(root, "data.csv")) {
+		t.Error("file matching no rule should stay")
+	}
+
+	records := readAudit(t, audit)
+	if len(records) != 2 {
+		t.Fatalf("audit records = %+v, want 2", records)
+	}
+	for _, rec := range records {
+		switch rec.Action {
+		case ActionDeleted:
+			if rec.Path != filepath.Join(root, "producer.log") || rec.Pattern != "**/*.log" {
+				t.Errorf("delete audit record = %+v", rec)
+			}
+		case ActionTrashed:
+			if rec.TrashPath == "" {
+				t.Errorf("trash audit record without trash path: %+v", rec)
+			}
+		default:
+			t.Errorf("unexpected audit action %q", rec.Action)
+		}
+	}
+}
+
+func TestSweep_NeverTouchesTrackedOrProtected(t *testing.T) {
+	root := t.TempDir()
+	writeOld(t, root, 48*time.Hour, "tracked.log", "ingest/kept.log", "junk.log")
+
+	j, err := New(root, config.JanitorConfig{
+		Rules:             []config.JanitorRule{{Pattern: "**/*.log", OlderThan: time.Hour, Action: config.JanitorDelete}},
+		OverrideSafetyCap: true,
+		AuditLog:          filepath.Join(t.TempDir(), "audit.jsonl"),
+	}, []string{"ingest/**"}, trackedSet{filepath.Join(root, "tracked.log"): true}, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := j.Sweep(time.Now()); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if !exists(filepath.Join(root, "tracked.log")) {
+		t.Error("tracked file was removed")
+	}
+	if !exists(filepath.Join(root, "ingest", "kept.log")) {
+		t.Error("file matching an ingestion pattern was removed")
+	}
+	if exists(filepath.Join(root, "junk.log")) {
+		t.Error("untracked junk should be removed")
+	}
+}
+
+// lateTracker starts tracking a file after the scan found it
+type lateTracker struct {
+	path  string
+	calls int
+}
+
+func (l *lateTracker) IsTracked(path string) bool {
+	if path != l.path {
+		return false
+	}
+	l.calls++
+	return l.calls > 1
+}
+
+func TestSweep_RechecksTrackingBeforeRemoval(t *testing.T) {
+	root := t.TempDir()
+	writeOld(t, root, 48*time.Hour, "late.log")
+
+	tracker := &lateTracker{path: filepath.Join(root, "late.log")}
+	j, err := New(root, config.JanitorConfig{
+		Rules:             []config.JanitorRule{{Pattern: "*.log", OlderThan: time.Hour, Action: config.JanitorDelete}},
+		OverrideSafetyCap: true,
+		AuditLog:          filepath.Join(t.TempDir(), "audit.jsonl"),
+	}, nil, tracker, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := j.Sweep(time.Now()); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if !exists(tracker.path) {
+		t.Error("file tracked during the sweep was removed")
+	}
+}
+
+func TestSweep_SafetyCap(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.JanitorConfig
+		junk     int
+		keep     int
+		wantErr  bool
+		wantLeft int
+	}{
+		{"within caps", config.JanitorConfig{MaxFiles: 5, MaxPercent: 50}, 3, 7, false, 0},
+		{"over max files", config.JanitorConfig{MaxFiles: 2, MaxPercent: 100}, 3, 0, true, 3},
+		{"over max percent", config.JanitorConfig{MaxFiles: 100, MaxPercent: 20}, 3, 7, true, 3},
+		{"default percent", config.JanitorConfig{}, 2, 8, true, 2},
+		{"overridden", config.JanitorConfig{MaxFiles: 1, MaxPercent: 1, OverrideSafetyCap: true}, 3, 0, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for i := range tt.junk {
+				writeOld(t, root, 48*time.Hour, filepath.Join("junk", string(rune('a'+i))+".tmp"))
+			}
+			for i := range tt.keep {
+				writeOld(t, root, 48*time.Hour, string(rune('a'+i))+".csv")
+			}
+
+			cfg := tt.cfg
+			cfg.Rules = []config.JanitorRule{{Pattern: "junk/*", OlderThan: time.Hour, Action: config.JanitorDelete}}
+			cfg.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+			j, err := New(root, cfg, nil, nil, false)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			_, err = j.Sweep(time.Now())
+			if tt.wantErr != errors.Is(err, ErrSafetyCap) {
+				t.Errorf("Sweep() error = %v, want safety cap %v", err, tt.wantErr)
+			}
+			left, _ := filepath.Glob(filepath.Join(root, "junk", "*"))
+			if len(left) != tt.wantLeft {
+				t.Errorf("%d junk files left, want %d", len(left), tt.wantLeft)
+			}
+			if tt.wantErr && len(readAudit(t, cfg.AuditLog)) != 0 {
+				t.Error("a refused sweep should not record removals")
+			}
+		})
+	}
+}
+
+func TestSweep_DryRun(t *testing.T) {
+	root := t.TempDir()
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeOld(t, root, 48*time.Hour, "junk.log")
+
+	j, err := New(root, config.JanitorConfig{
+		Rules:             []config.JanitorRule{{Pattern: "*.log", OlderThan: time.Hour, Action: config.JanitorDelete}},
+		OverrideSafetyCap: true,
+		AuditLog:          audit,
+	}, nil, nil, true)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	stats, err := j.Sweep(time.Now())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if stats.Matched != 1 || stats.Deleted != 0 {
+		t.Errorf("stats = %+v, want 1 matched and nothing deleted", stats)
+	}
+	if !exists(filepath.Join(root, "junk.log")) {
+		t.Error("dry run removed a file")
+	}
+	if exists(audit) {
+		t.Error("dry run should not write the audit log")
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	root := t.TempDir()
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeOld(t, root, 48*time.Hour, "a/junk.log", "b/junk.log", "keep.csv")
+
+	j, err := New(root, config.JanitorConfig{
+		Rules:             []config.JanitorRule{{Pattern: "**/*.log", OlderThan: time.Hour, Action: config.JanitorDelete}},
+		OverrideSafetyCap: true,
+		AuditLog:          audit,
+	}, nil, nil, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	opts := maintenance.Options{PlanOut: planPath, AuditLog: filepath.Join(t.TempDir(), "maintenance.jsonl")}
+	report, err := maintenance.Run(j, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Count != 2 || report.Actions[config.JanitorDelete] != 2 || report.Executed {
+		t.Fatalf("report = %+v, want 2 files planned for deletion", report)
+	}
+
+	// A producer reuses a planned name before the plan is applied
+	writeOld(t, root, 0, "b/junk.log")
+	apply := maintenance.Options{ApplyPlan: planPath, Yes: true, AuditLog: opts.AuditLog}
+	if _, err := maintenance.Run(j, apply, time.Now()); !errors.Is(err, maintenance.ErrDrift) {
+		t.Fatalf("Run(apply) error = %v, want ErrDrift", err)
+	}
+	if !exists(filepath.Join(root, "a", "junk.log")) || !exists(filepath.Join(root, "b", "junk.log")) {
+		t.Fatal("a drifted plan removed files")
+	}
+
+	writeOld(t, root, 48*time.Hour, "b/junk.log")
+	if _, err := maintenance.Run(j, opts, time.Now()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report, err := maintenance.Run(j, apply, time.Now()); err != nil || report.Applied != 2 {
+		t.Fatalf("Run(apply) = %+v, %v, want 2 files applied", report, err)
+	}
+	if exists(filepath.Join(root, "a", "junk.log")) || !exists(filepath.Join(root, "keep.csv")) {
+		t.Error("applied plan did not remove exactly the planned files")
+	}
+	if records := readAudit(t, audit); len(records) != 2 || records[0].Action != ActionDeleted {
+		t.Errorf("janitor audit = %+v, want both deletions", records)
+	}
+}
+
+func TestApply_RefusesUnmatchedItem(t *testing.T) {
+	root := t.TempDir()
+	writeOld(t, root, 48*time.Hour, "keep.csv", "junk.log")
+	j, err := New(root, config.JanitorConfig{
+		Rules:    []config.JanitorRule{{Pattern: "*.log", OlderThan: time.Hour, Action: config.JanitorDelete}},
+		AuditLog: filepath.Join(t.TempDir(), "audit.jsonl"),
+	}, []string{"*.csv"}, nil, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, item := range []maintenance.Item{
+		{Path: filepath.Join(root, "keep.csv"), Action: config.JanitorDelete, Detail: "*.log"},
+		{Path: filepath.Join(root, "junk.log"), Action: config.JanitorDelete, Detail: "*"},
+		{Path: filepath.Join(root, "junk.log"), Action: config.JanitorTrash, Detail: "*.log"},
+		{Path: filepath.Join(filepath.Dir(root), "junk.log"), Action: config.JanitorDelete, Detail: "*.log"},
+	} {
+		if err := j.Apply(item); err == nil {
+			t.Errorf("Apply(%+v) succeeded, want error", item)
+		}
+	}
+	if !exists(filepath.Join(root, "keep.csv")) || !exists(filepath.Join(root, "junk.log")) {
+		t.Error("refused items removed")
+	}
+}
+
+// unwatchRecorder is a Tracker recording the directories unwatched
+type unwatchRecorder struct {
+	unwatched []string
+}
+
+func (u *unwatchRecorder) IsTracked(string) bool { return false }
+
+func (u *unwatchRecorder) Unwatch(path string) error {
+	u.unwatched = append(u.unwatched, path)
+	return nil
+}
+
+// mkdirOld creates the named directories under root with an mtime of age
+// ago, deepest last so creating one does not move the mtime of another
+func mkdirOld(t *testing.T, root string, age time.Duration, names ...string) {
+	t.Helper()
+	mtime := time.Now().Add(-age)
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(root, name), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := os.Chtimes(filepath.Join(root, names[i]), mtime, mtime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+}
+
+func TestSweep_RemovesIdleEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	audit := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeOld(t, root, time.Minute, "busy/sub/new.csv")
+	mkdirOld(t, root, 48*time.Hour,
+		"2026-01-01", "2026-01-01/a", "2026-01-01/a/b", "2026-01-01/c",
+		"tomorrow", "busy", "busy/idle")
+	// A file of it was just ingested, moving its mtime
+	if err := os.Mkdir(filepath.Join(root, "active"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	tracker := &unwatchRecorder{}
+	j, err := New(root, config.JanitorConfig{
+		AuditLog:  audit,
+		EmptyDirs: &config.JanitorEmptyDirs{OlderThan: 24 * time.Hour, Keep: []string{"tomorrow"}},
+	}, nil, tracker, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	stats, err := j.Sweep(time.Now())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	// Deepest first, in reverse walk order
+	removed := []string{"busy/idle", "2026-01-01/c", "2026-01-01/a/b", "2026-01-01/a", "2026-01-01"}
+	if stats.RemovedDirs != len(removed) {
+		t.Errorf("removed %d directories, want %d", stats.RemovedDirs, len(removed))
+	}
+	for _, name := range removed {
+		if exists(filepath.Join(root, name)) {
+			t.Errorf("idle empty directory %s was kept", name)
+		}
+	}
+	for _, name := range []string{"tomorrow", "active", "busy/sub"} {
+		if !exists(filepath.Join(root, name)) {
+			t.Errorf("directory %s was removed", name)
+		}
+	}
+	if !exists(root) {
+		t.Error("the input directory was removed")
+	}
+
+	records := readAudit(t, audit)
+	if len(records) != len(removed) {
+		t.Fatalf("audit records = %+v, want %d", records, len(removed))
+	}
+	for i, rec := range records {
+		if rec.Action != ActionRemovedDir || rec.Path != filepath.Join(root, removed[i]) {
+			t.Errorf("audit record %d = %+v, want removal of %s", i, rec, removed[i])
+		}
+		if len(tracker.unwatched) <= i || tracker.unwatched[i] != rec.Path {
+			t.Errorf("unwatched = %v, want %s unwatched", tracker.unwatched, rec.Path)
+		}
+	}
+}
+
+func TestRemoveDir_RechecksBeforeRemoval(t *testing.T) {
+	root := t.TempDir()
+	mkdirOld(t, root, 48*time.Hour, "filled", "touched", "idle")
+	j, err := New(root, config.JanitorConfig{
+		AuditLog:  filepath.Join(t.TempDir(), "audit.jsonl"),
+		EmptyDirs: &config.JanitorEmptyDirs{OlderThan: time.Hour},
+	}, nil, nil, false)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	walked := func(name string) emptyDir {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("failed to stat directory: %v", err)
+		}
+		return emptyDir{path: filepath.Join(root, name), modTime: info.ModTime()}
+	}
+
+	// A producer writes into the directories after the walk saw them
+	filled, touched := walked("filled"), walked("touched")
+	writeOld(t, root, 48*time.Hour, "filled/upload.csv")
+	writeOld(t, root, 0, "touched/upload.csv")
+	if err := os.Remove(filepath.Join(root, "touched", "upload.csv")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+
+	for _, d := range []emptyDir{filled, touched} {
+		rec, err := j.removeDir(d, false)
+		if err != nil || rec.Action != "" {
+			t.Errorf("removeDir(%s) = %+v, %v, want it left alone", d.path, rec, err)
+		}
+		if !exists(d.path) {
+			t.Errorf("%s was removed", d.path)
+		}
+	}
+	if rec, err := j.removeDir(walked("idle"), false); err != nil || rec.Action != ActionRemovedDir {
+		t.Errorf("removeDir(idle) = %+v, %v, want it removed", rec, err)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.JanitorConfig
+	}{
+		{"empty pattern", config.JanitorConfig{Rules: []config.JanitorRule{{OlderThan: time.Hour}}}},
+		{"no age", config.JanitorConfig{Rules: []config.JanitorRule{{Pattern: "*.log"}}}},
+		{"unknown action", config.JanitorConfig{Rules: []config.JanitorRule{{Pattern: "*.log", OlderThan: time.Hour, Action: "shred"}}}},
+		{"percent over 100", config.JanitorConfig{MaxPercent: 150}},
+		{"empty dirs without age", config.JanitorConfig{EmptyDirs: &config.JanitorEmptyDirs{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(t.TempDir(), tt.cfg, nil, nil, false); err == nil {
+				t.Error("New() should reject the config")
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+// Package layout resolves where every artifact the ingestor writes is
+// placed, from one named path template per artifact, so the warehouse,
+// manifest, quarantine and receipt layouts are configured and validated in
+// one place. Templates hold {variable} placeholders expanded from a Context
+// and are relative to the base directory of their artifact.
+package layout
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// Artifact names a kind of file the ingestor writes
+type Artifact string
+
+// Artifacts and the base directory their template is relative to
+const (
+	// Warehouse is an ingested file, below the warehouse
+	Warehouse Artifact = "warehouse"
+	// Manifest is a JSON Lines manifest file, below the manifests directory
+	Manifest Artifact = "manifest"
+	// Quarantine is a quarantined file, below the quarantine directory
+	Quarantine Artifact = "quarantine"
+	// QuarantineSet is the directory of a quarantined file set, below the
+	// quarantine directory
+	QuarantineSet Artifact = "quarantine_set"
+	// Receipt is the receipt of a source, below the receipts directory of
+	// the source's directory
+	Receipt Artifact = "receipt"
+)
+
+// Defaults are the templates of every artifact when none is configured
+var Defaults = map[Artifact]string{
+	Warehouse:     "{rel_path}",
+	Manifest:      "{yyyy}/{mm}/{dd}/{hh}/manifest.jsonl",
+	Quarantine:    "{sha256}{ext}",
+	QuarantineSet: "sets/{name}-{unix_nano}",
+	Receipt:       "{name}" + config.ReceiptSuffix,
+}
+
+// MaxExtBytes is the longest extension {ext} keeps
+const MaxExtBytes = 16
+
+// Variables usable in the templates of every artifact
+var (
+	timeVars = []string{"yyyy", "mm", "dd", "hh", "unix_nano"}
+	fileVars = []string{"rel_path", "rel_dir", "name", "stem", "ext"}
+	// sourceVars are the variables Files turns into wildcards
+	sourceVars = []string{"source", "tenant"}
+)
+
+// variables lists the variables each artifact may use. The warehouse path
+// of file sets and batches is resolved before their content is hashed, and
+// receipts and quarantined sets may have no content hash, so only
+// quarantined files use {sha256}. Manifest files gather many files, so
+// they use none of the variables of a single one.
+var variables = map[Artifact][]string{
+	Warehouse:     slices.Concat(sourceVars, timeVars, fileVars, []string{"instance"}),
+	Manifest:      slices.Concat(sourceVars, timeVars, []string{"instance"}),
+	Quarantine:    slices.Concat(sourceVars, timeVars, fileVars, []string{"instance", "sha256"}),
+	QuarantineSet: slices.Concat(sourceVars, timeVars, fileVars, []string{"instance"}),
+	Receipt:       slices.Concat(sourceVars, timeVars, fileVars, []string{"instance"}),
+}
+
+// Context holds the values the variables of a template expand to
+type Context struct {
+	// Source, {source}, is the top-level input directory of the file, "."
+	// for files directly in the input directory
+	Source string
+	// Tenant, {tenant}, is the tenant the file belongs to, its source
+	Tenant string
+	// RelPath is the slash-separated path of the file relative to the input
+	// directory. It gives {rel_path}, {rel_dir}, {name}, {stem} and {ext},
+	// the extension of the name unless longer than MaxExtBytes.
+	RelPath string
+	// Time gives {yyyy}, {mm}, {dd}, {hh} and {unix_nano}
+	Time time.Time
+	// SHA256, {sha256}, is the content hash of the file
+	SHA256 string
+	// Instance, {instance}, is the host the ingestor runs on, filled in
+	// by Resolve when empty
+	Instance string
+}
+
+// NewContext returns the context of the file at relPath, slash-separated and
+// relative to the input directory, at t
+func NewContext(relPath string, t time.Time) Context {
+	source := "."
+	if s, _, found := strings.Cut(relPath, "/"); found {
+		source = s
+	}
+	return Context{Source: source, Tenant: source, RelPath: relPath, Time: t}
+}
+
+// Ext returns the extension of name {ext} expands to
+func Ext(name string) string {
+	ext := path.Ext(name)
+	if len(ext) > MaxExtBytes {
+		return ""
+	}
+	return ext
+}
+
+// value returns the expansion of the variable name in c
+func (c Context) value(name string) string {
+	base := ""
+	if c.RelPath != "" {
+		base = path.Base(c.RelPath)
+	}
+	switch name {
+	case "source":
+		return c.Source
+	case "tenant":
+		return c.Tenant
+	case "rel_path":
+		return c.RelPath
+	case "rel_dir":
+		return path.Dir(c.RelPath)
+	case "name":
+		return base
+	case "stem":
+		return strings.TrimSuffix(base, Ext(base))
+	case "ext":
+		return Ext(base)
+	case "sha256":
+		return c.SHA256
+	case "yyyy":
+		return c.Time.Format("2006")
+	case "mm":
+		return c.Time.Format("01")
+	case "dd":
+		return c.Time.Format("02")
+	case "hh":
+		return c.Time.Format("15")
+	case "unix_nano":
+		return strconv.FormatInt(c.Time.UnixNano(), 10)
+	case "instance":
+		return c.Instance
+	}
+	return ""
+}
+
+// segment is a literal of a template or, when variable is set, one of its
+// placeholders
+type segment struct {
+	literal  string
+	variable string
+}
+
+// template is a parsed path template
+type template struct {
+	raw      string
+	segments []segment
+}
+
+// parse parses raw, accepting only the variables of artifact
+func parse(artifact Artifact, raw string) (template, error) {
+	t := template{raw: raw}
+	allowed := variables[artifact]
+	rest := raw
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			open = len(rest)
+		}
+		if strings.ContainsRune(rest[:open], '}') {
+			return t, fmt.Errorf("unmatched } in %q", raw)
+		}
+		if open > 0 {
+			t.segments = append(t.segments, segment{literal: rest[:open]})
+		}
+		rest = rest[open:]
+		if rest == "" {
+			break
+		}
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return t, fmt.Errorf("unclosed { in %q", raw)
+		}
+		name := rest[1:end]
+		if !slices.Contains(allowed, name) {
+			return t, fmt.Errorf("unknown variable {%s} in %q: the %s template takes {%s}", name, raw, artifact, strings.Join(allowed, "}, {"))
+		}
+		t.segments = append(t.segments, segment{variable: name})
+		rest = rest[end+1:]
+	}
+	if len(t.segments) == 0 {
+		return t, fmt.Errorf("empty %s template", artifact)
+	}
+	return t, nil
+}
+
+// expand returns the slash-separated path t expands to in c
+func (t template) expand(c Context) string {
+	var b strings.Builder
+	for _, s := range t.segments {
+		if s.variable == "" {
+			b.WriteString(s.literal)
+			continue
+		}
+		b.WriteString(c.value(s.variable))
+	}
+	return path.Clean(b.String())
+}
+
+// uses reports whether t has a placeholder of any of names
+func (t template) uses(names []string) bool {
+	for _, s := range t.segments {
+		if slices.Contains(names, s.variable) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolver expands the template of every artifact, the one configured for
+// the file's source when there is one. It is safe for concurrent use.
+type Resolver struct {
+	templates map[Artifact]template
+	// sources holds the templates configured for single sources
+	sources  map[string]map[Artifact]template
+	instance string
+}
+
+// Default returns the resolver of the default layouts
+func Default() *Resolver {
+	r, err := Compile(nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Compile validates the templates of the paths section of the
+// configuration file and those of every source, and returns their resolver.
+// Artifacts without a template keep their default.
+func Compile(templates map[string]string, sources []config.SourceConfig) (*Resolver, error) {
+	host, _ := os.Hostname()
+	r := &Resolver{
+		templates: make(map[Artifact]template, len(Defaults)),
+		sources:   make(map[string]map[Artifact]template),
+		instance:  host,
+	}
+	for artifact, raw := range Defaults {
+		t, err := parse(artifact, raw)
+		if err != nil {
+			return nil, err
+		}
+		r.templates[artifact] = t
+	}
+	compiled, err := compile(templates)
+	if err != nil {
+		return nil, err
+	}
+	for artifact, t := range compiled {
+		r.templates[artifact] = t
+	}
+	for _, s := range sources {
+		if len(s.Paths) == 0 {
+			continue
+		}
+		compiled, err := compile(s.Paths)
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", s.Name, err)
+		}
+		r.sources[s.Name] = compiled
+	}
+	return r, nil
+}
+
+// compile parses and checks the templates, keyed by artifact name
+func compile(templates map[string]string) (map[Artifact]template, error) {
+	compiled := make(map[Artifact]template, len(templates))
+	for name, raw := range templates {
+		artifact := Artifact(name)
+		if _, ok := Defaults[artifact]; !ok {
+			return nil, fmt.Errorf("unknown artifact %q", name)
+		}
+		t, err := parse(artifact, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", artifact, err)
+		}
+		if err := check(artifact, t); err != nil {
+			return nil, fmt.Errorf("%s: %w", artifact, err)
+		}
+		compiled[artifact] = t
+	}
+	return compiled, nil
+}
+
+// sample is the context templates are checked with
+var sample = Context{
+	Source:   "source",
+	Tenant:   "source",
+	RelPath:  "source/dir/name.csv",
+	Time:     time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+	SHA256:   strings.Repeat("0", 64),
+	Instance: "host",
+}
+
+// check rejects templates that leave the base of their artifact or break
+// what reads the artifact back
+func check(artifact Artifact, t template) error {
+	expanded := t.expand(sample)
+	if expanded == "." || !filepath.IsLocal(filepath.FromSlash(expanded)) {
+		return fmt.Errorf("%q expands to %q, outside its base directory", t.raw, expanded)
+	}
+	switch artifact {
+	case Manifest:
+		// Day finalization and late entries work on the day directories,
+		// and readers of the manifests on the files' name
+		if !strings.HasPrefix(t.raw, "{yyyy}/{mm}/{dd}/") || path.Base(t.raw) != "manifest.jsonl" {
+			return fmt.Errorf("%q: want {yyyy}/{mm}/{dd}/.../manifest.jsonl", t.raw)
+		}
+	case Receipt:
+		// Receipts are recognized, and expired, by their suffix
+		if !strings.HasSuffix(expanded, config.ReceiptSuffix) {
+			return fmt.Errorf("%q: want a name ending in %s", t.raw, config.ReceiptSuffix)
+		}
+	}
+	return nil
+}
+
+// template returns the template of artifact for source
+func (r *Resolver) template(artifact Artifact, source string) template {
+	if t, ok := r.sources[source][artifact]; ok {
+		return t
+	}
+	return r.templates[artifact]
+}
+
+// Resolve returns the path of artifact in c, relative to the artifact's
+// base directory
+func (r *Resolver) Resolve(artifact Artifact, c Context) (string, error) {
+	if c.Instance == "" {
+		c.Instance = r.instance
+	}
+	t := r.template(artifact, c.Source)
+	expanded := t.expand(c)
+	if expanded == "." || !filepath.IsLocal(filepath.FromSlash(expanded)) {
+		return "", fmt.Errorf("%s path %q of %s is outside its base directory", artifact, expanded, c.RelPath)
+	}
+	return filepath.FromSlash(expanded), nil
+}
+
+// Custom reports whether artifact has a template other than its default,
+// for any source
+func (r *Resolver) Custom(artifact Artifact) bool {
+	if r.templates[artifact].raw != Defaults[artifact] {
+		return true
+	}
+	for _, templates := range r.sources {
+		if _, ok := templates[artifact]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Templates returns the template of artifact, and those of the sources
+// configuring their own by source name
+func (r *Resolver) Templates(artifact Artifact) (string, map[string]string) {
+	sources := make(map[string]string)
+	for source, templates := range r.sources {
+		if t, ok := templates[artifact]; ok {
+			sources[source] = t.raw
+		}
+	}
+	return r.templates[artifact].raw, sources
+}
+
+// Files returns the paths below base artifact is written to at t, in every
+// source: those of templates using no source variable whether they exist
+// or not, and the existing ones matching the others along with their path
+// for files directly in the input directory
+func (r *Resolver) Files(artifact Artifact, base string, t time.Time) []string {
+	c := Context{Time: t, Instance: r.instance}
+	templates := []template{r.templates[artifact]}
+	for _, name := range slices.Sorted(maps.Keys(r.sources)) {
+		if s, ok := r.sources[name][artifact]; ok {
+			templates = append(templates, s)
+		}
+	}
+
+	var files []string
+	add := func(file string) {
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	for _, tmpl := range templates {
+		if !tmpl.uses(sourceVars) {
+			add(filepath.Join(base, filepath.FromSlash(tmpl.expand(c))))
+			continue
+		}
+		pattern := glob(tmpl, c)
+		matches, _ := filepath.Glob(filepath.Join(globEscape(base), filepath.FromSlash(pattern)))
+		for _, m := range matches {
+			add(m)
+		}
+		top := c
+		top.Source, top.Tenant = ".", "."
+		add(filepath.Join(base, filepath.FromSlash(tmpl.expand(top))))
+	}
+	return files
+}
+
+// glob returns the filepath.Match pattern of tmpl in c, with the source
+// variables matching any directory name
+func glob(tmpl template, c Context) string {
+	var b strings.Builder
+	for _, s := range tmpl.segments {
+		switch {
+		case s.variable == "":
+			b.WriteString(globEscape(s.literal))
+		case slices.Contains(sourceVars, s.variable):
+			b.WriteString("*")
+		default:
+			b.WriteString(globEscape(c.value(s.variable)))
+		}
+	}
+	return path.Clean(b.String())
+}
+
+// globEscape escapes the characters filepath.Match treats specially
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
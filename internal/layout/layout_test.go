@@ -0,0 +1,225 @@
+package layout
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+var at = time.Date(2024, 3, 7, 9, 5, 1, 42, time.UTC)
+
+// TestDefaults_Golden locks the paths the default templates produce, those
+// the ingestor wrote before layouts could be configured
+func TestDefaults_Golden(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		artifact Artifact
+		c        Context
+		want     string
+	}{
+		{Warehouse, NewContext("vendor/2024/data.csv", at), "vendor/2024/data.csv"},
+		{Warehouse, NewContext("top.csv", at), "top.csv"},
+		{Warehouse, NewContext("vendor/data.csv.gz", at), "vendor/data.csv.gz"},
+		{Manifest, Context{Source: "vendor", Time: at}, "2024/03/07/09/manifest.jsonl"},
+		{Manifest, Context{Source: ".", Time: at}, "2024/03/07/09/manifest.jsonl"},
+		{Quarantine, Context{RelPath: "vendor/data.csv", SHA256: hash}, hash + ".csv"},
+		{Quarantine, Context{RelPath: "vendor/README", SHA256: hash}, hash},
+		{Quarantine, Context{RelPath: "vendor/data.averyveryverylongext", SHA256: hash}, hash},
+		{Quarantine, Context{SHA256: hash}, hash},
+		{QuarantineSet, NewContext("vendor/file.csv", at), "sets/file.csv-1709802301000000042"},
+		{Receipt, NewContext("vendor/data.csv", at), "data.csv.receipt.json"},
+		{Receipt, NewContext("top.csv", at), "top.csv.receipt.json"},
+	}
+	r := Default()
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.artifact, tt.c)
+		if err != nil {
+			t.Errorf("Resolve(%s, %+v) error = %v", tt.artifact, tt.c, err)
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Resolve(%s, %+v) = %q, want %q", tt.artifact, tt.c, got, want)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	c := NewContext("acme/in/2024/data.tar.gz", at)
+	c.SHA256 = "cafe"
+	c.Instance = "ingest-1"
+	tests := map[string]string{
+		"source":    "acme",
+		"tenant":    "acme",
+		"rel_path":  "acme/in/2024/data.tar.gz",
+		"rel_dir":   "acme/in/2024",
+		"name":      "data.tar.gz",
+		"stem":      "data.tar",
+		"ext":       ".gz",
+		"sha256":    "cafe",
+		"yyyy":      "2024",
+		"mm":        "03",
+		"dd":        "07",
+		"hh":        "09",
+		"unix_nano": "1709802301000000042",
+		"instance":  "ingest-1",
+	}
+	for name, want := range tests {
+		if got := c.value(name); got != want {
+			t.Errorf("{%s} = %q, want %q", name, got, want)
+		}
+	}
+	for _, vars := range variables {
+		for _, name := range vars {
+			if _, ok := tests[name]; !ok {
+				t.Errorf("variable {%s} is not tested", name)
+			}
+		}
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	if c := NewContext("acme/a.csv", at); c.Source != "acme" || c.Tenant != "acme" {
+		t.Errorf("context = %+v, want source and tenant acme", c)
+	}
+	if c := NewContext("a.csv", at); c.Source != "." || c.Tenant != "." {
+		t.Errorf("context = %+v, want top-level files in source .", c)
+	}
+}
+
+func TestExt(t *testing.T) {
+	tests := map[string]string{
+		"data.csv":                              ".csv",
+		"README":                                "",
+		".hidden":                               ".hidden",
+		"data." + strings.Repeat("x", 15):       "." + strings.Repeat("x", 15),
+		"data." + strings.Repeat("x", 16):       "",
+		"archive.tar.gz":                        ".gz",
+		"dir.d/file":                            "",
+		"name.with.many.dots.and.a.long.suffix": ".suffix",
+	}
+	for name, want := range tests {
+		if got := Ext(name); got != want {
+			t.Errorf("Ext(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	r, err := Compile(
+		map[string]string{
+			"warehouse":  "{source}/{yyyy}/{mm}/{name}",
+			"quarantine": "{source}/{sha256}{ext}",
+		},
+		[]config.SourceConfig{
+			{Name: "acme", Paths: map[string]string{"warehouse": "{tenant}/by-host/{instance}/{rel_path}"}},
+			{Name: "plain"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	c := NewContext("acme/in/a.csv", at)
+	c.Instance = "host"
+	tests := []struct {
+		artifact Artifact
+		c        Context
+		want     string
+	}{
+		{Warehouse, NewContext("plain/in/a.csv", at), "plain/2024/03/a.csv"},
+		{Warehouse, c, "acme/by-host/host/acme/in/a.csv"},
+		{Quarantine, Context{Source: "plain", RelPath: "plain/b.json", SHA256: "ff"}, "plain/ff.json"},
+		// Artifacts without a template keep their default
+		{Receipt, NewContext("plain/a.csv", at), "a.csv.receipt.json"},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.artifact, tt.c)
+		if err != nil {
+			t.Errorf("Resolve(%s, %+v) error = %v", tt.artifact, tt.c, err)
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Resolve(%s, %+v) = %q, want %q", tt.artifact, tt.c, got, want)
+		}
+	}
+	if !r.Custom(Warehouse) || !r.Custom(Quarantine) || r.Custom(Manifest) || r.Custom(Receipt) {
+		t.Error("Custom() does not report exactly the configured artifacts")
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]string
+		sources   []config.SourceConfig
+	}{
+		{"unknown artifact", map[string]string{"checksum": "{rel_path}.sha256"}, nil},
+		{"empty template", map[string]string{"warehouse": ""}, nil},
+		{"unknown variable", map[string]string{"warehouse": "{date}/{name}"}, nil},
+		{"variable of another artifact", map[string]string{"warehouse": "{sha256}{ext}"}, nil},
+		{"file variable in manifest", map[string]string{"manifest": "{yyyy}/{mm}/{dd}/{name}/manifest.jsonl"}, nil},
+		{"unclosed brace", map[string]string{"warehouse": "{source/{name}"}, nil},
+		{"unmatched brace", map[string]string{"warehouse": "source}/{name}"}, nil},
+		{"absolute", map[string]string{"warehouse": "/data/{rel_path}"}, nil},
+		{"parent", map[string]string{"quarantine": "../{sha256}"}, nil},
+		{"expands to base", map[string]string{"warehouse": "{name}/.."}, nil},
+		{"manifest without day", map[string]string{"manifest": "{source}/{yyyy}/{mm}/{dd}/manifest.jsonl"}, nil},
+		{"manifest name", map[string]string{"manifest": "{yyyy}/{mm}/{dd}/{hh}.jsonl"}, nil},
+		{"receipt suffix", map[string]string{"receipt": "{name}.json"}, nil},
+		{"invalid source template", nil, []config.SourceConfig{{Name: "acme", Paths: map[string]string{"receipt": "../{name}.receipt.json"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.templates, tt.sources); err == nil {
+				t.Error("Compile() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestResolve_OutsideBase(t *testing.T) {
+	r, err := Compile(map[string]string{"warehouse": "{source}/{name}"}, nil)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if got, err := r.Resolve(Warehouse, Context{Source: "..", RelPath: "../a.csv"}); err == nil {
+		t.Errorf("Resolve() = %q, want error for a path outside the warehouse", got)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	base := t.TempDir()
+	r, err := Compile(nil, []config.SourceConfig{
+		{Name: "acme", Paths: map[string]string{"manifest": "{yyyy}/{mm}/{dd}/{hh}/{source}/manifest.jsonl"}},
+	})
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	for _, rel := range []string{"2024/03/07/09/acme/manifest.jsonl", "2024/03/07/10/acme/manifest.jsonl"} {
+		path := filepath.Join(base, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := r.Files(Manifest, base, at)
+	want := []string{
+		filepath.Join(base, "2024", "03", "07", "09", "manifest.jsonl"),
+		filepath.Join(base, "2024", "03", "07", "09", "acme", "manifest.jsonl"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+
+	// The default layout names the hour's file whether it exists or not
+	if got := Default().Files(Manifest, base, at.Add(time.Hour)); len(got) != 1 || got[0] != filepath.Join(base, "2024", "03", "07", "10", "manifest.jsonl") {
+		t.Errorf("Files() = %v, want the default hour file", got)
+	}
+}
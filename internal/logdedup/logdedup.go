@@ -0,0 +1,166 @@
+// Package logdedup wraps a slog.Handler so that a warning or error repeated
+// many times in a short window is logged once, followed by a single summary
+// carrying the number of repeats
+package logdedup
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RepeatCountKey is the attribute holding the number of suppressed repeats
+// on a summary record
+const RepeatCountKey = "repeat_count"
+
+// ErrorKey is the attribute that, together with the message, identifies
+// repeats of the same record
+const ErrorKey = "error"
+
+// Handler deduplicates records at slog.LevelWarn and above. The first record
+// for a (message, error) pair is passed through immediately; identical ones
+// within the window are counted, and when the window closes the last of them
+// is emitted with RepeatCountKey. Debug and info records are never held.
+type Handler struct {
+	next  slog.Handler
+	id    string
+	state *state
+}
+
+// state is shared by a Handler and every handler derived from it
+type state struct {
+	window time.Duration
+	ids    atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]*pending
+	closed  bool
+}
+
+// pending tracks one deduplication window
+type pending struct {
+	next       slog.Handler
+	last       slog.Record
+	suppressed int
+	timer      *time.Timer
+}
+
+// New wraps next, deduplicating repeats within window
+func New(next slog.Handler, window time.Duration) *Handler {
+	return &Handler{
+		next:  next,
+		id:    "0",
+		state: &state{window: window, pending: make(map[string]*pending)},
+	}
+}
+
+// Enabled implements slog.Handler
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// WithAttrs implements slog.Handler. Records logged through the derived
+// handler are deduplicated separately from those of h.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derive(h.next.WithAttrs(attrs))
+}
+
+// WithGroup implements slog.Handler
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return h.derive(h.next.WithGroup(name))
+}
+
+func (h *Handler) derive(next slog.Handler) *Handler {
+	return &Handler{
+		next:  next,
+		id:    strconv.FormatUint(h.state.ids.Add(1), 10),
+		state: h.state,
+	}
+}
+
+// Handle implements slog.Handler
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn || h.state.window <= 0 {
+		return h.next.Handle(ctx, r)
+	}
+
+	key := h.id + "\x00" + r.Message + "\x00" + errorOf(r)
+
+	s := h.state
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return h.next.Handle(ctx, r)
+	}
+	if p, ok := s.pending[key]; ok {
+		p.last = r.Clone()
+		p.suppressed++
+		s.mu.Unlock()
+		return nil
+	}
+	p := &pending{next: h.next}
+	p.timer = time.AfterFunc(s.window, func() { s.expire(key, p) })
+	s.pending[key] = p
+	s.mu.Unlock()
+
+	return h.next.Handle(ctx, r)
+}
+
+// expire ends the window of key and emits its summary
+func (s *state) expire(key string, p *pending) {
+	s.mu.Lock()
+	if s.pending[key] != p {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, key)
+	s.mu.Unlock()
+
+	p.emit()
+}
+
+// emit writes the summary of a window that suppressed at least one record
+func (p *pending) emit() {
+	if p.suppressed == 0 {
+		return
+	}
+	r := p.last.Clone()
+	r.AddAttrs(slog.Int(RepeatCountKey, p.suppressed))
+	_ = p.next.Handle(context.Background(), r)
+}
+
+// Close emits the summaries of all open windows and stops deduplicating;
+// records handled afterwards pass straight through. Call it before exiting
+// so no repeat counts are lost.
+func (h *Handler) Close() {
+	s := h.state
+	s.mu.Lock()
+	open := make([]*pending, 0, len(s.pending))
+	for key, p := range s.pending {
+		p.timer.Stop()
+		open = append(open, p)
+		delete(s.pending, key)
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, p := range open {
+		p.emit()
+	}
+}
+
+// errorOf returns the string form of the record's error attribute
+func errorOf(r slog.Record) string {
+	var value string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == ErrorKey {
+			value = a.Value.String()
+			return false
+		}
+		return true
+	})
+	return value
+}
@@ -0,0 +1,166 @@
+package logdedup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a slog.Handler keeping every record it receives
+type recorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (r *recorder) Enabled(context.Context, slog.Level) bool { return true }
+func (r *recorder) WithAttrs([]slog.Attr) slog.Handler       { return r }
+func (r *recorder) WithGroup(string) slog.Handler            { return r }
+
+func (r *recorder) Handle(_ context.Context, rec slog.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec.Clone())
+	return nil
+}
+
+func (r *recorder) snapshot() []slog.Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]slog.Record(nil), r.records...)
+}
+
+// repeatCount returns the repeat_count attribute of rec, or 0 without one
+func repeatCount(rec slog.Record) int64 {
+	var n int64
+	rec.Attrs(func(a slog.Attr) bool {
+		if a.Key == RepeatCountKey {
+			n = a.Value.Int64()
+			return false
+		}
+		return true
+	})
+	return n
+}
+
+func TestHandler_SuppressesRepeatsUntilClose(t *testing.T) {
+	rec := &recorder{}
+	h := New(rec, time.Hour)
+	logger := slog.New(h)
+
+	for i := 0; i < 5; i++ {
+		logger.Error("database locked", "error", errors.New("busy"), "attempt", i)
+	}
+	logger.Error("database locked", "error", errors.New("disk full"))
+
+	records := rec.snapshot()
+	if len(records) != 2 {
+		t.Fatalf("got %d records before close, want 2 first occurrences", len(records))
+	}
+	for _, r := range records {
+		if repeatCount(r) != 0 {
+			t.Errorf("first occurrence carries %s", RepeatCountKey)
+		}
+	}
+
+	h.Close()
+
+	records = rec.snapshot()
+	if len(records) != 3 {
+		t.Fatalf("got %d records after close, want 3", len(records))
+	}
+	if n := repeatCount(records[2]); n != 4 {
+		t.Errorf("%s = %d, want 4", RepeatCountKey, n)
+	}
+
+	// After Close records pass straight through
+	logger.Error("database locked", "error", errors.New("busy"))
+	if n := len(rec.snapshot()); n != 4 {
+		t.Errorf("got %d records, want 4", n)
+	}
+}
+
+func TestHandler_EmitsSummaryWhenWindowCloses(t *testing.T) {
+	rec := &recorder{}
+	h := New(rec, 20*time.Millisecond)
+	defer h.Close()
+	logger := slog.New(h)
+
+	logger.Warn("warehouse unreachable", "error", "timeout")
+	logger.Warn("warehouse unreachable", "error", "timeout")
+	logger.Warn("warehouse unreachable", "error", "timeout")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rec.snapshot()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	records := rec.snapshot()
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want first occurrence and summary", len(records))
+	}
+	if n := repeatCount(records[1]); n != 2 {
+		t.Errorf("%s = %d, want 2", RepeatCountKey, n)
+	}
+
+	// A new window starts with a fresh first occurrence
+	logger.Warn("warehouse unreachable", "error", "timeout")
+	if n := len(rec.snapshot()); n != 3 {
+		t.Errorf("got %d records, want 3", n)
+	}
+}
+
+func TestHandler_NoSummaryWithoutRepeats(t *testing.T) {
+	rec := &recorder{}
+	h := New(rec, time.Hour)
+	slog.New(h).Error("once", "error", "boom")
+	h.Close()
+
+	if n := len(rec.snapshot()); n != 1 {
+		t.Errorf("got %d records, want 1", n)
+	}
+}
+
+func TestHandler_BypassesDebugAndInfo(t *testing.T) {
+	rec := &recorder{}
+	h := New(rec, time.Hour)
+	defer h.Close()
+	logger := slog.New(h)
+
+	for i := 0; i < 3; i++ {
+		logger.Debug("polling")
+		logger.Info("file processed", "error", "none")
+	}
+	if n := len(rec.snapshot()); n != 6 {
+		t.Errorf("got %d records, want all 6", n)
+	}
+}
+
+func TestHandler_Concurrent(t *testing.T) {
+	rec := &recorder{}
+	h := New(rec, time.Hour)
+	logger := slog.New(h)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Error("failed to process file", "error", "locked")
+			}
+		}()
+	}
+	wg.Wait()
+	h.Close()
+
+	records := rec.snapshot()
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if n := repeatCount(records[1]); n != 799 {
+		t.Errorf("%s = %d, want 799", RepeatCountKey, n)
+	}
+}
@@ -0,0 +1,339 @@
+// Package maintenance runs the operations that delete or move data, such as
+// sweeping the trash or the janitor, through one harness. An operation
+// plans the files it would act on; the harness reports the plan and only
+// executes it when confirmed, auditing every action. A plan written out can
+// be applied later once reviewed, provided none of its files changed.
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// SampleSize is how many paths a Report lists
+const SampleSize = 10
+
+// ErrNotConfirmed is returned by Run for a plan not executed because it
+// exceeds Options.YesIfUnder
+var ErrNotConfirmed = errors.New("plan exceeds the confirmation cap")
+
+// ErrDrift is returned by Run, wrapped in a DriftError, for a plan whose
+// files changed since it was written
+var ErrDrift = errors.New("plan drifted")
+
+// Operation is a destructive operation run by Run
+type Operation interface {
+	// Name names the operation in plans and audit records
+	Name() string
+	// Plan lists the files the operation acts on at now
+	Plan(now time.Time) ([]Item, error)
+	// Apply acts on one planned item. It must refuse items it would not
+	// have planned, as a plan file may have been edited.
+	Apply(item Item) error
+}
+
+// Item is a file an operation acts on
+type Item struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// SHA256 is set for the items of a plan written to a file, checked
+	// again before the plan is applied
+	SHA256 string `json:"sha256,omitempty"`
+	// Action is what the operation does to the file, such as delete
+	Action string `json:"action"`
+	// Detail is operation-specific, such as the rule that matched
+	Detail string `json:"detail,omitempty"`
+}
+
+// Invocation identifies the command that wrote a plan or executed it
+type Invocation struct {
+	Args []string `json:"args"`
+	User string   `json:"user,omitempty"`
+	Host string   `json:"host,omitempty"`
+	PID  int      `json:"pid"`
+}
+
+// CurrentInvocation returns the invocation of the running process
+func CurrentInvocation() Invocation {
+	inv := Invocation{Args: os.Args, PID: os.Getpid()}
+	if u, err := user.Current(); err == nil {
+		inv.User = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		inv.Host = host
+	}
+	return inv
+}
+
+// Plan is the reviewed form of an operation's items, as written by
+// Options.PlanOut
+type Plan struct {
+	Operation  string     `json:"operation"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Invocation Invocation `json:"invocation"`
+	Items      []Item     `json:"items"`
+}
+
+// Options configures Run
+type Options struct {
+	// Yes executes the plan; without it, or YesIfUnder, Run only reports
+	Yes bool
+	// YesIfUnder executes the plan when it has fewer items, and fails
+	// with ErrNotConfirmed otherwise
+	YesIfUnder int
+	// PlanOut writes the plan, with the hashes of its files, to this file
+	PlanOut string
+	// ApplyPlan runs the plan of this file instead of planning anew,
+	// failing with ErrDrift when any of its files changed
+	ApplyPlan string
+	// AuditLog is the JSON Lines file every executed action is appended to
+	AuditLog string
+	// Invocation is recorded in plans and audit records
+	Invocation Invocation
+}
+
+// RegisterFlags registers the flags of the options on fs, shared by every
+// destructive command
+func (o *Options) RegisterFlags(fs *flag.FlagSet, defaultAuditLog string) {
+	fs.BoolVar(&o.Yes, "yes", false, "Execute the plan; without it the command only reports what it would do")
+	fs.IntVar(&o.YesIfUnder, "yes-if-under", 0, "Execute the plan only when it has fewer than this many items, failing otherwise (0 disables)")
+	fs.StringVar(&o.PlanOut, "plan-out", "", "Write the plan, with the hashes of its files, to this JSON file for review")
+	fs.StringVar(&o.ApplyPlan, "apply-plan", "", "Apply the plan of this JSON file, written by -plan-out, refusing it if any of its files changed")
+	fs.StringVar(&o.AuditLog, "audit-log", defaultAuditLog, "JSON Lines file every executed action is recorded in")
+}
+
+// Report summarizes a plan and what Run did with it
+type Report struct {
+	Operation string         `json:"operation"`
+	Count     int            `json:"count"`
+	Bytes     int64          `json:"bytes"`
+	Actions   map[string]int `json:"actions"`
+	Samples   []string       `json:"samples"`
+	// Executed is set when the plan was executed; Applied and
+	// AppliedBytes count the items acted on
+	Executed     bool  `json:"executed"`
+	Applied      int   `json:"applied"`
+	AppliedBytes int64 `json:"applied_bytes"`
+}
+
+// AuditRecord is one line of the audit log
+type AuditRecord struct {
+	Time       time.Time  `json:"time"`
+	Operation  string     `json:"operation"`
+	Invocation Invocation `json:"invocation"`
+	Item
+}
+
+// Drift is a planned item that changed since the plan was written
+type Drift struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// DriftError lists the items of a plan that changed
+type DriftError struct {
+	Items []Drift
+}
+
+func (e *DriftError) Error() string {
+	reasons := make([]string, 0, len(e.Items))
+	for _, d := range e.Items {
+		reasons = append(reasons, d.Path+": "+d.Reason)
+	}
+	return fmt.Sprintf("%s: %d items changed (%s)", ErrDrift, len(e.Items), strings.Join(reasons, "; "))
+}
+
+func (e *DriftError) Unwrap() error { return ErrDrift }
+
+// Run plans op at now, or loads the plan of opts.ApplyPlan, and executes
+// it when opts confirm it. The report is returned in every case, with what
+// was applied before a failing action.
+func Run(op Operation, opts Options, now time.Time) (Report, error) {
+	var items []Item
+	if opts.ApplyPlan != "" {
+		plan, err := ReadPlan(opts.ApplyPlan)
+		if err != nil {
+			return Report{Operation: op.Name()}, err
+		}
+		if plan.Operation != op.Name() {
+			return Report{Operation: op.Name()}, fmt.Errorf("plan %s is of %s, not %s", opts.ApplyPlan, plan.Operation, op.Name())
+		}
+		if err := Verify(plan.Items); err != nil {
+			return Report{Operation: op.Name()}, err
+		}
+		items = plan.Items
+	} else {
+		var err error
+		items, err = op.Plan(now)
+		if err != nil {
+			return Report{Operation: op.Name()}, fmt.Errorf("plan %s: %w", op.Name(), err)
+		}
+	}
+
+	if opts.PlanOut != "" {
+		if err := writePlan(opts.PlanOut, Plan{Operation: op.Name(), CreatedAt: now, Invocation: opts.Invocation, Items: items}); err != nil {
+			return summarize(op.Name(), items), err
+		}
+	}
+
+	report := summarize(op.Name(), items)
+	switch {
+	case opts.Yes:
+	case opts.YesIfUnder > 0 && len(items) < opts.YesIfUnder:
+	case opts.YesIfUnder > 0:
+		return report, fmt.Errorf("%w: %d items, -yes-if-under is %d", ErrNotConfirmed, len(items), opts.YesIfUnder)
+	default:
+		return report, nil
+	}
+
+	report.Executed = true
+	if len(items) == 0 {
+		return report, nil
+	}
+	audit, err := os.OpenFile(opts.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return report, fmt.Errorf("open audit log: %w", err)
+	}
+	defer func() { _ = audit.Close() }()
+
+	for _, item := range items {
+		if err := op.Apply(item); err != nil {
+			return report, fmt.Errorf("%s %s: %w", item.Action, item.Path, err)
+		}
+		report.Applied++
+		report.AppliedBytes += item.Size
+		rec := AuditRecord{Time: time.Now(), Operation: op.Name(), Invocation: opts.Invocation, Item: item}
+		if err := appendAudit(audit, rec); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// summarize returns the report of the plan of items, not yet executed
+func summarize(operation string, items []Item) Report {
+	r := Report{Operation: operation, Actions: make(map[string]int), Samples: make([]string, 0)}
+	for _, item := range items {
+		r.Count++
+		r.Bytes += item.Size
+		r.Actions[item.Action]++
+		if len(r.Samples) < SampleSize {
+			r.Samples = append(r.Samples, item.Path)
+		}
+	}
+	return r
+}
+
+// Verify returns a DriftError listing the items whose file is gone, is no
+// longer a regular file or changed in size, modification time or, when
+// recorded, content
+func Verify(items []Item) error {
+	var drifted []Drift
+	for _, item := range items {
+		if reason := drift(item); reason != "" {
+			drifted = append(drifted, Drift{Path: item.Path, Reason: reason})
+		}
+	}
+	if len(drifted) > 0 {
+		return &DriftError{Items: drifted}
+	}
+	return nil
+}
+
+// drift returns why the file of item changed, empty when it did not
+func drift(item Item) string {
+	info, err := os.Lstat(item.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "missing"
+	case err != nil:
+		return err.Error()
+	case !info.Mode().IsRegular():
+		return "not a regular file"
+	case info.Size() != item.Size:
+		return fmt.Sprintf("size %d, planned %d", info.Size(), item.Size)
+	case !info.ModTime().Equal(item.ModTime):
+		return fmt.Sprintf("modified at %s, planned %s", info.ModTime().Format(time.RFC3339Nano), item.ModTime.Format(time.RFC3339Nano))
+	}
+	if item.SHA256 == "" {
+		return ""
+	}
+	hash, err := fileops.CalculateSHA256(item.Path)
+	if err != nil {
+		return err.Error()
+	}
+	if hash != item.SHA256 {
+		return "content changed"
+	}
+	return ""
+}
+
+// ReadPlan reads a plan written by Options.PlanOut
+func ReadPlan(path string) (Plan, error) {
+	var plan Plan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return plan, fmt.Errorf("read plan: %w", err)
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("parse plan %s: %w", path, err)
+	}
+	return plan, nil
+}
+
+// writePlan hashes the files of plan and writes it to path
+func writePlan(path string, plan Plan) error {
+	for i := range plan.Items {
+		hash, err := fileops.CalculateSHA256(plan.Items[i].Path)
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", plan.Items[i].Path, err)
+		}
+		plan.Items[i].SHA256 = hash
+	}
+	if plan.Items == nil {
+		plan.Items = make([]Item, 0)
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write plan: %w", err)
+	}
+	return nil
+}
+
+// AppendAudit appends rec to the audit log at path, for the destructive
+// actions taken outside Run
+func AppendAudit(path string, rec AuditRecord) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	return errors.Join(appendAudit(f, rec), f.Close())
+}
+
+// appendAudit appends rec to the audit log and syncs it
+func appendAudit(f *os.File, rec AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package maintenance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// removeAll is an operation deleting every file of a directory
+type removeAll struct{ dir string }
+
+func (removeAll) Name() string { return "remove-all" }
+
+func (o removeAll) Plan(time.Time) ([]Item, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, Item{Path: filepath.Join(o.dir, e.Name()), Size: info.Size(), ModTime: info.ModTime(), Action: "delete"})
+	}
+	return items, nil
+}
+
+func (o removeAll) Apply(item Item) error {
+	if filepath.Dir(item.Path) != o.dir {
+		return fmt.Errorf("%s is not in %s", item.Path, o.dir)
+	}
+	return os.Remove(item.Path)
+}
+
+// setup returns an operation over a directory of n files and options whose
+// audit log is in a temporary directory
+func setup(t *testing.T, n int) (removeAll, Options) {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range n {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d", i)), []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return removeAll{dir: dir}, Options{AuditLog: filepath.Join(t.TempDir(), "audit.jsonl"), Invocation: Invocation{Args: []string{"test"}}}
+}
+
+// remaining counts the files left in the directory of op
+func remaining(t *testing.T, op removeAll) int {
+	t.Helper()
+	entries, err := os.ReadDir(op.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestRun_DryRunByDefault(t *testing.T) {
+	op, opts := setup(t, 3)
+	report, err := Run(op, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Executed || report.Count != 3 || report.Bytes != 12 || report.Actions["delete"] != 3 || len(report.Samples) != 3 {
+		t.Errorf("report = %+v, want the plan of 3 files, not executed", report)
+	}
+	if n := remaining(t, op); n != 3 {
+		t.Errorf("%d files left, want all of them", n)
+	}
+	if _, err := os.Stat(opts.AuditLog); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("audit log written by a dry run: %v", err)
+	}
+}
+
+func TestRun_Yes(t *testing.T) {
+	op, opts := setup(t, 2)
+	opts.Yes = true
+	report, err := Run(op, opts, time.Now())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !report.Executed || report.Applied != 2 || report.AppliedBytes != 8 {
+		t.Errorf("report = %+v, want 2 files applied", report)
+	}
+	if n := remaining(t, op); n != 0 {
+		t.Errorf("%d files left, want none", n)
+	}
+
+	f, err := os.Open(opts.AuditLog)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	var records []AuditRecord
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit record: %v", err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 || records[0].Operation != "remove-all" || records[0].Action != "delete" || len(records[0].Invocation.Args) != 1 {
+		t.Errorf("audit records = %+v, want one per deleted file with the invocation", records)
+	}
+}
+
+func TestRun_YesIfUnder(t *testing.T) {
+	op, opts := setup(t, 3)
+	opts.YesIfUnder = 3
+	report, err := Run(op, opts, time.Now())
+	if !errors.Is(err, ErrNotConfirmed) || report.Executed {
+		t.Fatalf("Run() = %+v, %v, want ErrNotConfirmed", report, err)
+	}
+	if n := remaining(t, op); n != 3 {
+		t.Errorf("%d files left, want all of them", n)
+	}
+
+	opts.YesIfUnder = 4
+	if report, err := Run(op, opts, time.Now()); err != nil || report.Applied != 3 {
+		t.Errorf("Run() = %+v, %v, want 3 files applied", report, err)
+	}
+}
+
+func TestRun_PlanOutApplyPlan(t *testing.T) {
+	op, opts := setup(t, 3)
+	opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	if _, err := Run(op, opts, time.Now()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	plan, err := ReadPlan(opts.PlanOut)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+	if plan.Operation != "remove-all" || len(plan.Items) != 3 || plan.Items[0].SHA256 == "" {
+		t.Fatalf("plan = %+v, want 3 hashed items", plan)
+	}
+
+	apply := Options{ApplyPlan: opts.PlanOut, Yes: true, AuditLog: opts.AuditLog}
+	// A new file is not in the reviewed plan
+	if err := os.WriteFile(filepath.Join(op.dir, "new"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(op, apply, time.Now())
+	if err != nil {
+		t.Fatalf("Run(apply) error = %v", err)
+	}
+	if report.Applied != 3 {
+		t.Errorf("applied %d items, want the 3 of the plan", report.Applied)
+	}
+	if n := remaining(t, op); n != 1 {
+		t.Errorf("%d files left, want the one planned after", n)
+	}
+}
+
+func TestRun_ApplyPlanDrift(t *testing.T) {
+	tests := map[string]func(t *testing.T, path string){
+		"removed": func(t *testing.T, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"resized": func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("longer data"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"touched": func(t *testing.T, path string) {
+			at := time.Now().Add(time.Hour)
+			if err := os.Chtimes(path, at, at); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"rewritten in place": func(t *testing.T, path string) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("DATA"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			op, opts := setup(t, 3)
+			opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+			if _, err := Run(op, opts, time.Now()); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			changed := filepath.Join(op.dir, "f1")
+			change(t, changed)
+
+			apply := Options{ApplyPlan: opts.PlanOut, Yes: true, AuditLog: opts.AuditLog}
+			report, err := Run(op, apply, time.Now())
+			var drift *DriftError
+			if !errors.As(err, &drift) || !errors.Is(err, ErrDrift) {
+				t.Fatalf("Run(apply) error = %v, want a DriftError", err)
+			}
+			if len(drift.Items) != 1 || drift.Items[0].Path != changed {
+				t.Errorf("drifted items = %+v, want %s", drift.Items, changed)
+			}
+			if report.Executed || report.Applied != 0 {
+				t.Errorf("report = %+v, want nothing applied", report)
+			}
+			if _, err := os.Stat(filepath.Join(op.dir, "f0")); err != nil {
+				t.Errorf("unchanged file of a drifted plan removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestRun_ApplyRefused(t *testing.T) {
+	op, opts := setup(t, 1)
+	opts.PlanOut = filepath.Join(t.TempDir(), "plan.json")
+	if _, err := Run(op, opts, time.Now()); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// An operation refuses the items it would not plan, even if the plan
+	// names them
+	other := removeAll{dir: t.TempDir()}
+	if _, err := Run(other, Options{ApplyPlan: opts.PlanOut, Yes: true, AuditLog: opts.AuditLog}, time.Now()); err == nil {
+		t.Error("Run() applied an item the operation refuses")
+	}
+	if n := remaining(t, op); n != 1 {
+		t.Errorf("%d files left, want the refused one", n)
+	}
+}
@@ -0,0 +1,414 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CompleteMarker is the file marking a day of manifests final
+const CompleteMarker = "_COMPLETE"
+
+// LateDir is the folder of a finalized day holding the entries processed
+// that day but appended after it was finalized
+const LateDir = "late"
+
+// ErrMarkerMismatch is returned by VerifyDay when the manifests of a day no
+// longer match its marker
+var ErrMarkerMismatch = errors.New("manifests do not match their completion marker")
+
+// DayMarker is the content of a day's CompleteMarker
+type DayMarker struct {
+	// Day is the day finalized, as YYYY-MM-DD in the local time zone
+	Day string `json:"day"`
+	// Entries and Bytes count the entries of the day and their Size
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	// Files lists the manifest files of the day by their path relative to
+	// the day's directory, in order
+	Files []MarkedFile `json:"files"`
+	// Checksum is the SHA256 over every file's SHA256 and path, one
+	// "<sha256>  <path>\n" line each as sha256sum prints them
+	Checksum    string    `json:"checksum"`
+	FinalizedAt time.Time `json:"finalized_at"`
+}
+
+// MarkedFile is a manifest file listed in a DayMarker
+type MarkedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// dayFinalizer tracks which days are final. Callers hold Writer.mu, except
+// for reading late.
+type dayFinalizer struct {
+	grace time.Duration
+	now   func() time.Time
+	// final caches whether the directory of a day has its marker
+	final map[string]bool
+	// nextScan is when FinalizeDays next has a day to finalize
+	nextScan time.Time
+	late     atomic.Int64
+}
+
+// EnableDayFinalization makes FinalizeDays write the CompleteMarker of
+// every day of manifest files once the day is over and grace has passed,
+// for entries still being processed. Entries of a finalized day are written
+// below its LateDir instead. Call before the first Append.
+func (w *Writer) EnableDayFinalization(grace time.Duration) {
+	w.finalize = &dayFinalizer{grace: grace, now: time.Now, final: make(map[string]bool)}
+}
+
+// LateEntries counts the entries written to the LateDir of a finalized day
+func (w *Writer) LateEntries() int64 {
+	if w.finalize == nil {
+		return 0
+	}
+	return w.finalize.late.Load()
+}
+
+// dayDir returns the directory of the manifests of the day containing t
+func (w *Writer) dayDir(t time.Time) string {
+	return filepath.Join(w.basePath, t.Format("2006"), t.Format("01"), t.Format("02"))
+}
+
+// latePath returns the file an entry processed at t is written to once its
+// day is finalized
+func (w *Writer) latePath(t time.Time) string {
+	return filepath.Join(w.dayDir(t), LateDir, t.Format("15"), "manifest.jsonl")
+}
+
+// finalized reports whether the day of t has its marker. Callers hold mu.
+func (w *Writer) finalized(t time.Time) bool {
+	dir := w.dayDir(t)
+	final, ok := w.finalize.final[dir]
+	if !ok {
+		_, err := os.Stat(filepath.Join(dir, CompleteMarker))
+		final = err == nil
+		w.finalize.final[dir] = final
+	}
+	if !final {
+		// A day stays open until its first entry, so finalize it soon
+		// however late that entry is
+		if due := nextDay(t).Add(w.finalize.grace); due.Before(w.finalize.nextScan) {
+			w.finalize.nextScan = due
+		}
+	}
+	return final
+}
+
+// appendLate writes the entry of a finalized day below its LateDir.
+// Callers hold mu.
+func (w *Writer) appendLate(entry Entry) error {
+	w.finalize.late.Add(1)
+	slog.Warn("manifest entry for a finalized day, writing it to the late folder",
+		"sha256", entry.SHA256,
+		"source", entry.SourcePath,
+		"processed_at", entry.ProcessedAt,
+		"day", entry.ProcessedAt.Format(time.DateOnly),
+	)
+	return appendLine(w.latePath(entry.ProcessedAt), entry)
+}
+
+// FinalizeDays writes the CompleteMarker of every day of manifest files
+// that ended more than the grace period ago and has none yet, and returns
+// how many it finalized. It does nothing unless EnableDayFinalization was
+// called or when entries are only recorded in the database.
+func (w *Writer) FinalizeDays() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f := w.finalize
+	if f == nil || w.noFiles || w.closed {
+		return 0, nil
+	}
+	now := f.now()
+	if now.Before(f.nextScan) {
+		return 0, nil
+	}
+
+	days, err := w.listDays()
+	if err != nil {
+		return 0, err
+	}
+	finalized := 0
+	f.nextScan = nextDay(now).Add(f.grace)
+	for _, day := range days {
+		dir := w.dayDir(day)
+		if f.final[dir] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, CompleteMarker)); err == nil {
+			f.final[dir] = true
+			continue
+		}
+		due := nextDay(day).Add(f.grace)
+		if now.Before(due) {
+			if due.Before(f.nextScan) {
+				f.nextScan = due
+			}
+			continue
+		}
+		if err := w.finalizeDay(day, now); err != nil {
+			// Retried on the next call
+			f.nextScan = time.Time{}
+			return finalized, err
+		}
+		f.final[dir] = true
+		finalized++
+	}
+	return finalized, nil
+}
+
+// listDays returns the days with a manifest directory, oldest first
+func (w *Writer) listDays() ([]time.Time, error) {
+	var days []time.Time
+	years, err := os.ReadDir(w.basePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list manifest days: %w", err)
+	}
+	for _, year := range years {
+		months, err := readSubdirs(filepath.Join(w.basePath, year.Name()), year)
+		if err != nil {
+			return nil, err
+		}
+		for _, month := range months {
+			dayDirs, err := readSubdirs(filepath.Join(w.basePath, year.Name(), month.Name()), month)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range dayDirs {
+				day, err := time.ParseInLocation("2006/01/02", year.Name()+"/"+month.Name()+"/"+d.Name(), time.Local)
+				if err != nil || !d.IsDir() {
+					// Not a day directory
+					continue
+				}
+				days = append(days, day)
+			}
+		}
+	}
+	return days, nil
+}
+
+// readSubdirs lists the directory at path, nothing when d is no directory
+func readSubdirs(path string, d fs.DirEntry) ([]fs.DirEntry, error) {
+	if !d.IsDir() {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("list manifest days: %w", err)
+	}
+	return entries, nil
+}
+
+// finalizeDay writes the marker of day: open manifest files of the day are
+// closed and parquet periods finalized first, so the marker covers every
+// entry appended so far. Callers hold mu.
+func (w *Writer) finalizeDay(day, now time.Time) error {
+	dir := w.dayDir(day)
+	if w.parquet != nil {
+		if err := w.parquet.finalizeWithin(dir); err != nil {
+			return err
+		}
+	}
+	if within(dir, w.lines.path) {
+		if err := w.lines.close(); err != nil {
+			return err
+		}
+	}
+
+	marker, err := markDay(dir)
+	if err != nil {
+		return err
+	}
+	marker.Day = day.Format(time.DateOnly)
+	marker.FinalizedAt = now.UTC()
+	if err := writeMarker(filepath.Join(dir, CompleteMarker), marker); err != nil {
+		return err
+	}
+	slog.Info("manifest day finalized",
+		"day", marker.Day,
+		"entries", marker.Entries,
+		"bytes", marker.Bytes,
+		"files", len(marker.Files),
+	)
+	return nil
+}
+
+// RefinalizeDay rewrites the CompleteMarker of the day containing t when
+// the day has one, so that it covers entries written into the day's files
+// since, as manifest backfill does, and reports whether it did
+func (w *Writer) RefinalizeDay(t time.Time) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir := w.dayDir(t)
+	if _, err := os.Stat(filepath.Join(dir, CompleteMarker)); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read completion marker: %w", err)
+	}
+	if err := w.finalizeDay(t, time.Now()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// markDay lists and checksums the manifest files of the day at dir and
+// counts their entries. Files below LateDir are not part of the day.
+func markDay(dir string) (DayMarker, error) {
+	var marker DayMarker
+	sum := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == filepath.Join(dir, LateDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name := d.Name(); name != "manifest.jsonl" && name != parquetName {
+			return nil
+		}
+
+		entries, err := ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hash, size, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file := MarkedFile{Path: filepath.ToSlash(rel), Size: size, SHA256: hash}
+		marker.Files = append(marker.Files, file)
+		_, _ = fmt.Fprintf(sum, "%s  %s\n", file.SHA256, file.Path)
+		marker.Entries += len(entries)
+		for _, e := range entries {
+			marker.Bytes += e.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return marker, fmt.Errorf("checksum manifests of %s: %w", dir, err)
+	}
+	marker.Checksum = hex.EncodeToString(sum.Sum(nil))
+	return marker, nil
+}
+
+// VerifyDay checks the manifest files of the day at dir against its
+// CompleteMarker and returns the marker. Any file added, removed or changed
+// since finalization fails with ErrMarkerMismatch; entries below LateDir
+// are not checked.
+func VerifyDay(dir string) (DayMarker, error) {
+	var marker DayMarker
+	data, err := os.ReadFile(filepath.Join(dir, CompleteMarker))
+	if err != nil {
+		return marker, fmt.Errorf("read completion marker: %w", err)
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return marker, fmt.Errorf("decode completion marker: %w", err)
+	}
+
+	current, err := markDay(dir)
+	if err != nil {
+		return marker, err
+	}
+	if current.Checksum != marker.Checksum {
+		return marker, fmt.Errorf("%w: %s checksum %s, marker has %s", ErrMarkerMismatch, dir, current.Checksum, marker.Checksum)
+	}
+	if current.Entries != marker.Entries || current.Bytes != marker.Bytes {
+		return marker, fmt.Errorf("%w: %s has %d entries of %d bytes, marker has %d of %d",
+			ErrMarkerMismatch, dir, current.Entries, current.Bytes, marker.Entries, marker.Bytes)
+	}
+	return marker, nil
+}
+
+// writeMarker replaces the marker at path through a synced temporary file
+func writeMarker(path string, marker DayMarker) error {
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode completion marker: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create completion marker: %w", err)
+	}
+	_, err = file.Write(data)
+	err = errors.Join(err, file.Sync(), file.Close())
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write completion marker: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the SHA256 and size of the file at path
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = file.Close() }()
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// finalizeWithin finalizes every open period below dir
+func (p *parquetWriter) finalizeWithin(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	open := make([]string, 0, len(p.open))
+	for period := range p.open {
+		if within(dir, period) {
+			open = append(open, period)
+		}
+	}
+	slices.Sort(open)
+	for _, period := range open {
+		if err := p.finalize(period); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextDay returns the start of the day after the one containing t, in t's
+// time zone
+func nextDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// within reports whether path is dir or below it
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
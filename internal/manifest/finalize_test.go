@@ -0,0 +1,182 @@
+package manifest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for the finalizer tests step by hand
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// finalizingWriter enables day finalization on w with a fake clock
+func finalizingWriter(w *Writer, grace time.Duration, now time.Time) *fakeClock {
+	clock := &fakeClock{now: now}
+	w.EnableDayFinalization(grace)
+	w.finalize.now = clock.Now
+	return clock
+}
+
+func TestFinalizeDays_MarksDayAfterGrace(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer func() { _ = w.Close() }()
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	clock := finalizingWriter(w, time.Hour, day.Add(10*time.Hour))
+
+	for i, at := range []time.Time{day.Add(10 * time.Hour), day.Add(23*time.Hour + 59*time.Minute)} {
+		if err := w.Append(Entry{SHA256: string(rune('a' + i)), Size: 100, ProcessedAt: at}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Past midnight but within the grace period
+	clock.now = day.Add(24*time.Hour + 30*time.Minute)
+	if n, err := w.FinalizeDays(); err != nil || n != 0 {
+		t.Fatalf("FinalizeDays() = %d, %v within the grace period, want 0", n, err)
+	}
+	clock.now = day.Add(25*time.Hour + time.Second)
+	if n, err := w.FinalizeDays(); err != nil || n != 1 {
+		t.Fatalf("FinalizeDays() = %d, %v after the grace period, want 1", n, err)
+	}
+
+	dayDir := filepath.Join(dir, "2024", "03", "15")
+	marker, err := VerifyDay(dayDir)
+	if err != nil {
+		t.Fatalf("VerifyDay failed: %v", err)
+	}
+	if marker.Day != "2024-03-15" || marker.Entries != 2 || marker.Bytes != 200 || len(marker.Files) != 2 {
+		t.Errorf("marker = %+v, want 2 entries of 200 bytes in 2 files", marker)
+	}
+	if marker.Files[0].Path != "10/manifest.jsonl" || marker.Files[1].Path != "23/manifest.jsonl" {
+		t.Errorf("marker files = %+v", marker.Files)
+	}
+
+	if n, err := w.FinalizeDays(); err != nil || n != 0 {
+		t.Errorf("FinalizeDays() = %d, %v again, want the day finalized once", n, err)
+	}
+}
+
+func TestFinalizeDays_LateEntries(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer func() { _ = w.Close() }()
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	clock := finalizingWriter(w, 0, day.Add(12*time.Hour))
+
+	if err := w.Append(Entry{SHA256: "a", Size: 1, ProcessedAt: day.Add(12 * time.Hour)}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	clock.now = day.Add(26 * time.Hour)
+	if _, err := w.FinalizeDays(); err != nil {
+		t.Fatalf("FinalizeDays failed: %v", err)
+	}
+
+	// Stamped before midnight, appended after finalization
+	late := Entry{SHA256: "late", Size: 5, ProcessedAt: day.Add(23 * time.Hour)}
+	if err := w.Append(late); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	dayDir := filepath.Join(dir, "2024", "03", "15")
+	if _, err := os.Stat(filepath.Join(dayDir, LateDir, "23", "manifest.jsonl")); err != nil {
+		t.Fatalf("late entry not in the late folder: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dayDir, "23")); !os.IsNotExist(err) {
+		t.Errorf("late entry mutated the finalized day: %v", err)
+	}
+	if n := w.LateEntries(); n != 1 {
+		t.Errorf("LateEntries() = %d, want 1", n)
+	}
+	if _, err := VerifyDay(dayDir); err != nil {
+		t.Errorf("VerifyDay failed after a late entry: %v", err)
+	}
+
+	entries, err := w.ManifestEntries(day, day.Add(24*time.Hour-time.Nanosecond))
+	if err != nil {
+		t.Fatalf("ManifestEntries failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].SHA256 != "late" {
+		t.Errorf("ManifestEntries() = %d entries, want the late one read back too", len(entries))
+	}
+}
+
+func TestFinalizeDays_EntryOfOlderOpenDay(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer func() { _ = w.Close() }()
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	clock := finalizingWriter(w, time.Hour, day.Add(30*time.Hour))
+
+	// The first scan finds nothing and waits for the next midnight
+	if _, err := w.FinalizeDays(); err != nil {
+		t.Fatalf("FinalizeDays failed: %v", err)
+	}
+	// An entry of a day long over arrives: its day is finalized at the next
+	// scan rather than after the next midnight
+	if err := w.Append(Entry{SHA256: "a", Size: 1, ProcessedAt: day.Add(time.Hour)}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	clock.now = clock.now.Add(time.Second)
+	if n, err := w.FinalizeDays(); err != nil || n != 1 {
+		t.Errorf("FinalizeDays() = %d, %v, want the older day finalized", n, err)
+	}
+}
+
+func TestVerifyDay_DetectsChangedManifest(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir)
+	defer func() { _ = w.Close() }()
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	clock := finalizingWriter(w, 0, day.Add(time.Hour))
+
+	if err := w.Append(Entry{SHA256: "a", Size: 1, ProcessedAt: day.Add(time.Hour)}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	clock.now = day.Add(48 * time.Hour)
+	if _, err := w.FinalizeDays(); err != nil {
+		t.Fatalf("FinalizeDays failed: %v", err)
+	}
+
+	dayDir := filepath.Join(dir, "2024", "03", "15")
+	f, err := os.OpenFile(filepath.Join(dayDir, "01", "manifest.jsonl"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open manifest: %v", err)
+	}
+	_, _ = f.WriteString(`{"sha256":"forged","size":1,"processed_at":"2024-03-15T01:30:00Z"}` + "\n")
+	_ = f.Close()
+
+	if _, err := VerifyDay(dayDir); !errors.Is(err, ErrMarkerMismatch) {
+		t.Errorf("VerifyDay() = %v, want ErrMarkerMismatch", err)
+	}
+}
+
+func TestFinalizeDays_Parquet(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewParquetWriter(dir, PeriodDaily)
+	if err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	clock := finalizingWriter(w, 0, day.Add(time.Hour))
+
+	if err := w.Append(Entry{SHA256: "a", Size: 7, ProcessedAt: day.Add(time.Hour)}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	clock.now = day.Add(25 * time.Hour)
+	if n, err := w.FinalizeDays(); err != nil || n != 1 {
+		t.Fatalf("FinalizeDays() = %d, %v, want 1", n, err)
+	}
+
+	marker, err := VerifyDay(filepath.Join(dir, "2024", "03", "15"))
+	if err != nil {
+		t.Fatalf("VerifyDay failed: %v", err)
+	}
+	if len(marker.Files) != 1 || marker.Files[0].Path != parquetName || marker.Entries != 1 || marker.Bytes != 7 {
+		t.Errorf("marker = %+v, want the finalized parquet file", marker)
+	}
+}
@@ -0,0 +1,205 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// CorruptSuffix is appended to the name of a manifest file for the
+// companion holding the corrupt tails moved out of it
+const CorruptSuffix = ".corrupt"
+
+// tailProbe is how far from the end repairTail looks for the last newline
+// before reading further back
+const tailProbe = 64 * 1024
+
+// lineFile appends JSON lines to one file at a time and keeps it open
+// between appends. Every append is written with a single write and fsynced
+// before it returns, so an acknowledged entry survives a crash; a crash
+// during the write can only leave a torn last line, which repairTail moves
+// aside the next time the file is opened. It is not safe for concurrent
+// use, and LockDir keeps other processes from writing the same files.
+type lineFile struct {
+	path string
+	file *os.File
+}
+
+// append writes entry as a JSON line to path, switching files if needed
+func (l *lineFile) append(path string, entry Entry) error {
+	if l.path != path {
+		if err := l.close(); err != nil {
+			return err
+		}
+		if err := l.open(path); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest entry: %w", err)
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		// Reopen on the next append, which repairs a partial line
+		_ = l.close()
+		return fmt.Errorf("failed to write manifest entry: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		_ = l.close()
+		return fmt.Errorf("failed to sync manifest file: %w", err)
+	}
+	return nil
+}
+
+func (l *lineFile) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if _, err := repairTail(path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest file: %w", err)
+	}
+	l.path, l.file = path, file
+	return nil
+}
+
+// sync fsyncs the open file, if any
+func (l *lineFile) sync() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync manifest file: %w", err)
+	}
+	return nil
+}
+
+// close syncs and closes the open file, if any
+func (l *lineFile) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := errors.Join(l.sync(), l.file.Close())
+	l.path, l.file = "", nil
+	if err != nil {
+		return fmt.Errorf("failed to close manifest file: %w", err)
+	}
+	return nil
+}
+
+// repairTail moves what follows the last valid line of a JSON Lines file
+// into its CorruptSuffix companion and truncates the file there: a line
+// torn by a crash in the middle of its write, and a last line that is no
+// valid JSON, such as one interleaved with the write of another process.
+// Only the last complete line is checked, keeping the repair cheap on
+// large files. It returns the number of bytes moved; a missing file is not
+// an error.
+func repairTail(path string) (int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open manifest file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat manifest file: %w", err)
+	}
+	size := info.Size()
+
+	end, err := lastNewline(file, size)
+	if err != nil {
+		return 0, err
+	}
+	end++
+	if end > 0 {
+		start, err := lastNewline(file, end-1)
+		if err != nil {
+			return 0, err
+		}
+		start++
+		line := make([]byte, end-1-start)
+		if _, err := file.ReadAt(line, start); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read manifest file: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 && !json.Valid(line) {
+			end = start
+		}
+	}
+	if end == size {
+		return 0, nil
+	}
+
+	tail := make([]byte, size-end)
+	if _, err := file.ReadAt(tail, end); err != nil && !errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("failed to read manifest file: %w", err)
+	}
+	corrupt := path + CorruptSuffix
+	if err := saveCorrupt(corrupt, tail); err != nil {
+		return 0, err
+	}
+	if err := file.Truncate(end); err != nil {
+		return 0, fmt.Errorf("failed to truncate corrupt manifest tail: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync manifest file: %w", err)
+	}
+	slog.Warn("manifest ended in a corrupt line, moved it aside",
+		"path", path,
+		"corrupt", corrupt,
+		"corrupt_bytes", len(tail),
+		"kept_bytes", end,
+	)
+	return int64(len(tail)), nil
+}
+
+// lastNewline returns the offset of the last newline of file before pos,
+// -1 if there is none, probing backwards
+func lastNewline(file *os.File, pos int64) (int64, error) {
+	for pos > 0 {
+		start := max(0, pos-tailProbe)
+		buf := make([]byte, pos-start)
+		if _, err := file.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read manifest file: %w", err)
+		}
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return start + int64(i), nil
+		}
+		pos = start
+	}
+	return -1, nil
+}
+
+// saveCorrupt appends tail to the corrupt companion at path, ending it with
+// a newline so tails of several repairs stay apart, and syncs it before the
+// manifest is truncated
+func saveCorrupt(path string, tail []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open corrupt manifest companion: %w", err)
+	}
+	if !bytes.HasSuffix(tail, []byte("\n")) {
+		tail = append(tail, '\n')
+	}
+	_, err = file.Write(tail)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err = errors.Join(err, file.Close()); err != nil {
+		return fmt.Errorf("failed to write corrupt manifest companion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package manifest
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepairTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"complete", "{\"a\":1}\n{\"b\":2}\n", "{\"a\":1}\n{\"b\":2}\n"},
+		{"torn last line", "{\"a\":1}\n{\"b\":", "{\"a\":1}\n"},
+		{"only torn line", "{\"a\"", ""},
+		{"empty", "", ""},
+		{"torn beyond probe", "{\"a\":1}\n" + strings.Repeat("x", tailProbe+10), "{\"a\":1}\n"},
+		{"invalid last line", "{\"a\":1}\n{\"b\":{\"a\":1}\n", "{\"a\":1}\n"},
+		{"invalid line and torn tail", "{\"a\":1}\n{\"b\n{\"c\"", "{\"a\":1}\n"},
+		{"blank last line", "{\"a\":1}\n\n", "{\"a\":1}\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("failed to create manifest: %v", err)
+			}
+			moved, err := repairTail(path)
+			if err != nil {
+				t.Fatalf("repairTail() error = %v", err)
+			}
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.want {
+				t.Errorf("content after repair = %q, want %q", data, tt.want)
+			}
+			tail := tt.content[len(tt.want):]
+			if moved != int64(len(tail)) {
+				t.Errorf("moved = %d, want %d", moved, len(tail))
+			}
+
+			corrupt, err := os.ReadFile(path + CorruptSuffix)
+			if tail == "" {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("corrupt companion of a clean file: %q, %v", corrupt, err)
+				}
+				return
+			}
+			if want := strings.TrimSuffix(tail, "\n") + "\n"; string(corrupt) != want {
+				t.Errorf("corrupt companion = %q, want %q", corrupt, want)
+			}
+		})
+	}
+
+	if _, err := repairTail(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil {
+		t.Errorf("repairTail() of a missing file error = %v", err)
+	}
+}
+
+func TestWriter_Close(t *testing.T) {
+	tmpDir := t.TempDir()
+	w := NewWriter(tmpDir)
+	entry := Entry{SHA256: "abc", Name: "a.csv", ProcessedAt: time.Date(2024, 3, 15, 14, 0, 0, 0, time.UTC)}
+
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if w.lines.file == nil {
+		t.Error("writer should keep the manifest file open between appends")
+	}
+	if err := w.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+	if err := w.Append(entry); !errors.Is(err, ErrClosed) {
+		t.Errorf("Append after Close error = %v, want ErrClosed", err)
+	}
+
+	entries, err := ReadFile(w.manifestPath(entry))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("entries after Close = %+v, %v, want 1", entries, err)
+	}
+}
+
+func TestWriter_DropsTornLineOnReopen(t *testing.T) {
+	tmpDir := t.TempDir()
+	at := time.Date(2024, 3, 15, 14, 0, 0, 0, time.UTC)
+
+	w := NewWriter(tmpDir)
+	if err := w.Append(Entry{SHA256: "first", ProcessedAt: at}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A crash in the middle of a write leaves a partial line that was never
+	// acknowledged
+	path := w.manifestPath(Entry{ProcessedAt: at})
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open manifest: %v", err)
+	}
+	_, _ = f.WriteString(`{"schema_version":5,"sha256":"torn`)
+	_ = f.Close()
+
+	w = NewWriter(tmpDir)
+	if err := w.Append(Entry{SHA256: "second", ProcessedAt: at}); err != nil {
+		t.Fatalf("Append after crash failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	entries, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].SHA256 != "first" || entries[1].SHA256 != "second" {
+		t.Errorf("entries = %+v, want first and second", entries)
+	}
+	if corrupt, _ := os.ReadFile(path + CorruptSuffix); !strings.Contains(string(corrupt), `"sha256":"torn`) {
+		t.Errorf("corrupt companion = %q, want the torn line", corrupt)
+	}
+}
@@ -0,0 +1,77 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockName is the lock file a writer holds in the manifests directory
+const LockName = ".writer.lock"
+
+// ErrLocked is returned when another process writes the same manifests
+var ErrLocked = errors.New("manifest locked by another writer")
+
+// DirLock is the exclusive lock of one writer on a manifests directory
+type DirLock struct {
+	file *os.File
+}
+
+// lockHolder identifies the process holding a DirLock, recorded in the
+// lock file for the error of the next process trying to take it
+type lockHolder struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Since time.Time `json:"since"`
+}
+
+// LockDir takes the writer lock of the manifests directory at basePath,
+// creating both as needed. While another process holds it, it fails with
+// ErrLocked naming that process. The lock is advisory and released with
+// the process, so a crashed writer never leaves it behind.
+func LockDir(basePath string) (*DirLock, error) {
+	if err := os.MkdirAll(basePath, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(basePath, LockName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest lock: %w", err)
+	}
+	if err := flock(file); err != nil {
+		holder, _ := io.ReadAll(file)
+		_ = file.Close()
+		if holder = bytes.TrimSpace(holder); errors.Is(err, ErrLocked) && len(holder) > 0 {
+			return nil, fmt.Errorf("%s: %w, held by %s", basePath, err, holder)
+		}
+		return nil, fmt.Errorf("%s: %w", basePath, err)
+	}
+
+	host, _ := os.Hostname()
+	data, err := json.Marshal(lockHolder{PID: os.Getpid(), Host: host, Since: time.Now().UTC()})
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to marshal manifest lock: %w", err)
+	}
+	if err := file.Truncate(0); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to write manifest lock: %w", err)
+	}
+	if _, err := file.WriteAt(append(data, '\n'), 0); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to write manifest lock: %w", err)
+	}
+	return &DirLock{file: file}, nil
+}
+
+// Release releases the lock. The lock file stays for the next writer.
+func (l *DirLock) Release() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to release manifest lock: %w", err)
+	}
+	return nil
+}
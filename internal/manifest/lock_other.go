@@ -0,0 +1,10 @@
+//go:build !unix
+
+package manifest
+
+import "os"
+
+// flock is a no-op where advisory locks are not supported
+func flock(*os.File) error {
+	return nil
+}
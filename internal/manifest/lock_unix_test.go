@@ -0,0 +1,36 @@
+//go:build unix
+
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLockDir_RefusesSecondWriter(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := LockDir(dir)
+	if err != nil {
+		t.Fatalf("LockDir() error = %v", err)
+	}
+
+	_, err = LockDir(dir)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second LockDir() error = %v, want ErrLocked", err)
+	}
+	if want := fmt.Sprintf(`"pid":%d`, os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("second LockDir() error = %v, want the holder %s", err, want)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	lock, err = LockDir(dir)
+	if err != nil {
+		t.Fatalf("LockDir() after Release error = %v", err)
+	}
+	_ = lock.Release()
+}
@@ -1,81 +1,297 @@
 package manifest
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
+	"errors"
 	"path/filepath"
+	"sync"
 	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Dedup methods of duplicate entries
+const (
+	// DedupHash matched the SHA256 of an ingested file
+	DedupHash = "sha256"
+	// DedupBytes also compared the warehouse copy byte by byte
+	DedupBytes = "bytes"
+	// DedupKey matched the producer's idempotency key of an ingested file
+	// whose content differs; OriginalSHA256 is that file's hash
+	DedupKey = "idempotency_key"
 )
 
-// Entry represents a single manifest record
+// Entry represents a single manifest record. Fields added after version 1
+// must be optional and documented in the version history in schema.go, and
+// need a column in the parquet row and in storage.ManifestEntry.
 type Entry struct {
-	SHA256      string    `json:"sha256"`
-	Name        string    `json:"name"`
-	SourcePath  string    `json:"source_path"`
-	DestPath    string    `json:"dest_path"`
-	Size        int64     `json:"size"`
-	ProcessedAt time.Time `json:"processed_at"`
+	SchemaVersion int               `json:"schema_version"`
+	SHA256        string            `json:"sha256"`
+	Name          string            `json:"name"`
+	OriginalName  string            `json:"original_name,omitempty"`
+	SourcePath    string            `json:"source_path"`
+	DestPath      string            `json:"dest_path"`
+	Size          int64             `json:"size"`
+	ProcessedAt   time.Time         `json:"processed_at"`
+	Status        status.Status     `json:"status,omitempty"`
+	Reason        string            `json:"reason,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+	Parts         []Part            `json:"parts,omitempty"`
+	// Version and PreviousSHA256 link successive files ingested from the
+	// same source path when versioning is enabled
+	Version        int    `json:"version,omitempty"`
+	PreviousSHA256 string `json:"previous_sha256,omitempty"`
+	// Dedup tells how the content of a duplicate entry was matched with
+	// the warehouse copy at DestPath
+	Dedup string `json:"dedup,omitempty"`
+	// IdempotencyKey is the producer-supplied key of the file, if any
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// OriginalSHA256 is the hash of the ingested file a key duplicate
+	// matched
+	OriginalSHA256 string `json:"original_sha256,omitempty"`
+	// SelfTest marks the entry of a self-test probe, whose other artifacts
+	// are removed once the test is over
+	SelfTest bool `json:"self_test,omitempty"`
+	// AllocatedSize is the bytes the source occupied on disk, set only when
+	// it differs materially from Size, as for sparse images
+	AllocatedSize int64 `json:"allocated_size,omitempty"`
+	// Members and MembersSize are the file count and total size of a
+	// directory batch packaged into a tar, or of the duplicates a duplicate
+	// summary stands for, whose SourcePath is then the source directory
+	Members     int   `json:"members,omitempty"`
+	MembersSize int64 `json:"members_size,omitempty"`
+	// SourceRelPath is SourcePath relative to the input directory,
+	// slash-separated, the same however the input was spelled
+	SourceRelPath string `json:"source_rel_path,omitempty"`
+	// Sequence orders entries by when they were stamped, independent of
+	// the wall clock: it only grows, across restarts too, even when the
+	// clock steps back. Zero for entries stamped without a state database.
+	Sequence int64 `json:"sequence,omitempty"`
+	// Restores is the warehouse path of an earlier ingestion of the content
+	// whose copy was found missing when this file arrived as its duplicate;
+	// the file was ingested again to replace it
+	Restores string `json:"restores,omitempty"`
+	// Supersedes is the warehouse path of an earlier ingestion of the
+	// content that was recorded before the dedup window; the file was
+	// ingested again rather than skipped as its duplicate
+	Supersedes string `json:"supersedes,omitempty"`
+	// LinkCount is the number of hard links to the warehouse copy when it
+	// was hard-linked to a source that was kept: the two share an inode, so
+	// an in-place write to the source changes the copy too
+	LinkCount int `json:"link_count,omitempty"`
+	// Priority is the priority class the file was dispatched in, empty
+	// when no classes are configured
+	Priority string `json:"priority,omitempty"`
+	// SourceURL is where a file listed in a URL list was downloaded from.
+	// Its SourcePath is then the path next to the list it was ingested
+	// under, where no file existed.
+	SourceURL string `json:"source_url,omitempty"`
+	// Backfilled marks an entry synthesized from the state database for a
+	// file recorded before its manifest entry was written, by manifest
+	// backfill
+	Backfilled bool `json:"backfilled,omitempty"`
+}
+
+// Redacted returns a copy of e with every file name and path passed through
+// fn. Bare names are redacted in their source directory, so that names
+// below a redacted directory are hidden too.
+func (e Entry) Redacted(fn func(string) string) Entry {
+	dir := filepath.Dir(e.SourcePath)
+	name := func(n string) string {
+		if n == "" || e.SourcePath == "" {
+			return fn(n)
+		}
+		return filepath.Base(fn(filepath.Join(dir, n)))
+	}
+	e.Name = name(e.Name)
+	e.OriginalName = name(e.OriginalName)
+	e.SourcePath = fn(e.SourcePath)
+	e.DestPath = fn(e.DestPath)
+	if e.Restores != "" {
+		e.Restores = fn(e.Restores)
+	}
+	if e.Supersedes != "" {
+		e.Supersedes = fn(e.Supersedes)
+	}
+	if e.Parts != nil {
+		parts := make([]Part, len(e.Parts))
+		for i, p := range e.Parts {
+			p.Name = name(p.Name)
+			parts[i] = p
+		}
+		e.Parts = parts
+	}
+	return e
 }
 
-// Writer handles writing manifest entries to JSON Lines files
+// Part describes one input part of a file concatenated from a multi-part set
+type Part struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+}
+
+// ErrClosed is returned by Append after Close
+var ErrClosed = errors.New("manifest writer closed")
+
+// Writer handles writing manifest entries to JSON Lines files, or to parquet
+// files when created by NewParquetWriter. It is safe for concurrent use.
+//
+// Every Append is fsynced before it returns, so entries are never buffered
+// in memory: a crash loses only entries whose Append had not returned, and
+// can leave at most a torn last line, dropped when the file is next opened.
+// The database record of a file commits before its manifest entry, so a
+// crash between the two leaves a record without an entry. Entries recorded
+// in the database can instead commit together with the record, see
+// AppendCommitted.
 type Writer struct {
 	basePath string
+	parquet  *parquetWriter
+	// redact, when set, rewrites the names of every appended entry
+	redact func(string) string
+	// db, when set, records every entry in the database too, or only
+	// there when noFiles is set
+	db      EntryStore
+	noFiles bool
+	// sourceRoot is the input directory SourceRelPath is relative to
+	sourceRoot string
+	// layout places the JSON Lines manifest files
+	layout *layout.Resolver
+	// finalize, when set, marks days of manifests final; see
+	// EnableDayFinalization
+	finalize *dayFinalizer
+
+	mu     sync.Mutex
+	lines  lineFile
+	closed bool
 }
 
 // NewWriter creates a new manifest writer
 func NewWriter(basePath string) *Writer {
-	return &Writer{basePath: basePath}
+	return &Writer{basePath: basePath, layout: layout.Default()}
 }
 
-// Append adds an entry to the appropriate manifest file based on timestamp
+// Append adds an entry to the appropriate manifest file based on timestamp,
+// and to the database when it is a sink
 func (w *Writer) Append(entry Entry) error {
-	// Determine manifest file path based on timestamp
-	manifestPath := w.getManifestPath(entry.ProcessedAt)
+	return w.append(w.Prepare(entry), true)
+}
 
-	// Ensure directory exists
-	dir := filepath.Dir(manifestPath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("failed to create manifest directory: %w", err)
+// Prepare returns entry as Append records it: stamped with the current
+// schema version, redacted and with its source path relative to the input
+func (w *Writer) Prepare(entry Entry) Entry {
+	if entry.SchemaVersion == 0 {
+		entry.SchemaVersion = CurrentSchemaVersion
 	}
+	if w.redact != nil {
+		entry = entry.Redacted(w.redact)
+	}
+	// Derived from the redacted source path, so it never reveals more
+	if entry.SourceRelPath == "" && w.sourceRoot != "" && entry.SourcePath != "" {
+		if rel, err := filepath.Rel(w.sourceRoot, entry.SourcePath); err == nil && filepath.IsLocal(rel) {
+			entry.SourceRelPath = filepath.ToSlash(rel)
+		}
+	}
+	return entry
+}
 
-	// Open file in append mode, create if doesn't exist
-	file, err := os.OpenFile(manifestPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("failed to open manifest file: %w", err)
+func (w *Writer) append(entry Entry, db bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
 	}
-	defer func() {
-		_ = file.Close()
-	}()
 
-	// Encode entry as JSON line
-	data, err := json.Marshal(entry)
-	if err != nil {
-		return fmt.Errorf("failed to marshal manifest entry: %w", err)
+	var errs []error
+	if db && w.db != nil {
+		errs = append(errs, w.db.AppendManifestEntries(entry))
+	}
+	switch {
+	case w.noFiles:
+	case w.finalize != nil && w.finalized(entry.ProcessedAt):
+		errs = append(errs, w.appendLate(entry))
+	case w.parquet != nil:
+		errs = append(errs, w.parquet.append(entry))
+	default:
+		// Determine manifest file path based on timestamp
+		errs = append(errs, w.lines.append(w.manifestPath(entry), entry))
 	}
+	return errors.Join(errs...)
+}
+
+// SetRedaction makes Append pass the names and paths of every entry through
+// fn, such as a pseudonymizer of sensitive names. Call before the first
+// Append.
+func (w *Writer) SetRedaction(fn func(string) string) {
+	w.redact = fn
+}
+
+// SetSourceRoot makes every entry record its source path relative to root,
+// the input directory. Call before the first Append.
+func (w *Writer) SetSourceRoot(root string) {
+	w.sourceRoot = root
+}
+
+// SetLayout places the JSON Lines manifest files by the manifest template
+// of r, the one configured for the source of each entry when there is one.
+// Call before the first Append.
+func (w *Writer) SetLayout(r *layout.Resolver) {
+	w.layout = r
+}
 
-	// Write JSON line with newline
-	if _, err := file.Write(append(data, '\n')); err != nil {
-		return fmt.Errorf("failed to write manifest entry: %w", err)
+// Flush fsyncs the open manifest file. Appends are already synced, so this
+// only matters on paths that cannot trust that, such as panic recovery.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.parquet != nil {
+		return w.parquet.lines.sync()
 	}
+	return w.lines.sync()
+}
+
+// Close syncs and closes the open manifest file and finalizes parquet files
+// of periods that are still open. It must be called once the processor has
+// drained; later calls are no-ops and later appends fail with ErrClosed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.parquet != nil {
+		return w.parquet.close()
+	}
+	return w.lines.close()
+}
 
-	// Sync to ensure durability
-	if err := file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync manifest file: %w", err)
+// Files returns the manifest files an entry processed at t is written to,
+// those of every source when their layout differs. For parquet this is the
+// period's write-ahead companion and, once the period is finalized, its
+// parquet file.
+func (w *Writer) Files(t time.Time) []string {
+	if w.parquet != nil {
+		dir := w.parquet.periodDir(t)
+		return []string{filepath.Join(dir, parquetName+walSuffix), filepath.Join(dir, parquetName)}
 	}
+	return w.layout.Files(layout.Manifest, w.basePath, t)
+}
 
-	return nil
+// appendLine writes entry as a JSON line to manifestPath and syncs it
+func appendLine(manifestPath string, entry Entry) error {
+	var l lineFile
+	return errors.Join(l.append(manifestPath, entry), l.close())
 }
 
-// getManifestPath returns the path for the manifest file based on timestamp
-// Format: basePath/YYYY/MM/DD/HH/manifest.jsonl
-func (w *Writer) getManifestPath(t time.Time) string {
-	return filepath.Join(
-		w.basePath,
-		t.Format("2006"),
-		t.Format("01"),
-		t.Format("02"),
-		t.Format("15"),
-		"manifest.jsonl",
-	)
+// manifestPath returns the manifest file of entry, by default
+// basePath/YYYY/MM/DD/HH/manifest.jsonl for the hour it was processed in
+func (w *Writer) manifestPath(entry Entry) string {
+	rel, err := w.layout.Resolve(layout.Manifest, layout.NewContext(entry.SourceRelPath, entry.ProcessedAt))
+	if err != nil {
+		// Only a source name can take a path outside the manifests, so
+		// the entry goes where those of the top-level files do
+		rel, _ = w.layout.Resolve(layout.Manifest, layout.Context{Source: ".", Tenant: ".", Time: entry.ProcessedAt})
+	}
+	return filepath.Join(w.basePath, rel)
 }
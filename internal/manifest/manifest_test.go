@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,21 +22,21 @@ func TestNewWriter(t *testing.T) {
 	}
 }
 
-func TestWriter_getManifestPath(t *testing.T) {
+func TestWriter_manifestPath(t *testing.T) {
 	w := NewWriter("/manifests")
 
 	// Fixed timestamp for testing
 	ts := time.Date(2024, 3, 15, 14, 30, 0, 0, time.UTC)
 
 	expected := "/manifests/2024/03/15/14/manifest.jsonl"
-	result := w.getManifestPath(ts)
+	result := w.manifestPath(Entry{ProcessedAt: ts})
 
 	if result != expected {
-		t.Errorf("getManifestPath() = %q, want %q", result, expected)
+		t.Errorf("manifestPath() = %q, want %q", result, expected)
 	}
 }
 
-func TestWriter_getManifestPath_Various(t *testing.T) {
+func TestWriter_manifestPath_Various(t *testing.T) {
 	w := NewWriter("/base")
 
 	tests := []struct {
@@ -62,14 +63,34 @@ func TestWriter_getManifestPath_Various(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := w.getManifestPath(tt.time)
+			result := w.manifestPath(Entry{ProcessedAt: tt.time})
 			if result != tt.expected {
-				t.Errorf("getManifestPath() = %q, want %q", result, tt.expected)
+				t.Errorf("manifestPath() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
 }
 
+func TestWriter_Files(t *testing.T) {
+	ts := time.Date(2024, 3, 15, 14, 30, 0, 0, time.UTC)
+
+	got := NewWriter("/manifests").Files(ts)
+	if len(got) != 1 || got[0] != "/manifests/2024/03/15/14/manifest.jsonl" {
+		t.Errorf("jsonl Files() = %v", got)
+	}
+
+	base := t.TempDir()
+	w, err := NewParquetWriter(base, PeriodDaily)
+	if err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	dir := filepath.Join(base, "2024", "03", "15")
+	got = w.Files(ts)
+	if len(got) != 2 || got[0] != filepath.Join(dir, "manifest.parquet.wal") || got[1] != filepath.Join(dir, "manifest.parquet") {
+		t.Errorf("parquet Files() = %v", got)
+	}
+}
+
 func TestWriter_Append(t *testing.T) {
 	tmpDir := t.TempDir()
 	w := NewWriter(tmpDir)
@@ -88,7 +109,7 @@ func TestWriter_Append(t *testing.T) {
 	}
 
 	// Verify file was created
-	manifestPath := w.getManifestPath(entry.ProcessedAt)
+	manifestPath := w.manifestPath(entry)
 	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
 		t.Fatal("manifest file was not created")
 	}
@@ -147,7 +168,7 @@ func TestWriter_Append_MultipleEntries(t *testing.T) {
 	}
 
 	// Verify all entries are in the same file
-	manifestPath := w.getManifestPath(baseTime)
+	manifestPath := w.manifestPath(Entry{ProcessedAt: baseTime})
 	file, err := os.Open(manifestPath)
 	if err != nil {
 		t.Fatalf("failed to open manifest file: %v", err)
@@ -188,8 +209,8 @@ func TestWriter_Append_DifferentHours(t *testing.T) {
 	}
 
 	// Verify two different files were created
-	path1 := w.getManifestPath(entry1.ProcessedAt)
-	path2 := w.getManifestPath(entry2.ProcessedAt)
+	path1 := w.manifestPath(Entry{ProcessedAt: entry1.ProcessedAt})
+	path2 := w.manifestPath(Entry{ProcessedAt: entry2.ProcessedAt})
 
 	if path1 == path2 {
 		t.Error("expected different paths for different hours")
@@ -268,3 +289,50 @@ func containsAt(s, substr string, start int) bool {
 	}
 	return false
 }
+
+func TestEntry_Redacted(t *testing.T) {
+	// Hide everything below the secret directory
+	fn := func(s string) string {
+		dir, name, ok := strings.Cut(s, "/secret/")
+		if !ok {
+			return s
+		}
+		return dir + "/secret/x-" + strings.ReplaceAll(name, "/", "/x-")
+	}
+	e := Entry{
+		Name:         "a.csv",
+		OriginalName: "A.csv",
+		SourcePath:   "/in/secret/a.csv",
+		DestPath:     "/wh/secret/a.csv",
+		Parts:        []Part{{Index: 0, Name: "a.csv.part1"}},
+	}
+
+	got := e.Redacted(fn)
+	if got.Name != "x-a.csv" || got.OriginalName != "x-A.csv" || got.Parts[0].Name != "x-a.csv.part1" {
+		t.Errorf("names = %q, %q, %q, want them redacted in their directory", got.Name, got.OriginalName, got.Parts[0].Name)
+	}
+	if got.SourcePath != "/in/secret/x-a.csv" || got.DestPath != "/wh/secret/x-a.csv" {
+		t.Errorf("paths = %q, %q", got.SourcePath, got.DestPath)
+	}
+	if e.Parts[0].Name != "a.csv.part1" {
+		t.Error("Redacted() must not modify the original parts")
+	}
+}
+
+func TestWriter_Prepare_SourceRelPath(t *testing.T) {
+	w := NewWriter(t.TempDir())
+	w.SetSourceRoot("/in")
+
+	if got := w.Prepare(Entry{SourcePath: "/in/tenant/a.csv"}).SourceRelPath; got != "tenant/a.csv" {
+		t.Errorf("SourceRelPath = %q, want tenant/a.csv", got)
+	}
+	if got := w.Prepare(Entry{SourcePath: "/elsewhere/a.csv"}).SourceRelPath; got != "" {
+		t.Errorf("SourceRelPath outside the input = %q, want empty", got)
+	}
+
+	// Relative to the redacted source path
+	w.SetRedaction(func(s string) string { return strings.ReplaceAll(s, "secret", "x") })
+	if got := w.Prepare(Entry{SourcePath: "/in/secret/a.csv"}).SourceRelPath; got != "x/a.csv" {
+		t.Errorf("redacted SourceRelPath = %q, want x/a.csv", got)
+	}
+}
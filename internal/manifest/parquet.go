@@ -0,0 +1,387 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Manifest formats
+const (
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Parquet periods: one file is written per hour or per day
+const (
+	PeriodHourly = "hourly"
+	PeriodDaily  = "daily"
+)
+
+const (
+	parquetName = "manifest.parquet"
+	// walSuffix names the JSON Lines companion holding the entries of a
+	// period whose parquet file is not finalized yet
+	walSuffix = ".wal"
+)
+
+// parquetRow is the parquet layout of an Entry. Timestamps are stored with
+// microsecond precision in UTC.
+type parquetRow struct {
+	SchemaVersion  int32             `parquet:"schema_version"`
+	SHA256         string            `parquet:"sha256"`
+	Name           string            `parquet:"name"`
+	OriginalName   string            `parquet:"original_name,optional"`
+	SourcePath     string            `parquet:"source_path"`
+	DestPath       string            `parquet:"dest_path"`
+	Size           int64             `parquet:"size"`
+	ProcessedAt    time.Time         `parquet:"processed_at,timestamp(microsecond)"`
+	Status         string            `parquet:"status,optional"`
+	Reason         string            `parquet:"reason,optional"`
+	Tags           map[string]string `parquet:"tags"`
+	Parts          []parquetPart     `parquet:"parts,list"`
+	Version        int32             `parquet:"version,optional"`
+	PreviousSHA256 string            `parquet:"previous_sha256,optional"`
+	Dedup          string            `parquet:"dedup,optional"`
+	IdempotencyKey string            `parquet:"idempotency_key,optional"`
+	OriginalSHA256 string            `parquet:"original_sha256,optional"`
+	SelfTest       bool              `parquet:"self_test,optional"`
+	AllocatedSize  int64             `parquet:"allocated_size,optional"`
+	Members        int32             `parquet:"members,optional"`
+	MembersSize    int64             `parquet:"members_size,optional"`
+	SourceRelPath  string            `parquet:"source_rel_path,optional"`
+	Sequence       int64             `parquet:"sequence,optional"`
+	Restores       string            `parquet:"restores,optional"`
+	Supersedes     string            `parquet:"supersedes,optional"`
+	LinkCount      int32             `parquet:"link_count,optional"`
+	Priority       string            `parquet:"priority,optional"`
+	SourceURL      string            `parquet:"source_url,optional"`
+	Backfilled     bool              `parquet:"backfilled,optional"`
+}
+
+type parquetPart struct {
+	Index int32  `parquet:"index"`
+	Name  string `parquet:"name"`
+	Size  int64  `parquet:"size"`
+}
+
+func toRow(e Entry) parquetRow {
+	row := parquetRow{
+		SchemaVersion:  int32(e.SchemaVersion),
+		SHA256:         e.SHA256,
+		Name:           e.Name,
+		OriginalName:   e.OriginalName,
+		SourcePath:     e.SourcePath,
+		DestPath:       e.DestPath,
+		Size:           e.Size,
+		ProcessedAt:    e.ProcessedAt.UTC(),
+		Status:         string(e.Status),
+		Reason:         e.Reason,
+		Tags:           e.Tags,
+		Version:        int32(e.Version),
+		PreviousSHA256: e.PreviousSHA256,
+		Dedup:          e.Dedup,
+		IdempotencyKey: e.IdempotencyKey,
+		OriginalSHA256: e.OriginalSHA256,
+		SelfTest:       e.SelfTest,
+		AllocatedSize:  e.AllocatedSize,
+		Members:        int32(e.Members),
+		MembersSize:    e.MembersSize,
+		SourceRelPath:  e.SourceRelPath,
+		Sequence:       e.Sequence,
+		Restores:       e.Restores,
+		Supersedes:     e.Supersedes,
+		LinkCount:      int32(e.LinkCount),
+		Priority:       e.Priority,
+		SourceURL:      e.SourceURL,
+		Backfilled:     e.Backfilled,
+	}
+	for _, p := range e.Parts {
+		row.Parts = append(row.Parts, parquetPart{Index: int32(p.Index), Name: p.Name, Size: p.Size})
+	}
+	return row
+}
+
+func fromRow(row parquetRow) Entry {
+	e := Entry{
+		SchemaVersion:  int(row.SchemaVersion),
+		SHA256:         row.SHA256,
+		Name:           row.Name,
+		OriginalName:   row.OriginalName,
+		SourcePath:     row.SourcePath,
+		DestPath:       row.DestPath,
+		Size:           row.Size,
+		ProcessedAt:    row.ProcessedAt,
+		Status:         status.Status(row.Status),
+		Reason:         row.Reason,
+		Version:        int(row.Version),
+		PreviousSHA256: row.PreviousSHA256,
+		Dedup:          row.Dedup,
+		IdempotencyKey: row.IdempotencyKey,
+		OriginalSHA256: row.OriginalSHA256,
+		SelfTest:       row.SelfTest,
+		AllocatedSize:  row.AllocatedSize,
+		Members:        int(row.Members),
+		MembersSize:    row.MembersSize,
+		SourceRelPath:  row.SourceRelPath,
+		Sequence:       row.Sequence,
+		Restores:       row.Restores,
+		Supersedes:     row.Supersedes,
+		LinkCount:      int(row.LinkCount),
+		Priority:       row.Priority,
+		SourceURL:      row.SourceURL,
+		Backfilled:     row.Backfilled,
+	}
+	if len(row.Tags) > 0 {
+		e.Tags = row.Tags
+	}
+	for _, p := range row.Parts {
+		e.Parts = append(e.Parts, Part{Index: int(p.Index), Name: p.Name, Size: p.Size})
+	}
+	return e
+}
+
+// ReadParquet decodes every entry of a parquet manifest file, upgrading each
+// entry like Decode
+func ReadParquet(path string) ([]Entry, error) {
+	rows, err := parquet.ReadFile[parquetRow](path)
+	if err != nil {
+		return nil, fmt.Errorf("read parquet manifest %s: %w", path, err)
+	}
+	entries := make([]Entry, 0, len(rows))
+	for i, row := range rows {
+		entry, err := Upgrade(fromRow(row))
+		if err != nil {
+			return entries, fmt.Errorf("row %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ReadFile decodes a manifest file of either format, chosen by extension.
+// Write-ahead companions of unfinalized parquet periods are JSON Lines.
+func ReadFile(path string) ([]Entry, error) {
+	entries, _, err := ReadFileWith(path, ReadOptions{})
+	return entries, err
+}
+
+// ReadFileWith is ReadFile with the options of JSON Lines files. A line
+// over the cap is reported with the path of its file, a partial last line
+// logged.
+func ReadFileWith(path string, opts ReadOptions) ([]Entry, ReadStats, error) {
+	if strings.HasSuffix(path, ".parquet") {
+		entries, err := ReadParquet(path)
+		return entries, ReadStats{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, ReadStats{}, fmt.Errorf("open manifest %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+	entries, stats, err := ReadWith(file, opts)
+	var tooLong *LineTooLongError
+	if errors.As(err, &tooLong) {
+		tooLong.Path = path
+	}
+	if stats.PartialTail {
+		slog.Warn("manifest ends in a partial line, skipped it", "path", path)
+	}
+	return entries, stats, err
+}
+
+// parquetWriter collects entries of each period in its write-ahead
+// companion and converts them to a parquet file once the period is over
+type parquetWriter struct {
+	basePath string
+	period   string
+
+	mu sync.Mutex
+	// open holds the directories of periods with a write-ahead companion
+	open map[string]bool
+	// lines holds the companion of the latest period open
+	lines lineFile
+}
+
+// NewParquetWriter creates a manifest writer producing one parquet file per
+// period. Entries are appended to a JSON Lines write-ahead companion and the
+// parquet file is written when a later period starts or on Close. Companions
+// left by a crash are finalized here.
+func NewParquetWriter(basePath, period string) (*Writer, error) {
+	if period != PeriodHourly && period != PeriodDaily {
+		return nil, fmt.Errorf("unknown manifest period %q", period)
+	}
+
+	p := &parquetWriter{basePath: basePath, period: period, open: make(map[string]bool)}
+	if err := p.recover(); err != nil {
+		return nil, err
+	}
+	return &Writer{basePath: basePath, parquet: p}, nil
+}
+
+// periodDir returns the directory of the period containing t:
+// basePath/YYYY/MM/DD/HH for hourly and basePath/YYYY/MM/DD for daily
+func (p *parquetWriter) periodDir(t time.Time) string {
+	parts := []string{p.basePath, t.Format("2006"), t.Format("01"), t.Format("02")}
+	if p.period == PeriodHourly {
+		parts = append(parts, t.Format("15"))
+	}
+	return filepath.Join(parts...)
+}
+
+func (p *parquetWriter) append(entry Entry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir := p.periodDir(entry.ProcessedAt)
+	if err := p.lines.append(filepath.Join(dir, parquetName+walSuffix), entry); err != nil {
+		return err
+	}
+	p.open[dir] = true
+
+	// Period directories sort chronologically, so every open period before
+	// this one is over
+	for other := range p.open {
+		if other < dir {
+			if err := p.finalize(other); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parquetWriter) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := []error{p.lines.close()}
+	for dir := range p.open {
+		errs = append(errs, p.finalize(dir))
+	}
+	return errors.Join(errs...)
+}
+
+// recover finalizes every write-ahead companion under basePath
+func (p *parquetWriter) recover() error {
+	var dirs []string
+	err := filepath.WalkDir(p.basePath, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == p.basePath {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == parquetName+walSuffix {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan manifest write-ahead files: %w", err)
+	}
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := p.finalize(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalize merges the write-ahead companion of dir into its parquet file.
+// The parquet file is replaced atomically and the companion removed only
+// afterwards, so a crash at any point loses no entries. Entries already in
+// the parquet file are skipped, in case a crash came between the two steps.
+func (p *parquetWriter) finalize(dir string) error {
+	walPath := filepath.Join(dir, parquetName+walSuffix)
+	dstPath := filepath.Join(dir, parquetName)
+
+	if p.lines.path == walPath {
+		if err := p.lines.close(); err != nil {
+			return err
+		}
+	}
+	if _, err := repairTail(walPath); err != nil {
+		return err
+	}
+	pending, err := ReadFile(walPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		delete(p.open, dir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read manifest write-ahead file: %w", err)
+	}
+
+	var entries []Entry
+	if _, err := os.Stat(dstPath); err == nil {
+		if entries, err = ReadParquet(dstPath); err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[entryKey(e)] = true
+	}
+	for _, e := range pending {
+		if !seen[entryKey(e)] {
+			entries = append(entries, e)
+		}
+	}
+
+	rows := make([]parquetRow, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, toRow(e))
+	}
+
+	tmpPath := dstPath + ".tmp"
+	if err := writeParquet(tmpPath, rows); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit parquet manifest: %w", err)
+	}
+	if err := os.Remove(walPath); err != nil {
+		return fmt.Errorf("remove manifest write-ahead file: %w", err)
+	}
+
+	delete(p.open, dir)
+	return nil
+}
+
+// entryKey identifies an entry across a parquet round trip
+func entryKey(e Entry) string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%d", e.SHA256, e.SourcePath, e.Status, e.ProcessedAt.UnixMicro())
+}
+
+// writeParquet writes rows to path and syncs the file
+func writeParquet(path string, rows []parquetRow) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create parquet manifest: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if err := parquet.Write(file, rows); err != nil {
+		return fmt.Errorf("write parquet manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync parquet manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func parquetEntries() []Entry {
+	base := time.Date(2024, 3, 15, 14, 30, 0, 123456000, time.UTC)
+	return []Entry{
+		{
+			SHA256:      "aaa",
+			Name:        "a.csv",
+			SourcePath:  "/input/a.csv",
+			DestPath:    "/warehouse/a.csv",
+			Size:        10,
+			ProcessedAt: base,
+			Status:      status.Ingested,
+			Tags:        map[string]string{"tier": "bulk"},
+		},
+		{
+			SHA256:       "bbb",
+			Name:         "b.csv",
+			OriginalName: "a-very-long-b.csv",
+			SourcePath:   "/input/b.csv",
+			DestPath:     "/warehouse/b.csv",
+			Size:         20,
+			ProcessedAt:  base.Add(time.Minute),
+			Status:       status.Ingested,
+			Parts:        []Part{{Index: 1, Name: "b.csv.001", Size: 12}, {Index: 2, Name: "b.csv.002", Size: 8}},
+		},
+		{
+			Name:        "c.csv",
+			SourcePath:  "/input/c.csv",
+			ProcessedAt: base.Add(2 * time.Minute),
+			Status:      status.Vanished,
+			Reason:      "source_vanished",
+		},
+		{
+			SHA256:      "aaa",
+			Name:        "a-copy.csv",
+			SourcePath:  "/input/a-copy.csv",
+			DestPath:    "/warehouse/a.csv",
+			Size:        10,
+			ProcessedAt: base.Add(3 * time.Minute),
+			Status:      status.Duplicate,
+			Dedup:       DedupBytes,
+		},
+		{
+			SHA256:         "ddd",
+			Name:           "batch-42.csv",
+			SourcePath:     "/input/batch-42.csv",
+			DestPath:       "/warehouse/batch-42-first.csv",
+			Size:           12,
+			ProcessedAt:    base.Add(4 * time.Minute),
+			Status:         status.Duplicate,
+			Dedup:          DedupKey,
+			IdempotencyKey: "42",
+			OriginalSHA256: "eee",
+		},
+		{
+			SHA256:      "fff",
+			Name:        "probe.txt",
+			SourcePath:  "/input/.atomic-ingestor-self-test-1/probe.txt",
+			DestPath:    "/warehouse/.atomic-ingestor-self-test-1/probe.txt",
+			Size:        40,
+			ProcessedAt: base.Add(5 * time.Minute),
+			Status:      status.Ingested,
+			SelfTest:    true,
+		},
+		{
+			SHA256:        "ggg",
+			Name:          "disk.img",
+			SourcePath:    "/input/disk.img",
+			DestPath:      "/warehouse/disk.img",
+			Size:          1 << 30,
+			ProcessedAt:   base.Add(6 * time.Minute),
+			Status:        status.Ingested,
+			AllocatedSize: 4096,
+		},
+		{
+			SHA256:      "hhh",
+			Name:        "batch_0423.tar",
+			SourcePath:  "/input/batch_0423",
+			DestPath:    "/warehouse/batch_0423.tar",
+			Size:        10240,
+			ProcessedAt: base.Add(7 * time.Minute),
+			Status:      status.Ingested,
+			Members:     3,
+			MembersSize: 120,
+		},
+		{
+			SHA256:        "iii",
+			Name:          "orders.csv",
+			SourcePath:    "/input/tenant/orders.csv",
+			DestPath:      "/warehouse/tenant/orders.csv",
+			Size:          64,
+			ProcessedAt:   base.Add(8 * time.Minute),
+			Status:        status.Ingested,
+			SourceRelPath: "tenant/orders.csv",
+			Sequence:      12,
+			Restores:      "/warehouse/tenant/orders.csv",
+			Supersedes:    "/warehouse/tenant/orders.1.csv",
+			LinkCount:     2,
+		},
+	}
+}
+
+// assertEntries compares entries after a parquet round trip, which stores
+// timestamps in UTC
+func assertEntries(t *testing.T, got, want []Entry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		w := want[i]
+		w.SchemaVersion = CurrentSchemaVersion
+		w.ProcessedAt = w.ProcessedAt.UTC()
+		if !reflect.DeepEqual(got[i], w) {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+}
+
+func TestParquetWriter_RoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := NewParquetWriter(tmpDir, PeriodHourly)
+	if err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	entries := parquetEntries()
+	for _, e := range entries {
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "2024", "03", "15", "14")
+	if _, err := os.Stat(filepath.Join(dir, parquetName+walSuffix)); !os.IsNotExist(err) {
+		t.Errorf("write-ahead file should be removed after Close, stat error = %v", err)
+	}
+
+	got, err := ReadFile(filepath.Join(dir, parquetName))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	assertEntries(t, got, entries)
+}
+
+func TestParquetWriter_RollsToNextPeriod(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := NewParquetWriter(tmpDir, PeriodDaily)
+	if err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	first := parquetEntries()[0]
+	second := first
+	second.SHA256 = "next"
+	second.ProcessedAt = first.ProcessedAt.Add(24 * time.Hour)
+
+	for _, e := range []Entry{first, second} {
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// The first day is finalized as soon as the second one starts
+	got, err := ReadFile(filepath.Join(tmpDir, "2024", "03", "15", parquetName))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	assertEntries(t, got, []Entry{first})
+
+	got, err = ReadFile(filepath.Join(tmpDir, "2024", "03", "16", parquetName+walSuffix))
+	if err != nil {
+		t.Fatalf("ReadFile of write-ahead file failed: %v", err)
+	}
+	assertEntries(t, got, []Entry{second})
+}
+
+func TestParquetWriter_RecoversWriteAheadFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	entries := parquetEntries()
+
+	// Finalize the first entry, then crash with the rest only in the
+	// write-ahead file
+	w, err := NewParquetWriter(tmpDir, PeriodHourly)
+	if err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	if err := w.Append(entries[0]); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if w, err = NewParquetWriter(tmpDir, PeriodHourly); err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+	for _, e := range entries[1:] {
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// A crash after the parquet rename but before the companion removal
+	// leaves an entry in both
+	dir := filepath.Join(tmpDir, "2024", "03", "15", "14")
+	if err := appendLine(filepath.Join(dir, parquetName+walSuffix), func() Entry {
+		e := entries[0]
+		e.SchemaVersion = CurrentSchemaVersion
+		return e
+	}()); err != nil {
+		t.Fatalf("appendLine failed: %v", err)
+	}
+	// and a crash in the middle of an append leaves a torn line
+	wal, err := os.OpenFile(filepath.Join(dir, parquetName+walSuffix), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open write-ahead file: %v", err)
+	}
+	_, _ = wal.WriteString(`{"schema_version":5,"sha256":"to`)
+	_ = wal.Close()
+
+	if _, err := NewParquetWriter(tmpDir, PeriodHourly); err != nil {
+		t.Fatalf("NewParquetWriter failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, parquetName+walSuffix)); !os.IsNotExist(err) {
+		t.Errorf("write-ahead file should be removed after recovery, stat error = %v", err)
+	}
+	got, err := ReadParquet(filepath.Join(dir, parquetName))
+	if err != nil {
+		t.Fatalf("ReadParquet failed: %v", err)
+	}
+	assertEntries(t, got, entries)
+}
+
+func TestNewParquetWriter_InvalidPeriod(t *testing.T) {
+	if _, err := NewParquetWriter(t.TempDir(), "weekly"); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
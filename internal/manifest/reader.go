@@ -0,0 +1,128 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxLineBytes caps the length of a manifest line when ReadOptions
+// sets none. Entries with large tags, parts and member lists stay well
+// below it.
+const DefaultMaxLineBytes = 4 << 20
+
+// readBuffer is the buffer lines are read through; longer lines are
+// assembled from several reads, up to the cap
+const readBuffer = 64 * 1024
+
+// ErrLineTooLong is matched by the error of a line over the cap
+var ErrLineTooLong = errors.New("manifest line too long")
+
+// LineTooLongError reports a manifest line over the cap
+type LineTooLongError struct {
+	// Path is the manifest file, empty when read from a plain reader
+	Path string
+	// Line is the 1-based number of the line
+	Line  int
+	Limit int
+}
+
+func (e *LineTooLongError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: manifest line longer than %d bytes", e.Line, e.Limit)
+	}
+	return fmt.Sprintf("%s line %d: manifest line longer than %d bytes", e.Path, e.Line, e.Limit)
+}
+
+func (e *LineTooLongError) Is(target error) bool { return target == ErrLineTooLong }
+
+// ReadOptions tunes how manifest lines are read
+type ReadOptions struct {
+	// MaxLineBytes caps the length of a line; 0 uses DefaultMaxLineBytes.
+	// Memory stays bounded by it however long a line is.
+	MaxLineBytes int
+	// Lenient skips lines over the cap instead of failing on the first
+	Lenient bool
+}
+
+// ReadStats reports what a read skipped
+type ReadStats struct {
+	// Oversized counts the lines over the cap skipped in lenient mode
+	Oversized int
+	// PartialTail is set when the input ended in a line without its newline
+	// that does not decode, as a writer crashing mid-write leaves it. Every
+	// acknowledged entry ends with a newline, so the line is skipped.
+	PartialTail bool
+}
+
+// Read decodes every non-empty line of a manifest file, upgrading each entry
+func Read(r io.Reader) ([]Entry, error) {
+	entries, _, err := ReadWith(r, ReadOptions{})
+	return entries, err
+}
+
+// ReadWith is Read with options. Lines over the cap fail the read with a
+// LineTooLongError, or are skipped and counted in lenient mode. A partial
+// last line is skipped and reported in the stats.
+func ReadWith(r io.Reader, opts ReadOptions) ([]Entry, ReadStats, error) {
+	limit := opts.MaxLineBytes
+	if limit <= 0 {
+		limit = DefaultMaxLineBytes
+	}
+
+	entries := make([]Entry, 0)
+	var stats ReadStats
+	br := bufio.NewReaderSize(r, readBuffer)
+	var buf []byte
+	for line := 1; ; line++ {
+		var tooLong bool
+		var err error
+		buf, tooLong, err = readLine(br, buf[:0], limit)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return entries, stats, fmt.Errorf("read manifest: %w", err)
+		}
+		done := err != nil
+
+		switch {
+		case tooLong && opts.Lenient:
+			stats.Oversized++
+		case tooLong:
+			return entries, stats, &LineTooLongError{Line: line, Limit: limit}
+		case len(bytes.TrimSpace(buf)) > 0:
+			entry, err := Decode(buf)
+			if err != nil && done {
+				stats.PartialTail = true
+				break
+			}
+			if err != nil {
+				return entries, stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		if done {
+			return entries, stats, nil
+		}
+	}
+}
+
+// readLine appends the next line of r to buf, without its newline. Past
+// limit bytes the rest of the line is consumed and dropped and tooLong
+// set. It returns io.EOF with the last line when r ends.
+func readLine(r *bufio.Reader, buf []byte, limit int) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		if !tooLong {
+			if len(buf)+len(chunk) > limit {
+				tooLong, buf = true, buf[:0]
+			} else {
+				buf = append(buf, chunk...)
+			}
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return buf, tooLong, err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestRead_MixedVersions(t *testing.T) {
+	input := strings.Join([]string{
+		`{"sha256":"a","name":"a.csv","size":1,"processed_at":"2024-03-15T14:30:00Z"}`,
+		``,
+		`{"schema_version":3,"sha256":"b","name":"b.csv","size":2,"processed_at":"2024-03-15T14:30:00Z","status":"ingested"}`,
+	}, "\n")
+
+	entries, err := Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Read() returned %d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.SchemaVersion != CurrentSchemaVersion || e.Status != status.Ingested {
+			t.Errorf("entry %s = version %d status %q", e.SHA256, e.SchemaVersion, e.Status)
+		}
+	}
+}
+
+// hugeEntry returns the JSON line of an entry whose tags take about size
+// bytes
+func hugeEntry(t *testing.T, sha string, size int) string {
+	t.Helper()
+	entry := Entry{
+		SHA256:      sha,
+		Name:        sha + ".csv",
+		Size:        1,
+		ProcessedAt: time.Date(2024, 3, 15, 14, 30, 0, 0, time.UTC),
+		Status:      status.Ingested,
+		Tags:        map[string]string{"blob": strings.Repeat("x", size)},
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("failed to marshal entry: %v", err)
+	}
+	return string(data)
+}
+
+func TestRead_MultiMegabyteEntry(t *testing.T) {
+	line := hugeEntry(t, "big", 3<<20)
+	entries, err := Read(strings.NewReader(line + "\n"))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 1 || len(entries[0].Tags["blob"]) != 3<<20 {
+		t.Fatalf("Read() did not return the whole 3MB entry")
+	}
+}
+
+func TestReadFileWith_ReportsLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.jsonl")
+	input := strings.Join([]string{
+		hugeEntry(t, "a", 10),
+		hugeEntry(t, "b", 2<<20),
+	}, "\n")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	_, _, err := ReadFileWith(path, ReadOptions{MaxLineBytes: 1 << 20})
+	if !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("ReadFileWith() error = %v, want ErrLineTooLong", err)
+	}
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Path != path || tooLong.Line != 2 {
+		t.Errorf("ReadFileWith() error = %v, want %s line 2", err, path)
+	}
+}
+
+func TestReadWith_LenientSkipsLongLines(t *testing.T) {
+	limit := 256 * 1024
+	input := strings.Join([]string{
+		hugeEntry(t, "a", 10),
+		hugeEntry(t, "huge1", 5*limit),
+		``,
+		hugeEntry(t, "b", limit-200),
+		hugeEntry(t, "huge2", limit),
+	}, "\n")
+
+	entries, stats, err := ReadWith(strings.NewReader(input), ReadOptions{MaxLineBytes: limit, Lenient: true})
+	if err != nil {
+		t.Fatalf("ReadWith() error = %v", err)
+	}
+	if stats.Oversized != 2 {
+		t.Errorf("Oversized = %d, want 2", stats.Oversized)
+	}
+	if len(entries) != 2 || entries[0].SHA256 != "a" || entries[1].SHA256 != "b" {
+		t.Errorf("ReadWith() returned %d entries, want a and b around the skipped lines", len(entries))
+	}
+}
+
+func TestReadWith_SkipsPartialTail(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		partial bool
+		wantErr bool
+	}{
+		{"complete", "{\"sha256\":\"a\"}\n{\"sha256\":\"b\"}\n", 2, false, false},
+		{"no final newline", "{\"sha256\":\"a\"}\n{\"sha256\":\"b\"}", 2, false, false},
+		{"torn last line", "{\"sha256\":\"a\"}\n{\"sha256\":\"b", 1, true, false},
+		{"torn line in the middle", "{\"sha256\":\"a\"}\n{\"sha256\":\"b\n{\"sha256\":\"c\"}\n", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, stats, err := ReadWith(strings.NewReader(tt.input), ReadOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadWith() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(entries) != tt.want {
+				t.Errorf("ReadWith() returned %d entries, want %d", len(entries), tt.want)
+			}
+			if stats.PartialTail != tt.partial {
+				t.Errorf("PartialTail = %v, want %v", stats.PartialTail, tt.partial)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Schema version history:
+//
+//	1: sha256, name, source_path, dest_path, size, processed_at (lines
+//	   without schema_version)
+//	2: status, reason, original_name
+//	3: tags, parts
+//	4: version, previous_sha256
+//	5: duplicate status, dedup
+//	6: idempotency_key, original_sha256
+//	7: self_test
+//	8: allocated_size
+//	9: members, members_size
+//	10: source_rel_path
+//	11: sequence
+//	12: restores
+//	13: supersedes
+//	14: link_count
+//	15: duplicate_summary status
+//	16: priority
+//	17: source_url
+//	18: backfilled
+const CurrentSchemaVersion = 18
+
+// ErrNewerSchema is returned when a line was written by a newer version than
+// this binary understands
+var ErrNewerSchema = errors.New("manifest entry has a newer schema version")
+
+// Decode parses one manifest line and upgrades it to CurrentSchemaVersion,
+// filling defaults for fields that did not exist when it was written
+func Decode(line []byte) (Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return entry, fmt.Errorf("decode manifest entry: %w", err)
+	}
+	return Upgrade(entry)
+}
+
+// Upgrade fills the defaults of fields added after entry's version and
+// rejects versions newer than CurrentSchemaVersion
+func Upgrade(entry Entry) (Entry, error) {
+	if entry.SchemaVersion == 0 {
+		entry.SchemaVersion = 1
+	}
+	if entry.SchemaVersion > CurrentSchemaVersion {
+		return entry, fmt.Errorf("%w: %d > %d", ErrNewerSchema, entry.SchemaVersion, CurrentSchemaVersion)
+	}
+
+	// Version 1 only recorded successful ingests
+	if entry.SchemaVersion < 2 && entry.Status == "" {
+		entry.Status = status.Ingested
+	}
+	if entry.Status != "" && !entry.Status.Valid() {
+		return entry, fmt.Errorf("manifest entry %s: %w %q", entry.SHA256, status.ErrUnknown, entry.Status)
+	}
+
+	entry.SchemaVersion = CurrentSchemaVersion
+	return entry, nil
+}
+
+// Schema returns the JSON Schema of the current Entry, generated from the
+// struct so it cannot drift from what Append writes
+func Schema() map[string]any {
+	schema := objectSchema(reflect.TypeOf(Entry{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "manifest entry"
+	schema["x-schema-version"] = CurrentSchemaVersion
+	return schema
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	statusType = reflect.TypeOf(status.Status(""))
+)
+
+func objectSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": true,
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t == statusType {
+		return map[string]any{"type": "string", "enum": status.All()}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return objectSchema(t)
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,114 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestDecode_UpgradesOlderVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantStatus status.Status
+	}{
+		{
+			name:       "version 1 without schema_version",
+			line:       `{"sha256":"a","name":"a.csv","source_path":"/in/a.csv","dest_path":"/wh/a.csv","size":1,"processed_at":"2024-03-15T14:30:00Z"}`,
+			wantStatus: status.Ingested,
+		},
+		{
+			name:       "version 2 quarantined",
+			line:       `{"schema_version":2,"sha256":"b","name":"b.csv","source_path":"/in/b.csv","dest_path":"/q/b.csv","size":1,"processed_at":"2024-03-15T14:30:00Z","status":"quarantined","reason":"path_too_long"}`,
+			wantStatus: status.Quarantined,
+		},
+		{
+			name:       "current version",
+			line:       `{"schema_version":3,"sha256":"c","name":"c.csv","source_path":"/in/c.csv","dest_path":"/wh/c.csv","size":1,"processed_at":"2024-03-15T14:30:00Z","status":"ingested","tags":{"tier":"bulk"}}`,
+			wantStatus: status.Ingested,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := Decode([]byte(tt.line))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if entry.SchemaVersion != CurrentSchemaVersion {
+				t.Errorf("SchemaVersion = %d, want %d", entry.SchemaVersion, CurrentSchemaVersion)
+			}
+			if entry.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", entry.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDecode_RejectsNewerVersion(t *testing.T) {
+	_, err := Decode([]byte(`{"schema_version":999,"sha256":"x"}`))
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("Decode() error = %v, want ErrNewerSchema", err)
+	}
+}
+
+func TestWriter_AppendStampsSchemaVersion(t *testing.T) {
+	w := NewWriter(t.TempDir())
+	entry := Entry{SHA256: "v", ProcessedAt: time.Date(2024, 3, 15, 14, 0, 0, 0, time.UTC)}
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	data, err := os.ReadFile(w.manifestPath(entry))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("failed to unmarshal entry: %v", err)
+	}
+	if raw["schema_version"] != float64(CurrentSchemaVersion) {
+		t.Errorf("schema_version = %v, want %d", raw["schema_version"], CurrentSchemaVersion)
+	}
+}
+
+func TestSchema_CoversEntryFields(t *testing.T) {
+	schema := Schema()
+	properties := schema["properties"].(map[string]any)
+
+	typ := reflect.TypeOf(Entry{})
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if _, ok := properties[name]; !ok {
+			t.Errorf("schema missing property %q", name)
+		}
+	}
+
+	required := schema["required"].([]string)
+	for _, name := range required {
+		if name == "tags" || name == "status" {
+			t.Errorf("optional field %q listed as required", name)
+		}
+	}
+
+	processedAt := properties["processed_at"].(map[string]any)
+	if processedAt["format"] != "date-time" {
+		t.Errorf("processed_at format = %v, want date-time", processedAt["format"])
+	}
+
+	parts := properties["parts"].(map[string]any)
+	items := parts["items"].(map[string]any)
+	if items["type"] != "object" {
+		t.Errorf("parts items type = %v, want object", items["type"])
+	}
+
+	if _, err := json.Marshal(schema); err != nil {
+		t.Errorf("schema is not serializable: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package manifest
+
+import (
+	"cmp"
+	"errors"
+	"io/fs"
+	"slices"
+	"time"
+)
+
+// Reader reads back manifest entries, from files or from the database,
+// whichever sink they were written to
+type Reader interface {
+	// ManifestEntries returns the entries processed between from and to
+	// inclusive, in sequence order; see SortBySequence
+	ManifestEntries(from, to time.Time) ([]Entry, error)
+}
+
+// EntryStore keeps manifest entries in a database
+type EntryStore interface {
+	Reader
+	AppendManifestEntries(entries ...Entry) error
+}
+
+// SetDatabase makes Append record every entry in store, in addition to the
+// manifest files or, when files is false, instead of them. Call before the
+// first Append.
+func (w *Writer) SetDatabase(store EntryStore, files bool) {
+	w.db = store
+	w.noFiles = !files
+}
+
+// InDatabase reports whether entries are recorded in the database. Callers
+// creating the record of an ingested file then commit its prepared entry in
+// the same transaction and hand it to AppendCommitted.
+func (w *Writer) InDatabase() bool {
+	return w.db != nil
+}
+
+// WritesFiles reports whether entries are written to manifest files, and
+// so can fail to be after the record of their file committed
+func (w *Writer) WritesFiles() bool {
+	return !w.noFiles
+}
+
+// AppendCommitted is Append for an entry already committed to the database
+// with the record of its file: it is only written to the manifest files.
+func (w *Writer) AppendCommitted(entry Entry) error {
+	return w.append(w.Prepare(entry), false)
+}
+
+// ManifestEntries implements Reader. Entries are read from the manifest
+// files covering the time range, or from the database when it is the only
+// sink.
+func (w *Writer) ManifestEntries(from, to time.Time) ([]Entry, error) {
+	if w.noFiles {
+		return w.db.ManifestEntries(from, to)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Files are named by the time of their entries, local for the
+	// processor's, so the names in both local time and UTC of every hour
+	// touching the range are visited, along with the late entries of
+	// finalized days
+	read := make(map[string]bool)
+	seen := make(map[string]bool)
+	entries := make([]Entry, 0)
+	for t := from; ; t = t.Add(time.Hour) {
+		t = minTime(t, to)
+		paths := append(w.Files(t.Local()), w.Files(t.UTC())...)
+		paths = append(paths, w.latePath(t.Local()), w.latePath(t.UTC()))
+		for _, path := range paths {
+			if read[path] {
+				continue
+			}
+			read[path] = true
+			found, err := ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return entries, err
+			}
+			for _, e := range found {
+				// A parquet period caught between finalizing and removing
+				// its companion holds entries twice
+				if e.ProcessedAt.Before(from) || e.ProcessedAt.After(to) || seen[entryKey(e)] {
+					continue
+				}
+				seen[entryKey(e)] = true
+				entries = append(entries, e)
+			}
+		}
+		if !t.Before(to) {
+			break
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return a.ProcessedAt.Compare(b.ProcessedAt)
+	})
+	SortBySequence(entries)
+	return entries, nil
+}
+
+// SortBySequence orders entries sorted by ProcessedAt by their sequence
+// numbers, however they were interleaved in the files. Entries without one
+// keep their place after the numbered entry preceding them.
+func SortBySequence(entries []Entry) {
+	// Each entry sorts by the number of the latest numbered entry up to it
+	keys := make([]int64, len(entries))
+	var last int64
+	for i, e := range entries {
+		if e.Sequence > 0 {
+			last = e.Sequence
+		}
+		keys[i] = last
+	}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if c := cmp.Compare(keys[a], keys[b]); c != 0 {
+			return c
+		}
+		// A numbered entry comes before those carrying its number
+		return cmp.Compare(entries[b].Sequence, entries[a].Sequence)
+	})
+	sorted := make([]Entry, len(entries))
+	for i, k := range order {
+		sorted[i] = entries[k]
+	}
+	copy(entries, sorted)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,164 @@
+package manifest
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory EntryStore
+type memStore struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (m *memStore) AppendManifestEntries(entries ...Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+func (m *memStore) ManifestEntries(from, to time.Time) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []Entry
+	for _, e := range m.entries {
+		if !e.ProcessedAt.Before(from) && !e.ProcessedAt.After(to) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// stamped returns entries as Append records them
+func stamped(entries []Entry) []Entry {
+	out := make([]Entry, len(entries))
+	for i, e := range entries {
+		e.SchemaVersion = CurrentSchemaVersion
+		out[i] = e
+	}
+	return out
+}
+
+func TestWriter_ManifestEntries(t *testing.T) {
+	entries := parquetEntries()
+	// Spread the entries over several hourly files
+	for i := range entries {
+		entries[i].ProcessedAt = entries[i].ProcessedAt.Add(time.Duration(i) * time.Hour)
+	}
+
+	for _, format := range []string{FormatJSONL, FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			w := NewWriter(dir)
+			if format == FormatParquet {
+				var err error
+				if w, err = NewParquetWriter(dir, PeriodDaily); err != nil {
+					t.Fatalf("NewParquetWriter() error = %v", err)
+				}
+			}
+			for _, e := range entries {
+				if err := w.Append(e); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			got, err := w.ManifestEntries(entries[1].ProcessedAt, entries[3].ProcessedAt)
+			if err != nil {
+				t.Fatalf("ManifestEntries() error = %v", err)
+			}
+			if want := stamped(entries[1:4]); !reflect.DeepEqual(got, want) {
+				t.Errorf("ManifestEntries() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriter_DatabaseSink(t *testing.T) {
+	entries := parquetEntries()
+	from, to := entries[0].ProcessedAt, entries[len(entries)-1].ProcessedAt
+
+	t.Run("both", func(t *testing.T) {
+		dir := t.TempDir()
+		store := &memStore{}
+		w := NewWriter(dir)
+		w.SetDatabase(store, true)
+		if !w.InDatabase() {
+			t.Fatal("InDatabase() = false, want true")
+		}
+		if err := w.Append(entries[0]); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		// The database row of a committed entry was written with its record
+		if err := w.AppendCommitted(entries[1]); err != nil {
+			t.Fatalf("AppendCommitted() error = %v", err)
+		}
+
+		if want := stamped(entries[:1]); !reflect.DeepEqual(store.entries, want) {
+			t.Errorf("database entries = %+v, want %+v", store.entries, want)
+		}
+		got, err := w.ManifestEntries(from, to)
+		if err != nil {
+			t.Fatalf("ManifestEntries() error = %v", err)
+		}
+		if want := stamped(entries[:2]); !reflect.DeepEqual(got, want) {
+			t.Errorf("file entries = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("db", func(t *testing.T) {
+		dir := t.TempDir()
+		store := &memStore{}
+		w := NewWriter(dir)
+		w.SetDatabase(store, false)
+		for _, e := range entries {
+			if err := w.Append(e); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+		}
+		if err := w.AppendCommitted(entries[0]); err != nil {
+			t.Fatalf("AppendCommitted() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		if files, _ := os.ReadDir(dir); len(files) != 0 {
+			t.Errorf("manifests directory holds %d entries, want none", len(files))
+		}
+		got, err := w.ManifestEntries(from, to)
+		if err != nil {
+			t.Fatalf("ManifestEntries() error = %v", err)
+		}
+		if want := stamped(entries); !reflect.DeepEqual(got, want) {
+			t.Errorf("ManifestEntries() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestSortBySequence(t *testing.T) {
+	at := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Name: "b", Sequence: 2, ProcessedAt: at},
+		{Name: "a", Sequence: 1, ProcessedAt: at},
+		{Name: "unnumbered", ProcessedAt: at},
+		{Name: "d", Sequence: 4, ProcessedAt: at},
+		{Name: "c", Sequence: 3, ProcessedAt: at},
+	}
+	SortBySequence(entries)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	// The unnumbered entry stays after a, which preceded it
+	if want := []string{"a", "unnumbered", "b", "c", "d"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("SortBySequence() = %v, want %v", names, want)
+	}
+}
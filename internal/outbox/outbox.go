@@ -0,0 +1,248 @@
+// Package outbox delivers the notifications the processor writes to the
+// outbox table of the state database. Messages are deleted only once their
+// sender accepted them, so they survive restarts and outages of the
+// receiver, and are retried with exponential backoff. Delivery is at least
+// once: a crash between the send and the delete sends a message again.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+const (
+	// DefaultInterval is how often the dispatcher looks for due messages it
+	// was not woken for
+	DefaultInterval = time.Second
+	// MinBackoff is the wait after the first failed delivery
+	MinBackoff = time.Second
+	// MaxBackoff caps the wait between deliveries of a failing message
+	MaxBackoff = 5 * time.Minute
+)
+
+// batchSize is how many due messages are read at a time
+const batchSize = 100
+
+// Sender delivers one message to its receiver
+type Sender interface {
+	Send(ctx context.Context, msg storage.OutboxMessage) error
+}
+
+// Stats reports the outbox and the deliveries since the dispatcher started
+type Stats struct {
+	storage.OutboxStatus
+	// OldestPendingAge is how long the oldest ready message has waited
+	OldestPendingAge time.Duration `json:"oldest_pending_age_ns"`
+	Delivered        int64         `json:"delivered"`
+	Failures         int64         `json:"failures"`
+	// Dropped counts the messages deleted undelivered to keep the outbox
+	// within its limit
+	Dropped int64 `json:"dropped"`
+}
+
+// FlushResult is the outcome of a Flush
+type FlushResult struct {
+	Delivered int   `json:"delivered"`
+	Remaining int64 `json:"remaining"`
+}
+
+// Dispatcher sends the messages of the outbox in the order they were
+// written
+type Dispatcher struct {
+	store    *storage.Storage
+	sender   Sender
+	limit    int
+	interval time.Duration
+	// minBackoff and maxBackoff bound the wait after failed deliveries
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	wake chan struct{}
+	// mu serializes delivery passes and guards backoff
+	mu      sync.Mutex
+	backoff time.Duration
+
+	delivered atomic.Int64
+	failures  atomic.Int64
+	dropped   atomic.Int64
+}
+
+// New creates a Dispatcher delivering the outbox of store through sender,
+// keeping at most limit undelivered messages (0 is unlimited)
+func New(store *storage.Storage, sender Sender, limit int) *Dispatcher {
+	return &Dispatcher{
+		store:      store,
+		sender:     sender,
+		limit:      limit,
+		interval:   DefaultInterval,
+		minBackoff: MinBackoff,
+		maxBackoff: MaxBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the dispatcher for a message that became ready. It never
+// blocks.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers messages until ctx is done, starting at once with those left
+// undelivered by an earlier run. After a failed delivery it waits out the
+// backoff before trying again.
+func (d *Dispatcher) Run(ctx context.Context) {
+	if err := d.store.RetryOutboxNow(time.Now()); err != nil {
+		slog.Error("failed to retry outbox", "error", err)
+	}
+	for {
+		supervisor.Beat(ctx)
+		if _, err := d.deliver(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to deliver outbox", "error", err)
+		}
+		d.mu.Lock()
+		backoff := d.backoff
+		d.mu.Unlock()
+		if !d.wait(ctx, max(backoff, d.interval), backoff > 0) {
+			return
+		}
+	}
+}
+
+// wait blocks for wait, or until Notify when no backoff is in progress, and
+// reports false once ctx is done
+func (d *Dispatcher) wait(ctx context.Context, wait time.Duration, backingOff bool) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-d.wake:
+			if !backingOff {
+				return true
+			}
+		}
+	}
+}
+
+// Flush ends every backoff and delivers the outbox now, returning how many
+// messages were delivered and how many remain
+func (d *Dispatcher) Flush(ctx context.Context) (FlushResult, error) {
+	if err := d.store.RetryOutboxNow(time.Now()); err != nil {
+		return FlushResult{}, err
+	}
+	d.mu.Lock()
+	d.backoff = 0
+	d.mu.Unlock()
+
+	delivered, err := d.deliver(ctx)
+	res := FlushResult{Delivered: delivered}
+	status, serr := d.store.OutboxStatus()
+	if serr != nil && err == nil {
+		err = serr
+	}
+	res.Remaining = status.Depth
+	return res, err
+}
+
+// deliver trims the outbox to its limit and sends ready messages oldest
+// first. A failure ends the pass, and so does a message not yet due: no
+// message is sent past one waiting for a retry.
+func (d *Dispatcher) deliver(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.limit > 0 {
+		dropped, err := d.store.TrimOutbox(d.limit)
+		if err != nil {
+			return 0, err
+		}
+		if dropped > 0 {
+			d.dropped.Add(dropped)
+			slog.Warn("outbox full, dropped oldest undelivered notifications",
+				"dropped", dropped,
+				"dropped_total", d.dropped.Load(),
+				"limit", d.limit,
+			)
+		}
+	}
+
+	delivered := 0
+	for {
+		msgs, err := d.store.PendingOutbox(batchSize)
+		if err != nil || len(msgs) == 0 {
+			return delivered, err
+		}
+		for _, msg := range msgs {
+			if err := ctx.Err(); err != nil {
+				return delivered, err
+			}
+			if msg.NextAttemptAt.After(time.Now()) {
+				return delivered, nil
+			}
+			if err := d.sender.Send(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					// Shutting down, not a failure of the receiver
+					return delivered, ctx.Err()
+				}
+				d.failures.Add(1)
+				d.backoff = d.nextBackoff()
+				slog.Warn("failed to deliver notification, will retry",
+					"id", msg.ID,
+					"kind", msg.Kind,
+					"attempts", msg.Attempts+1,
+					"retry_in", d.backoff,
+					"error", err,
+				)
+				if derr := d.store.DeferOutbox(msg.ID, time.Now().Add(d.backoff), err.Error()); derr != nil {
+					return delivered, derr
+				}
+				return delivered, nil
+			}
+			d.backoff = 0
+			if err := d.store.DeleteOutbox(msg.ID); err != nil {
+				return delivered, fmt.Errorf("acknowledge delivered notification %d: %w", msg.ID, err)
+			}
+			d.delivered.Add(1)
+			delivered++
+		}
+	}
+}
+
+// nextBackoff doubles the backoff from minBackoff up to maxBackoff
+func (d *Dispatcher) nextBackoff() time.Duration {
+	if d.backoff <= 0 {
+		return d.minBackoff
+	}
+	return min(2*d.backoff, d.maxBackoff)
+}
+
+// Stats returns the state of the outbox
+func (d *Dispatcher) Stats() (Stats, error) {
+	status, err := d.store.OutboxStatus()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{
+		OutboxStatus: status,
+		Delivered:    d.delivered.Load(),
+		Failures:     d.failures.Load(),
+		Dropped:      d.dropped.Load(),
+	}
+	if !status.OldestPending.IsZero() {
+		stats.OldestPendingAge = time.Since(status.OldestPending)
+	}
+	return stats, nil
+}
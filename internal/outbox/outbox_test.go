@@ -0,0 +1,218 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openStore opens the state database at path, as a restart would
+func openStore(t *testing.T, path string) *storage.Storage {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// enqueue writes ready messages with the given payloads
+func enqueue(t *testing.T, store *storage.Storage, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if err := store.EnqueueOutbox(&storage.OutboxMessage{Kind: "file_ingested", Payload: payload, Ready: true}); err != nil {
+			t.Fatalf("EnqueueOutbox failed: %v", err)
+		}
+	}
+}
+
+// recorder is a Sender recording the payloads it accepted, failing while
+// down is set
+type recorder struct {
+	mu    sync.Mutex
+	down  bool
+	tries int
+	sent  []string
+}
+
+func (r *recorder) Send(_ context.Context, msg storage.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tries++
+	if r.down {
+		return errors.New("receiver unavailable")
+	}
+	r.sent = append(r.sent, msg.Payload)
+	return nil
+}
+
+func (r *recorder) snapshot() (int, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tries, slices.Clone(r.sent)
+}
+
+// fastDispatcher is a Dispatcher with backoff and polling shortened for
+// tests
+func fastDispatcher(store *storage.Storage, sender Sender, limit int) *Dispatcher {
+	d := New(store, sender, limit)
+	d.interval = 10 * time.Millisecond
+	d.minBackoff = 10 * time.Millisecond
+	d.maxBackoff = 40 * time.Millisecond
+	return d
+}
+
+// start runs d until the returned function is called, which waits for Run
+// to return
+func start(d *Dispatcher) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor polls cond for up to two seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcher_DeliversAfterRestartMidOutage(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "state.db")
+	store := openStore(t, dbPath)
+	enqueue(t, store, "a", "b", "c")
+
+	down := &recorder{down: true}
+	d := fastDispatcher(store, down, 0)
+	stop := start(d)
+	waitFor(t, "retries during the outage", func() bool {
+		tries, _ := down.snapshot()
+		return tries >= 3
+	})
+	stop()
+
+	stats, err := d.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Depth != 3 || stats.Delivered != 0 || stats.Failures < 3 || stats.OldestPendingAge <= 0 {
+		t.Fatalf("stats after the outage = %+v, want 3 pending and no delivery", stats)
+	}
+
+	// A new process finds the messages in the database
+	up := &recorder{}
+	restarted := fastDispatcher(openStore(t, dbPath), up, 0)
+	stop = start(restarted)
+	defer stop()
+	waitFor(t, "delivery after the restart", func() bool {
+		_, sent := up.snapshot()
+		return len(sent) == 3
+	})
+	if _, sent := up.snapshot(); !slices.Equal(sent, []string{"a", "b", "c"}) {
+		t.Errorf("delivered %v, want every message in order", sent)
+	}
+	waitFor(t, "an empty outbox", func() bool {
+		stats, err := restarted.Stats()
+		return err == nil && stats.Depth == 0 && stats.OldestPendingAge == 0
+	})
+}
+
+func TestDispatcher_FailureHoldsLaterMessages(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	enqueue(t, store, "a", "b")
+
+	sender := &recorder{down: true}
+	d := fastDispatcher(store, sender, 0)
+	if _, err := d.deliver(context.Background()); err != nil {
+		t.Fatalf("deliver failed: %v", err)
+	}
+	if tries, _ := sender.snapshot(); tries != 1 {
+		t.Errorf("sends in a failing pass = %d, want the pass to stop at the first failure", tries)
+	}
+	if d.backoff != d.minBackoff {
+		t.Errorf("backoff = %v, want %v", d.backoff, d.minBackoff)
+	}
+	// The failed message is retried once due, still ahead of the next
+	if _, err := d.deliver(context.Background()); err != nil {
+		t.Fatalf("deliver failed: %v", err)
+	}
+	if tries, _ := sender.snapshot(); tries != 1 {
+		t.Errorf("sends before the retry is due = %d, want 1", tries)
+	}
+	time.Sleep(d.minBackoff)
+	if _, err := d.deliver(context.Background()); err != nil {
+		t.Fatalf("deliver failed: %v", err)
+	}
+	if tries, _ := sender.snapshot(); tries != 2 {
+		t.Errorf("sends once the retry is due = %d, want 2", tries)
+	}
+	if d.backoff != 2*d.minBackoff {
+		t.Errorf("backoff after a second failure = %v, want it doubled", d.backoff)
+	}
+}
+
+func TestDispatcher_FlushSkipsBackoff(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	enqueue(t, store, "a")
+	due, _ := store.PendingOutbox(1)
+	if err := store.DeferOutbox(due[0].ID, time.Now().Add(time.Hour), "down"); err != nil {
+		t.Fatalf("DeferOutbox failed: %v", err)
+	}
+
+	sender := &recorder{}
+	d := New(store, sender, 0)
+	res, err := d.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if res.Delivered != 1 || res.Remaining != 0 {
+		t.Errorf("Flush() = %+v, want the deferred message delivered", res)
+	}
+}
+
+func TestDispatcher_LimitDropsOldest(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	enqueue(t, store, "a", "b", "c", "d")
+
+	sender := &recorder{}
+	d := New(store, sender, 2)
+	if _, err := d.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if _, sent := sender.snapshot(); !slices.Equal(sent, []string{"c", "d"}) {
+		t.Errorf("delivered %v, want the newest messages within the limit", sent)
+	}
+	if stats, _ := d.Stats(); stats.Dropped != 2 || stats.Delivered != 2 {
+		t.Errorf("stats = %+v, want 2 dropped and 2 delivered", stats)
+	}
+}
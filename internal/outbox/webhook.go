@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// DefaultWebhookTimeout bounds one webhook delivery
+const DefaultWebhookTimeout = 10 * time.Second
+
+// Webhook headers. Deliveries are at least once, so receivers deduplicate
+// by the message ID. Messages sent outside the outbox, such as replays, have
+// none.
+const (
+	HeaderEventKind = "X-Event-Kind"
+	HeaderMessageID = "X-Message-Id"
+)
+
+// Webhook sends each message as a JSON POST to a URL; any 2xx response
+// acknowledges it
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: DefaultWebhookTimeout}}
+}
+
+// Send posts the payload of msg
+func (w *Webhook) Send(ctx context.Context, msg storage.OutboxMessage) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader([]byte(msg.Payload)))
+	if err != nil {
+		return fmt.Errorf("create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventKind, msg.Kind)
+	if msg.ID != 0 {
+		req.Header.Set(HeaderMessageID, strconv.FormatUint(uint64(msg.ID), 10))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
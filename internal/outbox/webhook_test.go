@@ -0,0 +1,44 @@
+package outbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, body = r, string(data)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	msg := storage.OutboxMessage{ID: 7, Kind: "file_ingested", Payload: `{"kind":"file_ingested"}`}
+	if err := NewWebhook(srv.URL).Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got.Method != http.MethodPost || body != msg.Payload {
+		t.Errorf("request = %s %q, want a POST of the payload", got.Method, body)
+	}
+	if got.Header.Get("Content-Type") != "application/json" || got.Header.Get(HeaderEventKind) != "file_ingested" || got.Header.Get(HeaderMessageID) != "7" {
+		t.Errorf("headers = %v", got.Header)
+	}
+}
+
+func TestWebhook_SendRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	if err := NewWebhook(srv.URL).Send(context.Background(), storage.OutboxMessage{ID: 1}); err == nil {
+		t.Error("Send succeeded against a failing receiver")
+	}
+}
@@ -0,0 +1,278 @@
+package plan
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// Status is the planned outcome for a single input file
+type Status string
+
+const (
+	// StatusNew files would be ingested
+	StatusNew Status = "new"
+	// StatusDuplicate files match an existing record or an earlier file in the scan
+	StatusDuplicate Status = "duplicate"
+	// StatusFiltered files are ignored by the watcher (excluded, hidden,
+	// temp, sidecar)
+	StatusFiltered Status = "filtered"
+	// StatusWaiting files are not yet complete (no sidecar in sidecar mode)
+	// or are ingested with their batch or multi-part set
+	StatusWaiting Status = "waiting"
+	// StatusHeld files are held after shrinking until released
+	StatusHeld Status = "held"
+	// StatusCandidate files pass filtering but were not hashed (no-hash mode)
+	StatusCandidate Status = "candidate"
+)
+
+// Item describes the planned outcome for one file
+type Item struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Status      Status `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// Summary aggregates counts and bytes per planned status
+type Summary struct {
+	Files       map[Status]int   `json:"files"`
+	Bytes       map[Status]int64 `json:"bytes"`
+	TotalFiles  int              `json:"total_files"`
+	TotalBytes  int64            `json:"total_bytes"`
+	IngestBytes int64            `json:"ingest_bytes"`
+}
+
+// Report is the result of a plan run
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Input       string    `json:"input"`
+	Method      string    `json:"method"`
+	Hashed      bool      `json:"hashed"`
+	Summary     Summary   `json:"summary"`
+	Items       []Item    `json:"items"`
+}
+
+// Router routes files as the daemon does, a *watcher.Watcher configured
+// like the daemon's and never started
+type Router interface {
+	Route(path string) watcher.Route
+	SkipsDir(path string, d fs.DirEntry) bool
+}
+
+// Options controls a plan run
+type Options struct {
+	// Root is the input directory to scan
+	Root string
+	// Method is the global completion detection mode the daemon would use
+	Method string
+	// Router decides which files the daemon would track and how
+	Router Router
+	// ShrinkRelease is how long a file held after shrinking stays held,
+	// zero for until an operator releases it
+	ShrinkRelease time.Duration
+	// NoHash only reports names and sizes, skipping hashing and DB lookups
+	NoHash bool
+}
+
+// Planner scans an input directory and reports what an ingestion run would
+// do, without moving files or writing any state
+type Planner struct {
+	store storage.Reader
+	opts  Options
+}
+
+// New creates a new Planner. store may be nil when there is no state
+// database yet, in which case every hashed file is reported as new.
+func New(store storage.Reader, opts Options) *Planner {
+	return &Planner{store: store, opts: opts}
+}
+
+// Run scans the input directory and builds the report
+func (p *Planner) Run(ctx context.Context) (*Report, error) {
+	report := &Report{
+		GeneratedAt: time.Now(),
+		Input:       p.opts.Root,
+		Method:      p.opts.Method,
+		Hashed:      !p.opts.NoHash,
+		Items:       make([]Item, 0),
+	}
+
+	// Hash of every file planned as new in this run, so identical files
+	// within the input are reported as duplicates of the first one
+	planned := make(map[string]string)
+
+	err := filepath.WalkDir(p.opts.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() && path != p.opts.Root && p.opts.Router.SkipsDir(path, d) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+
+		item, err := p.planFile(path, info.Size(), planned)
+		if err != nil {
+			return err
+		}
+		report.Items = append(report.Items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan input %s: %w", p.opts.Root, err)
+	}
+
+	report.Summary = summarize(report.Items)
+	return report, nil
+}
+
+func (p *Planner) planFile(path string, size int64, planned map[string]string) (Item, error) {
+	item := Item{Path: path, Size: size}
+
+	route := p.opts.Router.Route(path)
+	switch route.Skip {
+	case "":
+	case watcher.SkipBatch, watcher.SkipFileSet:
+		item.Status = StatusWaiting
+		item.Reason = route.Skip
+		return item, nil
+	default:
+		item.Status = StatusFiltered
+		item.Reason = route.Skip
+		return item, nil
+	}
+	if route.Method == config.MethodSidecar {
+		if _, err := os.Stat(path + config.SidecarSuffix); err != nil {
+			item.Status = StatusWaiting
+			item.Reason = "no sidecar marker"
+			return item, nil
+		}
+	}
+
+	if p.store != nil {
+		hold, err := p.store.FindHold(path)
+		if err != nil {
+			return item, err
+		}
+		// A hold lifted since, timed out or of a file that changed lets it in
+		if hold != nil && hold.ReleasedAt == nil && hold.Size == size &&
+			(p.opts.ShrinkRelease <= 0 || time.Since(hold.HeldAt) < p.opts.ShrinkRelease) {
+			item.Status = StatusHeld
+			item.Reason = "smaller than the file last ingested from its path"
+			return item, nil
+		}
+	}
+
+	if p.opts.NoHash {
+		item.Status = StatusCandidate
+		return item, nil
+	}
+
+	hash, err := fileops.CalculateSHA256(path)
+	if err != nil {
+		return item, fmt.Errorf("calculate SHA256 for %s: %w", path, err)
+	}
+	item.SHA256 = hash
+
+	if p.store != nil {
+		existing, err := p.store.FindBySHA256(hash)
+		if err != nil {
+			return item, err
+		}
+		if existing != nil {
+			item.Status = StatusDuplicate
+			item.DuplicateOf = existing.DestPath
+			if item.DuplicateOf == "" {
+				item.DuplicateOf = existing.Path
+			}
+			return item, nil
+		}
+	}
+
+	if first, ok := planned[hash]; ok {
+		item.Status = StatusDuplicate
+		item.DuplicateOf = first
+		return item, nil
+	}
+
+	planned[hash] = path
+	item.Status = StatusNew
+	return item, nil
+}
+
+func summarize(items []Item) Summary {
+	summary := Summary{
+		Files: make(map[Status]int),
+		Bytes: make(map[Status]int64),
+	}
+	for _, item := range items {
+		summary.Files[item.Status]++
+		summary.Bytes[item.Status] += item.Size
+		summary.TotalFiles++
+		summary.TotalBytes += item.Size
+		if item.Status == StatusNew || item.Status == StatusCandidate {
+			summary.IngestBytes += item.Size
+		}
+	}
+	return summary
+}
+
+// WriteJSON writes the full report as indented JSON
+func WriteJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes one row per item with a header row
+func WriteCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "size", "status", "reason", "sha256", "duplicate_of"}); err != nil {
+		return fmt.Errorf("write csv header: %w", err)
+	}
+	for _, item := range report.Items {
+		row := []string{
+			item.Path,
+			strconv.FormatInt(item.Size, 10),
+			string(item.Status),
+			item.Reason,
+			item.SHA256,
+			item.DuplicateOf,
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("write csv row: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("flush csv: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type testEnv struct {
+	root         string
+	inputDir     string
+	warehouseDir string
+	manifestsDir string
+	dbPath       string
+}
+
+func openDB(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		if sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// setupTestEnv creates an input directory with one file of each planned
+// status and a state database that already knows one hash
+func setupTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	root := t.TempDir()
+	env := &testEnv{
+		root:         root,
+		inputDir:     filepath.Join(root, "input"),
+		warehouseDir: filepath.Join(root, "warehouse"),
+		manifestsDir: filepath.Join(root, "manifests"),
+		dbPath:       filepath.Join(root, "state", "state.db"),
+	}
+	for _, dir := range []string{env.inputDir, env.warehouseDir, env.manifestsDir, filepath.Dir(env.dbPath)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	writeFile(t, filepath.Join(env.inputDir, "new.csv"), "brand new")
+	writeFile(t, filepath.Join(env.inputDir, "sub", "again.csv"), "brand new")
+	writeFile(t, filepath.Join(env.inputDir, "old.csv"), "seen before")
+	writeFile(t, filepath.Join(env.inputDir, ".hidden.csv"), "hidden")
+	writeFile(t, filepath.Join(env.inputDir, "upload.csv.part"), "partial")
+
+	oldHash, err := fileops.CalculateSHA256(filepath.Join(env.inputDir, "old.csv"))
+	if err != nil {
+		t.Fatalf("failed to hash: %v", err)
+	}
+
+	store := storage.New(openDB(t, env.dbPath))
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if _, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256:   oldHash,
+		Name:     "old.csv",
+		Size:     11,
+		Status:   status.Adopted,
+		DestPath: "/warehouse/old.csv",
+	}); err != nil {
+		t.Fatalf("failed to seed record: %v", err)
+	}
+
+	return env
+}
+
+// router returns a watcher of the input directory routing files by method,
+// never started
+func router(t *testing.T, env *testEnv, method string) *watcher.Watcher {
+	t.Helper()
+
+	w, err := watcher.New(method, env.inputDir, 0)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// snapshot records every entry under root with its size, mtime and content hash
+func snapshot(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	entries := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state := info.Mode().String() + " " + info.ModTime().String()
+		if d.Type().IsRegular() {
+			hash, err := fileops.CalculateSHA256(path)
+			if err != nil {
+				return err
+			}
+			state += " " + hash
+		}
+		entries[path] = state
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to snapshot %s: %v", root, err)
+	}
+	return entries
+}
+
+func assertUnchanged(t *testing.T, before, after map[string]string) {
+	t.Helper()
+
+	for path, state := range before {
+		if after[path] != state {
+			t.Errorf("%s changed: %q -> %q", path, state, after[path])
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			t.Errorf("%s was created", path)
+		}
+	}
+}
+
+func itemsByName(report *Report) map[string]Item {
+	items := make(map[string]Item)
+	for _, item := range report.Items {
+		items[filepath.Base(item.Path)] = item
+	}
+	return items
+}
+
+func TestRun(t *testing.T) {
+	env := setupTestEnv(t)
+	before := snapshot(t, env.root)
+
+	store, err := storage.OpenReadOnly(env.dbPath)
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	report, err := New(store, Options{Root: env.inputDir, Method: config.MethodStabilityWindow, Router: router(t, env, config.MethodStabilityWindow)}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	items := itemsByName(report)
+	tests := []struct {
+		name   string
+		status Status
+	}{
+		{"new.csv", StatusNew},
+		{"again.csv", StatusDuplicate},
+		{"old.csv", StatusDuplicate},
+		{".hidden.csv", StatusFiltered},
+		{"upload.csv.part", StatusFiltered},
+	}
+	for _, tt := range tests {
+		if got := items[tt.name].Status; got != tt.status {
+			t.Errorf("%s: status = %q, want %q", tt.name, got, tt.status)
+		}
+	}
+
+	// WalkDir visits sub/again.csv after new.csv, so new.csv is planned first
+	if got, want := items["again.csv"].DuplicateOf, filepath.Join(env.inputDir, "new.csv"); got != want {
+		t.Errorf("again.csv duplicate_of = %q, want %q", got, want)
+	}
+	if got := items["old.csv"].DuplicateOf; got != "/warehouse/old.csv" {
+		t.Errorf("old.csv duplicate_of = %q, want /warehouse/old.csv", got)
+	}
+
+	if report.Summary.TotalFiles != 5 {
+		t.Errorf("TotalFiles = %d, want 5", report.Summary.TotalFiles)
+	}
+	if report.Summary.Files[StatusNew] != 1 {
+		t.Errorf("new files = %d, want 1", report.Summary.Files[StatusNew])
+	}
+	if report.Summary.IngestBytes != int64(len("brand new")) {
+		t.Errorf("IngestBytes = %d, want %d", report.Summary.IngestBytes, len("brand new"))
+	}
+
+	assertUnchanged(t, before, snapshot(t, env.root))
+}
+
+func TestRun_Sidecar(t *testing.T) {
+	env := setupTestEnv(t)
+	writeFile(t, filepath.Join(env.inputDir, "new.csv"+config.SidecarSuffix), "")
+	before := snapshot(t, env.root)
+
+	report, err := New(nil, Options{Root: env.inputDir, Method: config.MethodSidecar, Router: router(t, env, config.MethodSidecar)}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	items := itemsByName(report)
+	if got := items["new.csv"].Status; got != StatusNew {
+		t.Errorf("new.csv: status = %q, want %q", got, StatusNew)
+	}
+	if got := items["new.csv.ok"].Status; got != StatusFiltered {
+		t.Errorf("new.csv.ok: status = %q, want %q", got, StatusFiltered)
+	}
+	if got := items["old.csv"].Status; got != StatusWaiting {
+		t.Errorf("old.csv: status = %q, want %q", got, StatusWaiting)
+	}
+
+	assertUnchanged(t, before, snapshot(t, env.root))
+}
+
+func TestRun_RoutesLikeTheWatcher(t *testing.T) {
+	env := setupTestEnv(t)
+	writeFile(t, filepath.Join(env.inputDir, "vendorA", "orders.csv"), "vendor a orders")
+	writeFile(t, filepath.Join(env.inputDir, "vendorB", "orders.csv"), "vendor b orders")
+	writeFile(t, filepath.Join(env.inputDir, "vendorB", "orders.csv"+config.SidecarSuffix), "")
+	writeFile(t, filepath.Join(env.inputDir, "work", "draft.csv"), "draft")
+	writeFile(t, filepath.Join(env.inputDir, "batch_1", "part.csv"), "batch member")
+	writeFile(t, filepath.Join(env.inputDir, "big.csv.1"), "first part")
+	writeFile(t, filepath.Join(env.inputDir, config.TrashDirName, "gone.csv"), "ingested")
+	writeFile(t, filepath.Join(env.inputDir, "receipt.csv"+config.ReceiptSuffix), "{}")
+	writeFile(t, filepath.Join(env.inputDir, "shrunk.csv"), "tiny")
+	writeFile(t, filepath.Join(env.inputDir, "released.csv"), "tiny too")
+
+	store := storage.New(openDB(t, env.dbPath))
+	released := time.Now()
+	for _, h := range []storage.HeldFile{
+		{Path: filepath.Join(env.inputDir, "shrunk.csv"), Size: 4, HeldAt: time.Now()},
+		{Path: filepath.Join(env.inputDir, "released.csv"), Size: 8, HeldAt: time.Now(), ReleasedAt: &released},
+	} {
+		if err := store.HoldFile(&h); err != nil {
+			t.Fatalf("failed to hold %s: %v", h.Path, err)
+		}
+	}
+
+	w := router(t, env, config.MethodStabilityWindow)
+	if err := w.SetCompletionRules([]config.CompletionRule{{Pattern: "vendorB/*", Method: config.MethodSidecar}}); err != nil {
+		t.Fatalf("SetCompletionRules failed: %v", err)
+	}
+	if err := w.SetExcludeDirs("work"); err != nil {
+		t.Fatalf("SetExcludeDirs failed: %v", err)
+	}
+	if err := w.EnableBatches("batch_*", config.DefaultBatchMarker); err != nil {
+		t.Fatalf("EnableBatches failed: %v", err)
+	}
+	if err := w.EnableFileSets(`^(.+)\.(\d+)$`); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+
+	report, err := New(store, Options{Root: env.inputDir, Method: config.MethodStabilityWindow, Router: w}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	items := make(map[string]Item)
+	for _, item := range report.Items {
+		rel, _ := filepath.Rel(env.inputDir, item.Path)
+		items[filepath.ToSlash(rel)] = item
+	}
+	tests := []struct {
+		name   string
+		status Status
+		reason string
+	}{
+		{"vendorA/orders.csv", StatusNew, ""},
+		{"vendorB/orders.csv", StatusNew, ""},
+		{"vendorB/orders.csv" + config.SidecarSuffix, StatusFiltered, watcher.SkipSidecar},
+		{"batch_1/part.csv", StatusWaiting, watcher.SkipBatch},
+		{"big.csv.1", StatusWaiting, watcher.SkipFileSet},
+		{"receipt.csv" + config.ReceiptSuffix, StatusFiltered, watcher.SkipIgnored},
+		{"shrunk.csv", StatusHeld, "smaller than the file last ingested from its path"},
+		{"released.csv", StatusNew, ""},
+	}
+	for _, tt := range tests {
+		item, ok := items[tt.name]
+		if !ok {
+			t.Errorf("%s: missing from the report", tt.name)
+			continue
+		}
+		if item.Status != tt.status || item.Reason != tt.reason {
+			t.Errorf("%s: status = %q (%q), want %q (%q)", tt.name, item.Status, item.Reason, tt.status, tt.reason)
+		}
+	}
+	for _, name := range []string{"work/draft.csv", config.TrashDirName + "/gone.csv"} {
+		if _, ok := items[name]; ok {
+			t.Errorf("%s: reported although the watcher never watches its directory", name)
+		}
+	}
+}
+
+func TestRun_NoHash(t *testing.T) {
+	env := setupTestEnv(t)
+	before := snapshot(t, env.root)
+
+	report, err := New(nil, Options{Root: env.inputDir, Method: config.MethodStabilityWindow, Router: router(t, env, config.MethodStabilityWindow), NoHash: true}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for _, item := range report.Items {
+		if item.SHA256 != "" {
+			t.Errorf("%s was hashed in no-hash mode", item.Path)
+		}
+		if item.Status == StatusNew || item.Status == StatusDuplicate {
+			t.Errorf("%s has status %q in no-hash mode", item.Path, item.Status)
+		}
+	}
+	if report.Summary.Files[StatusCandidate] != 3 {
+		t.Errorf("candidates = %d, want 3", report.Summary.Files[StatusCandidate])
+	}
+
+	assertUnchanged(t, before, snapshot(t, env.root))
+}
+
+func TestWriteReports(t *testing.T) {
+	env := setupTestEnv(t)
+
+	report, err := New(nil, Options{Root: env.inputDir, Method: config.MethodStabilityWindow, Router: router(t, env, config.MethodStabilityWindow)}).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := WriteJSON(&jsonBuf, report); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(jsonBuf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to decode JSON report: %v", err)
+	}
+	if len(decoded.Items) != len(report.Items) {
+		t.Errorf("JSON items = %d, want %d", len(decoded.Items), len(report.Items))
+	}
+
+	var csvBuf bytes.Buffer
+	if err := WriteCSV(&csvBuf, report); err != nil {
+		t.Fatalf("WriteCSV failed: %v", err)
+	}
+	rows, err := csv.NewReader(&csvBuf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to decode CSV report: %v", err)
+	}
+	if len(rows) != len(report.Items)+1 {
+		t.Errorf("CSV rows = %d, want %d", len(rows), len(report.Items)+1)
+	}
+}
@@ -0,0 +1,202 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// attemptPruneInterval is how often attempts past their retention are
+// deleted
+const attemptPruneInterval = time.Hour
+
+// attemptLog writes the outcome of every attempt at a file to the attempts
+// table from a goroutine of its own, so the happy path never waits for the
+// insert. Attempts ended while a write is in progress are written together
+// in the next batch. The timings of sampled files are written and pruned
+// alongside.
+type attemptLog struct {
+	store     *storage.Storage
+	instance  string
+	retention time.Duration
+	// starts maps each path being attempted to when the attempt began
+	starts sync.Map
+
+	mu      sync.Mutex
+	pending []storage.Attempt
+	timings []storage.Timing
+	closed  bool
+
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	lastPrune time.Time
+}
+
+func newAttemptLog(store *storage.Storage, retention time.Duration) *attemptLog {
+	host, _ := os.Hostname()
+	l := &attemptLog{
+		store:     store,
+		instance:  fmt.Sprintf("%s:%d", host, os.Getpid()),
+		retention: retention,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// begin notes that an attempt at path started at started
+func (l *attemptLog) begin(path string, started time.Time) {
+	l.starts.Store(path, started)
+}
+
+// end queues the attempt at path that reached outcome. Its start is the
+// last begin for path, or now when there was none.
+func (l *attemptLog) end(path, hash string, outcome status.Status, cause error) {
+	now := time.Now()
+	started := now
+	if v, ok := l.starts.LoadAndDelete(path); ok {
+		started = v.(time.Time)
+	}
+	a := storage.Attempt{
+		Path:      path,
+		SHA256:    hash,
+		StartedAt: started,
+		EndedAt:   now,
+		Outcome:   outcome,
+		Instance:  l.instance,
+	}
+	if cause != nil {
+		a.Error = cause.Error()
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = append(l.pending, a)
+	l.mu.Unlock()
+	l.signal()
+}
+
+// timing queues the timing breakdown of a sampled file
+func (l *attemptLog) timing(t storage.Timing) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.timings = append(l.timings, t)
+	l.mu.Unlock()
+	l.signal()
+}
+
+// signal wakes the writer
+func (l *attemptLog) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued attempts until close, then writes those left
+func (l *attemptLog) run() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(attemptPruneInterval)
+	defer ticker.Stop()
+	l.prune()
+	for {
+		select {
+		case <-l.done:
+			l.flush()
+			return
+		case <-l.wake:
+			l.flush()
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
+
+// flush writes every queued attempt and timing in one batch each. A failed
+// batch is logged and dropped: the history is for investigations and must
+// not hold back ingestion.
+func (l *attemptLog) flush() {
+	l.mu.Lock()
+	batch, timings := l.pending, l.timings
+	l.pending, l.timings = nil, nil
+	l.mu.Unlock()
+	if len(batch) > 0 {
+		if err := l.store.RecordAttempts(batch); err != nil {
+			slog.Warn("failed to record attempts", "count", len(batch), "error", err)
+		}
+	}
+	if len(timings) > 0 {
+		if err := l.store.RecordTimings(timings); err != nil {
+			slog.Warn("failed to record timings", "count", len(timings), "error", err)
+		}
+	}
+}
+
+// prune deletes the attempts that ended, and the timings of files started,
+// before the retention
+func (l *attemptLog) prune() {
+	if l.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-l.retention)
+	n, err := l.store.PruneAttempts(cutoff)
+	if err != nil {
+		slog.Warn("failed to prune attempts", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("attempts pruned", "deleted", n, "retention", l.retention)
+	}
+	n, err = l.store.PruneTimings(cutoff)
+	if err != nil {
+		slog.Warn("failed to prune timings", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("timings pruned", "deleted", n, "retention", l.retention)
+	}
+}
+
+// close writes the queued attempts and stops the writer. Attempts ended
+// afterwards are dropped; closing twice is harmless.
+func (l *attemptLog) close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.mu.Unlock()
+	close(l.done)
+	<-l.stopped
+}
+
+// beginAttempt notes the start of an attempt at path
+func (p *Processor) beginAttempt(path string, started time.Time) {
+	if p.attempts != nil {
+		p.attempts.begin(path, started)
+	}
+}
+
+// endAttempt records the outcome of the attempt at path. Dry runs and
+// self-test probes write nothing.
+func (p *Processor) endAttempt(path, hash string, outcome status.Status, cause error) {
+	if p.attempts == nil || p.cfg.DryRun || p.isProbe(path) {
+		return
+	}
+	p.attempts.end(path, hash, outcome, cause)
+}
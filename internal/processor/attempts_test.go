@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestAttempts_FailsTwiceThenSucceeds(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	failures := 2
+	steps := builtins(env.processor, StepDedup, StepResolve, StepClaim)
+	steps = append(steps, funcStep{name: "upload", fn: func(*FileContext) error {
+		if failures > 0 {
+			failures--
+			return errors.New("upload refused")
+		}
+		return nil
+	}})
+	env.processor.defaultSteps = append(steps, builtins(env.processor, StepMove, StepManifest)...)
+
+	files := writeSourceFiles(t, env, "data.csv")
+	before := time.Now()
+	for range 3 {
+		env.processor.runPipeline(files)
+	}
+	env.processor.attempts.close()
+
+	attempts, err := env.store.Attempts(files[0])
+	if err != nil {
+		t.Fatalf("Attempts() error = %v", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3: %+v", len(attempts), attempts)
+	}
+	want := []status.Status{status.Failed, status.Failed, status.Ingested}
+	for i, a := range attempts {
+		if a.Number != i+1 || a.Outcome != want[i] || a.Path != files[0] {
+			t.Errorf("attempt %d = %+v, want number %d %s", i, a, i+1, want[i])
+		}
+		if a.StartedAt.Before(before.Add(-time.Second)) || a.EndedAt.Before(a.StartedAt) || a.Instance == "" {
+			t.Errorf("attempt %d timestamps or instance = %+v", i, a)
+		}
+		if i > 0 && a.StartedAt.Before(attempts[i-1].EndedAt) {
+			t.Errorf("attempt %d started before attempt %d ended", i+1, i)
+		}
+	}
+	if attempts[0].Error == "" || attempts[2].Error != "" {
+		t.Errorf("errors = %q, %q, want the failure recorded and none on success", attempts[0].Error, attempts[2].Error)
+	}
+	if attempts[2].SHA256 == "" {
+		t.Error("successful attempt has no hash")
+	}
+	// The hash finds the failures before the success too
+	if byHash, _ := env.store.Attempts(attempts[2].SHA256); len(byHash) != 3 {
+		t.Errorf("Attempts(sha) = %d attempts, want 3", len(byHash))
+	}
+
+	retried, err := env.store.RetriedFiles(before.Add(-time.Minute), 0)
+	if err != nil || len(retried) != 1 || retried[0].Attempts != 3 || retried[0].Outcome != status.Ingested {
+		t.Errorf("RetriedFiles() = %+v, %v, want data.csv ingested on attempt 3", retried, err)
+	}
+}
+
+func TestAttempts_DryRunWritesNothing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+
+	files := writeSourceFiles(t, env, "data.csv")
+	env.processor.runPipeline(files)
+	env.processor.attempts.close()
+
+	if attempts, _ := env.store.Attempts(files[0]); len(attempts) != 0 {
+		t.Errorf("dry run recorded attempts: %+v", attempts)
+	}
+	if status.Failed != status.Failed {
+		t.Errorf("status.Failed = %q, want the failed outcome %q", status.Failed, status.Failed)
+	}
+}
@@ -0,0 +1,328 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// Directory batch stages at which test hooks run
+const (
+	stageBatchStaged    = "batch_staged"
+	stageBatchCommit    = "batch_commit"
+	stageBatchCommitted = "batch_committed"
+)
+
+// batchMember is one file of a directory batch
+type batchMember struct {
+	src string
+	// rel is src relative to the input directory, slash-separated
+	rel    string
+	hash   string
+	size   int64
+	dst    resolvedPath
+	tags   map[string]string
+	staged string
+	// duplicate is set when the content is already in the warehouse or
+	// appears earlier in the same batch
+	duplicate bool
+	// original is the destination of the content already in the warehouse
+	original string
+	// processedAt and seq stamp the entry of the member
+	processedAt time.Time
+	seq         int64
+	// links is the link count of the staged copy when it is hard-linked to
+	// the kept source
+	links int
+}
+
+// batchMembers lists the files of a batch in path order, leaving out the
+// marker and files the watcher ignores
+func batchMembers(b watcher.Batch) ([]string, error) {
+	var members []string
+	err := filepath.WalkDir(b.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == config.TrashDirName {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || path == b.MarkerPath || watcher.ShouldIgnoreFile(path) {
+			return nil
+		}
+		members = append(members, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list batch %s: %w", b.Dir, err)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// entry returns the manifest entry of an ingested member
+func (m *batchMember) entry() manifest.Entry {
+	return manifest.Entry{
+		SHA256:       m.hash,
+		Name:         m.dst.name,
+		OriginalName: m.dst.originalName,
+		SourcePath:   m.src,
+		DestPath:     m.dst.path,
+		Size:         m.size,
+		ProcessedAt:  m.processedAt,
+		Status:       status.Ingested,
+		Tags:         m.tags,
+		Sequence:     m.seq,
+		LinkCount:    m.links,
+	}
+}
+
+// processBatch ingests every file of a directory batch or none of them:
+//
+//  1. copy each member to _staging/<id>/<key> in the warehouse
+//  2. insert the records of all members in one database transaction
+//  3. write the manifest entries and rename the staged files into place
+//
+// A failure before the commit removes the staging directory and leaves no
+// records, so nothing of the batch is visible and it is retried on the next
+// tick. Once committed, the batch is completed rather than rolled back: a
+// crash during the renames leaves staged files with records, which the
+// staging recovery at startup promotes. Members become visible one rename at
+// a time, so readers can briefly see part of a batch; the renames are all
+// within the warehouse filesystem and run back to back to keep that window
+// short. With BatchTar the batch is packaged into one tar instead.
+func (p *Processor) processBatch(b watcher.Batch) error {
+	if p.cfg.BatchTar {
+		return p.processBatchTar(b)
+	}
+	paths, err := batchMembers(b)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		slog.Warn("batch marker without files, ignoring", "batch", b.Dir, "marker", b.MarkerPath)
+		p.watcher.RemoveBatch(b.Dir)
+		return nil
+	}
+
+	members := make([]*batchMember, 0, len(paths))
+	seen := make(map[string]*batchMember, len(paths))
+	for _, path := range paths {
+		m, err := p.prepareBatchMember(path)
+		if err != nil {
+			return err
+		}
+		if first, ok := seen[m.hash]; ok {
+			// The content lands where the first member with it does
+			m.duplicate = true
+			m.original = first.original
+			if !first.duplicate {
+				m.original = first.dst.path
+			}
+		} else {
+			seen[m.hash] = m
+		}
+		members = append(members, m)
+	}
+
+	if p.cfg.DryRun {
+		for _, m := range members {
+			slog.Info("dry run: would ingest batch member",
+				"batch", b.Dir,
+				"path", m.src,
+				"sha256", m.hash,
+				"destination", m.dst.path,
+				"duplicate", m.duplicate,
+			)
+			if m.duplicate {
+				p.dryRun.wouldSkip(m.size)
+			} else {
+				p.dryRun.wouldIngest(m.size)
+			}
+		}
+		p.watcher.RemoveBatch(b.Dir)
+		return nil
+	}
+
+	id, err := destination.NewUploadID()
+	if err != nil {
+		return err
+	}
+	stagingDir := filepath.Join(p.cfg.Destination, filepath.FromSlash(destination.StagingPrefix), id)
+	rollback := func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			slog.Error("failed to remove batch staging directory", "batch", b.Dir, "staging", stagingDir, "error", err)
+		}
+	}
+
+	for _, m := range members {
+		if m.duplicate {
+			continue
+		}
+		key, err := filepath.Rel(p.cfg.Destination, m.dst.path)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("relative destination for %s: %w", m.src, err)
+		}
+		m.staged = filepath.Join(p.cfg.Destination, filepath.FromSlash(destination.StagingKey(id, filepath.ToSlash(key))))
+		if err := os.MkdirAll(filepath.Dir(m.staged), 0o755); err != nil {
+			rollback()
+			return fmt.Errorf("create staging directory for %s: %w", m.src, err)
+		}
+		method, err := fileops.CopyFileWithOptions(m.src, m.staged, p.placeOpts(m.src))
+		if err != nil {
+			rollback()
+			return fmt.Errorf("stage %s: %w", m.src, err)
+		}
+		m.links = p.sharedLinks(method, m.src, m.staged)
+		p.failpoint(stageBatchStaged)
+	}
+	p.flushBatch()
+
+	for _, m := range members {
+		if !m.duplicate {
+			m.processedAt, m.seq = p.stamp()
+			defer p.order.release(m.seq)
+		}
+	}
+	err = p.storage.Transaction(func(tx *storage.Storage) error {
+		for _, m := range members {
+			if m.duplicate {
+				continue
+			}
+			created, existing, err := tx.CreateFileIfAbsent(storage.FileRecord{
+				SHA256:       m.hash,
+				Name:         m.dst.name,
+				OriginalName: m.dst.originalName,
+				Path:         m.src,
+				Size:         m.size,
+				Status:       status.Ingested,
+				DestPath:     m.dst.path,
+				ProcessedAt:  m.processedAt,
+				Tags:         m.tags,
+				RelPath:      fileops.NormalizeName(p.limits.form, m.rel),
+				Sequence:     m.seq,
+			})
+			if err != nil {
+				return fmt.Errorf("create database record for %s: %w", m.src, err)
+			}
+			if !created {
+				return fmt.Errorf("content of %s was ingested concurrently from %s", m.src, existing.Path)
+			}
+			if err := p.commitEntry(tx, m.entry()); err != nil {
+				return err
+			}
+		}
+		p.failpoint(stageBatchCommit)
+		return nil
+	})
+	if err != nil {
+		rollback()
+		return fmt.Errorf("commit batch %s: %w", b.Dir, err)
+	}
+
+	// The records are durable from here on; failures below are completed by
+	// the staging recovery rather than rolled back
+	p.failpoint(stageBatchCommitted)
+
+	for _, m := range members {
+		if m.duplicate {
+			continue
+		}
+		if err := p.appendEntry(m.entry(), true); err != nil {
+			slog.Warn("failed to write manifest entry", "path", m.src, "error", err)
+		}
+	}
+
+	for _, m := range members {
+		if m.duplicate {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(m.dst.path), 0o755); err != nil {
+			return fmt.Errorf("create destination directory for %s (completed by staging recovery): %w", m.src, err)
+		}
+		if err := os.Rename(m.staged, m.dst.path); err != nil {
+			return fmt.Errorf("promote %s (completed by staging recovery): %w", m.src, err)
+		}
+		p.setXattrs(m.entry(), storage.SourceOf(fileops.NormalizeName(p.limits.form, m.rel)))
+		p.seal(m.dst.path)
+	}
+	_ = os.RemoveAll(stagingDir)
+
+	for _, m := range members {
+		p.disposeSource(m.src)
+		if m.duplicate {
+			p.record(m.src, m.hash, status.Duplicate, nil)
+			p.writeReceipt(m.src, Receipt{Status: status.Duplicate, SHA256: m.hash, Destination: m.original})
+			continue
+		}
+		p.record(m.src, m.hash, status.Ingested, nil)
+		p.writeReceipt(m.src, Receipt{Status: status.Ingested, SHA256: m.hash, Destination: m.dst.path})
+	}
+	p.disposeSource(b.MarkerPath)
+	if !p.keepsSource(b.Dir) {
+		// Only succeeds once the directory is empty
+		_ = os.Remove(b.Dir)
+	}
+	p.watcher.RemoveBatch(b.Dir)
+
+	slog.Info("batch processed successfully",
+		"batch", b.Dir,
+		"files", len(members),
+		"staging_id", id,
+	)
+	return nil
+}
+
+// prepareBatchMember hashes a member and resolves its destination and tags
+// without modifying anything
+func (p *Processor) prepareBatchMember(path string) (*batchMember, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat batch member %s: %w", path, err)
+	}
+	hash, err := p.hashFile(path, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("calculate SHA256 for %s: %w", path, err)
+	}
+
+	m := &batchMember{src: path, hash: hash, size: info.Size()}
+	original, err := p.storage.FindBySHA256(hash)
+	if err != nil {
+		return nil, fmt.Errorf("check file existence for %s: %w", path, err)
+	}
+	if original != nil {
+		m.duplicate = true
+		m.original = original.DestPath
+		return m, nil
+	}
+
+	relPath, err := filepath.Rel(p.cfg.Path, path)
+	if err != nil {
+		return nil, fmt.Errorf("calculate relative path for %s: %w", path, err)
+	}
+	m.rel = filepath.ToSlash(relPath)
+	if m.dst, err = p.destination(relPath, "", hash); err != nil {
+		return nil, fmt.Errorf("resolve destination for %s: %w", path, err)
+	}
+	if m.tags, err = p.rules.Evaluate(rules.File{RelPath: filepath.ToSlash(relPath), Size: info.Size(), Path: path}); err != nil {
+		return nil, fmt.Errorf("evaluate tagging rules for %s: %w", path, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,260 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// batchFiles are the members of the test batch relative to its directory
+var batchFiles = map[string]string{
+	"a.csv":        "alpha",
+	"b.csv":        "bravo",
+	"nested/c.csv": "charlie",
+}
+
+// writeBatch creates the test batch with its marker under the input directory
+func writeBatch(t *testing.T, env *testEnv) watcher.Batch {
+	t.Helper()
+	dir := filepath.Join(env.inputDir, "batch_1")
+	for name, content := range batchFiles {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create batch file: %v", err)
+		}
+	}
+	marker := filepath.Join(dir, config.DefaultBatchMarker)
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatalf("failed to create marker: %v", err)
+	}
+	return watcher.Batch{Dir: dir, MarkerPath: marker}
+}
+
+// countRecords returns how many database records exist
+func countRecords(t *testing.T, store *storage.Storage) int {
+	t.Helper()
+	files, err := store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	return len(files)
+}
+
+// warehouseFiles returns the regular files in the warehouse outside staging
+func warehouseFiles(t *testing.T, env *testEnv) []string {
+	t.Helper()
+	var files []string
+	staging := filepath.Join(env.warehouseDir, filepath.FromSlash(destination.StagingPrefix))
+	err := filepath.Walk(env.warehouseDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == filepath.Clean(staging) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(env.warehouseDir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk warehouse: %v", err)
+	}
+	return files
+}
+
+// assertRolledBack checks that nothing of the batch is visible and that its
+// sources are untouched
+func assertRolledBack(t *testing.T, env *testEnv, b watcher.Batch, keep int) {
+	t.Helper()
+	if n := countRecords(t, env.store); n != keep {
+		t.Errorf("got %d database records, want %d", n, keep)
+	}
+	if files := warehouseFiles(t, env); len(files) != 0 {
+		t.Errorf("warehouse files visible after rollback: %v", files)
+	}
+	staged, err := destination.NewLocal(env.warehouseDir).ListPrefix(context.Background(), destination.StagingPrefix)
+	if err != nil {
+		t.Fatalf("ListPrefix failed: %v", err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("staging objects left after rollback: %v", staged)
+	}
+	if _, err := os.Stat(b.MarkerPath); err != nil {
+		t.Errorf("marker removed after rollback: %v", err)
+	}
+}
+
+func TestProcessBatch_IngestsAll(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	b := writeBatch(t, env)
+
+	if err := env.processor.processBatch(b); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+
+	for name, content := range batchFiles {
+		data, err := os.ReadFile(filepath.Join(env.warehouseDir, "batch_1", filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("batch member %s missing from warehouse: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s content = %q, want %q", name, data, content)
+		}
+	}
+	if n := countRecords(t, env.store); n != len(batchFiles) {
+		t.Errorf("got %d database records, want %d", n, len(batchFiles))
+	}
+	if entries := readManifest(t, env.manifestsDir); len(entries) != len(batchFiles) {
+		t.Errorf("got %d manifest entries, want %d", len(entries), len(batchFiles))
+	}
+	if _, err := os.Stat(filepath.Join(b.Dir, "a.csv")); !os.IsNotExist(err) {
+		t.Errorf("batch source should be removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(b.MarkerPath); !os.IsNotExist(err) {
+		t.Errorf("marker should be removed, stat error = %v", err)
+	}
+}
+
+func TestProcessBatch_StagingFailureRollsBack(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	b := writeBatch(t, env)
+
+	// The last member disappears after the first one is staged
+	last := filepath.Join(b.Dir, "nested", "c.csv")
+	env.processor.failpoints = map[string]func(){stageBatchStaged: func() { _ = os.Remove(last) }}
+
+	if err := env.processor.processBatch(b); err == nil {
+		t.Fatal("processBatch() succeeded, want error")
+	}
+	assertRolledBack(t, env, b, 0)
+	if _, err := os.Stat(filepath.Join(b.Dir, "a.csv")); err != nil {
+		t.Errorf("staged source removed after rollback: %v", err)
+	}
+}
+
+func TestProcessBatch_CommitFailureRollsBack(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	b := writeBatch(t, env)
+
+	// Another writer claims the last member's content after the duplicate
+	// check, so its insert conflicts inside the transaction
+	last := filepath.Join(b.Dir, "nested", "c.csv")
+	hash, err := fileops.CalculateSHA256(last)
+	if err != nil {
+		t.Fatalf("failed to hash batch member: %v", err)
+	}
+	env.processor.failpoints = map[string]func(){stageBatchStaged: func() {
+		_, _, _ = env.store.CreateFileIfAbsent(storage.FileRecord{SHA256: hash, Path: "/elsewhere/c.csv"})
+	}}
+
+	if err := env.processor.processBatch(b); err == nil {
+		t.Fatal("processBatch() succeeded, want error")
+	}
+	assertRolledBack(t, env, b, 1)
+}
+
+func TestProcessBatch_CrashAfterCommitIsRecovered(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	b := writeBatch(t, env)
+
+	env.processor.failpoints = map[string]func(){stageBatchCommitted: func() { panic("crash") }}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("expected simulated crash")
+			}
+		}()
+		_ = env.processor.processBatch(b)
+	}()
+
+	if files := warehouseFiles(t, env); len(files) != 0 {
+		t.Errorf("files visible before promotion: %v", files)
+	}
+	if n := countRecords(t, env.store); n != len(batchFiles) {
+		t.Fatalf("got %d database records, want %d", n, len(batchFiles))
+	}
+
+	// Startup recovery completes the renames of the committed batch
+	res, err := destination.NewTwoPhase(destination.NewLocal(env.warehouseDir)).Recover(context.Background(), func(key string) (string, bool, error) {
+		file, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, filepath.FromSlash(key)))
+		if err != nil || file == nil {
+			return "", false, err
+		}
+		return file.ContentSHA256(), true, nil
+	})
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if res.Completed != len(batchFiles) || res.Deleted != 0 {
+		t.Errorf("Recover() = %+v, want %d completed", res, len(batchFiles))
+	}
+	if files := warehouseFiles(t, env); len(files) != len(batchFiles) {
+		t.Errorf("got warehouse files %v after recovery, want %d", files, len(batchFiles))
+	}
+}
+
+func TestProcessBatch_SkipsDuplicates(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	b := writeBatch(t, env)
+
+	first := filepath.Join(b.Dir, "a.csv")
+	hash, err := fileops.CalculateSHA256(first)
+	if err != nil {
+		t.Fatalf("failed to hash batch member: %v", err)
+	}
+	if _, _, err := env.store.CreateFileIfAbsent(storage.FileRecord{SHA256: hash, Path: "/elsewhere/a.csv"}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+
+	if err := env.processor.processBatch(b); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+	if files := warehouseFiles(t, env); len(files) != len(batchFiles)-1 {
+		t.Errorf("got warehouse files %v, want %d", files, len(batchFiles)-1)
+	}
+	if got := env.processor.Stats().Totals; got.Duplicate != 1 || got.Ingested != 2 {
+		t.Errorf("Totals = %+v, want 1 duplicate and 2 ingested", got)
+	}
+}
+
+func TestProcessBatch_DuplicateWithinBatchReceipt(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WriteReceipts = true
+	b := writeBatch(t, env)
+	again := filepath.Join(b.Dir, "z.csv")
+	if err := os.WriteFile(again, []byte(batchFiles["a.csv"]), 0o644); err != nil {
+		t.Fatalf("failed to create batch file: %v", err)
+	}
+
+	if err := env.processor.processBatch(b); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+	first := readReceipt(t, filepath.Join(b.Dir, "a.csv")+config.ReceiptSuffix)
+	duplicate := readReceipt(t, again+config.ReceiptSuffix)
+	if first.Status != status.Ingested || first.Destination == "" {
+		t.Fatalf("receipt of the first member = %+v, want it ingested", first)
+	}
+	if duplicate.Status != status.Duplicate || duplicate.Destination != first.Destination {
+		t.Errorf("receipt of the duplicate = %+v, want a duplicate of %s", duplicate, first.Destination)
+	}
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// sequenceBlock is how many sequence numbers are reserved in the state
+// database at a time
+const sequenceBlock = 1024
+
+// sequencer hands out the ProcessedAt and sequence number of every manifest
+// entry and watches the clock for regressions against the latest
+// ProcessedAt, persisted or stamped
+type sequencer struct {
+	mu     sync.Mutex
+	loaded bool
+	// next is the next number to hand out and limit the first one not
+	// reserved
+	next  int64
+	limit int64
+	last  time.Time
+	// behind is how far the clock was behind last at the latest check,
+	// zero unless beyond the tolerance
+	behind      time.Duration
+	regressions int64
+}
+
+// stamp returns the ProcessedAt and sequence number of a new entry. When
+// the sequence cannot be reserved the entry gets number 0 and the error is
+// logged; the file itself is not failed for it.
+func (p *Processor) stamp() (time.Time, int64) {
+	s := &p.seq
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := p.clock()
+	if err := p.loadSequence(); err != nil {
+		slog.Error("failed to load manifest sequence, stamping entry without one", "error", err)
+		return now, 0
+	}
+	p.checkClock(now)
+	if now.After(s.last) {
+		s.last = now
+	}
+	if p.cfg.DryRun {
+		return now, 0
+	}
+
+	if s.next >= s.limit {
+		first, err := p.storage.ReserveSequence(sequenceBlock, s.last)
+		if err != nil {
+			slog.Error("failed to reserve manifest sequence, stamping entry without one", "error", err)
+			return now, 0
+		}
+		s.next, s.limit = first, first+sequenceBlock
+	}
+	seq := s.next
+	s.next++
+	p.order.opened(seq)
+	return now, seq
+}
+
+// loadSequence reads the latest persisted ProcessedAt once. Callers hold
+// seq.mu.
+func (p *Processor) loadSequence() error {
+	s := &p.seq
+	if s.loaded {
+		return nil
+	}
+	persisted, err := p.storage.LoadSequence()
+	if err != nil {
+		return err
+	}
+	if persisted.LastProcessedAt.After(s.last) {
+		s.last = persisted.LastProcessedAt
+	}
+	s.loaded = true
+	return nil
+}
+
+// checkClock compares now with the latest ProcessedAt and reports whether
+// the clock is behind it by more than the tolerance. A regression is logged
+// and counted once, when it starts. Callers hold seq.mu.
+func (p *Processor) checkClock(now time.Time) bool {
+	s := &p.seq
+	behind := s.last.Sub(now)
+	if behind > p.cfg.ClockTolerance {
+		if s.behind == 0 {
+			s.regressions++
+			slog.Error("clock went back, ProcessedAt is no longer monotonic",
+				"behind", behind,
+				"last_processed_at", s.last,
+				"now", now,
+				"tolerance", p.cfg.ClockTolerance,
+				"action", p.onClockRegression(),
+			)
+		}
+		s.behind = behind
+		return true
+	}
+	if s.behind > 0 {
+		slog.Info("clock caught up with the latest ProcessedAt", "last_processed_at", s.last, "now", now)
+		s.behind = 0
+	}
+	return false
+}
+
+// onClockRegression returns the configured reaction to a clock regression
+func (p *Processor) onClockRegression() string {
+	if p.cfg.OnClockRegression == "" {
+		return config.ClockRegressionContinue
+	}
+	return p.cfg.OnClockRegression
+}
+
+// clockHeld reports whether processing holds because the clock is behind
+// the latest ProcessedAt and the configured reaction is to hold
+func (p *Processor) clockHeld() bool {
+	if p.onClockRegression() != config.ClockRegressionHold {
+		return false
+	}
+	s := &p.seq
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := p.loadSequence(); err != nil {
+		slog.Error("failed to load manifest sequence", "error", err)
+		return false
+	}
+	return p.checkClock(p.clock())
+}
+
+// clockStats returns the clock regressions seen since the processor started
+// and how far the clock is behind the latest ProcessedAt
+func (p *Processor) clockStats() (int64, time.Duration) {
+	s := &p.seq
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.regressions, s.behind
+}
@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// testClock is a clock tests step by hand
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// useTestClock makes the processor stamp entries from a clock starting now
+func useTestClock(env *testEnv) *testClock {
+	clock := &testClock{now: time.Now()}
+	env.processor.clock = clock.Now
+	return clock
+}
+
+// entryOf returns the manifest entry of the source named name
+func entryOf(t *testing.T, env *testEnv, name string) manifest.Entry {
+	t.Helper()
+	for _, e := range readManifest(t, env.manifestsDir) {
+		if filepath.Base(e.SourcePath) == name {
+			return e
+		}
+	}
+	t.Fatalf("no manifest entry for %s", name)
+	return manifest.Entry{}
+}
+
+func TestStamp_ClockRegressionContinuesWithSequence(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ClockTolerance = time.Second
+	clock := useTestClock(env)
+
+	ingest(t, env, "a.csv", "alpha")
+	clock.now = clock.now.Add(-40 * time.Minute)
+	ingest(t, env, "b.csv", "bravo")
+	ingest(t, env, "c.csv", "alpha")
+
+	a, b, c := entryOf(t, env, "a.csv"), entryOf(t, env, "b.csv"), entryOf(t, env, "c.csv")
+	if !b.ProcessedAt.Before(a.ProcessedAt) {
+		t.Fatalf("b.csv ProcessedAt = %v, want the regressed clock before %v", b.ProcessedAt, a.ProcessedAt)
+	}
+	if a.Sequence == 0 || b.Sequence <= a.Sequence || c.Sequence <= b.Sequence {
+		t.Errorf("sequences = %d, %d, %d, want increasing in processing order", a.Sequence, b.Sequence, c.Sequence)
+	}
+	if c.Status != status.Duplicate {
+		t.Errorf("c.csv status = %q, want duplicate", c.Status)
+	}
+
+	rec, err := env.store.FindBySHA256(b.SHA256)
+	if err != nil || rec == nil {
+		t.Fatalf("FindBySHA256(b.csv) = %v, %v", rec, err)
+	}
+	if rec.Sequence != b.Sequence {
+		t.Errorf("record sequence = %d, want the entry's %d", rec.Sequence, b.Sequence)
+	}
+
+	stats := env.processor.Stats()
+	if stats.ClockRegressions != 1 {
+		t.Errorf("ClockRegressions = %d, want one regression however many entries it stamped", stats.ClockRegressions)
+	}
+	if stats.ClockBehind < 39*time.Minute {
+		t.Errorf("ClockBehind = %v, want about 40m", stats.ClockBehind)
+	}
+
+	clock.now = a.ProcessedAt.Add(time.Second)
+	ingest(t, env, "d.csv", "delta")
+	if behind := env.processor.Stats().ClockBehind; behind != 0 {
+		t.Errorf("ClockBehind = %v once the clock caught up, want 0", behind)
+	}
+}
+
+func TestStamp_WithinToleranceIsNoRegression(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ClockTolerance = time.Second
+	clock := useTestClock(env)
+
+	ingest(t, env, "a.csv", "alpha")
+	clock.now = clock.now.Add(-500 * time.Millisecond)
+	ingest(t, env, "b.csv", "bravo")
+
+	if n := env.processor.Stats().ClockRegressions; n != 0 {
+		t.Errorf("ClockRegressions = %d, want 0 within the tolerance", n)
+	}
+}
+
+func TestProcessFiles_HoldsWhileClockIsBehind(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.OnClockRegression = config.ClockRegressionHold
+	clock := useTestClock(env)
+
+	ingest(t, env, "a.csv", "alpha")
+	clock.now = clock.now.Add(-time.Hour)
+
+	src := writeSourceFiles(t, env, "b.csv")[0]
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatalf("failed to age test file: %v", err)
+	}
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	env.processor.ProcessFiles()
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("b.csv was processed while the clock was behind: %v", err)
+	}
+	if n := env.processor.Stats().ClockRegressions; n != 1 {
+		t.Errorf("ClockRegressions = %d, want 1", n)
+	}
+
+	clock.now = clock.now.Add(2 * time.Hour)
+	env.processor.ProcessFiles()
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("b.csv still in the input once the clock caught up: %v", err)
+	}
+}
+
+func TestStamp_SequenceSurvivesRestart(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ClockTolerance = time.Second
+	clock := useTestClock(env)
+
+	ingest(t, env, "a.csv", "alpha")
+	first := entryOf(t, env, "a.csv")
+
+	// A restarted processor picks up after the reserved block and compares
+	// the clock with the last committed ProcessedAt
+	env.processor = New(env.cfg, env.store, env.watcher)
+	env.processor.clock = clock.Now
+	clock.now = clock.now.Add(-10 * time.Minute)
+	ingest(t, env, "b.csv", "bravo")
+
+	second := entryOf(t, env, "b.csv")
+	if second.Sequence <= first.Sequence {
+		t.Errorf("sequence after restart = %d, want after %d", second.Sequence, first.Sequence)
+	}
+	if n := env.processor.Stats().ClockRegressions; n != 1 {
+		t.Errorf("ClockRegressions = %d after restart with the clock behind, want 1", n)
+	}
+}
@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// ErrInsufficientSpace is returned when the warehouse or staging filesystem
+// has less free space than a source needs, counting what the copies in
+// flight reserved. The file stays tracked and is retried on the next tick.
+var ErrInsufficientSpace = errors.New("insufficient space")
+
+// stagedCopy is a source copied into the warehouse scratch area by the
+// copy-first strategy
+type stagedCopy struct {
+	// dir is the scratch directory of this copy, removed when the pipeline
+	// ends
+	dir  string
+	path string
+}
+
+// stageSource copies a source into _scratch/<id>/<relPath> under the
+// warehouse, reading it exactly once. Hashing and ingesting the staged copy
+// instead of the source guarantees the recorded hash matches the stored
+// bytes even when the source changes between reads, as on network mounts
+// whose writers ignore locks. Scratch shares the warehouse filesystem, so
+// the final move is a rename, and a copy orphaned by a crash is deleted on
+// the next start. It is kept out of the staging area, whose recovery would
+// promote a copy recorded before the crash but never moved.
+func (p *Processor) stageSource(filePath string, size int64) (stagedCopy, error) {
+	relPath, err := filepath.Rel(p.cfg.Path, filePath)
+	if err != nil {
+		return stagedCopy{}, fmt.Errorf("calculate relative path for %s: %w", filePath, err)
+	}
+	id, err := destination.NewUploadID()
+	if err != nil {
+		return stagedCopy{}, err
+	}
+	s := stagedCopy{dir: p.scratchDir(id)}
+	s.path = filepath.Join(s.dir, relPath)
+
+	if err := p.copyOpts.FileSystem().MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return stagedCopy{}, fmt.Errorf("create scratch directory for %s: %w", filePath, err)
+	}
+	if err := p.copyToStaging(filePath, s.path, size); err != nil {
+		_ = os.RemoveAll(s.dir)
+		return stagedCopy{}, err
+	}
+	return s, nil
+}
+
+// scratchDir returns the directory of the working copy with the given id, in
+// the scratch area of the warehouse
+func (p *Processor) scratchDir(id string) string {
+	return filepath.Join(p.cfg.Destination, filepath.FromSlash(destination.ScratchPrefix), id)
+}
+
+// accountedSize returns the size space checks count for a file of size
+// logical bytes occupying allocated bytes
+func (p *Processor) accountedSize(logical, allocated int64) int64 {
+	if p.cfg.SizeAccounting == config.SizeAccountingAllocated {
+		return allocated
+	}
+	return logical
+}
+
+// copyToStaging copies filePath to staged after checking the staging
+// filesystem can hold size bytes
+func (p *Processor) copyToStaging(filePath, staged string, size int64) error {
+	free, err := fileops.FreeSpace(filepath.Dir(staged))
+	if err != nil {
+		return fmt.Errorf("check staging space for %s: %w", filePath, err)
+	}
+	if free < size {
+		return fmt.Errorf("%w: %s needs %d bytes, %d free in %s", ErrInsufficientSpace, filePath, size, free, filepath.Dir(staged))
+	}
+
+	in, err := p.openSource(filePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", filePath, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := p.copyOpts.FileSystem().Create(staged)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", staged, err)
+	}
+	defer func() { _ = out.Close() }()
+	if f, ok := out.(*os.File); ok {
+		if err := fileops.Preallocate(f, size); err != nil {
+			return fmt.Errorf("stage %s: %w", filePath, err)
+		}
+	}
+
+	if err := copyContext(p.ctx, out, in); err != nil {
+		return fmt.Errorf("stage %s: %w", filePath, err)
+	}
+	if p.copyOpts.Sync != fileops.SyncNever {
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("sync %s: %w", staged, err)
+		}
+	}
+	return out.Close()
+}
+
+// copyContext copies src to dst, stopping when ctx is canceled
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openFile opens a source for reading; tests replace it through openSource
+func openFile(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
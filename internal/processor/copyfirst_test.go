@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// stagingEntries lists what is left in the warehouse staging area
+func stagingEntries(t *testing.T, env *testEnv) []os.DirEntry {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(env.warehouseDir, filepath.FromSlash(destination.StagingPrefix)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("failed to read staging directory: %v", err)
+	}
+	return entries
+}
+
+func TestCopyFirst_StoredBytesMatchHash(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.CopyFirst = true
+
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte("rewritten by the server"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	// The writer on the other side of the mount changes the file between
+	// reads: the first read sees one version, any later read another
+	opens := 0
+	env.processor.openSource = func(path string) (io.ReadCloser, error) {
+		opens++
+		if opens == 1 {
+			return io.NopCloser(strings.NewReader("first read")), nil
+		}
+		return os.Open(path)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if opens != 1 {
+		t.Errorf("source opened %d times, want once", opens)
+	}
+
+	files, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("records = %+v, %v, want 1", files, err)
+	}
+	stored, err := os.ReadFile(files[0].DestPath)
+	if err != nil {
+		t.Fatalf("failed to read warehouse copy: %v", err)
+	}
+	sum := sha256.Sum256(stored)
+	if hex.EncodeToString(sum[:]) != files[0].SHA256 {
+		t.Errorf("recorded hash %s does not match stored bytes %q", files[0].SHA256, stored)
+	}
+	if string(stored) != "first read" || files[0].Size != int64(len(stored)) {
+		t.Errorf("stored %q of size %d, want the staged copy", stored, files[0].Size)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source should be deleted after ingestion")
+	}
+	if left := stagingEntries(t, env); len(left) != 0 {
+		t.Errorf("staging area not cleaned up: %v", left)
+	}
+}
+
+func TestCopyFirst_DuplicateRemovesStagedCopy(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.CopyFirst = true
+
+	ingest(t, env, "a.csv", "same content")
+	ingest(t, env, "b.csv", "same content")
+
+	if left := stagingEntries(t, env); len(left) != 0 {
+		t.Errorf("staging area not cleaned up: %v", left)
+	}
+	if got := env.processor.Stats().Totals; got.Ingested != 1 || got.Duplicate != 1 {
+		t.Errorf("totals = %+v, want 1 ingested and 1 duplicate", got)
+	}
+}
+
+func TestCopyFirst_InsufficientSpace(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.CopyFirst = true
+
+	free, err := fileops.FreeSpace(env.warehouseDir)
+	if err != nil {
+		t.Fatalf("FreeSpace() error = %v", err)
+	}
+	// A sparse source larger than the free space of the staging filesystem
+	src := filepath.Join(env.inputDir, "huge.bin")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := f.Truncate(free + 1<<20); err != nil {
+		_ = f.Close()
+		t.Skipf("filesystem does not support large sparse files: %v", err)
+	}
+	_ = f.Close()
+
+	err = env.processor.processFile(src)
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("processFile() error = %v, want ErrInsufficientSpace", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source should stay in place: %v", err)
+	}
+	if left := stagingEntries(t, env); len(left) != 0 {
+		t.Errorf("staging area not cleaned up: %v", left)
+	}
+}
+
+func TestAccountedSize(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	if got := env.processor.accountedSize(1<<30, 4096); got != 1<<30 {
+		t.Errorf("logical accounting = %d, want %d", got, 1<<30)
+	}
+	env.cfg.SizeAccounting = config.SizeAccountingAllocated
+	if got := env.processor.accountedSize(1<<30, 4096); got != 4096 {
+		t.Errorf("allocated accounting = %d, want 4096", got)
+	}
+}
@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// ErrPathTooLong is returned when a destination exceeds the configured limits
+// and shortening is disabled
+var ErrPathTooLong = errors.New("path_too_long")
+
+const (
+	// shortHashLen is the number of hex characters appended to shortened names
+	shortHashLen = 8
+	// maxKeptExtBytes is the longest extension preserved when shortening
+	maxKeptExtBytes = 16
+	// compactComponentBytes is the length directory components are reduced
+	// to when the full path is too long
+	compactComponentBytes = 32
+)
+
+// pathLimits bounds the names the warehouse filesystem accepts
+type pathLimits struct {
+	maxName int
+	maxPath int
+	shorten bool
+	// form is the Unicode normalization applied to every component
+	form string
+}
+
+// resolvedPath is a destination that fits the limits
+type resolvedPath struct {
+	path string
+	// name is the final path component stored in the warehouse
+	name string
+	// originalName is set when name differs from the source name, byte for
+	// byte
+	originalName string
+}
+
+// resolveDestination computes the warehouse path for relPath under root and
+// validates it against the limits. Every layout goes through this function so
+// names are normalized the same way and over-long names are caught before any
+// work starts. Components are normalized before they are measured. When
+// shortening is enabled, offending components are replaced deterministically
+// by a truncated prefix plus a hash of the original component; otherwise
+// ErrPathTooLong is returned.
+func resolveDestination(root, relPath string, limits pathLimits) (resolvedPath, error) {
+	components := strings.Split(filepath.ToSlash(filepath.Clean(relPath)), "/")
+	originalName := components[len(components)-1]
+	for i, c := range components {
+		components[i] = fileops.NormalizeName(limits.form, c)
+	}
+
+	tooLong := func() bool {
+		return limits.maxPath > 0 && len(filepath.Join(root, filepath.Join(components...))) > limits.maxPath
+	}
+
+	overName := false
+	for _, c := range components {
+		if limits.maxName > 0 && len(c) > limits.maxName {
+			overName = true
+		}
+	}
+
+	if overName || tooLong() {
+		if !limits.shorten {
+			return resolvedPath{}, fmt.Errorf("%w: %s", ErrPathTooLong, filepath.Join(root, relPath))
+		}
+
+		for i, c := range components {
+			if limits.maxName > 0 && len(c) > limits.maxName {
+				components[i] = shortenComponent(c, limits.maxName)
+			}
+		}
+
+		// Compact directories first, then the file name, until the path fits
+		for i := 0; i < len(components) && tooLong(); i++ {
+			components[i] = shortenComponent(components[i], compactComponentBytes)
+		}
+		if tooLong() {
+			return resolvedPath{}, fmt.Errorf("%w: cannot shorten %s below %d bytes", ErrPathTooLong, filepath.Join(root, relPath), limits.maxPath)
+		}
+	}
+
+	res := resolvedPath{
+		path: filepath.Join(root, filepath.Join(components...)),
+		name: components[len(components)-1],
+	}
+	if res.name != originalName {
+		res.originalName = originalName
+	}
+	return res, nil
+}
+
+// shortenComponent reduces name to at most limit bytes as
+// <truncated stem>-<hash><ext>, cutting only at UTF-8 rune boundaries.
+// Names already within the limit are returned unchanged.
+func shortenComponent(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:shortHashLen]
+
+	ext := filepath.Ext(name)
+	if len(ext) > maxKeptExtBytes || len(ext)+len(suffix) >= limit {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+
+	keep := limit - len(suffix) - len(ext)
+	if keep < 0 {
+		keep = 0
+	}
+	return truncateUTF8(stem, keep) + suffix + ext
+}
+
+// truncateUTF8 returns the longest prefix of s of at most n bytes that does
+// not split a multi-byte character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
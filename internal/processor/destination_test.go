@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestShortenComponent(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		limit int
+	}{
+		{"ascii", strings.Repeat("a", 300) + ".csv", 255},
+		{"two byte runes", strings.Repeat("é", 200) + ".csv", 255},
+		{"three byte runes", strings.Repeat("日本語", 100) + ".parquet", 255},
+		{"four byte runes", strings.Repeat("😀", 80) + ".json", 64},
+		{"long extension", "report." + strings.Repeat("x", 300), 255},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shortenComponent(tt.input, tt.limit)
+
+			if len(got) > tt.limit {
+				t.Errorf("len = %d, want <= %d", len(got), tt.limit)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("result is not valid UTF-8: %q", got)
+			}
+			if again := shortenComponent(tt.input, tt.limit); again != got {
+				t.Errorf("not deterministic: %q != %q", got, again)
+			}
+			if ext := filepath.Ext(tt.input); len(ext) <= maxKeptExtBytes && !strings.HasSuffix(got, ext) {
+				t.Errorf("extension %q not preserved in %q", ext, got)
+			}
+		})
+	}
+}
+
+func TestShortenComponent_DistinctNames(t *testing.T) {
+	prefix := strings.Repeat("a", 300)
+	a := shortenComponent(prefix+"1.csv", 255)
+	b := shortenComponent(prefix+"2.csv", 255)
+	if a == b {
+		t.Errorf("names sharing a long prefix collided: %q", a)
+	}
+}
+
+func TestShortenComponent_WithinLimit(t *testing.T) {
+	if got := shortenComponent("data.csv", 255); got != "data.csv" {
+		t.Errorf("shortenComponent() = %q, want unchanged", got)
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+	root := "/warehouse"
+	longName := strings.Repeat("ü", 200) + ".csv"
+	deepDir := filepath.Join(strings.Repeat("d", 100), strings.Repeat("e", 100), strings.Repeat("f", 100))
+
+	tests := []struct {
+		name      string
+		relPath   string
+		limits    pathLimits
+		wantErr   bool
+		shortened bool
+	}{
+		{"no limits", filepath.Join("sub", longName), pathLimits{}, false, false},
+		{"fits", "sub/data.csv", pathLimits{maxName: 255, maxPath: 4096, shorten: true}, false, false},
+		{"long name shortened", longName, pathLimits{maxName: 255, maxPath: 4096, shorten: true}, false, true},
+		{"long name rejected", longName, pathLimits{maxName: 255, maxPath: 4096}, true, false},
+		{"deep path compacted", filepath.Join(deepDir, "data.csv"), pathLimits{maxName: 255, maxPath: 128, shorten: true}, false, false},
+		{"path cannot fit", filepath.Join(deepDir, "data.csv"), pathLimits{maxName: 255, maxPath: 20, shorten: true}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDestination(root, tt.relPath, tt.limits)
+			if tt.wantErr {
+				if !errors.Is(err, ErrPathTooLong) {
+					t.Fatalf("resolveDestination() error = %v, want ErrPathTooLong", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveDestination() error = %v", err)
+			}
+
+			if tt.limits.maxPath > 0 && len(got.path) > tt.limits.maxPath {
+				t.Errorf("path length = %d, want <= %d", len(got.path), tt.limits.maxPath)
+			}
+			for _, c := range strings.Split(got.path, string(filepath.Separator)) {
+				if tt.limits.maxName > 0 && len(c) > tt.limits.maxName {
+					t.Errorf("component %q length = %d, want <= %d", c, len(c), tt.limits.maxName)
+				}
+			}
+			if got.name != filepath.Base(got.path) {
+				t.Errorf("name = %q, want %q", got.name, filepath.Base(got.path))
+			}
+			if tt.shortened && got.originalName != filepath.Base(tt.relPath) {
+				t.Errorf("originalName = %q, want %q", got.originalName, filepath.Base(tt.relPath))
+			}
+			if !tt.shortened && got.originalName != "" && filepath.Base(tt.relPath) == got.name {
+				t.Errorf("originalName set for unchanged name: %q", got.originalName)
+			}
+		})
+	}
+}
+
+// Spellings of the same name: composed as U+00E9, decomposed as e plus U+0301
+const (
+	composedName   = "caf\u00e9.csv"
+	decomposedName = "cafe\u0301.csv"
+)
+
+func TestResolveDestination_Normalizes(t *testing.T) {
+	nfc := pathLimits{form: fileops.NormalizeNFC}
+
+	got, err := resolveDestination("/warehouse", filepath.Join("cafe\u0301s", decomposedName), nfc)
+	if err != nil {
+		t.Fatalf("resolveDestination() error = %v", err)
+	}
+	if want := filepath.Join("/warehouse", "caf\u00e9s", composedName); got.path != want {
+		t.Errorf("path = %q, want %q", got.path, want)
+	}
+	if got.name != composedName || got.originalName != decomposedName {
+		t.Errorf("name = %q, originalName = %q, want the composed name and the source bytes", got.name, got.originalName)
+	}
+
+	got, err = resolveDestination("/warehouse", composedName, nfc)
+	if err != nil {
+		t.Fatalf("resolveDestination() error = %v", err)
+	}
+	if got.originalName != "" {
+		t.Errorf("originalName = %q for a name already in NFC", got.originalName)
+	}
+
+	got, err = resolveDestination("/warehouse", decomposedName, pathLimits{form: fileops.NormalizeNone})
+	if err != nil {
+		t.Fatalf("resolveDestination() error = %v", err)
+	}
+	if got.name != decomposedName || got.originalName != "" {
+		t.Errorf("name = %q, originalName = %q, want the source bytes unchanged", got.name, got.originalName)
+	}
+}
+
+func TestIngest_NormalizesNames(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.limits.form = fileops.NormalizeNFC
+
+	ingest(t, env, decomposedName, "from macOS")
+
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, composedName)); err != nil {
+		t.Errorf("warehouse copy should use the composed name: %v", err)
+	}
+	files, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != composedName || files[0].OriginalName != decomposedName {
+		t.Errorf("records = %+v, want the composed name and the source bytes", files)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].Name != composedName || entries[0].OriginalName != decomposedName {
+		t.Errorf("manifest = %+v, want the composed name and the source bytes", entries)
+	}
+}
+
+func TestIngest_NormalizedNamesCollide(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.limits.form = fileops.NormalizeNFC
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+
+	ingest(t, env, composedName, "from linux")
+	ingest(t, env, decomposedName, "from macOS")
+
+	for name, content := range map[string]string{
+		composedName:       "from linux",
+		"caf\u00e9.v2.csv": "from macOS",
+	} {
+		data, err := os.ReadFile(filepath.Join(env.warehouseDir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+
+	chain, err := env.store.ListFiles(storage.FileFilter{RelPath: composedName})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(chain) != 2 {
+		t.Errorf("version chain has %d records, want both spellings", len(chain))
+	}
+}
@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// dryRunTally counts what a dry-run pass would have done, for the summary
+// logged at its end
+type dryRunTally struct {
+	ingest      atomic.Int64
+	ingestBytes atomic.Int64
+	skip        atomic.Int64
+	skipBytes   atomic.Int64
+	quarantine  atomic.Int64
+	hold        atomic.Int64
+}
+
+// wouldIngest counts a file of size the pass would have ingested
+func (t *dryRunTally) wouldIngest(size int64) {
+	t.ingest.Add(1)
+	t.ingestBytes.Add(size)
+}
+
+// wouldSkip counts a file of size the pass would have skipped as a
+// duplicate
+func (t *dryRunTally) wouldSkip(size int64) {
+	t.skip.Add(1)
+	t.skipBytes.Add(size)
+}
+
+// wouldQuarantine counts a file the pass would have quarantined
+func (t *dryRunTally) wouldQuarantine() {
+	t.quarantine.Add(1)
+}
+
+// wouldHold counts a file the pass would have held after it shrank
+func (t *dryRunTally) wouldHold() {
+	t.hold.Add(1)
+}
+
+// wouldQuarantine ends the processing of a file a dry run would have
+// quarantined for reason, logging and counting it in place of quarantining
+func (p *Processor) wouldQuarantine(fc *FileContext, reason string, err error) {
+	slog.Info("dry run: would quarantine file", "path", fc.SourcePath, "reason", reason, "error", err)
+	p.dryRun.wouldQuarantine()
+	p.watcher.RemoveFromTracking(fc.SourcePath)
+}
+
+// summarizeDryRun logs the tally of the pass and resets it for the next.
+// Nothing of a dry run is recorded, so the files it examined are found
+// again by the first real run.
+func (p *Processor) summarizeDryRun() {
+	ingest, ingestBytes := p.dryRun.ingest.Swap(0), p.dryRun.ingestBytes.Swap(0)
+	skip, skipBytes := p.dryRun.skip.Swap(0), p.dryRun.skipBytes.Swap(0)
+	quarantine, hold := p.dryRun.quarantine.Swap(0), p.dryRun.hold.Swap(0)
+	slog.Info("dry run: pass summary",
+		"would_ingest", ingest,
+		"would_ingest_bytes", ingestBytes,
+		"would_skip", skip,
+		"would_skip_bytes", skipBytes,
+		"would_quarantine", quarantine,
+		"would_hold", hold,
+		"total_bytes", ingestBytes+skipBytes,
+	)
+}
@@ -0,0 +1,235 @@
+package processor
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Event kinds. File events also carry the Outcome they report.
+const (
+	EventFileIngested      = "file_ingested"
+	EventFileDuplicate     = "file_duplicate"
+	EventFileQuarantined   = "file_quarantined"
+	EventFileVanished      = "file_vanished"
+	EventFileFailed        = "file_failed"
+	EventProcessingPaused  = "processing_paused"
+	EventProcessingResumed = "processing_resumed"
+	// EventSuspiciousShrink alerts on a file held because it shrank
+	EventSuspiciousShrink = "suspicious_shrink"
+	// EventDestinationDegraded alerts on a warehouse failing its probe,
+	// holding dispatch until EventDestinationRecovered
+	EventDestinationDegraded  = "destination_degraded"
+	EventDestinationRecovered = "destination_recovered"
+)
+
+// DefaultSubscriberQueue is how many events a subscriber may fall behind
+// before the oldest are dropped
+const DefaultSubscriberQueue = 1024
+
+// eventKinds maps outcomes to the kind of their event
+var eventKinds = map[status.Status]string{
+	status.Ingested:    EventFileIngested,
+	status.Duplicate:   EventFileDuplicate,
+	status.Quarantined: EventFileQuarantined,
+	status.Vanished:    EventFileVanished,
+	status.Failed:      EventFileFailed,
+}
+
+// Subscription delivers events to one subscriber from its own goroutine, in
+// the order they were published. Publishing never waits for the subscriber:
+// once its queue is full the oldest event is dropped and counted.
+type Subscription struct {
+	bus *eventBus
+	fn  func(Event)
+
+	mu      sync.Mutex
+	queue   []Event
+	limit   int
+	wake    chan struct{}
+	done    chan struct{}
+	closing bool
+	dropped atomic.Int64
+}
+
+// Dropped returns how many events were discarded because the subscriber fell
+// too far behind
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery. Queued events are discarded and an event being
+// handled is not waited for, so it is safe to call from the subscriber.
+func (s *Subscription) Unsubscribe() {
+	if s.bus.remove(s) {
+		close(s.done)
+	}
+}
+
+func (s *Subscription) enqueue(e Event) {
+	s.mu.Lock()
+	if len(s.queue) >= s.limit {
+		s.queue[0] = Event{}
+		s.queue = s.queue[1:]
+		s.dropped.Add(1)
+	}
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the oldest queued event
+func (s *Subscription) next() (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return Event{}, false
+	}
+	e := s.queue[0]
+	s.queue[0] = Event{}
+	s.queue = s.queue[1:]
+	return e, true
+}
+
+// run delivers queued events until the subscription ends. When the bus
+// closes, events already queued are delivered first.
+func (s *Subscription) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+		for {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			e, ok := s.next()
+			if !ok {
+				break
+			}
+			s.fn(e)
+		}
+		s.mu.Lock()
+		drained := s.closing && len(s.queue) == 0
+		s.mu.Unlock()
+		if drained {
+			return
+		}
+	}
+}
+
+// eventBus fans published events out to subscribers. Direct subscribers
+// are called inside Publish and must not block.
+type eventBus struct {
+	mu     sync.RWMutex
+	direct []func(Event)
+	subs   []*Subscription
+	wg     sync.WaitGroup
+}
+
+func (b *eventBus) subscribe(fn func(Event), limit int) *Subscription {
+	s := &Subscription{
+		bus:   b,
+		fn:    fn,
+		limit: max(limit, 1),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go s.run(&b.wg)
+	return s
+}
+
+// subscribeDirect registers fn to be called synchronously for every event
+func (b *eventBus) subscribeDirect(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.direct = append(b.direct, fn)
+}
+
+// remove reports whether s was still subscribed
+func (b *eventBus) remove(s *Subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.Index(b.subs, s)
+	if i < 0 {
+		return false
+	}
+	b.subs = slices.Delete(b.subs, i, i+1)
+	return true
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.direct {
+		fn(e)
+	}
+	for _, s := range b.subs {
+		s.enqueue(e)
+	}
+}
+
+// close ends every subscription after its queued events are delivered
+func (b *eventBus) close() {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.mu.Lock()
+		s.closing = true
+		s.mu.Unlock()
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	b.wg.Wait()
+}
+
+// Subscribe calls fn for every event the processor publishes: one per file
+// outcome, with its manifest entry and processing time when available, and
+// one per pause and resume. fn runs on a goroutine of its own and may be
+// slow; events it falls behind on by more than DefaultSubscriberQueue are
+// dropped, oldest first. Subscriptions may be added and removed at any time.
+func (p *Processor) Subscribe(fn func(Event)) *Subscription {
+	return p.events.subscribe(fn, DefaultSubscriberQueue)
+}
+
+// recordEntry is record for an outcome written to the manifest as entry,
+// attaching the entry and the time since hashing started to the event
+func (p *Processor) recordEntry(entry manifest.Entry, outcome status.Status, cause error, started time.Time) {
+	p.settle(entry.SourcePath, outcome)
+	p.endAttempt(entry.SourcePath, entry.SHA256, outcome, cause)
+	e := p.fileEvent(entry.SourcePath, entry.SHA256, outcome, cause)
+	entry = entry.Redacted(p.redactor.Text)
+	e.Entry = &entry
+	if !started.IsZero() {
+		e.Duration = e.At.Sub(started)
+	}
+	p.events.publish(e)
+	p.observeStorm(entry.SourcePath, outcome)
+}
+
+// publishState publishes a pause or resume event
+func (p *Processor) publishState(kind string) {
+	p.events.publish(Event{Kind: kind, At: time.Now()})
+}
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// collector gathers the events delivered to a subscriber
+type collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (c *collector) add(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, e)
+}
+
+func (c *collector) get() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+func TestSubscribe_DeliversFileEventsInOrder(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	var c collector
+	env.processor.Subscribe(c.add)
+
+	src := filepath.Join(env.inputDir, "a.csv")
+	_ = env.processor.processFile(src)
+	ingest(t, env, "a.csv", "first content")
+	ingest(t, env, "b.csv", "first content")
+	env.processor.Pause()
+	env.processor.Pause()
+	env.processor.Resume()
+
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	events := c.get()
+	want := []string{EventFileVanished, EventFileIngested, EventFileDuplicate, EventProcessingPaused, EventProcessingResumed}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want kinds %v", events, want)
+	}
+	for i, kind := range want {
+		if events[i].Kind != kind {
+			t.Errorf("event %d kind = %q, want %q", i, events[i].Kind, kind)
+		}
+	}
+
+	ingested := events[1]
+	if ingested.Path != src || ingested.Outcome != status.Ingested {
+		t.Errorf("ingested event = %+v", ingested)
+	}
+	if ingested.Entry == nil || ingested.Entry.SHA256 != ingested.SHA256 || ingested.Entry.DestPath == "" {
+		t.Errorf("ingested event entry = %+v", ingested.Entry)
+	}
+	if ingested.Duration <= 0 {
+		t.Errorf("ingested event duration = %v, want positive", ingested.Duration)
+	}
+	if dup := events[2]; dup.Entry == nil || dup.Entry.DestPath != ingested.Entry.DestPath {
+		t.Errorf("duplicate event entry = %+v, want the original destination", dup.Entry)
+	}
+}
+
+func TestSubscription_DropsOldestWhenBehind(t *testing.T) {
+	var bus eventBus
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var c collector
+	sub := bus.subscribe(func(e Event) {
+		if e.Path == "1" {
+			started <- struct{}{}
+			<-release
+		}
+		c.add(e)
+	}, 2)
+
+	bus.publish(Event{Path: "1"})
+	<-started
+	// The subscriber is blocked on the first event, so 2 and 3 are dropped
+	// to make room for 4 and 5
+	for _, path := range []string{"2", "3", "4", "5"} {
+		bus.publish(Event{Path: path})
+	}
+	close(release)
+	bus.close()
+
+	var got []string
+	for _, e := range c.get() {
+		got = append(got, e.Path)
+	}
+	if len(got) != 3 || got[0] != "1" || got[1] != "4" || got[2] != "5" {
+		t.Errorf("delivered = %v, want [1 4 5]", got)
+	}
+	if sub.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", sub.Dropped())
+	}
+}
+
+func TestSubscription_SlowSubscriberDoesNotBlockProcessing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	release := make(chan struct{})
+	sub := env.processor.Subscribe(func(Event) { <-release })
+	defer close(release)
+	defer sub.Unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 3 {
+			env.processor.record(filepath.Join(env.inputDir, string(rune('a'+i))), "", status.Failed, nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+	if got := env.processor.Stats().Totals.Failed; got != 3 {
+		t.Errorf("failed count = %d, want 3", got)
+	}
+}
+
+func TestSubscription_Unsubscribe(t *testing.T) {
+	var bus eventBus
+	var kept, removed collector
+	bus.subscribe(kept.add, 10)
+	sub := bus.subscribe(removed.add, 10)
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	bus.publish(Event{Path: "a"})
+	bus.close()
+
+	if got := kept.get(); len(got) != 1 {
+		t.Errorf("remaining subscriber got %d events, want 1", len(got))
+	}
+	if got := removed.get(); len(got) != 0 {
+		t.Errorf("removed subscriber got %+v", got)
+	}
+}
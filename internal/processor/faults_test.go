@@ -0,0 +1,42 @@
+package processor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops/faultfs"
+)
+
+func TestProcessFile_DiskFullInWarehouse(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	fsys := &faultfs.FS{}
+	env.processor.copyOpts.FS = fsys
+	// The warehouse is on another device and fills up partway through the copy
+	fsys.Inject(faultfs.Fault{Op: faultfs.Rename, Suffix: "a.csv", Err: syscall.EXDEV})
+	fsys.Inject(faultfs.Fault{Op: faultfs.Write, Suffix: ".tmp", Err: syscall.ENOSPC, After: 100})
+
+	content := strings.Repeat("1,alice\n", 100)
+	src := filepath.Join(env.inputDir, "a.csv")
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write a.csv: %v", err)
+	}
+	if err := env.processor.processFile(src); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("processFile() error = %v, want ENOSPC", err)
+	}
+
+	if got, err := os.ReadFile(src); err != nil || string(got) != content {
+		t.Errorf("source after the failed copy = %d bytes, %v, want it intact", len(got), err)
+	}
+	_ = filepath.WalkDir(env.warehouseDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("warehouse holds %s after the failed copy", path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,401 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// ReasonMissingParts quarantines a file set whose parts did not all arrive
+// before the part timeout
+const ReasonMissingParts = "missing_parts"
+
+// missingParts returns the part indices absent from set. Parts are numbered
+// from 1, or from 0 when a part 0 exists. The last index is the highest part
+// seen, or the count written in the marker when that is larger, so a missing
+// final part is only detected when the producer writes the count.
+func missingParts(set watcher.FileSet) []int {
+	first, last := 1, 0
+	for i := range set.Parts {
+		if i == 0 {
+			first = 0
+		}
+		last = max(last, i)
+	}
+	if data, err := os.ReadFile(set.MarkerPath); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && n > 0 {
+			last = max(last, first+n-1)
+		}
+	}
+
+	missing := make([]int, 0)
+	for i := first; i <= last; i++ {
+		if _, ok := set.Parts[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// orderedParts returns the parts of set sorted by index with their sizes
+func orderedParts(set watcher.FileSet) ([]string, []manifest.Part, error) {
+	indices := make([]int, 0, len(set.Parts))
+	for i := range set.Parts {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	paths := make([]string, 0, len(indices))
+	parts := make([]manifest.Part, 0, len(indices))
+	for _, i := range indices {
+		info, err := os.Stat(set.Parts[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("stat part %s: %w", set.Parts[i], err)
+		}
+		paths = append(paths, set.Parts[i])
+		parts = append(parts, manifest.Part{Index: i, Name: info.Name(), Size: info.Size()})
+	}
+	return paths, parts, nil
+}
+
+// concatenate streams paths in order into w and returns the SHA256 and size
+// of the concatenation
+func concatenate(w io.Writer, paths []string) (string, int64, error) {
+	hasher := sha256.New()
+	out := io.MultiWriter(w, hasher)
+
+	var size int64
+	for _, path := range paths {
+		n, err := copyPart(out, path)
+		size += n
+		if err != nil {
+			return "", size, err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+func copyPart(w io.Writer, path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open part %s: %w", path, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	n, err := io.Copy(w, file)
+	if err != nil {
+		return n, fmt.Errorf("copy part %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// processFileSet concatenates a complete multi-part set into one warehouse
+// file. The parts and marker are removed only after the combined file is
+// recorded and in place.
+func (p *Processor) processFileSet(set watcher.FileSet) error {
+	if missing := missingParts(set); len(missing) > 0 {
+		timeout := p.cfg.PartTimeout
+		if timeout <= 0 {
+			timeout = config.DefaultPartTimeout
+		}
+		if time.Since(set.MarkerAt) < timeout {
+			slog.Debug("waiting for missing parts", "path", set.Path, "missing", missing)
+			return nil
+		}
+		return p.quarantineSet(set, ReasonMissingParts, missing, fmt.Errorf("parts %v absent after %s", missing, timeout))
+	}
+
+	paths, parts, err := orderedParts(set)
+	if err != nil {
+		return err
+	}
+
+	relPath, err := filepath.Rel(p.cfg.Path, set.Path)
+	if err != nil {
+		return fmt.Errorf("calculate relative path for %s: %w", set.Path, err)
+	}
+	dst, err := p.warehousePath(relPath)
+	if errors.Is(err, ErrPathTooLong) {
+		return p.quarantineSet(set, ReasonPathTooLong, nil, err)
+	}
+	if err != nil {
+		return fmt.Errorf("resolve destination for %s: %w", set.Path, err)
+	}
+
+	if p.cfg.DryRun {
+		hash, size, err := concatenate(io.Discard, paths)
+		if err != nil {
+			return err
+		}
+		if dst, err = p.shard("", hash, dst); err != nil {
+			return fmt.Errorf("shard destination for %s: %w", set.Path, err)
+		}
+		slog.Info("dry run: would concatenate file set",
+			"path", set.Path,
+			"parts", len(parts),
+			"sha256", hash,
+			"destination", dst.path,
+			"size", size,
+		)
+		p.dryRun.wouldIngest(size)
+		p.watcher.RemoveFileSet(set.Path)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst.path), 0o755); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+	tmpDst := dst.path + ".tmp"
+	hash, size, err := p.writeConcatenation(tmpDst, paths)
+	if err != nil {
+		_ = os.Remove(tmpDst)
+		return err
+	}
+	// The hash sharding places by is only known once the parts are written
+	if dst, err = p.shard("", hash, dst); err != nil {
+		_ = os.Remove(tmpDst)
+		return fmt.Errorf("shard destination for %s: %w", set.Path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst.path), 0o755); err != nil {
+		_ = os.Remove(tmpDst)
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+
+	tags, err := p.rules.Evaluate(rules.File{RelPath: filepath.ToSlash(relPath), Size: size, Path: tmpDst})
+	if err != nil {
+		_ = os.Remove(tmpDst)
+		return fmt.Errorf("evaluate tagging rules for %s: %w", set.Path, err)
+	}
+
+	rel := fileops.NormalizeName(p.limits.form, filepath.ToSlash(relPath))
+	processedAt, seq := p.stamp()
+	defer p.order.release(seq)
+	entry := manifest.Entry{
+		SHA256:       hash,
+		Name:         dst.name,
+		OriginalName: dst.originalName,
+		SourcePath:   set.Path,
+		DestPath:     dst.path,
+		Size:         size,
+		ProcessedAt:  processedAt,
+		Status:       status.Ingested,
+		Tags:         tags,
+		Parts:        parts,
+		Sequence:     seq,
+	}
+	var (
+		created  bool
+		existing *storage.File
+	)
+	err = p.ingestTx(func(tx *storage.Storage) error {
+		var err error
+		created, existing, err = tx.CreateFileIfAbsent(storage.FileRecord{
+			SHA256:       hash,
+			Name:         dst.name,
+			OriginalName: dst.originalName,
+			Path:         set.Path,
+			Size:         size,
+			Status:       status.Ingested,
+			DestPath:     dst.path,
+			ProcessedAt:  entry.ProcessedAt,
+			Tags:         tags,
+			RelPath:      rel,
+			Sequence:     entry.Sequence,
+		})
+		if err != nil || !created {
+			return err
+		}
+		return p.commitEntry(tx, entry)
+	})
+	if err != nil {
+		_ = os.Remove(tmpDst)
+		return fmt.Errorf("create database record for %s: %w", set.Path, err)
+	}
+	if !created {
+		_ = os.Remove(tmpDst)
+		slog.Info("file set already processed, skipping", "path", set.Path, "sha256", hash, "original", existing.Path)
+		p.watcher.RemoveFileSet(set.Path)
+		p.record(set.Path, hash, status.Duplicate, nil)
+		p.writeReceipt(set.Path, Receipt{Status: status.Duplicate, SHA256: hash, Destination: existing.DestPath})
+		return nil
+	}
+
+	if err := os.Rename(tmpDst, dst.path); err != nil {
+		_ = os.Remove(tmpDst)
+		if derr := p.storage.ReleaseFile(hash); derr != nil {
+			slog.Error("failed to release database record", "path", set.Path, "sha256", hash, "error", derr)
+		}
+		return fmt.Errorf("rename concatenation to %s: %w", dst.path, err)
+	}
+	p.copyOpts.DeferSync(dst.path)
+	p.setXattrs(entry, storage.SourceOf(rel))
+	p.seal(dst.path)
+
+	if err := p.appendEntry(entry, true); err != nil {
+		slog.Warn("failed to write manifest entry", "path", set.Path, "error", err)
+	}
+
+	for _, path := range paths {
+		p.disposeSource(path)
+	}
+	p.disposeSource(set.MarkerPath)
+	p.watcher.RemoveFileSet(set.Path)
+	p.record(set.Path, hash, status.Ingested, nil)
+	p.writeReceipt(set.Path, Receipt{Status: status.Ingested, SHA256: hash, Destination: dst.path})
+
+	slog.Info("file set processed successfully",
+		"path", set.Path,
+		"parts", len(parts),
+		"sha256", hash,
+		"destination", dst.path,
+		"size", size,
+	)
+	return nil
+}
+
+// writeConcatenation writes the parts to dst, syncing per the copy options
+func (p *Processor) writeConcatenation(dst string, paths []string) (hash string, size int64, err error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", 0, fmt.Errorf("create %s: %w", dst, err)
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close %s: %w", dst, cerr)
+		}
+	}()
+
+	hash, size, err = concatenate(out, paths)
+	if err != nil {
+		return "", 0, err
+	}
+	if p.copyOpts.SyncNow() {
+		if err := out.Sync(); err != nil {
+			return "", 0, fmt.Errorf("sync %s: %w", dst, err)
+		}
+	}
+	return hash, size, nil
+}
+
+// setQuarantineRecord is written next to a quarantined file set
+type setQuarantineRecord struct {
+	Reason        string    `json:"reason"`
+	Error         string    `json:"error,omitempty"`
+	SourcePath    string    `json:"source_path"`
+	Parts         []string  `json:"parts"`
+	MissingParts  []int     `json:"missing_parts,omitempty"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// quarantineSet moves every part and the marker of a set into its own
+// quarantine directory and writes a JSON record listing the absent indices
+func (p *Processor) quarantineSet(set watcher.FileSet, reason string, missing []int, cause error) error {
+	if p.cfg.DryRun {
+		slog.Info("dry run: would quarantine file set", "path", set.Path, "reason", reason, "missing", missing)
+		p.watcher.RemoveFileSet(set.Path)
+		return nil
+	}
+
+	root := p.cfg.QuarantinePath
+	if root == "" {
+		root = config.DefaultQuarantinePath
+	}
+	now, seq := p.stamp()
+	defer p.order.release(seq)
+	rel, err := p.layout.Resolve(layout.QuarantineSet, p.layoutContext(set.Path, now))
+	if err != nil {
+		return fmt.Errorf("resolve quarantine directory of %s: %w", set.Path, err)
+	}
+	dir := filepath.Join(root, rel)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create quarantine directory %s: %w", dir, err)
+	}
+
+	record := setQuarantineRecord{
+		Reason:        reason,
+		SourcePath:    set.Path,
+		Parts:         make([]string, 0, len(set.Parts)+1),
+		MissingParts:  missing,
+		QuarantinedAt: now,
+	}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+
+	paths := make([]string, 0, len(set.Parts)+1)
+	for _, path := range set.Parts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	paths = append(paths, set.MarkerPath)
+
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if err := p.quarantineSource(path, filepath.Join(dir, name)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("move %s to quarantine: %w", path, err)
+		}
+		record.Parts = append(record.Parts, name)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode quarantine record: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "reason.json"), data, 0o644); err != nil {
+		return fmt.Errorf("write quarantine record: %w", err)
+	}
+
+	entry := manifest.Entry{
+		Name:        filepath.Base(set.Path),
+		SourcePath:  set.Path,
+		DestPath:    dir,
+		ProcessedAt: now,
+		Status:      status.Quarantined,
+		Reason:      reason,
+		Sequence:    seq,
+	}
+	if err := p.appendEntry(entry, false); err != nil {
+		slog.Warn("failed to write manifest entry", "path", set.Path, "error", err)
+	}
+
+	p.watcher.RemoveFileSet(set.Path)
+	p.record(set.Path, "", status.Quarantined, cause)
+	receipt := Receipt{Status: status.Quarantined, Destination: dir, Reason: reason}
+	if cause != nil {
+		receipt.Error = cause.Error()
+	}
+	p.writeReceipt(set.Path, receipt)
+
+	slog.Warn("file set quarantined",
+		"path", set.Path,
+		"reason", reason,
+		"missing", missing,
+		"destination", dir,
+		"error", cause,
+	)
+	return nil
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// writeSet creates the given parts and the marker in dir and returns the set
+func writeSet(t *testing.T, dir string, parts map[int]string, marker string) watcher.FileSet {
+	t.Helper()
+	stem := filepath.Join(dir, "file.csv")
+	set := watcher.FileSet{
+		Path:       stem,
+		Parts:      make(map[int]string),
+		MarkerPath: stem + config.FileSetMarkerSuffix,
+		MarkerAt:   time.Now(),
+	}
+	for i, content := range parts {
+		path := stem + "." + []string{"000", "001", "002", "003", "004"}[i]
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write part: %v", err)
+		}
+		set.Parts[i] = path
+	}
+	if err := os.WriteFile(set.MarkerPath, []byte(marker), 0o644); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+	return set
+}
+
+func TestProcessFileSet_ConcatenatesInOrder(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// Map iteration makes arrival order irrelevant; parts must be joined by index
+	set := writeSet(t, env.inputDir, map[int]string{3: "c\n", 1: "a\n", 2: "b\n"}, "")
+
+	if err := env.processor.processFileSet(set); err != nil {
+		t.Fatalf("processFileSet() error = %v", err)
+	}
+
+	dst := filepath.Join(env.warehouseDir, "file.csv")
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("combined file missing: %v", err)
+	}
+	if string(data) != "a\nb\nc\n" {
+		t.Errorf("combined content = %q, want %q", data, "a\nb\nc\n")
+	}
+
+	hash, err := fileops.CalculateSHA256(dst)
+	if err != nil {
+		t.Fatalf("failed to hash combined file: %v", err)
+	}
+	if exists, _ := env.store.FileExistsSince(hash, time.Time{}); !exists {
+		t.Error("combined file not recorded by hash of the concatenation")
+	}
+
+	for _, path := range append([]string{set.MarkerPath}, set.Parts[1], set.Parts[2], set.Parts[3]) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("source %s not removed: %v", path, err)
+		}
+	}
+
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || len(entries[0].Parts) != 3 {
+		t.Fatalf("manifest entries = %+v, want one entry with 3 parts", entries)
+	}
+	for i, part := range entries[0].Parts {
+		if part.Index != i+1 || part.Size != 2 {
+			t.Errorf("part %d = %+v, want index %d size 2", i, part, i+1)
+		}
+	}
+}
+
+func TestProcessFileSet_GapWaitsThenQuarantines(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+	env.cfg.PartTimeout = time.Hour
+
+	set := writeSet(t, env.inputDir, map[int]string{1: "a", 3: "c"}, "4")
+
+	// Within the timeout the set keeps waiting
+	if err := env.processor.processFileSet(set); err != nil {
+		t.Fatalf("processFileSet() error = %v", err)
+	}
+	if _, err := os.Stat(set.Parts[1]); err != nil {
+		t.Fatalf("part moved before timeout: %v", err)
+	}
+
+	set.MarkerAt = time.Now().Add(-2 * time.Hour)
+	if err := env.processor.processFileSet(set); err != nil {
+		t.Fatalf("processFileSet() error = %v", err)
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(env.cfg.QuarantinePath, "sets", "file.csv-*"))
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("quarantine dirs = %v, %v; want one", dirs, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dirs[0], "reason.json"))
+	if err != nil {
+		t.Fatalf("failed to read reason file: %v", err)
+	}
+	var record setQuarantineRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode reason file: %v", err)
+	}
+	if record.Reason != ReasonMissingParts {
+		t.Errorf("reason = %q, want %q", record.Reason, ReasonMissingParts)
+	}
+	if !reflect.DeepEqual(record.MissingParts, []int{2, 4}) {
+		t.Errorf("missing parts = %v, want [2 4]", record.MissingParts)
+	}
+	if len(record.Parts) != 3 {
+		t.Errorf("quarantined files = %v, want 2 parts and the marker", record.Parts)
+	}
+
+	entries, err := os.ReadDir(env.warehouseDir)
+	if err != nil {
+		t.Fatalf("failed to read warehouse: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("warehouse has %d entries, want 0", len(entries))
+	}
+}
+
+func TestMissingParts(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		parts  map[int]string
+		marker string
+		want   []int
+	}{
+		{"complete", map[int]string{1: "a", 2: "b"}, "", []int{}},
+		{"gap", map[int]string{1: "a", 3: "c"}, "", []int{2}},
+		{"zero based", map[int]string{0: "a", 1: "b"}, "2", []int{}},
+		{"missing tail from count", map[int]string{1: "a", 2: "b"}, "3", []int{3}},
+		{"missing head", map[int]string{2: "b"}, "", []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := writeSet(t, dir, tt.parts, tt.marker)
+			if got := missingParts(set); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingParts() = %v, want %v", got, tt.want)
+			}
+			for _, p := range set.Parts {
+				_ = os.Remove(p)
+			}
+		})
+	}
+}
+
+// readManifest decodes every manifest entry under dir
+func readManifest(t *testing.T, dir string) []manifest.Entry {
+	t.Helper()
+	var entries []manifest.Entry
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		read, err := manifest.ReadFile(path)
+		entries = append(entries, read...)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read manifests: %v", err)
+	}
+	return entries
+}
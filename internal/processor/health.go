@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+)
+
+// SetDestinationHealth probes the warehouse through h before each pass
+// dispatches its files. While the probe fails no file is attempted: the
+// pass returns with its files still tracked, Degraded reports it and
+// EventDestinationDegraded is published once, then EventDestinationRecovered
+// when a later probe, retried with the backoff of h, succeeds.
+func (p *Processor) SetDestinationHealth(h *destination.Health) {
+	p.health = h
+}
+
+// Degraded reports whether dispatch is held because the warehouse failed its
+// latest probe
+func (p *Processor) Degraded() bool {
+	return p.degraded.Load()
+}
+
+// DestinationHealth returns the state of the warehouse probes with its error
+// redacted, nil when the warehouse is not probed
+func (p *Processor) DestinationHealth() *destination.HealthStatus {
+	if p.health == nil {
+		return nil
+	}
+	status := p.health.Status()
+	status.Error = p.redactor.Text(status.Error)
+	return &status
+}
+
+// destinationHealthy reports whether the warehouse passed its probe, so
+// that the pending files of a pass can be dispatched, publishing the change
+// when it differs from the previous pass
+func (p *Processor) destinationHealthy(pending int) bool {
+	if p.health == nil {
+		return true
+	}
+	err := p.health.Check(p.ctx)
+	if err == nil {
+		if p.degraded.CompareAndSwap(true, false) {
+			slog.Info("warehouse healthy again, dispatching", "pending", pending)
+			p.events.publish(Event{Kind: EventDestinationRecovered, At: time.Now(), Destination: p.DestinationHealth()})
+		}
+		return true
+	}
+	if p.degraded.CompareAndSwap(false, true) {
+		status := p.DestinationHealth()
+		slog.Error("warehouse failed its health probe, holding dispatch", "pending", pending, "error", err, "next_probe", status.NextProbe)
+		p.events.publish(Event{Kind: EventDestinationDegraded, Error: status.Error, At: time.Now(), Destination: status})
+	} else {
+		slog.Debug("warehouse still unhealthy, holding dispatch", "pending", pending, "error", err)
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+)
+
+func TestDestinationHealth_HoldsDispatchWhileUnhealthy(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	dest := destination.NewMemory()
+	dest.SetPingError(errors.New("warehouse unreachable"))
+	env.processor.SetDestinationHealth(destination.NewHealth(dest, destination.HealthOptions{
+		Cache:      time.Hour,
+		MinBackoff: 200 * time.Millisecond,
+		MaxBackoff: 200 * time.Millisecond,
+	}))
+	var attempts atomic.Int64
+	env.processor.failpoints = map[string]func(){stageStat: func() { attempts.Add(1) }}
+	var c collector
+	env.processor.Subscribe(c.add)
+
+	srcs := writeSourceFiles(t, env, "a.csv", "b.csv")
+	old := time.Now().Add(-time.Hour)
+	for _, src := range srcs {
+		if err := os.Chtimes(src, old, old); err != nil {
+			t.Fatalf("failed to age test file: %v", err)
+		}
+	}
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	// Ticks within the backoff reuse the failed probe
+	env.processor.ProcessFiles()
+	env.processor.ProcessFiles()
+	if n := attempts.Load(); n != 0 {
+		t.Errorf("%d files attempted while the warehouse was unhealthy, want none", n)
+	}
+	if n := dest.Pings(); n != 1 {
+		t.Errorf("warehouse probed %d times within the backoff, want once", n)
+	}
+	if !env.processor.Degraded() {
+		t.Error("Degraded() = false while the probe fails")
+	}
+	if status := env.processor.DestinationHealth(); status == nil || status.Healthy || status.Failures != 1 {
+		t.Errorf("DestinationHealth() = %+v, want one failed probe", status)
+	}
+	for _, src := range srcs {
+		if _, err := os.Stat(src); err != nil {
+			t.Errorf("source %s moved while the warehouse was unhealthy: %v", src, err)
+		}
+	}
+
+	dest.SetPingError(nil)
+	time.Sleep(250 * time.Millisecond)
+	env.processor.ProcessFiles()
+	if env.processor.Degraded() {
+		t.Error("Degraded() = true once the probe succeeded")
+	}
+	for _, src := range srcs {
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("source %s not ingested once the warehouse recovered: %v", src, err)
+		}
+	}
+
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	var kinds []string
+	for _, e := range c.get() {
+		if e.Kind == EventDestinationDegraded || e.Kind == EventDestinationRecovered {
+			kinds = append(kinds, e.Kind)
+			if e.Destination == nil {
+				t.Errorf("%s event without the destination status", e.Kind)
+			}
+		}
+	}
+	if want := []string{EventDestinationDegraded, EventDestinationRecovered}; len(kinds) != 2 || kinds[0] != want[0] || kinds[1] != want[1] {
+		t.Errorf("destination events = %v, want %v", kinds, want)
+	}
+}
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// keyGroup names the capture group holding the idempotency key in a
+// pattern; patterns without it use their first group
+const keyGroup = "key"
+
+// idempotencyKeys extracts producer-supplied idempotency keys
+type idempotencyKeys struct {
+	pattern *regexp.Regexp
+	// group is the index of the submatch holding the key
+	group int
+	// field is the sidecar JSON field holding the key
+	field string
+}
+
+// SetIdempotencyKey enables idempotency keys, extracted from file names by
+// pattern (its "key" group, or its first group) or from the sidecar JSON
+// field. Either may be empty; the pattern is tried first. A file arriving
+// under a key that was already ingested is a duplicate even when its
+// content differs.
+func (p *Processor) SetIdempotencyKey(pattern, field string) error {
+	keys := &idempotencyKeys{field: field}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("idempotency key pattern %q: %w", pattern, err)
+		}
+		keys.pattern = re
+		if i := re.SubexpIndex(keyGroup); i > 0 {
+			keys.group = i
+		} else if re.NumSubexp() > 0 {
+			keys.group = 1
+		}
+	}
+	if keys.pattern == nil && keys.field == "" {
+		keys = nil
+	}
+	p.keys = keys
+	return nil
+}
+
+// idempotencyKey returns the key of the source at path, or "" when none is
+// configured or it cannot be extracted, leaving dedup to the hash alone
+func (p *Processor) idempotencyKey(path string) string {
+	if p.keys == nil {
+		return ""
+	}
+	if p.keys.pattern != nil {
+		if m := p.keys.pattern.FindStringSubmatch(filepath.Base(path)); m != nil && m[p.keys.group] != "" {
+			return m[p.keys.group]
+		}
+	}
+	if p.keys.field != "" {
+		key, err := sidecarField(path+config.SidecarSuffix, p.keys.field)
+		if err == nil && key != "" {
+			return key
+		}
+		slog.Debug("no idempotency key in sidecar, deduplicating by hash", "path", path, "field", p.keys.field, "error", err)
+	}
+	return ""
+}
+
+// sidecarField reads field from the JSON object in the sidecar at path.
+// String and number values are accepted.
+func sidecarField(path, field string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var obj map[string]any
+	if err := d.Decode(&obj); err != nil {
+		return "", fmt.Errorf("decode sidecar %s: %w", path, err)
+	}
+	switch v := obj[field].(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", fmt.Errorf("sidecar %s has no field %q", path, field)
+	default:
+		return "", fmt.Errorf("sidecar %s field %q is a %T, not a string", path, field, v)
+	}
+}
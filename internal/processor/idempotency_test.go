@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestIdempotencyKey_Extraction(t *testing.T) {
+	dir := t.TempDir()
+	writeSidecar := func(name, content string) string {
+		src := filepath.Join(dir, name)
+		if err := os.WriteFile(src+config.SidecarSuffix, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create sidecar: %v", err)
+		}
+		return src
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		field   string
+		path    string
+		want    string
+	}{
+		{"named group", `^(\w+)_(?P<key>[0-9a-f]+)\.csv$`, "", filepath.Join(dir, "orders_ab12.csv"), "ab12"},
+		{"first group", `^batch-(\d+)`, "", filepath.Join(dir, "batch-42.csv"), "42"},
+		{"no group uses whole match", `^batch-\d+`, "", filepath.Join(dir, "batch-42.csv"), "batch-42"},
+		{"pattern miss", `^batch-(\d+)`, "", filepath.Join(dir, "other.csv"), ""},
+		{"sidecar string", "", "request_id", writeSidecar("s.csv", `{"request_id":"r-1"}`), "r-1"},
+		{"sidecar number", "", "request_id", writeSidecar("n.csv", `{"request_id":12345678901234567890}`), "12345678901234567890"},
+		{"sidecar missing field", "", "request_id", writeSidecar("m.csv", `{"other":"x"}`), ""},
+		{"sidecar wrong type", "", "request_id", writeSidecar("w.csv", `{"request_id":{"a":1}}`), ""},
+		{"sidecar invalid", "", "request_id", writeSidecar("i.csv", `{not json`), ""},
+		{"sidecar absent", "", "request_id", filepath.Join(dir, "absent.csv"), ""},
+		{"pattern before sidecar", `^p-(\w+)`, "request_id", writeSidecar("p-name.csv", `{"request_id":"r-2"}`), "name"},
+		{"sidecar after pattern miss", `^p-(\w+)`, "request_id", writeSidecar("q.csv", `{"request_id":"r-3"}`), "r-3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Processor{}
+			if err := p.SetIdempotencyKey(tt.pattern, tt.field); err != nil {
+				t.Fatalf("SetIdempotencyKey() error = %v", err)
+			}
+			if got := p.idempotencyKey(tt.path); got != tt.want {
+				t.Errorf("idempotencyKey(%s) = %q, want %q", filepath.Base(tt.path), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetIdempotencyKey(t *testing.T) {
+	p := &Processor{}
+	if err := p.SetIdempotencyKey("(", ""); err == nil {
+		t.Error("SetIdempotencyKey() should reject an invalid pattern")
+	}
+	if err := p.SetIdempotencyKey("", ""); err != nil || p.keys != nil {
+		t.Errorf("SetIdempotencyKey() with nothing configured = %v, keys %+v, want disabled", err, p.keys)
+	}
+	if got := p.idempotencyKey("/in/a.csv"); got != "" {
+		t.Errorf("idempotencyKey() without keys = %q, want empty", got)
+	}
+}
+
+func TestIngest_DuplicateByIdempotencyKey(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.processor.SetIdempotencyKey(`^(?P<key>\w+)_`, ""); err != nil {
+		t.Fatalf("SetIdempotencyKey() error = %v", err)
+	}
+
+	ingest(t, env, "order7_a.csv", "first rendering")
+	// A resend regenerates the file, so its bytes differ
+	ingest(t, env, "order7_b.csv", "second rendering")
+
+	original, err := env.store.FindByIdempotencyKey("order7")
+	if err != nil || original == nil {
+		t.Fatalf("FindByIdempotencyKey() = %+v, %v, want the first file", original, err)
+	}
+	if original.Name != "order7_a.csv" {
+		t.Errorf("key owner = %s, want order7_a.csv", original.Name)
+	}
+
+	dups := duplicateEntries(t, env)
+	if len(dups) != 1 {
+		t.Fatalf("duplicate entries = %+v, want 1", dups)
+	}
+	dup := dups[0]
+	if dup.Dedup != manifest.DedupKey || dup.IdempotencyKey != "order7" {
+		t.Errorf("duplicate entry = %+v, want dedup by key order7", dup)
+	}
+	if dup.OriginalSHA256 != original.SHA256 || dup.SHA256 == original.SHA256 {
+		t.Errorf("duplicate hashes = %s, original %s, want the new hash and the original", dup.SHA256, dup.OriginalSHA256)
+	}
+}
+
+func TestIngest_HashDuplicateUnderNewKey(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.processor.SetIdempotencyKey(`^(\w+)_`, ""); err != nil {
+		t.Fatalf("SetIdempotencyKey() error = %v", err)
+	}
+
+	ingest(t, env, "order1_a.csv", "same content")
+	ingest(t, env, "order2_a.csv", "same content")
+
+	dups := duplicateEntries(t, env)
+	if len(dups) != 1 {
+		t.Fatalf("duplicate entries = %+v, want 1", dups)
+	}
+	if dups[0].Dedup != manifest.DedupHash || dups[0].IdempotencyKey != "order2" || dups[0].OriginalSHA256 != "" {
+		t.Errorf("duplicate entry = %+v, want dedup by hash under key order2", dups[0])
+	}
+	if file, _ := env.store.FindByIdempotencyKey("order2"); file != nil {
+		t.Errorf("hash duplicate should not claim its key, got %+v", file)
+	}
+}
+
+func TestIngest_MissingKeyFallsBackToHash(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.processor.SetIdempotencyKey("", "request_id"); err != nil {
+		t.Fatalf("SetIdempotencyKey() error = %v", err)
+	}
+
+	ingest(t, env, "a.csv", "content a")
+	ingest(t, env, "b.csv", "content b")
+	ingest(t, env, "c.csv", "content a")
+
+	var ingested int
+	for _, e := range readManifest(t, env.manifestsDir) {
+		if e.IdempotencyKey != "" {
+			t.Errorf("entry %s has key %q without a sidecar", e.Name, e.IdempotencyKey)
+		}
+		if e.Status == status.Ingested {
+			ingested++
+		}
+	}
+	if ingested != 2 {
+		t.Errorf("ingested = %d, want 2", ingested)
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 1 || dups[0].Dedup != manifest.DedupHash {
+		t.Errorf("duplicate entries = %+v, want one by hash", dups)
+	}
+}
@@ -0,0 +1,42 @@
+package processor
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+)
+
+// SetLayout places warehouse files, quarantined files and sets, receipts
+// and the JSON Lines manifest files by the templates of r. Call before
+// SetManifest and the first ProcessFiles.
+func (p *Processor) SetLayout(r *layout.Resolver) {
+	p.layout = r
+	p.manifest.SetLayout(r)
+}
+
+// layoutContext returns the layout context of the source at path at t. A
+// path outside the input directory counts as a top-level file of its name.
+func (p *Processor) layoutContext(path string, t time.Time) layout.Context {
+	rel, err := filepath.Rel(p.cfg.Path, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(path)
+	}
+	return layout.NewContext(filepath.ToSlash(rel), t)
+}
+
+// warehousePath resolves the warehouse path relPath, relative to the input
+// directory, is laid out at like resolveDestination. A name the layout
+// changes is kept as the original name.
+func (p *Processor) warehousePath(relPath string) (resolvedPath, error) {
+	rel := filepath.ToSlash(relPath)
+	laidOut, err := p.layout.Resolve(layout.Warehouse, layout.NewContext(rel, p.clock()))
+	if err != nil {
+		return resolvedPath{}, err
+	}
+	dst, err := resolveDestination(p.cfg.Destination, laidOut, p.limits)
+	if err != nil {
+		return dst, err
+	}
+	return renamedFrom(dst, rel, filepath.ToSlash(laidOut)), nil
+}
@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+)
+
+func TestLayout_Custom(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WriteReceipts = true
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	r, err := layout.Compile(
+		map[string]string{
+			"warehouse":  "{source}/{yyyy}/{name}",
+			"quarantine": "{source}/{sha256}{ext}",
+		},
+		[]config.SourceConfig{{Name: "acme", Paths: map[string]string{
+			"manifest": "{yyyy}/{mm}/{dd}/{hh}/{source}/manifest.jsonl",
+			"receipt":  "{stem}.done" + config.ReceiptSuffix,
+		}}},
+	)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	env.processor.SetLayout(r)
+
+	dir := filepath.Join(env.inputDir, "acme", "in")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "a.csv")
+	if err := os.WriteFile(src, []byte("1,alice\n"), 0o644); err != nil {
+		t.Fatalf("failed to write a.csv: %v", err)
+	}
+	start := time.Now()
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	wantDest := filepath.Join(env.warehouseDir, "acme", start.Format("2006"), "a.csv")
+	rec, err := env.store.FindByDestPath(wantDest)
+	if err != nil || rec == nil {
+		t.Fatalf("FindByDestPath(%s) = %v, %v, want the file laid out by source and year", wantDest, rec, err)
+	}
+	if rec.RelPath != "acme/in/a.csv" {
+		t.Errorf("recorded relative path = %q, want the path in the input", rec.RelPath)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.done"+config.ReceiptSuffix)); err != nil {
+		t.Errorf("receipt not written by the source's template: %v", err)
+	}
+	manifestDir := filepath.Join(env.manifestsDir, start.Format("2006/01/02/15"), "acme")
+	if entries := readManifest(t, manifestDir); len(entries) != 1 || entries[0].DestPath != wantDest {
+		t.Errorf("manifest of acme = %+v, want the entry of a.csv", entries)
+	}
+	entries, err := env.processor.manifest.ManifestEntries(start.Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("ManifestEntries() = %d entries, %v, want the entry of the source's manifest", len(entries), err)
+	}
+
+	bad := filepath.Join(dir, "b.json")
+	if err := os.WriteFile(bad, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.processor.quarantine(bad, "beef", 1, "test", StepResolve, nil); err != nil {
+		t.Fatalf("quarantine() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.cfg.QuarantinePath, "acme", "beef.json")); err != nil {
+		t.Errorf("file not quarantined by the template: %v", err)
+	}
+}
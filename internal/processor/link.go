@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// removesSource reports whether the source at path is deleted as soon as it
+// is ingested, rather than kept or moved into the trash
+func (p *Processor) removesSource(path string) bool {
+	return !p.keepsSource(path) && p.cfg.SourceGrace <= 0
+}
+
+// placeOpts returns the copy options for copying the source at path into
+// the warehouse. A hard link shares its data with the source, so it is only
+// made when the source is deleted once ingested, or with AllowHardlink.
+func (p *Processor) placeOpts(path string) fileops.CopyOptions {
+	opts := p.copyOpts
+	opts.AllowHardlink = p.cfg.AllowHardlink || p.removesSource(path)
+	return opts
+}
+
+// sharedLinks returns the link count of a warehouse copy at path of the
+// source at src placed by method when it stays linked to the kept source,
+// and 0 otherwise
+func (p *Processor) sharedLinks(method fileops.CopyMethod, src, path string) int {
+	if method != fileops.CopyHardlink || p.removesSource(src) {
+		return 0
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("failed to stat hard-linked file", "destination", path, "error", err)
+		return 0
+	}
+	return fileops.LinkCount(info)
+}
+
+// recordLinks records on fc, and on its manifest entry already committed to
+// the database, that its warehouse copy shares the inode of the kept source
+func (p *Processor) recordLinks(fc *FileContext, method fileops.CopyMethod) {
+	links := p.sharedLinks(method, fc.SourcePath, fc.Dest.path)
+	if links == 0 {
+		return
+	}
+	slog.Debug("warehouse copy hard-linked to the kept source",
+		"path", fc.SourcePath,
+		"destination", fc.Dest.path,
+		"link_count", links,
+	)
+	fc.LinkCount = links
+	if !p.manifest.InDatabase() {
+		return
+	}
+	if err := p.storage.UpdateLinkCount(fc.SHA256, links); err != nil {
+		slog.Warn("failed to record link count", "path", fc.SourcePath, "sha256", fc.SHA256, "error", err)
+	}
+}
+
+// seal makes an ingested file read-only with ImmutableWarehouse. The file is
+// already in the warehouse, so failures are only logged.
+func (p *Processor) seal(path string) {
+	if !p.cfg.ImmutableWarehouse {
+		return
+	}
+	info, err := os.Stat(path)
+	if err == nil {
+		err = os.Chmod(path, info.Mode().Perm()&^0o222)
+	}
+	if err != nil {
+		slog.Warn("failed to make ingested file read-only", "destination", path, "error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// overwriteInPlace rewrites path in place, as a producer reusing its file
+// would, reporting whether the write succeeded
+func overwriteInPlace(t *testing.T, path, content string) bool {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return true
+}
+
+func TestKeepSource_InPlaceModification(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		hardlink  bool
+		immutable bool
+		// changed is whether the in-place write reaches the warehouse
+		changed bool
+		links   int
+	}{
+		{name: "default copies"},
+		{name: "hard link shares the data", hardlink: true, changed: true, links: 2},
+		{name: "immutable copy", immutable: true},
+		{name: "immutable hard link", hardlink: true, immutable: true, links: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.KeepSource = true
+			env.cfg.AllowHardlink = tt.hardlink
+			env.cfg.ImmutableWarehouse = tt.immutable
+
+			ingest(t, env, "a.csv", "original")
+			src := filepath.Join(env.inputDir, "a.csv")
+			dst := filepath.Join(env.warehouseDir, "a.csv")
+
+			e := entryOf(t, env, "a.csv")
+			if e.LinkCount != tt.links {
+				t.Errorf("manifest link_count = %d, want %d", e.LinkCount, tt.links)
+			}
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatalf("stat warehouse copy: %v", err)
+			}
+			if readOnly := info.Mode().Perm()&0o222 == 0; readOnly != tt.immutable {
+				t.Errorf("warehouse copy mode = %v, want read-only %v", info.Mode(), tt.immutable)
+			}
+
+			wrote := overwriteInPlace(t, src, "tampered")
+			if tt.immutable && tt.hardlink && wrote && os.Geteuid() != 0 {
+				t.Error("in-place write to a source linked to a read-only copy succeeded")
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatalf("read warehouse copy: %v", err)
+			}
+			want := "original"
+			if tt.changed || (tt.hardlink && wrote) {
+				want = "tampered"
+			}
+			if string(got) != want {
+				t.Errorf("warehouse copy = %q after the in-place write, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestKeepSource_LinkCountInDatabaseManifest(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.KeepSource = true
+	env.cfg.AllowHardlink = true
+	useDatabaseSink(env, false)
+
+	from := time.Now()
+	ingest(t, env, "a.csv", "alpha")
+	entries, err := env.store.ManifestEntries(from, time.Now())
+	if err != nil {
+		t.Fatalf("ManifestEntries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].LinkCount != 2 {
+		t.Errorf("ManifestEntries() = %+v, want the link count of the shared inode", entries)
+	}
+}
+
+func TestMoveSource_NoLinkCount(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ImmutableWarehouse = true
+
+	ingest(t, env, "a.csv", "alpha")
+	if e := entryOf(t, env, "a.csv"); e.LinkCount != 0 {
+		t.Errorf("manifest link_count = %d for a moved source, want 0", e.LinkCount)
+	}
+	info, err := os.Stat(filepath.Join(env.warehouseDir, "a.csv"))
+	if err != nil || info.Mode().Perm()&0o222 != 0 {
+		t.Errorf("warehouse copy = %v, %v, want read-only", info, err)
+	}
+}
@@ -0,0 +1,138 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// SetOutbox writes every published event to the outbox table for d to
+// deliver. The notification of an ingested file is written in the
+// transaction creating its record and held until the file is in place, so
+// it survives a crash in between and is never sent for a claim that is
+// released. Call RecoverOutbox before processing files.
+func (p *Processor) SetOutbox(d *outbox.Dispatcher) {
+	p.outbox = d
+	p.events.subscribeDirect(p.enqueueNotification)
+}
+
+// holdNotification writes the notification of an ingested file in tx, held
+// until its event is published
+func (p *Processor) holdNotification(tx *storage.Storage, entry manifest.Entry) error {
+	if p.outbox == nil {
+		return nil
+	}
+	e := p.fileEvent(entry.SourcePath, entry.SHA256, status.Ingested, nil)
+	redacted := entry.Redacted(p.redactor.Text)
+	e.Entry = &redacted
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode notification for %s: %w", entry.SourcePath, err)
+	}
+	return tx.EnqueueOutbox(&storage.OutboxMessage{
+		CreatedAt: e.At,
+		Kind:      e.Kind,
+		SHA256:    e.SHA256,
+		Payload:   string(payload),
+	})
+}
+
+// enqueueNotification writes e to the outbox, releasing the notification
+// held for it when e reports an ingested file. Duplicates summarized by a
+// resend storm are notified through the storm events only.
+func (p *Processor) enqueueNotification(e Event) {
+	if e.Summarized {
+		return
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("failed to encode notification", "kind", e.Kind, "path", e.Path, "error", err)
+		return
+	}
+	if releasesHeld(e.Outcome) && e.SHA256 != "" {
+		released, err := p.storage.ReleaseOutbox(e.SHA256, string(payload))
+		if err != nil {
+			slog.Error("failed to release notification", "path", e.Path, "sha256", e.SHA256, "error", err)
+			return
+		}
+		if released {
+			p.outbox.Notify()
+			return
+		}
+	}
+	err = p.storage.EnqueueOutbox(&storage.OutboxMessage{
+		CreatedAt: e.At,
+		Kind:      e.Kind,
+		SHA256:    e.SHA256,
+		Payload:   string(payload),
+		Ready:     true,
+	})
+	if err != nil {
+		slog.Error("failed to write notification to outbox", "kind", e.Kind, "path", e.Path, "error", err)
+		return
+	}
+	p.outbox.Notify()
+}
+
+// RecoverOutbox settles the notifications a crash left held between the
+// claim of a file and its event: those of files that reached the warehouse
+// are released and those whose claim is gone are deleted. Files still
+// missing from the warehouse keep theirs held.
+func (p *Processor) RecoverOutbox() error {
+	held, err := p.storage.HeldOutbox()
+	if err != nil {
+		return err
+	}
+	released := 0
+	for _, msg := range held {
+		rec, err := p.storage.FindBySHA256(msg.SHA256)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec == nil:
+			err = p.storage.DeleteOutbox(msg.ID)
+		case fileExists(rec.DestPath):
+			released++
+			_, err = p.storage.ReleaseOutbox(msg.SHA256, "")
+		default:
+			slog.Warn("notification held for a file missing from the warehouse",
+				"sha256", msg.SHA256,
+				"destination", rec.DestPath,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if released > 0 {
+		slog.Info("released notifications held by an interrupted run", "count", released)
+		p.outbox.Notify()
+	}
+	return nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// releasesHeld reports whether the notification of outcome replaces one held
+// in the outbox. Only ingests commit theirs with the record, held until the
+// file is in place.
+func releasesHeld(outcome status.Status) bool {
+	switch outcome {
+	case status.Ingested:
+		return true
+	case status.Duplicate, status.DuplicateSummary, status.Quarantined, status.Vanished, status.Failed,
+		status.Adopted, status.Suspect, status.MissingRestored, status.Superseded:
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// pendingNotifications decodes the ready messages of the outbox
+func pendingNotifications(t *testing.T, store *storage.Storage) []Event {
+	t.Helper()
+	msgs, err := store.PendingOutbox(100)
+	if err != nil {
+		t.Fatalf("PendingOutbox failed: %v", err)
+	}
+	events := make([]Event, 0, len(msgs))
+	for _, msg := range msgs {
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			t.Fatalf("notification %d is not an event: %v", msg.ID, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestOutbox_IngestedNotificationHeldUntilMoved(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.SetOutbox(outbox.New(env.store, nil, 0))
+
+	var atClaim storage.OutboxStatus
+	env.processor.failpoints = map[string]func(){stageClaim: func() {
+		atClaim, _ = env.store.OutboxStatus()
+	}}
+	ingest(t, env, "data.csv", "a,b\n")
+
+	if atClaim.Held != 1 || atClaim.Depth != 0 {
+		t.Errorf("outbox at the claim = %+v, want the notification held with the record", atClaim)
+	}
+	events := pendingNotifications(t, env.store)
+	if len(events) != 1 || events[0].Kind != EventFileIngested || events[0].Entry == nil {
+		t.Fatalf("notifications = %+v, want the ingested event with its entry", events)
+	}
+	if status, _ := env.store.OutboxStatus(); status.Held != 0 {
+		t.Errorf("held notifications after the move = %d, want 0", status.Held)
+	}
+}
+
+func TestOutbox_ReleasedClaimNotifiesFailure(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.SetOutbox(outbox.New(env.store, nil, 0))
+
+	steps := builtins(env.processor, StepDedup, StepResolve, StepClaim)
+	steps = append(steps, funcStep{name: "broken", fn: func(*FileContext) error {
+		return errors.New("disk on fire")
+	}})
+	env.processor.defaultSteps = steps
+	env.processor.runPipeline(writeSourceFiles(t, env, "data.csv"))
+
+	events := pendingNotifications(t, env.store)
+	if len(events) != 1 || events[0].Kind != EventFileFailed {
+		t.Errorf("notifications = %+v, want only the failure", events)
+	}
+	if status, _ := env.store.OutboxStatus(); status.Held != 0 {
+		t.Errorf("held notifications after the release = %d, want 0", status.Held)
+	}
+}
+
+func TestRecoverOutbox(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.SetOutbox(outbox.New(env.store, nil, 0))
+
+	// Crashes left notifications held for a file that reached the
+	// warehouse, for one that did not and for a claim without a record
+	landed := filepath.Join(env.warehouseDir, "landed.csv")
+	if err := os.WriteFile(landed, []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to create warehouse file: %v", err)
+	}
+	for _, rec := range []storage.FileRecord{
+		{SHA256: "landed", Name: "landed.csv", Status: status.Ingested, DestPath: landed},
+		{SHA256: "missing", Name: "missing.csv", Status: status.Ingested, DestPath: filepath.Join(env.warehouseDir, "missing.csv")},
+	} {
+		if _, _, err := env.store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent failed: %v", err)
+		}
+	}
+	for _, hash := range []string{"landed", "missing", "orphan"} {
+		if err := env.store.EnqueueOutbox(&storage.OutboxMessage{Kind: EventFileIngested, SHA256: hash, Payload: "{}"}); err != nil {
+			t.Fatalf("EnqueueOutbox failed: %v", err)
+		}
+	}
+
+	if err := env.processor.RecoverOutbox(); err != nil {
+		t.Fatalf("RecoverOutbox failed: %v", err)
+	}
+	pending, _ := env.store.PendingOutbox(10)
+	if len(pending) != 1 || pending[0].SHA256 != "landed" {
+		t.Errorf("ready notifications = %+v, want the landed file's", pending)
+	}
+	held, _ := env.store.HeldOutbox()
+	if len(held) != 1 || held[0].SHA256 != "missing" {
+		t.Errorf("held notifications = %+v, want the missing file's only", held)
+	}
+}
@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+)
+
+// orderedCommitter writes manifest entries in the order of their sequence
+// numbers when ManifestStrictOrder is set. Every number stamped stays open
+// until its entry is committed or the number is released unused; an entry
+// committed while an earlier number is open waits in memory and is written
+// by the call that writes or releases the last number before it. Entries
+// without a sequence number are written at once.
+type orderedCommitter struct {
+	mu   sync.Mutex
+	open map[int64]bool
+	// ready holds the committed entries waiting for earlier numbers
+	ready map[int64]pendingEntry
+}
+
+// pendingEntry is a committed entry waiting to be written
+type pendingEntry struct {
+	path  string
+	write func() error
+}
+
+func newOrderedCommitter() *orderedCommitter {
+	return &orderedCommitter{open: make(map[int64]bool), ready: make(map[int64]pendingEntry)}
+}
+
+// opened records seq as handed out. Callers hold seq.mu, so numbers open
+// in the order they are stamped.
+func (c *orderedCommitter) opened(seq int64) {
+	if c == nil || seq == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open[seq] = true
+}
+
+// release gives up seq without an entry, as for a file that failed after
+// it was stamped. Releasing a number whose entry was committed is a no-op.
+func (c *orderedCommitter) release(seq int64) {
+	if c == nil || seq == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ready[seq]; ok || !c.open[seq] {
+		return
+	}
+	delete(c.open, seq)
+	c.flush(0)
+}
+
+// commit writes the entry numbered seq through write once every earlier
+// number is written or released. It returns the error of write when the
+// entry is written before it returns and nil when the entry waits; a
+// later failure to write it is logged.
+func (c *orderedCommitter) commit(seq int64, path string, write func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.open[seq] {
+		return write()
+	}
+	c.ready[seq] = pendingEntry{path: path, write: write}
+	return c.flush(seq)
+}
+
+// flush writes the waiting entries whose earlier numbers are all closed,
+// returning the error of the entry numbered own. Callers hold mu.
+func (c *orderedCommitter) flush(own int64) error {
+	var ownErr error
+	for len(c.open) > 0 {
+		first := int64(-1)
+		for seq := range c.open {
+			if first < 0 || seq < first {
+				first = seq
+			}
+		}
+		pending, ok := c.ready[first]
+		if !ok {
+			break
+		}
+		delete(c.open, first)
+		delete(c.ready, first)
+		err := pending.write()
+		if first == own {
+			ownErr = err
+		} else if err != nil {
+			slog.Warn("failed to write manifest entry", "path", pending.path, "sequence", first, "error", err)
+		}
+	}
+	return ownErr
+}
+
+// drain writes every waiting entry in order, giving up the numbers still
+// open. It runs on Close, when no file is processed anymore.
+func (c *orderedCommitter) drain() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for seq := range c.open {
+		if _, ok := c.ready[seq]; !ok {
+			delete(c.open, seq)
+		}
+	}
+	c.flush(0)
+}
+
+// appendEntry appends entry to the manifest, in sequence order under
+// ManifestStrictOrder. An entry committed to the database with the record
+// of its file is only written to the manifest files, and leaves pending
+// once it is, or is left to retryManifests when it fails to be.
+func (p *Processor) appendEntry(entry manifest.Entry, committed bool) error {
+	write := func() error {
+		if committed {
+			err := p.manifest.AppendCommitted(entry)
+			p.settleEntry(entry, err)
+			return err
+		}
+		return p.manifest.Append(entry)
+	}
+	if p.order == nil || entry.Sequence == 0 {
+		return write()
+	}
+	return p.order.commit(entry.Sequence, entry.SourcePath, write)
+}
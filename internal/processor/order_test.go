@@ -0,0 +1,197 @@
+package processor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+)
+
+func TestOrderedCommitter(t *testing.T) {
+	c := newOrderedCommitter()
+	var written []int64
+	write := func(seq int64) func() error {
+		return func() error {
+			written = append(written, seq)
+			return nil
+		}
+	}
+	for seq := int64(1); seq <= 4; seq++ {
+		c.opened(seq)
+	}
+
+	// 3 and 2 wait for 1, 4 is never committed
+	if err := c.commit(3, "c", write(3)); err != nil {
+		t.Fatalf("commit(3) error = %v", err)
+	}
+	if err := c.commit(2, "b", write(2)); err != nil {
+		t.Fatalf("commit(2) error = %v", err)
+	}
+	if len(written) != 0 {
+		t.Fatalf("written = %v before 1 was closed, want nothing", written)
+	}
+	c.release(1)
+	if !slices.Equal(written, []int64{2, 3}) {
+		t.Fatalf("written = %v after releasing 1, want [2 3]", written)
+	}
+
+	// Numbers released after their entry was committed stay written once
+	c.release(2)
+	c.opened(5)
+	fail := errors.New("disk full")
+	if err := c.commit(5, "e", func() error { return fail }); err != nil {
+		t.Fatalf("commit(5) behind 4 error = %v, want nil until written", err)
+	}
+	c.release(4)
+	if len(c.open) != 0 || len(c.ready) != 0 {
+		t.Errorf("open = %v, ready = %v, want both empty", c.open, c.ready)
+	}
+
+	c.opened(6)
+	if err := c.commit(6, "f", func() error { return fail }); !errors.Is(err, fail) {
+		t.Errorf("commit(6) error = %v, want the write error", err)
+	}
+	// Unknown numbers are written at once
+	if err := c.commit(9, "i", write(9)); err != nil || !slices.Equal(written, []int64{2, 3, 9}) {
+		t.Errorf("commit(9) = %v, written = %v, want it written at once", err, written)
+	}
+}
+
+func TestOrderedCommitter_Drain(t *testing.T) {
+	c := newOrderedCommitter()
+	var written []int64
+	for seq := int64(1); seq <= 3; seq++ {
+		c.opened(seq)
+	}
+	for _, seq := range []int64{3, 2} {
+		_ = c.commit(seq, "", func() error {
+			written = append(written, seq)
+			return nil
+		})
+	}
+	c.drain()
+	if !slices.Equal(written, []int64{2, 3}) {
+		t.Errorf("written = %v after drain, want [2 3]", written)
+	}
+}
+
+// ingestConcurrently ingests files through several copy workers, delaying
+// every other claim so files commit out of the order they were stamped in
+func ingestConcurrently(t *testing.T, env *testEnv) []string {
+	t.Helper()
+	env.cfg.HashWorkers = 4
+	env.cfg.CopyWorkers = 4
+	var claims atomic.Int64
+	env.processor.failpoints = map[string]func(){stageClaim: func() {
+		if claims.Add(1)%2 == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}}
+	files := writeSources(t, env.inputDir, "data", []int{64, 128, 256, 512, 1024, 2048, 64, 128, 256, 512, 1024, 2048})
+	env.processor.runPipeline(files)
+	return files
+}
+
+// manifestSequences returns the sequence numbers of every manifest file
+// under dir, in the order of their lines
+func manifestSequences(t *testing.T, dir string) [][]int64 {
+	t.Helper()
+	var files [][]int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".jsonl" {
+			return err
+		}
+		entries, err := manifest.ReadFile(path)
+		var seqs []int64
+		for _, e := range entries {
+			seqs = append(seqs, e.Sequence)
+		}
+		files = append(files, seqs)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read manifests: %v", err)
+	}
+	return files
+}
+
+func TestManifestSequence_Concurrent(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		name := "relaxed"
+		if strict {
+			name = "strict"
+		}
+		t.Run(name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			if strict {
+				env.processor.order = newOrderedCommitter()
+			}
+			files := ingestConcurrently(t, env)
+
+			reader := manifest.NewWriter(env.manifestsDir)
+			entries, err := reader.ManifestEntries(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatalf("ManifestEntries() error = %v", err)
+			}
+			if len(entries) != len(files) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(files))
+			}
+			for i := 1; i < len(entries); i++ {
+				if entries[i].Sequence <= entries[i-1].Sequence {
+					t.Fatalf("entries read back out of sequence order: %d after %d", entries[i].Sequence, entries[i-1].Sequence)
+				}
+			}
+
+			if !strict {
+				return
+			}
+			if len(env.processor.order.open) != 0 {
+				t.Errorf("numbers still open after the pass: %v", env.processor.order.open)
+			}
+			for _, seqs := range manifestSequences(t, env.manifestsDir) {
+				if !slices.IsSorted(seqs) {
+					t.Errorf("manifest lines out of sequence order: %v", seqs)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimStep_LostClaimReleasesSequence(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.order = newOrderedCommitter()
+	if err := env.processor.SetIdempotencyKey(`^(?P<key>\w+)_`, ""); err != nil {
+		t.Fatalf("SetIdempotencyKey() error = %v", err)
+	}
+	ingest(t, env, "order7_a.csv", "first rendering")
+
+	// Past dedup, as when the key is claimed concurrently: the claim loses
+	fc := newFileContext(t, env, "order7_b.csv", "second rendering")
+	fc.IdempotencyKey = "order7"
+	steps := builtins(env.processor, StepResolve, StepClaim, StepMove, StepManifest)
+	if err := env.processor.runSteps(steps, fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if fc.Claimed {
+		t.Fatal("claim should lose to the recorded key")
+	}
+
+	ingest(t, env, "other_a.csv", "unrelated")
+	if len(env.processor.order.open) != 0 {
+		t.Errorf("numbers still open: %v", env.processor.order.open)
+	}
+	found := false
+	for _, e := range readManifest(t, env.manifestsDir) {
+		found = found || filepath.Base(e.SourcePath) == "other_a.csv"
+	}
+	if !found {
+		t.Error("entry of the next file held behind the lost claim")
+	}
+}
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// panicLogInterval is how often a recovered panic of the same signature is
+// logged in full; repeats in between are counted into the next log
+const panicLogInterval = time.Minute
+
+// PanicError is a panic recovered while processing one file, which then
+// fails like any other error instead of taking the process down
+type PanicError struct {
+	Value any
+	// Stack is the goroutine stack at the panic
+	Stack []byte
+	// signature identifies the panic by its value and the code it came from
+	signature string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// newPanicError captures the recovered value r. Call it from the deferred
+// function that recovered.
+func newPanicError(r any) *PanicError {
+	return &PanicError{Value: r, Stack: debug.Stack(), signature: fmt.Sprintf("%v at %s", r, panicSite())}
+}
+
+// panicSite returns the function and line that panicked: the first frame
+// past the runtime's panic machinery
+func panicSite() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" || strings.HasPrefix(frame.Function, "runtime.panic") {
+			panicking = true
+		} else if panicking && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Sprintf("%s:%d", frame.Function, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// panicLog rate-limits the logs of recovered panics by signature
+type panicLog struct {
+	mu   sync.Mutex
+	seen map[string]*panicLogState
+}
+
+type panicLogState struct {
+	logged     time.Time
+	suppressed int
+}
+
+// allow reports whether a panic of signature is logged now, and how many of
+// its repeats were suppressed since it last was
+func (l *panicLog) allow(signature string, now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen == nil {
+		l.seen = make(map[string]*panicLogState)
+	}
+	s, ok := l.seen[signature]
+	if !ok {
+		l.seen[signature] = &panicLogState{logged: now}
+		return true, 0
+	}
+	if now.Sub(s.logged) < panicLogInterval {
+		s.suppressed++
+		return false, 0
+	}
+	suppressed := s.suppressed
+	s.logged, s.suppressed = now, 0
+	return true, suppressed
+}
+
+// guard runs fn and turns a panic into a *PanicError, so one bad file
+// cannot take a worker, and with it every other file, down
+func guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newPanicError(r)
+		}
+	}()
+	return fn()
+}
+
+// logPanic counts and logs err when it carries a recovered panic, at most
+// once per panicLogInterval for the same signature, and reports whether it
+// did. Other errors are left to the caller.
+func (p *Processor) logPanic(path string, err error, attrs ...any) bool {
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	p.stats.recordPanic()
+	if ok, repeats := p.panics.allow(perr.signature, time.Now()); ok {
+		attrs = append(attrs,
+			"path", path,
+			"panic", fmt.Sprint(perr.Value),
+			"signature", perr.signature,
+			"repeat_count", repeats,
+			"stack", string(perr.Stack),
+		)
+		slog.Error("recovered panic while processing file", attrs...)
+	}
+	return true
+}
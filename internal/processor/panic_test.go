@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// writeSourceFiles writes each name with its own content to the input
+// directory and returns their paths
+func writeSourceFiles(t *testing.T, env *testEnv, names ...string) []string {
+	t.Helper()
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(env.inputDir, name)
+		if err := os.WriteFile(path, []byte("content of "+name), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestGuard_PanickingStepFailsOnlyItsFile(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.CopyWorkers = 2
+
+	var nilMap map[string]int
+	steps := builtins(env.processor, StepDedup, StepResolve, StepClaim)
+	steps = append(steps, funcStep{name: "buggy", fn: func(fc *FileContext) error {
+		if fc.RelPath == "bad.csv" {
+			nilMap[fc.RelPath]++
+		}
+		return nil
+	}})
+	env.processor.defaultSteps = append(steps, builtins(env.processor, StepMove, StepManifest)...)
+
+	files := writeSourceFiles(t, env, "a.csv", "bad.csv", "b.csv")
+	env.processor.runPipeline(files)
+
+	if n := countRecords(t, env.store); n != 2 {
+		t.Errorf("got %d database records, want the 2 good files with the bad claim released", n)
+	}
+	stats := env.processor.Stats()
+	if stats.Totals.Ingested != 2 || stats.Totals.Failed != 1 || stats.Panics != 1 {
+		t.Errorf("stats = %+v, want 2 ingested, 1 failed and 1 panic", stats)
+	}
+
+	var failed *Event
+	for _, e := range env.processor.Recent() {
+		if e.Outcome == status.Failed {
+			failed = &e
+		}
+	}
+	if failed == nil || !strings.Contains(failed.Path, "bad.csv") {
+		t.Fatalf("failed event = %+v, want one for bad.csv", failed)
+	}
+	if !strings.Contains(failed.Error, "assignment to entry in nil map") || !strings.Contains(failed.Error, "panic_test.go") {
+		t.Errorf("failed event error = %q, want the panic and its stack", failed.Error)
+	}
+	if _, err := os.Stat(filepath.Join(env.inputDir, "bad.csv")); err != nil {
+		t.Errorf("source of the panicking file should stay for a retry: %v", err)
+	}
+}
+
+func TestGuard_PanicInHashStage(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.HashWorkers = 1
+
+	files := writeSourceFiles(t, env, "a.csv", "b.csv", "c.csv")
+	panicked := false
+	env.processor.failpoints = map[string]func(){stageHash: func() {
+		if !panicked {
+			panicked = true
+			panic("hash stage bug")
+		}
+	}}
+	env.processor.runPipeline(files)
+
+	stats := env.processor.Stats()
+	if stats.Totals.Ingested != 2 || stats.Totals.Failed != 1 || stats.Panics != 1 {
+		t.Errorf("stats = %+v, want 2 ingested, 1 failed and 1 panic", stats)
+	}
+
+	// The worker survived: the file is ingested on the next pass
+	env.processor.runPipeline(files[:1])
+	sum := sha256.Sum256([]byte("content of a.csv"))
+	if rec, err := env.store.FindBySHA256(hex.EncodeToString(sum[:])); err != nil || rec == nil {
+		t.Errorf("a.csv not ingested after the panic: %v", err)
+	}
+}
+
+func TestPanicLog_RateLimitsSignature(t *testing.T) {
+	var l panicLog
+	now := time.Now()
+
+	if ok, _ := l.allow("boom at f:1", now); !ok {
+		t.Fatal("first panic not logged")
+	}
+	for range 3 {
+		if ok, _ := l.allow("boom at f:1", now.Add(time.Second)); ok {
+			t.Error("repeat within the interval logged")
+		}
+	}
+	if ok, _ := l.allow("other at g:2", now.Add(time.Second)); !ok {
+		t.Error("panic of another signature not logged")
+	}
+	ok, repeats := l.allow("boom at f:1", now.Add(panicLogInterval))
+	if !ok || repeats != 3 {
+		t.Errorf("allow after the interval = %v, %d; want true, 3", ok, repeats)
+	}
+}
+
+func TestNewPanicError_Signature(t *testing.T) {
+	perr := func() (perr *PanicError) {
+		defer func() { perr = newPanicError(recover()) }()
+		var m map[string]int
+		m["x"] = 1
+		return nil
+	}()
+
+	if !strings.Contains(perr.signature, "TestNewPanicError_Signature") {
+		t.Errorf("signature = %q, want the panicking function", perr.signature)
+	}
+	if !strings.Contains(perr.Error(), "nil map") {
+		t.Errorf("Error() = %q, want the panic value", perr.Error())
+	}
+}
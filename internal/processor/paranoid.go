@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// confirmDuplicate compares fc with the warehouse copy of original before
+// skipping it. Matches that cannot be compared, because the copy is gone or
+// was compressed on ingest, fall back to the hash. Differing content means
+// the warehouse copy is corrupt or the hashes collide: the original is marked
+// suspect and the file continues through the pipeline to be ingested under a
+// disambiguated name.
+func (p *Processor) confirmDuplicate(fc *FileContext, original *storage.File) error {
+	if strings.HasSuffix(original.DestPath, compressedExt) && !strings.HasSuffix(fc.RelPath, compressedExt) {
+		slog.Debug("original was compressed on ingest, trusting hash", "path", fc.SourcePath, "original_destination", original.DestPath)
+		p.skipDuplicate(fc, original, manifest.DedupHash)
+		return nil
+	}
+	if _, err := os.Stat(original.DestPath); os.IsNotExist(err) {
+		slog.Debug("original no longer in warehouse, trusting hash", "path", fc.SourcePath, "original_destination", original.DestPath)
+		p.skipDuplicate(fc, original, manifest.DedupHash)
+		return nil
+	}
+
+	equal, err := fileops.FilesEqualWithOptions(fc.ContentPath, original.DestPath, fileops.CompareOptions{
+		BytesPerSecond: p.cfg.ParanoidDedupRate,
+	})
+	if err != nil {
+		return fmt.Errorf("compare %s with %s: %w", fc.SourcePath, original.DestPath, err)
+	}
+	if equal {
+		p.skipDuplicate(fc, original, manifest.DedupBytes)
+		return nil
+	}
+
+	slog.Error("hash match with different content, marking original suspect as its warehouse copy is corrupt or the hashes collide",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"size", fc.Size(),
+		"original", original.Path,
+		"original_destination", original.DestPath,
+		"original_processed_at", original.ProcessedAt,
+		"original_size", original.Size,
+	)
+	if err := p.storage.MarkSuspect(original); err != nil {
+		return err
+	}
+	original.Status = status.Suspect
+	fc.Suspect = original
+	return nil
+}
+
+// avoidSuspect renames dst when it would replace the warehouse copy of a
+// suspect record, which is kept for inspection. The new name carries the
+// suspect's record ID, e.g. data.collision-7.csv. A retry after the
+// comparison finds the suspect by its destination.
+func (p *Processor) avoidSuspect(fc *FileContext, dst resolvedPath) (resolvedPath, error) {
+	if !p.cfg.ParanoidDedup {
+		return dst, nil
+	}
+	suspect := fc.Suspect
+	if suspect == nil {
+		rec, err := p.storage.FindByDestPath(dst.path)
+		if err != nil {
+			return dst, err
+		}
+		if rec == nil || rec.Status != status.Suspect {
+			return dst, nil
+		}
+		suspect = rec
+	}
+	if suspect.DestPath != dst.path {
+		return dst, nil
+	}
+
+	relPath, err := filepath.Rel(p.cfg.Destination, dst.path)
+	if err != nil {
+		return dst, err
+	}
+	renamed, err := p.resolveVersion(relPath, fmt.Sprintf("collision-%d", suspect.ID))
+	if err != nil {
+		return dst, err
+	}
+	slog.Warn("ingesting under a disambiguated name next to suspect original",
+		"path", fc.SourcePath,
+		"destination", renamed.path,
+		"suspect_destination", suspect.DestPath,
+	)
+	return renamed, nil
+}
@@ -0,0 +1,158 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// ingest writes name with content to the input directory and processes it
+func ingest(t *testing.T, env *testEnv, name, content string) {
+	t.Helper()
+	src := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile(%s) error = %v", name, err)
+	}
+}
+
+// duplicateEntries returns the duplicate entries of the manifests
+func duplicateEntries(t *testing.T, env *testEnv) []manifest.Entry {
+	t.Helper()
+	var dups []manifest.Entry
+	for _, e := range readManifest(t, env.manifestsDir) {
+		if e.Status == status.Duplicate {
+			dups = append(dups, e)
+		}
+	}
+	return dups
+}
+
+func TestParanoidDedup_ByteConfirmed(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ParanoidDedup = true
+
+	ingest(t, env, "data.csv", "a,b\n1,2\n")
+	ingest(t, env, "copy.csv", "a,b\n1,2\n")
+
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "copy.csv")); !os.IsNotExist(err) {
+		t.Error("confirmed duplicate should not be ingested")
+	}
+	dups := duplicateEntries(t, env)
+	if len(dups) != 1 || dups[0].Dedup != manifest.DedupBytes {
+		t.Fatalf("duplicate entries = %+v, want one byte-confirmed", dups)
+	}
+	if want := filepath.Join(env.warehouseDir, "data.csv"); dups[0].DestPath != want {
+		t.Errorf("duplicate DestPath = %s, want the original %s", dups[0].DestPath, want)
+	}
+}
+
+func TestParanoidDedup_HashOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		paranoid bool
+		prepare  func(t *testing.T, env *testEnv)
+	}{
+		{"paranoid off", false, func(*testing.T, *testEnv) {}},
+		{"original gone", true, func(t *testing.T, env *testEnv) {
+			if err := os.Remove(filepath.Join(env.warehouseDir, "data.csv")); err != nil {
+				t.Fatalf("failed to remove original: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.ParanoidDedup = tt.paranoid
+
+			ingest(t, env, "data.csv", "a,b\n")
+			tt.prepare(t, env)
+			ingest(t, env, "copy.csv", "a,b\n")
+
+			dups := duplicateEntries(t, env)
+			if len(dups) != 1 || dups[0].Dedup != manifest.DedupHash {
+				t.Errorf("duplicate entries = %+v, want one hash-matched", dups)
+			}
+		})
+	}
+}
+
+func TestParanoidDedup_Mismatch(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ParanoidDedup = true
+
+	content := "a,b\n1,2\n"
+	ingest(t, env, "data.csv", content)
+	original, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "data.csv"))
+	if err != nil || original == nil {
+		t.Fatalf("original record missing: %v", err)
+	}
+
+	// Corrupt the warehouse copy without changing its size
+	corrupt := "a,b\n1,3\n"
+	if err := os.WriteFile(original.DestPath, []byte(corrupt), 0o644); err != nil {
+		t.Fatalf("failed to corrupt original: %v", err)
+	}
+
+	ingest(t, env, "data.csv", content)
+
+	renamed := filepath.Join(env.warehouseDir, fmt.Sprintf("data.collision-%d.csv", original.ID))
+	data, err := os.ReadFile(renamed)
+	if err != nil {
+		t.Fatalf("new file not ingested under a disambiguated name: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("disambiguated file = %q, want %q", data, content)
+	}
+	if data, _ := os.ReadFile(original.DestPath); string(data) != corrupt {
+		t.Error("suspect original should be left in place")
+	}
+
+	suspects, err := env.store.ListFiles(storage.FileFilter{Status: status.Suspect})
+	if err != nil || len(suspects) != 1 || suspects[0].ID != original.ID {
+		t.Fatalf("suspects = %+v, %v, want the original", suspects, err)
+	}
+	rec, err := env.store.FindBySHA256(original.SHA256)
+	if err != nil || rec == nil || rec.DestPath != renamed {
+		t.Errorf("hash should now point at the verified copy, got %+v, %v", rec, err)
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 0 {
+		t.Errorf("mismatch should not be recorded as a duplicate: %+v", dups)
+	}
+}
+
+func TestAvoidSuspect_Retry(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ParanoidDedup = true
+
+	ingest(t, env, "data.csv", "a,b\n")
+	original, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "data.csv"))
+	if err != nil || original == nil {
+		t.Fatalf("original record missing: %v", err)
+	}
+	if err := env.store.MarkSuspect(original); err != nil {
+		t.Fatalf("MarkSuspect() error = %v", err)
+	}
+
+	// A retry no longer sees the hash match but still avoids the suspect copy
+	fc := newFileContext(t, env, "data.csv", "a,b\n")
+	if err := env.processor.runSteps(builtins(env.processor, DefaultSteps...), fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	want := filepath.Join(env.warehouseDir, fmt.Sprintf("data.collision-%d.csv", original.ID))
+	if fc.Dest.path != want {
+		t.Errorf("destination = %s, want %s", fc.Dest.path, want)
+	}
+}
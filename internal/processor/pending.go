@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+const (
+	// ManifestRetryInterval is how often RetryManifests looks for pending
+	// entries it was not woken for
+	ManifestRetryInterval = 30 * time.Second
+	// ManifestMaxBackoff caps the wait between retries while the manifest
+	// files cannot be written
+	ManifestMaxBackoff = 5 * time.Minute
+)
+
+// manifestRetryBatch is how many ready entries a retry pass reads at a time
+const manifestRetryBatch = 100
+
+// settleEntry records the outcome of appending the committed entry to the
+// manifest files: the pending entry is cleared once it is written and left
+// to RetryManifests, as it stands now, when err says it is not
+func (p *Processor) settleEntry(entry manifest.Entry, err error) {
+	if !p.manifest.WritesFiles() {
+		return
+	}
+	if err == nil {
+		if err := p.storage.ClearManifestEntry(entry.SHA256); err != nil {
+			slog.Warn("failed to clear pending manifest entry", "path", entry.SourcePath, "sha256", entry.SHA256, "error", err)
+		}
+		return
+	}
+	if _, err := p.storage.ReleaseManifestEntry(entry.SHA256, entry, err.Error()); err != nil {
+		slog.Error("failed to keep manifest entry for retry", "path", entry.SourcePath, "sha256", entry.SHA256, "error", err)
+		return
+	}
+	select {
+	case p.entriesReady <- struct{}{}:
+	default:
+	}
+}
+
+// RecoverManifests settles the pending manifest entries a crash left held
+// between the claim of a file and its manifest append: those of files that
+// reached the warehouse are made ready for RetryManifests and those whose
+// record is gone are cleared. Files still missing from the warehouse keep
+// theirs held.
+func (p *Processor) RecoverManifests() error {
+	pending, err := p.storage.PendingManifestEntries()
+	if err != nil {
+		return err
+	}
+	released := 0
+	for _, row := range pending {
+		if row.Ready {
+			continue
+		}
+		rec, err := p.storage.FindBySHA256(row.SHA256)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec == nil:
+			err = p.storage.ClearManifestEntry(row.SHA256)
+		case fileExists(rec.DestPath):
+			released++
+			_, err = p.storage.ReleaseManifestEntry(row.SHA256, manifest.Entry{}, "interrupted before the manifest append")
+		default:
+			slog.Warn("manifest entry held for a file missing from the warehouse",
+				"sha256", row.SHA256,
+				"destination", rec.DestPath,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if released > 0 {
+		slog.Info("released manifest entries held by an interrupted run", "count", released)
+	}
+	return nil
+}
+
+// RetryManifests appends the pending entries ready for a retry until ctx is
+// done, so the manifest files catch up with the database after a failed
+// append or an outage of their volume. While appends fail it backs off up
+// to ManifestMaxBackoff. Retried entries are written as they come, outside
+// ManifestStrictOrder.
+func (p *Processor) RetryManifests(ctx context.Context) {
+	var backoff time.Duration
+	for {
+		supervisor.Beat(ctx)
+		if _, err := p.retryManifests(); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			backoff = min(max(2*backoff, time.Second), ManifestMaxBackoff)
+			slog.Warn("failed to append pending manifest entries", "retry_in", backoff, "error", err)
+		} else {
+			backoff = 0
+		}
+
+		timer := time.NewTimer(max(backoff, ManifestRetryInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-p.entriesReady:
+			timer.Stop()
+			if backoff > 0 {
+				// Woken while backing off: wait the backoff out
+				p.sleep(ctx, backoff)
+			}
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done
+func (p *Processor) sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// retryManifests appends the ready pending entries oldest first, returning
+// how many it wrote. An entry already in the manifest files, as after a
+// crash between the append and the clear, is cleared without being written
+// again. The first failure ends the pass.
+func (p *Processor) retryManifests() (int, error) {
+	written := 0
+	for {
+		rows, err := p.storage.ReadyManifestEntries(manifestRetryBatch)
+		if err != nil || len(rows) == 0 {
+			return written, err
+		}
+		for _, row := range rows {
+			entry, err := row.ManifestEntry()
+			if err != nil {
+				// Never readable, so never retried
+				slog.Error("dropping unreadable pending manifest entry", "sha256", row.SHA256, "error", err)
+				if err := p.storage.ClearManifestEntry(row.SHA256); err != nil {
+					return written, err
+				}
+				continue
+			}
+			if !p.entryWritten(entry) {
+				if err := p.manifest.AppendCommitted(entry); err != nil {
+					if ferr := p.storage.FailManifestEntry(row.ID, err.Error()); ferr != nil {
+						slog.Warn("failed to record manifest retry", "sha256", row.SHA256, "error", ferr)
+					}
+					return written, err
+				}
+				written++
+				slog.Info("appended pending manifest entry", "path", entry.SourcePath, "sha256", entry.SHA256, "attempts", row.Attempts+1)
+			}
+			if err := p.storage.ClearManifestEntry(row.SHA256); err != nil {
+				return written, err
+			}
+		}
+	}
+}
+
+// entryWritten reports whether the manifest files already hold the
+// ingested entry of the same content and ProcessedAt as entry. Failing to
+// read them counts as not written.
+func (p *Processor) entryWritten(entry manifest.Entry) bool {
+	entries, err := p.manifest.ManifestEntries(entry.ProcessedAt.Add(-time.Second), entry.ProcessedAt.Add(time.Second))
+	if err != nil {
+		slog.Debug("failed to read manifest before retrying its entry", "sha256", entry.SHA256, "error", err)
+		return false
+	}
+	for _, e := range entries {
+		if e.SHA256 == entry.SHA256 && e.ProcessedAt.Equal(entry.ProcessedAt) && e.Status == status.Ingested {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// pendingEntries returns the pending manifest entries of env
+func pendingEntries(t *testing.T, env *testEnv) []storage.PendingManifestEntry {
+	t.Helper()
+	rows, err := env.store.PendingManifestEntries()
+	if err != nil {
+		t.Fatalf("PendingManifestEntries() error = %v", err)
+	}
+	return rows
+}
+
+// ingestedLines counts the ingested manifest lines for sha256 under dir
+func ingestedLines(t *testing.T, dir, sha256 string) int {
+	t.Helper()
+	n := 0
+	for _, e := range readManifest(t, dir) {
+		if e.SHA256 == sha256 && e.Status == status.Ingested {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPendingManifest_ClearedOnAppend(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	files := writeSources(t, env.inputDir, "data", []int{64, 128})
+	env.processor.runPipeline(files)
+
+	if rows := pendingEntries(t, env); len(rows) != 0 {
+		t.Errorf("%d entries pending after appends that succeeded, want none", len(rows))
+	}
+	if entries := readManifest(t, env.manifestsDir); len(entries) != 2 {
+		t.Errorf("manifest has %d entries, want 2", len(entries))
+	}
+}
+
+func TestPendingManifest_RetriedAfterFailedAppend(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// The manifests volume cannot take the entry: a file stands where the
+	// directory of the year should be created
+	blocker := filepath.Join(env.manifestsDir, time.Now().Format("2006"))
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatalf("failed to block the manifests: %v", err)
+	}
+	src := filepath.Join(env.inputDir, "a.csv")
+	if err := os.WriteFile(src, []byte("1,alice\n"), 0o644); err != nil {
+		t.Fatalf("failed to write a.csv: %v", err)
+	}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v, want the file ingested despite the manifest", err)
+	}
+	rec, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "a.csv"))
+	if err != nil || rec == nil {
+		t.Fatalf("FindByDestPath() = %v, %v, want the record committed", rec, err)
+	}
+
+	rows := pendingEntries(t, env)
+	if len(rows) != 1 || !rows[0].Ready || rows[0].SHA256 != rec.SHA256 || rows[0].LastError == "" {
+		t.Fatalf("pending = %+v, want the entry of a.csv ready with its error", rows)
+	}
+	// Retries fail while the volume is out
+	if written, err := env.processor.retryManifests(); err == nil || written != 0 {
+		t.Fatalf("retryManifests() = %d, %v while blocked, want an error", written, err)
+	}
+	if rows := pendingEntries(t, env); len(rows) != 1 || rows[0].Attempts != 1 {
+		t.Fatalf("pending = %+v after a failed retry, want one attempt recorded", rows)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		env.processor.RetryManifests(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pendingEntries(t, env)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending entry never appended")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := ingestedLines(t, env.manifestsDir, rec.SHA256); n != 1 {
+		t.Errorf("manifest has %d lines for a.csv, want exactly 1", n)
+	}
+}
+
+func TestRecoverManifests(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	files := writeSources(t, env.inputDir, "data", []int{64, 128})
+	env.processor.runPipeline(files)
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 2 {
+		t.Fatalf("manifest has %d entries, want 2", len(entries))
+	}
+
+	// A crash left entries held: one was appended before the crash, one
+	// belongs to a claim whose record is gone
+	appended := entries[0]
+	for _, e := range []manifest.Entry{appended, {SHA256: "gone", ProcessedAt: time.Now()}} {
+		if err := env.store.HoldManifestEntry(e); err != nil {
+			t.Fatalf("HoldManifestEntry() error = %v", err)
+		}
+	}
+	if err := env.processor.RecoverManifests(); err != nil {
+		t.Fatalf("RecoverManifests() error = %v", err)
+	}
+	rows := pendingEntries(t, env)
+	if len(rows) != 1 || rows[0].SHA256 != appended.SHA256 || !rows[0].Ready {
+		t.Fatalf("pending = %+v, want only the entry of the file in the warehouse, ready", rows)
+	}
+
+	if written, err := env.processor.retryManifests(); err != nil || written != 0 {
+		t.Errorf("retryManifests() = %d, %v, want the appended entry cleared, not written again", written, err)
+	}
+	if n := ingestedLines(t, env.manifestsDir, appended.SHA256); n != 1 {
+		t.Errorf("manifest has %d lines for %s, want 1", n, appended.SHA256)
+	}
+	if rows := pendingEntries(t, env); len(rows) != 0 {
+		t.Errorf("%d entries pending after the retry, want none", len(rows))
+	}
+}
@@ -0,0 +1,330 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Stages of the file pipeline. Hashing is CPU-bound and copying is
+// I/O-bound, so each stage has its own worker limit.
+const (
+	StageHash = "hash"
+	StageCopy = "copy"
+)
+
+// hashedFile is a source that passed the hash stage and waits for a copy
+// worker to claim and move it
+type hashedFile struct {
+	path string
+	info os.FileInfo
+	// allocated is the bytes the source occupies on disk
+	allocated int64
+	hash      string
+	// started is when the hash stage picked the file up
+	started time.Time
+	// staged is the copy hashed instead of the source in copy-first mode
+	staged stagedCopy
+	// release releases the warehouse space reserved for the file
+	release func()
+	// url is where a file of a URL list was downloaded from into staged
+	url string
+	// lifted is set for a file let in under a lifted shrink hold
+	lifted bool
+	// timing is the breakdown of a sampled file, nil for the others
+	timing *timing
+}
+
+// dispatchedFile is a source dispatched to the hash workers
+type dispatchedFile struct {
+	path   string
+	timing *timing
+}
+
+// StageStats reports the load of one pipeline stage
+type StageStats struct {
+	Workers int `json:"workers"`
+	// QueueDepth counts files waiting for a worker of this stage
+	QueueDepth int64 `json:"queue_depth"`
+	Busy       int64 `json:"busy"`
+	Processed  int64 `json:"processed"`
+	// Utilization is the fraction of worker time spent busy during the
+	// current or last pass
+	Utilization float64 `json:"utilization"`
+}
+
+// stageMetrics tracks queue depth and worker utilization of a stage
+type stageMetrics struct {
+	mu        sync.Mutex
+	workers   int
+	queued    int64
+	busy      int64
+	processed int64
+	busyTime  time.Duration
+	start     time.Time
+	end       time.Time
+}
+
+// begin resets the metrics for a pass with the given workers and queue
+func (s *stageMetrics) begin(workers int, queued int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers = workers
+	s.queued = queued
+	s.busy = 0
+	s.processed = 0
+	s.busyTime = 0
+	s.start = time.Now()
+	s.end = time.Time{}
+}
+
+func (s *stageMetrics) enqueue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued++
+}
+
+// acquire moves a file from the queue to a worker and returns when it started
+func (s *stageMetrics) acquire() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued--
+	s.busy++
+	return time.Now()
+}
+
+func (s *stageMetrics) release(started time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy--
+	s.processed++
+	s.busyTime += time.Since(started)
+}
+
+func (s *stageMetrics) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.end = time.Now()
+}
+
+func (s *stageMetrics) snapshot() StageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := StageStats{
+		Workers:    s.workers,
+		QueueDepth: s.queued,
+		Busy:       s.busy,
+		Processed:  s.processed,
+	}
+	if s.start.IsZero() || s.workers == 0 {
+		return stats
+	}
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if elapsed := end.Sub(s.start); elapsed > 0 {
+		stats.Utilization = min(float64(s.busyTime)/(float64(elapsed)*float64(s.workers)), 1)
+	}
+	return stats
+}
+
+// PipelineStats returns the metrics of each pipeline stage keyed by stage name
+func (p *Processor) PipelineStats() map[string]StageStats {
+	return map[string]StageStats{
+		StageHash: p.hashPool.snapshot(),
+		StageCopy: p.copyPool.snapshot(),
+	}
+}
+
+// workerCount returns the workers of a stage: its own limit, falling back to
+// the shared concurrency, at least one and no more than there are files
+func workerCount(limit, concurrency, files int) int {
+	if limit < 1 {
+		limit = concurrency
+	}
+	return max(1, min(limit, files))
+}
+
+// runPipeline hashes files with HashWorkers workers and hands them to
+// CopyWorkers workers that claim and move them. The channel between the
+// stages holds one file per copy worker, so a slow disk blocks the hash
+// workers instead of letting hashed files pile up. Files are dispatched
+// by priority class, then round-robin across tenants, at most
+// TenantMaxWorkers of a tenant at a time. With priority classes, files
+// ready since the pass began join it when they outrank its lowest
+// priority, so urgent files do not wait for a long bulk pass to end.
+func (p *Processor) runPipeline(files []string) {
+	hashWorkers := workerCount(p.cfg.HashWorkers, p.cfg.Concurrency, len(files))
+	copyWorkers := workerCount(p.cfg.CopyWorkers, p.cfg.Concurrency, len(files))
+
+	p.hashPool.begin(hashWorkers, int64(len(files)))
+	p.copyPool.begin(copyWorkers, 0)
+	defer p.hashPool.finish()
+	defer p.copyPool.finish()
+
+	var order dispatchOrder
+	if len(p.priorities) > 0 {
+		slots := hashWorkers + copyWorkers
+		order = dispatchOrder{
+			classify: p.priorityOf,
+			ageOf:    modTime,
+			slots:    slots,
+			reserved: reservedSlots(slots, p.cfg.PriorityReserve),
+		}
+	}
+	sched := newOrderedScheduler(files, func(path string) string { return sourceOf(p.cfg.Path, path) }, p.cfg.TenantMaxWorkers, order)
+	p.sched.Store(sched)
+
+	sources := make(chan dispatchedFile, hashWorkers)
+	hashed := make(chan hashedFile, copyWorkers)
+
+	var hashWG sync.WaitGroup
+	for i := range hashWorkers {
+		hashWG.Go(func() {
+			for q := range sources {
+				f := q.path
+				slog.Debug("worker hashing file", "worker", i, "path", f)
+				started := p.hashPool.acquire()
+				q.timing.lap(timeQueue)
+				var h hashedFile
+				err := guard(func() (err error) {
+					h, err = p.hashSource(f)
+					return err
+				})
+				p.hashPool.release(started)
+				if err != nil {
+					p.reportFailure(StageHash, i, f, err)
+					sched.done(f)
+					continue
+				}
+				q.timing.lap(timeHash)
+				h.timing = q.timing
+				p.copyPool.enqueue()
+				hashed <- h
+			}
+		})
+	}
+
+	var copyWG sync.WaitGroup
+	for i := range copyWorkers {
+		copyWG.Go(func() {
+			defer p.flushOnPanic(i)
+			for h := range hashed {
+				slog.Debug("worker processing file", "worker", i, "path", h.path)
+				started := p.copyPool.acquire()
+				h.timing.lap(timeQueue)
+				err := guard(func() error { return p.ingestHashed(h) })
+				p.copyPool.release(started)
+				if err != nil {
+					p.reportFailure(StageCopy, i, h.path, err)
+				}
+				sched.done(h.path)
+			}
+		})
+	}
+
+	var (
+		releases   []func()
+		lastRefill = time.Now()
+		// queued is when each file joined the pass since it began
+		queued = make(map[string]time.Time)
+		began  = lastRefill
+	)
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+	for {
+		if len(p.priorities) > 0 && time.Since(lastRefill) >= refillInterval {
+			lastRefill = time.Now()
+			more, release := p.claimFiles(p.outranking(sched.lowest()))
+			releases = append(releases, release)
+			for _, f := range more {
+				p.hashPool.enqueue()
+				queued[f] = lastRefill
+			}
+			sched.add(more)
+		}
+		f, ok := sched.next()
+		if !ok {
+			break
+		}
+		at, refilled := queued[f]
+		if !refilled {
+			at = began
+		}
+		sources <- dispatchedFile{path: f, timing: p.newTiming(at)}
+	}
+	close(sources)
+	hashWG.Wait()
+	close(hashed)
+	copyWG.Wait()
+}
+
+// refillInterval is how often a pass looks for ready files outranking it
+const refillInterval = time.Second
+
+// outranking returns the files ready to process whose priority is above
+// lowest
+func (p *Processor) outranking(lowest int) []string {
+	var files []string
+	for _, f := range p.withoutPaused(p.watcher.GetFilesToProcess()) {
+		if p.priorityOf(f).Priority > lowest {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// modTime returns when the file at path was last written, zero when it
+// cannot be stat'ed
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// flushOnPanic syncs the manifest before a panicking copy worker takes the
+// process down, then lets the panic continue
+func (p *Processor) flushOnPanic(workerID int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	slog.Error("copy worker panicked, flushing manifest", "worker", workerID, "panic", r)
+	if err := p.manifest.Flush(); err != nil {
+		slog.Error("failed to flush manifest after panic", "error", err)
+	}
+	panic(r)
+}
+
+// reportFailure records and logs a file a pipeline worker failed to process
+func (p *Processor) reportFailure(stage string, workerID int, path string, err error) {
+	switch {
+	case p.logPanic(path, err, "stage", stage, "worker", workerID):
+		p.record(path, "", status.Failed, err)
+	case errors.Is(err, ErrSourceVanished):
+		// Already rolled back and logged as a warning
+	case errors.Is(err, errStormRejected):
+		// Already recorded as a duplicate
+	case errors.Is(err, errAlreadyIngested):
+		// Left in place by an earlier run
+	case errors.Is(err, errHeld):
+		// Waiting for an operator, alerted when held
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		slog.Info("processing interrupted by shutdown", "stage", stage, "worker", workerID, "path", path)
+	default:
+		p.record(path, "", status.Failed, err)
+		slog.Error("failed to process file", "stage", stage, "worker", workerID, "path", path, "error", err)
+	}
+}
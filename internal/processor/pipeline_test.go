@@ -0,0 +1,267 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		concurrency int
+		files       int
+		want        int
+	}{
+		{"own limit", 4, 1, 10, 4},
+		{"falls back to concurrency", 0, 3, 10, 3},
+		{"capped by files", 8, 1, 2, 2},
+		{"at least one", 0, 0, 10, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workerCount(tt.limit, tt.concurrency, tt.files); got != tt.want {
+				t.Errorf("workerCount(%d, %d, %d) = %d, want %d", tt.limit, tt.concurrency, tt.files, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeSources(t testing.TB, dir, prefix string, sizes []int) []string {
+	t.Helper()
+
+	files := make([]string, 0, len(sizes))
+	for i, size := range sizes {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%03d.bin", prefix, i))
+		data := make([]byte, size)
+		copy(data, fmt.Sprintf("%s %d", prefix, i))
+		for j := 32; j < size; j++ {
+			data[j] = byte(j * (i + 1))
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+func TestRunPipeline_SeparateWorkers(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.HashWorkers = 3
+	env.cfg.CopyWorkers = 2
+
+	files := writeSources(t, env.inputDir, "data", []int{64, 128, 256, 512, 1024, 2048})
+	env.processor.runPipeline(files)
+
+	for _, f := range files {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("source %s should have been moved", f)
+		}
+		dst := filepath.Join(env.warehouseDir, filepath.Base(f))
+		if _, err := os.Stat(dst); err != nil {
+			t.Errorf("destination %s missing: %v", dst, err)
+		}
+	}
+
+	stats := env.processor.PipelineStats()
+	if hash := stats[StageHash]; hash.Workers != 3 || hash.Processed != 6 || hash.QueueDepth != 0 || hash.Busy != 0 {
+		t.Errorf("hash stage stats = %+v", hash)
+	}
+	if cp := stats[StageCopy]; cp.Workers != 2 || cp.Processed != 6 || cp.QueueDepth != 0 || cp.Busy != 0 {
+		t.Errorf("copy stage stats = %+v", cp)
+	}
+	if u := stats[StageHash].Utilization; u <= 0 || u > 1 {
+		t.Errorf("hash utilization = %v, want within (0, 1]", u)
+	}
+}
+
+func TestRunPipeline_Backpressure(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.HashWorkers = 1
+	env.cfg.CopyWorkers = 1
+
+	// Hold the only copy worker after it claims its first file
+	release := make(chan struct{})
+	env.processor.failpoints = map[string]func(){stageClaim: func() { <-release }}
+
+	files := writeSources(t, env.inputDir, "data", []int{64, 64, 64, 64, 64, 64, 64, 64})
+	done := make(chan struct{})
+	go func() {
+		env.processor.runPipeline(files)
+		close(done)
+	}()
+
+	// One file is held by the copy worker, one waits in the channel and one
+	// is blocked in the hand-off; hashing must stop there
+	var stats map[string]StageStats
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stats = env.processor.PipelineStats()
+		if stats[StageHash].Processed >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stats = env.processor.PipelineStats()
+
+	if got := stats[StageHash].Processed; got != 3 {
+		t.Errorf("hashed %d files while copying was blocked, want 3", got)
+	}
+	if got := stats[StageHash].QueueDepth; got != int64(len(files)-3) {
+		t.Errorf("hash queue depth = %d, want %d", got, len(files)-3)
+	}
+	if got := stats[StageCopy].Busy; got != 1 {
+		t.Errorf("copy busy = %d, want 1", got)
+	}
+	if got := stats[StageCopy].QueueDepth; got != 2 {
+		t.Errorf("copy queue depth = %d, want 2", got)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pipeline did not finish")
+	}
+	if got := env.processor.PipelineStats()[StageCopy].Processed; got != int64(len(files)) {
+		t.Errorf("copied %d files, want %d", got, len(files))
+	}
+}
+
+func TestRunPipeline_Concurrency(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// Without stage limits both fall back to the shared concurrency
+	env.cfg.Concurrency = 4
+	env.cfg.HashWorkers = 0
+	env.cfg.CopyWorkers = 0
+
+	// Each claim waits until all four copy workers hold a file, or gives up
+	var inFlight, peak atomic.Int64
+	env.processor.failpoints = map[string]func(){stageClaim: func() {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			if old := peak.Load(); n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for peak.Load() < 4 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}}
+
+	files := writeSources(t, env.inputDir, "data", []int{64, 64, 64, 64, 64, 64, 64, 64})
+	env.processor.runPipeline(files)
+
+	if got := peak.Load(); got != 4 {
+		t.Errorf("%d files claimed at once, want the concurrency of 4", got)
+	}
+	if got := env.processor.PipelineStats()[StageCopy].Processed; got != int64(len(files)) {
+		t.Errorf("copied %d files, want %d", got, len(files))
+	}
+}
+
+func TestRunPipeline_SingleWorkerKeepsOrder(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.Concurrency = 1
+	env.cfg.HashWorkers = 0
+	env.cfg.CopyWorkers = 0
+
+	var c collector
+	env.processor.Subscribe(c.add)
+
+	files := writeSources(t, env.inputDir, "data", []int{2048, 64, 1024, 128, 512, 256})
+	env.processor.runPipeline(files)
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var got []string
+	for _, e := range c.get() {
+		if e.Kind == EventFileIngested {
+			got = append(got, e.Path)
+		}
+	}
+	if !slices.Equal(got, files) {
+		t.Errorf("ingested %v, want the pass order %v", got, files)
+	}
+}
+
+// BenchmarkRunPipeline ingests a directory of mixed-size files, a few large
+// ones among many small ones, with different hash and copy worker limits
+func BenchmarkRunPipeline(b *testing.B) {
+	var sizes []int
+	for range 4 {
+		sizes = append(sizes, 8<<20)
+	}
+	for range 32 {
+		sizes = append(sizes, 64<<10)
+	}
+	for range 64 {
+		sizes = append(sizes, 1<<10)
+	}
+	var total int64
+	for _, size := range sizes {
+		total += int64(size)
+	}
+
+	// Each ingested file logs at info level; keep the output readable
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	for _, workers := range []struct{ hash, copy int }{{1, 1}, {4, 1}, {1, 4}, {4, 4}} {
+		b.Run(fmt.Sprintf("hash=%d/copy=%d", workers.hash, workers.copy), func(b *testing.B) {
+			env := setupTestEnv(b)
+			defer env.cleanup()
+
+			env.cfg.HashWorkers = workers.hash
+			env.cfg.CopyWorkers = workers.copy
+			env.cfg.KeepSource = true
+
+			b.SetBytes(total)
+			for i := 0; b.Loop(); i++ {
+				b.StopTimer()
+				files := writeSources(b, env.inputDir, fmt.Sprintf("run%d", i), sizes)
+				b.StartTimer()
+
+				env.processor.runPipeline(files)
+			}
+		})
+	}
+}
+
+func TestFlushOnPanic(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the worker panic to continue", r)
+		}
+	}()
+	func() {
+		defer env.processor.flushOnPanic(0)
+		panic("boom")
+	}()
+	t.Error("panic should propagate past flushOnPanic")
+}
@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+)
+
+// DefaultPriorityClass is the class of files matching no priority pattern
+const DefaultPriorityClass = "default"
+
+// PriorityClass is a class of files dispatched ahead of lower priorities
+type PriorityClass struct {
+	Name     string
+	Pattern  string
+	Priority int
+	re       *regexp.Regexp
+}
+
+// matches reports whether the file at relPath belongs to c. Patterns without
+// a slash match the file name, others the path relative to the input
+// directory.
+func (c PriorityClass) matches(relPath string) bool {
+	if !strings.Contains(c.Pattern, "/") {
+		relPath = path.Base(relPath)
+	}
+	return c.re.MatchString(relPath)
+}
+
+// ClassStats reports the load of one priority class
+type ClassStats struct {
+	Priority int `json:"priority"`
+	// InFlight counts files handed to the workers and not finished yet
+	InFlight int `json:"in_flight"`
+	// Backlog counts ready files waiting for their turn
+	Backlog int `json:"backlog"`
+	// OldestAge is how long ago the oldest waiting file was last written,
+	// zero when none waits
+	OldestAge time.Duration `json:"oldest_age_ns"`
+}
+
+// priorities classifies files by the first class whose pattern matches them
+type priorities []PriorityClass
+
+// ParsePriorities parses priority classes written as
+// "name:pattern=priority" separated by commas, such as
+// "urgent:*.xml=10,bulk:*.mp4=1". Files matching none belong to
+// DefaultPriorityClass at priority 0.
+func ParsePriorities(spec string) ([]PriorityClass, error) {
+	var classes []PriorityClass
+	seen := map[string]bool{DefaultPriorityClass: true}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(item, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("priority class %q: want name:pattern=priority", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("priority class %q: name used twice", name)
+		}
+		seen[name] = true
+		i := strings.LastIndex(rest, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("priority class %q: want name:pattern=priority", item)
+		}
+		pattern := rest[:i]
+		priority, err := strconv.Atoi(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("priority class %q: invalid priority %q", name, rest[i+1:])
+		}
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("priority class %q: pattern %q: %w", name, pattern, err)
+		}
+		classes = append(classes, PriorityClass{Name: name, Pattern: pattern, Priority: priority, re: re})
+	}
+	return classes, nil
+}
+
+// classify returns the class of the file at relPath
+func (ps priorities) classify(relPath string) PriorityClass {
+	for _, c := range ps {
+		if c.matches(relPath) {
+			return c
+		}
+	}
+	return PriorityClass{Name: DefaultPriorityClass}
+}
+
+// reservedSlots returns how many of slots are kept for the highest priority
+// present: fraction of them rounded up, leaving at least one to the others
+// so they still make progress
+func reservedSlots(slots int, fraction float64) int {
+	if fraction <= 0 || slots < 2 {
+		return 0
+	}
+	return min(int(math.Ceil(fraction*float64(slots))), slots-1)
+}
+
+// priorityOf returns the class of the source src below the input directory
+func (p *Processor) priorityOf(src string) PriorityClass {
+	rel, err := filepath.Rel(p.cfg.Path, src)
+	if err != nil {
+		rel = src
+	}
+	return p.priorities.classify(filepath.ToSlash(rel))
+}
+
+// PriorityStats returns the in-flight and backlog gauges of every priority
+// class, empty when no classes are configured
+func (p *Processor) PriorityStats() map[string]ClassStats {
+	stats := make(map[string]ClassStats)
+	if len(p.priorities) == 0 {
+		return stats
+	}
+	for _, c := range append(p.priorities, PriorityClass{Name: DefaultPriorityClass}) {
+		stats[c.Name] = ClassStats{Priority: c.Priority}
+	}
+	if s := p.sched.Load(); s != nil {
+		for name, cs := range s.classSnapshot(p.clock()) {
+			stats[name] = cs
+		}
+	}
+	return stats
+}
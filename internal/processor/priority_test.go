@@ -0,0 +1,61 @@
+package processor
+
+import "testing"
+
+func TestParsePriorities(t *testing.T) {
+	classes, err := ParsePriorities("urgent:*.xml=10, bulk:media/**/*.mp4=1,low:*=-1")
+	if err != nil {
+		t.Fatalf("ParsePriorities() error = %v", err)
+	}
+	for _, tt := range []struct {
+		relPath  string
+		class    string
+		priority int
+	}{
+		{"acme/filing.xml", "urgent", 10},
+		{"media/2026/clip.mp4", "bulk", 1},
+		// Patterns with a slash match the whole relative path
+		{"other/clip.mp4", "low", -1},
+		{"notes.txt", "low", -1},
+	} {
+		c := priorities(classes).classify(tt.relPath)
+		if c.Name != tt.class || c.Priority != tt.priority {
+			t.Errorf("classify(%q) = %s=%d, want %s=%d", tt.relPath, c.Name, c.Priority, tt.class, tt.priority)
+		}
+	}
+	if c := priorities(nil).classify("a.xml"); c.Name != DefaultPriorityClass || c.Priority != 0 {
+		t.Errorf("classify() without classes = %+v, want the default class", c)
+	}
+
+	for _, spec := range []string{
+		"urgent",
+		"urgent:*.xml",
+		"urgent:*.xml=high",
+		":*.xml=1",
+		"a:*.xml=1,a:*.csv=2",
+		"default:*.xml=1",
+	} {
+		if _, err := ParsePriorities(spec); err == nil {
+			t.Errorf("ParsePriorities(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestReservedSlots(t *testing.T) {
+	for _, tt := range []struct {
+		slots    int
+		fraction float64
+		want     int
+	}{
+		{8, 0.25, 2},
+		{8, 0, 0},
+		{5, 0.25, 2},
+		// At least one slot is left to lower priorities
+		{2, 0.9, 1},
+		{1, 0.5, 0},
+	} {
+		if got := reservedSlots(tt.slots, tt.fraction); got != tt.want {
+			t.Errorf("reservedSlots(%d, %v) = %d, want %d", tt.slots, tt.fraction, got, tt.want)
+		}
+	}
+}
@@ -1,17 +1,30 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fetch"
 	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
 	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rename"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
 	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
 	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
 )
 
@@ -20,157 +33,569 @@ type Processor struct {
 	storage  *storage.Storage
 	watcher  *watcher.Watcher
 	manifest *manifest.Writer
+	copyOpts fileops.CopyOptions
+	limits   pathLimits
+	shards   *sharder
+	rules    *rules.Rules
+	renamer  *rename.Renamer
+	keys     *idempotencyKeys
+	// redactor pseudonymizes sensitive names in published events
+	redactor *redact.Redactor
+	// outbox delivers the notifications written to the outbox table, nil
+	// when notifications are disabled
+	outbox *outbox.Dispatcher
+	// dryRun tallies the pass in dry run
+	dryRun dryRunTally
+
+	// ctx cancels long-running work such as hashing on shutdown
+	ctx context.Context
+	// failpoints are test hooks run after each processing stage
+	failpoints map[string]func()
+	// openSource opens sources read by the copy-first strategy
+	openSource func(path string) (io.ReadCloser, error)
+	// copyExists checks the warehouse copy of a duplicate's original;
+	// present caches the copies it found and restored counts the originals
+	// found missing and ingested again
+	copyExists func(path string) bool
+	present    existenceCache
+	restored   atomic.Int64
+	// leftovers counts the ingested sources that could not be removed
+	leftovers atomic.Int64
+
+	// hashing maps each file being hashed to its *atomic.Int64 byte count
+	hashing sync.Map
+
+	// events publishes outcomes; stats is its built-in direct subscriber
+	events *eventBus
+	stats  *tracker
+	paused atomic.Bool
+
+	hashPool *stageMetrics
+	copyPool *stageMetrics
+	// sched dispatches the current or last pass
+	sched atomic.Pointer[scheduler]
+
+	// defaultSteps run for files matching none of the pipelines
+	defaultSteps []Step
+	pipelines    []pipelineRoute
+	steps        *stepMetrics
+	panics       panicLog
+	// mark is the ingestion watermark published for downstream consumers
+	mark watermarkState
+	// clock stamps ProcessedAt; seq numbers the stamps and watches
+	// clock for regressions
+	clock func() time.Time
+	seq   sequencer
+	// attempts writes the attempt history of every file
+	attempts *attemptLog
+	// storms detects the sources resending content already ingested
+	storms *stormTracker
+	// space reserves the size of the copies in flight against the free
+	// space of the warehouse; sameFS caches whether the input shares its
+	// filesystem
+	space      *spaceLedger
+	sameFSOnce sync.Once
+	sameFS     bool
+	// priorities are the priority classes ready files are dispatched by
+	priorities priorities
+	// xattrsUnsupported logs once that the warehouse filesystem refuses
+	// extended attributes
+	xattrsUnsupported sync.Once
+	// readOnly holds the sources that cannot be written, whose files are
+	// copied and never removed
+	readOnly map[string]bool
+	// fetcher downloads the files of URL lists, nil unless URLLists is set
+	fetcher *fetch.Client
+	// order writes manifest entries in sequence order, nil unless
+	// ManifestStrictOrder is set
+	order *orderedCommitter
+	// entriesReady wakes RetryManifests for an entry that failed to append
+	entriesReady chan struct{}
+	// layout places every file the processor writes, see SetLayout
+	layout *layout.Resolver
+	// health probes the warehouse before each pass dispatches, nil when
+	// it is not probed; degraded is set while the probe fails
+	health   *destination.Health
+	degraded atomic.Bool
 }
 
+// hashProgressLogInterval is how often progress of a long hash is logged
+const hashProgressLogInterval = 30 * time.Second
+
 func New(cfg *config.Config, storage *storage.Storage, watcher *watcher.Watcher) *Processor {
-	return &Processor{
-		cfg:      cfg,
-		storage:  storage,
-		watcher:  watcher,
-		manifest: manifest.NewWriter(cfg.ManifestsPath),
+	copyOpts := fileops.DefaultCopyOptions()
+	if policy, err := fileops.ParseSyncPolicy(cfg.SyncPolicy); err == nil {
+		copyOpts.Sync = policy
+	}
+	if copyOpts.Sync == fileops.SyncBatch {
+		copyOpts.Batch = &fileops.Batch{}
+	}
+	copyOpts.DirectThreshold = cfg.DirectThreshold
+
+	p := &Processor{
+		cfg:          cfg,
+		storage:      storage,
+		watcher:      watcher,
+		manifest:     manifest.NewWriter(cfg.ManifestsPath),
+		copyOpts:     copyOpts,
+		ctx:          context.Background(),
+		openSource:   openFile,
+		copyExists:   warehouseCopyExists,
+		clock:        time.Now,
+		events:       &eventBus{},
+		stats:        newTracker(),
+		hashPool:     &stageMetrics{},
+		copyPool:     &stageMetrics{},
+		steps:        newStepMetrics(),
+		shards:       newSharder(),
+		attempts:     newAttemptLog(storage, cfg.AttemptRetention),
+		storms:       newStormTracker(cfg),
+		space:        newSpaceLedger(cfg.Destination),
+		entriesReady: make(chan struct{}, 1),
+		layout:       layout.Default(),
+		limits: pathLimits{
+			maxName: cfg.MaxNameBytes,
+			maxPath: cfg.MaxPathBytes,
+			shorten: cfg.ShortenPaths,
+			form:    cfg.UnicodeNormalization,
+		},
+	}
+	if classes, err := ParsePriorities(cfg.Priorities); err == nil {
+		p.priorities = classes
+	}
+	if cfg.ManifestStrictOrder {
+		p.order = newOrderedCommitter()
+	}
+	if cfg.URLLists {
+		p.fetcher = fetch.New(fetch.Options{Timeout: cfg.URLTimeout, Retries: cfg.URLRetries, MaxBytes: cfg.URLMaxBytes, AllowHTTP: cfg.URLAllowHTTP})
+	}
+	p.manifest.SetSourceRoot(cfg.Path)
+	p.events.subscribeDirect(p.stats.record)
+	for _, name := range DefaultSteps {
+		p.defaultSteps = append(p.defaultSteps, builtinSteps[name](p))
+	}
+	return p
+}
+
+// SetContext sets the context whose cancellation aborts in-flight hashes
+func (p *Processor) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+// HashProgress returns the bytes hashed so far for every file currently
+// being hashed
+func (p *Processor) HashProgress() map[string]int64 {
+	progress := make(map[string]int64)
+	p.hashing.Range(func(key, value any) bool {
+		progress[key.(string)] = value.(*atomic.Int64).Load()
+		return true
+	})
+	return progress
+}
+
+// hashFile hashes a file while publishing its progress through HashProgress
+// and logging it at debug level every hashProgressLogInterval
+func (p *Processor) hashFile(filePath string, size int64) (string, error) {
+	processed := &atomic.Int64{}
+	p.hashing.Store(filePath, processed)
+	defer p.hashing.Delete(filePath)
+
+	start := time.Now()
+	lastLog := start
+	return fileops.CalculateSHA256WithOptions(filePath, fileops.HashOptions{
+		Context: p.ctx,
+		Progress: func(done int64) {
+			processed.Store(done)
+			if now := time.Now(); now.Sub(lastLog) >= hashProgressLogInterval {
+				lastLog = now
+				slog.Debug("hashing in progress",
+					"path", filePath,
+					"bytes_processed", done,
+					"size", size,
+					"elapsed", now.Sub(start),
+				)
+			}
+		},
+	})
+}
+
+// failpoint runs the test hook registered for stage, if any
+func (p *Processor) failpoint(stage string) {
+	if fn := p.failpoints[stage]; fn != nil {
+		fn()
 	}
 }
 
+// SetManifest replaces the default JSON Lines manifest writer
+func (p *Processor) SetManifest(w *manifest.Writer) {
+	w.SetSourceRoot(p.cfg.Path)
+	w.SetLayout(p.layout)
+	p.manifest = w
+}
+
+// Close summarizes the pending duplicates of resend storms, flushes and
+// closes the manifest writer, writes the queued attempt history and ends
+// subscriptions once their queued events are delivered. Call it once
+// ProcessFiles has returned for the last time, so every entry committed to
+// the database is in the manifest; closing twice is harmless.
+func (p *Processor) Close() error {
+	p.tickStorms(true)
+	p.events.close()
+	p.attempts.close()
+	p.order.drain()
+	if err := p.manifest.Close(); err != nil {
+		return fmt.Errorf("close manifest: %w", err)
+	}
+	return nil
+}
+
+// SetRenamer installs the rename rules applied to the warehouse path of
+// every file before it is normalized and checked for collisions
+func (p *Processor) SetRenamer(r *rename.Renamer) {
+	p.renamer = r
+}
+
+// SetRules installs the tagging rules evaluated for every ingested file
+func (p *Processor) SetRules(r *rules.Rules) {
+	p.rules = r
+}
+
 func (p *Processor) ProcessFiles() {
-	files := p.watcher.GetFilesToProcess()
+	p.tickStorms(false)
+	files, release := p.claimFiles(p.withoutPaused(p.watcher.GetFilesToProcess()))
+	defer release()
+	sets := p.watcher.GetFileSetsToProcess()
+	batches := p.watcher.GetBatchesToProcess()
+	lists, files := p.splitURLLists(files)
+	pending := len(files) + len(sets) + len(batches) + len(lists)
 
-	if len(files) == 0 {
+	if pending == 0 {
 		return
 	}
 
-	slog.Info("files ready to process", "count", len(files), "files", files)
+	if p.Paused() {
+		slog.Debug("processing paused", "pending", pending)
+		return
+	}
+
+	// Entries stamped now would sort before those already written
+	if p.clockHeld() {
+		slog.Debug("clock behind the latest ProcessedAt, holding processing", "pending", pending)
+		return
+	}
+
+	// Pause writes while a maintenance command (backup, prune) holds the lock;
+	// tracked files stay queued for the next tick
+	lock, err := p.storage.MaintenanceStatus()
+	if err != nil {
+		slog.Error("failed to check maintenance status", "error", err)
+		return
+	}
+	if lock != nil {
+		slog.Info("maintenance in progress, pausing processing",
+			"owner", lock.Owner,
+			"pid", lock.PID,
+			"host", lock.Host,
+			"expires_at", lock.ExpiresAt,
+			"pending", pending,
+		)
+		return
+	}
+
+	if !p.destinationHealthy(pending) {
+		return
+	}
 
-	// Use worker pool for concurrent processing
-	concurrency := p.cfg.Concurrency
-	if concurrency < 1 {
-		concurrency = 1
+	if p.cfg.DryRun {
+		defer p.summarizeDryRun()
 	}
-	if concurrency > len(files) {
-		concurrency = len(files)
+
+	// Multi-part sets are few and large, so they are handled one at a time
+	for _, set := range sets {
+		p.beginAttempt(set.Path, time.Now())
+		if err := guard(func() error { return p.processFileSet(set) }); err != nil {
+			p.record(set.Path, "", status.Failed, err)
+			if !p.logPanic(set.Path, err) {
+				slog.Error("failed to process file set", "path", set.Path, "error", err)
+			}
+		}
 	}
 
-	var wg sync.WaitGroup
-	fileChan := make(chan string, len(files))
+	// Batches are all-or-nothing and processed one at a time as well
+	for _, b := range batches {
+		p.beginAttempt(b.Dir, time.Now())
+		if err := guard(func() error { return p.processBatch(b) }); err != nil {
+			p.record(b.Dir, "", status.Failed, err)
+			if !p.logPanic(b.Dir, err) {
+				slog.Error("failed to process batch", "batch", b.Dir, "error", err)
+			}
+		}
+	}
 
-	// Start workers
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			for f := range fileChan {
-				slog.Debug("worker processing file", "worker", workerID, "path", f)
-				if err := p.processFile(f); err != nil {
-					slog.Error("failed to process file", "worker", workerID, "path", f, "error", err)
-				}
+	// Each URL list downloads its files concurrently itself
+	for _, list := range lists {
+		p.beginAttempt(list, time.Now())
+		if err := guard(func() error { return p.processURLList(list) }); err != nil {
+			p.record(list, "", status.Failed, err)
+			if !p.logPanic(list, err) {
+				slog.Error("failed to process URL list", "list", list, "error", err)
 			}
-		}(i)
+		}
+	}
+
+	if len(files) == 0 {
+		p.flushBatch()
+		return
 	}
 
-	// Send files to workers
-	for _, f := range files {
-		fileChan <- f
+	slog.Info("files ready to process", "count", len(files), "files", files)
+
+	p.runPipeline(files)
+	p.flushBatch()
+}
+
+// claimFiles claims files in the watcher so that a pass overlapping this
+// one skips them, returning those this pass owns and a function releasing
+// them. Releasing once the pass returns covers every outcome, panics
+// included; ingested files are no longer tracked by then.
+func (p *Processor) claimFiles(files []string) ([]string, func()) {
+	owned := files[:0]
+	for _, path := range files {
+		if p.watcher.Claim(path) {
+			owned = append(owned, path)
+		}
 	}
-	close(fileChan)
+	return owned, func() {
+		for _, path := range owned {
+			p.watcher.Release(path)
+		}
+	}
+}
 
-	// Wait for all workers to complete
-	wg.Wait()
+// flushBatch syncs copies whose fsync was deferred by the batch sync policy
+func (p *Processor) flushBatch() {
+	if p.copyOpts.Batch != nil {
+		if err := p.copyOpts.Batch.Flush(); err != nil {
+			slog.Error("failed to flush batched syncs", "error", err)
+		}
+	}
 }
 
+// processFile hashes and ingests a single file without the pipeline
 func (p *Processor) processFile(filePath string) error {
+	t := p.newTiming(time.Now())
+	h, err := p.hashSource(filePath)
+	if errors.Is(err, errStormRejected) || errors.Is(err, errAlreadyIngested) || errors.Is(err, errHeld) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.lap(timeHash)
+	h.timing = t
+	return p.ingestHashed(h)
+}
+
+// hashSource stats and hashes a source file, the CPU-bound first stage. In
+// copy-first mode it stages the source and hashes the staged copy.
+func (p *Processor) hashSource(filePath string) (_ hashedFile, err error) {
+	if p.skipIngested(filePath) {
+		return hashedFile{}, errAlreadyIngested
+	}
+	started := time.Now()
+	p.beginAttempt(filePath, started)
+
 	// Get file info and calculate SHA256
 	info, err := os.Stat(filePath)
+	if isVanished(filePath, err) {
+		return hashedFile{}, p.handleVanished(filePath, "", "", stageStat, err)
+	}
 	if err != nil {
 		slog.Warn("failed to stat file", "path", filePath, "error", err)
 		p.watcher.RemoveFromTracking(filePath)
-		return fmt.Errorf("stat file %s: %w", filePath, err)
+		return hashedFile{}, fmt.Errorf("stat file %s: %w", filePath, err)
 	}
 
-	hash, err := fileops.CalculateSHA256(filePath)
-	if err != nil {
-		slog.Warn("failed to calculate SHA256", "path", filePath, "error", err)
-		p.watcher.RemoveFromTracking(filePath)
-		return fmt.Errorf("calculate SHA256 for %s: %w", filePath, err)
-	}
+	p.failpoint(stageStat)
+	allocated := fileops.Allocated(info)
 
-	// Check if file with same SHA256 was already processed
-	exists, err := p.storage.FileExists(hash)
-	if err != nil {
-		slog.Error("failed to check file existence", "path", filePath, "error", err)
-		return fmt.Errorf("check file existence for %s: %w", filePath, err)
+	// A file held for shrinking waits without being hashed again
+	if p.heldBack(filePath, info.Size()) {
+		return hashedFile{}, errHeld
 	}
 
-	if exists {
-		slog.Info("file already processed, skipping", "path", filePath, "sha256", hash)
-		p.watcher.RemoveFromTracking(filePath)
-		return nil
+	// A resend storm rejects what it already recorded without hashing it
+	if original := p.knownInStorm(filePath, info.Size()); original != nil {
+		p.rejectInStorm(filePath, info.Size(), original, started)
+		return hashedFile{}, errStormRejected
 	}
 
-	// Calculate destination path
-	relPath, err := filepath.Rel(p.cfg.Path, filePath)
+	// Hold the space of the copy until the file is ingested or fails
+	release, err := p.reserveSpace(filePath, p.accountedSize(info.Size(), allocated))
 	if err != nil {
-		return fmt.Errorf("calculate relative path for %s: %w", filePath, err)
+		return hashedFile{}, err
 	}
-	dstPath := filepath.Join(p.cfg.Destination, relPath)
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
 
-	// Dry run mode - log what would happen but don't make changes
-	if p.cfg.DryRun {
-		slog.Info("dry run: would process file",
-			"path", filePath,
-			"sha256", hash,
-			"destination", dstPath,
-			"size", info.Size(),
-		)
+	// Copy-first hashes and ingests a local copy read from the source once
+	hashPath := filePath
+	var staged stagedCopy
+	if p.cfg.CopyFirst && !p.cfg.DryRun {
+		staged, err = p.stageSource(filePath, p.accountedSize(info.Size(), allocated))
+		if isVanished(filePath, err) {
+			return hashedFile{}, p.handleVanished(filePath, "", "", stageStat, err)
+		}
+		if errors.Is(err, ErrInsufficientSpace) || errors.Is(err, context.Canceled) {
+			// Keep the file tracked until there is room or for the next run
+			return hashedFile{}, err
+		}
+		if err != nil {
+			slog.Warn("failed to stage source", "path", filePath, "error", err)
+			p.watcher.RemoveFromTracking(filePath)
+			return hashedFile{}, err
+		}
+		if info, err = os.Stat(staged.path); err != nil {
+			_ = os.RemoveAll(staged.dir)
+			return hashedFile{}, fmt.Errorf("stat staged copy of %s: %w", filePath, err)
+		}
+		hashPath = staged.path
+	}
+
+	hash, err := p.hashFile(hashPath, info.Size())
+	if err != nil && staged.dir != "" {
+		_ = os.RemoveAll(staged.dir)
+	}
+	if isVanished(filePath, err) {
+		return hashedFile{}, p.handleVanished(filePath, "", "", stageHash, err)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// Shutting down: keep the file tracked for the next run
+		return hashedFile{}, fmt.Errorf("calculate SHA256 for %s: %w", filePath, err)
+	}
+	if err != nil {
+		slog.Warn("failed to calculate SHA256", "path", filePath, "error", err)
 		p.watcher.RemoveFromTracking(filePath)
-		return nil
+		return hashedFile{}, fmt.Errorf("calculate SHA256 for %s: %w", filePath, err)
 	}
+	p.failpoint(stageHash)
 
-	// Process the file in a transaction
-	processedAt := time.Now()
-	err = p.storage.Transaction(func(txStorage *storage.Storage) error {
-		// Create database record
-		if err := txStorage.CreateFile(hash, info.Name(), filePath, info.Size()); err != nil {
-			return fmt.Errorf("create database record: %w", err)
+	held, lifted := p.holdIfShrunk(filePath, info.Size(), hash)
+	if held {
+		if staged.dir != "" {
+			_ = os.RemoveAll(staged.dir)
 		}
+		return hashedFile{}, errHeld
+	}
 
-		// Ensure destination directory exists
-		dstDir := filepath.Dir(dstPath)
-		if err := os.MkdirAll(dstDir, 0o755); err != nil {
-			return fmt.Errorf("create destination directory %s: %w", dstDir, err)
-		}
+	return hashedFile{path: filePath, info: info, allocated: allocated, hash: hash, started: started, staged: staged, release: release, lifted: lifted}, nil
+}
 
-		// Move the file atomically (rename if same filesystem, copy+delete otherwise)
-		if err := fileops.MoveFile(filePath, dstPath); err != nil {
-			return fmt.Errorf("move file to %s: %w", dstPath, err)
-		}
+// ingestHashed runs the post-hash steps configured for a file, the
+// I/O-bound second stage
+func (p *Processor) ingestHashed(h hashedFile) error {
+	if h.release != nil {
+		defer h.release()
+	}
+	relPath, err := filepath.Rel(p.cfg.Path, h.path)
+	if err != nil {
+		return fmt.Errorf("calculate relative path for %s: %w", h.path, err)
+	}
+	fc := &FileContext{
+		SourcePath:  h.path,
+		RelPath:     filepath.ToSlash(relPath),
+		Info:        h.info,
+		Allocated:   h.allocated,
+		SHA256:      h.hash,
+		ContentPath: h.path,
+		Started:     h.started,
 
-		return nil
-	})
+		IdempotencyKey: p.idempotencyKey(h.path),
+		SelfTest:       p.isProbe(h.path),
+		SourceURL:      h.url,
 
-	if err != nil {
-		return fmt.Errorf("process file %s: %w", filePath, err)
+		timing: h.timing,
+	}
+	if len(p.priorities) > 0 {
+		fc.Priority = p.priorityOf(h.path).Name
+	}
+	if h.staged.dir != "" {
+		fc.ContentPath = h.staged.path
+		fc.addTemp(h.staged.dir)
 	}
+	if err := p.runSteps(p.pipelineFor(fc.RelPath), fc); err != nil {
+		return err
+	}
+	if h.lifted {
+		p.endHold(h.path)
+	}
+	return nil
+}
 
-	// Write manifest entry (outside transaction - best effort)
-	manifestEntry := manifest.Entry{
-		SHA256:      hash,
-		Name:        info.Name(),
-		SourcePath:  filePath,
-		DestPath:    dstPath,
-		Size:        info.Size(),
-		ProcessedAt: processedAt,
+// moveToWarehouse places a file at its destination, creating parent
+// directories. By default the source is moved; with a grace period it is
+// copied and the source moved into the trash, and with KeepSource or from a
+// read-only source it is copied and left in place. It returns how a copy
+// was made, empty for a move. onSync, when set, is called with the time of
+// each fsync.
+func (p *Processor) moveToWarehouse(filePath, dstPath string, onSync func(time.Duration)) (fileops.CopyMethod, error) {
+	fsys := p.copyOpts.FileSystem()
+	dstDir := filepath.Dir(dstPath)
+	if err := fsys.MkdirAll(dstDir, 0o755); err != nil {
+		return "", fmt.Errorf("create destination directory %s: %w", dstDir, err)
 	}
-	if err := p.manifest.Append(manifestEntry); err != nil {
-		slog.Warn("failed to write manifest entry", "path", filePath, "error", err)
-		// Don't fail the operation for manifest errors
+
+	if p.removesSource(filePath) {
+		// Move the file atomically (rename if same filesystem, copy+delete otherwise)
+		opts := p.copyOpts
+		opts.OnSync = onSync
+		if err := fileops.MoveFileWithOptions(filePath, dstPath, opts); err != nil {
+			return "", fmt.Errorf("move file to %s: %w", dstPath, err)
+		}
+		return "", nil
 	}
 
-	p.watcher.RemoveFromTracking(filePath)
+	// Copy under a temporary name so the destination only appears complete
+	tmpDst := dstPath + ".tmp"
+	opts := p.placeOpts(filePath)
+	opts.OnSync = onSync
+	method, err := fileops.CopyFileWithOptions(filePath, tmpDst, opts)
+	if err != nil {
+		_ = fsys.Remove(tmpDst)
+		return "", fmt.Errorf("copy file to %s: %w", dstPath, err)
+	}
+	if err := fsys.Rename(tmpDst, dstPath); err != nil {
+		_ = fsys.Remove(tmpDst)
+		return "", fmt.Errorf("rename copy to %s: %w", dstPath, err)
+	}
 
-	slog.Info("file processed successfully",
-		"path", filePath,
-		"sha256", hash,
-		"destination", dstPath,
-		"size", info.Size(),
-	)
-	return nil
+	p.disposeSource(filePath)
+	return method, nil
+}
+
+// disposeSource removes an ingested source according to the source options.
+// The warehouse copy is already complete, so a failure only leaves the source
+// in place, where dedup prevents re-ingestion: the next run finds it a
+// duplicate of the record made from its own path and removes it then.
+func (p *Processor) disposeSource(filePath string) {
+	switch {
+	case p.keepsSource(filePath):
+		return
+	case p.cfg.SourceGrace > 0:
+		trashed, err := trash.Move(p.cfg.Path, filePath)
+		if err != nil {
+			p.leftovers.Add(1)
+			slog.Warn("failed to move source to trash, left for the next run", "path", filePath, "error", err)
+			return
+		}
+		slog.Debug("source moved to trash", "path", filePath, "trash", trashed)
+	default:
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			p.leftovers.Add(1)
+			slog.Warn("failed to remove source, left for the next run", "path", filePath, "error", err)
+		}
+	}
 }
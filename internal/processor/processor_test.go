@@ -1,12 +1,21 @@
 package processor
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
 	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
 	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
 	"gorm.io/driver/sqlite"
@@ -26,7 +35,7 @@ type testEnv struct {
 	cleanup      func()
 }
 
-func setupTestEnv(t *testing.T) *testEnv {
+func setupTestEnv(t testing.TB) *testEnv {
 	t.Helper()
 
 	tmpDir := t.TempDir()
@@ -44,7 +53,7 @@ func setupTestEnv(t *testing.T) *testEnv {
 	}
 
 	// Setup database
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	db, err := gorm.Open(sqlite.Open(storage.DSN(dbPath)), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
@@ -76,6 +85,7 @@ func setupTestEnv(t *testing.T) *testEnv {
 
 	cleanup := func() {
 		_ = w.Close()
+		proc.attempts.close()
 		sqlDB, _ := db.DB()
 		if sqlDB != nil {
 			_ = sqlDB.Close()
@@ -383,3 +393,528 @@ func TestProcessFile_NonExistentFile(t *testing.T) {
 		t.Error("expected error for non-existent file, got nil")
 	}
 }
+
+func TestProcessFile_ConcurrentIdenticalContent(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	content := []byte("raced content")
+	files := []string{
+		filepath.Join(env.inputDir, "racer1.csv"),
+		filepath.Join(env.inputDir, "racer2.csv"),
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f, content, 0o644); err != nil {
+			t.Fatalf("failed to create %s: %v", f, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(files))
+	for i, f := range files {
+		wg.Add(1)
+		go func(i int, f string) {
+			defer wg.Done()
+			errs[i] = env.processor.processFile(f)
+		}(i, f)
+	}
+	wg.Wait()
+
+	// The loser must be treated as a duplicate, not a failure
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("processFile(%s) failed: %v", files[i], err)
+		}
+	}
+
+	entries, err := os.ReadDir(env.warehouseDir)
+	if err != nil {
+		t.Fatalf("failed to read warehouse dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected 1 file in warehouse, got %d", len(entries))
+	}
+}
+
+func TestProcessFiles_PausedDuringMaintenance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	testFile := filepath.Join(env.inputDir, "maintenance.csv")
+	if err := os.WriteFile(testFile, []byte("waits for maintenance"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	// Wait for stability window
+	time.Sleep(2 * time.Second)
+
+	m, err := env.store.BeginMaintenance("test", time.Minute)
+	if err != nil {
+		t.Fatalf("BeginMaintenance failed: %v", err)
+	}
+
+	env.processor.ProcessFiles()
+
+	if _, err := os.Stat(testFile); err != nil {
+		t.Fatal("file should stay in input while maintenance is running")
+	}
+
+	if err := m.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+
+	// Still tracked, so the next tick picks it up
+	env.processor.ProcessFiles()
+
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "maintenance.csv")); err != nil {
+		t.Error("file should be processed once maintenance ends")
+	}
+}
+
+func TestProcessFile_AppliesTaggingRules(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	r, err := rules.Parse([]byte(`
+- match:
+    path: "finance/**"
+  tags:
+    classification: restricted
+`))
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+	env.processor.SetRules(r)
+
+	dir := filepath.Join(env.inputDir, "finance")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	src := filepath.Join(dir, "ledger.csv")
+	if err := os.WriteFile(src, []byte("tagged"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	files, err := env.store.ListFiles(storage.FileFilter{Tags: map[string]string{"classification": "restricted"}})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "ledger.csv" {
+		t.Errorf("ListFiles() = %+v, want ledger.csv", files)
+	}
+}
+
+func TestProcessFile_SourceDisposal(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepSource bool
+		grace      time.Duration
+		inInput    bool
+		inTrash    bool
+	}{
+		{"delete", false, 0, false, false},
+		{"keep", true, 0, true, false},
+		{"grace", false, time.Hour, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+
+			env.cfg.KeepSource = tt.keepSource
+			env.cfg.SourceGrace = tt.grace
+
+			src := filepath.Join(env.inputDir, "source.csv")
+			if err := os.WriteFile(src, []byte("dispose "+tt.name), 0o644); err != nil {
+				t.Fatalf("failed to create test file: %v", err)
+			}
+
+			if err := env.processor.processFile(src); err != nil {
+				t.Fatalf("processFile() error = %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(env.warehouseDir, "source.csv")); err != nil {
+				t.Errorf("warehouse copy missing: %v", err)
+			}
+			if _, err := os.Stat(src); (err == nil) != tt.inInput {
+				t.Errorf("source present = %v, want %v", err == nil, tt.inInput)
+			}
+			trashed := filepath.Join(env.inputDir, config.TrashDirName, "source.csv")
+			if _, err := os.Stat(trashed); (err == nil) != tt.inTrash {
+				t.Errorf("trash entry present = %v, want %v", err == nil, tt.inTrash)
+			}
+		})
+	}
+}
+
+func TestProcessFile_CanceledHashLeavesSource(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.processor.SetContext(ctx)
+
+	src := filepath.Join(env.inputDir, "canceled.csv")
+	if err := os.WriteFile(src, []byte("canceled"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	err := env.processor.processFile(src)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("processFile() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source touched after canceled hash: %v", err)
+	}
+	if progress := env.processor.HashProgress(); len(progress) != 0 {
+		t.Errorf("HashProgress() = %v, want empty after hash ended", progress)
+	}
+}
+
+// unmanifested returns the records that have no manifest entry for their
+// content and destination
+func unmanifested(t *testing.T, env *testEnv) []storage.File {
+	t.Helper()
+	recs, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range readManifest(t, env.manifestsDir) {
+		seen[e.SHA256+"\x00"+e.DestPath] = true
+	}
+	var missing []storage.File
+	for _, rec := range recs {
+		if !seen[rec.SHA256+"\x00"+rec.DestPath] {
+			missing = append(missing, rec)
+		}
+	}
+	return missing
+}
+
+func TestClose_ManifestCoversEveryRecord(t *testing.T) {
+	for _, format := range []string{manifest.FormatJSONL, manifest.FormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.Concurrency = 4
+
+			if format == manifest.FormatParquet {
+				mw, err := manifest.NewParquetWriter(env.manifestsDir, manifest.PeriodHourly)
+				if err != nil {
+					t.Fatalf("NewParquetWriter() error = %v", err)
+				}
+				env.processor.SetManifest(mw)
+			}
+
+			env.processor.runPipeline(writeSources(t, env.inputDir, "close", []int{64, 128, 256, 512, 1024, 2048}))
+			if err := env.processor.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if err := env.processor.Close(); err != nil {
+				t.Errorf("second Close() error = %v", err)
+			}
+
+			if missing := unmanifested(t, env); len(missing) != 0 {
+				t.Errorf("records without manifest entries: %+v", missing)
+			}
+			if n := len(readManifest(t, env.manifestsDir)); n != 6 {
+				t.Errorf("manifest has %d entries, want 6", n)
+			}
+		})
+	}
+}
+
+// TestCrash_ManifestGuarantees documents what survives a kill without Close.
+// Every append is fsynced before it returns, so all entries written before
+// the crash are readable, from a JSON Lines file or a parquet write-ahead
+// companion alike. The database commits before the manifest, so the one gap
+// a crash can leave is a record whose entry was never written.
+func TestCrash_ManifestGuarantees(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	mw, err := manifest.NewParquetWriter(env.manifestsDir, manifest.PeriodHourly)
+	if err != nil {
+		t.Fatalf("NewParquetWriter() error = %v", err)
+	}
+	env.processor.SetManifest(mw)
+	env.processor.runPipeline(writeSources(t, env.inputDir, "crash", []int{64, 128, 256}))
+
+	// The process dies after committing the next file but before its
+	// manifest append
+	fc := newFileContext(t, env, "late.csv", "late")
+	if err := env.processor.runSteps(builtins(env.processor, StepDedup, StepResolve, StepClaim, StepMove), fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+
+	// The restarted writer finalizes the companions left behind
+	if _, err := manifest.NewParquetWriter(env.manifestsDir, manifest.PeriodHourly); err != nil {
+		t.Fatalf("NewParquetWriter() after crash error = %v", err)
+	}
+	if n := len(readManifest(t, env.manifestsDir)); n != 3 {
+		t.Errorf("manifest has %d entries after crash, want the 3 appended", n)
+	}
+	missing := unmanifested(t, env)
+	if len(missing) != 1 || missing[0].SHA256 != fc.SHA256 {
+		t.Errorf("records without manifest entries = %+v, want only late.csv", missing)
+	}
+}
+
+// startSidecarWatcher starts a sidecar watcher over the input directory that
+// persists completions in the environment's state database
+func TestProcessFiles_OverlappingPassesProcessOnce(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// An old mtime makes the file ready as soon as the watcher seeds it
+	src := filepath.Join(env.inputDir, "slow.csv")
+	if err := os.WriteFile(src, []byte("slow content"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatalf("failed to age test file: %v", err)
+	}
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	var hashes atomic.Int32
+	hashing := make(chan struct{})
+	env.processor.failpoints = map[string]func(){stageHash: func() {
+		if hashes.Add(1) == 1 {
+			close(hashing)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}}
+
+	var wg sync.WaitGroup
+	wg.Go(env.processor.ProcessFiles)
+	<-hashing
+	// The next tick fires while the first pass is still on the file
+	if files := env.watcher.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process during the first pass = %v, want none", files)
+	}
+	wg.Go(env.processor.ProcessFiles)
+	wg.Wait()
+
+	if n := hashes.Load(); n != 1 {
+		t.Errorf("file hashed %d times, want once", n)
+	}
+	if n := countRecords(t, env.store); n != 1 {
+		t.Errorf("got %d database records, want 1", n)
+	}
+	stats := env.processor.Stats()
+	if stats.Totals.Ingested != 1 || stats.Totals.Failed != 0 || stats.Totals.Vanished != 0 {
+		t.Errorf("stats = %+v, want a single ingestion", stats.Totals)
+	}
+}
+
+func startSidecarWatcher(t *testing.T, env *testEnv) *watcher.Watcher {
+	t.Helper()
+	w, err := watcher.New(config.MethodSidecar, env.inputDir, 1)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	if err := w.PersistCompletions(env.store); err != nil {
+		t.Fatalf("PersistCompletions() error = %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	return w
+}
+
+func TestSidecar_ExactlyOnceAcrossRestarts(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.Method = config.MethodSidecar
+
+	// The sidecar is seen, then removed, before the process dies
+	w := startSidecarWatcher(t, env)
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte("first seen"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(src+config.SidecarSuffix, nil, 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.GetFilesToProcess()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("sidecar was never observed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(src + config.SidecarSuffix); err != nil {
+		t.Fatalf("failed to remove sidecar: %v", err)
+	}
+	_ = w.Close()
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The rebuilt watcher and processor finish the file from the state database
+	w = startSidecarWatcher(t, env)
+	p := New(env.cfg, env.store, w)
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != src {
+		t.Fatalf("files to process after restart = %v, want %s", files, src)
+	}
+	if err := p.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	_ = w.Close()
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if paths, err := env.store.CompletedPaths(); err != nil || len(paths) != 0 {
+		t.Errorf("CompletedPaths() = %v, %v, want the completion settled", paths, err)
+	}
+
+	// Another restart has nothing left to do
+	w = startSidecarWatcher(t, env)
+	defer func() { _ = w.Close() }()
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process after second restart = %v, want none", files)
+	}
+
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].Status != status.Ingested || entries[0].SourcePath != src {
+		t.Errorf("manifest entries = %+v, want one ingested entry", entries)
+	}
+	records, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("database records = %+v, want one", records)
+	}
+}
+
+func TestSidecar_LateSidecarIgnored(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.Method = config.MethodSidecar
+
+	w, err := watcher.New(config.MethodSidecar, env.inputDir, 1)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	w.SetTombstoneWindow(time.Minute)
+	if err := w.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	p := New(env.cfg, env.store, w)
+	defer func() { _ = p.Close() }()
+
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte("1,alice\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	sidecar := src + config.SidecarSuffix
+	if err := os.WriteFile(sidecar, nil, 0o644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.GetFilesToProcess()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("sidecar was never observed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.ProcessFiles()
+	if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("source still in the input after processing: %v", err)
+	}
+
+	// The producer touches the sidecar again once the file is gone
+	_ = os.Remove(sidecar)
+	if err := os.WriteFile(sidecar, nil, 0o644); err != nil {
+		t.Fatalf("failed to rewrite sidecar: %v", err)
+	}
+	for w.EventStats().Suppressed == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("late sidecar event was never suppressed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process after the late sidecar = %v, want none", files)
+	}
+	p.ProcessFiles()
+
+	if stats := p.Stats(); stats.Totals.Ingested != 1 || stats.Totals.Failed != 0 || stats.Totals.Vanished != 0 {
+		t.Errorf("stats = %+v, want a single ingestion", stats.Totals)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("warnings or errors logged:\n%s", logs.String())
+	}
+}
+
+func TestRelativeInput_CanonicalPaths(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// Run as with -input input from the directory holding it
+	t.Chdir(filepath.Dir(env.inputDir))
+	input, err := config.ResolvePath("input")
+	if err != nil {
+		t.Fatalf("ResolvePath() error = %v", err)
+	}
+	env.cfg.Path = input
+	if err := os.MkdirAll(filepath.Join("input", "tenant"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join("input", "tenant", "a.csv"), []byte("relative input"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w, err := watcher.New(config.MethodStabilityWindow, "input", 0)
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	env.processor.watcher = w
+	env.processor.ProcessFiles()
+
+	files, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("ListFiles() = %v, %v; want one record", files, err)
+	}
+	if want := filepath.Join(input, "tenant", "a.csv"); files[0].Path != want || files[0].RelPath != "tenant/a.csv" {
+		t.Errorf("record paths = %s, %s; want %s, tenant/a.csv", files[0].Path, files[0].RelPath, want)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].SourceRelPath != "tenant/a.csv" {
+		t.Errorf("manifest entries = %+v, want one with source_rel_path tenant/a.csv", entries)
+	}
+	if tracked := w.Tracked(); len(tracked) != 0 {
+		t.Errorf("still tracked after ingest: %+v", tracked)
+	}
+}
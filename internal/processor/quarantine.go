@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Quarantine reasons
+const (
+	ReasonPathTooLong = "path_too_long"
+	// ReasonInvalidRename quarantines a file a rename rule rewrote to a path
+	// outside the warehouse
+	ReasonInvalidRename = "invalid_rename"
+)
+
+// quarantineRecord is written next to every quarantined file
+type quarantineRecord struct {
+	Reason string `json:"reason"`
+	// Step is the pipeline step that rejected the file
+	Step          string    `json:"step,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	SourcePath    string    `json:"source_path"`
+	SourceURL     string    `json:"source_url,omitempty"`
+	SHA256        string    `json:"sha256"`
+	Size          int64     `json:"size"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// quarantine moves a file that cannot be ingested into the quarantine
+// directory, named by its content hash so the name itself cannot be the
+// problem, and writes a JSON record explaining why and which step rejected it
+func (p *Processor) quarantine(filePath, hash string, size int64, reason, step string, cause error) error {
+	return p.quarantineOrigin(origin{path: filePath, content: filePath}, hash, size, reason, step, cause)
+}
+
+// origin is where quarantined content comes from: the source file at path,
+// or a file downloaded from url into content and ingested under path
+type origin struct {
+	path    string
+	content string
+	url     string
+}
+
+// quarantineOrigin is quarantine for content of any origin
+func (p *Processor) quarantineOrigin(o origin, hash string, size int64, reason, step string, cause error) error {
+	filePath := o.path
+	dir := p.cfg.QuarantinePath
+	if dir == "" {
+		dir = config.DefaultQuarantinePath
+	}
+
+	quarantinedAt, seq := p.stamp()
+	defer p.order.release(seq)
+	c := p.layoutContext(filePath, quarantinedAt)
+	c.SHA256 = hash
+	rel, err := p.layout.Resolve(layout.Quarantine, c)
+	if err != nil {
+		return fmt.Errorf("resolve quarantine path of %s: %w", filePath, err)
+	}
+	dstPath := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return fmt.Errorf("create quarantine directory %s: %w", filepath.Dir(dstPath), err)
+	}
+	record := quarantineRecord{
+		Reason:        reason,
+		Step:          step,
+		SourcePath:    filePath,
+		SourceURL:     o.url,
+		SHA256:        hash,
+		Size:          size,
+		QuarantinedAt: quarantinedAt,
+	}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode quarantine record: %w", err)
+	}
+	dstPath, err = writeQuarantineRecord(dstPath, data)
+	if err != nil {
+		return err
+	}
+
+	if err := p.quarantineSource(o.content, dstPath); err != nil {
+		return fmt.Errorf("move file to quarantine %s: %w", dstPath, err)
+	}
+
+	entry := manifest.Entry{
+		SHA256:      hash,
+		Name:        filepath.Base(filePath),
+		SourcePath:  filePath,
+		DestPath:    dstPath,
+		Size:        size,
+		ProcessedAt: record.QuarantinedAt,
+		Status:      status.Quarantined,
+		Reason:      reason,
+		SelfTest:    p.isProbe(filePath),
+		Sequence:    seq,
+		SourceURL:   o.url,
+	}
+	if err := p.appendEntry(entry, false); err != nil {
+		slog.Warn("failed to write manifest entry", "path", filePath, "error", err)
+	}
+
+	p.watcher.RemoveFromTracking(filePath)
+	p.recordEntry(entry, status.Quarantined, cause, time.Time{})
+	receipt := Receipt{Status: status.Quarantined, SHA256: hash, Destination: dstPath, Reason: reason}
+	if cause != nil {
+		receipt.Error = cause.Error()
+	}
+	p.writeReceipt(filePath, receipt)
+
+	slog.Warn("file quarantined",
+		"path", filePath,
+		"url", o.url,
+		"sha256", hash,
+		"reason", reason,
+		"step", step,
+		"destination", dstPath,
+		"error", cause,
+	)
+	return nil
+}
+
+// writeQuarantineRecord writes data as the reason record of dstPath, or of
+// <name>-<n><ext> next to it when content with the same hash was already
+// quarantined there, and returns the path the record belongs to. Claiming the
+// record file exclusively keeps one quarantine from overwriting another's.
+func writeQuarantineRecord(dstPath string, data []byte) (string, error) {
+	ext := filepath.Ext(dstPath)
+	stem := strings.TrimSuffix(dstPath, ext)
+	for n := 0; ; n++ {
+		path := dstPath
+		if n > 0 {
+			path = fmt.Sprintf("%s-%d%s", stem, n, ext)
+		}
+		f, err := os.OpenFile(path+".reason.json", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("write quarantine record: %w", err)
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", fmt.Errorf("write quarantine record: %w", err)
+		}
+		return path, nil
+	}
+}
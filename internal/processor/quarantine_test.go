@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+func TestProcessFile_QuarantinesLongName(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+	env.processor.limits = pathLimits{maxName: 64, maxPath: 4096}
+
+	src := filepath.Join(env.inputDir, strings.Repeat("x", 100)+".csv")
+	if err := os.WriteFile(src, []byte("too long"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	hash, err := fileops.CalculateSHA256(src)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	dst := filepath.Join(env.cfg.QuarantinePath, hash+".csv")
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("quarantined file missing: %v", err)
+	}
+
+	data, err := os.ReadFile(dst + ".reason.json")
+	if err != nil {
+		t.Fatalf("failed to read reason file: %v", err)
+	}
+	var record quarantineRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode reason file: %v", err)
+	}
+	if record.Reason != ReasonPathTooLong {
+		t.Errorf("reason = %q, want %q", record.Reason, ReasonPathTooLong)
+	}
+
+	exists, err := env.store.FileExistsSince(hash, time.Time{})
+	if err != nil {
+		t.Fatalf("FileExistsSince() error = %v", err)
+	}
+	if exists {
+		t.Error("quarantined file should not be recorded as ingested")
+	}
+}
+
+func TestProcessFile_ShortensLongName(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.processor.limits = pathLimits{maxName: 64, maxPath: 4096, shorten: true}
+
+	name := strings.Repeat("ß", 60) + ".csv"
+	src := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(src, []byte("shortened"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	hash, err := fileops.CalculateSHA256(src)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	record, err := env.store.FindBySHA256(hash)
+	if err != nil || record == nil {
+		t.Fatalf("FindBySHA256() = %v, %v", record, err)
+	}
+	if record.OriginalName != name {
+		t.Errorf("OriginalName = %q, want %q", record.OriginalName, name)
+	}
+	if len(record.Name) > 64 {
+		t.Errorf("Name length = %d, want <= 64", len(record.Name))
+	}
+	if _, err := os.Stat(record.DestPath); err != nil {
+		t.Errorf("shortened destination missing: %v", err)
+	}
+}
+
+func TestQuarantine_SameContentKeepsBothRecords(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	var hash string
+	for _, reason := range []string{ReasonPathTooLong, ReasonInvalidRename} {
+		src := filepath.Join(env.inputDir, "same.csv")
+		if err := os.WriteFile(src, []byte("same content"), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		var err error
+		hash, err = fileops.CalculateSHA256(src)
+		if err != nil {
+			t.Fatalf("failed to hash test file: %v", err)
+		}
+		if err := env.processor.quarantine(src, hash, 12, reason, "test", nil); err != nil {
+			t.Fatalf("quarantine() error = %v", err)
+		}
+	}
+
+	for path, want := range map[string]string{
+		filepath.Join(env.cfg.QuarantinePath, hash+".csv"):   ReasonPathTooLong,
+		filepath.Join(env.cfg.QuarantinePath, hash+"-1.csv"): ReasonInvalidRename,
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("quarantined file missing: %v", err)
+		}
+		data, err := os.ReadFile(path + ".reason.json")
+		if err != nil {
+			t.Fatalf("failed to read reason file: %v", err)
+		}
+		var record quarantineRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("failed to decode reason file: %v", err)
+		}
+		if record.Reason != want {
+			t.Errorf("%s reason = %q, want %q", filepath.Base(path), record.Reason, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// errAlreadyIngested ends the processing of a file of a read-only source
+// ingested by an earlier run and not modified since
+var errAlreadyIngested = errors.New("already ingested from a read-only source")
+
+// SetSources applies the options of the sources, top-level directories of
+// the input tree. Files of read-only sources are copied and left in place:
+// they are never removed, moved to the trash or quarantine, and get no
+// receipts. Only dedup keeps them from being ingested twice, so a dedup
+// window, which expires it, is rejected.
+func (p *Processor) SetSources(sources []config.SourceConfig) error {
+	readOnly := make(map[string]bool)
+	seen := make(map[string]bool, len(sources))
+	for i, s := range sources {
+		switch {
+		case s.Name == "":
+			return fmt.Errorf("source %d: empty name", i+1)
+		case s.Name == "." || s.Name == ".." || strings.ContainsAny(s.Name, `/\`):
+			return fmt.Errorf("source %q: want the name of a top-level input directory", s.Name)
+		case s.Name == config.TrashDirName:
+			return fmt.Errorf("source %q: the input trash is no source", s.Name)
+		case seen[s.Name]:
+			return fmt.Errorf("source %q: configured twice", s.Name)
+		}
+		seen[s.Name] = true
+		if !s.ReadOnly {
+			continue
+		}
+		if p.cfg.DedupWindow > 0 {
+			return fmt.Errorf("source %q: read-only sources rely on dedup, which a dedup window of %s expires", s.Name, p.cfg.DedupWindow)
+		}
+		readOnly[s.Name] = true
+	}
+	p.readOnly = readOnly
+	return nil
+}
+
+// keepsSource reports whether the source at path stays in place once
+// ingested
+func (p *Processor) keepsSource(path string) bool {
+	return p.cfg.KeepSource || p.readOnly[sourceOf(p.cfg.Path, path)]
+}
+
+// skipIngested reports whether the file at path is of a read-only source and
+// was ingested from the same path with the same size before it was last
+// modified. Such files are left in place and found again by every restart,
+// which then skips them without hashing. Files of a read-only source that
+// were duplicates are hashed again and rejected by dedup.
+func (p *Processor) skipIngested(path string) bool {
+	if !p.readOnly[sourceOf(p.cfg.Path, path)] {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	original := p.recordedAt(path, info.Size())
+	if original == nil || info.ModTime().After(original.CreatedAt) {
+		return false
+	}
+	slog.Debug("file of a read-only source already ingested, skipping",
+		"path", path,
+		"size", info.Size(),
+		"original_destination", original.DestPath,
+	)
+	p.watcher.RemoveFromTracking(path)
+	p.settle(path, status.Duplicate)
+	return true
+}
+
+// quarantineSource moves the source at path to dst in the quarantine, or
+// copies it there when its source is read-only
+func (p *Processor) quarantineSource(path, dst string) error {
+	if !p.readOnly[sourceOf(p.cfg.Path, path)] {
+		return fileops.MoveFileWithOptions(path, dst, p.copyOpts)
+	}
+	_, err := fileops.CopyFileWithOptions(path, dst, p.copyOpts)
+	return err
+}
@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// readOnlySnapshot writes files with contents into the source snap of env
+// and makes it read-only
+func readOnlySnapshot(t *testing.T, env *testEnv, contents map[string]string) string {
+	t.Helper()
+	snap := filepath.Join(env.inputDir, "snap")
+	if err := os.MkdirAll(snap, 0o755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(snap, name), []byte(content), 0o444); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	if err := os.Chmod(snap, 0o555); err != nil {
+		t.Fatalf("failed to make source read-only: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(snap, 0o755) })
+	return snap
+}
+
+// countStatuses counts the manifest entries of env by status
+func countStatuses(t *testing.T, env *testEnv) map[status.Status]int {
+	t.Helper()
+	counts := make(map[status.Status]int)
+	for _, e := range readManifest(t, env.manifestsDir) {
+		counts[e.Status]++
+	}
+	return counts
+}
+
+func TestReadOnlySource_CopiesAndSkipsOnRestart(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	// Sources of other directories would be trashed and get receipts
+	env.cfg.SourceGrace = time.Hour
+	env.cfg.WriteReceipts = true
+	sources := []config.SourceConfig{{Name: "snap", ReadOnly: true}}
+	if err := env.processor.SetSources(sources); err != nil {
+		t.Fatalf("SetSources() error = %v", err)
+	}
+
+	names := []string{"a.csv", "b.csv", "copy-of-a.csv"}
+	snap := readOnlySnapshot(t, env, map[string]string{"a.csv": "alpha", "b.csv": "bravo", "copy-of-a.csv": "alpha"})
+	for _, name := range names {
+		if err := env.processor.processFile(filepath.Join(snap, name)); err != nil {
+			t.Fatalf("processFile(%s) error = %v", name, err)
+		}
+	}
+
+	entries, err := os.ReadDir(snap)
+	if err != nil {
+		t.Fatalf("failed to list source: %v", err)
+	}
+	if len(entries) != len(names) {
+		t.Errorf("source holds %d files after ingestion, want the %d left in place without receipts", len(entries), len(names))
+	}
+	if _, err := os.Stat(filepath.Join(env.inputDir, config.TrashDirName)); !os.IsNotExist(err) {
+		t.Errorf("input trash created for a read-only source: %v", err)
+	}
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if _, err := os.Stat(filepath.Join(env.warehouseDir, "snap", name)); err != nil {
+			t.Errorf("%s not copied to the warehouse: %v", name, err)
+		}
+	}
+	if got := countStatuses(t, env); got[status.Ingested] != 2 || got[status.Duplicate] != 1 {
+		t.Errorf("manifest statuses = %v, want 2 ingested and 1 duplicate", got)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("ingesting a read-only source logged warnings:\n%s", logs.String())
+	}
+
+	// A restart finds every file again and skips those it ingested without
+	// hashing them; the duplicate is hashed and rejected again
+	env.processor = New(env.cfg, env.store, env.watcher)
+	if err := env.processor.SetSources(sources); err != nil {
+		t.Fatalf("SetSources() error = %v", err)
+	}
+	var c collector
+	env.processor.Subscribe(c.add)
+	for _, name := range names {
+		if err := env.processor.processFile(filepath.Join(snap, name)); err != nil {
+			t.Fatalf("processFile(%s) after restart error = %v", name, err)
+		}
+	}
+	// Subscribers are called asynchronously until Close delivers the rest
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	events := c.get()
+	if len(events) != 1 || events[0].Outcome != status.Duplicate || !strings.HasSuffix(events[0].Path, "copy-of-a.csv") {
+		t.Errorf("events after restart = %+v, want only the duplicate copy-of-a.csv", events)
+	}
+	if got := countStatuses(t, env); got[status.Ingested] != 2 || got[status.Duplicate] != 2 {
+		t.Errorf("manifest statuses after restart = %v, want 2 ingested and 2 duplicates", got)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("restarting over a read-only source logged warnings:\n%s", logs.String())
+	}
+}
+
+func TestReadOnlySource_RehashesModifiedFile(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.processor.SetSources([]config.SourceConfig{{Name: "snap", ReadOnly: true}}); err != nil {
+		t.Fatalf("SetSources() error = %v", err)
+	}
+	snap := readOnlySnapshot(t, env, map[string]string{"a.csv": "alpha"})
+	src := filepath.Join(snap, "a.csv")
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	// A new snapshot mounted over the old one, same name and size
+	if err := os.Chmod(src, 0o644); err != nil {
+		t.Fatalf("failed to make file writable: %v", err)
+	}
+	if err := os.WriteFile(src, []byte("ALPHA"), 0o644); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(src, later, later); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	if env.processor.skipIngested(src) {
+		t.Fatal("file modified after its ingestion skipped without hashing")
+	}
+}
+
+func TestSetSources_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []config.SourceConfig
+		window  time.Duration
+	}{
+		{"empty name", []config.SourceConfig{{ReadOnly: true}}, 0},
+		{"nested", []config.SourceConfig{{Name: "a/b", ReadOnly: true}}, 0},
+		{"parent", []config.SourceConfig{{Name: "..", ReadOnly: true}}, 0},
+		{"trash", []config.SourceConfig{{Name: config.TrashDirName}}, 0},
+		{"twice", []config.SourceConfig{{Name: "snap"}, {Name: "snap", ReadOnly: true}}, 0},
+		{"dedup window", []config.SourceConfig{{Name: "snap", ReadOnly: true}}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.DedupWindow = tt.window
+			if err := env.processor.SetSources(tt.sources); err == nil {
+				t.Error("SetSources() error = nil, want the incoherent sources rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Receipt is written back into the input directory so producers can see
+// what happened to their drop
+type Receipt struct {
+	Status      status.Status `json:"status"`
+	SHA256      string        `json:"sha256,omitempty"`
+	Destination string        `json:"destination,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Reason      string        `json:"reason,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// receiptPath returns where the receipt of src written at t goes: laid out
+// by the receipt template next to it, or in the receipts subdirectory of
+// its directory when one is configured
+func (p *Processor) receiptPath(src string, t time.Time) (string, error) {
+	rel, err := p.layout.Resolve(layout.Receipt, p.layoutContext(src, t))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(src), p.cfg.ReceiptsDir, rel), nil
+}
+
+// writeReceipt writes the receipt of src when receipts are enabled and its
+// source can be written. Callers
+// write it only after the source has been moved away, so a producer never
+// sees a receipt next to a file that is still in place. Failures are logged;
+// the ingest itself already succeeded.
+func (p *Processor) writeReceipt(src string, r Receipt) {
+	if !p.cfg.WriteReceipts || p.cfg.DryRun || p.readOnly[sourceOf(p.cfg.Path, src)] {
+		return
+	}
+	if !receiptStatus(r.Status) {
+		slog.Warn("status is not reported in receipts, skipping receipt", "path", src, "status", r.Status)
+		return
+	}
+	r.Timestamp = time.Now()
+
+	path, err := p.receiptPath(src, r.Timestamp)
+	if err != nil {
+		slog.Warn("failed to resolve receipt path", "path", src, "error", err)
+		return
+	}
+	if err := writeReceiptFile(path, r); err != nil {
+		slog.Warn("failed to write receipt", "path", src, "receipt", path, "error", err)
+		return
+	}
+	slog.Debug("receipt written", "path", src, "receipt", path, "status", r.Status)
+}
+
+// receiptStatus reports whether a receipt tells a producer s: the final
+// outcomes of a drop. Failed drops are retried and vanished ones have no
+// producer waiting; the other statuses are given to records later.
+func receiptStatus(s status.Status) bool {
+	switch s {
+	case status.Ingested, status.Duplicate, status.Quarantined:
+		return true
+	case status.Failed, status.Vanished, status.DuplicateSummary, status.Adopted, status.Suspect,
+		status.MissingRestored, status.Superseded:
+	}
+	return false
+}
+
+// writeReceiptFile writes r to path atomically through a temporary file,
+// which the watcher ignores like the receipt itself
+func writeReceiptFile(path string, r Receipt) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create receipts directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode receipt: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write receipt: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("commit receipt: %w", err)
+	}
+	return nil
+}
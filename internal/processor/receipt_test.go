@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// readReceipt decodes the receipt at path
+func readReceipt(t *testing.T, path string) Receipt {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read receipt: %v", err)
+	}
+	var r Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	return r
+}
+
+func TestProcessFile_WritesReceipts(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WriteReceipts = true
+
+	first := filepath.Join(env.inputDir, "a.csv")
+	second := filepath.Join(env.inputDir, "b.csv")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("same content"), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+	}
+	hash, err := fileops.CalculateSHA256(first)
+	if err != nil {
+		t.Fatalf("failed to hash test file: %v", err)
+	}
+
+	for _, path := range []string{first, second} {
+		if err := env.processor.processFile(path); err != nil {
+			t.Fatalf("processFile() error = %v", err)
+		}
+	}
+
+	dst := filepath.Join(env.warehouseDir, "a.csv")
+	ingested := readReceipt(t, first+".receipt.json")
+	if ingested.Status != status.Ingested || ingested.SHA256 != hash || ingested.Destination != dst {
+		t.Errorf("ingest receipt = %+v", ingested)
+	}
+	if ingested.Timestamp.IsZero() {
+		t.Error("receipt timestamp should be set")
+	}
+
+	duplicate := readReceipt(t, second+".receipt.json")
+	if duplicate.Status != status.Duplicate || duplicate.SHA256 != hash || duplicate.Destination != dst {
+		t.Errorf("duplicate receipt = %+v", duplicate)
+	}
+
+	// No temporary receipt is left behind
+	matches, err := filepath.Glob(filepath.Join(env.inputDir, "*.tmp"))
+	if err != nil || len(matches) != 0 {
+		t.Errorf("leftover temporary files: %v (%v)", matches, err)
+	}
+}
+
+func TestProcessFile_QuarantineReceiptInSubdirectory(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WriteReceipts = true
+	env.cfg.ReceiptsDir = "_receipts"
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+	env.processor.limits = pathLimits{maxName: 64, maxPath: 4096}
+
+	name := strings.Repeat("x", 100) + ".csv"
+	src := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(src, []byte("too long"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	r := readReceipt(t, filepath.Join(env.inputDir, "_receipts", name+".receipt.json"))
+	if r.Status != status.Quarantined || r.Reason != ReasonPathTooLong || r.Error == "" {
+		t.Errorf("quarantine receipt = %+v", r)
+	}
+	if !strings.HasPrefix(r.Destination, env.cfg.QuarantinePath) {
+		t.Errorf("Destination = %q, want a path in %q", r.Destination, env.cfg.QuarantinePath)
+	}
+}
+
+func TestProcessFile_NoReceiptsByDefault(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	src := filepath.Join(env.inputDir, "a.csv")
+	if err := os.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if _, err := os.Stat(src + ".receipt.json"); !os.IsNotExist(err) {
+		t.Errorf("receipt should not be written unless enabled, stat error = %v", err)
+	}
+}
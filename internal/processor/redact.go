@@ -0,0 +1,15 @@
+package processor
+
+import "github.com/1995parham-learning/atomic-ingestor/internal/redact"
+
+// SetRedactor pseudonymizes sensitive names in published events, and so in
+// recent files and stats, and, when manifests is set, in manifest entries.
+// The database keeps full names. Logs are redacted by wrapping the slog
+// handler with redact.NewHandler. Call after SetManifest.
+func (p *Processor) SetRedactor(r *redact.Redactor, manifests bool) {
+	p.redactor = r
+	if r != nil && manifests {
+		p.manifest.SetRedaction(r.Text)
+		p.manifest.SetSourceRoot(r.Text(p.cfg.Path))
+	}
+}
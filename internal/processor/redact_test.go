@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+)
+
+// redactedRun ingests files with sensitive names under a redactor and
+// returns what reached the logs, the events and the manifests
+func redactedRun(t *testing.T, manifests bool) (env *testEnv, logs string, events []string, manifest string) {
+	t.Helper()
+	r, err := redact.New([]string{"Jane*"}, []string{"clinic"}, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("redact.New() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(redact.NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), r)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	env = setupTestEnv(t)
+	t.Cleanup(env.cleanup)
+	env.processor.SetRedactor(r, manifests)
+	var c collector
+	env.processor.Subscribe(c.add)
+
+	if err := os.MkdirAll(filepath.Join(env.inputDir, "clinic"), 0o755); err != nil {
+		t.Fatalf("failed to create tenant directory: %v", err)
+	}
+	ingest(t, env, "Jane Doe.csv", "patient data")
+	ingest(t, env, "clinic/anna.csv", "tenant data")
+	ingest(t, env, "copy of Jane.csv", "patient data")
+	_ = env.processor.processFile(filepath.Join(env.inputDir, "Jane Missing.csv"))
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for _, e := range c.get() {
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("failed to encode event: %v", err)
+		}
+		events = append(events, string(data))
+	}
+	err = filepath.Walk(env.manifestsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		manifest += string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read manifests: %v", err)
+	}
+	return env, buf.String(), events, manifest
+}
+
+func TestRedaction_NoNameEscapes(t *testing.T) {
+	env, logs, events, manifest := redactedRun(t, true)
+
+	if len(events) != 4 || logs == "" || manifest == "" {
+		t.Fatalf("got %d events, %d bytes of logs and %d of manifests", len(events), len(logs), len(manifest))
+	}
+	for _, raw := range []string{"Jane", "anna"} {
+		if strings.Contains(logs, raw) {
+			t.Errorf("logs leak %q:\n%s", raw, logs)
+		}
+		if strings.Contains(manifest, raw) {
+			t.Errorf("manifest leaks %q:\n%s", raw, manifest)
+		}
+		for _, e := range events {
+			if strings.Contains(e, raw) {
+				t.Errorf("event leaks %q: %s", raw, e)
+			}
+		}
+	}
+	if !strings.Contains(manifest, redact.Prefix) {
+		t.Error("manifest should carry pseudonyms")
+	}
+
+	// The state database keeps the full name
+	record, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "Jane Doe.csv"))
+	if err != nil || record == nil || record.Name != "Jane Doe.csv" {
+		t.Errorf("database record = %+v, %v, want the full name", record, err)
+	}
+}
+
+func TestRedaction_ManifestKeepsNames(t *testing.T) {
+	_, logs, events, manifest := redactedRun(t, false)
+
+	if !strings.Contains(manifest, "Jane Doe.csv") || !strings.Contains(manifest, "clinic/anna.csv") {
+		t.Errorf("manifest should keep full names without manifest redaction:\n%s", manifest)
+	}
+	if strings.Contains(logs, "Jane") {
+		t.Error("logs are redacted whatever the manifest setting")
+	}
+	for _, e := range events {
+		if strings.Contains(e, "Jane") {
+			t.Errorf("event leaks a name: %s", e)
+		}
+	}
+}
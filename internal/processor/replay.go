@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// ReplayOptions selects the manifest entries Replay notifies again
+type ReplayOptions struct {
+	// From and To bound the processing time of the entries, inclusive
+	From, To time.Time
+	// Statuses keeps the entries of these statuses; empty keeps every
+	// entry of a file outcome
+	Statuses []status.Status
+	// Source keeps the entries of this top-level directory of Root, as the
+	// manifest records it; empty keeps every source
+	Source string
+	// Root is the input directory of the daemon the entries came from
+	Root string
+	// Redact, when set, pseudonymizes names as the daemon's events do. Leave
+	// it nil for manifests written pseudonymized.
+	Redact func(string) string
+	// Interval is the least time between two sends; 0 sends back to back
+	Interval time.Duration
+	// Progress, when set, is called after every send
+	Progress func(ReplayResult)
+}
+
+// ReplayResult counts what a Replay did
+type ReplayResult struct {
+	// Read counts the entries in the time range
+	Read int `json:"read"`
+	// Matched counts the entries kept by the filters
+	Matched int `json:"matched"`
+	Sent    int `json:"sent"`
+}
+
+// Replay sends the events of the manifest entries matching opts through
+// sender again, in manifest order, as the daemon would have. Each event is
+// marked replayed and keeps the time its file was processed; its entry
+// carries the sequence number and hash that identify the ingestion, by
+// which consumers deduplicate. Entries without a file outcome, such as the
+// summaries of resend storms, are skipped. The first failed send ends the
+// replay, the result counting what was sent before it.
+func Replay(ctx context.Context, entries manifest.Reader, sender outbox.Sender, opts ReplayOptions) (ReplayResult, error) {
+	var res ReplayResult
+	found, err := entries.ManifestEntries(opts.From, opts.To)
+	if err != nil {
+		return res, fmt.Errorf("read manifest entries: %w", err)
+	}
+	res.Read = len(found)
+
+	redact := opts.Redact
+	if redact == nil {
+		redact = func(s string) string { return s }
+	}
+	for _, entry := range found {
+		outcome := replayOutcome(entry)
+		kind, ok := eventKinds[outcome]
+		if !ok || (len(opts.Statuses) > 0 && !slices.Contains(opts.Statuses, outcome)) {
+			continue
+		}
+		source := entrySource(opts.Root, entry)
+		if opts.Source != "" && source != opts.Source {
+			continue
+		}
+		res.Matched++
+
+		if res.Sent > 0 && opts.Interval > 0 {
+			select {
+			case <-ctx.Done():
+				return res, ctx.Err()
+			case <-time.After(opts.Interval):
+			}
+		}
+		redacted := entry.Redacted(redact)
+		e := Event{
+			Kind:     kind,
+			Path:     redact(entry.SourcePath),
+			Source:   redact(source),
+			SHA256:   entry.SHA256,
+			Outcome:  outcome,
+			Error:    redact(entry.Reason),
+			At:       entry.ProcessedAt,
+			Entry:    &redacted,
+			Replayed: true,
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return res, fmt.Errorf("encode notification for %s: %w", entry.SourcePath, err)
+		}
+		msg := storage.OutboxMessage{CreatedAt: e.At, Kind: e.Kind, SHA256: e.SHA256, Payload: string(payload)}
+		if err := sender.Send(ctx, msg); err != nil {
+			return res, fmt.Errorf("send notification for %s: %w", entry.SourcePath, err)
+		}
+		res.Sent++
+		if opts.Progress != nil {
+			opts.Progress(res)
+		}
+	}
+	return res, nil
+}
+
+// replayOutcome returns the outcome the event of entry reported. Entries
+// written before statuses were recorded are all of ingested files.
+func replayOutcome(entry manifest.Entry) status.Status {
+	if entry.Status == "" {
+		return status.Ingested
+	}
+	return entry.Status
+}
+
+// entrySource returns the source of entry, from its path relative to the
+// input when recorded
+func entrySource(root string, entry manifest.Entry) string {
+	if entry.SourceRelPath == "" {
+		return sourceOf(root, entry.SourcePath)
+	}
+	source, _, found := strings.Cut(entry.SourceRelPath, "/")
+	if !found {
+		return "."
+	}
+	return source
+}
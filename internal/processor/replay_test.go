@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// recordingSender records the messages sent to it, failing from the
+// failAt-th one when set
+type recordingSender struct {
+	msgs   []storage.OutboxMessage
+	sentAt []time.Time
+	failAt int
+}
+
+func (s *recordingSender) Send(_ context.Context, msg storage.OutboxMessage) error {
+	if s.failAt > 0 && len(s.msgs)+1 >= s.failAt {
+		return errors.New("receiver down")
+	}
+	s.msgs = append(s.msgs, msg)
+	s.sentAt = append(s.sentAt, time.Now())
+	return nil
+}
+
+func (s *recordingSender) events(t *testing.T) []Event {
+	t.Helper()
+	events := make([]Event, 0, len(s.msgs))
+	for _, msg := range s.msgs {
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+			t.Fatalf("payload %s does not decode: %v", msg.Payload, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// writeReplayManifest writes a synthetic day of entries, two hours apart,
+// and returns a reader of them
+func writeReplayManifest(t *testing.T) (manifest.Reader, time.Time) {
+	t.Helper()
+	w := manifest.NewWriter(t.TempDir())
+	w.SetSourceRoot("/in")
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	entries := []manifest.Entry{
+		{Name: "a.csv", SourcePath: "/in/acme/a.csv", Status: status.Ingested},
+		{Name: "b.csv", SourcePath: "/in/globex/b.csv", Status: status.Ingested},
+		{Name: "c.csv", SourcePath: "/in/acme/c.csv", Status: status.Duplicate},
+		{Name: "acme", SourcePath: "/in/acme", Status: status.DuplicateSummary, Members: 3},
+		{Name: "d.csv", SourcePath: "/in/acme/d.csv", Status: status.Quarantined, Reason: "invalid_csv"},
+		{Name: "e.csv", SourcePath: "/in/acme/e.csv", Status: status.Ingested},
+	}
+	for i, e := range entries {
+		e.SHA256 = strings.Repeat(string(rune('a'+i)), 64)
+		e.ProcessedAt = day.Add(time.Duration(2*i) * time.Hour)
+		e.Sequence = int64(i + 1)
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w, day
+}
+
+func TestReplay_FiltersInManifestOrder(t *testing.T) {
+	entries, day := writeReplayManifest(t)
+	sender := &recordingSender{}
+	res, err := Replay(t.Context(), entries, sender, ReplayOptions{
+		From:     day.Add(time.Hour),
+		To:       day.Add(10 * time.Hour),
+		Statuses: []status.Status{status.Ingested, status.Quarantined},
+		Source:   "acme",
+		Root:     "/in",
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	// a.csv is before the range and c.csv a duplicate; e.csv, at 10:00, is
+	// the inclusive end
+	if res.Read != 5 || res.Matched != 2 || res.Sent != 2 {
+		t.Errorf("Replay() = %+v, want 5 read and d.csv and e.csv sent", res)
+	}
+	events := sender.events(t)
+	if len(events) != 2 {
+		t.Fatalf("sent %d notifications, want 2", len(events))
+	}
+	want := []struct {
+		path    string
+		kind    string
+		outcome status.Status
+		at      time.Time
+		seq     int64
+	}{
+		{"/in/acme/d.csv", EventFileQuarantined, status.Quarantined, day.Add(8 * time.Hour), 5},
+		{"/in/acme/e.csv", EventFileIngested, status.Ingested, day.Add(10 * time.Hour), 6},
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Path != w.path || e.Kind != w.kind || e.Outcome != w.outcome || e.Source != "acme" {
+			t.Errorf("event %d = %s %s %s from %s, want %s %s %s", i, e.Kind, e.Path, e.Outcome, e.Source, w.kind, w.path, w.outcome)
+		}
+		if !e.Replayed {
+			t.Errorf("event %d is not marked replayed", i)
+		}
+		if !e.At.Equal(w.at) || !sender.msgs[i].CreatedAt.Equal(w.at) {
+			t.Errorf("event %d at %s, want the original processed_at %s", i, e.At, w.at)
+		}
+		if e.Entry == nil || e.Entry.Sequence != w.seq || e.Entry.SHA256 != e.SHA256 {
+			t.Errorf("event %d entry = %+v, want the original entry of sequence %d", i, e.Entry, w.seq)
+		}
+		if sender.msgs[i].Kind != w.kind || sender.msgs[i].ID != 0 {
+			t.Errorf("message %d = %+v, want kind %s without an outbox ID", i, sender.msgs[i], w.kind)
+		}
+	}
+	if events[0].Error != "invalid_csv" {
+		t.Errorf("quarantine event error = %q, want its reason", events[0].Error)
+	}
+}
+
+func TestReplay_EveryFileOutcome(t *testing.T) {
+	entries, day := writeReplayManifest(t)
+	sender := &recordingSender{}
+	res, err := Replay(t.Context(), entries, sender, ReplayOptions{
+		From:   day,
+		To:     day.Add(24 * time.Hour),
+		Root:   "/in",
+		Redact: strings.ToUpper,
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	// The storm summary has no event of its own
+	if res.Read != 6 || res.Sent != 5 {
+		t.Errorf("Replay() = %+v, want every entry but the storm summary sent", res)
+	}
+	events := sender.events(t)
+	for i := 1; i < len(events); i++ {
+		if events[i].Entry.Sequence <= events[i-1].Entry.Sequence {
+			t.Errorf("events out of manifest order: %d after %d", events[i].Entry.Sequence, events[i-1].Entry.Sequence)
+		}
+	}
+	if len(events) > 0 && (events[0].Path != "/IN/ACME/A.CSV" || events[0].Entry.SourcePath != "/IN/ACME/A.CSV") {
+		t.Errorf("event = %s, entry %s, want redacted names", events[0].Path, events[0].Entry.SourcePath)
+	}
+}
+
+func TestReplay_RateLimitedAndStopsOnFailure(t *testing.T) {
+	entries, day := writeReplayManifest(t)
+	sender := &recordingSender{failAt: 4}
+	var progress []int
+	res, err := Replay(t.Context(), entries, sender, ReplayOptions{
+		From:     day,
+		To:       day.Add(24 * time.Hour),
+		Root:     "/in",
+		Interval: 30 * time.Millisecond,
+		Progress: func(r ReplayResult) { progress = append(progress, r.Sent) },
+	})
+	if err == nil {
+		t.Fatal("Replay() succeeded against a failing receiver")
+	}
+	if res.Sent != 3 || len(progress) != 3 || progress[2] != 3 {
+		t.Errorf("Replay() = %+v with progress %v, want the 3 sends before the failure", res, progress)
+	}
+	for i := 1; i < len(sender.sentAt); i++ {
+		if gap := sender.sentAt[i].Sub(sender.sentAt[i-1]); gap < 30*time.Millisecond {
+			t.Errorf("sends %d and %d %s apart, want at least the interval", i-1, i, gap)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// Rescan has the watcher rescan the directory at path, relative to the
+// input directory, for files it missed. Files ingested already from the
+// same path with the same size are left out, as the dedup options would
+// take them for duplicates. With forceReady the files of the directory are
+// ready on the next pass regardless of their stability window.
+func (p *Processor) Rescan(ctx context.Context, path string, forceReady bool) (watcher.RescanResult, error) {
+	res, err := p.watcher.Rescan(ctx, path, watcher.RescanOptions{
+		ForceReady: forceReady,
+		Ingested: func(path string, size int64) bool {
+			return p.recordedAt(path, size) != nil
+		},
+	})
+	if err != nil {
+		return res, err
+	}
+	slog.Info("rescanned directory",
+		"path", res.Path,
+		"walked", res.Walked,
+		"added", res.Added,
+		"already_tracked", res.AlreadyTracked,
+		"already_ingested", res.AlreadyIngested,
+		"waiting", res.Waiting,
+		"forced_ready", res.ForcedReady,
+	)
+	return res, nil
+}
@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// Reservation is the space held for a file being copied into the warehouse
+type Reservation struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	Since time.Time `json:"since"`
+}
+
+// SpaceLedger is a snapshot of the warehouse space reservations
+type SpaceLedger struct {
+	Path string `json:"path"`
+	// Free is what statfs reported at the last reservation or release, and
+	// Reserved what the copies in flight hold of it
+	Free         int64         `json:"free"`
+	Reserved     int64         `json:"reserved"`
+	Reservations []Reservation `json:"reservations"`
+	// Waiting counts the files waiting for reservations to be released
+	Waiting int `json:"waiting"`
+}
+
+// spaceLedger reserves the size of each copy against the free space of the
+// warehouse filesystem, so concurrent copies do not all pass a free-space
+// check only to run out of space halfway. Copies in flight count twice, as
+// written and as reserved, which errs on the side of waiting.
+type spaceLedger struct {
+	root string
+	// freeSpace is statfs; tests replace it to simulate a full disk
+	freeSpace func(path string) (int64, error)
+
+	mu           sync.Mutex
+	free         int64
+	reserved     int64
+	reservations map[*Reservation]struct{}
+	waiting      int
+	// released is closed and replaced whenever a reservation is released
+	released chan struct{}
+}
+
+func newSpaceLedger(root string) *spaceLedger {
+	return &spaceLedger{
+		root:         root,
+		freeSpace:    fileops.FreeSpace,
+		reservations: make(map[*Reservation]struct{}),
+		released:     make(chan struct{}),
+	}
+}
+
+// reserve holds size bytes for path, waiting while reservations of other
+// copies keep them from fitting. It returns ErrInsufficientSpace when the
+// space is missing with nothing left to release, and the function releasing
+// the reservation otherwise.
+func (l *spaceLedger) reserve(ctx context.Context, path string, size int64) (func(), error) {
+	logged := false
+	for {
+		l.mu.Lock()
+		free, err := l.freeSpace(existingAncestor(l.root))
+		if err != nil {
+			l.mu.Unlock()
+			return nil, fmt.Errorf("check warehouse space for %s: %w", path, err)
+		}
+		l.free = free
+		if free-l.reserved >= size {
+			r := &Reservation{Path: path, Size: size, Since: time.Now()}
+			l.reservations[r] = struct{}{}
+			l.reserved += size
+			l.mu.Unlock()
+			return sync.OnceFunc(func() { l.release(r) }), nil
+		}
+		if len(l.reservations) == 0 {
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s needs %d bytes, %d free in %s", ErrInsufficientSpace, path, size, free, l.root)
+		}
+		l.waiting++
+		released := l.released
+		reserved := l.reserved
+		l.mu.Unlock()
+
+		if !logged {
+			slog.Info("waiting for warehouse space reserved by other copies",
+				"path", path,
+				"size", size,
+				"free", free,
+				"reserved", reserved,
+			)
+			logged = true
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+		}
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *spaceLedger) release(r *Reservation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.reservations, r)
+	l.reserved -= r.Size
+	if free, err := l.freeSpace(existingAncestor(l.root)); err == nil {
+		l.free = free
+	}
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *spaceLedger) snapshot() SpaceLedger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := SpaceLedger{
+		Path:         l.root,
+		Free:         l.free,
+		Reserved:     l.reserved,
+		Reservations: make([]Reservation, 0, len(l.reservations)),
+		Waiting:      l.waiting,
+	}
+	for r := range l.reservations {
+		s.Reservations = append(s.Reservations, *r)
+	}
+	sort.Slice(s.Reservations, func(i, j int) bool { return s.Reservations[i].Since.Before(s.Reservations[j].Since) })
+	return s
+}
+
+// reserveSpace reserves the warehouse space a source of size bytes takes.
+// Files moved by a rename take none, so only copies reserve: copy-first,
+// kept or trashed sources and an input on another filesystem.
+func (p *Processor) reserveSpace(path string, size int64) (func(), error) {
+	if p.cfg.DryRun || !p.copiesSource(path) {
+		return func() {}, nil
+	}
+	return p.space.reserve(p.ctx, path, size)
+}
+
+// copiesSource reports whether ingesting the source at path copies its data
+func (p *Processor) copiesSource(path string) bool {
+	if p.cfg.CopyFirst || !p.removesSource(path) {
+		return true
+	}
+	p.sameFSOnce.Do(func() {
+		same, err := fileops.SameFilesystem(p.cfg.Path, existingAncestor(p.cfg.Destination))
+		if err != nil {
+			slog.Warn("failed to compare input and warehouse filesystems, reserving space for every file", "error", err)
+		}
+		p.sameFS = same
+	})
+	return !p.sameFS
+}
+
+// existingAncestor returns path, or its closest ancestor that exists when
+// path is not created yet, as the warehouse is on its first copy
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// SpaceReservations returns the warehouse space reservations of the copies
+// in flight
+func (p *Processor) SpaceReservations() SpaceLedger {
+	s := p.space.snapshot()
+	for i := range s.Reservations {
+		s.Reservations[i].Path = p.redactor.Text(s.Reservations[i].Path)
+	}
+	return s
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixedFree reports free bytes on every filesystem
+func fixedFree(free int64) func(string) (int64, error) {
+	return func(string) (int64, error) { return free, nil }
+}
+
+func TestSpaceLedger_SecondCopyWaits(t *testing.T) {
+	l := newSpaceLedger("/warehouse")
+	l.freeSpace = fixedFree(100)
+
+	release, err := l.reserve(context.Background(), "/input/first", 80)
+	if err != nil {
+		t.Fatalf("reserve(first) error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		release, err := l.reserve(context.Background(), "/input/second", 80)
+		if err == nil {
+			defer release()
+		}
+		done <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.snapshot().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("second reservation should wait for the first")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("second reservation returned %v before the first was released", err)
+	default:
+	}
+	if s := l.snapshot(); s.Reserved != 80 || len(s.Reservations) != 1 || s.Reservations[0].Path != "/input/first" {
+		t.Errorf("snapshot = %+v, want the first reservation", s)
+	}
+
+	release()
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("reserve(second) error = %v", err)
+	}
+	if s := l.snapshot(); s.Reserved != 0 || len(s.Reservations) != 0 || s.Waiting != 0 {
+		t.Errorf("snapshot = %+v, want every reservation released", s)
+	}
+}
+
+func TestSpaceLedger_InsufficientWithNothingReserved(t *testing.T) {
+	l := newSpaceLedger("/warehouse")
+	l.freeSpace = fixedFree(100)
+
+	if _, err := l.reserve(context.Background(), "/input/huge", 101); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("reserve() error = %v, want ErrInsufficientSpace", err)
+	}
+}
+
+func TestSpaceLedger_WaitCanceled(t *testing.T) {
+	l := newSpaceLedger("/warehouse")
+	l.freeSpace = fixedFree(100)
+	release, err := l.reserve(context.Background(), "/input/first", 80)
+	if err != nil {
+		t.Fatalf("reserve(first) error = %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.reserve(ctx, "/input/second", 80); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("reserve() error = %v, want the wait canceled", err)
+	}
+	if s := l.snapshot(); s.Waiting != 0 || s.Reserved != 80 {
+		t.Errorf("snapshot = %+v, want only the first reservation", s)
+	}
+}
+
+func TestProcessFile_ReservesWarehouseSpace(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.KeepSource = true
+	env.processor.space.freeSpace = fixedFree(4)
+
+	src := filepath.Join(env.inputDir, "big.csv")
+	if err := os.WriteFile(src, []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := env.processor.processFile(src); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("processFile() error = %v, want ErrInsufficientSpace", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "big.csv")); !os.IsNotExist(err) {
+		t.Error("no copy should start without the space for it")
+	}
+
+	env.processor.space.freeSpace = fixedFree(1 << 20)
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "big.csv")); err != nil {
+		t.Errorf("file should be ingested once there is space: %v", err)
+	}
+	if s := env.processor.SpaceReservations(); s.Reserved != 0 || len(s.Reservations) != 0 {
+		t.Errorf("reservations = %+v, want them released once ingested", s)
+	}
+}
+
+func TestProcessFile_RenameReservesNothing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.processor.space.freeSpace = fixedFree(0)
+
+	ingest(t, env, "moved.csv", "0123456789")
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "moved.csv")); err != nil {
+		t.Errorf("a rename within the filesystem needs no free space: %v", err)
+	}
+}
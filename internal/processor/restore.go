@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// existenceCacheLimit bounds the warehouse copies remembered as present
+const existenceCacheLimit = 4096
+
+// existenceCache remembers warehouse copies recently found present, so that
+// a burst of duplicates of the same content checks its copy once. Only
+// positive results are kept: a missing copy is acted on immediately.
+type existenceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// fresh reports whether path was found present less than ttl before now
+func (c *existenceCache) fresh(path string, ttl time.Duration, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at, ok := c.seen[path]
+	return ok && now.Sub(at) < ttl
+}
+
+// add remembers path as present at now, first dropping expired paths when
+// the cache is full, or all of them when none has expired
+func (c *existenceCache) add(path string, ttl time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	if len(c.seen) >= existenceCacheLimit {
+		for p, at := range c.seen {
+			if now.Sub(at) >= ttl {
+				delete(c.seen, p)
+			}
+		}
+		if len(c.seen) >= existenceCacheLimit {
+			clear(c.seen)
+		}
+	}
+	c.seen[path] = now
+}
+
+// forget drops path, whose copy was found missing or replaced
+func (c *existenceCache) forget(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, path)
+}
+
+// warehouseCopyExists reports whether the warehouse copy at path exists. A
+// copy that cannot be checked is assumed present, so an unreadable
+// warehouse skips duplicates as before rather than ingesting them twice.
+func warehouseCopyExists(path string) bool {
+	_, err := os.Lstat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("failed to check warehouse copy of duplicate, assuming it exists", "destination", path, "error", err)
+		return true
+	}
+	return err == nil
+}
+
+// originalMissing checks, when duplicates are verified or the duplicate is a
+// leftover of original's source, whether the warehouse copy of original is
+// gone, as after a manual delete. If it is, the duplicate is the only copy of
+// the content left: it continues through the pipeline and its claim marks
+// original missing_restored, releasing its hash and key, so the file is
+// ingested in its place.
+func (p *Processor) originalMissing(fc *FileContext, original *storage.File) bool {
+	// A leftover is the only copy when the ingestor stopped between claiming
+	// and moving it, so it is never trusted to the cache
+	leftover := original.Path == fc.SourcePath
+	if original.DestPath == "" || (!p.cfg.VerifyDuplicates && !leftover) {
+		return false
+	}
+	now := time.Now()
+	if !leftover && p.present.fresh(original.DestPath, p.cfg.DuplicateCheckCache, now) {
+		return false
+	}
+	if p.copyExists(original.DestPath) {
+		if p.cfg.DuplicateCheckCache > 0 {
+			p.present.add(original.DestPath, p.cfg.DuplicateCheckCache, now)
+		}
+		return false
+	}
+
+	slog.Error("warehouse copy of duplicate missing, ingesting the new arrival to restore it",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"size", fc.Size(),
+		"original", original.Path,
+		"original_sha256", original.SHA256,
+		"original_destination", original.DestPath,
+		"original_processed_at", original.ProcessedAt,
+	)
+	fc.Restores = original
+	return true
+}
+
+// markRestored marks the original a file restores missing_restored in tx,
+// before the file's own record takes its hash. Callers check fc.Restores.
+func (p *Processor) markRestored(tx *storage.Storage, fc *FileContext) error {
+	if err := tx.MarkMissingRestored(fc.Restores); err != nil {
+		return err
+	}
+	p.present.forget(fc.Restores.DestPath)
+	return nil
+}
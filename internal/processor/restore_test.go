@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestVerifyDuplicates_RestoresMissingCopy(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VerifyDuplicates = true
+
+	content := "a,b\n1,2\n"
+	ingest(t, env, "data.csv", content)
+	original, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "data.csv"))
+	if err != nil || original == nil {
+		t.Fatalf("original record missing: %v", err)
+	}
+	if err := os.Remove(original.DestPath); err != nil {
+		t.Fatalf("failed to remove warehouse copy: %v", err)
+	}
+
+	ingest(t, env, "copy.csv", content)
+
+	restored := filepath.Join(env.warehouseDir, "copy.csv")
+	if data, err := os.ReadFile(restored); err != nil || string(data) != content {
+		t.Fatalf("duplicate not ingested to restore the content: %q, %v", data, err)
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 0 {
+		t.Errorf("restoring arrival recorded as a duplicate: %+v", dups)
+	}
+	entry := entryOf(t, env, "copy.csv")
+	if entry.Status != status.Ingested || entry.Restores != original.DestPath {
+		t.Errorf("entry = %+v, want ingested restoring %s", entry, original.DestPath)
+	}
+
+	marked, err := env.store.ListFiles(storage.FileFilter{Status: status.MissingRestored})
+	if err != nil || len(marked) != 1 || marked[0].ID != original.ID {
+		t.Fatalf("missing_restored = %+v, %v, want the original", marked, err)
+	}
+	rec, err := env.store.FindBySHA256(original.SHA256)
+	if err != nil || rec == nil || rec.DestPath != restored {
+		t.Errorf("hash should now point at the restored copy, got %+v, %v", rec, err)
+	}
+	if n := env.processor.Stats().RestoredDuplicates; n != 1 {
+		t.Errorf("RestoredDuplicates = %d, want 1", n)
+	}
+
+	// The restored copy is present, so the next arrival is a duplicate again
+	ingest(t, env, "again.csv", content)
+	if dups := duplicateEntries(t, env); len(dups) != 1 || dups[0].DestPath != restored {
+		t.Errorf("duplicate entries = %+v, want one of the restored copy", dups)
+	}
+}
+
+func TestVerifyDuplicates_Off(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	ingest(t, env, "data.csv", "a,b\n")
+	if err := os.Remove(filepath.Join(env.warehouseDir, "data.csv")); err != nil {
+		t.Fatalf("failed to remove warehouse copy: %v", err)
+	}
+	ingest(t, env, "copy.csv", "a,b\n")
+
+	if dups := duplicateEntries(t, env); len(dups) != 1 {
+		t.Errorf("duplicate entries = %+v, want the arrival skipped unverified", dups)
+	}
+}
+
+func TestVerifyDuplicates_CachesPresentCopies(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VerifyDuplicates = true
+	env.cfg.DuplicateCheckCache = config.DefaultDuplicateCache
+	checks := 0
+	env.processor.copyExists = func(path string) bool {
+		checks++
+		return warehouseCopyExists(path)
+	}
+
+	ingest(t, env, "data.csv", "a,b\n")
+	for _, name := range []string{"copy1.csv", "copy2.csv", "copy3.csv"} {
+		ingest(t, env, name, "a,b\n")
+	}
+
+	if checks != 1 {
+		t.Errorf("warehouse copy checked %d times, want once", checks)
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 3 {
+		t.Errorf("duplicate entries = %d, want 3", len(dups))
+	}
+}
@@ -0,0 +1,305 @@
+package processor
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TenantStats reports the load of one tenant, a top-level directory of the
+// input tree
+type TenantStats struct {
+	// InFlight counts files handed to the workers and not finished yet
+	InFlight int `json:"in_flight"`
+	// Backlog counts ready files waiting for their turn
+	Backlog int `json:"backlog"`
+}
+
+// scheduler hands the ready files of a pass to the workers. Files of a
+// higher priority class go first, the longest waiting first when files are
+// aged. Files of one priority are handed out round-robin across tenants, so
+// a tenant with a large backlog cannot delay the files of the others until
+// it is drained. With a per-tenant cap, a tenant at its cap is skipped until
+// one of its files finishes, and with reserved slots the files below the
+// highest priority present only take the slots left over, so bulk traffic
+// cannot occupy every worker when urgent files arrive.
+type scheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// levels are the priorities of the pass, highest first
+	levels []*priorityLevel
+	// tenants lists the tenants in order of their first ready file
+	tenants  []string
+	inFlight map[string]int
+	// files are the files waiting or in flight
+	files        map[string]queuedFile
+	tenantOf     func(string) string
+	maxPerTenant int
+	order        dispatchOrder
+}
+
+// dispatchOrder ranks the files of a pass. The zero value keeps every file
+// at one priority in the order it became ready.
+type dispatchOrder struct {
+	classify func(string) PriorityClass
+	// ageOf returns when a file was last written
+	ageOf func(string) time.Time
+	// slots is how many files the pipeline holds at once, and reserved how
+	// many of them files below the highest priority present leave free
+	slots, reserved int
+}
+
+// queuedFile is a file of a pass with its tenant and class
+type queuedFile struct {
+	path     string
+	tenant   string
+	class    string
+	priority int
+	age      time.Time
+}
+
+// priorityLevel holds the files of one priority, queued per tenant
+type priorityLevel struct {
+	priority int
+	tenants  []string
+	queues   map[string][]queuedFile
+	// cursor is the tenant considered first by the next pick
+	cursor   int
+	inFlight int
+}
+
+func newScheduler(files []string, tenantOf func(string) string, maxPerTenant int) *scheduler {
+	return newOrderedScheduler(files, tenantOf, maxPerTenant, dispatchOrder{})
+}
+
+func newOrderedScheduler(files []string, tenantOf func(string) string, maxPerTenant int, order dispatchOrder) *scheduler {
+	s := &scheduler{
+		inFlight:     make(map[string]int),
+		files:        make(map[string]queuedFile, len(files)),
+		tenantOf:     tenantOf,
+		maxPerTenant: maxPerTenant,
+		order:        order,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.push(files)
+	return s
+}
+
+// add queues files that became ready during the pass
+func (s *scheduler) add(files []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.push(files)
+	s.cond.Broadcast()
+}
+
+func (s *scheduler) push(files []string) {
+	for _, path := range files {
+		f := queuedFile{path: path, tenant: s.tenantOf(path)}
+		if s.order.classify != nil {
+			c := s.order.classify(path)
+			f.class, f.priority = c.Name, c.Priority
+		}
+		if s.order.ageOf != nil {
+			f.age = s.order.ageOf(path)
+		}
+		if _, ok := s.inFlight[f.tenant]; !ok {
+			s.tenants = append(s.tenants, f.tenant)
+			s.inFlight[f.tenant] = 0
+		}
+		lvl := s.level(f.priority)
+		if _, ok := lvl.queues[f.tenant]; !ok {
+			lvl.tenants = append(lvl.tenants, f.tenant)
+		}
+		lvl.queues[f.tenant] = append(lvl.queues[f.tenant], f)
+		s.files[path] = f
+	}
+	if s.order.ageOf == nil {
+		return
+	}
+	for _, lvl := range s.levels {
+		for _, queue := range lvl.queues {
+			sort.SliceStable(queue, func(i, j int) bool { return queue[i].age.Before(queue[j].age) })
+		}
+	}
+}
+
+// level returns the level of priority, adding it in order when new
+func (s *scheduler) level(priority int) *priorityLevel {
+	i := sort.Search(len(s.levels), func(i int) bool { return s.levels[i].priority <= priority })
+	if i < len(s.levels) && s.levels[i].priority == priority {
+		return s.levels[i]
+	}
+	lvl := &priorityLevel{priority: priority, queues: make(map[string][]queuedFile)}
+	s.levels = slices.Insert(s.levels, i, lvl)
+	return lvl
+}
+
+// top returns the highest level with files waiting or in flight
+func (s *scheduler) top() *priorityLevel {
+	for _, lvl := range s.levels {
+		if lvl.inFlight > 0 || lvl.backlog() > 0 {
+			return lvl
+		}
+	}
+	return nil
+}
+
+// pick returns the next file to dispatch without waiting. ok is false when
+// the backlog is empty or every file waiting is held back by a cap.
+func (s *scheduler) pick() (string, bool) {
+	top := s.top()
+	below := 0
+	for _, lvl := range s.levels {
+		if lvl != top {
+			below += lvl.inFlight
+		}
+	}
+	for _, lvl := range s.levels {
+		if lvl != top && s.order.reserved > 0 && below >= s.order.slots-s.order.reserved {
+			break
+		}
+		if f, ok := s.pickIn(lvl); ok {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// pickIn picks the next file of lvl round-robin across its tenants
+func (s *scheduler) pickIn(lvl *priorityLevel) (string, bool) {
+	for i := range lvl.tenants {
+		tenant := lvl.tenants[(lvl.cursor+i)%len(lvl.tenants)]
+		queue := lvl.queues[tenant]
+		if len(queue) == 0 {
+			continue
+		}
+		if s.maxPerTenant > 0 && s.inFlight[tenant] >= s.maxPerTenant {
+			continue
+		}
+		lvl.queues[tenant] = queue[1:]
+		lvl.inFlight++
+		s.inFlight[tenant]++
+		lvl.cursor = (lvl.cursor + i + 1) % len(lvl.tenants)
+		return queue[0].path, true
+	}
+	return "", false
+}
+
+// backlog counts the files of lvl not dispatched yet
+func (lvl *priorityLevel) backlog() int {
+	n := 0
+	for _, q := range lvl.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// backlog counts the files not dispatched yet
+func (s *scheduler) backlog() int {
+	n := 0
+	for _, lvl := range s.levels {
+		n += lvl.backlog()
+	}
+	return n
+}
+
+// lowest returns the lowest priority of the pass
+func (s *scheduler) lowest() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.levels) == 0 {
+		return 0
+	}
+	return s.levels[len(s.levels)-1].priority
+}
+
+// next waits until a file may be dispatched and returns it. ok is false once
+// every file was dispatched.
+func (s *scheduler) next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if file, ok := s.pick(); ok {
+			return file, true
+		}
+		if s.backlog() == 0 {
+			return "", false
+		}
+		s.cond.Wait()
+	}
+}
+
+// done releases the slots of a dispatched file
+func (s *scheduler) done(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[file]
+	if !ok || s.inFlight[f.tenant] == 0 {
+		return
+	}
+	delete(s.files, file)
+	s.inFlight[f.tenant]--
+	s.level(f.priority).inFlight--
+	s.cond.Broadcast()
+}
+
+// snapshot returns the load of every tenant of the pass
+func (s *scheduler) snapshot() map[string]TenantStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]TenantStats, len(s.tenants))
+	for _, tenant := range s.tenants {
+		backlog := 0
+		for _, lvl := range s.levels {
+			backlog += len(lvl.queues[tenant])
+		}
+		stats[tenant] = TenantStats{InFlight: s.inFlight[tenant], Backlog: backlog}
+	}
+	return stats
+}
+
+// classSnapshot returns the load of every priority class with files in the
+// pass, aging the waiting files against now
+func (s *scheduler) classSnapshot(now time.Time) map[string]ClassStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]ClassStats)
+	waiting := make(map[string]bool)
+	for _, lvl := range s.levels {
+		for _, queue := range lvl.queues {
+			for _, f := range queue {
+				waiting[f.path] = true
+				cs := stats[f.class]
+				cs.Priority = f.priority
+				cs.Backlog++
+				if !f.age.IsZero() {
+					cs.OldestAge = max(cs.OldestAge, now.Sub(f.age))
+				}
+				stats[f.class] = cs
+			}
+		}
+	}
+	for path, f := range s.files {
+		if waiting[path] {
+			continue
+		}
+		cs := stats[f.class]
+		cs.Priority = f.priority
+		cs.InFlight++
+		stats[f.class] = cs
+	}
+	return stats
+}
+
+// TenantStats returns the in-flight and backlog gauges of every tenant with
+// files in the current or last pass
+func (p *Processor) TenantStats() map[string]TenantStats {
+	s := p.sched.Load()
+	if s == nil {
+		return map[string]TenantStats{}
+	}
+	return s.snapshot()
+}
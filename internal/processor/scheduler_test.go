@@ -0,0 +1,328 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tenantFiles returns n ready files of tenant below /input
+func tenantFiles(tenant string, n int) []string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("/input/%s/%05d.csv", tenant, i)
+	}
+	return files
+}
+
+func tenantOfTest(path string) string {
+	return sourceOf("/input", path)
+}
+
+// runRounds dispatches with a fixed number of workers per round and finishes
+// every dispatched file at the end of the round. It returns the round each
+// file was dispatched in.
+func runRounds(s *scheduler, workers int) map[string]int {
+	rounds := make(map[string]int)
+	for round := 1; ; round++ {
+		var dispatched []string
+		for range workers {
+			f, ok := s.pick()
+			if !ok {
+				break
+			}
+			dispatched = append(dispatched, f)
+			rounds[f] = round
+		}
+		if len(dispatched) == 0 {
+			return rounds
+		}
+		for _, f := range dispatched {
+			s.done(f)
+		}
+	}
+}
+
+func TestScheduler_SmallTenantNotStarved(t *testing.T) {
+	// The noisy tenant's files come first in the ready list
+	files := append(tenantFiles("noisy", 10000), tenantFiles("quiet", 3)...)
+	s := newScheduler(files, tenantOfTest, 0)
+
+	rounds := runRounds(s, 4)
+	if len(rounds) != len(files) {
+		t.Fatalf("dispatched %d files, want %d", len(rounds), len(files))
+	}
+	for _, f := range tenantFiles("quiet", 3) {
+		if rounds[f] > 2 {
+			t.Errorf("%s dispatched in round %d, want within 2 rounds", f, rounds[f])
+		}
+	}
+}
+
+func TestScheduler_TenantCap(t *testing.T) {
+	files := append(tenantFiles("noisy", 100), tenantFiles("quiet", 2)...)
+	s := newScheduler(files, tenantOfTest, 2)
+
+	for round, workers := 1, 8; round <= 3; round++ {
+		var dispatched []string
+		for range workers {
+			f, ok := s.pick()
+			if !ok {
+				break
+			}
+			dispatched = append(dispatched, f)
+		}
+		perTenant := make(map[string]int)
+		for _, f := range dispatched {
+			perTenant[tenantOfTest(f)]++
+		}
+		if perTenant["noisy"] > 2 {
+			t.Errorf("round %d dispatched %d noisy files, want at most 2", round, perTenant["noisy"])
+		}
+
+		stats := s.snapshot()
+		if stats["noisy"].InFlight != perTenant["noisy"] {
+			t.Errorf("round %d noisy in flight = %d, want %d", round, stats["noisy"].InFlight, perTenant["noisy"])
+		}
+		for _, f := range dispatched {
+			s.done(f)
+		}
+	}
+
+	stats := s.snapshot()
+	if stats["noisy"].Backlog != 100-6 || stats["quiet"].Backlog != 0 {
+		t.Errorf("backlog = %+v, want 94 noisy and 0 quiet", stats)
+	}
+}
+
+func TestScheduler_NextWaitsForSlot(t *testing.T) {
+	s := newScheduler(tenantFiles("a", 2), tenantOfTest, 1)
+
+	first, ok := s.next()
+	if !ok {
+		t.Fatal("next() returned no file")
+	}
+	got := make(chan string)
+	go func() {
+		f, _ := s.next()
+		got <- f
+	}()
+	s.done(first)
+	if second := <-got; second == first || !strings.HasPrefix(second, "/input/a/") {
+		t.Errorf("second file = %q", second)
+	}
+	s.done("/input/a/00001.csv")
+	if f, ok := s.next(); ok {
+		t.Errorf("next() = %q after every file was dispatched", f)
+	}
+}
+
+func TestRunPipeline_TenantStats(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.TenantMaxWorkers = 1
+	env.cfg.Concurrency = 4
+
+	var files []string
+	for _, tenant := range []string{"a", "b"} {
+		dir := filepath.Join(env.inputDir, tenant)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		files = append(files, writeSources(t, dir, tenant, []int{16, 32, 64})...)
+	}
+	env.processor.runPipeline(files)
+
+	if got := env.processor.Stats().Totals.Ingested; got != int64(len(files)) {
+		t.Errorf("ingested = %d, want %d", got, len(files))
+	}
+	stats := env.processor.TenantStats()
+	for _, tenant := range []string{"a", "b"} {
+		if stats[tenant] != (TenantStats{}) {
+			t.Errorf("tenant %s stats after the pass = %+v, want idle", tenant, stats[tenant])
+		}
+	}
+}
+
+// classFiles returns n ready files of tenant with extension ext
+func classFiles(tenant, ext string, n int) []string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("/input/%s/%05d.%s", tenant, i, ext)
+	}
+	return files
+}
+
+// testOrder ranks urgent xml above bulk mp4 with 8 slots, 2 of them reserved
+func testOrder(t *testing.T) dispatchOrder {
+	t.Helper()
+	classes, err := ParsePriorities("urgent:*.xml=10,bulk:*.mp4=1")
+	if err != nil {
+		t.Fatalf("ParsePriorities() error = %v", err)
+	}
+	return dispatchOrder{
+		classify: func(path string) PriorityClass {
+			return priorities(classes).classify(strings.TrimPrefix(path, "/input/"))
+		},
+		slots:    8,
+		reserved: reservedSlots(8, 0.25),
+	}
+}
+
+func TestScheduler_PriorityBeforeBacklog(t *testing.T) {
+	// The bulk backlog is ready first
+	files := append(classFiles("media", "mp4", 1000), classFiles("regulator", "xml", 5)...)
+	s := newOrderedScheduler(files, tenantOfTest, 0, testOrder(t))
+
+	var round1 []string
+	for range 8 {
+		f, ok := s.pick()
+		if !ok {
+			t.Fatal("pick() returned no file in the first round")
+		}
+		round1 = append(round1, f)
+	}
+	urgent, bulk := 0, 0
+	for _, f := range round1 {
+		if strings.HasSuffix(f, ".xml") {
+			urgent++
+		} else {
+			bulk++
+		}
+	}
+	if urgent != 5 {
+		t.Errorf("first round dispatched %d urgent files, want all 5", urgent)
+	}
+	if bulk == 0 {
+		t.Error("first round dispatched no bulk file, want bulk to keep making progress")
+	}
+}
+
+func TestScheduler_ReservesSlotsForArrivals(t *testing.T) {
+	s := newOrderedScheduler(classFiles("media", "mp4", 100), tenantOfTest, 0, testOrder(t))
+
+	// Alone, bulk may take every slot
+	var inFlight []string
+	for range 8 {
+		f, ok := s.pick()
+		if !ok {
+			t.Fatal("bulk alone did not get every slot")
+		}
+		inFlight = append(inFlight, f)
+	}
+
+	// Urgent files arriving mid-pass go next
+	urgent := classFiles("regulator", "xml", 3)
+	s.add(urgent)
+	for range urgent {
+		if f, ok := s.pick(); !ok || !strings.HasSuffix(f, ".xml") {
+			t.Fatalf("pick() = %q, %v with urgent files waiting", f, ok)
+		}
+	}
+
+	// While urgent files are in flight, bulk leaves the reserved slots free
+	for _, f := range inFlight[:2] {
+		s.done(f)
+	}
+	if f, ok := s.pick(); ok {
+		t.Errorf("pick() = %q with 6 bulk files in flight and 2 slots reserved", f)
+	}
+	s.done(inFlight[2])
+	if f, ok := s.pick(); !ok || !strings.HasSuffix(f, ".mp4") {
+		t.Errorf("pick() = %q, %v below the bulk limit, want a bulk file", f, ok)
+	}
+
+	stats := s.classSnapshot(time.Now())
+	if stats["urgent"].InFlight != 3 || stats["bulk"].InFlight != 6 || stats["bulk"].Backlog != 100-9 {
+		t.Errorf("class stats = %+v, want 3 urgent and 6 bulk in flight, 91 bulk waiting", stats)
+	}
+}
+
+func TestScheduler_MixedBacklogRounds(t *testing.T) {
+	s := newOrderedScheduler(classFiles("media", "mp4", 200), tenantOfTest, 0, testOrder(t))
+
+	// Urgent files trickle in while the backlog drains; each must be
+	// dispatched in the round after it arrived
+	var arrived []string
+	arrivals := make(map[string]int)
+	bulkDone := 0
+	for round := 1; round <= 20; round++ {
+		if round%3 == 0 {
+			more := classFiles(fmt.Sprintf("regulator%d", round), "xml", 2)
+			s.add(more)
+			for _, f := range more {
+				arrivals[f] = round
+			}
+			arrived = append(arrived, more...)
+		}
+		var dispatched []string
+		for range 8 {
+			f, ok := s.pick()
+			if !ok {
+				break
+			}
+			dispatched = append(dispatched, f)
+			if at, ok := arrivals[f]; ok && round > at {
+				t.Errorf("%s arrived in round %d but was dispatched in round %d", f, at, round)
+			}
+			delete(arrivals, f)
+			if strings.HasSuffix(f, ".mp4") {
+				bulkDone++
+			}
+		}
+		for _, f := range dispatched {
+			s.done(f)
+		}
+	}
+	if len(arrivals) != 0 {
+		t.Errorf("urgent files never dispatched: %v", arrivals)
+	}
+	if bulkDone < 100 {
+		t.Errorf("bulk dispatched %d files in 20 rounds, want it to keep making progress", bulkDone)
+	}
+}
+
+func TestScheduler_OldestFirst(t *testing.T) {
+	order := testOrder(t)
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	ages := map[string]time.Time{
+		"/input/a/new.xml": base.Add(time.Minute),
+		"/input/a/old.xml": base,
+	}
+	order.ageOf = func(path string) time.Time { return ages[path] }
+	s := newOrderedScheduler([]string{"/input/a/new.xml", "/input/a/old.xml"}, tenantOfTest, 0, order)
+
+	if f, _ := s.pick(); f != "/input/a/old.xml" {
+		t.Errorf("first pick = %q, want the oldest file", f)
+	}
+	stats := s.classSnapshot(base.Add(2 * time.Minute))
+	if stats["urgent"].OldestAge != time.Minute {
+		t.Errorf("urgent oldest age = %v, want 1m", stats["urgent"].OldestAge)
+	}
+}
+
+func TestRunPipeline_RecordsPriority(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.Priorities = "urgent:*.xml=10"
+	env.processor = New(env.cfg, env.store, env.watcher)
+	defer env.processor.attempts.close()
+
+	files := writeSourceFiles(t, env, "report.xml", "data.csv")
+	env.processor.runPipeline(files)
+
+	priority := make(map[string]string)
+	for _, e := range readManifest(t, env.manifestsDir) {
+		priority[e.Name] = e.Priority
+	}
+	if priority["report.xml"] != "urgent" || priority["data.csv"] != DefaultPriorityClass {
+		t.Errorf("manifest priorities = %v, want report.xml urgent and data.csv %s", priority, DefaultPriorityClass)
+	}
+	stats := env.processor.PriorityStats()
+	if stats["urgent"] != (ClassStats{Priority: 10}) || stats[DefaultPriorityClass] != (ClassStats{}) {
+		t.Errorf("priority stats after the pass = %+v, want idle classes", stats)
+	}
+}
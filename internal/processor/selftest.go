@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
+)
+
+// Self-test stages, in the order they run
+const (
+	SelfTestProbe     = "probe"
+	SelfTestIngest    = "ingest"
+	SelfTestDatabase  = "database"
+	SelfTestWarehouse = "warehouse"
+	SelfTestManifest  = "manifest"
+	SelfTestCleanup   = "cleanup"
+)
+
+// probeName is the file name of the self-test probe
+const probeName = "probe.txt"
+
+// SelfTestStage reports one stage of a self-test
+type SelfTestStage struct {
+	Name    string        `json:"name"`
+	OK      bool          `json:"ok"`
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// SelfTestReport is the outcome of a self-test. Stages after the first
+// failure are skipped, except cleanup, which always runs.
+type SelfTestReport struct {
+	OK       bool            `json:"ok"`
+	Probe    string          `json:"probe"`
+	SHA256   string          `json:"sha256"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration_ns"`
+	Stages   []SelfTestStage `json:"stages"`
+}
+
+// selfTest is one run of SelfTest
+type selfTest struct {
+	p      *Processor
+	report SelfTestReport
+	// dir is the probe directory relative to the input root
+	dir    string
+	record *storage.File
+}
+
+// run times fn as the named stage and records its outcome. It does nothing
+// once an earlier stage failed.
+func (t *selfTest) run(name string, fn func() error) {
+	if !t.report.OK {
+		return
+	}
+	start := time.Now()
+	err := fn()
+	stage := SelfTestStage{Name: name, OK: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		stage.Error = err.Error()
+		t.report.OK = false
+	}
+	t.report.Stages = append(t.report.Stages, stage)
+}
+
+// SelfTest ingests a uniquely named probe file through the full pipeline,
+// skipping the completion wait, and verifies it reached the warehouse with
+// its hash, the database and the manifest. The probe's source, warehouse
+// copy and database record are removed afterwards whatever the outcome.
+// Manifests are append-only, so the probe's entry stays, marked self_test.
+// The probe lives in a hidden directory of the input root, which the
+// watcher never tracks, so it is safe to run next to normal processing.
+func (p *Processor) SelfTest(ctx context.Context) SelfTestReport {
+	t := &selfTest{p: p, report: SelfTestReport{OK: true, Started: time.Now()}}
+
+	id, err := destination.NewUploadID()
+	if err != nil {
+		t.run(SelfTestProbe, func() error { return err })
+		return t.finish()
+	}
+	t.dir = config.SelfTestDirPrefix + id
+	src := filepath.Join(p.cfg.Path, t.dir, probeName)
+	content := fmt.Sprintf("atomic-ingestor self-test %s %s\n", id, t.report.Started.Format(time.RFC3339Nano))
+	sum := sha256.Sum256([]byte(content))
+	t.report.Probe = src
+	t.report.SHA256 = hex.EncodeToString(sum[:])
+
+	t.run(SelfTestProbe, func() error { return p.writeProbe(src, content) })
+	t.run(SelfTestIngest, func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return p.processFile(src)
+	})
+	t.run(SelfTestDatabase, t.checkDatabase)
+	t.run(SelfTestWarehouse, t.checkWarehouse)
+	t.run(SelfTestManifest, t.checkManifest)
+
+	// Clean up even when an earlier stage failed
+	ok := t.report.OK
+	t.report.OK = true
+	t.run(SelfTestCleanup, t.cleanup)
+	t.report.OK = ok && t.report.OK
+	return t.finish()
+}
+
+func (t *selfTest) finish() SelfTestReport {
+	t.report.Duration = time.Since(t.report.Started)
+	return t.report
+}
+
+// writeProbe creates the probe, and its sidecar in sidecar mode
+func (p *Processor) writeProbe(src, content string) error {
+	if p.cfg.DryRun {
+		return errors.New("self-test needs a real run, dry run mode moves nothing")
+	}
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		return fmt.Errorf("create probe directory: %w", err)
+	}
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("write probe: %w", err)
+	}
+	if p.cfg.Method == config.MethodSidecar {
+		if err := os.WriteFile(src+config.SidecarSuffix, nil, 0o644); err != nil {
+			return fmt.Errorf("write probe sidecar: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *selfTest) checkDatabase() error {
+	rec, err := t.p.storage.FindBySHA256(t.report.SHA256)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return errors.New("no database record for the probe")
+	}
+	t.record = rec
+	if rec.Status != status.Ingested {
+		return fmt.Errorf("probe record has status %q, want %q", rec.Status, status.Ingested)
+	}
+	return nil
+}
+
+func (t *selfTest) checkWarehouse() error {
+	hash, err := fileops.CalculateSHA256(t.record.DestPath)
+	if err != nil {
+		return fmt.Errorf("hash warehouse copy: %w", err)
+	}
+	if hash != t.report.SHA256 {
+		return fmt.Errorf("warehouse copy %s has sha256 %s, want %s", t.record.DestPath, hash, t.report.SHA256)
+	}
+	return nil
+}
+
+func (t *selfTest) checkManifest() error {
+	// Backends differ in the precision they keep of the record's time
+	at := t.record.ProcessedAt
+	entries, err := t.p.manifest.ManifestEntries(at.Add(-time.Second), at.Add(time.Second))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.SHA256 == t.report.SHA256 && e.Status == status.Ingested && e.SelfTest {
+			return nil
+		}
+	}
+	return fmt.Errorf("no self-test entry for the probe among the %d entries processed around %s", len(entries), at.Format(time.RFC3339))
+}
+
+// cleanup removes every artifact the probe may have left: the probe
+// directory in the input, trash, warehouse and quarantine trees and the
+// database record
+func (t *selfTest) cleanup() error {
+	t.p.watcher.RemoveFromTracking(t.report.Probe)
+	errs := make([]error, 0, 5)
+	// A stage failing after the claim leaves a record the checks never saw
+	if rec, err := t.p.storage.FindBySHA256(t.report.SHA256); err != nil {
+		errs = append(errs, err)
+	} else if rec != nil {
+		errs = append(errs, t.p.storage.DeleteFile(rec.SHA256))
+	}
+	for _, root := range []string{t.p.cfg.Path, trash.Dir(t.p.cfg.Path), t.p.cfg.Destination, t.p.cfg.QuarantinePath} {
+		if root == "" {
+			continue
+		}
+		dir := filepath.Join(root, t.dir)
+		if _, err := os.Lstat(dir); err != nil {
+			// Never created, or the tree itself is unusable
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, fmt.Errorf("remove probe directory: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isProbe reports whether path lies in a self-test probe directory
+func (p *Processor) isProbe(path string) bool {
+	rel, err := filepath.Rel(p.cfg.Path, path)
+	if err != nil {
+		return false
+	}
+	top, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return strings.HasPrefix(top, config.SelfTestDirPrefix)
+}
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// probeDirs returns the self-test probe directories left under root
+func probeDirs(t *testing.T, root string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to read %s: %v", root, err)
+	}
+	var dirs []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), config.SelfTestDirPrefix) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	return dirs
+}
+
+func TestSelfTest_Passes(t *testing.T) {
+	for _, method := range []string{config.MethodStabilityWindow, config.MethodSidecar} {
+		t.Run(method, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.Method = method
+
+			report := env.processor.SelfTest(context.Background())
+			if !report.OK {
+				t.Fatalf("SelfTest() = %+v, want OK", report)
+			}
+			want := []string{SelfTestProbe, SelfTestIngest, SelfTestDatabase, SelfTestWarehouse, SelfTestManifest, SelfTestCleanup}
+			if len(report.Stages) != len(want) {
+				t.Fatalf("stages = %+v, want %v", report.Stages, want)
+			}
+			for i, name := range want {
+				if s := report.Stages[i]; s.Name != name || !s.OK || s.Latency <= 0 {
+					t.Errorf("stage %d = %+v, want %s OK with a latency", i, s, name)
+				}
+			}
+
+			for _, root := range []string{env.inputDir, env.warehouseDir} {
+				if dirs := probeDirs(t, root); len(dirs) != 0 {
+					t.Errorf("probe directories left in %s: %v", root, dirs)
+				}
+			}
+			if file, _ := env.store.FindBySHA256(report.SHA256); file != nil {
+				t.Errorf("probe record left in the database: %+v", file)
+			}
+
+			entries := readManifest(t, env.manifestsDir)
+			if len(entries) != 1 || !entries[0].SelfTest || entries[0].SHA256 != report.SHA256 {
+				t.Errorf("manifest entries = %+v, want the probe flagged self_test", entries)
+			}
+		})
+	}
+}
+
+func TestSelfTest_ReportsFailureAndCleansUp(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// A file in place of the warehouse makes the move fail
+	if err := os.RemoveAll(env.warehouseDir); err != nil {
+		t.Fatalf("failed to remove warehouse: %v", err)
+	}
+	if err := os.WriteFile(env.warehouseDir, nil, 0o644); err != nil {
+		t.Fatalf("failed to create warehouse file: %v", err)
+	}
+
+	report := env.processor.SelfTest(context.Background())
+	if report.OK {
+		t.Fatalf("SelfTest() = %+v, want a failure", report)
+	}
+	last := report.Stages[len(report.Stages)-1]
+	if last.Name != SelfTestCleanup || !last.OK {
+		t.Errorf("last stage = %+v, want a successful cleanup", last)
+	}
+	var failed []string
+	for _, s := range report.Stages {
+		if !s.OK {
+			failed = append(failed, s.Name)
+			if s.Error == "" {
+				t.Errorf("failed stage %s has no error", s.Name)
+			}
+		}
+	}
+	if len(failed) != 1 || failed[0] == SelfTestProbe {
+		t.Errorf("failed stages = %v, want one after the probe", failed)
+	}
+	if dirs := probeDirs(t, env.inputDir); len(dirs) != 0 {
+		t.Errorf("probe directories left in the input: %v", dirs)
+	}
+}
+
+func TestSelfTest_DryRun(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+
+	report := env.processor.SelfTest(context.Background())
+	if report.OK || len(report.Stages) != 2 || report.Stages[0].Name != SelfTestProbe || report.Stages[0].OK {
+		t.Errorf("SelfTest() in dry run = %+v, want a failed probe stage and cleanup", report)
+	}
+}
+
+func TestIsProbe(t *testing.T) {
+	p := &Processor{cfg: &config.Config{Path: "/in"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join("/in", config.SelfTestDirPrefix+"abc", probeName), true},
+		{filepath.Join("/in", "data", config.SelfTestDirPrefix+"abc", probeName), false},
+		{"/in/a.csv", false},
+		{"/elsewhere/" + config.SelfTestDirPrefix + "abc/probe.txt", false},
+	}
+	for _, tt := range tests {
+		if got := p.isProbe(tt.path); got != tt.want {
+			t.Errorf("isProbe(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
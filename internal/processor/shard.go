@@ -0,0 +1,179 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+const (
+	// shardPattern names the shard subdirectories of count sharding
+	shardPattern = "shard-%02d"
+	// maxShards is the most shard subdirectories a directory gets, the
+	// number two digits can name
+	maxShards = 100
+	// countChunk is the number of names read at a time when counting the
+	// entries of a directory
+	countChunk = 1024
+)
+
+// shardName matches the shard subdirectories, which do not count against
+// the fan-out of their parent's files
+var shardName = regexp.MustCompile(`^shard-\d{2}$`)
+
+// sharder tracks how many entries the warehouse directories hold for count
+// sharding. A crowded directory reserves room for its shard subdirectories,
+// which fill the same way, so shards form a tree visited in level order and
+// no directory ever holds more than the fan-out.
+type sharder struct {
+	mu sync.Mutex
+	// counts is the number of files placed in each directory, seeded from
+	// disk the first time a directory is seen
+	counts map[string]int
+	// next is the level-order index of the first node of each root's tree
+	// with room left
+	next map[string]int
+}
+
+func newSharder() *sharder {
+	return &sharder{counts: make(map[string]int), next: make(map[string]int)}
+}
+
+// place returns the directory in the shard tree of root that takes the next
+// file and counts the file
+func (s *sharder) place(root string, fanout int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shards := min(maxShards, fanout/2)
+	capacity := fanout - shards
+	for k := s.next[root]; ; k++ {
+		dir := shardPath(root, k, shards)
+		count, ok := s.counts[dir]
+		if !ok {
+			var err error
+			if count, err = countFiles(dir); err != nil {
+				return "", err
+			}
+		}
+		s.counts[dir] = count
+		if count < capacity {
+			s.next[root] = k
+			s.counts[dir]++
+			return dir, nil
+		}
+	}
+}
+
+// shardPath returns the directory of node k of the level-order tree below
+// root in which every node has shards children
+func shardPath(root string, k, shards int) string {
+	var names []string
+	for ; k > 0; k = (k - 1) / shards {
+		names = append(names, fmt.Sprintf(shardPattern, (k-1)%shards))
+	}
+	slices.Reverse(names)
+	return filepath.Join(append([]string{root}, names...)...)
+}
+
+// countFiles counts the entries of dir besides its shard subdirectories.
+// A missing directory holds none.
+func countFiles(dir string) (int, error) {
+	f, err := os.Open(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("count entries of %s: %w", dir, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	count := 0
+	for {
+		names, err := f.Readdirnames(countChunk)
+		for _, name := range names {
+			if !shardName.MatchString(name) {
+				count++
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("count entries of %s: %w", dir, err)
+		}
+	}
+}
+
+// destination resolves the warehouse path of relPath like warehousePath and
+// shards it, see shard
+func (p *Processor) destination(relPath, key, hash string) (resolvedPath, error) {
+	dst, err := p.warehousePath(relPath)
+	if err != nil {
+		return dst, err
+	}
+	return p.shard(key, hash, dst)
+}
+
+// shard moves dst, a resolved warehouse destination, into the shard
+// directory chosen by the configured sharding. A file whose key, the
+// relative path recorded for it, was ingested before goes to the directory
+// of the latest recorded version instead, so collisions and versions are
+// handled against the path the earlier file was actually stored at. An
+// empty key skips the lookup.
+func (p *Processor) shard(key, hash string, dst resolvedPath) (resolvedPath, error) {
+	if p.cfg.WarehouseSharding == "" {
+		return dst, nil
+	}
+
+	base := filepath.Dir(dst.path)
+	dir := ""
+	if key != "" {
+		previous, err := p.storage.LatestVersion(fileops.NormalizeName(p.limits.form, key))
+		if err != nil {
+			return dst, err
+		}
+		if previous != nil {
+			dir = filepath.Dir(previous.DestPath)
+		}
+	}
+	if dir == "" {
+		switch p.cfg.WarehouseSharding {
+		case config.ShardingHash:
+			dir = filepath.Join(base, hash[:2])
+		case config.ShardingCount:
+			var err error
+			if dir, err = p.shards.place(base, p.cfg.ShardFanout); err != nil {
+				return dst, err
+			}
+		}
+	}
+	if dir == base {
+		return dst, nil
+	}
+
+	rel, err := filepath.Rel(p.cfg.Destination, filepath.Join(dir, dst.name))
+	if err != nil {
+		return dst, fmt.Errorf("relative shard directory %s: %w", dir, err)
+	}
+	original := dst.originalName
+	if original == "" {
+		original = dst.name
+	}
+	sharded, err := resolveDestination(p.cfg.Destination, rel, p.limits)
+	if err != nil {
+		return sharded, err
+	}
+	if sharded.name != original {
+		sharded.originalName = original
+	}
+	return sharded, nil
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestShardPath(t *testing.T) {
+	tests := []struct {
+		k    int
+		want string
+	}{
+		{0, "root"},
+		{1, "root/shard-00"},
+		{3, "root/shard-02"},
+		{4, "root/shard-00/shard-00"},
+		{9, "root/shard-01/shard-02"},
+		{13, "root/shard-00/shard-00/shard-00"},
+	}
+	for _, tt := range tests {
+		if got := filepath.ToSlash(shardPath("root", tt.k, 3)); got != tt.want {
+			t.Errorf("shardPath(root, %d, 3) = %s, want %s", tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestShard_CountBoundsFanout(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WarehouseSharding = config.ShardingCount
+	env.cfg.ShardFanout = 8
+
+	const files = 2000
+	if err := os.MkdirAll(filepath.Join(env.inputDir, "data"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for i := range files {
+		ingest(t, env, fmt.Sprintf("data/file-%04d.csv", i), fmt.Sprintf("content %d", i))
+	}
+
+	err := filepath.WalkDir(env.warehouseDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > env.cfg.ShardFanout {
+			t.Errorf("%s holds %d entries, want at most %d", path, len(entries), env.cfg.ShardFanout)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk warehouse: %v", err)
+	}
+
+	records, err := env.store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if len(records) != files {
+		t.Fatalf("len(records) = %d, want %d", len(records), files)
+	}
+	for _, rec := range records {
+		if _, err := os.Stat(rec.DestPath); err != nil {
+			t.Errorf("recorded destination %s: %v", rec.DestPath, err)
+		}
+	}
+	if got := len(warehouseFiles(t, env)); got != files {
+		t.Errorf("warehouse holds %d files, want %d", got, files)
+	}
+}
+
+func TestShard_CountsExistingEntries(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WarehouseSharding = config.ShardingCount
+	env.cfg.ShardFanout = 4
+
+	// Placed by hand before the processor started
+	for _, name := range []string{"old-1.csv", "old-2.csv"} {
+		if err := os.WriteFile(filepath.Join(env.warehouseDir, name), []byte(name), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	ingest(t, env, "new.csv", "new")
+
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "shard-00", "new.csv")); err != nil {
+		t.Errorf("new file not sharded past the existing entries: %v", err)
+	}
+}
+
+func TestShard_Hash(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WarehouseSharding = config.ShardingHash
+
+	ingest(t, env, "data.csv", "hashed")
+
+	rec, err := env.store.LatestVersion("data.csv")
+	if err != nil || rec == nil {
+		t.Fatalf("no record: %v", err)
+	}
+	want := filepath.Join(env.warehouseDir, rec.SHA256[:2], "data.csv")
+	if rec.DestPath != want {
+		t.Errorf("DestPath = %s, want %s", rec.DestPath, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("sharded file missing: %v", err)
+	}
+}
+
+func TestShard_VersionsFollowRecordedPath(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.WarehouseSharding = config.ShardingHash
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+
+	ingest(t, env, "data.csv", "first")
+	first, err := env.store.LatestVersion("data.csv")
+	if err != nil || first == nil {
+		t.Fatalf("no record of the first version: %v", err)
+	}
+	ingest(t, env, "data.csv", "second")
+	second, err := env.store.LatestVersion("data.csv")
+	if err != nil || second == nil {
+		t.Fatalf("no record of the second version: %v", err)
+	}
+
+	if second.Version != 2 {
+		t.Errorf("Version = %d, want 2", second.Version)
+	}
+	want := filepath.Join(filepath.Dir(first.DestPath), "data.v2.csv")
+	if second.DestPath != want {
+		t.Errorf("DestPath = %s, want %s next to the first version", second.DestPath, want)
+	}
+}
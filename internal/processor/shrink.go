@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// errHeld ends the processing of a file held because it shrank. The file
+// stays tracked and is ingested once forced in or once the hold times out.
+var errHeld = errors.New("file held after shrinking")
+
+// heldBack reports whether the source at path, of size, is held and must
+// not even be hashed: it is the file an earlier pass held, not forced in
+// and not timed out yet
+func (p *Processor) heldBack(path string, size int64) bool {
+	if p.cfg.ShrinkPercent <= 0 {
+		return false
+	}
+	hold, err := p.storage.FindHold(path)
+	if err != nil {
+		slog.Warn("failed to look up held file, checking it again", "path", path, "error", err)
+		return false
+	}
+	// A file that changed since is checked again
+	return hold != nil && hold.Size == size && !p.holdLifted(hold)
+}
+
+// holdLifted reports whether hold no longer keeps its file out: an operator
+// forced it in, or it timed out
+func (p *Processor) holdLifted(hold *storage.HeldFile) bool {
+	return hold.ReleasedAt != nil || (p.cfg.ShrinkRelease > 0 && p.clock().Sub(hold.HeldAt) >= p.cfg.ShrinkRelease)
+}
+
+// holdIfShrunk holds the file at path, of size and content hash, when it
+// is more than ShrinkPercent smaller than the file last ingested from the
+// same path, recording the hold and raising a suspicious_shrink alert. It
+// reports whether the file was held, and whether it is let in under a hold
+// that was lifted, which ends once the file is ingested. A dry run records
+// and alerts nothing, only counting the file it would hold.
+func (p *Processor) holdIfShrunk(path string, size int64, hash string) (held, lifted bool) {
+	if p.cfg.ShrinkPercent <= 0 || p.isProbe(path) {
+		return false, false
+	}
+	hold, err := p.storage.FindHold(path)
+	if err != nil {
+		slog.Warn("failed to look up held file, checking it again", "path", path, "error", err)
+	}
+	if hold != nil && hold.SHA256 == hash && p.holdLifted(hold) {
+		if hold.ReleasedAt == nil {
+			slog.Warn("held file timed out, ingesting it", "path", path, "sha256", hash, "held_at", hold.HeldAt, "release_after", p.cfg.ShrinkRelease)
+		}
+		return false, true
+	}
+
+	rel, ok := p.recordRelPath(path)
+	if !ok {
+		return false, false
+	}
+	previous, err := p.storage.LastByPath(rel)
+	if err != nil {
+		slog.Warn("failed to look up file by path, not checking it for shrinking", "path", path, "error", err)
+		return false, false
+	}
+	if previous == nil || previous.SHA256 == hash || float64(size) >= float64(previous.Size)*(1-p.cfg.ShrinkPercent/100) {
+		return false, false
+	}
+
+	if p.cfg.DryRun {
+		slog.Info("dry run: would hold file smaller than the one last ingested from its path",
+			"path", path,
+			"sha256", hash,
+			"size", size,
+			"previous_size", previous.Size,
+		)
+		p.dryRun.wouldHold()
+		p.watcher.RemoveFromTracking(path)
+		return true, false
+	}
+	hold = &storage.HeldFile{
+		Path:           path,
+		RelPath:        rel,
+		SHA256:         hash,
+		Size:           size,
+		PreviousSHA256: previous.SHA256,
+		PreviousSize:   previous.Size,
+		PreviousDest:   previous.DestPath,
+		HeldAt:         p.clock(),
+	}
+	if err := p.storage.HoldFile(hold); err != nil {
+		// Holding nothing would hold the file forever, unseen
+		slog.Error("failed to record held file, ingesting it", "path", path, "error", err)
+		return false, false
+	}
+	slog.Error("suspicious shrink, holding file smaller than the one last ingested from its path until it is forced in",
+		"path", path,
+		"hold_id", hold.ID,
+		"sha256", hash,
+		"size", size,
+		"previous_sha256", previous.SHA256,
+		"previous_size", previous.Size,
+		"previous_destination", previous.DestPath,
+		"release_after", p.cfg.ShrinkRelease,
+	)
+	alert := *hold
+	alert.Path = p.redactor.Text(alert.Path)
+	alert.RelPath = p.redactor.Text(alert.RelPath)
+	alert.PreviousDest = p.redactor.Text(alert.PreviousDest)
+	p.events.publish(Event{
+		Kind:   EventSuspiciousShrink,
+		Path:   alert.Path,
+		Source: p.redactor.Text(sourceOf(p.cfg.Path, path)),
+		SHA256: hash,
+		At:     time.Now(),
+		Shrink: &alert,
+	})
+	return true, false
+}
+
+// endHold forgets the hold of a file ingested after it was lifted
+func (p *Processor) endHold(path string) {
+	if err := p.storage.DeleteHold(path); err != nil {
+		slog.Warn("failed to delete hold of ingested file", "path", path, "error", err)
+	}
+}
+
+// HeldFiles returns the files held because they shrank, redacted, oldest
+// first
+func (p *Processor) HeldFiles() ([]storage.HeldFile, error) {
+	holds, err := p.storage.HeldFiles()
+	if err != nil {
+		return nil, err
+	}
+	for i := range holds {
+		holds[i].Path = p.redactor.Text(holds[i].Path)
+		holds[i].RelPath = p.redactor.Text(holds[i].RelPath)
+		holds[i].PreviousDest = p.redactor.Text(holds[i].PreviousDest)
+	}
+	return holds, nil
+}
+
+// ForceIngest lifts the hold id as by, so its file is ingested on the next
+// pass. It fails with storage.ErrNoHold when there is no such hold.
+func (p *Processor) ForceIngest(id uint, by string) (*storage.HeldFile, error) {
+	hold, err := p.storage.ReleaseHold(id, by, p.clock())
+	if err != nil {
+		return nil, fmt.Errorf("force ingest of hold %d: %w", id, err)
+	}
+	return hold, nil
+}
@@ -0,0 +1,153 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// resend writes content to name under the input directory of env and
+// processes it, as a producer replacing a file already ingested
+func resend(t *testing.T, env *testEnv, name, content string) string {
+	t.Helper()
+	src := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile(%s) error = %v", name, err)
+	}
+	return src
+}
+
+func TestShrink_HoldsUntilForced(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ShrinkPercent = 50
+	var c collector
+	env.processor.events.subscribeDirect(c.add)
+
+	full := strings.Repeat("row\n", 25)
+	ingest(t, env, "a.csv", full)
+	src := resend(t, env, "a.csv", "row\n")
+	// Held files wait without being hashed or alerted on again
+	resend(t, env, "a.csv", "row\n")
+
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("held file not left in the input directory: %v", err)
+	}
+	if counts := countStatuses(t, env); counts[status.Ingested] != 1 {
+		t.Fatalf("manifest statuses = %v, want only the full file ingested", counts)
+	}
+	var alerts []Event
+	for _, e := range c.get() {
+		if e.Kind == EventSuspiciousShrink {
+			alerts = append(alerts, e)
+		}
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("got %d suspicious_shrink alerts, want 1", len(alerts))
+	}
+	shrink := alerts[0].Shrink
+	if shrink == nil || shrink.Size != 4 || shrink.PreviousSize != int64(len(full)) ||
+		shrink.SHA256 != sha256Hex("row\n") || shrink.PreviousSHA256 != sha256Hex(full) {
+		t.Errorf("alert = %+v, want both sizes and hashes", shrink)
+	}
+
+	holds, err := env.processor.HeldFiles()
+	if err != nil || len(holds) != 1 {
+		t.Fatalf("HeldFiles() = %+v, %v, want the shrunk file", holds, err)
+	}
+	if _, err := env.processor.ForceIngest(holds[0].ID, "ops"); err != nil {
+		t.Fatalf("ForceIngest() error = %v", err)
+	}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() after ForceIngest error = %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("forced file still in the input directory: %v", err)
+	}
+	if counts := countStatuses(t, env); counts[status.Ingested] != 2 {
+		t.Errorf("manifest statuses = %v, want the forced file ingested", counts)
+	}
+	if holds, err := env.processor.HeldFiles(); err != nil || len(holds) != 0 {
+		t.Errorf("HeldFiles() after ingest = %+v, %v, want none", holds, err)
+	}
+}
+
+func TestShrink_IngestsGrowthAndSmallShrinks(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ShrinkPercent = 50
+
+	ingest(t, env, "a.csv", strings.Repeat("row\n", 10))
+	// Growing, and shrinking by less than the threshold, are legitimate
+	resend(t, env, "a.csv", strings.Repeat("row\n", 30))
+	resend(t, env, "a.csv", strings.Repeat("row\n", 20))
+	// So is a much smaller file at another path
+	resend(t, env, "b.csv", "row\n")
+
+	if counts := countStatuses(t, env); counts[status.Ingested] != 4 {
+		t.Errorf("manifest statuses = %v, want every file ingested", counts)
+	}
+	if holds, err := env.processor.HeldFiles(); err != nil || len(holds) != 0 {
+		t.Errorf("HeldFiles() = %+v, %v, want none", holds, err)
+	}
+}
+
+func TestShrink_ReleasesAfterTimeout(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ShrinkPercent = 50
+	env.cfg.ShrinkRelease = time.Hour
+	now := time.Now()
+	env.processor.clock = func() time.Time { return now }
+
+	ingest(t, env, "a.csv", strings.Repeat("row\n", 25))
+	src := resend(t, env, "a.csv", "row\n")
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("shrunk file not held: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() after the timeout error = %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("file still held after the timeout: %v", err)
+	}
+	if counts := countStatuses(t, env); counts[status.Ingested] != 2 {
+		t.Errorf("manifest statuses = %v, want the file ingested after the timeout", counts)
+	}
+}
+
+func TestShrink_DryRunHoldsNothing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.ShrinkPercent = 50
+	var c collector
+	env.processor.events.subscribeDirect(c.add)
+
+	ingest(t, env, "a.csv", strings.Repeat("row\n", 25))
+	env.cfg.DryRun = true
+	src := resend(t, env, "a.csv", "row\n")
+
+	if holds, err := env.processor.HeldFiles(); err != nil || len(holds) != 0 {
+		t.Errorf("HeldFiles() after a dry run = %+v, %v, want none", holds, err)
+	}
+	for _, e := range c.get() {
+		if e.Kind == EventSuspiciousShrink {
+			t.Errorf("dry run raised an alert: %+v", e)
+		}
+	}
+	if n := env.processor.dryRun.hold.Load(); n != 1 {
+		t.Errorf("would hold %d files, want 1", n)
+	}
+	if env.processor.heldBack(src, 4) {
+		t.Error("a real run would keep the file out after the dry run")
+	}
+}
@@ -0,0 +1,250 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// recentLimit is how many outcomes Recent keeps
+const recentLimit = 100
+
+// Event is the outcome of processing one file or file set, or a change of
+// the processor's state. Kind tells them apart.
+type Event struct {
+	Kind    string        `json:"kind"`
+	Path    string        `json:"path,omitempty"`
+	Source  string        `json:"source,omitempty"`
+	SHA256  string        `json:"sha256,omitempty"`
+	Outcome status.Status `json:"outcome,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	At      time.Time     `json:"at"`
+	// Entry is the manifest entry written for the outcome, if any
+	Entry *manifest.Entry `json:"entry,omitempty"`
+	// Duration is the time from the start of hashing to the outcome, when
+	// known
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	// Summarized marks a duplicate folded into the summary of a resend
+	// storm, which is not written to the manifest on its own
+	Summarized bool `json:"summarized,omitempty"`
+	// Storm describes the source of a resend storm event
+	Storm *StormStatus `json:"storm,omitempty"`
+	// Shrink describes the file of a suspicious shrink event
+	Shrink *storage.HeldFile `json:"shrink,omitempty"`
+	// Destination describes the warehouse of a destination health event
+	Destination *destination.HealthStatus `json:"destination,omitempty"`
+	// Replayed marks an event sent again by Replay from the manifest, At
+	// then being when its file was processed
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+// Counts tallies outcomes since the processor started
+type Counts struct {
+	Ingested    int64 `json:"ingested"`
+	Duplicate   int64 `json:"duplicate"`
+	Quarantined int64 `json:"quarantined"`
+	Vanished    int64 `json:"vanished"`
+	Failed      int64 `json:"failed"`
+}
+
+func (c *Counts) add(outcome status.Status) {
+	switch outcome {
+	case status.Ingested:
+		c.Ingested++
+	case status.Duplicate:
+		c.Duplicate++
+	case status.Quarantined:
+		c.Quarantined++
+	case status.Vanished:
+		c.Vanished++
+	case status.Failed:
+		c.Failed++
+	case status.DuplicateSummary, status.Adopted, status.Suspect, status.MissingRestored, status.Superseded:
+		// Not outcomes of processing a file
+	}
+}
+
+// Stats is a snapshot of the processor's counters. Sources are the top-level
+// directories of the input path; files directly inside it count under ".".
+type Stats struct {
+	Paused   bool              `json:"paused"`
+	Totals   Counts            `json:"totals"`
+	BySource map[string]Counts `json:"by_source"`
+	// Panics counts the panics recovered while processing files, each also
+	// counted as a failure
+	Panics int64 `json:"panics"`
+	// WatermarkLag is how far the ingestion watermark trails the clock
+	WatermarkLag time.Duration `json:"watermark_lag_ns"`
+	// ClockRegressions counts the times the clock fell behind the latest
+	// ProcessedAt, and ClockBehind is by how much it still is
+	ClockRegressions int64         `json:"clock_regressions"`
+	ClockBehind      time.Duration `json:"clock_behind_ns"`
+	// LateManifestEntries counts the entries written to the late folder of
+	// a finalized manifest day
+	LateManifestEntries int64 `json:"late_manifest_entries"`
+	// RestoredDuplicates counts the duplicates ingested because the
+	// warehouse copy of their original was missing
+	RestoredDuplicates int64 `json:"restored_duplicates"`
+	// SourceLeftovers counts the ingested sources that could not be
+	// removed, left to be removed when found again
+	SourceLeftovers int64 `json:"source_leftovers"`
+}
+
+// tracker records outcomes for Stats and Recent
+type tracker struct {
+	mu       sync.Mutex
+	totals   Counts
+	bySource map[string]Counts
+	// recent is a ring buffer of the last recentLimit events; next is the
+	// slot the following event is written to
+	recent []Event
+	next   int
+	panics int64
+}
+
+func newTracker() *tracker {
+	return &tracker{bySource: make(map[string]Counts)}
+}
+
+// record is a direct event subscriber, so Stats and Recent reflect an
+// outcome as soon as it is published
+func (t *tracker) record(e Event) {
+	if e.Outcome == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals.add(e.Outcome)
+	c := t.bySource[e.Source]
+	c.add(e.Outcome)
+	t.bySource[e.Source] = c
+
+	if len(t.recent) < recentLimit {
+		t.recent = append(t.recent, e)
+		return
+	}
+	t.recent[t.next] = e
+	t.next = (t.next + 1) % recentLimit
+}
+
+// recordPanic counts a recovered panic
+func (t *tracker) recordPanic() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.panics++
+}
+
+// record notes the outcome of processing path
+func (p *Processor) record(path, hash string, outcome status.Status, cause error) {
+	p.settle(path, outcome)
+	p.endAttempt(path, hash, outcome, cause)
+	p.events.publish(p.fileEvent(path, hash, outcome, cause))
+}
+
+// settle forgets the persisted completion of a file that reached a terminal
+// outcome. Failed files keep theirs to be retried after a restart. Files
+// ingested, rejected as duplicates or quarantined that left the input are
+// marked done, so late events for them are ignored.
+func (p *Processor) settle(path string, outcome status.Status) {
+	if outcome == status.Failed {
+		return
+	}
+	p.watcher.Settle(path)
+	if outcome != status.Vanished && !p.keepsSource(path) {
+		p.watcher.MarkDone(path)
+	}
+}
+
+// fileEvent builds the event of a file outcome
+func (p *Processor) fileEvent(path, hash string, outcome status.Status, cause error) Event {
+	e := Event{
+		Kind:    eventKinds[outcome],
+		Path:    p.redactor.Text(path),
+		Source:  p.redactor.Text(sourceOf(p.cfg.Path, path)),
+		SHA256:  hash,
+		Outcome: outcome,
+		At:      time.Now(),
+	}
+	if cause != nil {
+		e.Error = p.redactor.Text(cause.Error())
+	}
+	return e
+}
+
+// sourceOf returns the top-level directory of path below the input root
+func sourceOf(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "."
+	}
+	source, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found {
+		return "."
+	}
+	return source
+}
+
+// Stats returns the outcome counters since the processor started
+func (p *Processor) Stats() Stats {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	bySource := make(map[string]Counts, len(p.stats.bySource))
+	for k, v := range p.stats.bySource {
+		bySource[k] = v
+	}
+	regressions, behind := p.clockStats()
+	return Stats{
+		Paused:       p.Paused(),
+		Totals:       p.stats.totals,
+		BySource:     bySource,
+		Panics:       p.stats.panics,
+		WatermarkLag: p.watermarkLag(),
+
+		ClockRegressions:    regressions,
+		ClockBehind:         behind,
+		LateManifestEntries: p.manifest.LateEntries(),
+		RestoredDuplicates:  p.restored.Load(),
+		SourceLeftovers:     p.leftovers.Load(),
+	}
+}
+
+// Recent returns the latest outcomes, newest first
+func (p *Processor) Recent() []Event {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	n := len(p.stats.recent)
+	events := make([]Event, 0, n)
+	for i := 1; i <= n; i++ {
+		events = append(events, p.stats.recent[(p.stats.next-i+n)%n])
+	}
+	return events
+}
+
+// Pause stops ProcessFiles from picking up work. Files keep being tracked
+// and are processed after Resume.
+func (p *Processor) Pause() {
+	if p.paused.CompareAndSwap(false, true) {
+		p.publishState(EventProcessingPaused)
+	}
+}
+
+// Resume undoes Pause
+func (p *Processor) Resume() {
+	if p.paused.CompareAndSwap(true, false) {
+		p.publishState(EventProcessingResumed)
+	}
+}
+
+// Paused reports whether processing is paused
+func (p *Processor) Paused() bool {
+	return p.paused.Load()
+}
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestSourceOf(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/input/a.csv", "."},
+		{"/input/vendor/a.csv", "vendor"},
+		{"/input/vendor/2026/a.csv", "vendor"},
+		{"/elsewhere/a.csv", "."},
+	}
+	for _, tt := range tests {
+		if got := sourceOf("/input", tt.path); got != tt.want {
+			t.Errorf("sourceOf(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestStats_CountsOutcomesPerSource(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	dir := filepath.Join(env.inputDir, "vendor")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	first := filepath.Join(dir, "a.csv")
+	second := filepath.Join(env.inputDir, "b.csv")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("same content"), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+	}
+
+	for _, path := range []string{first, second, filepath.Join(env.inputDir, "missing.csv")} {
+		_ = env.processor.processFile(path)
+	}
+
+	stats := env.processor.Stats()
+	if stats.Totals.Ingested != 1 || stats.Totals.Duplicate != 1 || stats.Totals.Vanished != 1 {
+		t.Errorf("Totals = %+v, want 1 ingested, 1 duplicate, 1 vanished", stats.Totals)
+	}
+	if got := stats.BySource["vendor"]; got.Ingested != 1 {
+		t.Errorf("vendor counts = %+v, want 1 ingested", got)
+	}
+	if got := stats.BySource["."]; got.Duplicate != 1 || got.Vanished != 1 {
+		t.Errorf("top-level counts = %+v, want 1 duplicate, 1 vanished", got)
+	}
+
+	recent := env.processor.Recent()
+	if len(recent) != 3 || recent[0].Outcome != status.Vanished || recent[2].Outcome != status.Ingested {
+		t.Errorf("Recent = %+v, want newest first", recent)
+	}
+}
+
+func TestRecent_KeepsLatest(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	for i := 0; i < recentLimit+5; i++ {
+		env.processor.record(fmt.Sprintf("/input/%d", i), "", status.Failed, nil)
+	}
+
+	recent := env.processor.Recent()
+	if len(recent) != recentLimit {
+		t.Fatalf("expected %d events, got %d", recentLimit, len(recent))
+	}
+	if recent[0].Path != fmt.Sprintf("/input/%d", recentLimit+4) {
+		t.Errorf("newest event = %q", recent[0].Path)
+	}
+	if recent[recentLimit-1].Path != "/input/5" {
+		t.Errorf("oldest event = %q, want /input/5", recent[recentLimit-1].Path)
+	}
+	if env.processor.Stats().Totals.Failed != int64(recentLimit+5) {
+		t.Errorf("Failed = %d, want %d", env.processor.Stats().Totals.Failed, recentLimit+5)
+	}
+}
+
+func TestProcessFiles_Paused(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte("content"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	// Wait for stability window
+	time.Sleep(2 * time.Second)
+
+	env.processor.Pause()
+	env.processor.ProcessFiles()
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("paused processor should leave the source: %v", err)
+	}
+
+	env.processor.Resume()
+	env.processor.ProcessFiles()
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source should be ingested after resume, stat error = %v", err)
+	}
+}
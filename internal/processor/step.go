@@ -0,0 +1,385 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Built-in step names
+const (
+	StepDedup       = "dedup"
+	StepResolve     = "resolve"
+	StepValidateCSV = "validate-csv"
+	StepCompress    = "compress"
+	StepTag         = "tag"
+	StepClaim       = "claim"
+	StepMove        = "move"
+	StepManifest    = "manifest"
+	StepReceipt     = "receipt"
+)
+
+// DefaultSteps is the pipeline of files matching no configured pipeline. It
+// is the ingest sequence the processor always ran.
+var DefaultSteps = []string{StepDedup, StepResolve, StepTag, StepClaim, StepMove, StepManifest, StepReceipt}
+
+// Step is one stage of the post-hash pipeline applied to every file. Steps
+// run in order and hand state to each other through the FileContext. A step
+// may end the pipeline early by setting Done, quarantine the file by
+// returning an error from quarantineError, or fail it with any other error.
+//
+// Steps before claim have no side effects besides temporary files, and a
+// failure after claim releases it, so retrying a failed file on the next
+// tick re-runs the whole pipeline safely.
+type Step interface {
+	Name() string
+	Apply(ctx context.Context, fc *FileContext) error
+}
+
+// FileContext is the state of one file handed from step to step
+type FileContext struct {
+	// SourcePath is the file in the input directory and RelPath its
+	// slash-separated path relative to the input directory
+	SourcePath string
+	RelPath    string
+	Info       os.FileInfo
+	// Allocated is the bytes the source occupies on disk
+	Allocated int64
+	SHA256    string
+	// Started is when hashing of the file began
+	Started time.Time
+	// IdempotencyKey is the producer-supplied key of the file, if any
+	IdempotencyKey string
+	// SelfTest marks the probe of a self-test
+	SelfTest bool
+	// Priority is the priority class of the file, empty when no classes
+	// are configured
+	Priority string
+	// SourceURL is where a file of a URL list was downloaded from. Its
+	// SourcePath is the path next to the list it is ingested under, where
+	// no file exists.
+	SourceURL string
+
+	// ContentPath holds the content to ingest: the source itself, or a
+	// temporary file written by a transforming step such as compress
+	ContentPath string
+	// DestRelPath is RelPath as rewritten by the rename rules, set by
+	// resolve; the destination and versions are derived from it
+	DestRelPath string
+	// Dest is the warehouse destination set by resolve. LatestPath is the
+	// unversioned destination a latest link is kept at.
+	Dest       resolvedPath
+	LatestPath string
+	Tags       storage.Tags
+
+	// Record is the database record claimed by the claim step
+	Record  storage.FileRecord
+	Claimed bool
+
+	// Suspect is the record whose warehouse copy failed the byte comparison
+	// of paranoid dedup; the file is then ingested under a different name
+	Suspect *storage.File
+	// Restores is the duplicate's original whose warehouse copy is missing;
+	// the file is then ingested in its place
+	Restores *storage.File
+	// Supersedes is the duplicate's original recorded before the dedup
+	// window; the file is then ingested again after it
+	Supersedes *storage.File
+	// LinkCount is the link count of the warehouse copy when it shares the
+	// inode of the kept source
+	LinkCount int
+
+	// Done stops the pipeline without error once the file is handled, for
+	// example as a duplicate or in dry run
+	Done bool
+
+	// manifest is set when the pipeline writes manifest entries
+	manifest bool
+	temps    []string
+	// timing is the breakdown of a sampled file, nil for the others
+	timing *timing
+}
+
+// origin returns where the content of fc comes from
+func (fc *FileContext) origin() origin {
+	if fc.SourceURL != "" {
+		return origin{path: fc.SourcePath, content: fc.ContentPath, url: fc.SourceURL}
+	}
+	return origin{path: fc.SourcePath, content: fc.SourcePath}
+}
+
+// Size returns the size of the source file
+func (fc *FileContext) Size() int64 {
+	return fc.Info.Size()
+}
+
+// allocatedSize returns the allocated size of the source when it differs
+// materially from its size, or 0
+func (fc *FileContext) allocatedSize() int64 {
+	if !fileops.AllocationDiffers(fc.Size(), fc.Allocated) {
+		return 0
+	}
+	return fc.Allocated
+}
+
+// addTemp registers a temporary file or directory removed when the pipeline
+// ends
+func (fc *FileContext) addTemp(path string) {
+	fc.temps = append(fc.temps, path)
+}
+
+// StepError attributes a pipeline failure to the step that caused it
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %s: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// quarantineErr asks the executor to quarantine the file for reason
+type quarantineErr struct {
+	reason string
+	err    error
+}
+
+func (e *quarantineErr) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+func (e *quarantineErr) Unwrap() error {
+	return e.err
+}
+
+// quarantineError returns an error that quarantines the file for reason
+func quarantineError(reason string, err error) error {
+	return &quarantineErr{reason: reason, err: err}
+}
+
+// builtinSteps registers the steps a pipeline can be built from
+var builtinSteps = map[string]func(p *Processor) Step{
+	StepDedup:       func(p *Processor) Step { return dedupStep{p} },
+	StepResolve:     func(p *Processor) Step { return resolveStep{p} },
+	StepValidateCSV: func(p *Processor) Step { return validateCSVStep{} },
+	StepCompress:    func(p *Processor) Step { return compressStep{p} },
+	StepTag:         func(p *Processor) Step { return tagStep{p} },
+	StepClaim:       func(p *Processor) Step { return claimStep{p} },
+	StepMove:        func(p *Processor) Step { return moveStep{p} },
+	StepManifest:    func(p *Processor) Step { return manifestStep{p} },
+	StepReceipt:     func(p *Processor) Step { return receiptStep{p} },
+}
+
+// stepOrder lists constraints between steps: each key must come after every
+// step listed for it when both are present
+var stepOrder = map[string][]string{
+	StepResolve:  {StepDedup},
+	StepCompress: {StepResolve},
+	StepClaim:    {StepResolve, StepCompress},
+	StepMove:     {StepClaim},
+	StepManifest: {StepMove},
+	StepReceipt:  {StepMove},
+}
+
+// requiredSteps must appear in every pipeline
+var requiredSteps = []string{StepResolve, StepClaim, StepMove}
+
+// buildSteps instantiates the named steps after validating their order
+func (p *Processor) buildSteps(names []string) ([]Step, error) {
+	for _, name := range requiredSteps {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("missing required step %q", name)
+		}
+	}
+
+	steps := make([]Step, 0, len(names))
+	for i, name := range names {
+		newStep, ok := builtinSteps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown step %q", name)
+		}
+		if slices.Contains(names[:i], name) {
+			return nil, fmt.Errorf("step %q listed twice", name)
+		}
+		for _, before := range stepOrder[name] {
+			if slices.Contains(names[i+1:], before) {
+				return nil, fmt.Errorf("step %q must come after %q", name, before)
+			}
+		}
+		steps = append(steps, newStep(p))
+	}
+	return steps, nil
+}
+
+// pipelineRoute is a compiled pipeline rule
+type pipelineRoute struct {
+	re    *regexp.Regexp
+	steps []Step
+}
+
+// SetPipelines selects the steps of files whose path relative to the input
+// directory matches each rule's pattern. Rules are matched in order; files
+// matching none run DefaultSteps. Pipelines apply to single files, not to
+// multi-part sets or directory batches.
+func (p *Processor) SetPipelines(rules []config.PipelineRule) error {
+	routes := make([]pipelineRoute, 0, len(rules))
+	for i, r := range rules {
+		if r.Pattern == "" {
+			return fmt.Errorf("pipeline rule %d: empty pattern", i+1)
+		}
+		re, err := glob.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("pipeline rule %d: pattern %q: %w", i+1, r.Pattern, err)
+		}
+		steps, err := p.buildSteps(r.Steps)
+		if err != nil {
+			return fmt.Errorf("pipeline rule %d: %w", i+1, err)
+		}
+		routes = append(routes, pipelineRoute{re: re, steps: steps})
+	}
+	p.pipelines = routes
+	return nil
+}
+
+// pipelineFor returns the steps of the file at relPath
+func (p *Processor) pipelineFor(relPath string) []Step {
+	for _, r := range p.pipelines {
+		if r.re.MatchString(relPath) {
+			return r.steps
+		}
+	}
+	return p.defaultSteps
+}
+
+// runSteps applies steps to fc in order. Quarantine requests are honored,
+// other failures release a claimed record so the file is retried, and every
+// error names the failing step.
+func (p *Processor) runSteps(steps []Step, fc *FileContext) error {
+	defer func() {
+		for _, tmp := range fc.temps {
+			_ = os.RemoveAll(tmp)
+		}
+		p.order.release(fc.Record.Sequence)
+	}()
+
+	fc.manifest = slices.ContainsFunc(steps, func(s Step) bool { return s.Name() == StepManifest })
+	for _, step := range steps {
+		start := time.Now()
+		// A panicking step fails the file like an error, so the claim is
+		// released and temporary files removed
+		err := guard(func() error { return step.Apply(p.ctx, fc) })
+		p.steps.observe(step.Name(), time.Since(start), err)
+		fc.timing.lap(stepStage(step.Name()))
+		slog.Debug("pipeline step finished", "path", fc.SourcePath, "step", step.Name(), "duration", time.Since(start), "error", err)
+
+		var qerr *quarantineErr
+		switch {
+		case err == nil:
+			if fc.Done {
+				p.endTiming(fc, status.Duplicate)
+				return nil
+			}
+			continue
+		case errors.Is(err, ErrSourceVanished):
+			p.endTiming(fc, status.Vanished)
+			return err
+		case errors.As(err, &qerr) && p.cfg.DryRun:
+			p.releaseClaim(fc)
+			p.wouldQuarantine(fc, qerr.reason, qerr.err)
+			p.endTiming(fc, status.Quarantined)
+			return nil
+		case errors.As(err, &qerr):
+			p.releaseClaim(fc)
+			err := p.quarantineOrigin(fc.origin(), fc.SHA256, fc.Size(), qerr.reason, step.Name(), qerr.err)
+			fc.timing.lap(timeCopy)
+			p.endTiming(fc, status.Quarantined)
+			return err
+		default:
+			p.releaseClaim(fc)
+			p.endTiming(fc, status.Failed)
+			return fmt.Errorf("process file %s: %w", fc.SourcePath, &StepError{Step: step.Name(), Err: err})
+		}
+	}
+
+	p.watcher.RemoveFromTracking(fc.SourcePath)
+	p.recordEntry(fc.entry(), status.Ingested, nil, fc.Started)
+	fc.timing.lap(timeNotify)
+	p.endTiming(fc, status.Ingested)
+	slog.Info("file processed successfully",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"destination", fc.Dest.path,
+		"size", fc.Size(),
+		"tags", fc.Tags,
+	)
+	return nil
+}
+
+// releaseClaim deletes the record claimed for fc so the file is retried on
+// the next tick
+func (p *Processor) releaseClaim(fc *FileContext) {
+	if !fc.Claimed {
+		return
+	}
+	if err := p.storage.ReleaseFile(fc.SHA256); err != nil {
+		slog.Error("failed to release database record", "path", fc.SourcePath, "sha256", fc.SHA256, "error", err)
+	}
+	fc.Claimed = false
+}
+
+// StepStats reports how often a pipeline step ran and how long it took
+type StepStats struct {
+	Runs     int64         `json:"runs"`
+	Failures int64         `json:"failures"`
+	Total    time.Duration `json:"total_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+// stepMetrics aggregates StepStats per step name
+type stepMetrics struct {
+	mu    sync.Mutex
+	steps map[string]StepStats
+}
+
+func newStepMetrics() *stepMetrics {
+	return &stepMetrics{steps: make(map[string]StepStats)}
+}
+
+func (m *stepMetrics) observe(name string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.steps[name]
+	s.Runs++
+	if err != nil {
+		s.Failures++
+	}
+	s.Total += d
+	s.Max = max(s.Max, d)
+	m.steps[name] = s
+}
+
+// StepStats returns timing and failure counts of every pipeline step run
+// since startup, keyed by step name
+func (p *Processor) StepStats() map[string]StepStats {
+	p.steps.mu.Lock()
+	defer p.steps.mu.Unlock()
+	return maps.Clone(p.steps.steps)
+}
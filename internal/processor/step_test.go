@@ -0,0 +1,261 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// funcStep is a test step running fn
+type funcStep struct {
+	name string
+	fn   func(fc *FileContext) error
+}
+
+func (s funcStep) Name() string { return s.name }
+
+func (s funcStep) Apply(_ context.Context, fc *FileContext) error { return s.fn(fc) }
+
+// newFileContext hashes a new input file and returns its context
+func newFileContext(t *testing.T, env *testEnv, name, content string) *FileContext {
+	t.Helper()
+
+	src := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	h, err := env.processor.hashSource(src)
+	if err != nil {
+		t.Fatalf("hashSource() error = %v", err)
+	}
+	return &FileContext{SourcePath: src, RelPath: name, Info: h.info, SHA256: h.hash, ContentPath: src}
+}
+
+// builtins instantiates the named built-in steps without validation
+func builtins(p *Processor, names ...string) []Step {
+	steps := make([]Step, 0, len(names))
+	for _, name := range names {
+		steps = append(steps, builtinSteps[name](p))
+	}
+	return steps
+}
+
+func TestBuildSteps(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	tests := []struct {
+		name    string
+		steps   []string
+		wantErr string
+	}{
+		{"default", DefaultSteps, ""},
+		{"minimal", []string{StepResolve, StepClaim, StepMove}, ""},
+		{"with transforms", []string{StepDedup, StepResolve, StepValidateCSV, StepCompress, StepClaim, StepMove, StepManifest}, ""},
+		{"missing claim", []string{StepResolve, StepMove}, `missing required step "claim"`},
+		{"unknown", []string{StepResolve, StepClaim, StepMove, "encrypt"}, `unknown step "encrypt"`},
+		{"twice", []string{StepResolve, StepTag, StepTag, StepClaim, StepMove}, `step "tag" listed twice`},
+		{"move before claim", []string{StepResolve, StepMove, StepClaim}, `step "move" must come after "claim"`},
+		{"compress after claim", []string{StepResolve, StepClaim, StepCompress, StepMove}, `step "claim" must come after "compress"`},
+		{"resolve before dedup", []string{StepResolve, StepDedup, StepClaim, StepMove}, `step "resolve" must come after "dedup"`},
+		{"manifest before move", []string{StepResolve, StepClaim, StepManifest, StepMove}, `step "manifest" must come after "move"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := env.processor.buildSteps(tt.steps)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("buildSteps() error = %v", err)
+				}
+				if len(steps) != len(tt.steps) {
+					t.Errorf("buildSteps() returned %d steps, want %d", len(steps), len(tt.steps))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("buildSteps() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunSteps_OrderAndContext(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	var order []string
+	trace := func(name string, fn func(fc *FileContext) error) Step {
+		return funcStep{name: name, fn: func(fc *FileContext) error {
+			order = append(order, name)
+			return fn(fc)
+		}}
+	}
+	p := env.processor
+	steps := []Step{
+		trace("first", func(fc *FileContext) error {
+			if fc.SHA256 == "" || fc.Size() != 4 {
+				t.Errorf("first step got hash %q and size %d", fc.SHA256, fc.Size())
+			}
+			return nil
+		}),
+		builtins(p, StepResolve)[0],
+		trace("label", func(fc *FileContext) error {
+			if fc.Dest.path != filepath.Join(env.warehouseDir, "data.csv") {
+				t.Errorf("destination after resolve = %q", fc.Dest.path)
+			}
+			fc.Tags = storage.Tags{"checked": "yes"}
+			return nil
+		}),
+		builtins(p, StepClaim)[0],
+		trace("claimed", func(fc *FileContext) error {
+			if !fc.Claimed || fc.Record.SHA256 != fc.SHA256 {
+				t.Errorf("claim did not hand over its record: %+v", fc.Record)
+			}
+			return nil
+		}),
+		builtins(p, StepMove)[0],
+	}
+
+	fc := newFileContext(t, env, "data.csv", "a,b\n")
+	if err := p.runSteps(steps, fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+
+	if got := strings.Join(order, ","); got != "first,label,claimed" {
+		t.Errorf("step order = %s", got)
+	}
+	rec, err := env.store.FindBySHA256(fc.SHA256)
+	if err != nil || rec == nil {
+		t.Fatalf("record missing: %v", err)
+	}
+	if rec.Tags["checked"] != "yes" {
+		t.Errorf("tags set by a step were not recorded: %v", rec.Tags)
+	}
+
+	stats := p.StepStats()
+	for _, name := range []string{StepResolve, StepClaim, StepMove} {
+		if stats[name].Runs != 1 || stats[name].Failures != 0 {
+			t.Errorf("StepStats()[%s] = %+v", name, stats[name])
+		}
+	}
+}
+
+func TestRunSteps_FailureInTheMiddle(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	p := env.processor
+	errBoom := errors.New("upload refused")
+	ranAfter := false
+	steps := append(builtins(p, StepResolve, StepClaim),
+		funcStep{name: "upload", fn: func(*FileContext) error { return errBoom }},
+		funcStep{name: "notify", fn: func(*FileContext) error { ranAfter = true; return nil }},
+		builtins(p, StepMove)[0],
+	)
+
+	fc := newFileContext(t, env, "data.csv", "a,b\n")
+	err := p.runSteps(steps, fc)
+
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || stepErr.Step != "upload" || !errors.Is(err, errBoom) {
+		t.Fatalf("runSteps() error = %v, want upload step error", err)
+	}
+	if ranAfter {
+		t.Error("steps after the failure should not run")
+	}
+	if rec, _ := env.store.FindBySHA256(fc.SHA256); rec != nil {
+		t.Error("claim should be released after a failure")
+	}
+	if _, err := os.Stat(fc.SourcePath); err != nil {
+		t.Errorf("source should stay for a retry: %v", err)
+	}
+	if got := p.StepStats()["upload"]; got.Failures != 1 {
+		t.Errorf("upload failures = %d, want 1", got.Failures)
+	}
+
+	// The retry runs the pipeline from the start
+	if err := p.runSteps(builtins(p, DefaultSteps...), fc); err != nil {
+		t.Fatalf("retry error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "data.csv")); err != nil {
+		t.Errorf("retried file missing from warehouse: %v", err)
+	}
+}
+
+func TestRunSteps_QuarantineNamesStep(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	p := env.processor
+	if err := p.SetPipelines([]config.PipelineRule{{
+		Pattern: "**/*.csv",
+		Steps:   []string{StepDedup, StepResolve, StepValidateCSV, StepClaim, StepMove},
+	}}); err != nil {
+		t.Fatalf("SetPipelines() error = %v", err)
+	}
+
+	src := filepath.Join(env.inputDir, "broken.csv")
+	if err := os.WriteFile(src, []byte("a,b\nc\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := p.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(env.cfg.QuarantinePath, "*.reason.json"))
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 quarantine record, got %d", len(matches))
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("failed to read quarantine record: %v", err)
+	}
+	var record quarantineRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode quarantine record: %v", err)
+	}
+	if record.Reason != ReasonInvalidCSV || record.Step != StepValidateCSV {
+		t.Errorf("quarantine record = %+v, want reason %s from step %s", record, ReasonInvalidCSV, StepValidateCSV)
+	}
+	if rec, _ := env.store.FindBySHA256(record.SHA256); rec != nil {
+		t.Error("quarantined file should not be recorded as ingested")
+	}
+}
+
+func TestSetPipelines_Routing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	p := env.processor
+	err := p.SetPipelines([]config.PipelineRule{
+		{Pattern: "raw/**", Steps: []string{StepResolve, StepClaim, StepMove}},
+		{Pattern: "**/*.csv", Steps: DefaultSteps},
+	})
+	if err != nil {
+		t.Fatalf("SetPipelines() error = %v", err)
+	}
+
+	if got := len(p.pipelineFor("raw/data.csv")); got != 3 {
+		t.Errorf("raw/data.csv runs %d steps, want the first matching rule's 3", got)
+	}
+	if got := len(p.pipelineFor("other/data.csv")); got != len(DefaultSteps) {
+		t.Errorf("other/data.csv runs %d steps, want %d", got, len(DefaultSteps))
+	}
+	if got := p.pipelineFor("data.json"); len(got) != len(p.defaultSteps) {
+		t.Errorf("unmatched file runs %d steps, want the default pipeline", len(got))
+	}
+
+	err = p.SetPipelines([]config.PipelineRule{{Pattern: "*.csv", Steps: []string{StepResolve}}})
+	if err == nil || !strings.Contains(err.Error(), "pipeline rule 1") {
+		t.Errorf("SetPipelines() error = %v, want invalid rule 1", err)
+	}
+}
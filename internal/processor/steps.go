@@ -0,0 +1,610 @@
+package processor
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// ReasonInvalidCSV quarantines a file rejected by the validate-csv step
+const ReasonInvalidCSV = "invalid_csv"
+
+// compressedExt is appended to destinations of the compress step
+const compressedExt = ".gz"
+
+// skipDuplicate ends processing of a file whose content is already in the
+// warehouse as original. dedup is the manifest dedup method that matched it.
+// While its source is in a resend storm the duplicate is only counted
+// towards the next summary of the source. A duplicate at the path its
+// original was ingested from is that source, left in place by a failed
+// removal, and is removed now unless sources are kept. In dry run it is
+// only logged.
+func (p *Processor) skipDuplicate(fc *FileContext, original *storage.File, dedup string) {
+	if p.cfg.DryRun {
+		slog.Info("dry run: would skip duplicate",
+			"path", fc.SourcePath,
+			"sha256", fc.SHA256,
+			"size", fc.Size(),
+			"dedup", dedup,
+			"original", original.Path,
+			"original_destination", original.DestPath,
+		)
+		p.dryRun.wouldSkip(fc.Size())
+		p.watcher.RemoveFromTracking(fc.SourcePath)
+		fc.Done = true
+		return
+	}
+	slog.Info("file already processed, skipping",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"size", fc.Size(),
+		"dedup", dedup,
+		"idempotency_key", fc.IdempotencyKey,
+		"original", original.Path,
+		"original_destination", original.DestPath,
+		"original_processed_at", original.ProcessedAt,
+	)
+	p.watcher.RemoveFromTracking(fc.SourcePath)
+	if original.Path == fc.SourcePath && fc.SourceURL == "" && !fc.SelfTest {
+		if original.DestPath != "" && p.copyExists(original.DestPath) {
+			p.disposeSource(fc.SourcePath)
+		} else {
+			slog.Warn("warehouse copy of leftover source missing, keeping the source",
+				"path", fc.SourcePath,
+				"original_destination", original.DestPath,
+			)
+		}
+	}
+	if !fc.SelfTest && p.storms.aggregate(sourceOf(p.cfg.Path, fc.SourcePath), fc.Size(), false, p.clock()) {
+		p.writeReceipt(fc.SourcePath, Receipt{Status: status.Duplicate, SHA256: fc.SHA256, Destination: original.DestPath})
+		p.recordSummarized(fc.SourcePath, fc.SHA256, fc.Started)
+		fc.Done = true
+		return
+	}
+	processedAt, seq := p.stamp()
+	defer p.order.release(seq)
+	entry := manifest.Entry{
+		SHA256:      fc.SHA256,
+		Name:        filepath.Base(fc.SourcePath),
+		SourcePath:  fc.SourcePath,
+		DestPath:    original.DestPath,
+		Size:        fc.Size(),
+		ProcessedAt: processedAt,
+		Status:      status.Duplicate,
+		Dedup:       dedup,
+		Sequence:    seq,
+
+		IdempotencyKey: fc.IdempotencyKey,
+		SelfTest:       fc.SelfTest,
+		AllocatedSize:  fc.allocatedSize(),
+		Priority:       fc.Priority,
+		SourceURL:      fc.SourceURL,
+	}
+	if dedup == manifest.DedupKey {
+		entry.OriginalSHA256 = original.SHA256
+	}
+	p.recordEntry(entry, status.Duplicate, nil, fc.Started)
+	if !fc.SelfTest {
+		err := p.storage.RecordDuplicate(storage.DuplicateRecord{
+			DetectedAt: processedAt,
+			Source:     sourceOf(p.cfg.Path, fc.SourcePath),
+			Path:       fc.SourcePath,
+			SHA256:     fc.SHA256,
+			Size:       fc.Size(),
+			Dedup:      dedup,
+			Original:   original,
+		})
+		if err != nil {
+			slog.Warn("failed to record duplicate for the digest", "path", fc.SourcePath, "error", err)
+		}
+	}
+	p.writeReceipt(fc.SourcePath, Receipt{Status: status.Duplicate, SHA256: fc.SHA256, Destination: original.DestPath})
+	if fc.manifest {
+		if err := p.appendEntry(entry, false); err != nil {
+			slog.Warn("failed to write manifest entry", "path", fc.SourcePath, "error", err)
+		}
+	}
+	fc.Done = true
+}
+
+// dedupStep skips content that was already ingested, after confirming the
+// match byte by byte in paranoid mode
+type dedupStep struct{ p *Processor }
+
+func (dedupStep) Name() string { return StepDedup }
+
+func (s dedupStep) Apply(_ context.Context, fc *FileContext) error {
+	original, err := s.p.storage.FindBySHA256(fc.SHA256)
+	if err != nil {
+		return fmt.Errorf("check file existence for %s: %w", fc.SourcePath, err)
+	}
+	if original == nil {
+		return s.dedupKey(fc)
+	}
+	if s.p.outsideDedupWindow(fc, original) || s.p.originalMissing(fc, original) {
+		return nil
+	}
+	if s.p.cfg.ParanoidDedup {
+		return s.p.confirmDuplicate(fc, original)
+	}
+	s.p.skipDuplicate(fc, original, manifest.DedupHash)
+	return nil
+}
+
+// dedupKey skips a file whose idempotency key was already ingested with
+// different content, as producers regenerating a resend do
+func (s dedupStep) dedupKey(fc *FileContext) error {
+	if fc.IdempotencyKey == "" {
+		return nil
+	}
+	original, err := s.p.storage.FindByIdempotencyKey(fc.IdempotencyKey)
+	if err != nil {
+		return fmt.Errorf("check idempotency key of %s: %w", fc.SourcePath, err)
+	}
+	if original != nil && !s.p.outsideDedupWindow(fc, original) && !s.p.originalMissing(fc, original) {
+		s.p.skipDuplicate(fc, original, manifest.DedupKey)
+	}
+	return nil
+}
+
+// resolveStep computes the warehouse destination, quarantining files whose
+// destination cannot fit the path limits
+type resolveStep struct{ p *Processor }
+
+func (resolveStep) Name() string { return StepResolve }
+
+func (s resolveStep) Apply(_ context.Context, fc *FileContext) error {
+	destRel, rule, err := s.p.renamer.Apply(fc.RelPath)
+	if err != nil {
+		return s.quarantine(fc, ReasonInvalidRename, err)
+	}
+	if rule > 0 {
+		slog.Debug("destination renamed", "path", fc.SourcePath, "rule", rule, "renamed", destRel)
+	}
+	fc.DestRelPath = destRel
+
+	dst, err := s.p.destination(filepath.FromSlash(destRel), destRel, fc.SHA256)
+	if errors.Is(err, ErrPathTooLong) {
+		return s.quarantine(fc, ReasonPathTooLong, err)
+	}
+	if err != nil {
+		return fmt.Errorf("resolve destination for %s: %w", fc.SourcePath, err)
+	}
+	if dst, err = s.p.avoidSuspect(fc, dst); err != nil {
+		return fmt.Errorf("resolve destination for %s: %w", fc.SourcePath, err)
+	}
+	if dst.originalName != "" && fileops.NormalizeName(s.p.limits.form, dst.originalName) != dst.name {
+		slog.Warn("destination name shortened to fit path limits",
+			"path", fc.SourcePath,
+			"original_name", dst.originalName,
+			"name", dst.name,
+		)
+	}
+	fc.Dest = renamedFrom(dst, fc.RelPath, destRel)
+	return nil
+}
+
+// quarantine quarantines a file whose destination cannot be resolved, or
+// only logs it in dry run
+func (s resolveStep) quarantine(fc *FileContext, reason string, err error) error {
+	if s.p.cfg.DryRun {
+		s.p.wouldQuarantine(fc, reason, err)
+		fc.Done = true
+		return nil
+	}
+	return quarantineError(reason, err)
+}
+
+// renamedFrom records the source name of relPath as the original name of
+// dst when a rename rule rewrote relPath to destRel
+func renamedFrom(dst resolvedPath, relPath, destRel string) resolvedPath {
+	if destRel == relPath {
+		return dst
+	}
+	dst.originalName = ""
+	if name := path.Base(relPath); name != dst.name {
+		dst.originalName = name
+	}
+	return dst
+}
+
+// validateCSVStep quarantines content that does not parse as CSV with the
+// same number of fields in every record
+type validateCSVStep struct{}
+
+func (validateCSVStep) Name() string { return StepValidateCSV }
+
+func (validateCSVStep) Apply(_ context.Context, fc *FileContext) error {
+	f, err := os.Open(fc.ContentPath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", fc.ContentPath, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	r := csv.NewReader(f)
+	r.ReuseRecord = true
+	for {
+		_, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return quarantineError(ReasonInvalidCSV, err)
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", fc.ContentPath, err)
+		}
+	}
+}
+
+// compressStep gzips the content into a temporary file next to the
+// destination and appends .gz to the destination name. The temporary file is
+// rewritten from scratch on every attempt.
+type compressStep struct{ p *Processor }
+
+func (compressStep) Name() string { return StepCompress }
+
+func (s compressStep) Apply(ctx context.Context, fc *FileContext) error {
+	dst, err := s.p.destination(filepath.FromSlash(fc.DestRelPath)+compressedExt, fc.DestRelPath, fc.SHA256)
+	if err != nil {
+		return fmt.Errorf("resolve compressed destination for %s: %w", fc.SourcePath, err)
+	}
+	dst = renamedFrom(dst, fc.RelPath, fc.DestRelPath)
+
+	dir := filepath.Dir(dst.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create destination directory %s: %w", dir, err)
+	}
+	tmp := filepath.Join(dir, "."+dst.name+".tmp")
+	fc.addTemp(tmp)
+
+	if err := gzipFile(ctx, fc.ContentPath, tmp, s.p.copyOpts.Sync != fileops.SyncNever); err != nil {
+		return err
+	}
+	fc.ContentPath = tmp
+	fc.Dest = dst
+	return nil
+}
+
+// gzipFile writes the gzip compression of src to dst
+func gzipFile(ctx context.Context, src, dst string, sync bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	defer func() { _ = out.Close() }()
+
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(src)
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := in.Read(buf)
+		if n > 0 {
+			if _, werr := zw.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("compress %s: %w", src, werr)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", src, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress %s: %w", src, err)
+	}
+	if sync {
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("sync %s: %w", dst, err)
+		}
+	}
+	return out.Close()
+}
+
+// tagStep evaluates the tagging rules
+type tagStep struct{ p *Processor }
+
+func (tagStep) Name() string { return StepTag }
+
+func (s tagStep) Apply(_ context.Context, fc *FileContext) error {
+	tags, err := s.p.rules.Evaluate(rules.File{
+		RelPath: fc.RelPath,
+		Size:    fc.Size(),
+		Path:    fc.SourcePath,
+	})
+	if err != nil {
+		return fmt.Errorf("evaluate tagging rules for %s: %w", fc.SourcePath, err)
+	}
+	fc.Tags = tags
+	return nil
+}
+
+// claimStep claims the content hash before touching the file. Losing the
+// insert race to another worker means the same content is already being
+// ingested, so the file is a duplicate. In dry run the pipeline ends here.
+type claimStep struct{ p *Processor }
+
+func (claimStep) Name() string { return StepClaim }
+
+func (s claimStep) Apply(_ context.Context, fc *FileContext) error {
+	p := s.p
+	if p.cfg.DryRun {
+		slog.Info("dry run: would process file",
+			"path", fc.SourcePath,
+			"sha256", fc.SHA256,
+			"destination", fc.Dest.path,
+			"size", fc.Size(),
+			"tags", fc.Tags,
+		)
+		p.dryRun.wouldIngest(fc.Size())
+		p.watcher.RemoveFromTracking(fc.SourcePath)
+		fc.Done = true
+		return nil
+	}
+
+	fc.LatestPath = fc.Dest.path
+	processedAt, seq := p.stamp()
+	// A claim that fails or loses leaves seq unused; a created one is
+	// released with fc.Record when the pipeline ends
+	defer func() {
+		if !fc.Claimed {
+			p.order.release(seq)
+		}
+	}()
+	rec := storage.FileRecord{
+		SHA256:       fc.SHA256,
+		Name:         fc.Dest.name,
+		OriginalName: fc.Dest.originalName,
+		Path:         fc.SourcePath,
+		Size:         fc.Size(),
+		Status:       status.Ingested,
+		DestPath:     fc.Dest.path,
+		ProcessedAt:  processedAt,
+		Tags:         fc.Tags,
+		// Spellings of the same name share versions
+		RelPath:        fileops.NormalizeName(p.limits.form, fc.DestRelPath),
+		IdempotencyKey: fc.IdempotencyKey,
+		AllocatedSize:  fc.allocatedSize(),
+		Sequence:       seq,
+		Source:         sourceOf(p.cfg.Path, fc.SourcePath),
+		// On the clock the dedup window is measured on
+		CreatedAt: processedAt,
+	}
+
+	var relPath string
+	if p.cfg.VersionOnNameConflict != "" {
+		var err error
+		if relPath, err = filepath.Rel(p.cfg.Destination, fc.Dest.path); err != nil {
+			return fmt.Errorf("calculate relative destination for %s: %w", fc.SourcePath, err)
+		}
+	}
+
+	var (
+		created  bool
+		existing *storage.File
+	)
+	err := p.ingestTx(func(tx *storage.Storage) error {
+		if fc.Restores != nil {
+			if err := p.markRestored(tx, fc); err != nil {
+				return err
+			}
+		}
+		if fc.Supersedes != nil {
+			if err := p.markSuperseded(tx, fc); err != nil {
+				return err
+			}
+		}
+		var err error
+		if p.cfg.VersionOnNameConflict != "" {
+			created, existing, err = p.claimVersion(tx, &rec, &fc.Dest, relPath)
+		} else {
+			created, existing, err = tx.CreateFileIfAbsent(rec)
+		}
+		if err != nil || !created {
+			return err
+		}
+		fc.Record = rec
+		return p.commitEntry(tx, fc.entry())
+	})
+	if err != nil {
+		return fmt.Errorf("create database record for %s: %w", fc.SourcePath, err)
+	}
+	if !created {
+		dedup := manifest.DedupHash
+		if existing.SHA256 != fc.SHA256 {
+			dedup = manifest.DedupKey
+		}
+		p.skipDuplicate(fc, existing, dedup)
+		return nil
+	}
+
+	fc.Record = rec
+	fc.Claimed = true
+	if fc.Restores != nil {
+		p.restored.Add(1)
+	}
+	p.failpoint(stageClaim)
+	return nil
+}
+
+// ingestTx runs fn, which creates the records of ingested files, in one
+// transaction, so that the manifest entries and notifications fn records
+// commit with the records
+func (p *Processor) ingestTx(fn func(tx *storage.Storage) error) error {
+	return p.storage.Transaction(fn)
+}
+
+// commitEntry records the manifest entry of an ingested file in tx when
+// entries are recorded in the database, holds it as pending when they are
+// written to manifest files, and holds its notification when notifications
+// are enabled. The later manifest append must use appendEntry with
+// committed set so the entry is not recorded twice and leaves pending.
+func (p *Processor) commitEntry(tx *storage.Storage, entry manifest.Entry) error {
+	if p.manifest.InDatabase() {
+		if err := tx.AppendManifestEntries(p.manifest.Prepare(entry)); err != nil {
+			return err
+		}
+	}
+	if p.manifest.WritesFiles() {
+		if err := tx.HoldManifestEntry(entry); err != nil {
+			return err
+		}
+	}
+	return p.holdNotification(tx, entry)
+}
+
+// moveStep places the content at its destination and disposes of the source
+type moveStep struct{ p *Processor }
+
+func (moveStep) Name() string { return StepMove }
+
+func (s moveStep) Apply(_ context.Context, fc *FileContext) error {
+	p := s.p
+
+	onSync := fc.timing.syncHook()
+	var err error
+	if fc.ContentPath == fc.SourcePath {
+		var method fileops.CopyMethod
+		if method, err = p.moveToWarehouse(fc.SourcePath, fc.Dest.path, onSync); err == nil {
+			p.recordFinalSize(fc)
+			p.recordLinks(fc, method)
+		}
+	} else {
+		opts := p.copyOpts
+		opts.OnSync = onSync
+		if err = fileops.MoveFileWithOptions(fc.ContentPath, fc.Dest.path, opts); err == nil && fc.SourceURL == "" {
+			p.disposeSource(fc.SourcePath)
+		}
+	}
+	if err != nil {
+		if fc.SourceURL == "" && isVanished(fc.SourcePath, err) {
+			// handleVanished releases the claim itself
+			fc.Claimed = false
+			return p.handleVanished(fc.SourcePath, fc.SHA256, fc.Dest.path, stageMove, err)
+		}
+		return err
+	}
+	p.setXattrs(fc.entry(), fc.Record.Source)
+	p.seal(fc.Dest.path)
+
+	if p.cfg.VersionLatestLink && fc.Record.Version > 0 {
+		p.updateLatestLink(fc.LatestPath, fc.Dest.path)
+	}
+	return nil
+}
+
+// recordFinalSize re-stats a source moved into the warehouse as is, so the
+// size recorded is the size ingested even when the source kept growing
+// after it was stat'ed. Failures are logged, the file is already in the
+// warehouse.
+func (p *Processor) recordFinalSize(fc *FileContext) {
+	info, err := os.Stat(fc.Dest.path)
+	if err != nil {
+		slog.Warn("failed to stat ingested file", "path", fc.SourcePath, "destination", fc.Dest.path, "error", err)
+		return
+	}
+	if info.Size() == fc.Size() {
+		return
+	}
+	slog.Warn("file size changed during processing",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"size", fc.Size(),
+		"final_size", info.Size(),
+	)
+	fc.Info = info
+	fc.Record.Size = info.Size()
+	if err := p.storage.UpdateSize(fc.SHA256, info.Size(), fc.allocatedSize()); err != nil {
+		slog.Warn("failed to record final size", "path", fc.SourcePath, "sha256", fc.SHA256, "error", err)
+	}
+}
+
+// manifestStep appends the manifest entry. Manifest errors are logged but do
+// not fail the file, which is already in the warehouse.
+type manifestStep struct{ p *Processor }
+
+func (manifestStep) Name() string { return StepManifest }
+
+func (s manifestStep) Apply(_ context.Context, fc *FileContext) error {
+	if err := s.p.appendEntry(fc.entry(), true); err != nil {
+		slog.Warn("failed to write manifest entry", "path", fc.SourcePath, "error", err)
+	}
+	return nil
+}
+
+// entry returns the manifest entry of the ingested file
+func (fc *FileContext) entry() manifest.Entry {
+	return manifest.Entry{
+		SHA256:       fc.SHA256,
+		Name:         fc.Dest.name,
+		OriginalName: fc.Dest.originalName,
+		SourcePath:   fc.SourcePath,
+		DestPath:     fc.Dest.path,
+		Size:         fc.Size(),
+		ProcessedAt:  fc.Record.ProcessedAt,
+		Status:       status.Ingested,
+		Tags:         fc.Tags,
+
+		Version:        fc.Record.Version,
+		PreviousSHA256: fc.Record.PreviousSHA256,
+		IdempotencyKey: fc.IdempotencyKey,
+		SelfTest:       fc.SelfTest,
+		AllocatedSize:  fc.allocatedSize(),
+		Sequence:       fc.Record.Sequence,
+		Restores:       fc.restores(),
+		Supersedes:     fc.supersedes(),
+		LinkCount:      fc.LinkCount,
+		Priority:       fc.Priority,
+		SourceURL:      fc.SourceURL,
+	}
+}
+
+// restores returns the warehouse path of the original the file restores
+func (fc *FileContext) restores() string {
+	if fc.Restores == nil {
+		return ""
+	}
+	return fc.Restores.DestPath
+}
+
+// supersedes returns the warehouse path of the original the file supersedes
+func (fc *FileContext) supersedes() string {
+	if fc.Supersedes == nil {
+		return ""
+	}
+	return fc.Supersedes.DestPath
+}
+
+// receiptStep writes the ingestion receipt when receipts are enabled
+type receiptStep struct{ p *Processor }
+
+func (receiptStep) Name() string { return StepReceipt }
+
+func (s receiptStep) Apply(_ context.Context, fc *FileContext) error {
+	s.p.writeReceipt(fc.SourcePath, Receipt{Status: status.Ingested, SHA256: fc.SHA256, Destination: fc.Dest.path})
+	return nil
+}
@@ -0,0 +1,520 @@
+package processor
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rename"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestCompressStep(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	p := env.processor
+	if err := p.SetPipelines([]config.PipelineRule{{
+		Pattern: "**/*.csv",
+		Steps:   []string{StepDedup, StepResolve, StepValidateCSV, StepCompress, StepTag, StepClaim, StepMove, StepManifest},
+	}}); err != nil {
+		t.Fatalf("SetPipelines() error = %v", err)
+	}
+
+	content := "id,name\n1,alpha\n2,beta\n"
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	// A temporary file left by an interrupted attempt is rewritten
+	stale := filepath.Join(env.warehouseDir, ".data.csv.gz.tmp")
+	if err := os.WriteFile(stale, []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("failed to create stale temp: %v", err)
+	}
+
+	if err := p.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	dst := filepath.Join(env.warehouseDir, "data.csv.gz")
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatalf("compressed file missing: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("destination is not gzip: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("decompressed content = %q, want %q", data, content)
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source should have been removed")
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("temporary file should not remain")
+	}
+
+	rec, err := env.store.FindByDestPath(dst)
+	if err != nil || rec == nil {
+		t.Fatalf("record for compressed destination missing: %v", err)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].DestPath != dst {
+		t.Errorf("manifest entries = %+v, want one for %s", entries, dst)
+	}
+}
+
+func TestValidateCSVStep(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"valid", "a,b\n1,2\n", true},
+		{"quoted", "a,b\n\"x,y\",2\n", true},
+		{"empty", "", true},
+		{"ragged", "a,b\n1\n", false},
+		{"bare quote", "a,b\n1,x\"y\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := newFileContext(t, env, tt.name+".csv", tt.content)
+			err := validateCSVStep{}.Apply(t.Context(), fc)
+			var qerr *quarantineErr
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("Apply() error = %v, want valid", err)
+			case !tt.valid && (!errors.As(err, &qerr) || qerr.reason != ReasonInvalidCSV):
+				t.Errorf("Apply() error = %v, want %s quarantine", err, ReasonInvalidCSV)
+			}
+		})
+	}
+}
+
+func TestClaimStep_DryRunEndsPipeline(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+
+	fc := newFileContext(t, env, "data.csv", "a,b\n")
+	if err := env.processor.runSteps(builtins(env.processor, DefaultSteps...), fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if !fc.Done || fc.Claimed {
+		t.Errorf("dry run should end at claim without claiming: done=%v claimed=%v", fc.Done, fc.Claimed)
+	}
+	if _, err := os.Stat(fc.SourcePath); err != nil {
+		t.Errorf("source should stay in dry run: %v", err)
+	}
+	if got := env.processor.StepStats()[StepMove].Runs; got != 0 {
+		t.Errorf("move ran %d times in dry run", got)
+	}
+}
+
+func TestRunSteps_DryRunDoesNotQuarantine(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	fc := newFileContext(t, env, "ragged.csv", "a,b\n1\n")
+	steps := builtins(env.processor, StepDedup, StepResolve, StepValidateCSV, StepClaim, StepMove, StepManifest)
+	if err := env.processor.runSteps(steps, fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+	if _, err := os.Stat(fc.SourcePath); err != nil {
+		t.Errorf("source should stay in dry run: %v", err)
+	}
+	if _, err := os.Stat(env.cfg.QuarantinePath); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote to the quarantine: %v", err)
+	}
+	if n := env.processor.dryRun.quarantine.Load(); n != 1 {
+		t.Errorf("would quarantine %d files, want 1", n)
+	}
+	if counts, err := env.store.CountByStatus(); err != nil || len(counts) != 0 {
+		t.Errorf("CountByStatus() = %v, %v, want nothing recorded", counts, err)
+	}
+}
+
+func TestSkipDuplicate_DryRunRecordsNothing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	original := filepath.Join(env.inputDir, "original.csv")
+	if err := os.WriteFile(original, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := env.processor.processFile(original); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	env.cfg.DryRun = true
+
+	dup := filepath.Join(env.inputDir, "resent.csv")
+	fresh := filepath.Join(env.inputDir, "fresh.csv")
+	if err := os.WriteFile(dup, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(fresh, []byte("c,d,e\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	for _, path := range []string{dup, fresh} {
+		if err := env.processor.processFile(path); err != nil {
+			t.Fatalf("processFile(%s) error = %v", path, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("source should stay in dry run: %v", err)
+		}
+	}
+
+	counts, err := env.store.CountByStatus()
+	if err != nil {
+		t.Fatalf("CountByStatus() error = %v", err)
+	}
+	if counts[status.Ingested] != 1 || counts[status.Duplicate] != 0 {
+		t.Errorf("database counts = %v, want only the original", counts)
+	}
+	if entries := readManifest(t, env.manifestsDir); len(entries) != 1 {
+		t.Errorf("manifest has %d entries, want only the original", len(entries))
+	}
+
+	tally := &env.processor.dryRun
+	if tally.ingest.Load() != 1 || tally.ingestBytes.Load() != int64(len("c,d,e\n")) {
+		t.Errorf("would ingest %d files of %d bytes, want the fresh one", tally.ingest.Load(), tally.ingestBytes.Load())
+	}
+	if tally.skip.Load() != 1 || tally.skipBytes.Load() != int64(len("a,b\n")) {
+		t.Errorf("would skip %d files of %d bytes, want the resent one", tally.skip.Load(), tally.skipBytes.Load())
+	}
+	env.processor.summarizeDryRun()
+	if tally.ingest.Load() != 0 || tally.skip.Load() != 0 {
+		t.Error("the summary should reset the tally for the next pass")
+	}
+}
+
+func TestSkipDuplicate_RemovesLeftoverSource(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// Ingested while sources were kept, as a failed removal leaves them
+	src := filepath.Join(env.inputDir, "left.csv")
+	if err := os.WriteFile(src, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	env.cfg.KeepSource = true
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("kept source is gone: %v", err)
+	}
+	env.cfg.KeepSource = false
+
+	resent := filepath.Join(env.inputDir, "resent.csv")
+	if err := os.WriteFile(resent, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	for _, path := range []string{src, resent} {
+		if err := env.processor.processFile(path); err != nil {
+			t.Fatalf("processFile(%s) error = %v", path, err)
+		}
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("leftover source should be removed once found again: %v", err)
+	}
+	if _, err := os.Stat(resent); err != nil {
+		t.Errorf("duplicate from another path should stay: %v", err)
+	}
+	counts, err := env.store.CountByStatus()
+	if err != nil || counts[status.Ingested] != 1 {
+		t.Errorf("CountByStatus() = %v, %v, want the leftover not ingested again", counts, err)
+	}
+}
+
+func TestSkipDuplicate_KeepsLeftoverWithoutCopy(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	// Claimed, then the ingestor stopped before the move: the record is
+	// committed but its warehouse copy was never written
+	content := "a,b\n"
+	src := filepath.Join(env.inputDir, "claimed.csv")
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	env.cfg.KeepSource = true
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	env.cfg.KeepSource = false
+	dest := filepath.Join(env.warehouseDir, "claimed.csv")
+	if err := os.Remove(dest); err != nil {
+		t.Fatalf("failed to remove warehouse copy: %v", err)
+	}
+
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() after restart error = %v", err)
+	}
+	if data, err := os.ReadFile(dest); err != nil || string(data) != content {
+		t.Fatalf("leftover not ingested again to restore its copy: %q, %v", data, err)
+	}
+	marked, err := env.store.ListFiles(storage.FileFilter{Status: status.MissingRestored})
+	if err != nil || len(marked) != 1 {
+		t.Errorf("missing_restored = %+v, %v, want the claimed record", marked, err)
+	}
+}
+
+func TestMoveStep_RecordsFinalSize(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	src := filepath.Join(env.inputDir, "growing.log")
+	if err := os.WriteFile(src, []byte("first line\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	// The producer appends once the file was hashed
+	env.processor.failpoints = map[string]func(){stageHash: func() {
+		f, err := os.OpenFile(src, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Errorf("failed to open source: %v", err)
+			return
+		}
+		_, _ = f.WriteString("second line\n")
+		_ = f.Close()
+	}}
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	const final = int64(len("first line\nsecond line\n"))
+	record, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "growing.log"))
+	if err != nil || record == nil {
+		t.Fatalf("FindByDestPath() = %v, %v", record, err)
+	}
+	if record.Size != final {
+		t.Errorf("database size = %d, want the final size %d", record.Size, final)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].Size != final {
+		t.Errorf("manifest = %+v, want one entry of size %d", entries, final)
+	}
+}
+
+func TestClaimStep_RecordsAllocatedSize(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	src := filepath.Join(env.inputDir, "disk.img")
+	const size = 1 << 30
+	if err := os.WriteFile(src, []byte("boot"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := os.Truncate(src, size); err != nil {
+		t.Fatalf("failed to extend test file: %v", err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("failed to stat test file: %v", err)
+	}
+	allocated := fileops.Allocated(info)
+	if !fileops.AllocationDiffers(size, allocated) {
+		t.Skipf("filesystem does not support sparse files: allocated %d of %d", allocated, size)
+	}
+
+	fc := &FileContext{
+		SourcePath:  src,
+		RelPath:     "disk.img",
+		Info:        info,
+		Allocated:   allocated,
+		SHA256:      "sparse",
+		ContentPath: src,
+	}
+	if err := env.processor.runSteps(builtins(env.processor, StepResolve, StepClaim, StepManifest), fc); err != nil {
+		t.Fatalf("runSteps() error = %v", err)
+	}
+
+	record, err := env.store.FindBySHA256("sparse")
+	if err != nil || record == nil {
+		t.Fatalf("FindBySHA256() = %v, %v", record, err)
+	}
+	if record.Size != size || record.AllocatedSize != allocated {
+		t.Errorf("database sizes = %d, %d, want %d, %d", record.Size, record.AllocatedSize, size, allocated)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].Size != size || entries[0].AllocatedSize != allocated {
+		t.Errorf("manifest = %+v, want sizes %d and %d", entries, size, allocated)
+	}
+}
+
+// useDatabaseSink records manifest entries of env in its state database,
+// and in the manifest files too when files is set
+func useDatabaseSink(env *testEnv, files bool) {
+	mw := manifest.NewWriter(env.manifestsDir)
+	mw.SetDatabase(env.store, files)
+	env.processor.SetManifest(mw)
+}
+
+func TestManifestSink_SameEntries(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	useDatabaseSink(env, true)
+
+	from := time.Now()
+	ingest(t, env, "a.csv", "alpha")
+	ingest(t, env, "b.csv", "beta")
+	ingest(t, env, "a-copy.csv", "alpha")
+
+	// A source vanishing after its claim releases the entry committed with
+	// the record
+	gone := filepath.Join(env.inputDir, "gone.csv")
+	if err := os.WriteFile(gone, []byte("gamma"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	env.processor.failpoints = map[string]func(){stageClaim: func() { _ = os.Remove(gone) }}
+	if err := env.processor.processFile(gone); !errors.Is(err, ErrSourceVanished) {
+		t.Fatalf("processFile() error = %v, want ErrSourceVanished", err)
+	}
+	to := time.Now()
+
+	files, err := env.processor.manifest.ManifestEntries(from, to)
+	if err != nil {
+		t.Fatalf("ManifestEntries() from files error = %v", err)
+	}
+	rows, err := env.store.ManifestEntries(from, to)
+	if err != nil {
+		t.Fatalf("ManifestEntries() from the database error = %v", err)
+	}
+	statuses := make([]status.Status, 0, len(files))
+	for i := range files {
+		statuses = append(statuses, files[i].Status)
+		// The database keeps UTC
+		files[i].ProcessedAt = files[i].ProcessedAt.UTC()
+	}
+	want := []status.Status{status.Ingested, status.Ingested, status.Duplicate, status.Vanished}
+	if !slices.Equal(statuses, want) {
+		t.Errorf("file entry statuses = %v, want %v", statuses, want)
+	}
+	if !reflect.DeepEqual(rows, files) {
+		t.Errorf("database entries = %+v\nwant the file entries %+v", rows, files)
+	}
+}
+
+func TestManifestSink_DatabaseOnly(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	useDatabaseSink(env, false)
+
+	from := time.Now()
+	ingest(t, env, "a.csv", "alpha")
+	if report := env.processor.SelfTest(t.Context()); !report.OK {
+		t.Errorf("SelfTest() = %+v, want OK with entries in the database", report)
+	}
+
+	if dirs, _ := os.ReadDir(env.manifestsDir); len(dirs) != 0 {
+		t.Errorf("manifests directory holds %d entries, want none", len(dirs))
+	}
+	entries, err := env.processor.manifest.ManifestEntries(from, time.Now())
+	if err != nil {
+		t.Fatalf("ManifestEntries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a.csv" || !entries[1].SelfTest {
+		t.Errorf("ManifestEntries() = %+v, want a.csv and the probe", entries)
+	}
+}
+
+func TestResolveStep_Rename(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+	renamer, err := rename.New([]config.RenameRule{{
+		Match:   `EXPORT-(?P<env>[A-Z]+)-(?P<y>\d{4})(?P<m>\d{2})(?P<d>\d{2})-[^/]*\.csv$`,
+		Replace: "${env}_${y}-${m}-${d}.csv",
+	}})
+	if err != nil {
+		t.Fatalf("rename.New() error = %v", err)
+	}
+	env.processor.SetRenamer(renamer)
+
+	// Both exports rename to the same path, so the second is a new version
+	ingest(t, env, "EXPORT-PROD-20240615-FINAL-v2 (1).csv", "first")
+	ingest(t, env, "EXPORT-PROD-20240615-FINAL-v3.csv", "second")
+
+	for name, content := range map[string]string{"PROD_2024-06-15.csv": "first", "PROD_2024-06-15.v2.csv": "second"} {
+		if data, err := os.ReadFile(filepath.Join(env.warehouseDir, name)); err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, content)
+		}
+	}
+	for source, version := range map[string]int{"EXPORT-PROD-20240615-FINAL-v2 (1).csv": 1, "EXPORT-PROD-20240615-FINAL-v3.csv": 2} {
+		e := entryOf(t, env, source)
+		if e.OriginalName != source || e.Version != version {
+			t.Errorf("entry of %s has original name %q, version %d, want the source name, version %d", source, e.OriginalName, e.Version, version)
+		}
+	}
+}
+
+func TestResolveStep_RenameOutsideWarehouse(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+	renamer, err := rename.New([]config.RenameRule{{Match: `^(.*)$`, Replace: "../$1"}})
+	if err != nil {
+		t.Fatalf("rename.New() error = %v", err)
+	}
+	env.processor.SetRenamer(renamer)
+
+	src := writeSourceFiles(t, env, "a.csv")[0]
+	if err := env.processor.processFile(src); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	e := entryOf(t, env, "a.csv")
+	if e.Status != status.Quarantined || e.Reason != ReasonInvalidRename {
+		t.Errorf("entry = %+v, want quarantined for %s", e, ReasonInvalidRename)
+	}
+}
+
+func TestSkipDuplicate_RecordsDuplicate(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	if err := os.MkdirAll(filepath.Join(env.inputDir, "acme"), 0o755); err != nil {
+		t.Fatalf("failed to create source directory: %v", err)
+	}
+	ingest(t, env, "orders.csv", "a,b\n1,2\n")
+	ingest(t, env, "acme/resent.csv", "a,b\n1,2\n")
+
+	stats, err := env.store.DuplicateStats(time.Time{}, time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("DuplicateStats() error = %v", err)
+	}
+	if stats.Duplicates != 1 || len(stats.Originals) != 1 ||
+		stats.Originals[0].DestPath != filepath.Join(env.warehouseDir, "orders.csv") {
+		t.Fatalf("stats = %+v, want one duplicate of orders.csv", stats)
+	}
+	want := []storage.SourceDuplicates{
+		{Source: "acme", Duplicates: 1, Bytes: 8},
+		{Source: ".", Ingested: 1, IngestedBytes: 8},
+	}
+	if !reflect.DeepEqual(stats.Sources, want) {
+		t.Errorf("sources = %+v, want the duplicate from acme and the original from the root", stats.Sources)
+	}
+}
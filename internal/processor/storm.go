@@ -0,0 +1,499 @@
+package processor
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Resend storm event kinds, published with the Source and Storm they concern
+const (
+	EventResendStorm      = "resend_storm"
+	EventResendStormEnded = "resend_storm_ended"
+)
+
+// stormBuckets is how many buckets the storm window is counted in; the
+// window slides by one bucket at a time
+const stormBuckets = 10
+
+// errStormRejected ends the processing of a file rejected by path and size
+// during a resend storm, already recorded as a duplicate
+var errStormRejected = errors.New("rejected as a duplicate of a resend storm")
+
+// StormStatus describes a source in a resend storm, or paused by one
+type StormStatus struct {
+	Source   string    `json:"source"`
+	Storming bool      `json:"storming"`
+	Since    time.Time `json:"since,omitzero"`
+	// Files and Duplicates count the arrivals of the current window
+	Files      int64   `json:"files"`
+	Duplicates int64   `json:"duplicates"`
+	Ratio      float64 `json:"ratio"`
+	// Rejected counts the files rejected by path and size without being
+	// hashed, and Summarized every duplicate folded into a summary, those
+	// rejected included
+	Rejected   int64 `json:"rejected"`
+	Summarized int64 `json:"summarized"`
+	// Paused is set from the start of the storm until an operator resumes
+	// the source, when storms pause their source
+	Paused bool `json:"paused"`
+}
+
+// stormBucket counts the arrivals of a source in one slice of the window
+type stormBucket struct {
+	start             time.Time
+	files, duplicates int64
+}
+
+// sourceStorm is the storm state of one source
+type sourceStorm struct {
+	buckets  []stormBucket
+	storming bool
+	since    time.Time
+	paused   bool
+
+	rejected   int64
+	summarized int64
+	// pending are the duplicates not written to a summary yet, the first
+	// of them summarized at pendingSince
+	pending      stormSummary
+	pendingSince time.Time
+}
+
+// stormSummary totals the duplicates of a source written as one record
+type stormSummary struct {
+	source string
+	files  int64
+	bytes  int64
+}
+
+// stormChange is a source entering or leaving a storm. The duplicates
+// pending when it left are summarized with it.
+type stormChange struct {
+	source  string
+	entered bool
+	status  StormStatus
+	summary stormSummary
+}
+
+// stormTracker detects resend storms from the ratio of duplicates among the
+// arrivals of each source over a sliding window
+type stormTracker struct {
+	cfg *config.Config
+
+	mu      sync.Mutex
+	sources map[string]*sourceStorm
+}
+
+func newStormTracker(cfg *config.Config) *stormTracker {
+	return &stormTracker{cfg: cfg, sources: make(map[string]*sourceStorm)}
+}
+
+func (t *stormTracker) enabled() bool {
+	return t.cfg.StormRatio > 0
+}
+
+// totals counts the arrivals of the buckets still within the window at now,
+// dropping the older ones
+func (t *stormTracker) totals(s *sourceStorm, now time.Time) (files, duplicates int64) {
+	cutoff := now.Add(-t.cfg.StormWindow)
+	kept := s.buckets[:0]
+	for _, b := range s.buckets {
+		if b.start.After(cutoff) {
+			kept = append(kept, b)
+			files += b.files
+			duplicates += b.duplicates
+		}
+	}
+	s.buckets = kept
+	return files, duplicates
+}
+
+// status describes s; callers hold t.mu
+func (t *stormTracker) status(source string, s *sourceStorm, now time.Time) StormStatus {
+	files, dups := t.totals(s, now)
+	st := StormStatus{
+		Source:     source,
+		Storming:   s.storming,
+		Since:      s.since,
+		Files:      files,
+		Duplicates: dups,
+		Rejected:   s.rejected,
+		Summarized: s.summarized,
+		Paused:     s.paused,
+	}
+	if files > 0 {
+		st.Ratio = float64(dups) / float64(files)
+	}
+	return st
+}
+
+// normal reports whether a source sending st no longer storms
+func (t *stormTracker) normal(st StormStatus) bool {
+	return st.Files < int64(t.cfg.StormMinFiles) || st.Ratio < t.cfg.StormRatio
+}
+
+// leave ends the storm of s, taking its pending duplicates; callers hold t.mu
+func (t *stormTracker) leave(source string, s *sourceStorm, st StormStatus) *stormChange {
+	s.storming = false
+	c := &stormChange{source: source, status: st, summary: s.pending}
+	c.status.Storming = false
+	s.pending, s.pendingSince = stormSummary{source: source}, time.Time{}
+	return c
+}
+
+// observe counts an arrival of source at now, a duplicate or not, and
+// returns the change it caused, if any
+func (t *stormTracker) observe(source string, duplicate bool, now time.Time) *stormChange {
+	if !t.enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sources[source]
+	if !ok {
+		s = &sourceStorm{pending: stormSummary{source: source}}
+		t.sources[source] = s
+	}
+	width := t.cfg.StormWindow / stormBuckets
+	start := now.Truncate(max(width, time.Nanosecond))
+	if n := len(s.buckets); n == 0 || s.buckets[n-1].start.Before(start) {
+		s.buckets = append(s.buckets, stormBucket{start: start})
+	}
+	b := &s.buckets[len(s.buckets)-1]
+	b.files++
+	if duplicate {
+		b.duplicates++
+	}
+
+	st := t.status(source, s, now)
+	switch {
+	case !s.storming && !t.normal(st):
+		s.storming, s.since = true, now
+		if t.cfg.StormPause {
+			s.paused = true
+		}
+		st = t.status(source, s, now)
+		return &stormChange{source: source, entered: true, status: st}
+	case s.storming && t.normal(st):
+		return t.leave(source, s, st)
+	}
+	return nil
+}
+
+// aggregate counts a duplicate of size bytes of source towards its next
+// summary, reporting whether it did: duplicates are summarized while their
+// source storms. Duplicates rejected by path and size are summarized
+// whatever the state, since they were only rejected during a storm.
+func (t *stormTracker) aggregate(source string, size int64, rejected bool, now time.Time) bool {
+	if !t.enabled() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sources[source]
+	if !ok || (!s.storming && !rejected) {
+		return false
+	}
+	if s.pendingSince.IsZero() {
+		s.pendingSince = now
+	}
+	s.pending.files++
+	s.pending.bytes += size
+	s.summarized++
+	if rejected {
+		s.rejected++
+	}
+	return true
+}
+
+// tick ends the storms whose window normalized without new arrivals and
+// takes the pending duplicates whose summary is due at now, or all of them
+// when final is set. Sources left with nothing to track are forgotten.
+func (t *stormTracker) tick(now time.Time, final bool) ([]stormChange, []stormSummary) {
+	if !t.enabled() {
+		return nil, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		changes   []stormChange
+		summaries []stormSummary
+	)
+	for source, s := range t.sources {
+		st := t.status(source, s, now)
+		if s.storming && t.normal(st) {
+			changes = append(changes, *t.leave(source, s, st))
+		}
+		if s.pending.files > 0 && (final || now.Sub(s.pendingSince) >= t.cfg.StormSummaryInterval) {
+			summaries = append(summaries, s.pending)
+			s.pending, s.pendingSince = stormSummary{source: source}, time.Time{}
+		}
+		if len(s.buckets) == 0 && !s.storming && !s.paused && s.pending.files == 0 {
+			delete(t.sources, source)
+		}
+	}
+	return changes, summaries
+}
+
+func (t *stormTracker) storming(source string) bool {
+	if !t.enabled() {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sources[source]
+	return ok && s.storming
+}
+
+func (t *stormTracker) paused(source string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sources[source]
+	return ok && s.paused
+}
+
+// resume unpauses the source match selects, returning its name
+func (t *stormTracker) resume(match func(source string) bool) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for source, s := range t.sources {
+		if s.paused && match(source) {
+			s.paused = false
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// statuses describes the sources storming or paused, by name
+func (t *stormTracker) statuses(now time.Time) []StormStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	storms := []StormStatus{}
+	for source, s := range t.sources {
+		if s.storming || s.paused {
+			storms = append(storms, t.status(source, s, now))
+		}
+	}
+	sort.Slice(storms, func(i, j int) bool { return storms[i].Source < storms[j].Source })
+	return storms
+}
+
+// observeStorm counts an ingested or duplicate file towards the resend storm
+// detection of its source
+func (p *Processor) observeStorm(path string, outcome status.Status) {
+	if outcome != status.Ingested && outcome != status.Duplicate {
+		return
+	}
+	if c := p.storms.observe(sourceOf(p.cfg.Path, path), outcome == status.Duplicate, p.clock()); c != nil {
+		p.stormChanged(*c)
+	}
+}
+
+// stormChanged alerts on a source entering or leaving a resend storm,
+// summarizing the duplicates it left pending
+func (p *Processor) stormChanged(c stormChange) {
+	st := c.status
+	attrs := []any{
+		"source", c.source,
+		"files", st.Files,
+		"duplicates", st.Duplicates,
+		"ratio", st.Ratio,
+		"window", p.cfg.StormWindow,
+	}
+	kind := EventResendStorm
+	if c.entered {
+		slog.Error("resend storm, rejecting files of the source already recorded under the same path and size and summarizing its duplicates",
+			append(attrs, "paused", st.Paused)...)
+	} else {
+		kind = EventResendStormEnded
+		slog.Warn("resend storm over", append(attrs, "since", st.Since, "rejected", st.Rejected, "summarized", st.Summarized)...)
+		p.writeStormSummary(c.summary)
+	}
+	st.Source = p.redactor.Text(st.Source)
+	p.events.publish(Event{Kind: kind, Source: st.Source, At: time.Now(), Storm: &st})
+}
+
+// tickStorms ends the resend storms that normalized and writes the summaries
+// that are due, or every pending one when final is set
+func (p *Processor) tickStorms(final bool) {
+	changes, summaries := p.storms.tick(p.clock(), final)
+	for _, c := range changes {
+		p.stormChanged(c)
+	}
+	for _, sum := range summaries {
+		p.writeStormSummary(sum)
+	}
+}
+
+// writeStormSummary records the duplicates of a resend storm as one
+// manifest entry and one duplicate record
+func (p *Processor) writeStormSummary(sum stormSummary) {
+	if sum.files == 0 {
+		return
+	}
+	dir := p.cfg.Path
+	if sum.source != "." {
+		dir = filepath.Join(p.cfg.Path, sum.source)
+	}
+	processedAt, seq := p.stamp()
+	defer p.order.release(seq)
+	entry := manifest.Entry{
+		Name:        filepath.Base(dir),
+		SourcePath:  dir,
+		Size:        sum.bytes,
+		ProcessedAt: processedAt,
+		Status:      status.DuplicateSummary,
+		Sequence:    seq,
+		Members:     int(sum.files),
+		MembersSize: sum.bytes,
+	}
+	if p.cfg.DryRun {
+		slog.Info("dry run: would summarize resend storm", "source", sum.source, "files", sum.files, "bytes", sum.bytes)
+	} else {
+		if err := p.appendEntry(entry, false); err != nil {
+			slog.Warn("failed to write resend storm summary to the manifest", "source", sum.source, "error", err)
+		}
+		err := p.storage.RecordDuplicate(storage.DuplicateRecord{
+			DetectedAt: processedAt,
+			Source:     sum.source,
+			Path:       dir,
+			Size:       sum.bytes,
+			Count:      sum.files,
+		})
+		if err != nil {
+			slog.Warn("failed to record resend storm summary for the digest", "source", sum.source, "error", err)
+		}
+	}
+	slog.Info("resend storm duplicates summarized", "source", sum.source, "files", sum.files, "bytes", sum.bytes)
+}
+
+// knownInStorm returns the record of a file ingested from the same path with
+// the same size as the source at path, when its source is in a resend storm.
+// The file is then taken for a duplicate without being hashed. Arrivals a
+// full check would ingest again, or compare byte by byte, are left to it.
+func (p *Processor) knownInStorm(path string, size int64) *storage.File {
+	if p.cfg.ParanoidDedup || !p.storms.storming(sourceOf(p.cfg.Path, path)) || p.isProbe(path) {
+		return nil
+	}
+	return p.recordedAt(path, size)
+}
+
+// recordedAt returns the record of a file ingested from the same path with
+// the same size as the source at path, nil when there is none or the dedup
+// options would not take the source for a duplicate of it
+func (p *Processor) recordedAt(path string, size int64) *storage.File {
+	rel, ok := p.recordRelPath(path)
+	if !ok {
+		return nil
+	}
+	original, err := p.storage.FindByRelPathSize(rel, size)
+	if err != nil {
+		slog.Warn("failed to look up file by path and size, hashing it", "path", path, "error", err)
+		return nil
+	}
+	if original == nil {
+		return nil
+	}
+	if p.cfg.DedupWindow > 0 && original.CreatedAt.Before(p.clock().Add(-p.cfg.DedupWindow)) {
+		return nil
+	}
+	if (p.cfg.VerifyDuplicates || original.Path == path) && original.DestPath != "" && !p.copyExists(original.DestPath) {
+		return nil
+	}
+	return original
+}
+
+// recordRelPath returns the relative path records of the file at path are
+// kept under: its renamed and normalized warehouse path
+func (p *Processor) recordRelPath(path string) (string, bool) {
+	rel, err := filepath.Rel(p.cfg.Path, path)
+	if err != nil {
+		return "", false
+	}
+	destRel, _, err := p.renamer.Apply(filepath.ToSlash(rel))
+	if err != nil {
+		return "", false
+	}
+	return fileops.NormalizeName(p.limits.form, destRel), true
+}
+
+// rejectInStorm ends the processing of a file of a resend storm rejected by
+// path and size as a duplicate of original
+func (p *Processor) rejectInStorm(path string, size int64, original *storage.File, started time.Time) {
+	slog.Debug("file of a resend storm already recorded, rejecting it without hashing",
+		"path", path,
+		"size", size,
+		"original", original.Path,
+		"original_destination", original.DestPath,
+	)
+	p.watcher.RemoveFromTracking(path)
+	p.storms.aggregate(sourceOf(p.cfg.Path, path), size, true, p.clock())
+	p.writeReceipt(path, Receipt{Status: status.Duplicate, SHA256: original.SHA256, Destination: original.DestPath})
+	p.recordSummarized(path, "", started)
+}
+
+// recordSummarized is record for a duplicate folded into a resend storm
+// summary, whose event is not written to the outbox
+func (p *Processor) recordSummarized(path, hash string, started time.Time) {
+	p.settle(path, status.Duplicate)
+	p.endAttempt(path, hash, status.Duplicate, nil)
+	e := p.fileEvent(path, hash, status.Duplicate, nil)
+	e.Summarized = true
+	if !started.IsZero() {
+		e.Duration = e.At.Sub(started)
+	}
+	p.events.publish(e)
+	p.observeStorm(path, status.Duplicate)
+}
+
+// withoutPaused leaves out the files of sources paused by a resend storm,
+// which stay tracked until their source is resumed
+func (p *Processor) withoutPaused(files []string) []string {
+	kept := files[:0]
+	held := 0
+	for _, path := range files {
+		if p.storms.paused(sourceOf(p.cfg.Path, path)) {
+			held++
+			continue
+		}
+		kept = append(kept, path)
+	}
+	if held > 0 {
+		slog.Debug("holding files of sources paused by a resend storm", "held", held)
+	}
+	return kept
+}
+
+// Storms returns the sources in a resend storm or paused by one, by name
+func (p *Processor) Storms() []StormStatus {
+	storms := p.storms.statuses(p.clock())
+	for i := range storms {
+		storms[i].Source = p.redactor.Text(storms[i].Source)
+	}
+	return storms
+}
+
+// ResumeSource resumes processing of a source paused by a resend storm,
+// named as in Storms, and reports whether it was paused. A source still
+// storming keeps rejecting the files already recorded.
+func (p *Processor) ResumeSource(source string) bool {
+	resumed, ok := p.storms.resume(func(s string) bool { return s == source || p.redactor.Text(s) == source })
+	if ok {
+		slog.Info("source paused by a resend storm resumed", "source", resumed)
+	}
+	return ok
+}
@@ -0,0 +1,194 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// useStorms enables resend storm detection: a source storms once half of at
+// least four files within ten minutes are duplicates
+func useStorms(env *testEnv) *testClock {
+	env.cfg.StormRatio = 0.5
+	env.cfg.StormWindow = 10 * time.Minute
+	env.cfg.StormMinFiles = 4
+	env.cfg.StormSummaryInterval = time.Minute
+	return useTestClock(env)
+}
+
+// startStorm ingests four files of source and resends them, which puts
+// source in a resend storm with the last resend
+func startStorm(t *testing.T, env *testEnv, source string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(env.inputDir, source), 0o755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	names := []string{"a.csv", "b.csv", "c.csv", "d.csv"}
+	for _, name := range names {
+		ingest(t, env, filepath.Join(source, name), "content of "+name)
+	}
+	for _, name := range names {
+		ingest(t, env, filepath.Join(source, name), "content of "+name)
+	}
+	if !env.processor.storms.storming(source) {
+		t.Fatalf("%s should be in a resend storm, storms = %+v", source, env.processor.Storms())
+	}
+}
+
+// summaryEntries returns the resend storm summaries of the manifests
+func summaryEntries(t *testing.T, env *testEnv) []manifest.Entry {
+	t.Helper()
+	var sums []manifest.Entry
+	for _, e := range readManifest(t, env.manifestsDir) {
+		if e.Status == status.DuplicateSummary {
+			sums = append(sums, e)
+		}
+	}
+	return sums
+}
+
+func TestStorm_SummarizesResendsAndIngestsNewFiles(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	clock := useStorms(env)
+	var events []Event
+	env.processor.events.subscribeDirect(func(e Event) {
+		if e.Kind == EventResendStorm || e.Kind == EventResendStormEnded {
+			events = append(events, e)
+		}
+	})
+
+	startStorm(t, env, "acme")
+	if len(events) != 1 || events[0].Kind != EventResendStorm || events[0].Source != "acme" {
+		t.Fatalf("storm events = %+v, want acme entering a storm", events)
+	}
+	// Duplicates before the storm are recorded one by one
+	if dups := duplicateEntries(t, env); len(dups) != 4 {
+		t.Fatalf("duplicate entries = %d, want the 4 resends before the storm", len(dups))
+	}
+
+	// Resends under a recorded path and size are rejected without hashing,
+	// resends under another name are hashed; both are summarized
+	ingest(t, env, "acme/a.csv", "content of a.csv")
+	ingest(t, env, "acme/b.csv", "content of b.csv")
+	ingest(t, env, "acme/renamed.csv", "content of c.csv")
+	// New content and other sources are ingested promptly
+	ingest(t, env, "acme/new.csv", "brand new")
+	if err := os.MkdirAll(filepath.Join(env.inputDir, "globex"), 0o755); err != nil {
+		t.Fatalf("failed to create source: %v", err)
+	}
+	ingest(t, env, "globex/x.csv", "globex content")
+	for _, name := range []string{"new.csv", "x.csv"} {
+		if e := entryOf(t, env, name); e.Status != status.Ingested {
+			t.Errorf("%s entry = %+v, want ingested during the storm", name, e)
+		}
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 4 {
+		t.Errorf("duplicate entries = %d, want the storm's duplicates summarized", len(dups))
+	}
+	if sums := summaryEntries(t, env); len(sums) != 0 {
+		t.Fatalf("summaries = %+v, want none before the interval", sums)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	env.processor.ProcessFiles()
+	sums := summaryEntries(t, env)
+	if len(sums) != 1 {
+		t.Fatalf("summaries = %+v, want one", sums)
+	}
+	if sum := sums[0]; sum.Members != 3 || sum.SourcePath != filepath.Join(env.inputDir, "acme") || sum.Size != sum.MembersSize {
+		t.Errorf("summary = %+v, want the 3 duplicates of acme", sum)
+	}
+	storms := env.processor.Storms()
+	if len(storms) != 1 || storms[0].Rejected != 2 || storms[0].Summarized != 3 {
+		t.Errorf("storms = %+v, want acme with 2 rejected of 3 summarized", storms)
+	}
+
+	// The digest counts every duplicate a summary stands for
+	stats, err := env.store.DuplicateStats(clock.now.Add(-time.Hour), clock.now.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("DuplicateStats() error = %v", err)
+	}
+	if stats.Duplicates != 7 {
+		t.Errorf("digest duplicates = %d, want 7", stats.Duplicates)
+	}
+
+	// The storm ends once its window holds no more duplicates
+	clock.now = clock.now.Add(11 * time.Minute)
+	env.processor.ProcessFiles()
+	if storms := env.processor.Storms(); len(storms) != 0 {
+		t.Errorf("storms = %+v, want none once the ratio normalized", storms)
+	}
+	if len(events) != 2 || events[1].Kind != EventResendStormEnded {
+		t.Errorf("storm events = %+v, want the storm ended", events)
+	}
+	ingest(t, env, "acme/d.csv", "content of d.csv")
+	if dups := duplicateEntries(t, env); len(dups) != 5 {
+		t.Errorf("duplicate entries = %d, want resends recorded one by one again", len(dups))
+	}
+}
+
+func TestStorm_PausesSourceUntilResumed(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	useStorms(env)
+	env.cfg.StormPause = true
+
+	startStorm(t, env, "acme")
+	storms := env.processor.Storms()
+	if len(storms) != 1 || !storms[0].Paused {
+		t.Fatalf("storms = %+v, want acme paused", storms)
+	}
+
+	files := []string{filepath.Join(env.inputDir, "acme", "e.csv"), filepath.Join(env.inputDir, "globex", "x.csv")}
+	if held := env.processor.withoutPaused(files); len(held) != 1 || held[0] != files[1] {
+		t.Errorf("withoutPaused() = %v, want only the globex file", held)
+	}
+
+	if env.processor.ResumeSource("globex") {
+		t.Error("ResumeSource(globex) = true, want false for a source not paused")
+	}
+	if !env.processor.ResumeSource("acme") {
+		t.Fatal("ResumeSource(acme) = false, want true")
+	}
+	files = []string{filepath.Join(env.inputDir, "acme", "e.csv")}
+	if kept := env.processor.withoutPaused(files); len(kept) != 1 {
+		t.Errorf("withoutPaused() = %v, want the resumed source processed", kept)
+	}
+}
+
+func TestStorm_CloseSummarizesPending(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	useStorms(env)
+
+	startStorm(t, env, "acme")
+	ingest(t, env, "acme/a.csv", "content of a.csv")
+	if err := env.processor.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if sums := summaryEntries(t, env); len(sums) != 1 || sums[0].Members != 1 {
+		t.Errorf("summaries = %+v, want the pending duplicate summarized on close", sums)
+	}
+}
+
+func TestStorm_Disabled(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	for range 2 {
+		for _, name := range []string{"a.csv", "b.csv", "c.csv", "d.csv"} {
+			ingest(t, env, name, "content of "+name)
+		}
+	}
+	if storms := env.processor.Storms(); len(storms) != 0 {
+		t.Errorf("storms = %+v, want none when disabled", storms)
+	}
+	if dups := duplicateEntries(t, env); len(dups) != 4 {
+		t.Errorf("duplicate entries = %d, want every duplicate recorded", len(dups))
+	}
+}
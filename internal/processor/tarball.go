@@ -0,0 +1,436 @@
+package processor
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// tarExt is appended to the directory name of a packaged batch
+const tarExt = ".tar"
+
+// tarEntry is one entry of a batch tar
+type tarEntry struct {
+	// name is the slash-separated path relative to the batch directory,
+	// ending in a slash for directories
+	name string
+	// path is the file archived, which differs from the entry's own path
+	// for followed symlinks
+	path string
+	// src is the entry's own path in the batch directory
+	src  string
+	info os.FileInfo
+}
+
+// tarball is a batch tar written to staging, or only hashed in dry run
+type tarball struct {
+	hash        string
+	size        int64
+	members     int
+	membersSize int64
+	// sources are the files archived, disposed of once the tar is committed
+	sources []string
+}
+
+// tarEntries lists the directories and files of a batch sorted by name,
+// leaving out the marker and files the watcher ignores. Symlinks are skipped
+// or, when following, replaced by the files they point to.
+func (p *Processor) tarEntries(b watcher.Batch) ([]tarEntry, error) {
+	var entries []tarEntry
+	err := filepath.WalkDir(b.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == b.Dir {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if d.Name() == config.TrashDirName || watcher.ShouldIgnoreFile(path) {
+				return fs.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entries = append(entries, tarEntry{name: name + "/", path: path, src: path, info: info})
+			return nil
+		}
+		if path == b.MarkerPath || watcher.ShouldIgnoreFile(path) {
+			return nil
+		}
+
+		target := path
+		if d.Type()&fs.ModeSymlink != 0 {
+			if p.cfg.BatchTarSymlinks != config.SymlinksFollow {
+				slog.Debug("skipping symlink in batch", "batch", b.Dir, "path", path)
+				return nil
+			}
+			if target, err = filepath.EvalSymlinks(path); err != nil {
+				slog.Warn("skipping broken symlink in batch", "batch", b.Dir, "path", path, "error", err)
+				return nil
+			}
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			slog.Warn("skipping non-regular file in batch", "batch", b.Dir, "path", path, "mode", info.Mode().String())
+			return nil
+		}
+		entries = append(entries, tarEntry{name: name, path: target, src: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list batch %s: %w", b.Dir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+// writeTar streams the tar of entries to w. Headers carry no owner and a
+// fixed modification time unless BatchTarMtimes is set, so the same
+// directory content always yields the same bytes.
+func (p *Processor) writeTar(w io.Writer, entries []tarEntry) (tarball, error) {
+	var t tarball
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		if err := p.ctx.Err(); err != nil {
+			return t, err
+		}
+		hdr := &tar.Header{
+			Name:    e.name,
+			Mode:    int64(e.info.Mode().Perm()),
+			ModTime: time.Unix(0, 0),
+		}
+		if p.cfg.BatchTarMtimes {
+			hdr.ModTime = e.info.ModTime()
+		}
+		if e.info.IsDir() {
+			hdr.Typeflag = tar.TypeDir
+			if err := tw.WriteHeader(hdr); err != nil {
+				return t, fmt.Errorf("archive %s: %w", e.src, err)
+			}
+			continue
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.info.Size()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return t, fmt.Errorf("archive %s: %w", e.src, err)
+		}
+		if err := copyMember(tw, e.path, hdr.Size); err != nil {
+			return t, fmt.Errorf("archive %s: %w", e.src, err)
+		}
+		t.members++
+		t.membersSize += hdr.Size
+		t.sources = append(t.sources, e.src)
+	}
+	if err := tw.Close(); err != nil {
+		return t, fmt.Errorf("finish tar: %w", err)
+	}
+	return t, nil
+}
+
+// copyMember copies the size bytes of path the header announced, failing if
+// the file shrank since it was listed
+func copyMember(w io.Writer, path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.CopyN(w, f, size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("file shrank below %d bytes while archiving", size)
+		}
+		return err
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+// buildTar writes the tar of entries to path, or only hashes it when path is
+// empty
+func (p *Processor) buildTar(path string, entries []tarEntry) (tarball, error) {
+	h := sha256.New()
+	var n countingWriter
+	w := io.MultiWriter(h, &n)
+
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return tarball{}, fmt.Errorf("create %s: %w", path, err)
+		}
+		defer func() { _ = f.Close() }()
+		w = io.MultiWriter(f, h, &n)
+	}
+
+	t, err := p.writeTar(w, entries)
+	if err != nil {
+		return t, err
+	}
+	if f != nil {
+		if p.copyOpts.Sync != fileops.SyncNever {
+			if err := f.Sync(); err != nil {
+				return t, fmt.Errorf("sync %s: %w", path, err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			return t, fmt.Errorf("close %s: %w", path, err)
+		}
+	}
+	t.hash = hex.EncodeToString(h.Sum(nil))
+	t.size = n.n
+	return t, nil
+}
+
+// processBatchTar ingests a directory batch as one tar named after the
+// directory. The tar is built in staging and hashed for dedup, claimed in
+// the database and only then renamed into place, after which the archived
+// sources are disposed of. A crash after the claim leaves a staged tar with
+// a record, which the staging recovery promotes.
+func (p *Processor) processBatchTar(b watcher.Batch) error {
+	started := time.Now()
+	entries, err := p.tarEntries(b)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(entries, func(e tarEntry) bool { return !e.info.IsDir() }) {
+		slog.Warn("batch marker without files, ignoring", "batch", b.Dir, "marker", b.MarkerPath)
+		p.watcher.RemoveBatch(b.Dir)
+		return nil
+	}
+
+	relDir, err := filepath.Rel(p.cfg.Path, b.Dir)
+	if err != nil {
+		return fmt.Errorf("calculate relative path for %s: %w", b.Dir, err)
+	}
+	relKey := filepath.ToSlash(relDir) + tarExt
+	dst, err := p.warehousePath(relDir + tarExt)
+	if err != nil {
+		return fmt.Errorf("resolve destination for %s: %w", b.Dir, err)
+	}
+
+	if p.cfg.DryRun {
+		t, err := p.buildTar("", entries)
+		if err != nil {
+			return err
+		}
+		if dst, err = p.shard(relKey, t.hash, dst); err != nil {
+			return fmt.Errorf("shard destination for %s: %w", b.Dir, err)
+		}
+		slog.Info("dry run: would ingest batch as tar",
+			"batch", b.Dir,
+			"sha256", t.hash,
+			"destination", dst.path,
+			"members", t.members,
+			"size", t.size,
+		)
+		p.dryRun.wouldIngest(t.size)
+		p.watcher.RemoveBatch(b.Dir)
+		return nil
+	}
+
+	id, err := destination.NewUploadID()
+	if err != nil {
+		return err
+	}
+	stagingDir := filepath.Join(p.cfg.Destination, filepath.FromSlash(destination.StagingPrefix), id)
+	key, err := filepath.Rel(p.cfg.Destination, dst.path)
+	if err != nil {
+		return fmt.Errorf("relative destination for %s: %w", b.Dir, err)
+	}
+	staged := filepath.Join(p.cfg.Destination, filepath.FromSlash(destination.StagingKey(id, filepath.ToSlash(key))))
+	rollback := func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			slog.Error("failed to remove batch staging directory", "batch", b.Dir, "staging", stagingDir, "error", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(staged), 0o755); err != nil {
+		return fmt.Errorf("create staging directory for %s: %w", b.Dir, err)
+	}
+	t, err := p.buildTar(staged, entries)
+	if err != nil {
+		rollback()
+		return err
+	}
+	// The tar is staged under its unsharded key: its hash picks the shard
+	if dst, err = p.shard(relKey, t.hash, dst); err != nil {
+		rollback()
+		return fmt.Errorf("shard destination for %s: %w", b.Dir, err)
+	}
+	p.failpoint(stageBatchStaged)
+
+	entry := manifest.Entry{
+		SHA256:       t.hash,
+		Name:         dst.name,
+		OriginalName: dst.originalName,
+		SourcePath:   b.Dir,
+		DestPath:     dst.path,
+		Size:         t.size,
+		Status:       status.Ingested,
+		Members:      t.members,
+		MembersSize:  t.membersSize,
+	}
+	if entry.Tags, err = p.rules.Evaluate(rules.File{RelPath: relKey, Size: t.size, Path: b.Dir}); err != nil {
+		rollback()
+		return fmt.Errorf("evaluate tagging rules for %s: %w", b.Dir, err)
+	}
+
+	entry.ProcessedAt, entry.Sequence = p.stamp()
+	defer p.order.release(entry.Sequence)
+	var (
+		created  bool
+		existing *storage.File
+	)
+	err = p.ingestTx(func(tx *storage.Storage) error {
+		var err error
+		created, existing, err = tx.CreateFileIfAbsent(storage.FileRecord{
+			SHA256:       t.hash,
+			Name:         dst.name,
+			OriginalName: dst.originalName,
+			Path:         b.Dir,
+			Size:         t.size,
+			Status:       status.Ingested,
+			DestPath:     dst.path,
+			ProcessedAt:  entry.ProcessedAt,
+			Tags:         entry.Tags,
+			RelPath:      fileops.NormalizeName(p.limits.form, relKey),
+			Sequence:     entry.Sequence,
+		})
+		if err != nil || !created {
+			return err
+		}
+		return p.commitEntry(tx, entry)
+	})
+	if err != nil {
+		rollback()
+		return fmt.Errorf("create database record for %s: %w", b.Dir, err)
+	}
+	if !created {
+		// The same directory content was ingested before
+		rollback()
+		entry.DestPath = existing.DestPath
+		entry.Status = status.Duplicate
+		entry.Dedup = manifest.DedupHash
+		p.finishBatchTar(b, t, entry, status.Duplicate, status.Duplicate, started)
+		slog.Info("batch already ingested, skipping",
+			"batch", b.Dir,
+			"sha256", t.hash,
+			"original", existing.Path,
+			"original_destination", existing.DestPath,
+		)
+		return nil
+	}
+	p.failpoint(stageBatchCommitted)
+
+	if err := os.MkdirAll(filepath.Dir(dst.path), 0o755); err != nil {
+		p.releaseBatchTar(b, t.hash, rollback)
+		return fmt.Errorf("create destination directory for %s: %w", b.Dir, err)
+	}
+	if err := os.Rename(staged, dst.path); err != nil {
+		p.releaseBatchTar(b, t.hash, rollback)
+		return fmt.Errorf("promote tar of %s: %w", b.Dir, err)
+	}
+	p.setXattrs(entry, storage.SourceOf(fileops.NormalizeName(p.limits.form, relKey)))
+	p.seal(dst.path)
+	_ = os.RemoveAll(stagingDir)
+
+	if err := p.appendEntry(entry, true); err != nil {
+		slog.Warn("failed to write manifest entry", "path", b.Dir, "error", err)
+	}
+	p.finishBatchTar(b, t, entry, status.Ingested, status.Ingested, started)
+	slog.Info("batch processed successfully",
+		"batch", b.Dir,
+		"sha256", t.hash,
+		"destination", dst.path,
+		"members", t.members,
+		"size", t.size,
+		"staging_id", id,
+	)
+	return nil
+}
+
+// releaseBatchTar deletes the record of a tar that could not be put in
+// place, so the batch is retried on the next tick
+func (p *Processor) releaseBatchTar(b watcher.Batch, hash string, rollback func()) {
+	rollback()
+	if err := p.storage.ReleaseFile(hash); err != nil {
+		slog.Error("failed to release database record", "batch", b.Dir, "sha256", hash, "error", err)
+	}
+}
+
+// finishBatchTar records the outcome of a packaged batch and disposes of
+// its sources: the archived files, the marker and the directories they
+// leave empty
+func (p *Processor) finishBatchTar(b watcher.Batch, t tarball, entry manifest.Entry, outcome, receipt status.Status, started time.Time) {
+	if outcome == status.Duplicate {
+		if err := p.appendEntry(entry, false); err != nil {
+			slog.Warn("failed to write manifest entry", "path", b.Dir, "error", err)
+		}
+	}
+	for _, src := range t.sources {
+		p.disposeSource(src)
+	}
+	p.disposeSource(b.MarkerPath)
+	if !p.keepsSource(b.Dir) {
+		removeEmptyDirs(b.Dir)
+	}
+	p.watcher.RemoveBatch(b.Dir)
+	p.recordEntry(entry, outcome, nil, started)
+	p.writeReceipt(b.Dir, Receipt{Status: receipt, SHA256: t.hash, Destination: entry.DestPath})
+}
+
+// removeEmptyDirs removes root and the directories below it that are
+// empty, deepest first. Directories still holding files are kept.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+	for _, dir := range dirs {
+		// Only succeeds once the directory is empty
+		_ = os.Remove(dir)
+	}
+}
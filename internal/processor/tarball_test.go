@@ -0,0 +1,227 @@
+package processor
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// readTar returns the entry names of a tar in order and the contents of
+// its files
+func readTar(t *testing.T, path string) ([]string, map[string]string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open tar: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var names []string
+	contents := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names, contents
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(time.Unix(0, 0)) || hdr.Uid != 0 || hdr.Uname != "" {
+			t.Errorf("entry %s has modtime %v, owner %d %q, want none", hdr.Name, hdr.ModTime, hdr.Uid, hdr.Uname)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", hdr.Name, err)
+			}
+			contents[hdr.Name] = string(data)
+		}
+	}
+}
+
+func TestBatchTar_RoundTrip(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.BatchTar = true
+
+	b := writeBatch(t, env)
+	if err := env.processor.processBatch(b); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+
+	if got := warehouseFiles(t, env); !reflect.DeepEqual(got, []string{"batch_1.tar"}) {
+		t.Fatalf("warehouse = %v, want only the batch tar", got)
+	}
+	names, contents := readTar(t, filepath.Join(env.warehouseDir, "batch_1.tar"))
+	if want := []string{"a.csv", "b.csv", "nested/", "nested/c.csv"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("tar entries = %v, want %v", names, want)
+	}
+	if !reflect.DeepEqual(contents, batchFiles) {
+		t.Errorf("tar contents = %v, want %v", contents, batchFiles)
+	}
+	if _, err := os.Stat(b.Dir); !os.IsNotExist(err) {
+		t.Errorf("batch directory should be removed after commit: %v", err)
+	}
+
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 {
+		t.Fatalf("manifest = %+v, want one entry", entries)
+	}
+	e := entries[0]
+	if e.Members != 3 || e.MembersSize != int64(len("alpha")+len("bravo")+len("charlie")) || e.SourcePath != b.Dir {
+		t.Errorf("manifest entry = %+v", e)
+	}
+	record, err := env.store.FindBySHA256(e.SHA256)
+	if err != nil || record == nil || record.DestPath != e.DestPath || record.Size != e.Size {
+		t.Errorf("database record = %+v, %v, want the tar", record, err)
+	}
+}
+
+func TestBatchTar_ResendDeduplicates(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.BatchTar = true
+
+	first := writeBatch(t, env)
+	entries, err := env.processor.tarEntries(first)
+	if err != nil {
+		t.Fatalf("tarEntries() error = %v", err)
+	}
+	want, err := env.processor.buildTar("", entries)
+	if err != nil {
+		t.Fatalf("buildTar() error = %v", err)
+	}
+	if err := env.processor.processBatch(first); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+
+	// The same content sent again, written at another time
+	resent := writeBatch(t, env)
+	later := time.Now().Add(time.Hour)
+	for name := range batchFiles {
+		if err := os.Chtimes(filepath.Join(resent.Dir, filepath.FromSlash(name)), later, later); err != nil {
+			t.Fatalf("failed to change modification time: %v", err)
+		}
+	}
+	if err := env.processor.processBatch(resent); err != nil {
+		t.Fatalf("processBatch() of the re-send error = %v", err)
+	}
+
+	got := readManifest(t, env.manifestsDir)
+	if len(got) != 2 || got[0].SHA256 != want.hash || got[1].SHA256 != want.hash {
+		t.Fatalf("manifest = %+v, want two entries with hash %s", got, want.hash)
+	}
+	if got[1].Status != status.Duplicate || got[1].DestPath != got[0].DestPath {
+		t.Errorf("re-send entry = %+v, want a duplicate of the first tar", got[1])
+	}
+	if files := warehouseFiles(t, env); len(files) != 1 {
+		t.Errorf("warehouse = %v, want a single tar", files)
+	}
+	if left := stagingEntries(t, env); len(left) != 0 {
+		t.Errorf("staging area not cleaned up: %v", left)
+	}
+	if _, err := os.Stat(resent.Dir); !os.IsNotExist(err) {
+		t.Errorf("re-sent directory should be removed: %v", err)
+	}
+}
+
+func TestBatchTar_Mtimes(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.BatchTarMtimes = true
+
+	b := writeBatch(t, env)
+	hash := func() string {
+		t.Helper()
+		entries, err := env.processor.tarEntries(b)
+		if err != nil {
+			t.Fatalf("tarEntries() error = %v", err)
+		}
+		tb, err := env.processor.buildTar("", entries)
+		if err != nil {
+			t.Fatalf("buildTar() error = %v", err)
+		}
+		return tb.hash
+	}
+	before := hash()
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(b.Dir, "a.csv"), later, later); err != nil {
+		t.Fatalf("failed to change modification time: %v", err)
+	}
+	if hash() == before {
+		t.Error("kept modification times should change the hash")
+	}
+}
+
+func TestBatchTar_Symlinks(t *testing.T) {
+	for _, mode := range []string{config.SymlinksSkip, config.SymlinksFollow} {
+		t.Run(mode, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.BatchTar = true
+			env.cfg.BatchTarSymlinks = mode
+
+			b := writeBatch(t, env)
+			outside := filepath.Join(t.TempDir(), "outside.csv")
+			if err := os.WriteFile(outside, []byte("delta"), 0o644); err != nil {
+				t.Fatalf("failed to create link target: %v", err)
+			}
+			if err := os.Symlink(outside, filepath.Join(b.Dir, "link.csv")); err != nil {
+				t.Skipf("symlinks not supported: %v", err)
+			}
+			if err := os.Symlink(filepath.Dir(outside), filepath.Join(b.Dir, "linkdir")); err != nil {
+				t.Fatalf("failed to create directory symlink: %v", err)
+			}
+
+			if err := env.processor.processBatch(b); err != nil {
+				t.Fatalf("processBatch() error = %v", err)
+			}
+			_, contents := readTar(t, filepath.Join(env.warehouseDir, "batch_1.tar"))
+			got, linked := contents["link.csv"]
+			if linked != (mode == config.SymlinksFollow) || (linked && got != "delta") {
+				t.Errorf("link.csv in tar = %q, %v", got, linked)
+			}
+			want := len(batchFiles)
+			if mode == config.SymlinksFollow {
+				want++
+			}
+			if len(contents) != want {
+				t.Errorf("tar files = %v, directory symlinks are never archived", contents)
+			}
+			if _, err := os.Stat(outside); err != nil {
+				t.Errorf("link target must survive the batch: %v", err)
+			}
+		})
+	}
+}
+
+func TestBatchTar_DryRun(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.BatchTar = true
+	env.cfg.DryRun = true
+
+	b := writeBatch(t, env)
+	if err := env.processor.processBatch(b); err != nil {
+		t.Fatalf("processBatch() error = %v", err)
+	}
+	if files := warehouseFiles(t, env); len(files) != 0 {
+		t.Errorf("dry run wrote %v", files)
+	}
+	if n := countRecords(t, env.store); n != 0 {
+		t.Errorf("dry run created %d records", n)
+	}
+	if _, err := os.Stat(b.MarkerPath); err != nil {
+		t.Errorf("dry run must leave the batch: %v", err)
+	}
+}
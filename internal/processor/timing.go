@@ -0,0 +1,115 @@
+package processor
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Stages of the timing breakdown of a sampled file
+const (
+	timeQueue = iota
+	timeHash
+	timeDedup
+	timeTransform
+	timeCommit
+	timeCopy
+	timeFsync
+	timeManifest
+	timeNotify
+	timeStages
+)
+
+// timing accumulates the breakdown of a sampled file. Each lap charges the
+// time since the previous one to a stage, so the stages add up to the time
+// since start. A nil timing, that of every file not sampled, records
+// nothing, which keeps the unsampled path free of allocations.
+type timing struct {
+	start  time.Time
+	mark   time.Time
+	stages [timeStages]time.Duration
+}
+
+// newTiming returns the timing of a file queued at queued when the file is
+// drawn into the sample, and nil otherwise
+func (p *Processor) newTiming(queued time.Time) *timing {
+	rate := p.cfg.TimingSampleRate
+	if rate <= 0 || p.attempts == nil || p.cfg.DryRun {
+		return nil
+	}
+	if rate < 1 && rand.Float64() >= rate {
+		return nil
+	}
+	return &timing{start: queued, mark: queued}
+}
+
+// lap charges the time since the last lap to stage
+func (t *timing) lap(stage int) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.stages[stage] += now.Sub(t.mark)
+	t.mark = now
+}
+
+// onSync moves an fsync within the copy from the copy stage to fsync
+func (t *timing) onSync(d time.Duration) {
+	t.stages[timeCopy] -= d
+	t.stages[timeFsync] += d
+}
+
+// syncHook returns the fsync callback of the copy options of the file, nil
+// when it is not sampled
+func (t *timing) syncHook() func(time.Duration) {
+	if t == nil {
+		return nil
+	}
+	return t.onSync
+}
+
+// stepStage returns the stage the time of a pipeline step is charged to
+func stepStage(step string) int {
+	switch step {
+	case StepDedup:
+		return timeDedup
+	case StepClaim:
+		return timeCommit
+	case StepMove:
+		return timeCopy
+	case StepManifest:
+		return timeManifest
+	case StepReceipt:
+		return timeNotify
+	default:
+		return timeTransform
+	}
+}
+
+// endTiming queues the breakdown of a sampled file that reached outcome.
+// Self-test probes are not recorded.
+func (p *Processor) endTiming(fc *FileContext, outcome status.Status) {
+	t := fc.timing
+	if t == nil || fc.SelfTest {
+		return
+	}
+	p.attempts.timing(storage.Timing{
+		Path:      fc.SourcePath,
+		SHA256:    fc.SHA256,
+		Size:      fc.Size(),
+		Outcome:   outcome,
+		StartedAt: t.start,
+		Total:     t.mark.Sub(t.start),
+		Queue:     t.stages[timeQueue],
+		Hash:      t.stages[timeHash],
+		Dedup:     t.stages[timeDedup],
+		Transform: t.stages[timeTransform],
+		Commit:    t.stages[timeCommit],
+		Copy:      t.stages[timeCopy],
+		Fsync:     t.stages[timeFsync],
+		Manifest:  t.stages[timeManifest],
+		Notify:    t.stages[timeNotify],
+	})
+}
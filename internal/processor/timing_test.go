@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestTiming_BreakdownSumsToTotal(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.TimingSampleRate = 1
+	// Copied rather than renamed, so the copy is fsynced
+	env.cfg.KeepSource = true
+
+	const slow = 20 * time.Millisecond
+	steps := builtins(env.processor, StepDedup, StepResolve)
+	steps = append(steps, funcStep{name: "upload", fn: func(*FileContext) error {
+		time.Sleep(slow)
+		return nil
+	}})
+	env.processor.defaultSteps = append(steps, builtins(env.processor, StepClaim, StepMove, StepManifest, StepReceipt)...)
+
+	files := writeSourceFiles(t, env, "a.csv", "b.csv", "c.csv")
+	before := time.Now()
+	env.processor.runPipeline(files)
+	elapsed := time.Since(before)
+	env.processor.attempts.close()
+
+	timings, err := env.store.SlowFiles(before.Add(-time.Second), time.Now().Add(time.Second), 0)
+	if err != nil {
+		t.Fatalf("SlowFiles() error = %v", err)
+	}
+	if len(timings) != len(files) {
+		t.Fatalf("got %d timings, want one per file: %+v", len(timings), timings)
+	}
+	for _, tm := range timings {
+		sum := tm.Queue + tm.Hash + tm.Dedup + tm.Transform + tm.Commit + tm.Copy + tm.Fsync + tm.Manifest + tm.Notify
+		if diff := (sum - tm.Total).Abs(); diff > tm.Total/100 {
+			t.Errorf("%s: stages sum to %v, total %v", tm.Path, sum, tm.Total)
+		}
+		if tm.Total > elapsed || tm.Transform < slow {
+			t.Errorf("%s: total %v, transform %v, want the pass of %v and the slow step", tm.Path, tm.Total, tm.Transform, elapsed)
+		}
+		if tm.Outcome != status.Ingested || tm.SHA256 == "" || tm.Hash <= 0 || tm.Commit <= 0 || tm.Copy <= 0 || tm.Fsync <= 0 || tm.Manifest <= 0 {
+			t.Errorf("timing = %+v, want every stage of an ingested file measured", tm)
+		}
+	}
+	if timings[0].Total < timings[len(timings)-1].Total {
+		t.Errorf("timings not slowest first: %v before %v", timings[0].Total, timings[len(timings)-1].Total)
+	}
+}
+
+func TestTiming_SampleRate(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+
+	const files = 5000
+	for _, rate := range []float64{0, 0.01, 0.1, 0.5, 1} {
+		t.Run(fmt.Sprint(rate), func(t *testing.T) {
+			env.cfg.TimingSampleRate = rate
+			sampled := 0
+			for range files {
+				if env.processor.newTiming(time.Now()) != nil {
+					sampled++
+				}
+			}
+			// Within five standard deviations of the binomial draw
+			want := rate * files
+			tolerance := 5 * math.Sqrt(files*rate*(1-rate))
+			if got := float64(sampled); got < want-tolerance || got > want+tolerance {
+				t.Errorf("sampled %d of %d files at rate %v, want about %.0f", sampled, files, rate, want)
+			}
+		})
+	}
+}
+
+func TestTiming_NotSampledInDryRun(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.TimingSampleRate = 1
+	env.cfg.DryRun = true
+
+	if tm := env.processor.newTiming(time.Now()); tm != nil {
+		t.Errorf("newTiming() = %+v in dry run, want nil", tm)
+	}
+}
@@ -0,0 +1,253 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// ReasonInvalidURLList is the quarantine reason of a URL list that cannot be
+// parsed
+const ReasonInvalidURLList = "invalid_url_list"
+
+// ReasonChecksumMismatch is the quarantine reason of a file downloaded from a
+// URL list whose hash differs from the one the list declares
+const ReasonChecksumMismatch = "checksum_mismatch"
+
+// StepFetch names the download of a file listed in a URL list in quarantine
+// records
+const StepFetch = "fetch"
+
+// URLList is a file listing files to download and ingest in its place
+type URLList struct {
+	Files []URLFile `json:"files"`
+}
+
+// URLFile is one file of a URL list. Name is what the file is ingested as,
+// next to the list, and defaults to the last element of the URL path.
+type URLFile struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Name   string `json:"name,omitempty"`
+}
+
+// ParseURLList parses and validates a URL list: every file needs an http or
+// https URL, the SHA256 of its content in hex and a name that is a plain
+// file name, unique within the list. Whether plain http is downloaded is up
+// to the fetcher.
+func ParseURLList(data []byte) (URLList, error) {
+	var list URLList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return URLList{}, fmt.Errorf("decode URL list: %w", err)
+	}
+	if len(list.Files) == 0 {
+		return URLList{}, errors.New("URL list has no files")
+	}
+	seen := make(map[string]bool, len(list.Files))
+	for i := range list.Files {
+		f := &list.Files[i]
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return URLList{}, fmt.Errorf("file %d: invalid url %q: %w", i, f.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return URLList{}, fmt.Errorf("file %d: url %q is not http or https", i, f.URL)
+		}
+		f.SHA256 = strings.ToLower(f.SHA256)
+		if b, err := hex.DecodeString(f.SHA256); err != nil || len(b) != 32 {
+			return URLList{}, fmt.Errorf("file %d: invalid sha256 %q", i, f.SHA256)
+		}
+		if f.Name == "" {
+			f.Name = path.Base(u.Path)
+		}
+		if !validURLFileName(f.Name) {
+			return URLList{}, fmt.Errorf("file %d: invalid name %q", i, f.Name)
+		}
+		if seen[f.Name] {
+			return URLList{}, fmt.Errorf("file %d: name %q used twice", i, f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return list, nil
+}
+
+// validURLFileName reports whether name can be the name of a downloaded
+// file: a plain visible file name that is no URL list, receipt or sidecar
+// itself
+func validURLFileName(name string) bool {
+	switch {
+	case name == "", name == ".", name == "..", name == "/":
+		return false
+	case strings.ContainsAny(name, `/\`), strings.HasPrefix(name, "."):
+		return false
+	case strings.HasSuffix(name, config.URLListSuffix), strings.HasSuffix(name, config.ReceiptSuffix),
+		strings.HasSuffix(name, config.SidecarSuffix):
+		return false
+	}
+	return true
+}
+
+// isURLList reports whether the file at path is a URL list to download
+func (p *Processor) isURLList(path string) bool {
+	return p.fetcher != nil && strings.HasSuffix(path, config.URLListSuffix)
+}
+
+// splitURLLists separates the URL lists from the other files
+func (p *Processor) splitURLLists(files []string) (lists, rest []string) {
+	for _, path := range files {
+		if p.isURLList(path) {
+			lists = append(lists, path)
+		} else {
+			rest = append(rest, path)
+		}
+	}
+	return lists, rest
+}
+
+// processURLList downloads the files of a URL list, at most URLConcurrency
+// at a time, and ingests each under its name next to the list. A file whose
+// hash differs from the declared one is quarantined. The list is consumed
+// once every file is ingested or quarantined; when a download or an ingest
+// fails, it stays in place and is retried once it changes or after a
+// restart, skipping the files ingested from it meanwhile.
+func (p *Processor) processURLList(listPath string) error {
+	data, err := os.ReadFile(listPath)
+	if isVanished(listPath, err) {
+		return p.handleVanished(listPath, "", "", stageStat, err)
+	}
+	if err != nil {
+		return fmt.Errorf("read URL list %s: %w", listPath, err)
+	}
+	list, err := ParseURLList(data)
+	if err != nil {
+		sum := sha256.Sum256(data)
+		return p.quarantine(listPath, hex.EncodeToString(sum[:]), int64(len(data)), ReasonInvalidURLList, StepFetch, err)
+	}
+
+	dir := filepath.Dir(listPath)
+	if p.cfg.DryRun {
+		for _, f := range list.Files {
+			slog.Info("dry run: would download file", "list", listPath, "url", f.URL, "path", filepath.Join(dir, f.Name))
+		}
+		p.watcher.RemoveFromTracking(listPath)
+		return nil
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+	slots := make(chan struct{}, max(p.cfg.URLConcurrency, 1))
+	for _, f := range list.Files {
+		filePath := filepath.Join(dir, f.Name)
+		if p.ingestedFromList(filePath, f.SHA256) {
+			slog.Debug("file of URL list ingested by an earlier attempt, skipping it", "list", listPath, "url", f.URL, "path", filePath)
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := guard(func() error { return p.ingestURL(filePath, f) }); err != nil {
+				p.record(filePath, "", status.Failed, err)
+				if !p.logPanic(filePath, err, "url", f.URL) {
+					slog.Error("failed to ingest file of URL list", "list", listPath, "url", f.URL, "path", filePath, "error", err)
+				}
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		// Retried once the list changes or after a restart
+		p.watcher.RemoveFromTracking(listPath)
+		return fmt.Errorf("URL list %s: %d of %d files failed", listPath, failed, len(list.Files))
+	}
+	p.disposeSource(listPath)
+	p.watcher.RemoveFromTracking(listPath)
+	p.settle(listPath, status.Ingested)
+	p.endAttempt(listPath, "", status.Ingested, nil)
+	slog.Info("URL list processed successfully", "list", listPath, "files", len(list.Files))
+	return nil
+}
+
+// ingestedFromList reports whether the content hash, declared for the file
+// at filePath, was ingested from there already
+func (p *Processor) ingestedFromList(filePath, hash string) bool {
+	file, err := p.storage.FindBySHA256(hash)
+	if err != nil {
+		slog.Warn("failed to look up file by sha256, downloading it", "path", filePath, "error", err)
+		return false
+	}
+	return file != nil && file.Path == filePath
+}
+
+// ingestURL downloads f into the warehouse scratch area and runs the
+// pipeline on it as the file at filePath
+func (p *Processor) ingestURL(filePath string, f URLFile) error {
+	started := time.Now()
+	p.beginAttempt(filePath, started)
+
+	id, err := destination.NewUploadID()
+	if err != nil {
+		return err
+	}
+	staged := stagedCopy{dir: p.scratchDir(id)}
+	staged.path = filepath.Join(staged.dir, f.Name)
+	if err := os.MkdirAll(staged.dir, 0o755); err != nil {
+		return fmt.Errorf("create scratch directory for %s: %w", f.URL, err)
+	}
+	ingesting := false
+	defer func() {
+		if !ingesting {
+			_ = os.RemoveAll(staged.dir)
+		}
+	}()
+
+	res, err := p.fetcher.Download(p.ctx, f.URL, staged.path)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", f.URL, err)
+	}
+	if res.SHA256 != f.SHA256 {
+		o := origin{path: filePath, content: staged.path, url: f.URL}
+		cause := fmt.Errorf("downloaded sha256 %s, list declares %s", res.SHA256, f.SHA256)
+		return p.quarantineOrigin(o, res.SHA256, res.Size, ReasonChecksumMismatch, StepFetch, cause)
+	}
+	info, err := os.Stat(staged.path)
+	if err != nil {
+		return fmt.Errorf("stat download of %s: %w", f.URL, err)
+	}
+	slog.Debug("file of URL list downloaded", "url", f.URL, "path", filePath, "size", res.Size, "duration", time.Since(started))
+
+	// The pipeline removes the scratch directory once it ends
+	ingesting = true
+	return p.ingestHashed(hashedFile{
+		path:      filePath,
+		info:      info,
+		allocated: fileops.Allocated(info),
+		hash:      res.SHA256,
+		started:   started,
+		staged:    staged,
+		url:       f.URL,
+	})
+}
@@ -0,0 +1,235 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fetch"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// enableURLLists turns URL lists on for env with fast retries
+func enableURLLists(env *testEnv) {
+	env.cfg.URLLists = true
+	env.cfg.URLConcurrency = 2
+	env.processor.fetcher = fetch.New(fetch.Options{Retries: 2, Backoff: time.Millisecond, AllowHTTP: true})
+}
+
+// writeURLList writes list as name under the input directory of env
+func writeURLList(t *testing.T, env *testEnv, name string, list URLList) string {
+	t.Helper()
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatalf("failed to encode URL list: %v", err)
+	}
+	path := filepath.Join(env.inputDir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write URL list: %v", err)
+	}
+	return path
+}
+
+func TestProcessURLList_IngestsDownloads(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	enableURLLists(env)
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	// flaky.csv fails twice before it is served
+	var flaky atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky.csv":
+			if flaky.Add(1) <= 2 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("flaky,content\n"))
+		case "/data/report.csv":
+			_, _ = w.Write([]byte("report,content\n"))
+		case "/tampered.csv":
+			_, _ = w.Write([]byte("not what was declared\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	listPath := writeURLList(t, env, "batch"+config.URLListSuffix, URLList{Files: []URLFile{
+		{URL: srv.URL + "/flaky.csv", SHA256: sha256Hex("flaky,content\n")},
+		{URL: srv.URL + "/data/report.csv", SHA256: strings.ToUpper(sha256Hex("report,content\n")), Name: "renamed.csv"},
+		{URL: srv.URL + "/tampered.csv", SHA256: sha256Hex("declared\n")},
+	}})
+
+	if err := env.processor.processURLList(listPath); err != nil {
+		t.Fatalf("processURLList() error = %v", err)
+	}
+	if _, err := os.Stat(listPath); !os.IsNotExist(err) {
+		t.Errorf("URL list still in the input directory: %v", err)
+	}
+	if got := flaky.Load(); got != 3 {
+		t.Errorf("flaky URL requested %d times, want 3", got)
+	}
+
+	byStatus := make(map[status.Status][]manifest.Entry)
+	for _, e := range readManifest(t, env.manifestsDir) {
+		byStatus[e.Status] = append(byStatus[e.Status], e)
+	}
+	ingested := make(map[string]manifest.Entry)
+	for _, e := range byStatus[status.Ingested] {
+		ingested[e.SourceURL] = e
+	}
+	for url, want := range map[string]string{
+		srv.URL + "/flaky.csv":       filepath.Join(env.inputDir, "flaky.csv"),
+		srv.URL + "/data/report.csv": filepath.Join(env.inputDir, "renamed.csv"),
+	} {
+		e, ok := ingested[url]
+		if !ok {
+			t.Errorf("no ingested entry with source_url %s", url)
+			continue
+		}
+		if e.SourcePath != want {
+			t.Errorf("entry of %s has source_path %s, want %s", url, e.SourcePath, want)
+		}
+		if _, err := os.Stat(e.DestPath); err != nil {
+			t.Errorf("download of %s not in the warehouse: %v", url, err)
+		}
+	}
+
+	quarantined := byStatus[status.Quarantined]
+	if len(quarantined) != 1 || quarantined[0].SourceURL != srv.URL+"/tampered.csv" || quarantined[0].Reason != ReasonChecksumMismatch {
+		t.Fatalf("quarantined entries = %+v, want the tampered download", quarantined)
+	}
+	data, err := os.ReadFile(quarantined[0].DestPath + ".reason.json")
+	if err != nil {
+		t.Fatalf("failed to read quarantine record: %v", err)
+	}
+	var record quarantineRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode quarantine record: %v", err)
+	}
+	if record.SourceURL != srv.URL+"/tampered.csv" || record.Step != StepFetch {
+		t.Errorf("quarantine record = %+v, want the URL and step %s", record, StepFetch)
+	}
+
+	staging, _ := filepath.Glob(filepath.Join(env.warehouseDir, "_staging", "*"))
+	if len(staging) != 0 {
+		t.Errorf("staging directories left behind: %v", staging)
+	}
+}
+
+func TestProcessURLList_KeepsListUntilEveryURLSucceeds(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	enableURLLists(env)
+
+	var down atomic.Bool
+	down.Store(true)
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/late.csv" && down.Load() {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		served.Add(1)
+		_, _ = w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	listPath := writeURLList(t, env, "pending"+config.URLListSuffix, URLList{Files: []URLFile{
+		{URL: srv.URL + "/early.csv", SHA256: sha256Hex("content of /early.csv")},
+		{URL: srv.URL + "/late.csv", SHA256: sha256Hex("content of /late.csv")},
+	}})
+
+	if err := env.processor.processURLList(listPath); err == nil {
+		t.Fatal("processURLList() succeeded with a URL failing")
+	}
+	if _, err := os.Stat(listPath); err != nil {
+		t.Fatalf("URL list consumed with a URL failing: %v", err)
+	}
+
+	down.Store(false)
+	if err := env.processor.processURLList(listPath); err != nil {
+		t.Fatalf("processURLList() error on retry = %v", err)
+	}
+	if _, err := os.Stat(listPath); !os.IsNotExist(err) {
+		t.Errorf("URL list still in the input directory: %v", err)
+	}
+	if got := served.Load(); got != 2 {
+		t.Errorf("served %d downloads, want each file once", got)
+	}
+	if counts := countStatuses(t, env); counts[status.Ingested] != 2 || len(counts) != 1 {
+		t.Errorf("manifest statuses = %v, want 2 ingested", counts)
+	}
+}
+
+func TestProcessURLList_QuarantinesInvalidList(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	enableURLLists(env)
+	env.cfg.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+
+	listPath := filepath.Join(env.inputDir, "broken"+config.URLListSuffix)
+	if err := os.WriteFile(listPath, []byte(`{"files": [{"url": "ftp://host/a", "sha256": "00"}]}`), 0o644); err != nil {
+		t.Fatalf("failed to write URL list: %v", err)
+	}
+	if err := env.processor.processURLList(listPath); err != nil {
+		t.Fatalf("processURLList() error = %v", err)
+	}
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 1 || entries[0].Reason != ReasonInvalidURLList {
+		t.Fatalf("manifest entries = %+v, want the list quarantined as %s", entries, ReasonInvalidURLList)
+	}
+}
+
+func TestParseURLList(t *testing.T) {
+	hash := sha256Hex("x")
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", `{"files": [{"url": "https://host/a/b.csv", "sha256": "` + hash + `"}]}`, ""},
+		{"no files", `{"files": []}`, "no files"},
+		{"not json", `files`, "decode"},
+		{"scheme", `{"files": [{"url": "file:///etc/passwd", "sha256": "` + hash + `"}]}`, "not http"},
+		{"short hash", `{"files": [{"url": "https://host/a", "sha256": "abc"}]}`, "invalid sha256"},
+		{"no name", `{"files": [{"url": "https://host/", "sha256": "` + hash + `"}]}`, "invalid name"},
+		{"nested name", `{"files": [{"url": "https://host/a", "sha256": "` + hash + `", "name": "../a"}]}`, "invalid name"},
+		{"list name", `{"files": [{"url": "https://host/a", "sha256": "` + hash + `", "name": "b` + config.URLListSuffix + `"}]}`, "invalid name"},
+		{"name twice", `{"files": [{"url": "https://host/a", "sha256": "` + hash + `"}, {"url": "https://other/a", "sha256": "` + hash + `"}]}`, "used twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := ParseURLList([]byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseURLList() error = %v", err)
+				}
+				if list.Files[0].Name != "b.csv" {
+					t.Errorf("name = %q, want it taken from the URL", list.Files[0].Name)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseURLList() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// ReasonSourceVanished is recorded when a source disappears while it is
+// being processed
+const ReasonSourceVanished = "source_vanished"
+
+// ErrSourceVanished is returned by processFile when the source was removed
+// after it was picked up. The outcome is fully rolled back and recorded, so
+// callers log it at warn level rather than as a failure.
+var ErrSourceVanished = errors.New(ReasonSourceVanished)
+
+// Processing stages at which a vanished source is detected
+const (
+	stageStat  = "stat"
+	stageHash  = "hash"
+	stageClaim = "claim"
+	stageMove  = "move"
+)
+
+// isVanished reports whether err was caused by filePath no longer existing.
+// The source is checked directly so a missing destination directory is not
+// mistaken for a vanished source.
+func isVanished(filePath string, err error) bool {
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	_, serr := os.Lstat(filePath)
+	return errors.Is(serr, fs.ErrNotExist)
+}
+
+// handleVanished rolls back whatever processFile had done for a source that
+// disappeared: it releases the claimed hash, removes any partial destination,
+// stops tracking the file and records a vanished manifest entry. hash and
+// dstPath are empty when the source vanished before those stages. A dry run
+// only stops tracking the file.
+func (p *Processor) handleVanished(filePath, hash, dstPath, stage string, cause error) error {
+	if p.cfg.DryRun {
+		p.watcher.RemoveFromTracking(filePath)
+		slog.Info("dry run: source vanished during processing", "path", filePath, "stage", stage, "error", cause)
+		return fmt.Errorf("%w at %s: %s", ErrSourceVanished, stage, filePath)
+	}
+	if hash != "" && (stage == stageClaim || stage == stageMove) {
+		if err := p.storage.ReleaseFile(hash); err != nil {
+			slog.Error("failed to release database record", "path", filePath, "sha256", hash, "error", err)
+		}
+	}
+	if dstPath != "" && stage == stageMove {
+		_ = os.Remove(dstPath + ".tmp")
+	}
+
+	p.watcher.RemoveFromTracking(filePath)
+	processedAt, seq := p.stamp()
+	defer p.order.release(seq)
+	entry := manifest.Entry{
+		SHA256:      hash,
+		Name:        filepath.Base(filePath),
+		SourcePath:  filePath,
+		ProcessedAt: processedAt,
+		Status:      status.Vanished,
+		Reason:      ReasonSourceVanished,
+		SelfTest:    p.isProbe(filePath),
+		Sequence:    seq,
+	}
+	p.recordEntry(entry, status.Vanished, cause, time.Time{})
+	if err := p.appendEntry(entry, false); err != nil {
+		slog.Warn("failed to write manifest entry", "path", filePath, "error", err)
+	}
+
+	slog.Warn("source vanished during processing",
+		"path", filePath,
+		"stage", stage,
+		"sha256", hash,
+		"error", cause,
+	)
+	return fmt.Errorf("%w at %s: %s", ErrSourceVanished, stage, filePath)
+}
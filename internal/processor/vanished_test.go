@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestProcessFile_SourceVanished(t *testing.T) {
+	// The source is deleted right after each stage; the next stage touching
+	// it must notice and roll everything back
+	tests := []struct {
+		name      string
+		failpoint string
+	}{
+		{"before stat", ""},
+		{"after stat", stageStat},
+		{"after hash", stageHash},
+		{"after claim", stageClaim},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+
+			content := []byte("vanishing content")
+			src := filepath.Join(env.inputDir, "data.csv")
+			if err := os.WriteFile(src, content, 0o644); err != nil {
+				t.Fatalf("failed to create test file: %v", err)
+			}
+
+			remove := func() {
+				if err := os.Remove(src); err != nil {
+					t.Fatalf("failed to remove source: %v", err)
+				}
+			}
+			if tt.failpoint == "" {
+				remove()
+			} else {
+				env.processor.failpoints = map[string]func(){tt.failpoint: remove}
+			}
+
+			err := env.processor.processFile(src)
+			if !errors.Is(err, ErrSourceVanished) {
+				t.Fatalf("processFile error = %v, want %v", err, ErrSourceVanished)
+			}
+
+			sum := sha256.Sum256(content)
+			hash := hex.EncodeToString(sum[:])
+			exists, err := env.store.FileExistsSince(hash, time.Time{})
+			if err != nil {
+				t.Fatalf("FileExistsSince failed: %v", err)
+			}
+			if exists {
+				t.Error("database record should be rolled back")
+			}
+
+			err = filepath.Walk(env.warehouseDir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					t.Errorf("unexpected warehouse file %s", path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatalf("failed to walk warehouse: %v", err)
+			}
+
+			entries := readManifest(t, env.manifestsDir)
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 manifest entry, got %d", len(entries))
+			}
+			if entries[0].Status != status.Vanished || entries[0].Reason != ReasonSourceVanished {
+				t.Errorf("manifest entry = %s/%s, want %s/%s",
+					entries[0].Status, entries[0].Reason, status.Vanished, ReasonSourceVanished)
+			}
+			if entries[0].SourcePath != src {
+				t.Errorf("SourcePath = %q, want %q", entries[0].SourcePath, src)
+			}
+		})
+	}
+}
+
+func TestProcessFile_SourceVanishedInDryRun(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+
+	src := filepath.Join(env.inputDir, "data.csv")
+	if err := os.WriteFile(src, []byte("vanishing content"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	env.processor.failpoints = map[string]func(){stageStat: func() { _ = os.Remove(src) }}
+
+	if err := env.processor.processFile(src); !errors.Is(err, ErrSourceVanished) {
+		t.Fatalf("processFile error = %v, want %v", err, ErrSourceVanished)
+	}
+	if entries := readManifest(t, env.manifestsDir); len(entries) != 0 {
+		t.Errorf("dry run wrote manifest entries: %+v", entries)
+	}
+}
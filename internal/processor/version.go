@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// versionTimeLayout formats ingest times in version names
+const versionTimeLayout = "2006-01-02T15:04:05Z"
+
+// errClaimLost rolls back a version reservation whose content turned out to
+// be claimed already
+var errClaimLost = errors.New("content already claimed")
+
+// claimVersion claims rec like CreateFileIfAbsent, but when its relative
+// path was ingested before, or its destination is taken, it reserves the
+// next version of the path and renames dst accordingly. The first version
+// keeps the plain name unless a latest link takes it. The reservation and
+// the record commit in one transaction, and lineage points at the newest
+// version seen so far.
+func (p *Processor) claimVersion(s *storage.Storage, rec *storage.FileRecord, dst *resolvedPath, relPath string) (bool, *storage.File, error) {
+	var (
+		created  bool
+		existing *storage.File
+	)
+
+	err := s.Transaction(func(tx *storage.Storage) error {
+		// Known content is a duplicate and must not consume a version
+		original, err := tx.FindBySHA256(rec.SHA256)
+		if err != nil {
+			return err
+		}
+		if original != nil {
+			existing = original
+			return errClaimLost
+		}
+
+		floor := 0
+		previous, err := tx.LatestVersion(rec.RelPath)
+		if err != nil {
+			return err
+		}
+		if previous == nil {
+			// Ingested before paths were recorded, or placed by hand
+			if previous, err = tx.FindByDestPath(dst.path); err != nil {
+				return err
+			}
+		}
+		if previous != nil {
+			floor = max(previous.Version, 1)
+			rec.PreviousSHA256 = previous.SHA256
+		} else if _, err := os.Lstat(dst.path); err == nil && !p.cfg.VersionLatestLink {
+			floor = 1
+		}
+
+		version, err := tx.NextVersion(rec.RelPath, floor)
+		if err != nil {
+			return err
+		}
+		rec.Version = version
+
+		if version > 1 || p.cfg.VersionLatestLink {
+			versioned, err := p.versionedDestination(relPath, version, rec.ProcessedAt)
+			if err != nil {
+				return err
+			}
+			// Keep the source spelling when the plain name was normalized
+			// or shortened
+			if dst.originalName != "" {
+				versioned.originalName = dst.originalName
+			}
+			*dst = versioned
+			rec.Name = dst.name
+			rec.OriginalName = dst.originalName
+			rec.DestPath = dst.path
+		}
+
+		created, existing, err = tx.CreateFileIfAbsent(*rec)
+		if err != nil {
+			return err
+		}
+		if !created {
+			return errClaimLost
+		}
+		return nil
+	})
+	if errors.Is(err, errClaimLost) {
+		return false, existing, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("claim version of %s: %w", relPath, err)
+	}
+	return created, existing, nil
+}
+
+// versionedDestination resolves the destination of a version of relPath.
+// Timestamp names fall back to adding the sequence number when two versions
+// arrive within the same second.
+func (p *Processor) versionedDestination(relPath string, version int, at time.Time) (resolvedPath, error) {
+	suffix := fmt.Sprintf("v%d", version)
+	if p.cfg.VersionOnNameConflict == config.VersionTimestamp {
+		suffix = at.UTC().Format(versionTimeLayout)
+	}
+
+	dst, err := p.resolveVersion(relPath, suffix)
+	if err != nil {
+		return resolvedPath{}, err
+	}
+	if p.cfg.VersionOnNameConflict == config.VersionTimestamp {
+		if _, err := os.Lstat(dst.path); err == nil {
+			return p.resolveVersion(relPath, fmt.Sprintf("%s.v%d", suffix, version))
+		}
+	}
+	return dst, nil
+}
+
+// resolveVersion resolves relPath with suffix inserted before its extension
+func (p *Processor) resolveVersion(relPath, suffix string) (resolvedPath, error) {
+	name := filepath.Base(relPath)
+	ext := filepath.Ext(name)
+	versioned := strings.TrimSuffix(name, ext) + "." + suffix + ext
+
+	dst, err := resolveDestination(p.cfg.Destination, filepath.Join(filepath.Dir(relPath), versioned), p.limits)
+	if err != nil {
+		return resolvedPath{}, err
+	}
+	if dst.originalName == "" {
+		dst.originalName = name
+	}
+	return dst, nil
+}
+
+// updateLatestLink points the symlink at linkPath to target. The link is
+// swapped in with a rename so readers never miss it, and a regular file at
+// linkPath, ingested before links were enabled, is left alone.
+func (p *Processor) updateLatestLink(linkPath, target string) {
+	if info, err := os.Lstat(linkPath); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		slog.Warn("cannot create latest link over an existing file", "link", linkPath, "target", target)
+		return
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(linkPath), target)
+	if err != nil {
+		slog.Warn("failed to update latest link", "link", linkPath, "target", target, "error", err)
+		return
+	}
+	tmp := filepath.Join(filepath.Dir(linkPath), "."+filepath.Base(linkPath)+".latest.tmp")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(rel, tmp); err != nil {
+		slog.Warn("failed to update latest link", "link", linkPath, "target", target, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, linkPath); err != nil {
+		_ = os.Remove(tmp)
+		slog.Warn("failed to update latest link", "link", linkPath, "target", target, "error", err)
+	}
+}
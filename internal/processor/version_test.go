@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// overwrite drops successive contents at the same input path, ingesting
+// each before the next arrives
+func overwrite(t *testing.T, env *testEnv, name string, contents ...string) {
+	t.Helper()
+
+	src := filepath.Join(env.inputDir, name)
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		t.Fatalf("failed to create input directory: %v", err)
+	}
+	for _, content := range contents {
+		if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+		if err := env.processor.processFile(src); err != nil {
+			t.Fatalf("processFile() error = %v", err)
+		}
+	}
+}
+
+func TestVersioning_Sequence(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+
+	overwrite(t, env, "latest.csv", "hour 1", "hour 2", "hour 3")
+
+	for name, content := range map[string]string{
+		"latest.csv":    "hour 1",
+		"latest.v2.csv": "hour 2",
+		"latest.v3.csv": "hour 3",
+	} {
+		data, err := os.ReadFile(filepath.Join(env.warehouseDir, name))
+		if err != nil {
+			t.Errorf("version %s missing: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+
+	chain, err := env.store.ListFiles(storage.FileFilter{RelPath: "latest.csv"})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("version chain has %d records, want 3", len(chain))
+	}
+	for i, f := range chain {
+		if f.Version != i+1 {
+			t.Errorf("chain[%d].Version = %d, want %d", i, f.Version, i+1)
+		}
+		want := ""
+		if i > 0 {
+			want = chain[i-1].SHA256
+		}
+		if f.PreviousSHA256 != want {
+			t.Errorf("chain[%d].PreviousSHA256 = %q, want %q", i, f.PreviousSHA256, want)
+		}
+	}
+	if chain[2].OriginalName != "latest.csv" {
+		t.Errorf("OriginalName = %q, want latest.csv", chain[2].OriginalName)
+	}
+
+	entries := readManifest(t, env.manifestsDir)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 manifest entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Version == 3 && e.PreviousSHA256 != chain[1].SHA256 {
+			t.Errorf("manifest lineage of v3 = %q, want %q", e.PreviousSHA256, chain[1].SHA256)
+		}
+	}
+}
+
+func TestVersioning_LatestLink(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+	env.cfg.VersionLatestLink = true
+
+	overwrite(t, env, "latest.csv", "hour 1", "hour 2", "hour 3")
+
+	for _, name := range []string{"latest.v1.csv", "latest.v2.csv", "latest.v3.csv"} {
+		if _, err := os.Stat(filepath.Join(env.warehouseDir, name)); err != nil {
+			t.Errorf("version %s missing: %v", name, err)
+		}
+	}
+
+	link := filepath.Join(env.warehouseDir, "latest.csv")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("latest link missing: %v", err)
+	}
+	if target != "latest.v3.csv" {
+		t.Errorf("latest link points at %q, want latest.v3.csv", target)
+	}
+	if data, _ := os.ReadFile(link); string(data) != "hour 3" {
+		t.Errorf("latest link content = %q, want hour 3", data)
+	}
+}
+
+func TestVersioning_Timestamp(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionTimestamp
+
+	overwrite(t, env, "sub/latest.csv", "hour 1", "hour 2", "hour 3")
+
+	entries, err := os.ReadDir(filepath.Join(env.warehouseDir, "sub"))
+	if err != nil {
+		t.Fatalf("failed to read warehouse: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 warehouse files, got %d", len(entries))
+	}
+
+	// Versions ingested within the same second also carry the sequence
+	pattern := regexp.MustCompile(`^latest\.\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z(\.v\d+)?\.csv$`)
+	versioned := 0
+	for _, e := range entries {
+		if pattern.MatchString(e.Name()) {
+			versioned++
+		} else if e.Name() != "latest.csv" {
+			t.Errorf("unexpected warehouse file %s", e.Name())
+		}
+	}
+	if versioned != 2 {
+		t.Errorf("expected 2 timestamped versions, got %d", versioned)
+	}
+}
+
+func TestVersioning_DuplicateKeepsSequence(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+
+	overwrite(t, env, "latest.csv", "hour 1", "hour 1", "hour 2")
+
+	chain, err := env.store.ListFiles(storage.FileFilter{RelPath: "latest.csv"})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(chain) != 2 || chain[1].Version != 2 {
+		t.Fatalf("unchanged content should not take a version: %+v", chain)
+	}
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "latest.v2.csv")); err != nil {
+		t.Errorf("second version missing: %v", err)
+	}
+}
+
+func TestVersioning_ExistingDestination(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.VersionOnNameConflict = config.VersionSequence
+
+	// A file placed in the warehouse without a record still takes the name
+	if err := os.WriteFile(filepath.Join(env.warehouseDir, "latest.csv"), []byte("by hand"), 0o644); err != nil {
+		t.Fatalf("failed to create warehouse file: %v", err)
+	}
+
+	overwrite(t, env, "latest.csv", "hour 1")
+
+	if data, _ := os.ReadFile(filepath.Join(env.warehouseDir, "latest.csv")); string(data) != "by hand" {
+		t.Errorf("existing warehouse file was overwritten: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(env.warehouseDir, "latest.v2.csv")); err != nil {
+		t.Errorf("new version missing: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WatermarkFile is the name of the watermark in the manifests directory
+const WatermarkFile = "_watermark.json"
+
+// Watermark tells incremental consumers how far ingestion is complete:
+// every file the watcher saw before At has been ingested, found to be a
+// duplicate or quarantined
+type Watermark struct {
+	At time.Time `json:"watermark"`
+	// UpdatedAt is when the watermark was last computed
+	UpdatedAt time.Time `json:"updated_at"`
+	// Pending counts the files, sets and batches holding it back
+	Pending int `json:"pending"`
+}
+
+// watermarkState is the latest watermark, loaded from the published file on
+// first use so that it never moves back across restarts
+type watermarkState struct {
+	mu      sync.Mutex
+	loaded  bool
+	current Watermark
+}
+
+// AdvanceWatermark recomputes the watermark from the watcher and publishes
+// it to WatermarkFile atomically. The watermark only moves forward: files
+// found at startup hold it where the previous run left it until they are
+// processed. A dry run computes it without publishing.
+func (p *Processor) AdvanceWatermark() (Watermark, error) {
+	p.mark.mu.Lock()
+	defer p.mark.mu.Unlock()
+
+	path := filepath.Join(p.cfg.ManifestsPath, WatermarkFile)
+	if !p.mark.loaded {
+		prev, err := readWatermark(path)
+		if err != nil {
+			return p.mark.current, err
+		}
+		p.mark.current = prev
+		p.mark.loaded = true
+	}
+
+	at, pending := p.watcher.Watermark()
+	next := Watermark{
+		At:        p.mark.current.At,
+		UpdatedAt: time.Now().UTC(),
+		Pending:   pending,
+	}
+	if at.After(next.At) {
+		next.At = at.UTC()
+	}
+	p.mark.current = next
+
+	if p.cfg.DryRun {
+		return next, nil
+	}
+	return next, writeWatermark(path, next)
+}
+
+// CurrentWatermark returns the watermark last computed, zero before the
+// first AdvanceWatermark
+func (p *Processor) CurrentWatermark() Watermark {
+	p.mark.mu.Lock()
+	defer p.mark.mu.Unlock()
+	return p.mark.current
+}
+
+// watermarkLag returns how far the watermark trails the clock, zero before
+// it is first computed
+func (p *Processor) watermarkLag() time.Duration {
+	mark := p.CurrentWatermark()
+	if mark.At.IsZero() {
+		return 0
+	}
+	return max(time.Since(mark.At), 0)
+}
+
+// readWatermark reads the watermark published at path, zero when there is
+// none yet
+func readWatermark(path string) (Watermark, error) {
+	var mark Watermark
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mark, nil
+	}
+	if err != nil {
+		return mark, fmt.Errorf("read watermark: %w", err)
+	}
+	if err := json.Unmarshal(data, &mark); err != nil {
+		slog.Warn("ignoring unreadable watermark", "path", path, "error", err)
+		return Watermark{}, nil
+	}
+	return mark, nil
+}
+
+// writeWatermark replaces the watermark at path through a temporary file
+func writeWatermark(path string, mark Watermark) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create manifests directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(mark, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode watermark: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write watermark: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("commit watermark: %w", err)
+	}
+	return nil
+}
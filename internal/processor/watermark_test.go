@@ -0,0 +1,159 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// arrive writes name into the input directory and waits until the watcher
+// tracks it, returning the time it is tracked by
+func arrive(t *testing.T, env *testEnv, name string) (string, time.Time) {
+	t.Helper()
+	path := writeSourceFiles(t, env, name)[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for !env.watcher.IsTracked(path) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never tracked", name)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return path, time.Now()
+}
+
+// advance advances the watermark, failing the test if it moved back
+func advance(t *testing.T, env *testEnv, prev Watermark) Watermark {
+	t.Helper()
+	mark, err := env.processor.AdvanceWatermark()
+	if err != nil {
+		t.Fatalf("AdvanceWatermark() error = %v", err)
+	}
+	if mark.At.Before(prev.At) {
+		t.Fatalf("watermark moved back from %v to %v", prev.At, mark.At)
+	}
+	return mark
+}
+
+func TestAdvanceWatermark_NeverPassesPendingFiles(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	first, firstTracked := arrive(t, env, "a.csv")
+	time.Sleep(50 * time.Millisecond)
+	second, secondTracked := arrive(t, env, "b.csv")
+
+	mark := advance(t, env, Watermark{})
+	if mark.At.After(firstTracked) || mark.Pending != 2 {
+		t.Fatalf("watermark = %+v, want at most %v with both files pending", mark, firstTracked)
+	}
+
+	// The later file finishes first: a.csv still holds the watermark back
+	if err := env.processor.processFile(second); err != nil {
+		t.Fatalf("processFile(b.csv) error = %v", err)
+	}
+	mark = advance(t, env, mark)
+	if mark.At.After(firstTracked) || mark.Pending != 1 {
+		t.Fatalf("watermark = %+v after b.csv, want at most %v while a.csv is pending", mark, firstTracked)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	third, thirdTracked := arrive(t, env, "c.csv")
+	if err := env.processor.processFile(first); err != nil {
+		t.Fatalf("processFile(a.csv) error = %v", err)
+	}
+	mark = advance(t, env, mark)
+	if !mark.At.After(secondTracked) || mark.At.After(thirdTracked) || mark.Pending != 1 {
+		t.Fatalf("watermark = %+v after a.csv, want in (%v, %v] while c.csv is pending", mark, secondTracked, thirdTracked)
+	}
+
+	if err := env.processor.processFile(third); err != nil {
+		t.Fatalf("processFile(c.csv) error = %v", err)
+	}
+	before := time.Now()
+	mark = advance(t, env, mark)
+	if mark.At.Before(before) || mark.Pending != 0 {
+		t.Fatalf("watermark = %+v with nothing pending, want now", mark)
+	}
+
+	data, err := os.ReadFile(filepath.Join(env.manifestsDir, WatermarkFile))
+	if err != nil {
+		t.Fatalf("failed to read watermark file: %v", err)
+	}
+	var published Watermark
+	if err := json.Unmarshal(data, &published); err != nil {
+		t.Fatalf("failed to decode watermark file: %v", err)
+	}
+	if !published.At.Equal(mark.At) {
+		t.Errorf("published watermark = %v, want %v", published.At, mark.At)
+	}
+	if lag := env.processor.Stats().WatermarkLag; lag < 0 || lag > time.Minute {
+		t.Errorf("Stats().WatermarkLag = %v, want the age of the watermark", lag)
+	}
+}
+
+func TestAdvanceWatermark_SurvivesRestart(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	if err := env.watcher.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	first, _ := arrive(t, env, "a.csv")
+	if err := env.processor.processFile(first); err != nil {
+		t.Fatalf("processFile(a.csv) error = %v", err)
+	}
+	published := advance(t, env, Watermark{})
+
+	// A file left pending across the restart was seen by the earlier run,
+	// so the new one holds the watermark where it was
+	leftover := writeSourceFiles(t, env, "b.csv")[0]
+	w, err := watcher.New(config.MethodStabilityWindow, env.inputDir, 1)
+	if err != nil {
+		t.Fatalf("watcher.New() error = %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	restarted := New(env.cfg, env.store, w)
+
+	mark, err := restarted.AdvanceWatermark()
+	if err != nil {
+		t.Fatalf("AdvanceWatermark() error = %v", err)
+	}
+	if !mark.At.Equal(published.At) || mark.Pending != 1 {
+		t.Fatalf("watermark after restart = %+v, want %v held by the leftover file", mark, published.At)
+	}
+
+	if err := restarted.processFile(leftover); err != nil {
+		t.Fatalf("processFile(b.csv) error = %v", err)
+	}
+	if mark, _ = restarted.AdvanceWatermark(); !mark.At.After(published.At) {
+		t.Errorf("watermark = %v once the leftover file is processed, want after %v", mark.At, published.At)
+	}
+}
+
+func TestAdvanceWatermark_DryRunPublishesNothing(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DryRun = true
+
+	mark, err := env.processor.AdvanceWatermark()
+	if err != nil {
+		t.Fatalf("AdvanceWatermark() error = %v", err)
+	}
+	if mark.At.IsZero() {
+		t.Error("dry run watermark is zero with nothing tracked, want now")
+	}
+	if _, err := os.Stat(filepath.Join(env.manifestsDir, WatermarkFile)); !os.IsNotExist(err) {
+		t.Errorf("watermark file stat error = %v, want not exist in a dry run", err)
+	}
+}
@@ -0,0 +1,42 @@
+package processor
+
+import (
+	"log/slog"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// outsideDedupWindow checks, when a dedup window is set, whether original
+// was recorded before it. The arrival is then not a duplicate: it continues
+// through the pipeline and its claim marks original superseded, releasing
+// its hash and key, so the content is ingested again.
+func (p *Processor) outsideDedupWindow(fc *FileContext, original *storage.File) bool {
+	if p.cfg.DedupWindow <= 0 {
+		return false
+	}
+	cutoff := p.clock().Add(-p.cfg.DedupWindow)
+	if !original.CreatedAt.Before(cutoff) {
+		return false
+	}
+
+	slog.Info("content recorded before the dedup window, ingesting it again",
+		"path", fc.SourcePath,
+		"sha256", fc.SHA256,
+		"original", original.Path,
+		"original_destination", original.DestPath,
+		"original_recorded_at", original.CreatedAt,
+		"dedup_window", p.cfg.DedupWindow,
+	)
+	fc.Supersedes = original
+	return true
+}
+
+// markSuperseded marks the original a file supersedes superseded in tx,
+// before the file's own record takes its hash. Callers check fc.Supersedes.
+func (p *Processor) markSuperseded(tx *storage.Storage, fc *FileContext) error {
+	if err := tx.MarkSuperseded(fc.Supersedes); err != nil {
+		return err
+	}
+	p.present.forget(fc.Supersedes.DestPath)
+	return nil
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestDedupWindow_ResendInsideWindowIsDuplicate(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DedupWindow = 24 * time.Hour
+	clock := useTestClock(env)
+
+	content := "a,b\n1,2\n"
+	ingest(t, env, "q1.csv", content)
+	clock.now = clock.now.Add(23 * time.Hour)
+	ingest(t, env, "resend.csv", content)
+
+	if dups := duplicateEntries(t, env); len(dups) != 1 || filepath.Base(dups[0].SourcePath) != "resend.csv" {
+		t.Errorf("duplicate entries = %+v, want the resend", dups)
+	}
+	if superseded, _ := env.store.ListFiles(storage.FileFilter{Status: status.Superseded}); len(superseded) != 0 {
+		t.Errorf("superseded = %+v, want none inside the window", superseded)
+	}
+}
+
+func TestDedupWindow_RecurrenceOutsideWindowIsIngested(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	env.cfg.DedupWindow = 24 * time.Hour
+	clock := useTestClock(env)
+
+	content := "a,b\n1,2\n"
+	ingest(t, env, "q1.csv", content)
+	original, err := env.store.FindByDestPath(filepath.Join(env.warehouseDir, "q1.csv"))
+	if err != nil || original == nil {
+		t.Fatalf("original record missing: %v", err)
+	}
+	clock.now = clock.now.Add(25 * time.Hour)
+	ingest(t, env, "q2.csv", content)
+
+	if dups := duplicateEntries(t, env); len(dups) != 0 {
+		t.Errorf("recurrence recorded as a duplicate: %+v", dups)
+	}
+	entry := entryOf(t, env, "q2.csv")
+	if entry.Status != status.Ingested || entry.Supersedes != original.DestPath {
+		t.Errorf("entry = %+v, want ingested superseding %s", entry, original.DestPath)
+	}
+	marked, err := env.store.ListFiles(storage.FileFilter{Status: status.Superseded})
+	if err != nil || len(marked) != 1 || marked[0].ID != original.ID {
+		t.Fatalf("superseded = %+v, %v, want the original", marked, err)
+	}
+	rec, err := env.store.FindBySHA256(original.SHA256)
+	if err != nil || rec == nil || rec.DestPath != filepath.Join(env.warehouseDir, "q2.csv") {
+		t.Errorf("hash should now point at the new ingestion, got %+v, %v", rec, err)
+	}
+
+	// The new record starts the window over
+	ingest(t, env, "resend.csv", content)
+	if dups := duplicateEntries(t, env); len(dups) != 1 || filepath.Base(dups[0].SourcePath) != "resend.csv" {
+		t.Errorf("duplicate entries = %+v, want the resend", dups)
+	}
+}
+
+func TestDedupWindow_Off(t *testing.T) {
+	env := setupTestEnv(t)
+	defer env.cleanup()
+	clock := useTestClock(env)
+
+	ingest(t, env, "q1.csv", "a,b\n")
+	clock.now = clock.now.AddDate(1, 0, 0)
+	ingest(t, env, "q2.csv", "a,b\n")
+
+	if dups := duplicateEntries(t, env); len(dups) != 1 {
+		t.Errorf("duplicate entries = %+v, want the recurrence skipped without a window", dups)
+	}
+}
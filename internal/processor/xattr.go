@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+)
+
+// Extended attributes of warehouse files set with SetXattrs
+const (
+	XattrSHA256      = "user.ingestor.sha256"
+	XattrIngestID    = "user.ingestor.ingest_id"
+	XattrProcessedAt = "user.ingestor.processed_at"
+	XattrSource      = "user.ingestor.source"
+)
+
+// XattrNames are the extended attributes SetXattrs sets
+var XattrNames = []string{XattrSHA256, XattrIngestID, XattrProcessedAt, XattrSource}
+
+// IngestID identifies the ingestion of content at processedAt with manifest
+// sequence seq. It is derived from the record alone, so the identifier of a
+// warehouse file can be checked against the database.
+func IngestID(sha string, processedAt time.Time, seq int64) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\n%s\n%d", sha, FormatProcessedAt(processedAt), seq))
+	return hex.EncodeToString(sum[:16])
+}
+
+// FormatProcessedAt formats a processing time as stored in XattrProcessedAt
+func FormatProcessedAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// setXattrs records entry in the extended attributes of its warehouse copy
+// when SetXattrs is set. It runs before the copy is sealed, as a read-only
+// file takes no attributes. Failures are logged; the file is already in the
+// warehouse.
+func (p *Processor) setXattrs(entry manifest.Entry, source string) {
+	if !p.cfg.SetXattrs {
+		return
+	}
+	err := fileops.SetXattrs(entry.DestPath, map[string]string{
+		XattrSHA256:      entry.SHA256,
+		XattrIngestID:    IngestID(entry.SHA256, entry.ProcessedAt, entry.Sequence),
+		XattrProcessedAt: FormatProcessedAt(entry.ProcessedAt),
+		XattrSource:      source,
+	})
+	if errors.Is(err, fileops.ErrXattrUnsupported) {
+		p.xattrsUnsupported.Do(func() {
+			slog.Warn("warehouse filesystem does not support extended attributes, not setting them", "destination", entry.DestPath)
+		})
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to set extended attributes", "destination", entry.DestPath, "error", err)
+	}
+}
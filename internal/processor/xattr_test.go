@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+func TestSetXattrs(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			env := setupTestEnv(t)
+			defer env.cleanup()
+			env.cfg.SetXattrs = enabled
+			if err := os.MkdirAll(filepath.Join(env.inputDir, "acme"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			ingest(t, env, "acme/orders.csv", "id,total\n1,10\n")
+			dst := filepath.Join(env.warehouseDir, "acme", "orders.csv")
+			attrs, err := fileops.GetXattrs(dst, XattrNames)
+			if err != nil {
+				t.Fatalf("GetXattrs() error = %v", err)
+			}
+			if !enabled {
+				if len(attrs) != 0 {
+					t.Errorf("extended attributes = %v without SetXattrs, want none", attrs)
+				}
+				return
+			}
+			if len(attrs) == 0 {
+				t.Skip("filesystem does not support extended attributes")
+			}
+
+			// The database record alone reproduces every attribute
+			file, err := env.store.FindByDestPath(dst)
+			if err != nil || file == nil {
+				t.Fatalf("FindByDestPath() = %v, %v", file, err)
+			}
+			want := map[string]string{
+				XattrSHA256:      file.SHA256,
+				XattrIngestID:    IngestID(file.SHA256, file.ProcessedAt, file.Sequence),
+				XattrProcessedAt: FormatProcessedAt(file.ProcessedAt),
+				XattrSource:      "acme",
+			}
+			for name, value := range want {
+				if attrs[name] != value {
+					t.Errorf("%s = %q, want %q", name, attrs[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestIngestID_Deterministic(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 0, 0, 123456789, time.UTC)
+	id := IngestID("abc", at, 7)
+	if IngestID("abc", at.Local(), 7) != id {
+		t.Error("ingest id depends on the time zone")
+	}
+	if IngestID("abc", at, 8) == id || IngestID("abd", at, 7) == id {
+		t.Error("ingest id does not depend on the sequence and hash")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package recovery
+
+// processAlive reports every process as running; liveness is only checked
+// on Unix
+func processAlive(int) bool {
+	return true
+}
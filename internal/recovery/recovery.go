@@ -0,0 +1,300 @@
+// Package recovery scans the warehouse, manifests and state database at
+// startup for inconsistencies an earlier run left behind, for strict
+// startup to report before anything repairs them
+package recovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Anomaly kinds
+const (
+	// KindOrphanTemp is a temporary file of an interrupted write in the
+	// warehouse or the manifests
+	KindOrphanTemp = "orphan_temp"
+	// KindStagingObject is an upload left in the warehouse staging area,
+	// completed or deleted by staging recovery
+	KindStagingObject = "staging_object"
+	// KindScratchCopy is a working copy left in the warehouse scratch area,
+	// deleted on the next start
+	KindScratchCopy = "scratch_copy"
+	// KindMissingDestination is a file record whose warehouse copy is gone
+	KindMissingDestination = "missing_destination"
+	// KindManifestDivergence is a recently ingested file with no entry in
+	// its manifest file
+	KindManifestDivergence = "manifest_divergence"
+	// KindStaleClaim is a maintenance lock held by a process that is no
+	// longer running, which pauses processing until it expires
+	KindStaleClaim = "stale_claim"
+)
+
+// Options configures a Scan
+type Options struct {
+	Warehouse string
+	Manifests string
+	// ManifestWindow is how far back file records are compared with the
+	// JSON Lines manifest; 0 skips the comparison
+	ManifestWindow time.Duration
+	// Layout places the manifest files, the default layout when nil
+	Layout *layout.Resolver
+	// Now is the time of the scan, time.Now when zero
+	Now time.Time
+}
+
+// Anomaly is one inconsistency
+type Anomaly struct {
+	Kind string `json:"kind"`
+	// Path is the file or record the anomaly is about
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// Report lists the inconsistencies found by a Scan
+type Report struct {
+	ScannedAt time.Time `json:"scanned_at"`
+	Anomalies []Anomaly `json:"anomalies"`
+	// Fingerprint identifies the set of anomalies, independent of their
+	// details, so an acknowledged set is recognized on the next start
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Clean reports whether no anomaly was found
+func (r *Report) Clean() bool {
+	return len(r.Anomalies) == 0
+}
+
+// Scan looks for every kind of anomaly. It only reads.
+func Scan(store *storage.Storage, opts Options) (*Report, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	report := &Report{ScannedAt: opts.Now.UTC(), Anomalies: []Anomaly{}}
+
+	scans := []func(*storage.Storage, Options) ([]Anomaly, error){
+		scanTemps,
+		scanDestinations,
+		scanManifests,
+		scanClaims,
+	}
+	for _, scan := range scans {
+		found, err := scan(store, opts)
+		if err != nil {
+			return nil, err
+		}
+		report.Anomalies = append(report.Anomalies, found...)
+	}
+
+	slices.SortFunc(report.Anomalies, func(a, b Anomaly) int {
+		if c := strings.Compare(a.Kind, b.Kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	h := sha256.New()
+	for _, a := range report.Anomalies {
+		fmt.Fprintf(h, "%s\x00%s\n", a.Kind, a.Path)
+	}
+	if !report.Clean() {
+		report.Fingerprint = hex.EncodeToString(h.Sum(nil))[:16]
+	}
+	return report, nil
+}
+
+// scanTemps finds the temporary files of interrupted writes and the
+// objects left in the staging and scratch areas
+func scanTemps(_ *storage.Storage, opts Options) ([]Anomaly, error) {
+	var found []Anomaly
+	staging := filepath.Join(opts.Warehouse, filepath.FromSlash(strings.TrimSuffix(destination.StagingPrefix, "/")))
+	scratch := filepath.Join(opts.Warehouse, filepath.FromSlash(strings.TrimSuffix(destination.ScratchPrefix, "/")))
+	for _, root := range []string{opts.Warehouse, opts.Manifests} {
+		if root == "" {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && path == root {
+				return fs.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if strings.HasPrefix(path, staging+string(filepath.Separator)) {
+				found = append(found, Anomaly{Kind: KindStagingObject, Path: path, Detail: "upload interrupted before it was committed"})
+				return nil
+			}
+			if strings.HasPrefix(path, scratch+string(filepath.Separator)) {
+				found = append(found, Anomaly{Kind: KindScratchCopy, Path: path, Detail: "working copy of an interrupted ingest"})
+				return nil
+			}
+			if strings.HasSuffix(d.Name(), config.TempSuffix) {
+				found = append(found, Anomaly{Kind: KindOrphanTemp, Path: path, Detail: "temporary file of an interrupted write"})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %s for temporary files: %w", root, err)
+		}
+	}
+	return found, nil
+}
+
+// scanDestinations finds file records whose warehouse copy is missing
+func scanDestinations(store *storage.Storage, _ Options) ([]Anomaly, error) {
+	files, err := store.ListFiles(storage.FileFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var found []Anomaly
+	for _, f := range files {
+		if f.DestPath == "" || f.Status == status.Suspect {
+			continue
+		}
+		if _, err := os.Lstat(f.DestPath); errors.Is(err, fs.ErrNotExist) {
+			found = append(found, Anomaly{
+				Kind:   KindMissingDestination,
+				Path:   f.DestPath,
+				Detail: fmt.Sprintf("record %s (%s) has no warehouse copy", f.SHA256, f.Status),
+			})
+		}
+	}
+	return found, nil
+}
+
+// scanManifests finds files ingested within the manifest window that their
+// JSON Lines manifest file has no entry for. Files whose entry is pending,
+// still to be appended or retried by the processor, are in progress rather
+// than divergent.
+func scanManifests(store *storage.Storage, opts Options) ([]Anomaly, error) {
+	if opts.ManifestWindow <= 0 || opts.Manifests == "" {
+		return nil, nil
+	}
+	files, err := store.ListFiles(storage.FileFilter{Status: status.Ingested})
+	if err != nil {
+		return nil, err
+	}
+	pending, err := store.PendingManifestEntries()
+	if err != nil {
+		return nil, err
+	}
+	inProgress := make(map[string]bool, len(pending))
+	for _, row := range pending {
+		inProgress[row.SHA256] = true
+	}
+
+	writer := manifest.NewWriter(opts.Manifests)
+	if opts.Layout != nil {
+		writer.SetLayout(opts.Layout)
+	}
+	entries := make(map[string]map[string]bool)
+	since := opts.Now.Add(-opts.ManifestWindow)
+	var found []Anomaly
+	for _, f := range files {
+		if f.ProcessedAt.Before(since) || inProgress[f.SHA256] {
+			continue
+		}
+		// The processor stamps entries, and so names manifest files, in
+		// local time. A layout by source spreads the hour over the files
+		// of every source.
+		paths := writer.Files(f.ProcessedAt.Local())
+		recorded := false
+		for _, path := range paths {
+			hashes, ok := entries[path]
+			if !ok {
+				var readErr error
+				hashes, readErr, err = manifestHashes(path)
+				if err != nil {
+					return nil, err
+				}
+				if readErr != nil {
+					found = append(found, Anomaly{Kind: KindManifestDivergence, Path: path, Detail: readErr.Error()})
+				}
+				entries[path] = hashes
+			}
+			recorded = recorded || hashes[f.SHA256]
+		}
+		if !recorded {
+			found = append(found, Anomaly{
+				Kind:   KindManifestDivergence,
+				Path:   f.DestPath,
+				Detail: fmt.Sprintf("record %s has no entry in %s", f.SHA256, strings.Join(paths, ", ")),
+			})
+		}
+	}
+	return found, nil
+}
+
+// manifestHashes returns the hashes of the ingested entries of a manifest
+// file, none when it does not exist. A line that cannot be read, such as one
+// torn by a crash, ends the entries returned and is reported as readErr.
+func manifestHashes(path string) (hashes map[string]bool, readErr, err error) {
+	hashes = make(map[string]bool)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return hashes, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open manifest %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, _, readErr := manifest.ReadWith(f, manifest.ReadOptions{Lenient: true})
+	for _, e := range entries {
+		if e.Status == "" || e.Status == status.Ingested {
+			hashes[e.SHA256] = true
+		}
+	}
+	return hashes, readErr, nil
+}
+
+// scanClaims finds a maintenance lock held by a process of this host that
+// is no longer running
+func scanClaims(store *storage.Storage, _ Options) ([]Anomaly, error) {
+	lock, err := store.MaintenanceStatus()
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	if lock.Host != host || processAlive(lock.PID) {
+		return nil, nil
+	}
+	return []Anomaly{{
+		Kind: KindStaleClaim,
+		Path: "maintenance_lock",
+		Detail: fmt.Sprintf("held by %s (pid %d on %s, no longer running) until %s",
+			lock.Owner, lock.PID, lock.Host, lock.ExpiresAt.UTC().Format(time.RFC3339)),
+	}}, nil
+}
+
+// WriteReport writes the anomalies of report as an aligned table
+func WriteReport(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Startup inconsistencies found at %s (fingerprint %s)\n", report.ScannedAt.Format(time.RFC3339), report.Fingerprint)
+	fmt.Fprintln(tw, "KIND\tPATH\tDETAIL")
+	for _, a := range report.Anomalies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Kind, a.Path, a.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write startup report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package recovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// env is a warehouse, manifests directory and state database to fabricate
+// anomalies in
+type env struct {
+	db        *gorm.DB
+	store     *storage.Storage
+	warehouse string
+	manifests string
+}
+
+func setup(t *testing.T) env {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "state.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	e := env{db: db, store: store, warehouse: filepath.Join(dir, "warehouse"), manifests: filepath.Join(dir, "manifests")}
+	for _, d := range []string{e.warehouse, e.manifests} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", d, err)
+		}
+	}
+	return e
+}
+
+func (e env) scan(t *testing.T, now time.Time) *Report {
+	t.Helper()
+	report, err := Scan(e.store, Options{Warehouse: e.warehouse, Manifests: e.manifests, ManifestWindow: 24 * time.Hour, Now: now})
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	return report
+}
+
+// ingest records a file with its warehouse copy and, when listed, its
+// manifest entry
+func (e env) ingest(t *testing.T, name string, at time.Time, listed bool) string {
+	t.Helper()
+	dest := filepath.Join(e.warehouse, name)
+	write(t, dest, name)
+	sha := "sha-" + name
+	if _, _, err := e.store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256: sha, Name: name, Size: 1, Status: status.Ingested, DestPath: dest, ProcessedAt: at, RelPath: name,
+	}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+	if listed {
+		w := manifest.NewWriter(e.manifests)
+		if err := w.Append(manifest.Entry{SHA256: sha, Name: name, DestPath: dest, ProcessedAt: at.Local(), Status: status.Ingested}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+	return dest
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestScan_Clean(t *testing.T) {
+	e := setup(t)
+	now := time.Now()
+	e.ingest(t, "a.csv", now.Add(-time.Hour), true)
+	// Outside the manifest window, so not compared
+	e.ingest(t, "old.csv", now.Add(-48*time.Hour), false)
+
+	report := e.scan(t, now)
+	if !report.Clean() || report.Fingerprint != "" {
+		t.Errorf("Scan() = %+v, want no anomalies", report)
+	}
+}
+
+func TestScan_FindsEveryKind(t *testing.T) {
+	e := setup(t)
+	now := time.Now()
+
+	write(t, filepath.Join(e.warehouse, "acme", "orders.csv.tmp"), "partial")
+	write(t, filepath.Join(e.manifests, "2024", "manifest.jsonl.tmp"), "partial")
+	staged := filepath.Join(e.warehouse, "_staging", "upload-1", "acme", "big.csv")
+	write(t, staged, "partial")
+	write(t, filepath.Join(e.warehouse, "_scratch", "copy-1", "acme", "big.csv"), "partial")
+
+	gone := e.ingest(t, "gone.csv", now.Add(-48*time.Hour), false)
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	unlisted := e.ingest(t, "unlisted.csv", now.Add(-time.Hour), false)
+	e.ingest(t, "listed.csv", now.Add(-time.Hour), true)
+	// Not listed yet, but pending: the processor retries its entry
+	e.ingest(t, "retrying.csv", now.Add(-time.Hour), false)
+	if err := e.store.HoldManifestEntry(manifest.Entry{SHA256: "sha-retrying.csv", Name: "retrying.csv"}); err != nil {
+		t.Fatalf("HoldManifestEntry() error = %v", err)
+	}
+
+	lock, _ := json.Marshal(storage.MaintenanceLock{Owner: "backup", PID: 1 << 30, Host: hostname(t), StartedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err := e.db.Create(&storage.Meta{Key: "maintenance_lock", Value: string(lock)}).Error; err != nil {
+		t.Fatalf("failed to write maintenance lock: %v", err)
+	}
+
+	report := e.scan(t, now)
+	var got []string
+	for _, a := range report.Anomalies {
+		got = append(got, a.Kind+" "+strings.TrimPrefix(a.Path, filepath.Dir(e.warehouse)))
+	}
+	want := []string{
+		KindManifestDivergence + " /warehouse/unlisted.csv",
+		KindMissingDestination + " /warehouse/gone.csv",
+		KindOrphanTemp + " /manifests/2024/manifest.jsonl.tmp",
+		KindOrphanTemp + " /warehouse/acme/orders.csv.tmp",
+		KindScratchCopy + " /warehouse/_scratch/copy-1/acme/big.csv",
+		KindStagingObject + " /warehouse/_staging/upload-1/acme/big.csv",
+		KindStaleClaim + " maintenance_lock",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("anomalies =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if report.Fingerprint == "" {
+		t.Error("report has no fingerprint")
+	}
+
+	// The same anomalies give the same fingerprint; a new one changes it
+	if again := e.scan(t, now.Add(time.Minute)); again.Fingerprint != report.Fingerprint {
+		t.Errorf("fingerprint changed from %s to %s without new anomalies", report.Fingerprint, again.Fingerprint)
+	}
+	if err := os.Remove(unlisted); err != nil {
+		t.Fatal(err)
+	}
+	if changed := e.scan(t, now); changed.Fingerprint == report.Fingerprint {
+		t.Error("fingerprint unchanged after a new anomaly")
+	}
+}
+
+func TestScan_LiveClaim(t *testing.T) {
+	e := setup(t)
+	m, err := e.store.BeginMaintenance("backup", time.Hour)
+	if err != nil {
+		t.Fatalf("BeginMaintenance() error = %v", err)
+	}
+	defer func() { _ = m.End() }()
+
+	if report := e.scan(t, time.Now()); !report.Clean() {
+		t.Errorf("Scan() = %+v, want a lock of a running process left alone", report.Anomalies)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	var b strings.Builder
+	report := &Report{
+		ScannedAt:   time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC),
+		Fingerprint: "0123456789abcdef",
+		Anomalies:   []Anomaly{{Kind: KindOrphanTemp, Path: "/warehouse/a.tmp", Detail: "temporary file of an interrupted write"}},
+	}
+	if err := WriteReport(&b, report); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	for _, want := range []string{"fingerprint 0123456789abcdef", "orphan_temp", "/warehouse/a.tmp"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func hostname(t *testing.T) string {
+	t.Helper()
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Hostname() error = %v", err)
+	}
+	return host
+}
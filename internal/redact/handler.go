@@ -0,0 +1,97 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Handler wraps a slog.Handler, redacting the message and every attribute
+// of each record before passing it on. Strings, errors, string slices and
+// maps, and stringers are redacted in place; other values are replaced by
+// their redacted text only when it differs from their printed form.
+type Handler struct {
+	next slog.Handler
+	r    *Redactor
+}
+
+// NewHandler wraps next with r
+func NewHandler(next slog.Handler, r *Redactor) *Handler {
+	return &Handler{next: next, r: r}
+}
+
+// Enabled implements slog.Handler
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h *Handler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.Text(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements slog.Handler
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), r: h.r}
+}
+
+// WithGroup implements slog.Handler
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), r: h.r}
+}
+
+func (h *Handler) attr(a slog.Attr) slog.Attr {
+	return slog.Attr{Key: a.Key, Value: h.value(a.Value.Resolve())}
+}
+
+func (h *Handler) value(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.StringValue(h.r.Text(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			redacted[i] = h.attr(a)
+		}
+		return slog.GroupValue(redacted...)
+	case slog.KindAny:
+	default:
+		// Numbers, times and durations hold no names
+		return v
+	}
+
+	switch x := v.Any().(type) {
+	case error:
+		return slog.StringValue(h.r.Text(x.Error()))
+	case []string:
+		redacted := make([]string, len(x))
+		for i, s := range x {
+			redacted[i] = h.r.Text(s)
+		}
+		return slog.AnyValue(redacted)
+	case map[string]string:
+		redacted := make(map[string]string, len(x))
+		for k, s := range x {
+			redacted[h.r.Text(k)] = h.r.Text(s)
+		}
+		return slog.AnyValue(redacted)
+	case fmt.Stringer:
+		return slog.StringValue(h.r.Text(x.String()))
+	default:
+		printed := fmt.Sprint(x)
+		if redacted := h.r.Text(printed); redacted != printed {
+			return slog.StringValue(redacted)
+		}
+		return v
+	}
+}
@@ -0,0 +1,58 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type named struct{ path string }
+
+func (n named) String() string { return n.path }
+
+type record struct {
+	Path string
+	Size int
+}
+
+func TestHandler_RedactsEveryAttribute(t *testing.T) {
+	r := newTestRedactor(t)
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), r))
+
+	logger.With("source", "/in/Jane.csv").WithGroup("file").Info("failed to ingest /in/Jane.csv",
+		"path", "/in/clinic/anna.csv",
+		"error", errors.New(`open "/in/Jane.csv": denied`),
+		"files", []string{"/in/Jane.csv", "/in/x_patient_y.csv"},
+		"tags", map[string]string{"name": "Jane.csv"},
+		"stringer", named{"/in/Jane.csv"},
+		"struct", record{Path: "/in/Jane.csv", Size: 3},
+		slog.Group("nested", "name", "Jane.csv"),
+		"size", 42,
+		"elapsed", time.Second,
+	)
+
+	out := buf.String()
+	for _, raw := range []string{"Jane", "anna", "patient"} {
+		if strings.Contains(out, raw) {
+			t.Errorf("log line leaks %q: %s", raw, out)
+		}
+	}
+	for _, want := range []string{r.Pseudonym("Jane.csv"), r.Pseudonym("anna.csv"), `"size":42`, `"elapsed":1000000000`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log line lacks %q: %s", want, out)
+		}
+	}
+}
+
+func TestHandler_KeepsOtherValues(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), newTestRedactor(t)))
+	logger.Info("file processed", "struct", record{Path: "/in/a.csv", Size: 3})
+	if !strings.Contains(buf.String(), `"struct":{"Path":"/in/a.csv","Size":3}`) {
+		t.Errorf("a value without sensitive names should stay structured: %s", buf.String())
+	}
+}
@@ -0,0 +1,239 @@
+// Package redact replaces sensitive file and directory names with stable
+// pseudonyms before they reach logs, events and manifests
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+)
+
+// Prefix starts every pseudonym
+const Prefix = "redacted-"
+
+// pseudonymHex is how many hex digits of the HMAC a pseudonym keeps
+const pseudonymHex = 16
+
+// minKeyBytes is the shortest HMAC key accepted
+const minKeyBytes = 16
+
+// pseudonymRe matches a pseudonym, which is never redacted again
+var pseudonymRe = regexp.MustCompile(fmt.Sprintf("^%s[0-9a-f]{%d}$", Prefix, pseudonymHex))
+
+// Redactor pseudonymizes path components matching one of its patterns and
+// every component below one of its tenant directories. A nil Redactor
+// leaves everything as is.
+type Redactor struct {
+	patterns []*regexp.Regexp
+	tenants  map[string]bool
+	key      []byte
+}
+
+// Load creates a redactor from cfg, reading its HMAC key from the key file.
+// It returns nil when cfg redacts nothing.
+func Load(cfg *config.RedactionConfig) (*Redactor, error) {
+	if cfg == nil || len(cfg.Patterns) == 0 && len(cfg.Tenants) == 0 {
+		return nil, nil
+	}
+	if cfg.KeyFile == "" {
+		return nil, errors.New("redaction needs a key_file")
+	}
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read redaction key: %w", err)
+	}
+	return New(cfg.Patterns, cfg.Tenants, []byte(strings.TrimSpace(string(key))))
+}
+
+// New creates a redactor hiding path components matching one of the glob
+// patterns and every component below the top-level tenant directories.
+// Pseudonyms are an HMAC of the name under key, so they are stable for as
+// long as the key is.
+func New(patterns, tenants []string, key []byte) (*Redactor, error) {
+	if len(key) < minKeyBytes {
+		return nil, fmt.Errorf("redaction key must have at least %d bytes", minKeyBytes)
+	}
+	r := &Redactor{tenants: make(map[string]bool, len(tenants)), key: key}
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			return nil, fmt.Errorf("redaction pattern %q must match a single path component", pattern)
+		}
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for _, tenant := range tenants {
+		if tenant == "" || strings.Contains(tenant, "/") {
+			return nil, fmt.Errorf("redaction tenant %q must be a top-level directory name", tenant)
+		}
+		r.tenants[tenant] = true
+	}
+	return r, nil
+}
+
+// Pseudonym returns the stable pseudonym of name. Spellings of the same
+// name in different Unicode normalization forms share it.
+func (r *Redactor) Pseudonym(name string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(fileops.NormalizeName(fileops.NormalizeNFC, name)))
+	return Prefix + hex.EncodeToString(mac.Sum(nil))[:pseudonymHex]
+}
+
+// sensitive reports whether the path component name matches a pattern
+func (r *Redactor) sensitive(name string) bool {
+	if pseudonymRe.MatchString(name) {
+		return false
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text redacts every sensitive name in s, which may be a path, a name or a
+// free-form message such as an error. Names are looked for between path
+// separators, whitespace, quotes and the punctuation around them, so names
+// containing spaces are found too, and end before a colon and a space, where
+// error messages go on. The longest matching name wins. Every component
+// after a tenant directory is redacted.
+func (r *Redactor) Text(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+
+	var b strings.Builder
+	below := false // the previous component was a tenant directory or below it
+	for i := 0; i < len(s); {
+		if !startsName(s, i) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		end := componentEnd(s, i)
+		if below && i > 0 && s[i-1] == '/' {
+			if name := trimName(s[i:end]); name != "" {
+				b.WriteString(r.Pseudonym(name))
+				i += len(name)
+				below = i < len(s) && s[i] == '/'
+				continue
+			}
+		}
+
+		if n := r.match(trimMessage(s[i:end])); n > 0 {
+			b.WriteString(r.Pseudonym(s[i : i+n]))
+			i += n
+			below = false
+			continue
+		}
+
+		// Tenants are whole components followed by a separator
+		word := s[i:wordEnd(s, i)]
+		below = r.tenants[word] && i+len(word) < len(s) && s[i+len(word)] == '/'
+		b.WriteString(word)
+		i += len(word)
+	}
+	return b.String()
+}
+
+// match returns the length of the longest sensitive name at the start of
+// span that ends at a boundary, or 0
+func (r *Redactor) match(span string) int {
+	for n := len(span); n > 0; n-- {
+		if n < len(span) && !isBoundary(span[n]) && span[n] != ':' {
+			continue
+		}
+		if r.sensitive(span[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// startsName reports whether a name may start at s[i]
+func startsName(s string, i int) bool {
+	if isBoundary(s[i]) || s[i] == ':' {
+		return false
+	}
+	return i == 0 || isBoundary(s[i-1]) || s[i-1] == ':'
+}
+
+// componentEnd returns where the path component starting at i ends: at the
+// next separator, quote, bracket or newline
+func componentEnd(s string, i int) int {
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '/', '\\', '"', '\'', '`', '\n', '(', ')', '[', ']', '{', '}', '<', '>':
+			return j
+		}
+	}
+	return len(s)
+}
+
+// wordEnd returns where the word starting at i ends
+func wordEnd(s string, i int) int {
+	for j := i; j < len(s); j++ {
+		if isBoundary(s[j]) || s[j] == ':' {
+			return j
+		}
+	}
+	return len(s)
+}
+
+// trimMessage cuts component at a colon followed by a space, where error
+// messages continue after a path
+func trimMessage(component string) string {
+	if i := strings.Index(component, ": "); i >= 0 {
+		return component[:i]
+	}
+	return component
+}
+
+// trimName returns the name of a component below a tenant
+func trimName(component string) string {
+	return strings.TrimRight(trimMessage(component), " \t,;:")
+}
+
+// isBoundary reports whether c separates names
+func isBoundary(c byte) bool {
+	switch c {
+	case '/', '\\', ' ', '\t', '\n', '\r', '"', '\'', '`', '(', ')', '[', ']', '{', '}', ',', ';', '=', '<', '>':
+		return true
+	}
+	return false
+}
+
+// Writer returns a writer redacting each write to w, for loggers outside
+// slog. Each write must hold whole lines, as the log package's do.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	if r == nil {
+		return w
+	}
+	return writer{r: r, w: w}
+}
+
+type writer struct {
+	r *Redactor
+	w io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.Text(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
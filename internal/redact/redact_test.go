@@ -0,0 +1,136 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func newTestRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	r, err := New([]string{"Jane*", "*_patient_*"}, []string{"clinic"}, testKey)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return r
+}
+
+func TestText(t *testing.T) {
+	r := newTestRedactor(t)
+	jane := r.Pseudonym("Jane Doe.csv")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain name", "Jane Doe.csv", jane},
+		{"path", "/in/acme/Jane Doe.csv", "/in/acme/" + jane},
+		{"directory", "/in/Jane/2024/a.csv", "/in/" + r.Pseudonym("Jane") + "/2024/a.csv"},
+		{"error message", `stat "/in/Jane Doe.csv": no such file`, `stat "/in/` + jane + `": no such file`},
+		{"error without quotes", "open /in/Jane Doe.csv: permission denied", "open /in/" + jane + ": permission denied"},
+		{"inner pattern", "/in/x_patient_y.csv", "/in/" + r.Pseudonym("x_patient_y.csv")},
+		{"tenant", "/in/clinic/smith/report 1.csv", "/in/clinic/" + r.Pseudonym("smith") + "/" + r.Pseudonym("report 1.csv")},
+		{"tenant in error", "move /in/clinic/anna.csv: disk full", "move /in/clinic/" + r.Pseudonym("anna.csv") + ": disk full"},
+		{"tenant without children", "tenant clinic is busy", "tenant clinic is busy"},
+		{"nothing sensitive", "/in/acme/data.csv", "/in/acme/data.csv"},
+		{"pseudonym kept", "/in/" + jane, "/in/" + jane},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Text(tt.in); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPseudonym_Stable(t *testing.T) {
+	r := newTestRedactor(t)
+	same, _ := New(nil, nil, testKey)
+	other, _ := New(nil, nil, []byte("fedcba9876543210"))
+
+	p := r.Pseudonym("Jane.csv")
+	if !strings.HasPrefix(p, Prefix) || len(p) != len(Prefix)+pseudonymHex {
+		t.Errorf("Pseudonym() = %q, want %s and %d hex digits", p, Prefix, pseudonymHex)
+	}
+	if same.Pseudonym("Jane.csv") != p {
+		t.Error("the same key should give the same pseudonym")
+	}
+	if other.Pseudonym("Jane.csv") == p {
+		t.Error("another key should give another pseudonym")
+	}
+	if r.Pseudonym("Jos\u00e9.csv") != r.Pseudonym("Jose\u0301.csv") {
+		t.Error("normalization forms of a name should share a pseudonym")
+	}
+}
+
+func TestText_NilRedactor(t *testing.T) {
+	var r *Redactor
+	if got := r.Text("/in/Jane.csv"); got != "/in/Jane.csv" {
+		t.Errorf("nil Text() = %q", got)
+	}
+	var buf bytes.Buffer
+	if r.Writer(&buf) != &buf {
+		t.Error("nil Writer() should return the writer unchanged")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		tenants  []string
+		key      []byte
+	}{
+		{"short key", nil, nil, []byte("short")},
+		{"pattern with separator", []string{"a/*"}, nil, testKey},
+		{"nested tenant", nil, []string{"a/b"}, testKey},
+		{"empty tenant", nil, []string{""}, testKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.patterns, tt.tenants, tt.key); err == nil {
+				t.Error("New() should fail")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	if r, err := Load(nil); r != nil || err != nil {
+		t.Errorf("Load(nil) = %v, %v, want nil", r, err)
+	}
+	if _, err := Load(&config.RedactionConfig{Patterns: []string{"*"}}); err == nil {
+		t.Error("Load() without a key file should fail")
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, append(testKey, '\n'), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	r, err := Load(&config.RedactionConfig{Patterns: []string{"Jane*"}, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := newTestRedactor(t).Pseudonym("Jane.csv"); r.Pseudonym("Jane.csv") != want {
+		t.Error("a trailing newline in the key file should be ignored")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	r := newTestRedactor(t)
+	var buf bytes.Buffer
+	log.New(r.Writer(&buf), "", 0).Printf("record not found WHERE dest_path = %q", "/wh/Jane.csv")
+	if strings.Contains(buf.String(), "Jane") || !strings.Contains(buf.String(), r.Pseudonym("Jane.csv")) {
+		t.Errorf("written = %q, want the pseudonym", buf.String())
+	}
+}
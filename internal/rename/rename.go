@@ -0,0 +1,136 @@
+// Package rename rewrites the warehouse path of ingested files with the
+// ordered regular expression rules of the config file
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// ErrInvalidResult is returned by Apply when a rule rewrites a path to one
+// that does not stay inside the warehouse
+var ErrInvalidResult = errors.New("rename rule produced an invalid path")
+
+// Renamer applies rename rules to slash-separated paths relative to the
+// input directory. A nil Renamer leaves every path as is.
+type Renamer struct {
+	rules []rule
+}
+
+type rule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// New compiles rules, rejecting patterns that do not compile and
+// replacements that reference a group the pattern lacks. It returns nil
+// when there are no rules.
+func New(rules []config.RenameRule) (*Renamer, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Renamer{rules: make([]rule, 0, len(rules))}
+	for i, cfg := range rules {
+		if cfg.Match == "" {
+			return nil, fmt.Errorf("rename rule %d: missing match", i+1)
+		}
+		re, err := regexp.Compile(cfg.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rename rule %d: %w", i+1, err)
+		}
+		if err := checkReplacement(re, cfg.Replace); err != nil {
+			return nil, fmt.Errorf("rename rule %d: %w", i+1, err)
+		}
+		r.rules = append(r.rules, rule{re: re, replace: cfg.Replace})
+	}
+	return r, nil
+}
+
+// Apply rewrites relPath with the first rule whose pattern matches it: the
+// leftmost match is replaced by the expanded replacement, in which $name,
+// ${name} and $1 refer to capture groups and $$ is a literal $. It returns
+// the new path and the number of the rule applied, counting from 1, or
+// relPath and 0 when no rule matches.
+func (r *Renamer) Apply(relPath string) (string, int, error) {
+	if r == nil {
+		return relPath, 0, nil
+	}
+	for i, rl := range r.rules {
+		m := rl.re.FindStringSubmatchIndex(relPath)
+		if m == nil {
+			continue
+		}
+		renamed := relPath[:m[0]] + string(rl.re.ExpandString(nil, rl.replace, relPath, m)) + relPath[m[1]:]
+		cleaned := path.Clean(renamed)
+		if renamed == "" || strings.HasSuffix(renamed, "/") || strings.HasPrefix(cleaned, "/") ||
+			cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return relPath, i + 1, fmt.Errorf("%w: rule %d rewrote %q to %q", ErrInvalidResult, i+1, relPath, renamed)
+		}
+		return cleaned, i + 1, nil
+	}
+	return relPath, 0, nil
+}
+
+// checkReplacement rejects a replacement referencing a group re does not
+// have, including the ambiguous $1x, which Go reads as the group named 1x
+// rather than group 1 followed by x, and a $ starting no reference
+func checkReplacement(re *regexp.Regexp, replace string) error {
+	names := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			names[name] = true
+		}
+	}
+
+	for i := 0; i < len(replace); i++ {
+		if replace[i] != '$' {
+			continue
+		}
+		at := i
+		i++
+		if i < len(replace) && replace[i] == '$' {
+			continue
+		}
+		brace := i < len(replace) && replace[i] == '{'
+		if brace {
+			i++
+		}
+		start := i
+		for i < len(replace) && isNameByte(replace[i]) {
+			i++
+		}
+		name := replace[start:i]
+		if name == "" || brace && (i >= len(replace) || replace[i] != '}') {
+			return fmt.Errorf("malformed group reference at byte %d of replacement %q; write $$ for a literal $", at, replace)
+		}
+		if !brace {
+			i--
+		}
+
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				return fmt.Errorf("replacement %q references group %d but the pattern has %d", replace, n, re.NumSubexp())
+			}
+			continue
+		}
+		if !names[name] {
+			if name[0] >= '0' && name[0] <= '9' {
+				return fmt.Errorf("replacement %q references group %q; write ${%s} when a group number is followed by a name character",
+					replace, name, name[:len(name)-len(strings.TrimLeft(name, "0123456789"))])
+			}
+			return fmt.Errorf("replacement %q references group %q the pattern does not name", replace, name)
+		}
+	}
+	return nil
+}
+
+// isNameByte reports whether b may appear in a group reference
+func isNameByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
@@ -0,0 +1,111 @@
+package rename
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+func TestNew_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.RenameRule
+		wantErr string
+	}{
+		{"valid named groups", config.RenameRule{Match: `(?P<env>[A-Z]+)-(?P<day>\d+)`, Replace: "${env}_${day}"}, ""},
+		{"valid numbered groups", config.RenameRule{Match: `(\w+)-(\d+)`, Replace: "$2-$1"}, ""},
+		{"literal dollar", config.RenameRule{Match: `price`, Replace: "$$price"}, ""},
+		{"empty replacement", config.RenameRule{Match: ` \(\d+\)`, Replace: ""}, ""},
+		{"missing match", config.RenameRule{Replace: "x"}, "missing match"},
+		{"bad pattern", config.RenameRule{Match: `(unclosed`, Replace: "x"}, "missing closing )"},
+		{"missing named group", config.RenameRule{Match: `(?P<env>\w+)`, Replace: "${day}"}, `group "day"`},
+		{"group number too high", config.RenameRule{Match: `(\w+)`, Replace: "$2"}, "references group 2 but the pattern has 1"},
+		{"ambiguous number", config.RenameRule{Match: `(\w+)`, Replace: "$1x"}, "write ${1}"},
+		{"unclosed brace", config.RenameRule{Match: `(\w+)`, Replace: "${1"}, "malformed"},
+		{"lone dollar", config.RenameRule{Match: `(\w+)`, Replace: "a$"}, "malformed"},
+		{"dollar before punctuation", config.RenameRule{Match: `(\w+)`, Replace: "$-1"}, "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]config.RenameRule{tt.rule})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	r, err := New([]config.RenameRule{
+		{
+			Match:   `EXPORT-(?P<env>PROD|TEST)-(?P<y>\d{4})(?P<m>\d{2})(?P<d>\d{2})-[^/]*\.csv$`,
+			Replace: "${env}_${y}-${m}-${d}.csv",
+		},
+		{Match: ` \(\d+\)(\.[^./]+)$`, Replace: "$1"},
+		{Match: `^drop/`, Replace: ""},
+		{Match: `^escape/(?P<rest>.*)$`, Replace: "../${rest}"},
+		{Match: `^flatten/(.*)$`, Replace: "/$1"},
+		{Match: `^tree/(.*)$`, Replace: "$1/"},
+		{Match: `^dots/(.*)$`, Replace: "a/./b//$1"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		relPath  string
+		want     string
+		wantRule int
+		wantErr  bool
+	}{
+		{"producer export", "EXPORT-PROD-20240615-FINAL-v2 (1).csv", "PROD_2024-06-15.csv", 1, false},
+		{"directories kept", "tenant/EXPORT-TEST-20240101-x.csv", "tenant/TEST_2024-01-01.csv", 1, false},
+		{"copy suffix dropped", "report (2).csv", "report.csv", 2, false},
+		{"no match", "orders.csv", "orders.csv", 0, false},
+		{"anchored rule", "a (1).csv/b (2).csv", "a (1).csv/b.csv", 2, false},
+		{"unicode untouched", "café (3).csv", "café.csv", 2, false},
+		{"strip a prefix", "drop/a.csv", "a.csv", 3, false},
+		{"leaving the warehouse", "escape/a.csv", "", 4, true},
+		{"absolute result", "flatten/a.csv", "", 5, true},
+		{"directory result", "tree/a.csv", "", 6, true},
+		{"cleaned result", "dots/c.csv", "a/b/c.csv", 7, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rule, err := r.Apply(tt.relPath)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidResult) {
+					t.Errorf("Apply(%q) error = %v, want ErrInvalidResult", tt.relPath, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply(%q) error = %v", tt.relPath, err)
+			}
+			if got != tt.want || rule != tt.wantRule {
+				t.Errorf("Apply(%q) = %q, rule %d, want %q, rule %d", tt.relPath, got, rule, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestApply_NilRenamer(t *testing.T) {
+	r, err := New(nil)
+	if err != nil || r != nil {
+		t.Fatalf("New(nil) = %v, %v, want nil", r, err)
+	}
+	if got, rule, err := r.Apply("a/b.csv"); got != "a/b.csv" || rule != 0 || err != nil {
+		t.Errorf("Apply() = %q, %d, %v, want the path unchanged", got, rule, err)
+	}
+}
@@ -0,0 +1,186 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears bounds how far Next looks for a matching time, so that
+// expressions that never match, such as 30 February, fail instead of
+// looping
+const cronSearchYears = 5
+
+// Schedule is a five-field cron expression, minute hour day-of-month month
+// day-of-week, evaluated in a time zone. Fields take *, numbers, ranges
+// (1-5), lists (1,15) and steps (*/15, 8-18/2); day-of-week counts from
+// Sunday as 0, with 7 accepted for Sunday too. As in cron, when both day
+// fields are restricted a day matching either one matches.
+type Schedule struct {
+	expr   string
+	loc    *time.Location
+	minute field
+	hour   field
+	dom    field
+	month  field
+	dow    field
+	// anyDOM and anyDOW record whether a day field was *, in which case
+	// only the other one restricts the day
+	anyDOM bool
+	anyDOW bool
+}
+
+// field is the set of values a cron field matches, as a bit per value
+type field uint64
+
+func (f field) has(v int) bool { return f&(1<<uint(v)) != 0 }
+
+// ParseSchedule parses a cron expression evaluated in loc
+func ParseSchedule(expr string, loc *time.Location) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(parts))
+	}
+	s := &Schedule{expr: expr, loc: loc, anyDOM: parts[2] == "*", anyDOW: parts[4] == "*"}
+	fields := []struct {
+		dst      *field
+		name     string
+		min, max int
+	}{
+		{&s.minute, "minute", 0, 59},
+		{&s.hour, "hour", 0, 23},
+		{&s.dom, "day of month", 1, 31},
+		{&s.month, "month", 1, 12},
+		{&s.dow, "day of week", 0, 7},
+	}
+	for i, f := range fields {
+		var err error
+		if *f.dst, err = parseField(parts[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("schedule %q: %s: %w", expr, f.name, err)
+		}
+	}
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseField parses one comma-separated cron field of values in [lo, hi]
+func parseField(spec string, lo, hi int) (field, error) {
+	var f field
+	for _, item := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepSpec)
+			}
+			step = n
+		}
+
+		first, last := lo, hi
+		switch {
+		case rangeSpec == "*":
+		case strings.Contains(rangeSpec, "-"):
+			a, b, _ := strings.Cut(rangeSpec, "-")
+			var err error
+			if first, err = parseValue(a, lo, hi); err != nil {
+				return 0, err
+			}
+			if last, err = parseValue(b, lo, hi); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("invalid range %q", rangeSpec)
+			}
+		default:
+			v, err := parseValue(rangeSpec, lo, hi)
+			if err != nil {
+				return 0, err
+			}
+			first = v
+			// A single value with a step runs to the end, as in 5/15
+			last = v
+			if hasStep {
+				last = hi
+			}
+		}
+		for v := first; v <= last; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func parseValue(s string, lo, hi int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("value %q out of range %d-%d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// String returns the expression the schedule was parsed from
+func (s *Schedule) String() string { return s.expr }
+
+// Location returns the time zone the schedule is evaluated in
+func (s *Schedule) Location() *time.Location { return s.loc }
+
+// dayMatches reports whether the day of t matches the day fields
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom.has(t.Day()), s.dow.has(int(t.Weekday()))
+	switch {
+	case s.anyDOM && s.anyDOW:
+		return true
+	case s.anyDOM:
+		return dow
+	case s.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first time strictly after after that the schedule
+// fires, or the zero time when it fires in none of the next years. Wall
+// clock times skipped by a daylight saving change never fire.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !s.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc)
+		case !s.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc)
+		case !s.minute.has(t.Minute()):
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Prev returns the last time strictly before before that the schedule
+// fired, or the zero time when it fired in none of the past years
+func (s *Schedule) Prev(before time.Time) time.Time {
+	for _, span := range []time.Duration{time.Hour, 24 * time.Hour, 32 * 24 * time.Hour, 367 * 24 * time.Hour, cronSearchYears * 366 * 24 * time.Hour} {
+		t := s.Next(before.Add(-span - time.Minute))
+		if t.IsZero() || !t.Before(before) {
+			continue
+		}
+		for {
+			next := s.Next(t)
+			if next.IsZero() || !next.Before(before) {
+				return t
+			}
+			t = next
+		}
+	}
+	return time.Time{}
+}
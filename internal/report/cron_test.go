@@ -0,0 +1,108 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"0 7 * *",
+		"0 7 * * * *",
+		"60 7 * * *",
+		"0 24 * * *",
+		"0 7 0 * *",
+		"0 7 * 13 *",
+		"0 7 * * 8",
+		"0 7-5 * * *",
+		"*/0 * * * *",
+		"a 7 * * *",
+	} {
+		if _, err := ParseSchedule(expr, time.UTC); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// A Monday
+	base := time.Date(2024, 6, 10, 6, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"0 7 * * *", base, time.Date(2024, 6, 10, 7, 0, 0, 0, time.UTC)},
+		// Strictly after: a firing at after is skipped
+		{"0 7 * * *", base.Add(30 * time.Minute), time.Date(2024, 6, 11, 7, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", base.Add(time.Second), time.Date(2024, 6, 10, 6, 45, 0, 0, time.UTC)},
+		{"5/20 8-18/2 * * *", base, time.Date(2024, 6, 10, 8, 5, 0, 0, time.UTC)},
+		{"0 7 * * 1-5", base.AddDate(0, 0, 4).Add(time.Hour), time.Date(2024, 6, 17, 7, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", base, time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1,7 *", base, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 15th or a Friday
+		{"0 0 15 * 5", base, time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 5", base.AddDate(0, 0, 4), time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)},
+		// Never
+		{"0 0 30 2 *", base, time.Time{}},
+	} {
+		s, err := ParseSchedule(tt.expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) error = %v", tt.expr, err)
+		}
+		if got := s.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.expr, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestSchedule_Prev(t *testing.T) {
+	base := time.Date(2024, 6, 10, 6, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		expr   string
+		before time.Time
+		want   time.Time
+	}{
+		{"0 7 * * *", base, time.Date(2024, 6, 9, 7, 0, 0, 0, time.UTC)},
+		// Strictly before: a firing at before is skipped
+		{"0 7 * * *", time.Date(2024, 6, 10, 7, 0, 0, 0, time.UTC), time.Date(2024, 6, 9, 7, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", base, time.Date(2024, 6, 10, 6, 15, 0, 0, time.UTC)},
+		{"0 0 1 * *", base, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", base, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", base, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+	} {
+		s, err := ParseSchedule(tt.expr, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) error = %v", tt.expr, err)
+		}
+		if got := s.Prev(tt.before); !got.Equal(tt.want) {
+			t.Errorf("%q.Prev(%v) = %v, want %v", tt.expr, tt.before, got, tt.want)
+		}
+	}
+}
+
+func TestSchedule_TimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	s, err := ParseSchedule("0 7 * * *", loc)
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	// 7:00 in Berlin is 5:00 UTC in summer and 6:00 UTC in winter
+	if got, want := s.Next(time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)), time.Date(2024, 6, 10, 5, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next() in summer = %v, want %v", got.UTC(), want)
+	}
+	if got, want := s.Next(time.Date(2024, 12, 10, 0, 0, 0, 0, time.UTC)), time.Date(2024, 12, 10, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next() in winter = %v, want %v", got.UTC(), want)
+	}
+	// 2:30 does not exist on the day clocks spring forward
+	s, _ = ParseSchedule("30 2 * * *", loc)
+	if got, want := s.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, loc)), time.Date(2024, 4, 1, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Next() across the gap = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,255 @@
+// Package report builds the ingestion report stakeholders receive without
+// access to dashboards: what was ingested, duplicated, failed and
+// quarantined in a period, and what was still waiting when it was built
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Report is the ingestion report of [From, To)
+type Report struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generated_at"`
+
+	Ingested      int64 `json:"ingested"`
+	IngestedBytes int64 `json:"ingested_bytes"`
+	Duplicates    int64 `json:"duplicates"`
+	// DuplicateBytes is the size of the duplicates skipped
+	DuplicateBytes int64 `json:"duplicate_bytes"`
+	// Failures counts the failed attempts, each retried file once per try
+	Failures    int64 `json:"failures"`
+	Quarantined int64 `json:"quarantined"`
+	// Reasons counts the quarantined files by reason, most frequent first
+	Reasons []Reason `json:"quarantine_reasons"`
+	// Sources are the top sources by ingested bytes
+	Sources []storage.SourceIngestion `json:"sources"`
+	// Backlog is nil when the report was built outside the daemon
+	Backlog *Backlog `json:"backlog"`
+}
+
+// Reason counts the files quarantined for one reason
+type Reason struct {
+	Reason string `json:"reason"`
+	Files  int64  `json:"files"`
+}
+
+// Backlog is what the daemon was still waiting on when the report was built
+type Backlog struct {
+	// Tracked counts the files, sets and batches being watched
+	Tracked int `json:"tracked"`
+	// Ready counts those ready to be processed
+	Ready int `json:"ready"`
+	// Oldest is the earliest write among them, zero when unknown
+	Oldest time.Time `json:"oldest,omitzero"`
+	// WatermarkLag is how far the ingestion watermark trailed the clock
+	WatermarkLag time.Duration `json:"watermark_lag_ns"`
+}
+
+// Options configures Build
+type Options struct {
+	// QuarantinePath is scanned for the reasons files were quarantined
+	QuarantinePath string
+	// Top bounds the sources listed
+	Top int
+	// Backlog returns the current backlog, nil outside the daemon
+	Backlog func() *Backlog
+	// Now stamps GeneratedAt, time.Now when nil
+	Now func() time.Time
+}
+
+// Build aggregates the ingestion of [from, to)
+func Build(store storage.Reader, from, to time.Time, opts Options) (*Report, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("report period %s to %s is empty", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = config.DefaultReportTop
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	ingested, err := store.IngestionStats(from, to, top)
+	if err != nil {
+		return nil, err
+	}
+	dups, err := store.DuplicateStats(from, to, 1)
+	if err != nil {
+		return nil, err
+	}
+	reasons, err := quarantineReasons(opts.QuarantinePath, ingested.From, ingested.To)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		From:           ingested.From,
+		To:             ingested.To,
+		GeneratedAt:    now().UTC(),
+		Ingested:       ingested.Files,
+		IngestedBytes:  ingested.Bytes,
+		Duplicates:     dups.Duplicates,
+		DuplicateBytes: dups.Bytes,
+		Reasons:        reasons,
+		Sources:        ingested.Sources,
+	}
+	for outcome, attempts := range ingested.Outcomes {
+		switch outcome {
+		case status.Failed:
+			report.Failures += attempts
+		case status.Ingested, status.Duplicate, status.Quarantined:
+			// Counted from the records, duplicates and quarantine instead
+		case status.DuplicateSummary, status.Vanished, status.Adopted, status.Suspect, status.MissingRestored, status.Superseded:
+			// Not reported
+		}
+	}
+	for _, r := range reasons {
+		report.Quarantined += r.Files
+	}
+	if opts.Backlog != nil {
+		report.Backlog = opts.Backlog()
+	}
+	return report, nil
+}
+
+// quarantineRecord is the subset of the processor's reason files read here
+type quarantineRecord struct {
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// quarantineReasons counts the files quarantined in [from, to) below root
+// by the reason recorded next to them. Files released from quarantine
+// since are no longer counted.
+func quarantineReasons(root string, from, to time.Time) ([]Reason, error) {
+	reasons := []Reason{}
+	if root == "" {
+		return reasons, nil
+	}
+	counts := make(map[string]int64)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || (d.Name() != "reason.json" && !strings.HasSuffix(d.Name(), ".reason.json")) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var record quarantineRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil
+		}
+		if at := record.QuarantinedAt; !at.Before(from) && at.Before(to) {
+			counts[record.Reason]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan quarantine %s: %w", root, err)
+	}
+	for reason, n := range counts {
+		reasons = append(reasons, Reason{Reason: reason, Files: n})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Files != reasons[j].Files {
+			return reasons[i].Files > reasons[j].Files
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	return reasons, nil
+}
+
+// Redacted returns a copy of the report with the sources passed through fn
+func (r *Report) Redacted(fn func(string) string) *Report {
+	c := *r
+	c.Sources = make([]storage.SourceIngestion, len(r.Sources))
+	for i, s := range r.Sources {
+		s.Source = fn(s.Source)
+		c.Sources[i] = s
+	}
+	return &c
+}
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
+// tableTemplate renders the report for people to read; its tab-separated
+// columns are aligned by WriteTable
+var tableTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`Ingestion report {{time .From}} to {{time .To}}
+Generated:	{{time .GeneratedAt}}
+Ingested:	{{.Ingested}} files	{{.IngestedBytes}} bytes
+Duplicates:	{{.Duplicates}} files	{{.DuplicateBytes}} bytes
+Failed attempts:	{{.Failures}}
+Quarantined:	{{.Quarantined}} files
+{{- if .Reasons}}
+
+Quarantine reasons
+REASON	FILES
+{{- range .Reasons}}
+{{.Reason}}	{{.Files}}
+{{- end}}
+{{- end}}
+
+Top sources by ingested bytes
+SOURCE	FILES	BYTES
+{{- range .Sources}}
+{{.Source}}	{{.Files}}	{{.Bytes}}
+{{- end}}
+
+Backlog at report time
+{{- with .Backlog}}
+Tracked:	{{.Tracked}} ({{.Ready}} ready)
+{{- if not .Oldest.IsZero}}
+Oldest write:	{{time .Oldest}}
+{{- end}}
+Watermark lag:	{{.WatermarkLag}}
+{{- else}}
+Unknown:	built outside the daemon
+{{- end}}
+`))
+
+// WriteTable writes the report as aligned text
+func WriteTable(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if err := tableTemplate.Execute(tw, report); err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openStore opens a migrated state database at path
+func openStore(t *testing.T, path string) *storage.Storage {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// dayStart starts the day the synthetic data is reported for
+var dayStart = time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// ingest records a file of size bytes from source ingested at at
+func ingest(t *testing.T, store *storage.Storage, source string, size int64, at time.Time) *storage.File {
+	t.Helper()
+	sha := fmt.Sprintf("sha-%s-%d", source, at.UnixNano())
+	rel := fmt.Sprintf("%s/%d.csv", source, at.UnixNano())
+	_, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256: sha, Size: size, Status: status.Ingested,
+		DestPath: "/warehouse/" + rel, ProcessedAt: at, RelPath: rel,
+	})
+	if err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+	file, _ := store.FindBySHA256(sha)
+	return file
+}
+
+// quarantine writes the reason file of a file quarantined at at
+func quarantine(t *testing.T, root, name, reason string, at time.Time) {
+	t.Helper()
+	data, _ := json.Marshal(quarantineRecord{Reason: reason, QuarantinedAt: at})
+	path := filepath.Join(root, name+".reason.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	quarantineDir := t.TempDir()
+	from, to := dayStart, dayStart.AddDate(0, 0, 1)
+
+	original := ingest(t, store, "acme", 100, from.Add(time.Hour))
+	ingest(t, store, "acme", 300, from.Add(2*time.Hour))
+	ingest(t, store, "globex", 1000, from.Add(3*time.Hour))
+	ingest(t, store, "initech", 50, from.Add(4*time.Hour))
+	// The day before
+	ingest(t, store, "globex", 5000, from.Add(-time.Hour))
+
+	for range 3 {
+		err := store.RecordDuplicate(storage.DuplicateRecord{
+			DetectedAt: from.Add(5 * time.Hour), Source: "acme", SHA256: original.SHA256,
+			Size: 100, Dedup: "hash", Original: original,
+		})
+		if err != nil {
+			t.Fatalf("RecordDuplicate() error = %v", err)
+		}
+	}
+	err := store.RecordAttempts([]storage.Attempt{
+		{Path: "/in/a.csv", StartedAt: from, EndedAt: from.Add(time.Hour), Outcome: status.Failed},
+		{Path: "/in/a.csv", StartedAt: from, EndedAt: from.Add(2 * time.Hour), Outcome: status.Failed},
+		{Path: "/in/old.csv", StartedAt: from, EndedAt: from.Add(-time.Hour), Outcome: status.Failed},
+	})
+	if err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+
+	quarantine(t, quarantineDir, "acme/bad.csv", "checksum mismatch", from.Add(time.Hour))
+	quarantine(t, quarantineDir, "acme/worse.csv", "checksum mismatch", from.Add(2*time.Hour))
+	quarantine(t, quarantineDir, "globex/empty.csv", "empty file", from.Add(3*time.Hour))
+	quarantine(t, quarantineDir, "globex/old.csv", "empty file", from.Add(-time.Hour))
+
+	generated := to.Add(7 * time.Hour)
+	report, err := Build(store, from, to, Options{
+		QuarantinePath: quarantineDir,
+		Top:            2,
+		Backlog:        func() *Backlog { return &Backlog{Tracked: 4, Ready: 1, WatermarkLag: time.Minute} },
+		Now:            func() time.Time { return generated },
+	})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if report.Ingested != 4 || report.IngestedBytes != 1450 {
+		t.Errorf("ingested = %d files, %d bytes; want 4, 1450", report.Ingested, report.IngestedBytes)
+	}
+	if report.Duplicates != 3 || report.DuplicateBytes != 300 {
+		t.Errorf("duplicates = %d files, %d bytes; want 3, 300", report.Duplicates, report.DuplicateBytes)
+	}
+	if report.Failures != 2 {
+		t.Errorf("Failures = %d, want 2", report.Failures)
+	}
+	if report.Quarantined != 3 || len(report.Reasons) != 2 ||
+		report.Reasons[0] != (Reason{Reason: "checksum mismatch", Files: 2}) ||
+		report.Reasons[1] != (Reason{Reason: "empty file", Files: 1}) {
+		t.Errorf("quarantined = %d, reasons = %+v; want 3 files for two reasons", report.Quarantined, report.Reasons)
+	}
+	if len(report.Sources) != 2 || report.Sources[0].Source != "globex" || report.Sources[1].Source != "acme" ||
+		report.Sources[1].Files != 2 || report.Sources[1].Bytes != 400 {
+		t.Errorf("Sources = %+v, want globex then acme", report.Sources)
+	}
+	if report.Backlog == nil || report.Backlog.Tracked != 4 || !report.GeneratedAt.Equal(generated) {
+		t.Errorf("report = %+v, want the backlog and generation time", report)
+	}
+}
+
+func TestBuild_EmptyPeriod(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	report, err := Build(store, dayStart, dayStart.Add(time.Hour), Options{QuarantinePath: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if report.Ingested != 0 || report.Quarantined != 0 || report.Sources == nil || report.Reasons == nil {
+		t.Errorf("report = %+v, want zeros and empty lists", report)
+	}
+	if _, err := Build(store, dayStart, dayStart, Options{}); err == nil {
+		t.Error("Build() of an empty period succeeded, want an error")
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	report := &Report{
+		From: dayStart, To: dayStart.AddDate(0, 0, 1), GeneratedAt: dayStart.AddDate(0, 0, 1),
+		Ingested: 4, IngestedBytes: 1450, Failures: 2, Quarantined: 1,
+		Reasons: []Reason{{Reason: "empty file", Files: 1}},
+		Sources: []storage.SourceIngestion{{Source: "globex", Files: 1, Bytes: 1000}},
+	}
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, report.Redacted(strings.ToUpper)); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"Ingested:", "1450 bytes", "empty file", "GLOBEX", "built outside the daemon"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table lacks %q:\n%s", want, out)
+		}
+	}
+	if report.Sources[0].Source != "globex" {
+		t.Error("Redacted() changed the original report")
+	}
+
+	report.Backlog = &Backlog{Tracked: 3, Ready: 2, Oldest: dayStart, WatermarkLag: time.Minute}
+	buf.Reset()
+	if err := WriteTable(&buf, report); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	if out := buf.String(); !strings.Contains(out, "3 (2 ready)") || !strings.Contains(out, "1m0s") {
+		t.Errorf("table lacks the backlog:\n%s", out)
+	}
+}
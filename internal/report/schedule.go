@@ -0,0 +1,182 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// EventKind is the outbox kind of a scheduled ingestion report
+const EventKind = "ingestion_report"
+
+// checkInterval bounds how late a due report is sent
+const checkInterval = time.Minute
+
+// fileLayout names the report files after their scheduled time
+const fileLayout = "2006-01-02T1504"
+
+// Event is the notification payload of a scheduled report
+type Event struct {
+	Kind   string    `json:"kind"`
+	At     time.Time `json:"at"`
+	Report *Report   `json:"report"`
+}
+
+// Scheduler delivers the ingestion report of the period between two
+// firings of its schedule each time the schedule fires. When the last one
+// was sent is kept in the state database, so restarts do not repeat it and
+// a firing missed while the daemon was down is caught up on start.
+type Scheduler struct {
+	store    *storage.Storage
+	schedule *Schedule
+	opts     Options
+	dir      string
+	redact   func(string) string
+	notify   func()
+	now      func() time.Time
+}
+
+// NewScheduler returns a scheduler of reports built with opts. Reports are
+// written to dir as JSON and as a text table when dir is set, and through
+// the outbox with their sources passed through redact when notify is set;
+// notify is called after a report is written to the outbox.
+func NewScheduler(store *storage.Storage, schedule *Schedule, opts Options, dir string, redact func(string) string, notify func()) *Scheduler {
+	return &Scheduler{
+		store:    store,
+		schedule: schedule,
+		opts:     opts,
+		dir:      dir,
+		redact:   redact,
+		notify:   notify,
+		now:      time.Now,
+	}
+}
+
+// Run sends reports as they fall due until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		supervisor.Beat(ctx)
+		if _, err := s.Emit(); err != nil {
+			slog.Error("failed to send ingestion report", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Emit delivers the report of the last firing of the schedule unless it
+// was already sent, covering the period since the firing before it. It
+// reports whether it delivered one.
+func (s *Scheduler) Emit() (bool, error) {
+	now := s.now()
+	// The last firing at or before now; firings fall on whole minutes
+	at := s.schedule.Prev(now.Add(time.Second))
+	if at.IsZero() {
+		return false, nil
+	}
+	last, err := s.store.LastReport()
+	if err != nil {
+		return false, err
+	}
+	if !last.Before(at) {
+		return false, nil
+	}
+	from := s.schedule.Prev(at)
+	if from.IsZero() {
+		from = at.Add(-24 * time.Hour)
+	}
+
+	opts := s.opts
+	opts.Now = s.now
+	report, err := Build(s.store, from, at, opts)
+	if err != nil {
+		return false, err
+	}
+	if s.dir != "" {
+		if err := s.write(report, at); err != nil {
+			return false, err
+		}
+	}
+	if s.notify == nil {
+		err = s.store.RecordReport(at)
+	} else {
+		err = s.enqueue(report, at)
+	}
+	if err != nil {
+		return false, err
+	}
+	slog.Info("ingestion report sent",
+		"from", report.From,
+		"to", report.To,
+		"ingested", report.Ingested,
+		"failures", report.Failures,
+		"quarantined", report.Quarantined,
+	)
+	if s.notify != nil {
+		s.notify()
+	}
+	return true, nil
+}
+
+// enqueue writes the redacted report to the outbox
+func (s *Scheduler) enqueue(report *Report, at time.Time) error {
+	payload, err := json.Marshal(Event{Kind: EventKind, At: at.UTC(), Report: report.Redacted(s.redact)})
+	if err != nil {
+		return fmt.Errorf("encode ingestion report: %w", err)
+	}
+	return s.store.EnqueueReport(&storage.OutboxMessage{
+		CreatedAt: s.now().UTC(),
+		Kind:      EventKind,
+		Payload:   string(payload),
+		Ready:     true,
+	}, at)
+}
+
+// write writes the report to the reports directory as JSON and as a text
+// table, named after the time it was scheduled in the schedule's zone
+func (s *Scheduler) write(report *Report, at time.Time) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("create reports directory: %w", err)
+	}
+	base := filepath.Join(s.dir, "report-"+at.In(s.schedule.Location()).Format(fileLayout))
+	for ext, render := range map[string]func(*bytes.Buffer, *Report) error{
+		".json": func(b *bytes.Buffer, r *Report) error { return WriteJSON(b, r) },
+		".txt":  func(b *bytes.Buffer, r *Report) error { return WriteTable(b, r) },
+	} {
+		var buf bytes.Buffer
+		if err := render(&buf, report); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(base+ext, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it in place, so readers never see a partial report
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write report %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write report %s: %w", path, err)
+	}
+	return nil
+}
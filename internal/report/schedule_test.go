@@ -0,0 +1,113 @@
+package report
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+func TestScheduler_FiresOncePerFiring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store := openStore(t, path)
+	dir := t.TempDir()
+	ingest(t, store, "acme", 100, dayStart.Add(time.Hour))
+	// In the period of the second report
+	ingest(t, store, "acme", 100, dayStart.Add(8*time.Hour))
+
+	schedule, err := ParseSchedule("0 7 * * *", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseSchedule() error = %v", err)
+	}
+	now := dayStart.Add(6 * time.Hour)
+	notified := 0
+	newScheduler := func(store *storage.Storage) *Scheduler {
+		s := NewScheduler(store, schedule, Options{}, dir, strings.ToUpper, func() { notified++ })
+		s.now = func() time.Time { return now }
+		return s
+	}
+	s := newScheduler(store)
+
+	// The first report goes out at once, for the last firing
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v, want the first report sent", sent, err)
+	}
+	// Not again before the next firing, even after a restart
+	now = dayStart.Add(6*time.Hour + 59*time.Minute)
+	if sent, err := newScheduler(openStore(t, path)).Emit(); err != nil || sent {
+		t.Fatalf("Emit() = %v, %v before the firing, want nothing sent", sent, err)
+	}
+	now = dayStart.Add(7 * time.Hour)
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v at the firing, want a report sent", sent, err)
+	}
+	now = now.Add(30 * time.Second)
+	if sent, err := s.Emit(); err != nil || sent {
+		t.Fatalf("Emit() = %v, %v again, want nothing sent", sent, err)
+	}
+	if notified != 2 {
+		t.Errorf("dispatcher notified %d times, want 2", notified)
+	}
+
+	msgs, err := store.PendingOutbox(10)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("PendingOutbox() = %+v, %v, want 2 reports", msgs, err)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(msgs[1].Payload), &e); err != nil {
+		t.Fatalf("decode report payload: %v", err)
+	}
+	wantFrom, wantTo := dayStart.Add(-17*time.Hour), dayStart.Add(7*time.Hour)
+	if msgs[1].Kind != EventKind || !e.At.Equal(wantTo) || !e.Report.From.Equal(wantFrom) || !e.Report.To.Equal(wantTo) {
+		t.Errorf("second report = %+v, want the day up to 7:00", e)
+	}
+	if e.Report.Ingested != 1 || len(e.Report.Sources) != 1 || e.Report.Sources[0].Source != "ACME" {
+		t.Errorf("second report = %+v, want one file from the redacted source", e.Report)
+	}
+
+	for _, name := range []string{"report-2024-06-10T0700.json", "report-2024-06-10T0700.txt", "report-2024-06-09T0700.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("report file %s: %v", name, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "report-2024-06-10T0700.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Report
+	if err := json.Unmarshal(data, &written); err != nil || written.Sources[0].Source != "acme" {
+		t.Errorf("written report = %+v, %v, want unredacted sources", written, err)
+	}
+}
+
+func TestScheduler_CatchesUpOnce(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "state.db"))
+	schedule, _ := ParseSchedule("0 7 * * *", time.UTC)
+	now := dayStart.Add(7 * time.Hour)
+	s := NewScheduler(store, schedule, Options{}, "", nil, nil)
+	s.now = func() time.Time { return now }
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v, want the first report", sent, err)
+	}
+
+	// Down across three firings: only the last one is reported
+	now = dayStart.AddDate(0, 0, 3).Add(12 * time.Hour)
+	if sent, err := s.Emit(); err != nil || !sent {
+		t.Fatalf("Emit() = %v, %v after the downtime, want a report", sent, err)
+	}
+	if sent, err := s.Emit(); err != nil || sent {
+		t.Fatalf("Emit() = %v, %v again, want nothing sent", sent, err)
+	}
+	last, err := store.LastReport()
+	if want := dayStart.AddDate(0, 0, 3).Add(7 * time.Hour); err != nil || !last.Equal(want) {
+		t.Errorf("LastReport() = %v, %v, want %v", last, err, want)
+	}
+	// Without a notify url nothing goes through the outbox
+	if msgs, err := store.PendingOutbox(10); err != nil || len(msgs) != 0 {
+		t.Errorf("PendingOutbox() = %+v, %v, want none", msgs, err)
+	}
+}
@@ -0,0 +1,218 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// Match selects the files a rule applies to. Every set condition must hold.
+type Match struct {
+	// Path is a glob over the slash-separated path relative to the input
+	// directory. "*" matches within one component, "**" across components.
+	Path string `yaml:"path"`
+	// MinSize and MaxSize bound the file size, e.g. "1GB" or "512". Units are
+	// powers of 1024.
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+	// ContentType is a prefix of the detected MIME type, e.g. "text/"
+	ContentType string `yaml:"content_type"`
+}
+
+// Rule attaches Tags to every file that satisfies Match
+type Rule struct {
+	Match Match             `yaml:"match"`
+	Tags  map[string]string `yaml:"tags"`
+
+	path    *regexp.Regexp
+	minSize int64
+	maxSize int64
+}
+
+// File describes the file a rule set is evaluated against
+type File struct {
+	// RelPath is the path relative to the input directory
+	RelPath string
+	Size    int64
+	// Path is the absolute path, read only when a rule needs the content type
+	Path string
+}
+
+// Rules is a validated, ordered rule set
+type Rules struct {
+	rules       []Rule
+	contentType bool
+}
+
+// Load reads and validates a YAML rules file
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules file: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse validates a YAML list of rules
+func Parse(data []byte) (*Rules, error) {
+	var list []Rule
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&list); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode rules: %w", err)
+	}
+
+	rs := &Rules{rules: list}
+	for i := range rs.rules {
+		if err := rs.rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if rs.rules[i].Match.ContentType != "" {
+			rs.contentType = true
+		}
+	}
+	return rs, nil
+}
+
+func (r *Rule) compile() error {
+	if len(r.Tags) == 0 {
+		return errors.New("no tags")
+	}
+	for k := range r.Tags {
+		if k == "" {
+			return errors.New("empty tag key")
+		}
+	}
+
+	if r.Match.Path != "" {
+		re, err := glob.Compile(r.Match.Path)
+		if err != nil {
+			return fmt.Errorf("path %q: %w", r.Match.Path, err)
+		}
+		r.path = re
+	}
+
+	var err error
+	if r.minSize, err = parseSize(r.Match.MinSize, 0); err != nil {
+		return fmt.Errorf("min_size: %w", err)
+	}
+	if r.maxSize, err = parseSize(r.Match.MaxSize, -1); err != nil {
+		return fmt.Errorf("max_size: %w", err)
+	}
+	if r.maxSize >= 0 && r.maxSize < r.minSize {
+		return fmt.Errorf("max_size %d is below min_size %d", r.maxSize, r.minSize)
+	}
+	return nil
+}
+
+func (r *Rule) matches(f File, contentType string) bool {
+	if r.path != nil && !r.path.MatchString(f.RelPath) {
+		return false
+	}
+	if f.Size < r.minSize {
+		return false
+	}
+	if r.maxSize >= 0 && f.Size > r.maxSize {
+		return false
+	}
+	if r.Match.ContentType != "" && !strings.HasPrefix(contentType, r.Match.ContentType) {
+		return false
+	}
+	return true
+}
+
+// Evaluate returns the merged tags of every matching rule, or nil when none
+// match. Rules apply in file order, so later rules win on key conflicts.
+func (rs *Rules) Evaluate(f File) (map[string]string, error) {
+	if rs == nil || len(rs.rules) == 0 {
+		return nil, nil
+	}
+
+	var contentType string
+	if rs.contentType {
+		ct, err := DetectContentType(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		contentType = ct
+	}
+
+	var tags map[string]string
+	for i := range rs.rules {
+		if !rs.rules[i].matches(f, contentType) {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		for k, v := range rs.rules[i].Tags {
+			tags[k] = v
+		}
+	}
+	return tags, nil
+}
+
+// DetectContentType sniffs the MIME type from the first 512 bytes of a file
+func DetectContentType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open for content type: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("read for content type: %w", err)
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a byte count with an optional unit, returning def for ""
+func parseSize(s string, def int64) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return def, nil
+	}
+
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			factor = u.factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/factor {
+		return 0, fmt.Errorf("size %q overflows", s)
+	}
+	return n * factor, nil
+}
@@ -0,0 +1,175 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testRules = `
+- match:
+    path: "finance/**"
+  tags:
+    classification: restricted
+    owner: finance
+- match:
+    path: "**/*.csv"
+    min_size: 1GB
+  tags:
+    tier: bulk
+- match:
+    path: "finance/public/**"
+  tags:
+    classification: public
+`
+
+func TestEvaluate(t *testing.T) {
+	rs, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		file File
+		want map[string]string
+	}{
+		{
+			name: "no match",
+			file: File{RelPath: "sales/a.json", Size: 10},
+			want: nil,
+		},
+		{
+			name: "path match",
+			file: File{RelPath: "finance/q1/a.json", Size: 10},
+			want: map[string]string{"classification": "restricted", "owner": "finance"},
+		},
+		{
+			name: "size below threshold",
+			file: File{RelPath: "a.csv", Size: 1<<30 - 1},
+			want: nil,
+		},
+		{
+			name: "size at threshold, top level",
+			file: File{RelPath: "a.csv", Size: 1 << 30},
+			want: map[string]string{"tier": "bulk"},
+		},
+		{
+			name: "rules merge",
+			file: File{RelPath: "finance/a.csv", Size: 2 << 30},
+			want: map[string]string{"classification": "restricted", "owner": "finance", "tier": "bulk"},
+		},
+		{
+			name: "later rule overrides",
+			file: File{RelPath: "finance/public/a.json", Size: 10},
+			want: map[string]string{"classification": "public", "owner": "finance"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rs.Evaluate(tt.file)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate_ContentType(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "a.dat")
+	if err := os.WriteFile(text, []byte("plain text content"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	binary := filepath.Join(dir, "b.dat")
+	if err := os.WriteFile(binary, []byte{0x1f, 0x8b, 0x08, 0x00}, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	rs, err := Parse([]byte(`
+- match:
+    content_type: "text/"
+  tags:
+    kind: text
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got, err := rs.Evaluate(File{RelPath: "a.dat", Path: text})
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if got["kind"] != "text" {
+		t.Errorf("text file tags = %v, want kind=text", got)
+	}
+
+	got, err = rs.Evaluate(File{RelPath: "b.dat", Path: binary})
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("binary file tags = %v, want none", got)
+	}
+}
+
+func TestEvaluate_NilRules(t *testing.T) {
+	var rs *Rules
+	got, err := rs.Evaluate(File{RelPath: "a.csv"})
+	if err != nil || got != nil {
+		t.Errorf("Evaluate() = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"not a list", "match: {}"},
+		{"no tags", "- match: {path: a}"},
+		{"bad size", "- match: {min_size: big}\n  tags: {a: b}"},
+		{"negative size", "- match: {max_size: \"-1\"}\n  tags: {a: b}"},
+		{"inverted range", "- match: {min_size: 2KB, max_size: 1KB}\n  tags: {a: b}"},
+		{"empty key", "- match: {}\n  tags: {\"\": b}"},
+		{"overflowing size", "- match: {max_size: 9000000000GB}\n  tags: {a: b}"},
+		{"unknown field", "- match: {min_sise: 1KB}\n  tags: {a: b}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.yaml)); err == nil {
+				t.Error("Parse() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", -1},
+		{"512", 512},
+		{"2KB", 2048},
+		{"1 mb", 1 << 20},
+		{"1GB", 1 << 30},
+		{"3B", 3},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in, -1)
+		if err != nil {
+			t.Errorf("parseSize(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package sla
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// Outbox kinds of SLA alerts
+const (
+	// EventMissed alerts that a deadline passed without its file, once as
+	// a warning at the deadline and again as critical after the grace
+	EventMissed = "sla_missed"
+	// EventResolved notices that the file of a missed deadline arrived
+	EventResolved = "sla_resolved"
+)
+
+// checkInterval bounds how late a deadline is checked
+const checkInterval = time.Minute
+
+// Event is the notification payload of an SLA alert
+type Event struct {
+	Kind string          `json:"kind"`
+	At   time.Time       `json:"at"`
+	Miss storage.SLAMiss `json:"miss"`
+}
+
+// Scheduler checks the deadlines of its rules as they pass, recording the
+// ones missed in the state database and alerting through the outbox.
+// Misses are kept until a matching file arrives, so restarts neither
+// repeat alerts nor lose track of late files.
+type Scheduler struct {
+	store  *storage.Storage
+	rules  []*Rule
+	root   string
+	redact func(string) string
+	notify func()
+	now    func() time.Time
+}
+
+// NewScheduler returns a scheduler checking rules against the files
+// ingested from the input directory root. Alerts are written to the outbox
+// with their paths passed through redact when notify is set; notify is
+// called after an alert is written to the outbox. Without notify they are
+// only logged.
+func NewScheduler(store *storage.Storage, rules []*Rule, root string, redact func(string) string, notify func()) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		rules:  rules,
+		root:   root,
+		redact: redact,
+		notify: notify,
+		now:    time.Now,
+	}
+}
+
+// Run checks deadlines as they pass until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		supervisor.Beat(ctx)
+		if err := s.Check(); err != nil {
+			slog.Error("failed to check SLA deadlines", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check resolves the misses whose file arrived, escalates the ones past
+// their grace and records a miss for the last deadline of every rule
+// passed without a file
+func (s *Scheduler) Check() error {
+	now := s.now()
+	for _, r := range s.rules {
+		if err := s.checkOpen(r, now); err != nil {
+			return err
+		}
+		if err := s.checkDeadline(r, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOpen resolves or escalates the unresolved misses of r. A late file
+// resolves every miss whose window it falls after.
+func (s *Scheduler) checkOpen(r *Rule, now time.Time) error {
+	open, err := s.store.UnresolvedSLAMisses(r.Name)
+	if err != nil {
+		return err
+	}
+	for i := range open {
+		m := &open[i]
+		file, err := r.firstMatch(s.store, s.root, m.WindowStart, now)
+		if err != nil {
+			return err
+		}
+		switch {
+		case file != nil:
+			at := file.ProcessedAt.UTC()
+			m.ResolvedAt, m.ResolvedPath, m.ResolvedSHA256 = &at, file.Path, file.SHA256
+			if err := s.record(m, EventResolved, now); err != nil {
+				return err
+			}
+			slog.Info("SLA deadline met late", "rule", m.Rule, "deadline", m.Deadline, "path", file.Path, "late_by", at.Sub(m.Deadline))
+		case m.Severity == storage.SLASeverityWarning && !now.Before(m.Deadline.Add(r.grace)):
+			escalated := now.UTC()
+			m.Severity, m.EscalatedAt = storage.SLASeverityCritical, &escalated
+			if err := s.record(m, EventMissed, now); err != nil {
+				return err
+			}
+			slog.Error("SLA deadline missed past grace", "rule", m.Rule, "deadline", m.Deadline, "pattern", m.Pattern)
+		}
+	}
+	return nil
+}
+
+// checkDeadline records a miss when the last deadline of r passed without
+// a file in its window. A deadline found past its grace, as after
+// downtime, is critical at once.
+func (s *Scheduler) checkDeadline(r *Rule, now time.Time) error {
+	deadline := r.Deadline(now)
+	if deadline.IsZero() {
+		return nil
+	}
+	miss, err := s.store.FindSLAMiss(r.Name, deadline)
+	if err != nil || miss != nil {
+		return err
+	}
+	start := r.WindowStart(deadline)
+	file, err := r.firstMatch(s.store, s.root, start, deadline)
+	if err != nil || file != nil {
+		return err
+	}
+
+	miss = &storage.SLAMiss{
+		Rule:        r.Name,
+		Deadline:    deadline,
+		WindowStart: start,
+		Pattern:     r.Pattern,
+		Severity:    storage.SLASeverityWarning,
+		MissedAt:    now,
+	}
+	if !now.Before(deadline.Add(r.grace)) {
+		escalated := now.UTC()
+		miss.Severity, miss.EscalatedAt = storage.SLASeverityCritical, &escalated
+	}
+	if err := s.record(miss, EventMissed, now); err != nil {
+		return err
+	}
+	if miss.Severity == storage.SLASeverityCritical {
+		slog.Error("SLA deadline missed past grace", "rule", r.Name, "deadline", deadline, "pattern", r.Pattern)
+	} else {
+		slog.Warn("SLA deadline missed", "rule", r.Name, "deadline", deadline, "pattern", r.Pattern, "grace", r.grace)
+	}
+	return nil
+}
+
+// record saves m with an alert of kind in the outbox when notifications
+// are on
+func (s *Scheduler) record(m *storage.SLAMiss, kind string, now time.Time) error {
+	if s.notify == nil {
+		return s.store.RecordSLAMiss(m, nil)
+	}
+	alert := *m
+	if s.redact != nil {
+		alert.ResolvedPath = s.redact(alert.ResolvedPath)
+		alert.Pattern = s.redact(alert.Pattern)
+	}
+	payload, err := json.Marshal(Event{Kind: kind, At: now.UTC(), Miss: alert})
+	if err != nil {
+		return fmt.Errorf("encode SLA alert: %w", err)
+	}
+	if err := s.store.RecordSLAMiss(m, &storage.OutboxMessage{
+		CreatedAt: now.UTC(),
+		Kind:      kind,
+		Payload:   string(payload),
+		Ready:     true,
+	}); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
@@ -0,0 +1,134 @@
+package sla
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// alerts decodes the alerts written to the outbox
+func alerts(t *testing.T, store *storage.Storage) []Event {
+	t.Helper()
+	msgs, err := store.PendingOutbox(100)
+	if err != nil {
+		t.Fatalf("PendingOutbox() error = %v", err)
+	}
+	events := make([]Event, len(msgs))
+	for i, msg := range msgs {
+		if err := json.Unmarshal([]byte(msg.Payload), &events[i]); err != nil {
+			t.Fatalf("decode alert: %v", err)
+		}
+		if events[i].Kind != msg.Kind {
+			t.Errorf("alert kind %q in a %q message", events[i].Kind, msg.Kind)
+		}
+	}
+	return events
+}
+
+func TestScheduler_MissEscalateResolve(t *testing.T) {
+	store := openStore(t)
+	rules := compile(t, config.SLARule{Name: "acme-daily", Source: "acme", Pattern: "orders-*.csv", Schedule: "0 7 * * *", Grace: 2 * time.Hour})
+	now := dayStart.Add(6 * time.Hour)
+	notified := 0
+	s := NewScheduler(store, rules, root, strings.ToUpper, func() { notified++ })
+	s.now = func() time.Time { return now }
+	check := func() {
+		t.Helper()
+		if err := s.Check(); err != nil {
+			t.Fatalf("Check() at %s error = %v", now.Format(time.Kitchen), err)
+		}
+	}
+
+	// Yesterday's file met yesterday's deadline
+	ingest(t, store, "acme/orders-1.csv", dayStart.Add(-20*time.Hour))
+	check()
+	// Files of another source or name do not count for today
+	ingest(t, store, "globex/orders-2.csv", dayStart.Add(6*time.Hour))
+	ingest(t, store, "acme/invoices-2.csv", dayStart.Add(6*time.Hour))
+	now = dayStart.Add(7 * time.Hour)
+	check()
+	deadline := dayStart.Add(7 * time.Hour)
+	miss, err := store.FindSLAMiss("acme-daily", deadline)
+	if err != nil || miss == nil || miss.Severity != storage.SLASeverityWarning {
+		t.Fatalf("FindSLAMiss() = %+v, %v, want a warning at the deadline", miss, err)
+	}
+	// Checked again within the grace, nothing changes
+	now = dayStart.Add(8 * time.Hour)
+	check()
+	if notified != 1 {
+		t.Fatalf("notified %d times within the grace, want 1", notified)
+	}
+
+	now = dayStart.Add(9 * time.Hour)
+	check()
+	check()
+	if miss, _ = store.FindSLAMiss("acme-daily", deadline); miss.Severity != storage.SLASeverityCritical || miss.EscalatedAt == nil {
+		t.Fatalf("miss after the grace = %+v, want it critical", miss)
+	}
+
+	// The late file resolves the miss
+	ingest(t, store, "acme/orders-2.csv", dayStart.Add(10*time.Hour))
+	now = dayStart.Add(10*time.Hour + time.Minute)
+	check()
+	miss, _ = store.FindSLAMiss("acme-daily", deadline)
+	if miss.ResolvedAt == nil || !miss.ResolvedAt.Equal(dayStart.Add(10*time.Hour)) || miss.ResolvedPath != "/in/acme/orders-2.csv" {
+		t.Fatalf("miss after the late file = %+v, want it resolved by orders-2.csv", miss)
+	}
+	check()
+
+	events := alerts(t, store)
+	if len(events) != 3 || notified != 3 {
+		t.Fatalf("got %d alerts, notified %d times, want 3 each", len(events), notified)
+	}
+	for i, want := range []struct{ kind, severity string }{
+		{EventMissed, storage.SLASeverityWarning},
+		{EventMissed, storage.SLASeverityCritical},
+		{EventResolved, storage.SLASeverityCritical},
+	} {
+		if e := events[i]; e.Kind != want.kind || e.Miss.Severity != want.severity || !e.Miss.Deadline.Equal(deadline) {
+			t.Errorf("alert %d = %+v, want %s %s", i, e, want.kind, want.severity)
+		}
+	}
+	if got := events[2].Miss.ResolvedPath; got != "/IN/ACME/ORDERS-2.CSV" {
+		t.Errorf("resolved path in the alert = %q, want it redacted", got)
+	}
+
+	// Today's file met tomorrow's deadline
+	now = dayStart.Add(31 * time.Hour)
+	check()
+	if miss, err := store.FindSLAMiss("acme-daily", now); err != nil || miss != nil {
+		t.Errorf("FindSLAMiss(tomorrow) = %+v, %v, want the deadline met", miss, err)
+	}
+}
+
+func TestScheduler_MissedDuringDowntime(t *testing.T) {
+	store := openStore(t)
+	rules := compile(t, config.SLARule{Name: "weekly", Pattern: "reports/*.pdf", Schedule: "0 9 * * 1", Timezone: "Europe/Berlin", Grace: time.Hour})
+	// Started long after the deadline, Monday 07:00 UTC
+	now := dayStart.Add(12 * time.Hour)
+	s := NewScheduler(store, rules, root, nil, nil)
+	s.now = func() time.Time { return now }
+	if err := s.Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	misses, err := store.SLAMisses(time.Time{})
+	if err != nil || len(misses) != 1 {
+		t.Fatalf("SLAMisses() = %+v, %v, want one miss", misses, err)
+	}
+	if m := misses[0]; m.Severity != storage.SLASeverityCritical || !m.Deadline.Equal(dayStart.Add(7*time.Hour)) || !m.WindowStart.Equal(dayStart.Add(-161*time.Hour)) {
+		t.Errorf("miss = %+v, want it critical at once for the week to 09:00 Berlin", m)
+	}
+	// Without notifications misses are only recorded
+	if msgs, _ := store.PendingOutbox(10); len(msgs) != 0 {
+		t.Errorf("outbox holds %d alerts without notifications, want none", len(msgs))
+	}
+
+	statuses, err := Status(store, rules, root, now)
+	if err != nil || len(statuses) != 1 || statuses[0].State != storage.SLASeverityCritical || statuses[0].Unresolved != 1 {
+		t.Errorf("Status() = %+v, %v, want the rule critical", statuses, err)
+	}
+}
@@ -0,0 +1,225 @@
+// Package sla checks that files expected at deadlines, such as a daily
+// export by 07:00, were ingested in time, and keeps a record of the
+// deadlines missed
+package sla
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+	"github.com/1995parham-learning/atomic-ingestor/internal/report"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Rule is a compiled config.SLARule
+type Rule struct {
+	Name     string
+	Pattern  string
+	Schedule *report.Schedule
+	source   string
+	re       *regexp.Regexp
+	window   time.Duration
+	grace    time.Duration
+	// query is the source files are looked up in, every source when empty
+	query string
+}
+
+// Compile validates rules and compiles them in order
+func Compile(rules []config.SLARule) ([]*Rule, error) {
+	compiled := make([]*Rule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("sla rule %d: name is required", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("sla rule %d: duplicate name %q", i+1, r.Name)
+		}
+		names[r.Name] = true
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("sla rule %q: pattern is required", r.Name)
+		}
+		if r.Source != "" && (strings.Contains(r.Source, "/") || !filepath.IsLocal(r.Source)) {
+			return nil, fmt.Errorf("sla rule %q: source %q is not a top-level directory", r.Name, r.Source)
+		}
+		if r.Window < 0 || r.Grace < 0 {
+			return nil, fmt.Errorf("sla rule %q: window and grace must not be negative", r.Name)
+		}
+		tz := r.Timezone
+		if tz == "" {
+			tz = "UTC"
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("sla rule %q: timezone %q: %w", r.Name, r.Timezone, err)
+		}
+		schedule, err := report.ParseSchedule(r.Schedule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("sla rule %q: %w", r.Name, err)
+		}
+		re, err := glob.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("sla rule %q: pattern %q: %w", r.Name, r.Pattern, err)
+		}
+		query := r.Source
+		if query == "" {
+			query = sourceOfPattern(r.Pattern)
+		}
+		compiled = append(compiled, &Rule{
+			Name:     r.Name,
+			Pattern:  r.Pattern,
+			Schedule: schedule,
+			source:   r.Source,
+			re:       re,
+			window:   r.Window,
+			grace:    r.Grace,
+			query:    query,
+		})
+	}
+	return compiled, nil
+}
+
+// sourceOfPattern returns the only source a pattern relative to the input
+// directory can match files of, or "" when it can match several
+func sourceOfPattern(pattern string) string {
+	first, _, found := strings.Cut(pattern, "/")
+	switch {
+	case strings.Contains(first, "**"):
+		return ""
+	case !found:
+		// Files directly in the input directory
+		return "."
+	case strings.ContainsAny(first, "*?"):
+		return ""
+	}
+	return first
+}
+
+// Deadline returns the last deadline of r at or before now, zero when its
+// schedule never fired
+func (r *Rule) Deadline(now time.Time) time.Time {
+	// Firings fall on whole minutes
+	return r.Schedule.Prev(now.Add(time.Second))
+}
+
+// WindowStart returns the earliest time a file counts for deadline
+func (r *Rule) WindowStart(deadline time.Time) time.Time {
+	if r.window > 0 {
+		return deadline.Add(-r.window)
+	}
+	if prev := r.Schedule.Prev(deadline); !prev.IsZero() {
+		return prev
+	}
+	return deadline.Add(-24 * time.Hour)
+}
+
+// Grace returns how long after a deadline a miss turns critical
+func (r *Rule) Grace() time.Duration {
+	return r.grace
+}
+
+// Match reports whether the file at path, below the input directory root,
+// is one the rule expects
+func (r *Rule) Match(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if r.source != "" {
+		var ok bool
+		if rel, ok = strings.CutPrefix(rel, r.source+"/"); !ok {
+			return false
+		}
+	}
+	return r.re.MatchString(rel)
+}
+
+// firstMatch returns the first file ingested between from and to that r
+// expects, or nil
+func (r *Rule) firstMatch(store storage.Reader, root string, from, to time.Time) (*storage.File, error) {
+	files, err := store.IngestedBetween(r.query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		if r.Match(root, files[i].Path) {
+			return &files[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Rule states reported by Status
+const (
+	// StateMet is a deadline a file arrived for in time
+	StateMet = "met"
+	// StateDue is a passed deadline without a file that no check recorded
+	// as missed yet
+	StateDue = "due"
+	// StateResolved is a missed deadline whose file arrived late
+	StateResolved = "resolved"
+)
+
+// RuleStatus is the state of a rule at its last deadline. State is StateMet,
+// StateDue, StateResolved or the severity of the miss.
+type RuleStatus struct {
+	Rule         string    `json:"rule"`
+	Pattern      string    `json:"pattern"`
+	LastDeadline time.Time `json:"last_deadline,omitzero"`
+	NextDeadline time.Time `json:"next_deadline"`
+	State        string    `json:"state"`
+	// File is the file that met or resolved the last deadline
+	File       string    `json:"file,omitempty"`
+	IngestedAt time.Time `json:"ingested_at,omitzero"`
+	// Unresolved counts the misses of the rule no file has resolved,
+	// earlier deadlines included
+	Unresolved int `json:"unresolved"`
+}
+
+// Status returns the state of every rule at now, with the input directory
+// at root
+func Status(store storage.Reader, rules []*Rule, root string, now time.Time) ([]RuleStatus, error) {
+	statuses := make([]RuleStatus, 0, len(rules))
+	for _, r := range rules {
+		st := RuleStatus{Rule: r.Name, Pattern: r.Pattern, NextDeadline: r.Schedule.Next(now)}
+		open, err := store.UnresolvedSLAMisses(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		st.Unresolved = len(open)
+
+		deadline := r.Deadline(now)
+		st.LastDeadline = deadline
+		if deadline.IsZero() {
+			statuses = append(statuses, st)
+			continue
+		}
+		miss, err := store.FindSLAMiss(r.Name, deadline)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case miss != nil && miss.ResolvedAt != nil:
+			st.State, st.File, st.IngestedAt = StateResolved, miss.ResolvedPath, *miss.ResolvedAt
+		case miss != nil:
+			st.State = miss.Severity
+		default:
+			file, err := r.firstMatch(store, root, r.WindowStart(deadline), deadline)
+			if err != nil {
+				return nil, err
+			}
+			st.State = StateDue
+			if file != nil {
+				st.State, st.File, st.IngestedAt = StateMet, file.Path, file.ProcessedAt
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
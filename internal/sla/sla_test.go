@@ -0,0 +1,147 @@
+package sla
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const root = "/in"
+
+// dayStart is a Monday
+var dayStart = time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+
+// openStore opens a migrated state database
+func openStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "state.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := storage.New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return store
+}
+
+// ingest records the file at rel below root as ingested at at
+func ingest(t *testing.T, store *storage.Storage, rel string, at time.Time) {
+	t.Helper()
+	_, _, err := store.CreateFileIfAbsent(storage.FileRecord{
+		SHA256: fmt.Sprintf("sha-%s-%d", rel, at.UnixNano()), Path: root + "/" + rel, RelPath: rel,
+		Status: status.Ingested, ProcessedAt: at,
+	})
+	if err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+}
+
+func compile(t *testing.T, rules ...config.SLARule) []*Rule {
+	t.Helper()
+	compiled, err := Compile(rules)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	return compiled
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	for name, r := range map[string]config.SLARule{
+		"no name":        {Pattern: "*.csv", Schedule: "0 7 * * *"},
+		"no pattern":     {Name: "a", Schedule: "0 7 * * *"},
+		"bad schedule":   {Name: "a", Pattern: "*.csv", Schedule: "7 o'clock"},
+		"bad timezone":   {Name: "a", Pattern: "*.csv", Schedule: "0 7 * * *", Timezone: "Mars/Olympus"},
+		"nested source":  {Name: "a", Source: "acme/orders", Pattern: "*.csv", Schedule: "0 7 * * *"},
+		"negative grace": {Name: "a", Pattern: "*.csv", Schedule: "0 7 * * *", Grace: -time.Hour},
+	} {
+		if _, err := Compile([]config.SLARule{r}); err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error", name)
+		}
+	}
+	dup := config.SLARule{Name: "a", Pattern: "*.csv", Schedule: "0 7 * * *"}
+	if _, err := Compile([]config.SLARule{dup, dup}); err == nil {
+		t.Error("Compile() of duplicate names succeeded, want an error")
+	}
+}
+
+func TestRule_Match(t *testing.T) {
+	rules := compile(t,
+		config.SLARule{Name: "source", Source: "acme", Pattern: "orders-*.csv", Schedule: "0 7 * * *"},
+		config.SLARule{Name: "root", Pattern: "**/orders-*.csv", Schedule: "0 7 * * *"},
+	)
+	for _, tt := range []struct {
+		rule int
+		path string
+		want bool
+	}{
+		{0, "/in/acme/orders-1.csv", true},
+		{0, "/in/acme/eu/orders-1.csv", false},
+		{0, "/in/globex/orders-1.csv", false},
+		{0, "/elsewhere/acme/orders-1.csv", false},
+		{1, "/in/globex/eu/orders-1.csv", true},
+		{1, "/in/orders-1.csv", true},
+		{1, "/in/globex/invoices-1.csv", false},
+	} {
+		if got := rules[tt.rule].Match(root, tt.path); got != tt.want {
+			t.Errorf("%s.Match(%s) = %v, want %v", rules[tt.rule].Name, tt.path, got, tt.want)
+		}
+	}
+	// Files are looked up in the one source a pattern can match
+	for pattern, want := range map[string]string{"acme/*.csv": "acme", "*.csv": ".", "*/orders.csv": "", "**/x.csv": ""} {
+		if got := sourceOfPattern(pattern); got != want {
+			t.Errorf("sourceOfPattern(%s) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestRule_WindowStart(t *testing.T) {
+	rules := compile(t,
+		config.SLARule{Name: "weekdays", Pattern: "*.csv", Schedule: "0 7 * * 1-5"},
+		config.SLARule{Name: "window", Pattern: "*.csv", Schedule: "0 7 * * 1-5", Window: 2 * time.Hour},
+	)
+	monday := dayStart.Add(7 * time.Hour)
+	// Since the previous firing, the Friday before
+	if got, want := rules[0].WindowStart(monday), monday.Add(-72*time.Hour); !got.Equal(want) {
+		t.Errorf("WindowStart() = %v, want %v", got, want)
+	}
+	if got, want := rules[1].WindowStart(monday), monday.Add(-2*time.Hour); !got.Equal(want) {
+		t.Errorf("WindowStart() with a window = %v, want %v", got, want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	store := openStore(t)
+	rules := compile(t,
+		config.SLARule{Name: "met", Source: "acme", Pattern: "*.csv", Schedule: "0 7 * * *"},
+		config.SLARule{Name: "due", Source: "globex", Pattern: "*.csv", Schedule: "0 7 * * *"},
+	)
+	ingest(t, store, "acme/orders.csv", dayStart.Add(6*time.Hour))
+
+	statuses, err := Status(store, rules, root, dayStart.Add(8*time.Hour))
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("Status() = %+v, want two rules", statuses)
+	}
+	if st := statuses[0]; st.State != StateMet || st.File != "/in/acme/orders.csv" || !st.NextDeadline.Equal(dayStart.Add(31*time.Hour)) {
+		t.Errorf("status of met = %+v, want met by orders.csv", st)
+	}
+	if st := statuses[1]; st.State != StateDue || st.File != "" {
+		t.Errorf("status of due = %+v, want due", st)
+	}
+}
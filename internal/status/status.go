@@ -0,0 +1,121 @@
+// Package status defines the statuses a file ends up in, shared by every
+// record of it: processing outcomes and events, manifest entries, database
+// rows, receipts, attempts and notifications. Code names statuses by their
+// constants, and a status decoded from JSON or text is validated. Consumers
+// that switch over statuses list every constant without a default, so that
+// adding one means revisiting each switch; the tests check that All lists
+// every status exactly once.
+package status
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrUnknown is returned, wrapped, when decoding a string that is not a
+// Status
+var ErrUnknown = errors.New("unknown status")
+
+// Status is the status of a file. The zero value is unset, as in manifest
+// entries written before statuses were recorded.
+type Status string
+
+const (
+	// Ingested files were moved or copied into the warehouse
+	Ingested Status = "ingested"
+	// Duplicate files matched content already in the warehouse
+	Duplicate Status = "duplicate"
+	// DuplicateSummary stands for the duplicates of a resend storm
+	// summarized in one manifest entry
+	DuplicateSummary Status = "duplicate_summary"
+	// Quarantined files were moved to the quarantine directory
+	Quarantined Status = "quarantined"
+	// Vanished files disappeared before they were ingested
+	Vanished Status = "vanished"
+	// Failed attempts are retried
+	Failed Status = "failed"
+	// Adopted files already lived in the warehouse and were recorded by the
+	// adopt command
+	Adopted Status = "adopted"
+	// Suspect files have a warehouse copy that no longer matched their hash
+	// when paranoid dedup compared it with new content
+	Suspect Status = "suspect"
+	// MissingRestored files had their warehouse copy found missing when
+	// their content arrived again, and the arrival was ingested to replace it
+	MissingRestored Status = "missing_restored"
+	// Superseded files had their content arrive again after the dedup window
+	// and ingested anew, the new record taking over their hash
+	Superseded Status = "superseded"
+)
+
+// all lists every Status in canonical order
+var all = [...]Status{
+	Ingested,
+	Duplicate,
+	DuplicateSummary,
+	Quarantined,
+	Vanished,
+	Failed,
+	Adopted,
+	Suspect,
+	MissingRestored,
+	Superseded,
+}
+
+// UnknownLabel is the metric label of a string that is not a Status
+const UnknownLabel = "unknown"
+
+// All returns every Status in canonical order
+func All() []Status {
+	return all[:]
+}
+
+// Parse returns the Status named s, wrapping ErrUnknown for any other string
+func Parse(s string) (Status, error) {
+	st := Status(s)
+	if !st.Valid() {
+		return "", fmt.Errorf("%w %q", ErrUnknown, s)
+	}
+	return st, nil
+}
+
+// Valid reports whether s is one of the statuses
+func (s Status) Valid() bool {
+	return slices.Contains(all[:], s)
+}
+
+// String returns the canonical name of s
+func (s Status) String() string {
+	return string(s)
+}
+
+// Label returns s as a metric label: its canonical name, which is lowercase
+// snake case, or UnknownLabel for any other value, so labels stay bounded
+func (s Status) Label() string {
+	if !s.Valid() {
+		return UnknownLabel
+	}
+	return string(s)
+}
+
+// MarshalText encodes s as is, so that a record holding a status this
+// version does not know is still reported; decoding it is refused
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s), nil
+}
+
+// UnmarshalText decodes a Status, or the unset value from empty text,
+// wrapping ErrUnknown for any other string
+func (s *Status) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*s = ""
+		return nil
+	}
+	st, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
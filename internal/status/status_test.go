@@ -0,0 +1,104 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+// describe switches over every status without a default, the pattern every
+// exhaustive consumer follows
+func describe(s Status) string {
+	switch s {
+	case Ingested:
+		return "in the warehouse"
+	case Duplicate, DuplicateSummary:
+		return "already in the warehouse"
+	case Quarantined:
+		return "in quarantine"
+	case Vanished:
+		return "gone"
+	case Failed:
+		return "retried"
+	case Adopted:
+		return "adopted"
+	case Suspect:
+		return "suspect"
+	case MissingRestored:
+		return "restored"
+	case Superseded:
+		return "superseded"
+	}
+	return ""
+}
+
+func TestAll_RoundTrip(t *testing.T) {
+	label := regexp.MustCompile(`^[a-z][a-z_]*$`)
+	seen := make(map[Status]bool)
+	for _, s := range All() {
+		if seen[s] {
+			t.Errorf("%q listed twice", s)
+		}
+		seen[s] = true
+		if !s.Valid() {
+			t.Errorf("%q is not valid", s)
+		}
+		if describe(s) == "" {
+			t.Errorf("%q is not handled by the exhaustive switch", s)
+		}
+		if !label.MatchString(s.Label()) || s.Label() != s.String() {
+			t.Errorf("Label() of %q = %q, want its name", s, s.Label())
+		}
+
+		parsed, err := Parse(s.String())
+		if err != nil || parsed != s {
+			t.Errorf("Parse(%q) = %q, %v", s, parsed, err)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("Marshal(%q) error = %v", s, err)
+		}
+		var decoded Status
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded != s {
+			t.Errorf("Unmarshal(%s) = %q, %v", data, decoded, err)
+		}
+	}
+	if len(seen) != 10 {
+		t.Errorf("All() has %d statuses, want 10; handle a new status in every switch over statuses", len(seen))
+	}
+}
+
+func TestUnmarshal_Validates(t *testing.T) {
+	var entry struct {
+		Status Status `json:"status,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(`{"status":"failure"}`), &entry); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Unmarshal(failure) error = %v, want ErrUnknown", err)
+	}
+	if err := json.Unmarshal([]byte(`{"status":""}`), &entry); err != nil || entry.Status != "" {
+		t.Errorf("Unmarshal(empty) = %q, %v, want the unset status", entry.Status, err)
+	}
+	if data, err := json.Marshal(entry); err != nil || string(data) != "{}" {
+		t.Errorf("Marshal(unset) = %s, %v, want it omitted", data, err)
+	}
+
+	counts := map[Status]int{Ingested: 1}
+	data, err := json.Marshal(counts)
+	if err != nil {
+		t.Fatalf("Marshal(map) error = %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"failure":1}`), &counts); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Unmarshal of an unknown key error = %v, want ErrUnknown", err)
+	}
+	if string(data) != `{"ingested":1}` {
+		t.Errorf("Marshal(map) = %s", data)
+	}
+
+	if data, err := json.Marshal(Status("failure")); err != nil || string(data) != `"failure"` {
+		t.Errorf("Marshal(failure) = %s, %v, want it encoded as is", data, err)
+	}
+	if got := Status("failure").Label(); got != UnknownLabel {
+		t.Errorf("Label() of an unknown status = %q, want %q", got, UnknownLabel)
+	}
+}
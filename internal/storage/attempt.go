@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Attempt records one try at processing a file, whatever its outcome, so the
+// failures before a success survive log rotation
+type Attempt struct {
+	ID uint `gorm:"primaryKey" json:"-"`
+	// Path is the source path of the file
+	Path string `gorm:"index:idx_attempts_path,priority:1" json:"path"`
+	// SHA256 is empty when the attempt failed before hashing finished
+	SHA256 string `gorm:"index" json:"sha256,omitempty"`
+	// Number counts the attempts of the file, from 1
+	Number    int           `gorm:"index:idx_attempts_path,priority:2" json:"number"`
+	StartedAt time.Time     `json:"started_at"`
+	EndedAt   time.Time     `gorm:"index" json:"ended_at"`
+	Outcome   status.Status `json:"outcome"`
+	Error     string        `json:"error,omitempty"`
+	// Instance identifies the process that made the attempt as host:pid
+	Instance string `json:"instance"`
+}
+
+// RecordAttempts stores a batch of attempts in one transaction. The attempt
+// after a failed one continues its numbering; any other outcome ends the
+// file's timeline, so a later arrival at the same path starts over at 1.
+func (s *Storage) RecordAttempts(attempts []Attempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range attempts {
+			a := &attempts[i]
+			var last Attempt
+			err := tx.Where("path = ?", a.Path).Order("id DESC").First(&last).Error
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				a.Number = 1
+			case err != nil:
+				return fmt.Errorf("query last attempt of %s: %w", a.Path, err)
+			case last.Outcome == status.Failed:
+				a.Number = last.Number + 1
+			default:
+				a.Number = 1
+			}
+			a.StartedAt, a.EndedAt = a.StartedAt.UTC(), a.EndedAt.UTC()
+			if err := tx.Create(a).Error; err != nil {
+				return fmt.Errorf("record attempt of %s: %w", a.Path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Attempts returns the attempts at a source path, or at the file with a
+// SHA256, oldest first. A hash matches the failed attempts before its
+// success too, since they share the path.
+func (q queries) Attempts(pathOrSHA256 string) ([]Attempt, error) {
+	var attempts []Attempt
+	err := q.db.
+		Where("path = ? OR path IN (?)", pathOrSHA256,
+			q.db.Model(&Attempt{}).Select("path").Where("sha256 = ?", pathOrSHA256)).
+		Order("id").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, fmt.Errorf("query attempts of %s: %w", pathOrSHA256, err)
+	}
+	return attempts, nil
+}
+
+// RetriedFile summarizes a file that needed more than one attempt
+type RetriedFile struct {
+	Path string `json:"path"`
+	// Attempts is the highest attempt number of the file in the period
+	Attempts  int       `json:"attempts"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	// Outcome is that of the latest attempt, failed while still retrying
+	Outcome status.Status `json:"outcome"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// RetriedFiles returns up to limit files with an attempt after the first
+// ending at or after since, those with the most attempts first. A limit that
+// is not positive returns them all.
+func (q queries) RetriedFiles(since time.Time, limit int) ([]RetriedFile, error) {
+	var rows []struct {
+		Path     string
+		Attempts int
+		LastID   uint
+	}
+	query := q.db.Model(&Attempt{}).
+		Select("path, max(number) AS attempts, max(id) AS last_id").
+		Where("ended_at >= ?", since.UTC()).
+		Group("path").
+		Having("max(number) > 1").
+		Order("attempts DESC").Order("path")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("query retried files: %w", err)
+	}
+
+	files := make([]RetriedFile, 0, len(rows))
+	for _, row := range rows {
+		var last, first Attempt
+		if err := q.db.First(&last, row.LastID).Error; err != nil {
+			return nil, fmt.Errorf("query last attempt of %s: %w", row.Path, err)
+		}
+		// The timeline starts at the first attempt of the latest run of
+		// numbers, which may predate since
+		if err := q.db.Where("path = ? AND number = 1 AND id <= ?", row.Path, row.LastID).
+			Order("id DESC").First(&first).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("query first attempt of %s: %w", row.Path, err)
+		}
+		if first.ID == 0 {
+			first = last
+		}
+		files = append(files, RetriedFile{
+			Path:      row.Path,
+			Attempts:  row.Attempts,
+			StartedAt: first.StartedAt,
+			EndedAt:   last.EndedAt,
+			Outcome:   last.Outcome,
+			Error:     last.Error,
+		})
+	}
+	return files, nil
+}
+
+// PruneAttempts deletes the attempts that ended before cutoff and returns
+// how many it deleted
+func (s *Storage) PruneAttempts(cutoff time.Time) (int64, error) {
+	res := s.db.Where("ended_at < ?", cutoff.UTC()).Delete(&Attempt{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("prune attempts: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
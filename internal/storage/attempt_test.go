@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestRecordAttempts_Numbering(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	attempt := func(path, sha string, outcome status.Status, minute int) Attempt {
+		return Attempt{
+			Path: path, SHA256: sha, Outcome: outcome, Instance: "host:1",
+			StartedAt: at.Add(time.Duration(minute) * time.Minute),
+			EndedAt:   at.Add(time.Duration(minute)*time.Minute + time.Second),
+		}
+	}
+	// Two batches, so numbering continues across them
+	if err := store.RecordAttempts([]Attempt{
+		attempt("/in/a.csv", "", status.Failed, 0),
+		attempt("/in/b.csv", "sha-b", "ingested", 0),
+	}); err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+	if err := store.RecordAttempts([]Attempt{
+		attempt("/in/a.csv", "sha-a", status.Failed, 1),
+		attempt("/in/a.csv", "sha-a", "ingested", 2),
+		// A new arrival at an ingested path starts over
+		attempt("/in/b.csv", "sha-b2", "duplicate", 3),
+	}); err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+
+	got, err := store.Attempts("sha-a")
+	if err != nil {
+		t.Fatalf("Attempts() error = %v", err)
+	}
+	var numbers []int
+	for _, a := range got {
+		numbers = append(numbers, a.Number)
+	}
+	if len(got) != 3 || numbers[0] != 1 || numbers[1] != 2 || numbers[2] != 3 || got[2].Outcome != "ingested" {
+		t.Errorf("Attempts(sha-a) = %+v, want attempts 1 to 3 ending ingested", got)
+	}
+	if got, _ := store.Attempts("/in/b.csv"); len(got) != 2 || got[1].Number != 1 {
+		t.Errorf("Attempts(/in/b.csv) = %+v, want two first attempts", got)
+	}
+}
+
+func TestRetriedFiles(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	var batch []Attempt
+	add := func(path string, outcome status.Status, minute int) {
+		a := Attempt{
+			Path: path, Outcome: outcome,
+			StartedAt: at.Add(time.Duration(minute) * time.Minute),
+			EndedAt:   at.Add(time.Duration(minute) * time.Minute),
+		}
+		if outcome == status.Failed {
+			a.Error = "disk full"
+		}
+		batch = append(batch, a)
+	}
+	add("/in/flaky.csv", status.Failed, 0)
+	add("/in/flaky.csv", status.Failed, 1)
+	add("/in/flaky.csv", "ingested", 2)
+	add("/in/stuck.csv", status.Failed, 0)
+	add("/in/stuck.csv", status.Failed, 5)
+	add("/in/once.csv", "ingested", 0)
+	if err := store.RecordAttempts(batch); err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+
+	got, err := store.RetriedFiles(at, 0)
+	if err != nil {
+		t.Fatalf("RetriedFiles() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("RetriedFiles() = %+v, want flaky and stuck", got)
+	}
+	if got[0].Path != "/in/flaky.csv" || got[0].Attempts != 3 || got[0].Outcome != "ingested" ||
+		!got[0].StartedAt.Equal(at) || !got[0].EndedAt.Equal(at.Add(2*time.Minute)) {
+		t.Errorf("first = %+v, want flaky ingested on attempt 3", got[0])
+	}
+	if got[1].Path != "/in/stuck.csv" || got[1].Attempts != 2 || got[1].Outcome != status.Failed || got[1].Error != "disk full" {
+		t.Errorf("second = %+v, want stuck still failing", got[1])
+	}
+	if got, _ := store.RetriedFiles(at, 1); len(got) != 1 {
+		t.Errorf("RetriedFiles(limit 1) = %+v, want one", got)
+	}
+
+	n, err := store.PruneAttempts(at.Add(3 * time.Minute))
+	if err != nil || n != 5 {
+		t.Errorf("PruneAttempts() = %d, %v, want 5 deleted", n, err)
+	}
+	if got, _ := store.Attempts("/in/stuck.csv"); len(got) != 1 || got[0].Number != 2 {
+		t.Errorf("attempts after pruning = %+v, want the last of stuck", got)
+	}
+}
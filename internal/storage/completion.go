@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Completion records that the sidecar of a data file was seen, so the
+// completion survives a restart even once the sidecar is gone. It is
+// deleted when the file reaches a terminal state.
+type Completion struct {
+	Path       string `gorm:"primaryKey"`
+	ObservedAt time.Time
+}
+
+// RecordCompletion durably records the completion of path observed at at.
+// A completion already recorded keeps its first observation time.
+func (s *Storage) RecordCompletion(path string, at time.Time) error {
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Completion{Path: path, ObservedAt: at}).Error
+	if err != nil {
+		return fmt.Errorf("record completion: %w", err)
+	}
+	return nil
+}
+
+// DeleteCompletion forgets the completion of path
+func (s *Storage) DeleteCompletion(path string) error {
+	if err := s.db.Where("path = ?", path).Delete(&Completion{}).Error; err != nil {
+		return fmt.Errorf("delete completion: %w", err)
+	}
+	return nil
+}
+
+// CompletedPaths returns every recorded completion, oldest first
+func (s *Storage) CompletedPaths() ([]string, error) {
+	var paths []string
+	if err := s.db.Model(&Completion{}).Order("observed_at").Order("path").Pluck("path", &paths).Error; err != nil {
+		return nil, fmt.Errorf("list completions: %w", err)
+	}
+	return paths, nil
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompletions(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Now()
+	for i, path := range []string{"/input/b.csv", "/input/a.csv"} {
+		if err := store.RecordCompletion(path, base.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("RecordCompletion(%s) error = %v", path, err)
+		}
+	}
+	// A repeated observation keeps the first one
+	if err := store.RecordCompletion("/input/b.csv", base.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordCompletion() again error = %v", err)
+	}
+
+	paths, err := store.CompletedPaths()
+	if err != nil {
+		t.Fatalf("CompletedPaths() error = %v", err)
+	}
+	if want := []string{"/input/b.csv", "/input/a.csv"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("CompletedPaths() = %v, want %v", paths, want)
+	}
+
+	if err := store.DeleteCompletion("/input/b.csv"); err != nil {
+		t.Fatalf("DeleteCompletion() error = %v", err)
+	}
+	if err := store.DeleteCompletion("/input/missing.csv"); err != nil {
+		t.Fatalf("DeleteCompletion() of a missing path error = %v", err)
+	}
+	if paths, _ = store.CompletedPaths(); !reflect.DeepEqual(paths, []string{"/input/a.csv"}) {
+		t.Errorf("CompletedPaths() after delete = %v", paths)
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// configHistoryKey is the meta key holding the configurations of the latest
+// runs
+const configHistoryKey = "config_history"
+
+// ConfigRecord is the effective configuration a run started with, sensitive
+// values redacted
+type ConfigRecord struct {
+	RecordedAt time.Time         `json:"recorded_at"`
+	Host       string            `json:"host,omitempty"`
+	PID        int               `json:"pid,omitempty"`
+	Config     map[string]string `json:"config"`
+}
+
+// RecordConfig appends rec to the configuration history, keeping the latest
+// keep records, and returns the record of the previous run, nil on the
+// first one
+func (s *Storage) RecordConfig(rec ConfigRecord, keep int) (*ConfigRecord, error) {
+	rec.RecordedAt = rec.RecordedAt.UTC()
+	var previous *ConfigRecord
+	err := s.Transaction(func(tx *Storage) error {
+		var history []ConfigRecord
+		if _, err := tx.getMeta(configHistoryKey, &history); err != nil {
+			return err
+		}
+		if n := len(history); n > 0 {
+			previous = &history[n-1]
+		}
+		history = append(history, rec)
+		if keep > 0 && len(history) > keep {
+			history = history[len(history)-keep:]
+		}
+		return tx.putMeta(configHistoryKey, history)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("record configuration: %w", err)
+	}
+	return previous, nil
+}
+
+// ConfigHistory returns the configurations of the latest runs, oldest first
+func (q queries) ConfigHistory() ([]ConfigRecord, error) {
+	var meta Meta
+	err := q.db.Where("key = ?", configHistoryKey).First(&meta).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query configuration history: %w", err)
+	}
+	var history []ConfigRecord
+	if err := json.Unmarshal([]byte(meta.Value), &history); err != nil {
+		return nil, fmt.Errorf("decode configuration history: %w", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordConfig(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if history, err := store.ConfigHistory(); err != nil || len(history) != 0 {
+		t.Fatalf("ConfigHistory() = %+v, %v, want none", history, err)
+	}
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, window := range []string{"10", "2", "5"} {
+		previous, err := store.RecordConfig(ConfigRecord{
+			RecordedAt: start.Add(time.Duration(i) * time.Hour),
+			Config:     map[string]string{"stability_seconds": window},
+		}, 2)
+		if err != nil {
+			t.Fatalf("RecordConfig(%d) error = %v", i, err)
+		}
+		switch {
+		case i == 0 && previous != nil:
+			t.Errorf("first RecordConfig() previous = %+v, want nil", previous)
+		case i == 1 && (previous == nil || previous.Config["stability_seconds"] != "10"):
+			t.Errorf("second RecordConfig() previous = %+v, want the first run", previous)
+		}
+	}
+
+	history, err := store.ConfigHistory()
+	if err != nil {
+		t.Fatalf("ConfigHistory() error = %v", err)
+	}
+	if len(history) != 2 || history[0].Config["stability_seconds"] != "2" || history[1].Config["stability_seconds"] != "5" {
+		t.Fatalf("ConfigHistory() = %+v, want the latest 2 runs, oldest first", history)
+	}
+	if !history[1].RecordedAt.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("RecordedAt = %v, want %v", history[1].RecordedAt, start.Add(2*time.Hour))
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrDatabaseCorrupt wraps the errors of a state database whose file is
+// damaged, such as after an unclean failover of the volume it is on. Such
+// errors never go away by retrying.
+var ErrDatabaseCorrupt = errors.New("state database corrupt")
+
+// corruptMessages are the SQLite errors, SQLITE_CORRUPT and SQLITE_NOTADB,
+// of a damaged database file
+var corruptMessages = []string{
+	"database disk image is malformed",
+	"file is not a database",
+	"malformed database schema",
+}
+
+// databaseFileSuffixes are the files of an SQLite database in WAL mode
+var databaseFileSuffixes = []string{"", "-wal", "-shm"}
+
+// defaultMaxIdleConns is the idle connection limit of database/sql, which
+// openDatabase leaves as it is
+const defaultMaxIdleConns = 2
+
+// IsCorrupt reports whether err is ErrDatabaseCorrupt or an SQLite error of
+// a damaged database file
+func IsCorrupt(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrDatabaseCorrupt) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range corruptMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnCorrupt calls fn with every error of a statement failing on a damaged
+// database file, and wraps that error in ErrDatabaseCorrupt for the caller.
+// It must be called before the storage is used concurrently.
+func (s *Storage) OnCorrupt(fn func(error)) error {
+	hook := func(db *gorm.DB) {
+		if db.Error != nil && !errors.Is(db.Error, ErrDatabaseCorrupt) && IsCorrupt(db.Error) {
+			db.Error = fmt.Errorf("%w: %w", ErrDatabaseCorrupt, db.Error)
+			fn(db.Error)
+		}
+	}
+	cb := s.db.Callback()
+	return errors.Join(
+		cb.Create().After("*").Register("storage:corrupt_create", hook),
+		cb.Query().After("*").Register("storage:corrupt_query", hook),
+		cb.Update().After("*").Register("storage:corrupt_update", hook),
+		cb.Delete().After("*").Register("storage:corrupt_delete", hook),
+		cb.Row().After("*").Register("storage:corrupt_row", hook),
+		cb.Raw().After("*").Register("storage:corrupt_raw", hook),
+	)
+}
+
+// MoveAside renames the database file at path, with its write-ahead log and
+// shared memory, to aside and migrates a fresh database in its place. Idle
+// connections to the old file are closed first; connections in use finish
+// on it, so callers stop the writers beforehand.
+func (s *Storage) MoveAside(path, aside string) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	// Connections returned to the pool close until the fresh database is
+	// migrated
+	sqlDB.SetMaxIdleConns(0)
+	defer sqlDB.SetMaxIdleConns(defaultMaxIdleConns)
+
+	for _, suffix := range databaseFileSuffixes {
+		err := os.Rename(path+suffix, aside+suffix)
+		if err != nil && !(suffix != "" && errors.Is(err, fs.ErrNotExist)) {
+			return fmt.Errorf("move %s aside: %w", path+suffix, err)
+		}
+	}
+	if err := s.Migrate(); err != nil {
+		return fmt.Errorf("migrate fresh database %s: %w", path, err)
+	}
+	return nil
+}
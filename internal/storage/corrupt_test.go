@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestIsCorrupt(t *testing.T) {
+	tests := map[string]bool{
+		"database disk image is malformed":             true,
+		"file is not a database":                       true,
+		"malformed database schema (files) - near 'x'": true,
+		"database is locked":                           false,
+		"no such table: files":                         false,
+	}
+	for msg, want := range tests {
+		if got := IsCorrupt(errors.New(msg)); got != want {
+			t.Errorf("IsCorrupt(%q) = %v, want %v", msg, got, want)
+		}
+	}
+	if IsCorrupt(nil) || !IsCorrupt(ErrDatabaseCorrupt) {
+		t.Error("IsCorrupt() misclassifies nil or ErrDatabaseCorrupt")
+	}
+}
+
+func TestOnCorrupt(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var observed []error
+	if err := store.OnCorrupt(func(err error) { observed = append(observed, err) }); err != nil {
+		t.Fatalf("OnCorrupt() error = %v", err)
+	}
+	if _, err := store.FileExistsSince("abc", time.Time{}); err != nil || len(observed) != 0 {
+		t.Fatalf("FileExistsSince() error = %v, observed %v, want a healthy query", err, observed)
+	}
+
+	// The driver fails every query from now on as it would on a damaged file
+	inject := func(db *gorm.DB) { _ = db.AddError(errors.New("database disk image is malformed")) }
+	if err := store.db.Callback().Query().Before("gorm:query").Register("test:corrupt", inject); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.FileExistsSince("abc", time.Time{})
+	if !errors.Is(err, ErrDatabaseCorrupt) {
+		t.Errorf("FileExistsSince() error = %v, want ErrDatabaseCorrupt", err)
+	}
+	if len(observed) != 1 || !errors.Is(observed[0], ErrDatabaseCorrupt) {
+		t.Errorf("observed %v, want the corruption error", observed)
+	}
+}
+
+func TestMoveAside(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.db")
+	db, err := gorm.Open(sqlite.Open(DSN(path)), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	store := New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "old", Status: status.Ingested, ProcessedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	aside := path + ".corrupt"
+	if err := store.MoveAside(path, aside); err != nil {
+		t.Fatalf("MoveAside() error = %v", err)
+	}
+	if _, err := os.Stat(aside); err != nil {
+		t.Errorf("database not moved aside: %v", err)
+	}
+	if exists, err := store.FileExistsSince("old", time.Time{}); err != nil || exists {
+		t.Errorf("FileExistsSince() = %v, %v, want a fresh database", exists, err)
+	}
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "new", Status: status.Ingested, ProcessedAt: time.Now()}); err != nil {
+		t.Errorf("fresh database not migrated: %v", err)
+	}
+}
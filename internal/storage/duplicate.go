@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Duplicate records an arrival skipped because its content was already
+// ingested, for the duplicate digest. The original is copied into the row
+// so the digest still names it after its record is deleted.
+type Duplicate struct {
+	ID uint `gorm:"primaryKey"`
+	// DetectedAt leads both indexes, which cover the range scans of
+	// DuplicateStats grouped by source and by original
+	DetectedAt time.Time `gorm:"not null;index:idx_duplicates_detected_source,priority:1;index:idx_duplicates_detected_original,priority:1"`
+	// Source is the top-level directory of the arrival below the input
+	// directory, "." for files directly in it
+	Source string `gorm:"index:idx_duplicates_detected_source,priority:2"`
+	Path   string
+	SHA256 string
+	Size   int64
+	// Dedup is the manifest dedup method that matched the arrival
+	Dedup string
+
+	OriginalSHA256 string `gorm:"index:idx_duplicates_detected_original,priority:2"`
+	OriginalPath   string
+	// Count is how many arrivals the row stands for: 1 for a single
+	// duplicate, more for the summary of a resend storm, whose Size totals
+	// them and whose Path is the source directory
+	Count int64 `gorm:"not null;default:1"`
+}
+
+// DuplicateRecord holds the fields of a skipped duplicate
+type DuplicateRecord struct {
+	DetectedAt time.Time
+	Source     string
+	Path       string
+	SHA256     string
+	Size       int64
+	Dedup      string
+	// Original is the record the arrival duplicated, nil for a summary
+	Original *File
+	// Count is the arrivals a summary stands for; 0 records one
+	Count int64
+}
+
+// RecordDuplicate stores a skipped duplicate
+func (s *Storage) RecordDuplicate(rec DuplicateRecord) error {
+	dup := Duplicate{
+		DetectedAt: rec.DetectedAt.UTC(),
+		Source:     rec.Source,
+		Path:       rec.Path,
+		SHA256:     rec.SHA256,
+		Size:       rec.Size,
+		Dedup:      rec.Dedup,
+		Count:      max(rec.Count, 1),
+	}
+	if rec.Original != nil {
+		dup.OriginalSHA256 = rec.Original.SHA256
+		dup.OriginalPath = rec.Original.DestPath
+	}
+	if err := s.db.Create(&dup).Error; err != nil {
+		return fmt.Errorf("record duplicate: %w", err)
+	}
+	return nil
+}
+
+// DuplicateSummary aggregates the duplicates detected in a period
+type DuplicateSummary struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Duplicates and Bytes total every duplicate of the period
+	Duplicates int64 `json:"duplicates"`
+	Bytes      int64 `json:"bytes"`
+	// Originals are the most duplicated originals, by duplicate count
+	Originals []OriginalDuplicates `json:"originals"`
+	// Sources holds every source that sent a duplicate or had a file
+	// ingested in the period, by duplicated bytes
+	Sources []SourceDuplicates `json:"sources"`
+}
+
+// OriginalDuplicates counts the duplicates of one original
+type OriginalDuplicates struct {
+	SHA256     string `json:"sha256"`
+	DestPath   string `json:"dest_path"`
+	Duplicates int64  `json:"duplicates"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// SourceDuplicates counts the duplicates one source sent, next to the files
+// of that source ingested in the same period
+type SourceDuplicates struct {
+	Source        string `json:"source"`
+	Duplicates    int64  `json:"duplicates"`
+	Bytes         int64  `json:"bytes"`
+	Ingested      int64  `json:"ingested"`
+	IngestedBytes int64  `json:"ingested_bytes"`
+}
+
+// DuplicateStats aggregates the duplicates detected in [from, to): the top
+// originals by duplicate count, at most top of them or all when top is not
+// positive, and every source, including those with no duplicates. Ties are
+// broken by bytes, then by hash or name, so the result is stable.
+func (q queries) DuplicateStats(from, to time.Time, top int) (*DuplicateSummary, error) {
+	from, to = from.UTC(), to.UTC()
+	summary := &DuplicateSummary{From: from, To: to, Originals: []OriginalDuplicates{}, Sources: []SourceDuplicates{}}
+	inRange := func() *gorm.DB {
+		return q.db.Model(&Duplicate{}).Where("detected_at >= ? AND detected_at < ?", from, to)
+	}
+
+	var total struct {
+		Duplicates int64
+		Bytes      int64
+	}
+	if err := inRange().
+		Select("COALESCE(sum(count), 0) AS duplicates, COALESCE(sum(size), 0) AS bytes").
+		Scan(&total).Error; err != nil {
+		return nil, fmt.Errorf("total duplicates: %w", err)
+	}
+	summary.Duplicates, summary.Bytes = total.Duplicates, total.Bytes
+
+	// Summaries stand for many originals and name none
+	originals := inRange().
+		Where("original_sha256 <> ?", "").
+		Select("original_sha256 AS sha256, max(original_path) AS dest_path, sum(count) AS duplicates, COALESCE(sum(size), 0) AS bytes").
+		Group("original_sha256").
+		Order("duplicates DESC").Order("bytes DESC").Order("original_sha256")
+	if top > 0 {
+		originals = originals.Limit(top)
+	}
+	if err := originals.Scan(&summary.Originals).Error; err != nil {
+		return nil, fmt.Errorf("duplicates by original: %w", err)
+	}
+
+	var dups []SourceDuplicates
+	if err := inRange().
+		Select("source, sum(count) AS duplicates, COALESCE(sum(size), 0) AS bytes").
+		Group("source").
+		Scan(&dups).Error; err != nil {
+		return nil, fmt.Errorf("duplicates by source: %w", err)
+	}
+	var ingested []SourceDuplicates
+	if err := q.db.Model(&File{}).
+		Select("source, count(*) AS ingested, COALESCE(sum(size), 0) AS ingested_bytes").
+		Where("processed_at >= ? AND processed_at < ?", from, to).
+		Group("source").
+		Scan(&ingested).Error; err != nil {
+		return nil, fmt.Errorf("ingested files by source: %w", err)
+	}
+
+	bySource := make(map[string]*SourceDuplicates)
+	for _, row := range append(dups, ingested...) {
+		s, ok := bySource[row.Source]
+		if !ok {
+			s = &SourceDuplicates{Source: row.Source}
+			bySource[row.Source] = s
+		}
+		s.Duplicates += row.Duplicates
+		s.Bytes += row.Bytes
+		s.Ingested += row.Ingested
+		s.IngestedBytes += row.IngestedBytes
+	}
+	for _, s := range bySource {
+		summary.Sources = append(summary.Sources, *s)
+	}
+	sort.Slice(summary.Sources, func(i, j int) bool {
+		a, b := summary.Sources[i], summary.Sources[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Duplicates != b.Duplicates {
+			return a.Duplicates > b.Duplicates
+		}
+		return a.Source < b.Source
+	})
+	return summary, nil
+}
+
+// digestKey is the meta key holding when the last scheduled digest was sent
+const digestKey = "digest_sent_at"
+
+// LastDigest returns when the last scheduled digest was enqueued, or the zero
+// time when none was
+func (s *Storage) LastDigest() (time.Time, error) {
+	var meta Meta
+	err := s.db.Where("key = ?", digestKey).First(&meta).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query last digest: %w", err)
+	}
+	at, err := time.Parse(time.RFC3339Nano, meta.Value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse last digest time %q: %w", meta.Value, err)
+	}
+	return at, nil
+}
+
+// EnqueueDigest writes the outbox message of the digest sent at at and
+// records at as the last digest in one transaction, so a restart neither
+// repeats the digest nor skips it
+func (s *Storage) EnqueueDigest(msg *OutboxMessage, at time.Time) error {
+	return s.Transaction(func(tx *Storage) error {
+		if err := tx.EnqueueOutbox(msg); err != nil {
+			return err
+		}
+		err := tx.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Meta{
+			Key:   digestKey,
+			Value: at.UTC().Format(time.RFC3339Nano),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("record last digest: %w", err)
+		}
+		return nil
+	})
+}
+
+// SourceOf returns the source of a path relative to the input directory:
+// its top-level directory, or "." for a file directly in the input
+// directory
+func SourceOf(relPath string) string {
+	source, _, found := strings.Cut(relPath, "/")
+	if !found || source == "" || source == "." || source == ".." {
+		return "."
+	}
+	return source
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestDuplicateStats(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+
+	// acme and globex resend, initech only sends new files
+	originals := map[string]*File{}
+	for i, rel := range []string{"acme/orders.csv", "acme/prices.csv", "globex/feed.csv", "initech/a.csv", "initech/b.csv", "root.csv"} {
+		sha := fmt.Sprintf("sha-%d", i)
+		_, _, err := store.CreateFileIfAbsent(FileRecord{
+			SHA256: sha, Name: rel, Size: 100, Status: status.Ingested,
+			DestPath: "/warehouse/" + rel, ProcessedAt: from.Add(time.Hour), RelPath: rel,
+		})
+		if err != nil {
+			t.Fatalf("CreateFileIfAbsent(%s) error = %v", rel, err)
+		}
+		originals[rel], _ = store.FindBySHA256(sha)
+	}
+	// Ingested outside the period
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "late", Size: 1, ProcessedAt: to, RelPath: "umbrella/x.csv"}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+
+	record := func(at time.Time, source, original string, size int64) {
+		t.Helper()
+		err := store.RecordDuplicate(DuplicateRecord{
+			DetectedAt: at, Source: source, Path: "/input/" + original, SHA256: originals[original].SHA256,
+			Size: size, Dedup: "hash", Original: originals[original],
+		})
+		if err != nil {
+			t.Fatalf("RecordDuplicate() error = %v", err)
+		}
+	}
+	for i := range 3 {
+		record(from.Add(time.Duration(i)*time.Hour), "acme", "acme/orders.csv", 100)
+	}
+	record(from.Add(time.Hour), "acme", "acme/prices.csv", 100)
+	record(from.Add(2*time.Hour), "globex", "globex/feed.csv", 1000)
+	record(from.Add(3*time.Hour), "globex", "globex/feed.csv", 1000)
+	// A resend storm summary counts its duplicates but names no original
+	if err := store.RecordDuplicate(DuplicateRecord{
+		DetectedAt: from.Add(4 * time.Hour), Source: "acme", Path: "/input/acme", Size: 500, Count: 5,
+	}); err != nil {
+		t.Fatalf("RecordDuplicate(summary) error = %v", err)
+	}
+	// Before and at the end of the period, so not counted
+	record(from.Add(-time.Second), "acme", "acme/orders.csv", 100)
+	record(to, "globex", "globex/feed.csv", 1000)
+
+	got, err := store.DuplicateStats(from, to, 2)
+	if err != nil {
+		t.Fatalf("DuplicateStats() error = %v", err)
+	}
+	if got.Duplicates != 11 || got.Bytes != 2900 {
+		t.Errorf("totals = %d duplicates, %d bytes; want 11, 2900", got.Duplicates, got.Bytes)
+	}
+	wantOriginals := []OriginalDuplicates{
+		{SHA256: "sha-0", DestPath: "/warehouse/acme/orders.csv", Duplicates: 3, Bytes: 300},
+		{SHA256: "sha-2", DestPath: "/warehouse/globex/feed.csv", Duplicates: 2, Bytes: 2000},
+	}
+	if !reflect.DeepEqual(got.Originals, wantOriginals) {
+		t.Errorf("Originals = %+v, want %+v", got.Originals, wantOriginals)
+	}
+	wantSources := []SourceDuplicates{
+		{Source: "globex", Duplicates: 2, Bytes: 2000, Ingested: 1, IngestedBytes: 100},
+		{Source: "acme", Duplicates: 9, Bytes: 900, Ingested: 2, IngestedBytes: 200},
+		{Source: ".", Ingested: 1, IngestedBytes: 100},
+		{Source: "initech", Ingested: 2, IngestedBytes: 200},
+	}
+	if !reflect.DeepEqual(got.Sources, wantSources) {
+		t.Errorf("Sources = %+v, want %+v", got.Sources, wantSources)
+	}
+
+	// Every original when top is not positive, and nothing in an empty
+	// period
+	if all, err := store.DuplicateStats(from, to, 0); err != nil || len(all.Originals) != 3 {
+		t.Errorf("DuplicateStats(top 0) = %+v, %v; want all 3 originals", all, err)
+	}
+	empty, err := store.DuplicateStats(to.AddDate(1, 0, 0), to.AddDate(1, 0, 7), 10)
+	if err != nil || empty.Duplicates != 0 || empty.Bytes != 0 || len(empty.Originals) != 0 || len(empty.Sources) != 0 {
+		t.Errorf("DuplicateStats(empty period) = %+v, %v; want nothing", empty, err)
+	}
+}
+
+func TestSourceOf(t *testing.T) {
+	for relPath, want := range map[string]string{
+		"acme/2024/a.csv": "acme",
+		"acme/a.csv":      "acme",
+		"a.csv":           ".",
+		"":                ".",
+		"../a/b.csv":      ".",
+	} {
+		if got := SourceOf(relPath); got != want {
+			t.Errorf("SourceOf(%q) = %q, want %q", relPath, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoHold is returned when releasing a hold that does not exist
+var ErrNoHold = errors.New("no such held file")
+
+// HeldFile is a file kept out of the warehouse until an operator releases
+// it, or the hold times out, because it arrived much smaller than the file
+// last ingested from the same path
+type HeldFile struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// Path is the source path of the file, held once
+	Path    string `gorm:"uniqueIndex" json:"path"`
+	RelPath string `json:"rel_path"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+	// The Previous fields describe the record the file shrank from
+	PreviousSHA256 string    `json:"previous_sha256"`
+	PreviousSize   int64     `json:"previous_size"`
+	PreviousDest   string    `json:"previous_destination"`
+	HeldAt         time.Time `gorm:"index" json:"held_at"`
+	// ReleasedAt is set once an operator forces the file in
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+	ReleasedBy string     `json:"released_by,omitempty"`
+}
+
+// HoldFile records the hold of h.Path, replacing an earlier hold of the same
+// path, which is stale once the file changed
+func (s *Storage) HoldFile(h *HeldFile) error {
+	h.HeldAt = h.HeldAt.UTC()
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "path"}},
+		UpdateAll: true,
+	}).Create(h).Error
+	if err != nil {
+		return fmt.Errorf("hold file %s: %w", h.Path, err)
+	}
+	return nil
+}
+
+// FindHold returns the hold of path, or nil when the file is not held
+func (q queries) FindHold(path string) (*HeldFile, error) {
+	var h HeldFile
+	err := q.db.Where("path = ?", path).First(&h).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query hold of %s: %w", path, err)
+	}
+	return &h, nil
+}
+
+// HeldFiles returns every held file, oldest first
+func (s *Storage) HeldFiles() ([]HeldFile, error) {
+	var holds []HeldFile
+	if err := s.db.Order("held_at").Order("id").Find(&holds).Error; err != nil {
+		return nil, fmt.Errorf("list held files: %w", err)
+	}
+	return holds, nil
+}
+
+// ReleaseHold forces the file held as id in, signed off by by, and returns
+// its hold. It fails with ErrNoHold when there is no such hold.
+func (s *Storage) ReleaseHold(id uint, by string, at time.Time) (*HeldFile, error) {
+	var h HeldFile
+	err := s.Transaction(func(tx *Storage) error {
+		if err := tx.db.First(&h, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNoHold
+			}
+			return fmt.Errorf("query hold %d: %w", id, err)
+		}
+		at = at.UTC()
+		h.ReleasedAt, h.ReleasedBy = &at, by
+		if err := tx.db.Save(&h).Error; err != nil {
+			return fmt.Errorf("release hold %d: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// DeleteHold forgets the hold of path
+func (s *Storage) DeleteHold(path string) error {
+	if err := s.db.Where("path = ?", path).Delete(&HeldFile{}).Error; err != nil {
+		return fmt.Errorf("delete hold of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHeldFiles(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Now()
+	hold := &HeldFile{Path: "/in/a.csv", RelPath: "a.csv", SHA256: "short", Size: 10, PreviousSHA256: "long", PreviousSize: 100, HeldAt: base}
+	if err := store.HoldFile(hold); err != nil {
+		t.Fatalf("HoldFile() error = %v", err)
+	}
+	// The file changed again: its new hold replaces the first
+	if err := store.HoldFile(&HeldFile{Path: "/in/a.csv", RelPath: "a.csv", SHA256: "shorter", Size: 5, PreviousSHA256: "long", PreviousSize: 100, HeldAt: base.Add(time.Minute)}); err != nil {
+		t.Fatalf("HoldFile() again error = %v", err)
+	}
+
+	holds, err := store.HeldFiles()
+	if err != nil {
+		t.Fatalf("HeldFiles() error = %v", err)
+	}
+	if len(holds) != 1 || holds[0].SHA256 != "shorter" || holds[0].ReleasedAt != nil {
+		t.Fatalf("HeldFiles() = %+v, want the second hold only", holds)
+	}
+
+	released, err := store.ReleaseHold(holds[0].ID, "ops", base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ReleaseHold() error = %v", err)
+	}
+	if released.ReleasedAt == nil || released.ReleasedBy != "ops" {
+		t.Errorf("ReleaseHold() = %+v, want it signed off by ops", released)
+	}
+	got, err := store.FindHold("/in/a.csv")
+	if err != nil || got == nil || got.ReleasedAt == nil || !got.ReleasedAt.Equal(base.Add(time.Hour).UTC()) {
+		t.Errorf("FindHold() = %+v, %v, want the release recorded", got, err)
+	}
+	if _, err := store.ReleaseHold(holds[0].ID+1, "ops", base); !errors.Is(err, ErrNoHold) {
+		t.Errorf("ReleaseHold(unknown) error = %v, want ErrNoHold", err)
+	}
+
+	if err := store.DeleteHold("/in/a.csv"); err != nil {
+		t.Fatalf("DeleteHold() error = %v", err)
+	}
+	if got, err := store.FindHold("/in/a.csv"); err != nil || got != nil {
+		t.Errorf("FindHold() after DeleteHold = %+v, %v, want nil", got, err)
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maintenanceKey is the meta key holding the maintenance lock
+const maintenanceKey = "maintenance_lock"
+
+// ErrMaintenanceActive is returned when another process holds the maintenance lock
+var ErrMaintenanceActive = errors.New("maintenance already in progress")
+
+// Meta is a small key/value table for process-wide state
+type Meta struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// MaintenanceLock describes the holder of the maintenance lock
+type MaintenanceLock struct {
+	Owner     string    `json:"owner"`
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Maintenance is a held maintenance lock. The daemon pauses writes while it
+// is held; release it with End.
+type Maintenance struct {
+	storage *Storage
+	lock    MaintenanceLock
+}
+
+// BeginMaintenance takes the advisory maintenance lock for owner. The lock
+// expires after ttl so a crashed maintenance command cannot pause the daemon
+// forever; an expired lock is taken over.
+func (s *Storage) BeginMaintenance(owner string, ttl time.Duration) (*Maintenance, error) {
+	host, _ := os.Hostname()
+	now := time.Now()
+	lock := MaintenanceLock{
+		Owner:     owner,
+		PID:       os.Getpid(),
+		Host:      host,
+		StartedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return nil, fmt.Errorf("encode maintenance lock: %w", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		current, err := New(tx).MaintenanceStatus()
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return fmt.Errorf("%w: held by %s (pid %d on %s) since %s",
+				ErrMaintenanceActive, current.Owner, current.PID, current.Host, current.StartedAt.Format(time.RFC3339))
+		}
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Meta{
+			Key:   maintenanceKey,
+			Value: string(data),
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrMaintenanceActive) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("take maintenance lock: %w", err)
+	}
+
+	return &Maintenance{storage: s, lock: lock}, nil
+}
+
+// MaintenanceStatus returns the current unexpired maintenance lock, or nil
+// when no maintenance is running
+func (s *Storage) MaintenanceStatus() (*MaintenanceLock, error) {
+	var meta Meta
+	err := s.db.Where("key = ?", maintenanceKey).First(&meta).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query maintenance lock: %w", err)
+	}
+
+	var lock MaintenanceLock
+	if err := json.Unmarshal([]byte(meta.Value), &lock); err != nil {
+		return nil, fmt.Errorf("decode maintenance lock: %w", err)
+	}
+	if time.Now().After(lock.ExpiresAt) {
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+// End releases the maintenance lock if it is still ours
+func (m *Maintenance) End() error {
+	current, err := m.storage.MaintenanceStatus()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.PID != m.lock.PID || !current.StartedAt.Equal(m.lock.StartedAt) {
+		return nil
+	}
+	if err := m.storage.db.Where("key = ?", maintenanceKey).Delete(&Meta{}).Error; err != nil {
+		return fmt.Errorf("release maintenance lock: %w", err)
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of the SQLite database to dest
+func (s *Storage) Backup(dest string) error {
+	if err := s.db.Exec("VACUUM INTO ?", dest).Error; err != nil {
+		return fmt.Errorf("backup database: %w", err)
+	}
+	return nil
+}
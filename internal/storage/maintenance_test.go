@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBeginMaintenance(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	lock, err := store.MaintenanceStatus()
+	if err != nil {
+		t.Fatalf("MaintenanceStatus failed: %v", err)
+	}
+	if lock != nil {
+		t.Fatalf("expected no maintenance lock, got %+v", lock)
+	}
+
+	m, err := store.BeginMaintenance("backup", time.Hour)
+	if err != nil {
+		t.Fatalf("BeginMaintenance failed: %v", err)
+	}
+
+	lock, err = store.MaintenanceStatus()
+	if err != nil {
+		t.Fatalf("MaintenanceStatus failed: %v", err)
+	}
+	if lock == nil || lock.Owner != "backup" {
+		t.Fatalf("MaintenanceStatus = %+v, want lock owned by backup", lock)
+	}
+
+	// A second maintenance command must be refused
+	if _, err := store.BeginMaintenance("prune", time.Hour); !errors.Is(err, ErrMaintenanceActive) {
+		t.Errorf("second BeginMaintenance error = %v, want ErrMaintenanceActive", err)
+	}
+
+	if err := m.End(); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+
+	lock, err = store.MaintenanceStatus()
+	if err != nil {
+		t.Fatalf("MaintenanceStatus failed: %v", err)
+	}
+	if lock != nil {
+		t.Errorf("expected lock to be released, got %+v", lock)
+	}
+
+	// End is idempotent
+	if err := m.End(); err != nil {
+		t.Errorf("second End failed: %v", err)
+	}
+}
+
+func TestBeginMaintenance_ExpiredLockIsTakenOver(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	stale, err := store.BeginMaintenance("crashed", -time.Second)
+	if err != nil {
+		t.Fatalf("BeginMaintenance failed: %v", err)
+	}
+
+	lock, err := store.MaintenanceStatus()
+	if err != nil {
+		t.Fatalf("MaintenanceStatus failed: %v", err)
+	}
+	if lock != nil {
+		t.Errorf("expired lock should not be reported, got %+v", lock)
+	}
+
+	m, err := store.BeginMaintenance("backup", time.Hour)
+	if err != nil {
+		t.Fatalf("BeginMaintenance over expired lock failed: %v", err)
+	}
+
+	// Releasing the stale handle must not drop the new holder's lock
+	if err := stale.End(); err != nil {
+		t.Fatalf("stale End failed: %v", err)
+	}
+	lock, err = store.MaintenanceStatus()
+	if err != nil {
+		t.Fatalf("MaintenanceStatus failed: %v", err)
+	}
+	if lock == nil || lock.Owner != "backup" {
+		t.Errorf("MaintenanceStatus = %+v, want lock owned by backup", lock)
+	}
+
+	if err := m.End(); err != nil {
+		t.Errorf("End failed: %v", err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.CreateFile("backup123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	if err := store.Backup(dest); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	ro, err := OpenReadOnly(dest)
+	if err != nil {
+		t.Fatalf("OpenReadOnly on backup failed: %v", err)
+	}
+	defer func() { _ = ro.Close() }()
+
+	exists, err := ro.FileExistsSince("backup123", time.Time{})
+	if err != nil {
+		t.Fatalf("FileExistsSince failed: %v", err)
+	}
+	if !exists {
+		t.Error("backup should contain the record")
+	}
+}
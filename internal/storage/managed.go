@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// ManagedDir is a directory the ingestor created at startup rather than
+// found, so cleanup tooling can tell the paths it manages from those that
+// existed before it
+type ManagedDir struct {
+	Path string `gorm:"primaryKey"`
+	// Role names the directory by its flag, e.g. "warehouse"
+	Role      string
+	CreatedAt time.Time
+}
+
+// RecordManagedDir records that the directory at path was created at at. A
+// directory already recorded keeps its first record.
+func (s *Storage) RecordManagedDir(path, role string, at time.Time) error {
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ManagedDir{Path: path, Role: role, CreatedAt: at.UTC()}).Error
+	if err != nil {
+		return fmt.Errorf("record managed directory: %w", err)
+	}
+	return nil
+}
+
+// ManagedDirs returns every directory the ingestor created, oldest first
+func (s *Storage) ManagedDirs() ([]ManagedDir, error) {
+	var dirs []ManagedDir
+	if err := s.db.Order("created_at").Order("path").Find(&dirs).Error; err != nil {
+		return nil, fmt.Errorf("list managed directories: %w", err)
+	}
+	return dirs, nil
+}
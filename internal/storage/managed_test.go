@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManagedDirs(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Now()
+	if err := store.RecordManagedDir("/data/warehouse", "warehouse", base); err != nil {
+		t.Fatalf("RecordManagedDir() error = %v", err)
+	}
+	if err := store.RecordManagedDir("/data/manifests", "manifests", base.Add(time.Second)); err != nil {
+		t.Fatalf("RecordManagedDir() error = %v", err)
+	}
+	// Recreating a directory keeps its first record
+	if err := store.RecordManagedDir("/data/warehouse", "warehouse", base.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordManagedDir() again error = %v", err)
+	}
+
+	dirs, err := store.ManagedDirs()
+	if err != nil {
+		t.Fatalf("ManagedDirs() error = %v", err)
+	}
+	if len(dirs) != 2 || dirs[0].Path != "/data/warehouse" || dirs[1].Role != "manifests" {
+		t.Fatalf("ManagedDirs() = %+v", dirs)
+	}
+	if !dirs[0].CreatedAt.Equal(base.UTC()) {
+		t.Errorf("warehouse created at %v, want the first record %v", dirs[0].CreatedAt, base)
+	}
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"gorm.io/gorm"
+)
+
+// ManifestEntry is a manifest entry kept in the database by the database
+// manifest sink, one column per Entry field. Times are stored in UTC so
+// ranges compare the same on every backend.
+type ManifestEntry struct {
+	ID            uint `gorm:"primaryKey"`
+	SchemaVersion int
+	SHA256        string `gorm:"index"`
+	Name          string
+	OriginalName  string
+	SourcePath    string
+	DestPath      string
+	Size          int64
+	ProcessedAt   time.Time `gorm:"index"`
+	Status        status.Status
+	Reason        string
+	Tags          Tags  `gorm:"type:text"`
+	Parts         Parts `gorm:"type:text"`
+
+	Version        int
+	PreviousSHA256 string
+	Dedup          string
+	IdempotencyKey string
+	OriginalSHA256 string
+	SelfTest       bool
+	AllocatedSize  int64
+	Members        int
+	MembersSize    int64
+	SourceRelPath  string
+	Sequence       int64 `gorm:"index"`
+	Restores       string
+	Supersedes     string
+	LinkCount      int
+	Priority       string
+	SourceURL      string
+	Backfilled     bool
+}
+
+// Parts are the input parts of a manifest entry, stored as a JSON array
+type Parts []manifest.Part
+
+// Value implements driver.Valuer
+func (p Parts) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]manifest.Part(p))
+	if err != nil {
+		return nil, fmt.Errorf("encode parts: %w", err)
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (p *Parts) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("scan parts: unsupported type %T", value)
+	}
+	if len(data) == 0 {
+		*p = nil
+		return nil
+	}
+	if err := json.Unmarshal(data, (*[]manifest.Part)(p)); err != nil {
+		return fmt.Errorf("decode parts: %w", err)
+	}
+	return nil
+}
+
+func newManifestEntry(e manifest.Entry) ManifestEntry {
+	return ManifestEntry{
+		SchemaVersion: e.SchemaVersion,
+		SHA256:        e.SHA256,
+		Name:          e.Name,
+		OriginalName:  e.OriginalName,
+		SourcePath:    e.SourcePath,
+		DestPath:      e.DestPath,
+		Size:          e.Size,
+		ProcessedAt:   e.ProcessedAt.UTC(),
+		Status:        e.Status,
+		Reason:        e.Reason,
+		Tags:          e.Tags,
+		Parts:         e.Parts,
+
+		Version:        e.Version,
+		PreviousSHA256: e.PreviousSHA256,
+		Dedup:          e.Dedup,
+		IdempotencyKey: e.IdempotencyKey,
+		OriginalSHA256: e.OriginalSHA256,
+		SelfTest:       e.SelfTest,
+		AllocatedSize:  e.AllocatedSize,
+		Members:        e.Members,
+		MembersSize:    e.MembersSize,
+		SourceRelPath:  e.SourceRelPath,
+		Sequence:       e.Sequence,
+		Restores:       e.Restores,
+		Supersedes:     e.Supersedes,
+		LinkCount:      e.LinkCount,
+		Priority:       e.Priority,
+		SourceURL:      e.SourceURL,
+		Backfilled:     e.Backfilled,
+	}
+}
+
+func (m ManifestEntry) entry() manifest.Entry {
+	return manifest.Entry{
+		SchemaVersion: m.SchemaVersion,
+		SHA256:        m.SHA256,
+		Name:          m.Name,
+		OriginalName:  m.OriginalName,
+		SourcePath:    m.SourcePath,
+		DestPath:      m.DestPath,
+		Size:          m.Size,
+		ProcessedAt:   m.ProcessedAt,
+		Status:        m.Status,
+		Reason:        m.Reason,
+		Tags:          m.Tags,
+		Parts:         m.Parts,
+
+		Version:        m.Version,
+		PreviousSHA256: m.PreviousSHA256,
+		Dedup:          m.Dedup,
+		IdempotencyKey: m.IdempotencyKey,
+		OriginalSHA256: m.OriginalSHA256,
+		SelfTest:       m.SelfTest,
+		AllocatedSize:  m.AllocatedSize,
+		Members:        m.Members,
+		MembersSize:    m.MembersSize,
+		SourceRelPath:  m.SourceRelPath,
+		Sequence:       m.Sequence,
+		Restores:       m.Restores,
+		Supersedes:     m.Supersedes,
+		LinkCount:      m.LinkCount,
+		Priority:       m.Priority,
+		SourceURL:      m.SourceURL,
+		Backfilled:     m.Backfilled,
+	}
+}
+
+// AppendManifestEntries records entries in the manifest_entries table. Call
+// it inside a Transaction to commit the entry of an ingested file with its
+// record.
+func (s *Storage) AppendManifestEntries(entries ...manifest.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	rows := make([]ManifestEntry, len(entries))
+	for i, e := range entries {
+		rows[i] = newManifestEntry(e)
+	}
+	if err := s.db.Create(&rows).Error; err != nil {
+		return fmt.Errorf("append manifest entries: %w", err)
+	}
+	return nil
+}
+
+// ManifestEntries implements manifest.Reader over the manifest_entries
+// table, upgrading each entry like manifest.Decode
+func (q queries) ManifestEntries(from, to time.Time) ([]manifest.Entry, error) {
+	var rows []ManifestEntry
+	err := q.db.Where("processed_at >= ? AND processed_at <= ?", from.UTC(), to.UTC()).
+		Order("processed_at").Order("id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("list manifest entries: %w", err)
+	}
+
+	entries := make([]manifest.Entry, 0, len(rows))
+	for _, row := range rows {
+		entry, err := manifest.Upgrade(row.entry())
+		if err != nil {
+			return entries, fmt.Errorf("manifest entry %d: %w", row.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+	manifest.SortBySequence(entries)
+	return entries, nil
+}
+
+// ReleaseFile deletes the record of a claim whose file never reached the
+// warehouse, together with the ingested manifest entry, the pending entry
+// and the held notification committed with it, releasing the hash so the
+// content can be ingested again
+func (s *Storage) ReleaseFile(sha256 string) error {
+	return s.Transaction(func(tx *Storage) error {
+		file, err := tx.FindBySHA256(sha256)
+		if err != nil || file == nil {
+			return err
+		}
+		if err := tx.committedEntries(file).Delete(&ManifestEntry{}).Error; err != nil {
+			return fmt.Errorf("delete manifest entry: %w", err)
+		}
+		if err := tx.db.Where("sha256 = ? AND ready = ?", sha256, false).Delete(&OutboxMessage{}).Error; err != nil {
+			return fmt.Errorf("delete held outbox message: %w", err)
+		}
+		if err := tx.ClearManifestEntry(sha256); err != nil {
+			return err
+		}
+		return tx.DeleteFile(sha256)
+	})
+}
+
+// committedEntries selects the ingested manifest entries committed with the
+// record of file
+func (s *Storage) committedEntries(file *File) *gorm.DB {
+	return s.db.Model(&ManifestEntry{}).Where("sha256 = ? AND status = ? AND processed_at = ?",
+		file.SHA256, status.Ingested, file.ProcessedAt.UTC())
+}
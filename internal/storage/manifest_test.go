@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestManifestEntries(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Entries come in with local times and nanoseconds, like the processor's
+	base := time.Date(2024, 3, 15, 14, 30, 0, 123456789, time.FixedZone("east", 3*3600))
+	entries := []manifest.Entry{
+		{
+			SchemaVersion: manifest.CurrentSchemaVersion,
+			SHA256:        "aaa",
+			Name:          "a.csv",
+			OriginalName:  "a-long.csv",
+			SourcePath:    "/input/a.csv",
+			DestPath:      "/warehouse/a.csv",
+			Size:          10,
+			ProcessedAt:   base,
+			Status:        status.Ingested,
+			Tags:          map[string]string{"tier": "bulk"},
+			Parts:         []manifest.Part{{Index: 1, Name: "a.csv.001", Size: 10}},
+			Version:       2,
+
+			PreviousSHA256: "zzz",
+			IdempotencyKey: "42",
+			SelfTest:       true,
+			AllocatedSize:  4 << 20,
+			Members:        3,
+			MembersSize:    9,
+			SourceRelPath:  "in/a.csv",
+			Sequence:       7,
+			Restores:       "/warehouse/old/a.csv",
+			Supersedes:     "/warehouse/older/a.csv",
+			LinkCount:      2,
+		},
+		{
+			SchemaVersion:  manifest.CurrentSchemaVersion,
+			SHA256:         "aaa",
+			Name:           "a-copy.csv",
+			SourcePath:     "/input/a-copy.csv",
+			DestPath:       "/warehouse/a.csv",
+			Size:           10,
+			ProcessedAt:    base.Add(time.Minute),
+			Status:         status.Duplicate,
+			Dedup:          manifest.DedupKey,
+			OriginalSHA256: "aaa",
+		},
+		{
+			// Written before schema versions existed
+			Name:        "old.csv",
+			SourcePath:  "/input/old.csv",
+			ProcessedAt: base.Add(time.Hour),
+		},
+	}
+	if err := store.AppendManifestEntries(entries...); err != nil {
+		t.Fatalf("AppendManifestEntries() error = %v", err)
+	}
+
+	got, err := store.ManifestEntries(base, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ManifestEntries() error = %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("ManifestEntries() returned %d entries, want %d", len(got), len(entries))
+	}
+	for i, e := range entries {
+		g := got[i]
+		if !g.ProcessedAt.Equal(e.ProcessedAt) {
+			t.Errorf("entry %d processed_at = %v, want %v", i, g.ProcessedAt, e.ProcessedAt)
+		}
+		if i == 2 {
+			e.SchemaVersion = manifest.CurrentSchemaVersion
+			e.Status = status.Ingested
+		}
+		if g.SHA256 != e.SHA256 || g.Name != e.Name || g.Status != e.Status || g.SchemaVersion != e.SchemaVersion ||
+			g.Dedup != e.Dedup || g.OriginalSHA256 != e.OriginalSHA256 || g.Members != e.Members ||
+			len(g.Parts) != len(e.Parts) || len(g.Tags) != len(e.Tags) || g.AllocatedSize != e.AllocatedSize {
+			t.Errorf("entry %d = %+v, want %+v", i, g, e)
+		}
+	}
+
+	// The range is inclusive at both ends and compares instants, not zones
+	got, err = store.ManifestEntries(base.Add(time.Minute).UTC(), base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ManifestEntries() of one minute error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "a-copy.csv" {
+		t.Errorf("ManifestEntries() of one minute = %+v, want a-copy.csv", got)
+	}
+}
+
+func TestReleaseFile(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Now()
+	rec := FileRecord{SHA256: "claim1", Name: "a.csv", Path: "/input/a.csv", Size: 10, Status: status.Ingested, ProcessedAt: at}
+	entry := manifest.Entry{SHA256: "claim1", Name: "a.csv", Size: 10, ProcessedAt: at, Status: status.Ingested}
+	err := store.Transaction(func(tx *Storage) error {
+		if _, _, err := tx.CreateFileIfAbsent(rec); err != nil {
+			return err
+		}
+		return tx.AppendManifestEntries(entry)
+	})
+	if err != nil {
+		t.Fatalf("claim error = %v", err)
+	}
+	// An earlier ingest of the same content, since purged, keeps its entry
+	earlier := entry
+	earlier.ProcessedAt = at.Add(-time.Hour)
+	if err := store.AppendManifestEntries(earlier); err != nil {
+		t.Fatalf("AppendManifestEntries() error = %v", err)
+	}
+
+	// The final size reaches the committed entry only
+	if err := store.UpdateSize("claim1", 25, 0); err != nil {
+		t.Fatalf("UpdateSize() error = %v", err)
+	}
+	entries, err := store.ManifestEntries(at.Add(-time.Hour), at)
+	if err != nil {
+		t.Fatalf("ManifestEntries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Size != 10 || entries[1].Size != 25 {
+		t.Errorf("entries after UpdateSize = %+v, want sizes 10 and 25", entries)
+	}
+
+	if err := store.ReleaseFile("claim1"); err != nil {
+		t.Fatalf("ReleaseFile() error = %v", err)
+	}
+	if file, _ := store.FindBySHA256("claim1"); file != nil {
+		t.Errorf("record left after ReleaseFile: %+v", file)
+	}
+	entries, _ = store.ManifestEntries(at.Add(-time.Hour), at)
+	if len(entries) != 1 || !entries[0].ProcessedAt.Equal(earlier.ProcessedAt) {
+		t.Errorf("entries after ReleaseFile = %+v, want only the earlier ingest", entries)
+	}
+	if err := store.ReleaseFile("claim1"); err != nil {
+		t.Errorf("ReleaseFile() of a released claim error = %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// migrationLockKey is the meta key holding the migration lock
+const migrationLockKey = "migration_lock"
+
+// Migration lock timing. A lock older than migrationLockTTL was left by a
+// crashed process and is taken over.
+const (
+	migrationLockTTL   = 10 * time.Minute
+	migrationLockWait  = time.Minute
+	migrationLockRetry = 100 * time.Millisecond
+)
+
+// ErrUnknownMigration is returned by MigrateTo for an ID not in the chain
+var ErrUnknownMigration = errors.New("unknown migration")
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// Migration is one step of the schema. The chain only grows: a migration
+// once released is never edited, and a change to a model, such as a new
+// column, comes with a new migration at the end, usually AutoMigrate of
+// that model plus any backfill the new column needs.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(tx *gorm.DB) error
+}
+
+// migrations is the schema, oldest first
+var migrations = []Migration{
+	{
+		ID:          "0001_drop_soft_delete",
+		Description: "purge soft-deleted files and drop files.deleted_at",
+		Up:          dropSoftDelete,
+	},
+	{
+		ID:          "0002_create_tables",
+		Description: "create or complete every table with AutoMigrate",
+		Up: func(tx *gorm.DB) error {
+			for _, model := range []any{&File{}, &Meta{}, &PathSequence{}, &Completion{}, &ManifestEntry{}, &OutboxMessage{}, &ManagedDir{}} {
+				if err := tx.AutoMigrate(model); err != nil {
+					return fmt.Errorf("auto migrate %T: %w", model, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID:          "0003_backfill_file_status",
+		Description: "mark files recorded before statuses existed as ingested",
+		Up: func(tx *gorm.DB) error {
+			return tx.Model(&File{}).
+				Where("status IS NULL OR status = ?", "").
+				Update("status", status.Ingested).Error
+		},
+	},
+	{
+		ID:          "0004_manifest_restores",
+		Description: "add manifest_entries.restores",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ManifestEntry{})
+		},
+	},
+	{
+		ID:          "0005_duplicate_digest",
+		Description: "create duplicates and add files.source for the duplicate digest",
+		Up:          addDuplicateDigest,
+	},
+	{
+		ID:          "0006_attempts",
+		Description: "create attempts for the per-file attempt history",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Attempt{})
+		},
+	},
+	{
+		ID:          "0007_dedup_window",
+		Description: "index files by sha256 and created_at and add manifest_entries.supersedes for the dedup window",
+		Up: func(tx *gorm.DB) error {
+			for _, model := range []any{&File{}, &ManifestEntry{}} {
+				if err := tx.AutoMigrate(model); err != nil {
+					return fmt.Errorf("auto migrate %T: %w", model, err)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID:          "0008_link_count",
+		Description: "add manifest_entries.link_count for warehouse copies hard-linked to their source",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ManifestEntry{})
+		},
+	},
+	{
+		ID:          "0009_duplicate_count",
+		Description: "add duplicates.count for the summaries of resend storms",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Duplicate{})
+		},
+	},
+	{
+		ID:          "0010_priority",
+		Description: "add manifest_entries.priority for the priority class files were dispatched in",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ManifestEntry{})
+		},
+	},
+	{
+		ID:          "0011_source_url",
+		Description: "add manifest_entries.source_url for files downloaded from URL lists",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ManifestEntry{})
+		},
+	},
+	{
+		ID:          "0012_held_files",
+		Description: "create held_files for files held back after shrinking",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&HeldFile{})
+		},
+	},
+	{
+		ID:          "0013_sla_misses",
+		Description: "create sla_misses for deadlines of SLA rules that passed without a file",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&SLAMiss{})
+		},
+	},
+	{
+		ID:          "0014_pending_manifest_entries",
+		Description: "create pending_manifest_entries for manifest entries committed but not yet appended",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&PendingManifestEntry{})
+		},
+	},
+	{
+		ID:          "0015_timings",
+		Description: "create timings for the per-stage breakdown of sampled files",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Timing{})
+		},
+	},
+	{
+		ID:          "0016_manifest_backfilled",
+		Description: "add manifest_entries.backfilled for entries synthesized from file records",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&ManifestEntry{})
+		},
+	},
+}
+
+// MigrationState is a migration of the chain and when it was applied
+type MigrationState struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	// AppliedAt is nil while the migration is pending
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// AutoMigrate brings the database to the latest schema; see Migrate
+func (s *Storage) AutoMigrate() error {
+	return s.Migrate()
+}
+
+// Migrate runs every pending migration. Each migration commits in its own
+// transaction together with its record in schema_migrations, and a lock
+// keeps concurrent processes from running them twice.
+func (s *Storage) Migrate() error {
+	return s.migrate(migrations[len(migrations)-1].ID)
+}
+
+// MigrateTo runs the pending migrations up to and including id
+func (s *Storage) MigrateTo(id string) error {
+	return s.migrate(id)
+}
+
+func (s *Storage) migrate(target string) error {
+	last := -1
+	for i, m := range migrations {
+		if m.ID == target {
+			last = i
+		}
+	}
+	if last < 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownMigration, target)
+	}
+
+	if err := s.db.AutoMigrate(&SchemaMigration{}, &Meta{}); err != nil {
+		return fmt.Errorf("create migration tables: %w", err)
+	}
+	unlock, err := s.lockMigrations()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations[:last+1] {
+		if _, ok := applied[m.ID]; ok {
+			continue
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		slog.Info("applied database migration", "id", m.ID, "description", m.Description)
+	}
+	return nil
+}
+
+// MigrationStatus returns every migration of the chain, applied or not, in
+// order. A database never migrated reports them all pending.
+func (s *Storage) MigrationStatus() ([]MigrationState, error) {
+	applied := make(map[string]time.Time)
+	if s.db.Migrator().HasTable(&SchemaMigration{}) {
+		var err error
+		if applied, err = s.appliedMigrations(); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{ID: m.ID, Description: m.Description}
+		if at, ok := applied[m.ID]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// appliedMigrations returns when each applied migration ran, by ID
+func (s *Storage) appliedMigrations() (map[string]time.Time, error) {
+	var rows []SchemaMigration
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("query applied migrations: %w", err)
+	}
+	applied := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.ID] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// migrationLock describes the holder of the migration lock
+type migrationLock struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// lockMigrations takes the migration lock, waiting up to migrationLockWait
+// for another process to finish, and returns its release
+func (s *Storage) lockMigrations() (func(), error) {
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(migrationLockWait)
+	for {
+		lock := migrationLock{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()}
+		data, err := json.Marshal(lock)
+		if err != nil {
+			return nil, fmt.Errorf("encode migration lock: %w", err)
+		}
+		value := string(data)
+
+		taken, err := s.takeMigrationLock(value)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return func() {
+				if err := s.db.Where("key = ? AND value = ?", migrationLockKey, value).Delete(&Meta{}).Error; err != nil {
+					slog.Error("failed to release migration lock", "error", err)
+				}
+			}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("migration lock still held after %s", migrationLockWait)
+		}
+		time.Sleep(migrationLockRetry)
+	}
+}
+
+// takeMigrationLock stores value as the migration lock unless an unexpired
+// one is held
+func (s *Storage) takeMigrationLock(value string) (bool, error) {
+	taken := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var meta Meta
+		err := tx.Where("key = ?", migrationLockKey).First(&meta).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			var held migrationLock
+			if json.Unmarshal([]byte(meta.Value), &held) == nil && time.Since(held.StartedAt) < migrationLockTTL {
+				return nil
+			}
+			slog.Warn("taking over stale migration lock", "lock", meta.Value)
+		}
+		taken = true
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Meta{
+			Key:   migrationLockKey,
+			Value: value,
+		}).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("take migration lock: %w", err)
+	}
+	return taken, nil
+}
+
+// dropSoftDelete migrates a files table created while File embedded
+// gorm.Model. Soft-deleted rows were invisible to every query yet still
+// blocked their SHA256, so they are purged, making that content ingestable
+// again, and the deleted_at column is dropped.
+func dropSoftDelete(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if !m.HasTable(&File{}) || !m.HasColumn(&File{}, "deleted_at") {
+		return nil
+	}
+
+	if err := tx.Exec("DELETE FROM files WHERE deleted_at IS NOT NULL").Error; err != nil {
+		return fmt.Errorf("purge soft-deleted files: %w", err)
+	}
+	if m.HasIndex(&File{}, "idx_files_deleted_at") {
+		if err := m.DropIndex(&File{}, "idx_files_deleted_at"); err != nil {
+			return fmt.Errorf("drop deleted_at index: %w", err)
+		}
+	}
+	if err := m.DropColumn(&File{}, "deleted_at"); err != nil {
+		return fmt.Errorf("drop deleted_at column: %w", err)
+	}
+	return nil
+}
+
+// sourceBackfillBatch is how many files addDuplicateDigest backfills at once
+const sourceBackfillBatch = 1000
+
+// addDuplicateDigest creates the duplicates table and backfills files.source
+// from rel_path in batches
+func addDuplicateDigest(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&File{}, &Duplicate{}); err != nil {
+		return fmt.Errorf("auto migrate duplicate digest: %w", err)
+	}
+
+	var lastID uint
+	for {
+		var rows []struct {
+			ID      uint
+			RelPath string
+		}
+		err := tx.Model(&File{}).Select("id, rel_path").
+			Where("id > ? AND (source IS NULL OR source = ?)", lastID, "").
+			Order("id").Limit(sourceBackfillBatch).
+			Scan(&rows).Error
+		if err != nil {
+			return fmt.Errorf("list files to backfill source: %w", err)
+		}
+		for _, row := range rows {
+			if err := tx.Model(&File{}).Where("id = ?", row.ID).Update("source", SourceOf(row.RelPath)).Error; err != nil {
+				return fmt.Errorf("backfill source of file %d: %w", row.ID, err)
+			}
+		}
+		if len(rows) < sourceBackfillBatch {
+			return nil
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+}
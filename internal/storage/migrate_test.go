@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// openTestDB opens an empty database without migrating it
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "state.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// pending returns the IDs of the migrations not applied
+func pending(t *testing.T, store *Storage) []string {
+	t.Helper()
+	states, err := store.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	var ids []string
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			ids = append(ids, state.ID)
+		}
+	}
+	return ids
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	db := openTestDB(t)
+	store := New(db)
+	if got := pending(t, store); len(got) != len(migrations) {
+		t.Fatalf("pending = %v on a fresh database, want every migration", got)
+	}
+
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if got := pending(t, store); len(got) != 0 {
+		t.Errorf("pending = %v after Migrate, want none", got)
+	}
+	for _, model := range []any{&File{}, &Meta{}, &PathSequence{}, &Completion{}, &ManifestEntry{}, &OutboxMessage{}, &ManagedDir{}, &Duplicate{}, &Attempt{}} {
+		if !db.Migrator().HasTable(model) {
+			t.Errorf("table of %T missing after Migrate", model)
+		}
+	}
+
+	// Running again applies nothing twice
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("second Migrate failed: %v", err)
+	}
+	var count int64
+	db.Model(&SchemaMigration{}).Count(&count)
+	if count != int64(len(migrations)) {
+		t.Errorf("schema_migrations has %d rows, want %d", count, len(migrations))
+	}
+	var lock int64
+	db.Model(&Meta{}).Where("key = ?", migrationLockKey).Count(&lock)
+	if lock != 0 {
+		t.Error("migration lock held after Migrate returned")
+	}
+}
+
+func TestMigrate_ExistingSchemaWithoutHistory(t *testing.T) {
+	// A database as bare AutoMigrate left it, before migrations were
+	// recorded, holding a file from before statuses
+	db := openTestDB(t)
+	for _, model := range []any{&File{}, &Meta{}, &PathSequence{}, &Completion{}, &ManifestEntry{}, &OutboxMessage{}, &ManagedDir{}} {
+		if err := db.AutoMigrate(model); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if err := db.Create(&File{SHA256: "old", Name: "old.csv"}).Error; err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+	if err := db.Create(&File{SHA256: "adopted", Status: status.Adopted, RelPath: "acme/2024/a.csv"}).Error; err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+
+	store := New(db)
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if got := pending(t, store); len(got) != 0 {
+		t.Errorf("pending = %v, want none", got)
+	}
+	old, err := store.FindBySHA256("old")
+	if err != nil || old == nil || old.Status != status.Ingested {
+		t.Errorf("file without status = %+v, %v; want backfilled as ingested", old, err)
+	}
+	adopted, err := store.FindBySHA256("adopted")
+	if err != nil || adopted == nil || adopted.Status != status.Adopted {
+		t.Errorf("adopted file = %+v, %v; want its status kept", adopted, err)
+	}
+	if adopted != nil && old != nil && (adopted.Source != "acme" || old.Source != ".") {
+		t.Errorf("sources = %q, %q; want backfilled from the relative paths", adopted.Source, old.Source)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	store := New(openTestDB(t))
+	if err := store.MigrateTo(migrations[0].ID); err != nil {
+		t.Fatalf("MigrateTo failed: %v", err)
+	}
+	if got := pending(t, store); len(got) != len(migrations)-1 || got[0] != migrations[1].ID {
+		t.Errorf("pending = %v after MigrateTo the first, want the rest", got)
+	}
+
+	if err := store.MigrateTo("9999_missing"); !errors.Is(err, ErrUnknownMigration) {
+		t.Errorf("MigrateTo(unknown) = %v, want ErrUnknownMigration", err)
+	}
+}
+
+func TestMigrate_Lock(t *testing.T) {
+	db := openTestDB(t)
+	store := New(db)
+	if err := db.AutoMigrate(&Meta{}); err != nil {
+		t.Fatalf("failed to create meta table: %v", err)
+	}
+
+	held, _ := json.Marshal(migrationLock{PID: 1, Host: "other", StartedAt: time.Now().UTC()})
+	if err := db.Create(&Meta{Key: migrationLockKey, Value: string(held)}).Error; err != nil {
+		t.Fatalf("failed to store lock: %v", err)
+	}
+	if taken, err := store.takeMigrationLock("ours"); err != nil || taken {
+		t.Errorf("takeMigrationLock() = %v, %v while another process holds it, want false", taken, err)
+	}
+
+	// A lock left by a crashed process is taken over
+	stale, _ := json.Marshal(migrationLock{PID: 1, Host: "other", StartedAt: time.Now().Add(-time.Hour).UTC()})
+	if err := db.Model(&Meta{}).Where("key = ?", migrationLockKey).Update("value", string(stale)).Error; err != nil {
+		t.Fatalf("failed to age lock: %v", err)
+	}
+	if err := store.Migrate(); err != nil {
+		t.Fatalf("Migrate with a stale lock failed: %v", err)
+	}
+}
+
+// legacyFile is the files table as created while File embedded gorm.Model
+type legacyFile struct {
+	gorm.Model
+	SHA256 string `gorm:"uniqueIndex;not null"`
+	Name   string
+}
+
+func (legacyFile) TableName() string { return "files" }
+
+func TestAutoMigrate_DropsSoftDelete(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "legacy.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer func() {
+		if sqlDB, _ := db.DB(); sqlDB != nil {
+			_ = sqlDB.Close()
+		}
+	}()
+
+	if err := db.AutoMigrate(&legacyFile{}); err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+	for _, f := range []legacyFile{{SHA256: "live", Name: "live.csv"}, {SHA256: "soft", Name: "soft.csv"}} {
+		if err := db.Create(&f).Error; err != nil {
+			t.Fatalf("failed to insert legacy row: %v", err)
+		}
+	}
+	if err := db.Where("sha256 = ?", "soft").Delete(&legacyFile{}).Error; err != nil {
+		t.Fatalf("failed to soft-delete legacy row: %v", err)
+	}
+
+	store := New(db)
+	if err := store.AutoMigrate(); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+
+	if db.Migrator().HasColumn(&File{}, "deleted_at") {
+		t.Error("deleted_at column should be dropped")
+	}
+	live, err := store.FindBySHA256("live")
+	if err != nil || live == nil || live.Name != "live.csv" {
+		t.Errorf("live row = %v, %v; want kept", live, err)
+	}
+
+	// The soft-deleted row no longer blocks its hash
+	created, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "soft", Status: status.Ingested})
+	if err != nil || !created {
+		t.Errorf("CreateFileIfAbsent of purged hash = %v, %v; want created", created, err)
+	}
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is a notification waiting to be delivered. Messages about
+// ingested files are written in the transaction creating their records and
+// held until the file is in place, so a notification is neither lost by a
+// crash nor sent for a file that never reached the warehouse. Delivered
+// messages are deleted.
+type OutboxMessage struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	Kind      string
+	// SHA256 is the content of the file the message is about, if any
+	SHA256  string `gorm:"index"`
+	Payload string
+	// Ready is false while the message is held with the claim of its file
+	Ready         bool `gorm:"index"`
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string
+}
+
+// OutboxStatus summarizes the undelivered messages
+type OutboxStatus struct {
+	// Depth counts the messages ready for delivery
+	Depth int64 `json:"depth"`
+	// Held counts the messages waiting for their file to be in place
+	Held int64 `json:"held"`
+	// OldestPending is when the oldest ready message was written, zero when
+	// there is none
+	OldestPending time.Time `json:"oldest_pending,omitzero"`
+}
+
+// EnqueueOutbox writes msg, due at once unless it says otherwise
+func (s *Storage) EnqueueOutbox(msg *OutboxMessage) error {
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	msg.CreatedAt = msg.CreatedAt.UTC()
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = msg.CreatedAt
+	}
+	msg.NextAttemptAt = msg.NextAttemptAt.UTC()
+	if err := s.db.Create(msg).Error; err != nil {
+		return fmt.Errorf("enqueue outbox message: %w", err)
+	}
+	return nil
+}
+
+// ReleaseOutbox makes the messages held for the file with the given SHA256
+// ready, replacing their payload unless payload is empty, and reports
+// whether any was held
+func (s *Storage) ReleaseOutbox(sha256, payload string) (bool, error) {
+	updates := map[string]any{"ready": true}
+	if payload != "" {
+		updates["payload"] = payload
+	}
+	res := s.db.Model(&OutboxMessage{}).Where("sha256 = ? AND ready = ?", sha256, false).Updates(updates)
+	if res.Error != nil {
+		return false, fmt.Errorf("release outbox message: %w", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// HeldOutbox returns the messages still held, oldest first
+func (s *Storage) HeldOutbox() ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	if err := s.db.Where("ready = ?", false).Order("id").Find(&msgs).Error; err != nil {
+		return nil, fmt.Errorf("list held outbox messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// PendingOutbox returns up to limit ready messages, oldest first
+func (s *Storage) PendingOutbox(limit int) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	if err := s.db.Where("ready = ?", true).Order("id").Limit(limit).Find(&msgs).Error; err != nil {
+		return nil, fmt.Errorf("list pending outbox messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// DeleteOutbox deletes the message with the given ID, once delivered or
+// given up on
+func (s *Storage) DeleteOutbox(id uint) error {
+	if err := s.db.Delete(&OutboxMessage{}, id).Error; err != nil {
+		return fmt.Errorf("delete outbox message: %w", err)
+	}
+	return nil
+}
+
+// DeferOutbox records a failed delivery of the message with the given ID
+// and when it is due again
+func (s *Storage) DeferOutbox(id uint, next time.Time, cause string) error {
+	err := s.db.Model(&OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": next.UTC(),
+		"last_error":      cause,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("defer outbox message: %w", err)
+	}
+	return nil
+}
+
+// RetryOutboxNow makes every ready message due at now, skipping the rest of
+// their backoff
+func (s *Storage) RetryOutboxNow(now time.Time) error {
+	err := s.db.Model(&OutboxMessage{}).Where("ready = ?", true).Update("next_attempt_at", now.UTC()).Error
+	if err != nil {
+		return fmt.Errorf("retry outbox messages: %w", err)
+	}
+	return nil
+}
+
+// TrimOutbox deletes the oldest ready messages beyond limit and returns how
+// many it deleted. Held messages are never dropped: a held message is
+// released when its file lands, and rows holding a claim are bounded by the
+// files in flight.
+func (s *Storage) TrimOutbox(limit int) (int64, error) {
+	var depth int64
+	if err := s.db.Model(&OutboxMessage{}).Where("ready = ?", true).Count(&depth).Error; err != nil {
+		return 0, fmt.Errorf("count outbox messages: %w", err)
+	}
+	excess := depth - int64(limit)
+	if excess <= 0 {
+		return 0, nil
+	}
+	var ids []uint
+	err := s.db.Model(&OutboxMessage{}).Where("ready = ?", true).Order("id").Limit(int(excess)).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, fmt.Errorf("find oldest outbox messages: %w", err)
+	}
+	res := s.db.Where("id IN ?", ids).Delete(&OutboxMessage{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("trim outbox: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// OutboxStatus returns the depth of the outbox and the age of its oldest
+// ready message
+func (s *Storage) OutboxStatus() (OutboxStatus, error) {
+	var status OutboxStatus
+	if err := s.db.Model(&OutboxMessage{}).Where("ready = ?", true).Count(&status.Depth).Error; err != nil {
+		return status, fmt.Errorf("count outbox messages: %w", err)
+	}
+	if err := s.db.Model(&OutboxMessage{}).Where("ready = ?", false).Count(&status.Held).Error; err != nil {
+		return status, fmt.Errorf("count held outbox messages: %w", err)
+	}
+	var oldest []OutboxMessage
+	if err := s.db.Where("ready = ?", true).Order("id").Limit(1).Find(&oldest).Error; err != nil {
+		return status, fmt.Errorf("find oldest outbox message: %w", err)
+	}
+	if len(oldest) > 0 {
+		status.OldestPending = oldest[0].CreatedAt
+	}
+	return status, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestOutbox_HoldAndRelease(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.EnqueueOutbox(&OutboxMessage{Kind: "file_ingested", SHA256: "abc", Payload: "held"}); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+	if err := store.EnqueueOutbox(&OutboxMessage{Kind: "file_failed", Payload: "ready", Ready: true}); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+
+	due, err := store.PendingOutbox(10)
+	if err != nil {
+		t.Fatalf("PendingOutbox() error = %v", err)
+	}
+	if len(due) != 1 || due[0].Payload != "ready" {
+		t.Fatalf("PendingOutbox() = %+v, want only the ready message", due)
+	}
+
+	released, err := store.ReleaseOutbox("abc", "final")
+	if err != nil || !released {
+		t.Fatalf("ReleaseOutbox() = %v, %v; want true", released, err)
+	}
+	if released, _ := store.ReleaseOutbox("abc", "again"); released {
+		t.Error("ReleaseOutbox() of a released message reported a held one")
+	}
+	due, _ = store.PendingOutbox(10)
+	if len(due) != 2 || due[0].Payload != "final" {
+		t.Errorf("PendingOutbox() after release = %+v, want the released message first with its new payload", due)
+	}
+}
+
+func TestOutbox_DeferAndRetryNow(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	msg := &OutboxMessage{Kind: "file_ingested", Payload: "p", Ready: true}
+	if err := store.EnqueueOutbox(msg); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+	now := time.Now()
+	if err := store.DeferOutbox(msg.ID, now.Add(time.Hour), "connection refused"); err != nil {
+		t.Fatalf("DeferOutbox() error = %v", err)
+	}
+	if due, _ := store.PendingOutbox(10); len(due) != 1 || !due[0].NextAttemptAt.After(now) {
+		t.Fatalf("PendingOutbox() = %+v, want the deferred message due later", due)
+	}
+
+	if err := store.RetryOutboxNow(now); err != nil {
+		t.Fatalf("RetryOutboxNow() error = %v", err)
+	}
+	due, _ := store.PendingOutbox(10)
+	if len(due) != 1 || due[0].NextAttemptAt.After(now) || due[0].Attempts != 1 || due[0].LastError != "connection refused" {
+		t.Fatalf("PendingOutbox() after retry = %+v, want the message with its failed attempt", due)
+	}
+
+	if err := store.DeleteOutbox(msg.ID); err != nil {
+		t.Fatalf("DeleteOutbox() error = %v", err)
+	}
+	if status, _ := store.OutboxStatus(); status.Depth != 0 {
+		t.Errorf("depth after delete = %d, want 0", status.Depth)
+	}
+}
+
+func TestOutbox_TrimAndStatus(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Now().Add(-time.Hour)
+	for i := range 5 {
+		msg := &OutboxMessage{Kind: "file_ingested", Payload: string(rune('a' + i)), Ready: true, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := store.EnqueueOutbox(msg); err != nil {
+			t.Fatalf("EnqueueOutbox() error = %v", err)
+		}
+	}
+	if err := store.EnqueueOutbox(&OutboxMessage{Kind: "file_ingested", SHA256: "held"}); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+
+	dropped, err := store.TrimOutbox(3)
+	if err != nil || dropped != 2 {
+		t.Fatalf("TrimOutbox() = %d, %v; want 2 dropped", dropped, err)
+	}
+	status, err := store.OutboxStatus()
+	if err != nil {
+		t.Fatalf("OutboxStatus() error = %v", err)
+	}
+	if status.Depth != 3 || status.Held != 1 {
+		t.Errorf("status = %+v, want 3 ready and the held message kept", status)
+	}
+	if want := base.Add(2 * time.Minute); !status.OldestPending.Equal(want.UTC()) {
+		t.Errorf("oldest pending = %v, want %v", status.OldestPending, want)
+	}
+	if dropped, _ := store.TrimOutbox(3); dropped != 0 {
+		t.Errorf("TrimOutbox() within the limit dropped %d", dropped)
+	}
+}
+
+func TestReleaseFile_DeletesHeldNotification(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	err := store.Transaction(func(tx *Storage) error {
+		if _, _, err := tx.CreateFileIfAbsent(FileRecord{SHA256: "abc", Name: "a.csv", Status: status.Ingested}); err != nil {
+			return err
+		}
+		return tx.EnqueueOutbox(&OutboxMessage{Kind: "file_ingested", SHA256: "abc"})
+	})
+	if err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if err := store.ReleaseFile("abc"); err != nil {
+		t.Fatalf("ReleaseFile() error = %v", err)
+	}
+	if held, _ := store.HeldOutbox(); len(held) != 0 {
+		t.Errorf("held messages after release = %+v, want none", held)
+	}
+}
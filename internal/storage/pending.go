@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"gorm.io/gorm"
+)
+
+// PendingManifestEntry is the manifest entry of an ingested file not yet
+// appended to the manifest files. It is written in the transaction creating
+// the record of its file, held until the file is in place and deleted once
+// the entry is appended, so an entry is neither lost when the append fails
+// after the commit nor written for a file that never reached the warehouse.
+type PendingManifestEntry struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	SHA256    string    `gorm:"index"`
+	// Entry is the manifest entry as JSON
+	Entry string
+	// Ready is false while the entry is held with the claim of its file
+	Ready     bool `gorm:"index"`
+	Attempts  int
+	LastError string
+}
+
+// ManifestEntry decodes the entry of p
+func (p PendingManifestEntry) ManifestEntry() (manifest.Entry, error) {
+	var entry manifest.Entry
+	if err := json.Unmarshal([]byte(p.Entry), &entry); err != nil {
+		return manifest.Entry{}, fmt.Errorf("decode pending manifest entry %d: %w", p.ID, err)
+	}
+	return entry, nil
+}
+
+// HoldManifestEntry records entry as pending and held. Call it inside the
+// Transaction creating the record of its file.
+func (s *Storage) HoldManifestEntry(entry manifest.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode pending manifest entry: %w", err)
+	}
+	row := PendingManifestEntry{CreatedAt: time.Now().UTC(), SHA256: entry.SHA256, Entry: string(data)}
+	if err := s.db.Create(&row).Error; err != nil {
+		return fmt.Errorf("hold manifest entry: %w", err)
+	}
+	return nil
+}
+
+// ReleaseManifestEntry makes the entries pending for the file with the
+// given SHA256 ready to be retried, replacing them with entry unless its
+// SHA256 is empty and recording cause as their last error. It reports
+// whether any was pending.
+func (s *Storage) ReleaseManifestEntry(sha256 string, entry manifest.Entry, cause string) (bool, error) {
+	updates := map[string]any{"ready": true, "last_error": cause}
+	if entry.SHA256 != "" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return false, fmt.Errorf("encode pending manifest entry: %w", err)
+		}
+		updates["entry"] = string(data)
+	}
+	res := s.db.Model(&PendingManifestEntry{}).Where("sha256 = ?", sha256).Updates(updates)
+	if res.Error != nil {
+		return false, fmt.Errorf("release manifest entry: %w", res.Error)
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// ClearManifestEntry deletes the entries pending for the file with the
+// given SHA256 once their entry is in the manifest files
+func (s *Storage) ClearManifestEntry(sha256 string) error {
+	if err := s.db.Where("sha256 = ?", sha256).Delete(&PendingManifestEntry{}).Error; err != nil {
+		return fmt.Errorf("clear pending manifest entry: %w", err)
+	}
+	return nil
+}
+
+// ReadyManifestEntries returns up to limit pending entries ready to be
+// retried, oldest first
+func (s *Storage) ReadyManifestEntries(limit int) ([]PendingManifestEntry, error) {
+	var rows []PendingManifestEntry
+	if err := s.db.Where("ready = ?", true).Order("id").Limit(limit).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("list ready manifest entries: %w", err)
+	}
+	return rows, nil
+}
+
+// FailManifestEntry records a failed retry of the pending entry id
+func (s *Storage) FailManifestEntry(id uint, cause string) error {
+	err := s.db.Model(&PendingManifestEntry{}).Where("id = ?", id).Updates(map[string]any{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": cause,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("record failed manifest entry: %w", err)
+	}
+	return nil
+}
+
+// PendingManifestEntries returns every pending entry, held or ready,
+// oldest first
+func (q queries) PendingManifestEntries() ([]PendingManifestEntry, error) {
+	var rows []PendingManifestEntry
+	if err := q.db.Order("id").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("list pending manifest entries: %w", err)
+	}
+	return rows, nil
+}
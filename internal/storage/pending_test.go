@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestPendingManifestEntries(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, sha := range []string{"aaa", "bbb"} {
+		if err := store.HoldManifestEntry(manifest.Entry{SHA256: sha, Name: sha + ".csv", ProcessedAt: at}); err != nil {
+			t.Fatalf("HoldManifestEntry(%s) error = %v", sha, err)
+		}
+	}
+	if ready, err := store.ReadyManifestEntries(10); err != nil || len(ready) != 0 {
+		t.Fatalf("ReadyManifestEntries() = %v, %v while held, want none", ready, err)
+	}
+
+	// The append failed with the entry as it stood after the move
+	final := manifest.Entry{SHA256: "aaa", Name: "aaa.csv", ProcessedAt: at, LinkCount: 2}
+	if released, err := store.ReleaseManifestEntry("aaa", final, "disk full"); err != nil || !released {
+		t.Fatalf("ReleaseManifestEntry() = %v, %v, want released", released, err)
+	}
+	if err := store.FailManifestEntry(1, "still full"); err != nil {
+		t.Fatalf("FailManifestEntry() error = %v", err)
+	}
+	ready, err := store.ReadyManifestEntries(10)
+	if err != nil || len(ready) != 1 {
+		t.Fatalf("ReadyManifestEntries() = %v, %v, want aaa", ready, err)
+	}
+	if ready[0].Attempts != 1 || ready[0].LastError != "still full" {
+		t.Errorf("ready entry = %+v, want one attempt and the last error", ready[0])
+	}
+	entry, err := ready[0].ManifestEntry()
+	if err != nil || entry.LinkCount != 2 || !entry.ProcessedAt.Equal(at) {
+		t.Errorf("ManifestEntry() = %+v, %v, want the released entry", entry, err)
+	}
+
+	if err := store.ClearManifestEntry("aaa"); err != nil {
+		t.Fatalf("ClearManifestEntry() error = %v", err)
+	}
+	if released, err := store.ReleaseManifestEntry("aaa", manifest.Entry{}, ""); err != nil || released {
+		t.Errorf("ReleaseManifestEntry() of a cleared entry = %v, %v, want nothing released", released, err)
+	}
+
+	// Released claims take their pending entry with them
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "bbb", Name: "bbb.csv", Status: status.Ingested, ProcessedAt: at}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+	if err := store.ReleaseFile("bbb"); err != nil {
+		t.Fatalf("ReleaseFile() error = %v", err)
+	}
+	if rows, err := store.PendingManifestEntries(); err != nil || len(rows) != 0 {
+		t.Errorf("PendingManifestEntries() = %v, %v after the claims ended, want none", rows, err)
+	}
+}
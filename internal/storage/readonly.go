@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"fmt"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ReadOnly is a state database handle that only exposes queries. The
+// underlying SQLite connection is opened with mode=ro, so even a query path
+// bug cannot modify the file the daemon is writing.
+type ReadOnly struct {
+	queries
+}
+
+var _ Reader = (*ReadOnly)(nil)
+
+// OpenReadOnly opens an existing SQLite state database read-only. It neither
+// creates the file nor runs migrations.
+func OpenReadOnly(path string) (*ReadOnly, error) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", path, busyTimeoutMillis)), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open read-only database: %w", err)
+	}
+	return &ReadOnly{queries{db: db}}, nil
+}
+
+// Close releases the underlying connection pool
+func (r *ReadOnly) Close() error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("get sql db: %w", err)
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.CreateFile("ro123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+
+	ro, err := OpenReadOnly(dbFile(t, store))
+	if err != nil {
+		t.Fatalf("OpenReadOnly failed: %v", err)
+	}
+	defer func() { _ = ro.Close() }()
+
+	exists, err := ro.FileExistsSince("ro123", time.Time{})
+	if err != nil {
+		t.Fatalf("FileExistsSince failed: %v", err)
+	}
+	if !exists {
+		t.Error("file should be visible through the read-only handle")
+	}
+
+	file, err := ro.FindBySHA256("ro123")
+	if err != nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if file == nil || file.Name != "test.txt" {
+		t.Errorf("FindBySHA256 = %+v, want record for test.txt", file)
+	}
+
+	// The connection itself must refuse writes
+	if err := ro.db.Create(&File{SHA256: "sneaky"}).Error; err == nil {
+		t.Error("expected write through read-only connection to fail")
+	}
+}
+
+func TestOpenReadOnly_MissingDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "missing.db")
+
+	if _, err := OpenReadOnly(dbPath); err == nil {
+		t.Error("expected error opening a missing database read-only")
+	}
+}
+
+// dbFile returns the path of the main database file behind store
+func dbFile(t *testing.T, store *Storage) string {
+	t.Helper()
+
+	var rows []struct {
+		Name string
+		File string
+	}
+	if err := store.db.Raw("PRAGMA database_list").Scan(&rows).Error; err != nil {
+		t.Fatalf("failed to list databases: %v", err)
+	}
+	for _, row := range rows {
+		if row.Name == "main" {
+			return row.File
+		}
+	}
+	t.Fatal("main database not found")
+	return ""
+}
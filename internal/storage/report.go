@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// reportKey is the meta key holding the scheduled time of the last
+// ingestion report
+const reportKey = "report_sent_for"
+
+// IngestionSummary aggregates the files ingested and the attempts made in a
+// period
+type IngestionSummary struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Files and Bytes total the files ingested in the period. Adopted
+	// files were placed by hand and are left out.
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Sources are the top sources by ingested bytes
+	Sources []SourceIngestion `json:"sources"`
+	// Outcomes counts the attempts that ended in the period by outcome
+	Outcomes map[status.Status]int64 `json:"outcomes"`
+}
+
+// SourceIngestion totals the files of one source ingested in a period
+type SourceIngestion struct {
+	Source string `json:"source"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// IngestionStats aggregates the files processed in [from, to), listing at
+// most top sources by ingested bytes, or all when top is not positive. Ties
+// are broken by file count, then by name, so the result is stable.
+func (q queries) IngestionStats(from, to time.Time, top int) (*IngestionSummary, error) {
+	from, to = from.UTC(), to.UTC()
+	summary := &IngestionSummary{From: from, To: to, Sources: []SourceIngestion{}, Outcomes: map[status.Status]int64{}}
+	ingested := func() *gorm.DB {
+		return q.db.Model(&File{}).
+			Where("processed_at >= ? AND processed_at < ?", from, to).
+			Where("status <> ?", status.Adopted)
+	}
+
+	var total struct {
+		Files int64
+		Bytes int64
+	}
+	if err := ingested().
+		Select("count(*) AS files, COALESCE(sum(size), 0) AS bytes").
+		Scan(&total).Error; err != nil {
+		return nil, fmt.Errorf("total ingested files: %w", err)
+	}
+	summary.Files, summary.Bytes = total.Files, total.Bytes
+
+	sources := ingested().
+		Select("source, count(*) AS files, COALESCE(sum(size), 0) AS bytes").
+		Group("source").
+		Order("bytes DESC").Order("files DESC").Order("source")
+	if top > 0 {
+		sources = sources.Limit(top)
+	}
+	if err := sources.Scan(&summary.Sources).Error; err != nil {
+		return nil, fmt.Errorf("ingested files by source: %w", err)
+	}
+
+	var outcomes []struct {
+		Outcome  status.Status
+		Attempts int64
+	}
+	if err := q.db.Model(&Attempt{}).
+		Select("outcome, count(*) AS attempts").
+		Where("ended_at >= ? AND ended_at < ?", from, to).
+		Group("outcome").
+		Scan(&outcomes).Error; err != nil {
+		return nil, fmt.Errorf("attempts by outcome: %w", err)
+	}
+	for _, row := range outcomes {
+		summary.Outcomes[row.Outcome] = row.Attempts
+	}
+	return summary, nil
+}
+
+// LastReport returns the scheduled time of the last ingestion report, or the
+// zero time when none was sent
+func (s *Storage) LastReport() (time.Time, error) {
+	var at time.Time
+	if _, err := s.getMeta(reportKey, &at); err != nil {
+		return time.Time{}, fmt.Errorf("query last report: %w", err)
+	}
+	return at, nil
+}
+
+// RecordReport records the report scheduled at at as sent
+func (s *Storage) RecordReport(at time.Time) error {
+	if err := s.putMeta(reportKey, at.UTC()); err != nil {
+		return fmt.Errorf("record last report: %w", err)
+	}
+	return nil
+}
+
+// EnqueueReport writes the outbox message of the report scheduled at at and
+// records it as sent in one transaction, so a restart neither repeats the
+// report nor skips it
+func (s *Storage) EnqueueReport(msg *OutboxMessage, at time.Time) error {
+	return s.Transaction(func(tx *Storage) error {
+		if err := tx.EnqueueOutbox(msg); err != nil {
+			return err
+		}
+		return tx.RecordReport(at)
+	})
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestIngestionStats(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	create := func(i int, rel string, size int64, at time.Time, st status.Status) {
+		t.Helper()
+		_, _, err := store.CreateFileIfAbsent(FileRecord{
+			SHA256: fmt.Sprintf("sha-%d", i), Name: rel, Size: size, Status: st,
+			DestPath: "/warehouse/" + rel, ProcessedAt: at, RelPath: rel,
+		})
+		if err != nil {
+			t.Fatalf("CreateFileIfAbsent(%s) error = %v", rel, err)
+		}
+	}
+	create(0, "acme/a.csv", 100, from, status.Ingested)
+	create(1, "acme/b.csv", 100, from.Add(time.Hour), status.Ingested)
+	create(2, "globex/feed.csv", 500, from.Add(2*time.Hour), status.Ingested)
+	create(3, "initech/x.csv", 200, from.Add(3*time.Hour), status.Ingested)
+	// Placed by hand, outside the period, and at its end: not counted
+	create(4, "acme/adopted.csv", 1000, from.Add(time.Hour), status.Adopted)
+	create(5, "acme/early.csv", 1000, from.Add(-time.Second), status.Ingested)
+	create(6, "acme/late.csv", 1000, to, status.Ingested)
+
+	attempts := []Attempt{
+		{Path: "/in/a.csv", StartedAt: from, EndedAt: from.Add(time.Minute), Outcome: status.Failed},
+		{Path: "/in/a.csv", StartedAt: from, EndedAt: from.Add(2 * time.Minute), Outcome: status.Failed},
+		{Path: "/in/a.csv", StartedAt: from, EndedAt: from.Add(3 * time.Minute), Outcome: "ingested"},
+		{Path: "/in/b.csv", StartedAt: from, EndedAt: to, Outcome: status.Failed},
+	}
+	if err := store.RecordAttempts(attempts); err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+
+	got, err := store.IngestionStats(from, to, 2)
+	if err != nil {
+		t.Fatalf("IngestionStats() error = %v", err)
+	}
+	if got.Files != 4 || got.Bytes != 900 {
+		t.Errorf("totals = %d files, %d bytes; want 4, 900", got.Files, got.Bytes)
+	}
+	wantSources := []SourceIngestion{
+		{Source: "globex", Files: 1, Bytes: 500},
+		{Source: "acme", Files: 2, Bytes: 200},
+	}
+	if !reflect.DeepEqual(got.Sources, wantSources) {
+		t.Errorf("Sources = %+v, want %+v", got.Sources, wantSources)
+	}
+	wantOutcomes := map[status.Status]int64{status.Failed: 2, status.Ingested: 1}
+	if !reflect.DeepEqual(got.Outcomes, wantOutcomes) {
+		t.Errorf("Outcomes = %v, want %v", got.Outcomes, wantOutcomes)
+	}
+
+	// A tie in bytes goes to the source with more files, then by name
+	all, err := store.IngestionStats(from, to, 0)
+	if err != nil {
+		t.Fatalf("IngestionStats() error = %v", err)
+	}
+	if len(all.Sources) != 3 || all.Sources[1].Source != "acme" || all.Sources[2].Source != "initech" {
+		t.Errorf("Sources = %+v, want every source by bytes", all.Sources)
+	}
+}
+
+func TestRecordReport(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if last, err := store.LastReport(); err != nil || !last.IsZero() {
+		t.Fatalf("LastReport() = %v, %v, want zero before any report", last, err)
+	}
+	at := time.Date(2024, 6, 1, 7, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	if err := store.EnqueueReport(&OutboxMessage{CreatedAt: at, Kind: "ingestion_report", Payload: "{}", Ready: true}, at); err != nil {
+		t.Fatalf("EnqueueReport() error = %v", err)
+	}
+	last, err := store.LastReport()
+	if err != nil || !last.Equal(at) {
+		t.Errorf("LastReport() = %v, %v, want %v", last, err, at)
+	}
+	if msgs, err := store.PendingOutbox(10); err != nil || len(msgs) != 1 {
+		t.Errorf("PendingOutbox() = %+v, %v, want the report", msgs, err)
+	}
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "fmt"
+
+// scanCursorKey is the meta key holding where the initial scan of the input
+// directory stopped
+const scanCursorKey = "scan_cursor"
+
+// LoadScanCursor returns the path, relative to the input directory, the
+// last incremental scan was to look at next, or "" when it finished
+func (s *Storage) LoadScanCursor() (string, error) {
+	var cursor string
+	if _, err := s.getMeta(scanCursorKey, &cursor); err != nil {
+		return "", fmt.Errorf("query scan cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// SaveScanCursor records rel as the next path the incremental scan looks
+// at, "" once it finished
+func (s *Storage) SaveScanCursor(rel string) error {
+	if err := s.putMeta(scanCursorKey, rel); err != nil {
+		return fmt.Errorf("save scan cursor: %w", err)
+	}
+	return nil
+}
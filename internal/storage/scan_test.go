@@ -0,0 +1,20 @@
+package storage
+
+import "testing"
+
+func TestScanCursor(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if cursor, err := store.LoadScanCursor(); err != nil || cursor != "" {
+		t.Fatalf("LoadScanCursor() = %q, %v before any save, want empty", cursor, err)
+	}
+	for _, want := range []string{"acme/2024/feed-0042.csv", ""} {
+		if err := store.SaveScanCursor(want); err != nil {
+			t.Fatalf("SaveScanCursor(%q) error = %v", want, err)
+		}
+		if cursor, err := store.LoadScanCursor(); err != nil || cursor != want {
+			t.Errorf("LoadScanCursor() = %q, %v, want %q", cursor, err, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sequenceKey is the meta key holding the manifest sequence
+const sequenceKey = "manifest_sequence"
+
+// Sequence is the persisted state of the manifest sequence: the first
+// number not yet reserved and the latest ProcessedAt stamped when the last
+// block was reserved
+type Sequence struct {
+	Next            int64     `json:"next"`
+	LastProcessedAt time.Time `json:"last_processed_at"`
+}
+
+// LoadSequence returns the manifest sequence. Before the first reservation
+// it starts after the highest sequence number recorded, with the
+// ProcessedAt of the newest file record.
+func (s *Storage) LoadSequence() (Sequence, error) {
+	var meta Meta
+	err := s.db.Where("key = ?", sequenceKey).First(&meta).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return Sequence{}, fmt.Errorf("query manifest sequence: %w", err)
+	}
+
+	var seq Sequence
+	if err == nil {
+		if err := json.Unmarshal([]byte(meta.Value), &seq); err != nil {
+			return Sequence{}, fmt.Errorf("decode manifest sequence: %w", err)
+		}
+		return seq, nil
+	}
+
+	var highest struct{ Files, Entries int64 }
+	if err := s.db.Model(&File{}).Select("COALESCE(MAX(sequence), 0)").Scan(&highest.Files).Error; err != nil {
+		return Sequence{}, fmt.Errorf("query highest file sequence: %w", err)
+	}
+	if err := s.db.Model(&ManifestEntry{}).Select("COALESCE(MAX(sequence), 0)").Scan(&highest.Entries).Error; err != nil {
+		return Sequence{}, fmt.Errorf("query highest manifest sequence: %w", err)
+	}
+	seq.Next = max(highest.Files, highest.Entries) + 1
+
+	var newest []File
+	if err := s.db.Order("id DESC").Limit(1).Find(&newest).Error; err != nil {
+		return Sequence{}, fmt.Errorf("query newest file: %w", err)
+	}
+	if len(newest) > 0 {
+		seq.LastProcessedAt = newest[0].ProcessedAt
+	}
+	return seq, nil
+}
+
+// ReserveSequence reserves the n numbers following the last reservation and
+// returns the first, recording last as the latest ProcessedAt stamped.
+// Numbers are never handed out twice: those reserved but unused when the
+// process stops are skipped.
+func (s *Storage) ReserveSequence(n int64, last time.Time) (int64, error) {
+	var first int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		seq, err := New(tx).LoadSequence()
+		if err != nil {
+			return err
+		}
+		first = seq.Next
+		seq.Next += n
+		if last.After(seq.LastProcessedAt) {
+			seq.LastProcessedAt = last.UTC()
+		}
+		data, err := json.Marshal(seq)
+		if err != nil {
+			return fmt.Errorf("encode manifest sequence: %w", err)
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Meta{
+			Key:   sequenceKey,
+			Value: string(data),
+		}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reserve manifest sequence: %w", err)
+	}
+	return first, nil
+}
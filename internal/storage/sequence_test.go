@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveSequence_NeverReusesNumbers(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first, err := store.ReserveSequence(10, time.Now())
+	if err != nil {
+		t.Fatalf("ReserveSequence() error = %v", err)
+	}
+	if first != 1 {
+		t.Errorf("first reservation starts at %d, want 1", first)
+	}
+	second, err := store.ReserveSequence(10, time.Now())
+	if err != nil {
+		t.Fatalf("ReserveSequence() again error = %v", err)
+	}
+	if second != 11 {
+		t.Errorf("second reservation starts at %d, want 11", second)
+	}
+}
+
+func TestReserveSequence_LastProcessedAtOnlyMovesForward(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	latest := time.Now().UTC().Truncate(time.Microsecond)
+	if _, err := store.ReserveSequence(1, latest); err != nil {
+		t.Fatalf("ReserveSequence() error = %v", err)
+	}
+	if _, err := store.ReserveSequence(1, latest.Add(-time.Hour)); err != nil {
+		t.Fatalf("ReserveSequence() with an earlier time error = %v", err)
+	}
+
+	seq, err := store.LoadSequence()
+	if err != nil {
+		t.Fatalf("LoadSequence() error = %v", err)
+	}
+	if !seq.LastProcessedAt.Equal(latest) || seq.Next != 3 {
+		t.Errorf("LoadSequence() = %+v, want next 3 and last %v", seq, latest)
+	}
+}
+
+func TestLoadSequence_StartsAfterRecordedNumbers(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	processedAt := time.Now().UTC().Truncate(time.Microsecond)
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "aaa", ProcessedAt: processedAt, Sequence: 41}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+
+	seq, err := store.LoadSequence()
+	if err != nil {
+		t.Fatalf("LoadSequence() error = %v", err)
+	}
+	if seq.Next != 42 || !seq.LastProcessedAt.Equal(processedAt) {
+		t.Errorf("LoadSequence() = %+v, want next 42 and the newest record's ProcessedAt %v", seq, processedAt)
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// SLA miss severities
+const (
+	// SLASeverityWarning is a miss within its grace period
+	SLASeverityWarning = "warning"
+	// SLASeverityCritical is a miss past its grace period
+	SLASeverityCritical = "critical"
+)
+
+// SLAMiss records a deadline of an SLA rule that passed without a matching
+// file ingested in its window, and what became of it
+type SLAMiss struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Rule string `gorm:"uniqueIndex:idx_sla_misses_rule_deadline,priority:1" json:"rule"`
+	// Deadline is when the file was due and WindowStart the earliest a
+	// file counted for it
+	Deadline    time.Time `gorm:"uniqueIndex:idx_sla_misses_rule_deadline,priority:2" json:"deadline"`
+	WindowStart time.Time `json:"window_start"`
+	Pattern     string    `json:"pattern"`
+	// Severity is SLASeverityWarning until the grace period is over
+	Severity    string     `json:"severity"`
+	MissedAt    time.Time  `json:"missed_at"`
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	// ResolvedAt is set once a matching file arrived late, with the path
+	// and hash of that file
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolvedPath   string     `json:"resolved_path,omitempty"`
+	ResolvedSHA256 string     `json:"resolved_sha256,omitempty"`
+}
+
+// RecordSLAMiss records m, creating it when new, and enqueues msg with it
+// when set, so an alert goes out exactly when its change is recorded
+func (s *Storage) RecordSLAMiss(m *SLAMiss, msg *OutboxMessage) error {
+	m.Deadline = m.Deadline.UTC()
+	m.WindowStart = m.WindowStart.UTC()
+	m.MissedAt = m.MissedAt.UTC()
+	return s.Transaction(func(tx *Storage) error {
+		if err := tx.db.Save(m).Error; err != nil {
+			return fmt.Errorf("record SLA miss of %s at %s: %w", m.Rule, m.Deadline, err)
+		}
+		if msg == nil {
+			return nil
+		}
+		return tx.EnqueueOutbox(msg)
+	})
+}
+
+// FindSLAMiss returns the miss of rule at deadline, or nil when none was
+// recorded. Deadlines are met more often than not, so the lookup does not
+// log a missing row.
+func (q queries) FindSLAMiss(rule string, deadline time.Time) (*SLAMiss, error) {
+	var misses []SLAMiss
+	if err := q.db.Where("rule = ? AND deadline = ?", rule, deadline.UTC()).Limit(1).Find(&misses).Error; err != nil {
+		return nil, fmt.Errorf("query SLA miss of %s: %w", rule, err)
+	}
+	if len(misses) == 0 {
+		return nil, nil
+	}
+	return &misses[0], nil
+}
+
+// SLAMisses returns the misses of deadlines at or after since, oldest
+// first
+func (q queries) SLAMisses(since time.Time) ([]SLAMiss, error) {
+	var misses []SLAMiss
+	if err := q.db.Where("deadline >= ?", since.UTC()).Order("deadline").Order("id").Find(&misses).Error; err != nil {
+		return nil, fmt.Errorf("list SLA misses: %w", err)
+	}
+	return misses, nil
+}
+
+// UnresolvedSLAMisses returns the misses of rule no file has resolved yet,
+// oldest first
+func (q queries) UnresolvedSLAMisses(rule string) ([]SLAMiss, error) {
+	var misses []SLAMiss
+	if err := q.db.Where("rule = ? AND resolved_at IS NULL", rule).Order("deadline").Find(&misses).Error; err != nil {
+		return nil, fmt.Errorf("list unresolved SLA misses of %s: %w", rule, err)
+	}
+	return misses, nil
+}
+
+// IngestedBetween returns the files ingested from source between from and
+// to inclusive, oldest first; an empty source matches every source
+func (q queries) IngestedBetween(source string, from, to time.Time) ([]File, error) {
+	query := q.db.Where("processed_at >= ? AND processed_at <= ?", from.UTC(), to.UTC()).
+		Where("status = ?", status.Ingested)
+	if source != "" {
+		query = query.Where("source = ?", source)
+	}
+	var files []File
+	if err := query.Order("processed_at").Order("id").Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("list files ingested between %s and %s: %w", from, to, err)
+	}
+	return files, nil
+}
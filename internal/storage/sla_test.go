@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+func TestSLAMisses(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	deadline := time.Date(2024, 6, 10, 7, 0, 0, 0, time.UTC)
+	miss := &SLAMiss{Rule: "daily", Deadline: deadline, WindowStart: deadline.Add(-24 * time.Hour), Pattern: "acme/*.csv", Severity: SLASeverityWarning, MissedAt: deadline}
+	if err := store.RecordSLAMiss(miss, &OutboxMessage{CreatedAt: deadline, Kind: "sla_missed", Payload: "{}", Ready: true}); err != nil {
+		t.Fatalf("RecordSLAMiss() error = %v", err)
+	}
+	if msgs, err := store.PendingOutbox(10); err != nil || len(msgs) != 1 || msgs[0].Kind != "sla_missed" {
+		t.Fatalf("PendingOutbox() = %+v, %v, want the alert enqueued", msgs, err)
+	}
+
+	// Escalating updates the same row
+	escalated := deadline.Add(time.Hour)
+	miss.Severity, miss.EscalatedAt = SLASeverityCritical, &escalated
+	if err := store.RecordSLAMiss(miss, nil); err != nil {
+		t.Fatalf("RecordSLAMiss() escalation error = %v", err)
+	}
+	got, err := store.FindSLAMiss("daily", deadline)
+	if err != nil || got == nil || got.Severity != SLASeverityCritical || got.EscalatedAt == nil {
+		t.Fatalf("FindSLAMiss() = %+v, %v, want the escalated miss", got, err)
+	}
+	if got, err := store.FindSLAMiss("daily", deadline.Add(24*time.Hour)); err != nil || got != nil {
+		t.Errorf("FindSLAMiss(next day) = %+v, %v, want nil", got, err)
+	}
+
+	if open, err := store.UnresolvedSLAMisses("daily"); err != nil || len(open) != 1 {
+		t.Errorf("UnresolvedSLAMisses() = %+v, %v, want the miss", open, err)
+	}
+	resolved := deadline.Add(2 * time.Hour)
+	miss.ResolvedAt, miss.ResolvedPath = &resolved, "/in/acme/late.csv"
+	if err := store.RecordSLAMiss(miss, nil); err != nil {
+		t.Fatalf("RecordSLAMiss() resolution error = %v", err)
+	}
+	if open, err := store.UnresolvedSLAMisses("daily"); err != nil || len(open) != 0 {
+		t.Errorf("UnresolvedSLAMisses() after resolution = %+v, %v, want none", open, err)
+	}
+
+	misses, err := store.SLAMisses(deadline)
+	if err != nil || len(misses) != 1 || misses[0].ID != miss.ID {
+		t.Errorf("SLAMisses() = %+v, %v, want the one miss", misses, err)
+	}
+	if misses, err := store.SLAMisses(deadline.Add(time.Second)); err != nil || len(misses) != 0 {
+		t.Errorf("SLAMisses(later) = %+v, %v, want none", misses, err)
+	}
+}
+
+func TestIngestedBetween(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	for i, rec := range []FileRecord{
+		{SHA256: "a", Path: "/in/acme/a.csv", RelPath: "acme/a.csv", Status: status.Ingested, ProcessedAt: base.Add(time.Hour)},
+		{SHA256: "b", Path: "/in/globex/b.csv", RelPath: "globex/b.csv", Status: status.Ingested, ProcessedAt: base.Add(2 * time.Hour)},
+		{SHA256: "c", Path: "/in/acme/c.csv", RelPath: "acme/c.csv", Status: status.Adopted, ProcessedAt: base.Add(3 * time.Hour)},
+		{SHA256: "d", Path: "/in/acme/d.csv", RelPath: "acme/d.csv", Status: status.Ingested, ProcessedAt: base.Add(5 * time.Hour)},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent(%d) error = %v", i, err)
+		}
+	}
+
+	files, err := store.IngestedBetween("acme", base, base.Add(4*time.Hour))
+	if err != nil || len(files) != 1 || files[0].SHA256 != "a" {
+		t.Errorf("IngestedBetween(acme) = %+v, %v, want a only", files, err)
+	}
+	files, err = store.IngestedBetween("", base.Add(time.Hour), base.Add(5*time.Hour))
+	if err != nil || len(files) != 3 || files[0].SHA256 != "a" || files[2].SHA256 != "d" {
+		t.Errorf("IngestedBetween(all) = %+v, %v, want a, b and d", files, err)
+	}
+}
@@ -0,0 +1,716 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// SnapshotVersion is the version of the snapshot format ExportSnapshot
+// writes. A snapshot starts with snapshotMagic and its version byte,
+// followed by frames, each a uvarint length and a payload whose first byte
+// is its kind: one header, then per table a table frame and its row frames,
+// then an end frame. The SHA-256 of everything before it closes the
+// snapshot.
+const SnapshotVersion = 1
+
+// snapshotMagic opens every snapshot
+const snapshotMagic = "AISNAP"
+
+// Frame kinds
+const (
+	frameHeader = 'H'
+	frameTable  = 'T'
+	frameRow    = 'R'
+	frameEnd    = 'E'
+)
+
+// Value kinds of row frames
+const (
+	valueNull byte = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBytes
+	valueTime
+	valueFalse
+	valueTrue
+)
+
+// maxFrame bounds the frames read, so a corrupt length cannot exhaust memory
+const maxFrame = 64 << 20
+
+// defaultSnapshotBatch is how many rows one import statement inserts at
+// most; batches are smaller for wide tables, under the bind variable limit
+const defaultSnapshotBatch = 1000
+
+// maxBindVars stays below the SQLite limit of bind variables per statement
+const maxBindVars = 30000
+
+// ErrSnapshotCorrupt is returned for a snapshot that is truncated, fails its
+// checksum or does not decode
+var ErrSnapshotCorrupt = errors.New("corrupt snapshot")
+
+// snapshotModels are the tables a snapshot carries, in the order they are
+// written. schema_migrations is left out: the importing database creates
+// its schema by running the migrations.
+var snapshotModels = []any{
+	&File{}, &Duplicate{}, &Attempt{}, &ManifestEntry{}, &OutboxMessage{},
+	&Completion{}, &PathSequence{}, &ManagedDir{}, &HeldFile{}, &SLAMiss{},
+	&PendingManifestEntry{}, &Timing{}, &Meta{},
+}
+
+// snapshotSkipMeta are the meta keys of locks held by the running process,
+// which must not carry over
+var snapshotSkipMeta = []string{maintenanceKey, migrationLockKey}
+
+// SnapshotTable is a table of a snapshot and how many rows it holds
+type SnapshotTable struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+}
+
+// SnapshotInfo describes a snapshot
+type SnapshotInfo struct {
+	Version int `json:"version"`
+	// Schema is the last migration applied to the exported database
+	Schema    string          `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+	Tables    []SnapshotTable `json:"tables"`
+	SHA256    string          `json:"sha256"`
+}
+
+// ImportOptions tune ImportSnapshot
+type ImportOptions struct {
+	// BatchSize caps the rows inserted by one statement,
+	// defaultSnapshotBatch when zero
+	BatchSize int
+	// Progress, when set, is called after every batch with the rows of
+	// table read so far out of total
+	Progress func(table string, done, total int64)
+}
+
+// ImportedTable counts the rows of a table ImportSnapshot inserted and the
+// ones it skipped because a row with the same key already existed
+type ImportedTable struct {
+	Name     string `json:"name"`
+	Inserted int64  `json:"inserted"`
+	Skipped  int64  `json:"skipped"`
+}
+
+// ExportSnapshot writes every table of the state database to w in one read
+// transaction, so the snapshot is consistent while the daemon writes
+func (q queries) ExportSnapshot(w io.Writer) (*SnapshotInfo, error) {
+	info := &SnapshotInfo{Version: SnapshotVersion, CreatedAt: time.Now().UTC()}
+	sw := newSnapshotWriter(w)
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if info.Schema, err = lastMigration(tx); err != nil {
+			return err
+		}
+		sw.raw(append([]byte(snapshotMagic), SnapshotVersion))
+		var header snapshotEncoder
+		header.kind(frameHeader)
+		header.str(info.Schema)
+		header.time(info.CreatedAt)
+		header.uvarint(uint64(len(snapshotModels)))
+		sw.frame(header.buf)
+
+		for _, model := range snapshotModels {
+			table, err := exportTable(tx, sw, model)
+			if err != nil {
+				return err
+			}
+			info.Tables = append(info.Tables, table)
+		}
+
+		var end snapshotEncoder
+		end.kind(frameEnd)
+		end.uvarint(uint64(len(info.Tables)))
+		sw.frame(end.buf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sw.close()
+	if err != nil {
+		return nil, fmt.Errorf("write snapshot: %w", err)
+	}
+	info.SHA256 = fmt.Sprintf("%x", sum)
+	return info, nil
+}
+
+// exportTable writes the table and row frames of model, ordered by primary
+// key so equal databases give equal snapshots
+func exportTable(tx *gorm.DB, sw *snapshotWriter, model any) (SnapshotTable, error) {
+	s, err := parseModel(tx, model)
+	if err != nil {
+		return SnapshotTable{}, err
+	}
+	query := func() *gorm.DB {
+		q := tx.Table(s.Table)
+		if s.Table == "meta" {
+			q = q.Where("key NOT IN ?", snapshotSkipMeta)
+		}
+		return q
+	}
+	table := SnapshotTable{Name: s.Table}
+	if err := query().Count(&table.Rows).Error; err != nil {
+		return table, fmt.Errorf("count %s: %w", s.Table, err)
+	}
+
+	rows, err := query().Order(clause.OrderBy{Columns: primaryOrder(s)}).Rows()
+	if err != nil {
+		return table, fmt.Errorf("export %s: %w", s.Table, err)
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return table, fmt.Errorf("export %s: %w", s.Table, err)
+	}
+
+	var head snapshotEncoder
+	head.kind(frameTable)
+	head.str(s.Table)
+	head.uvarint(uint64(table.Rows))
+	head.uvarint(uint64(len(columns)))
+	for _, c := range columns {
+		head.str(c)
+	}
+	sw.frame(head.buf)
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var written int64
+	var row snapshotEncoder
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return table, fmt.Errorf("export %s: %w", s.Table, err)
+		}
+		row.buf = row.buf[:0]
+		row.kind(frameRow)
+		for _, v := range values {
+			if err := row.value(v); err != nil {
+				return table, fmt.Errorf("export %s: %w", s.Table, err)
+			}
+		}
+		sw.frame(row.buf)
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return table, fmt.Errorf("export %s: %w", s.Table, err)
+	}
+	// Counted and read in the same transaction
+	if written != table.Rows {
+		return table, fmt.Errorf("export %s: counted %d rows, read %d", s.Table, table.Rows, written)
+	}
+	return table, sw.err
+}
+
+// lastMigration returns the last migration of the chain applied to the
+// database, empty when none was
+func lastMigration(tx *gorm.DB) (string, error) {
+	var applied []string
+	if err := tx.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return "", fmt.Errorf("query applied migrations: %w", err)
+	}
+	last := ""
+	for _, m := range migrations {
+		if slices.Contains(applied, m.ID) {
+			last = m.ID
+		}
+	}
+	return last, nil
+}
+
+// VerifySnapshot reads the whole snapshot from r, checking its framing,
+// row counts and checksum, and describes it
+func VerifySnapshot(r io.Reader) (*SnapshotInfo, error) {
+	return readSnapshot(r, nil)
+}
+
+// ImportSnapshot loads the snapshot in r into the database. The snapshot is
+// verified in full before anything is written and the schema is brought to
+// the latest migration. Rows are inserted in batches, each committed on its
+// own; a row whose primary or unique key already exists is skipped, so an
+// interrupted import is resumed by running it again.
+func (s *Storage) ImportSnapshot(r io.ReadSeeker, opts ImportOptions) ([]ImportedTable, error) {
+	info, err := VerifySnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	if info.Schema != "" && !slices.ContainsFunc(migrations, func(m Migration) bool { return m.ID == info.Schema }) {
+		return nil, fmt.Errorf("snapshot schema %s is newer than this database knows: %w", info.Schema, ErrUnknownMigration)
+	}
+	if err := s.Migrate(); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind snapshot: %w", err)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultSnapshotBatch
+	}
+
+	imp := &snapshotImporter{db: s.db, opts: opts}
+	if _, err := readSnapshot(r, imp); err != nil {
+		return nil, err
+	}
+	if err := imp.flush(); err != nil {
+		return nil, err
+	}
+	return imp.tables, nil
+}
+
+// snapshotImporter inserts the rows of a snapshot as readSnapshot decodes
+// them
+type snapshotImporter struct {
+	db     *gorm.DB
+	opts   ImportOptions
+	tables []ImportedTable
+
+	// The table being imported
+	schema  *schema.Schema
+	table   SnapshotTable
+	columns []string
+	batch   []map[string]any
+	batchAt int
+	read    int64
+}
+
+// begin starts importing table with columns, after the previous one
+func (imp *snapshotImporter) begin(table SnapshotTable, columns []string) error {
+	if err := imp.flush(); err != nil {
+		return err
+	}
+	var model any
+	for _, m := range snapshotModels {
+		s, err := parseModel(imp.db, m)
+		if err != nil {
+			return err
+		}
+		if s.Table == table.Name {
+			model, imp.schema = m, s
+		}
+	}
+	if model == nil {
+		return fmt.Errorf("snapshot table %s is unknown to this database", table.Name)
+	}
+	for _, c := range columns {
+		if !imp.db.Migrator().HasColumn(model, c) {
+			return fmt.Errorf("snapshot column %s.%s is unknown to this database", table.Name, c)
+		}
+	}
+	imp.table, imp.columns, imp.read = table, columns, 0
+	imp.tables = append(imp.tables, ImportedTable{Name: table.Name})
+	imp.batchAt = max(1, min(imp.opts.BatchSize, maxBindVars/max(1, len(columns))))
+	return nil
+}
+
+// row queues a row of the current table, inserting the batch once full
+func (imp *snapshotImporter) row(values []any) error {
+	row := make(map[string]any, len(values))
+	for i, v := range values {
+		row[imp.columns[i]] = coerce(imp.schema.LookUpField(imp.columns[i]), v)
+	}
+	imp.batch = append(imp.batch, row)
+	imp.read++
+	if len(imp.batch) < imp.batchAt {
+		return nil
+	}
+	return imp.flush()
+}
+
+// flush inserts the queued rows of the current table
+func (imp *snapshotImporter) flush() error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	res := imp.db.Table(imp.table.Name).Clauses(clause.OnConflict{DoNothing: true}).Create(imp.batch)
+	if res.Error != nil {
+		return fmt.Errorf("import %s: %w", imp.table.Name, res.Error)
+	}
+	t := &imp.tables[len(imp.tables)-1]
+	t.Inserted += res.RowsAffected
+	t.Skipped += int64(len(imp.batch)) - res.RowsAffected
+	imp.batch = imp.batch[:0]
+	if imp.opts.Progress != nil {
+		imp.opts.Progress(imp.table.Name, imp.read, imp.table.Rows)
+	}
+	return nil
+}
+
+// coerce converts a value as another backend returns it to the type of
+// field, such as the integer SQLite stores booleans as
+func coerce(field *schema.Field, v any) any {
+	if field == nil {
+		return v
+	}
+	switch x := v.(type) {
+	case int64:
+		if field.DataType == schema.Bool {
+			return x != 0
+		}
+	case []byte:
+		if field.DataType == schema.String {
+			return string(x)
+		}
+	}
+	return v
+}
+
+// readSnapshot decodes the snapshot in r, passing its tables and rows to
+// imp when set, and checks it end to end
+func readSnapshot(r io.Reader, imp *snapshotImporter) (*SnapshotInfo, error) {
+	sr := newSnapshotReader(r)
+	magic := make([]byte, len(snapshotMagic)+1)
+	if err := sr.raw(magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrSnapshotCorrupt)
+	}
+	info := &SnapshotInfo{Version: int(magic[len(snapshotMagic)])}
+	if info.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", info.Version)
+	}
+
+	header, err := sr.frame(frameHeader)
+	if err != nil {
+		return nil, err
+	}
+	info.Schema = header.str()
+	info.CreatedAt = header.time()
+	tables := header.uvarint()
+	if err := header.done(); err != nil {
+		return nil, err
+	}
+
+	var values []any
+	for range tables {
+		head, err := sr.frame(frameTable)
+		if err != nil {
+			return nil, err
+		}
+		table := SnapshotTable{Name: head.str(), Rows: int64(head.uvarint())}
+		columns := make([]string, head.uvarint())
+		for i := range columns {
+			columns[i] = head.str()
+		}
+		if err := head.done(); err != nil {
+			return nil, err
+		}
+		if imp != nil {
+			if err := imp.begin(table, columns); err != nil {
+				return nil, err
+			}
+		}
+		for range table.Rows {
+			row, err := sr.frame(frameRow)
+			if err != nil {
+				return nil, err
+			}
+			values = values[:0]
+			for range columns {
+				values = append(values, row.value())
+			}
+			if err := row.done(); err != nil {
+				return nil, err
+			}
+			if imp != nil {
+				if err := imp.row(values); err != nil {
+					return nil, err
+				}
+			}
+		}
+		info.Tables = append(info.Tables, table)
+	}
+
+	end, err := sr.frame(frameEnd)
+	if err != nil {
+		return nil, err
+	}
+	if n := end.uvarint(); end.done() != nil || n != tables {
+		return nil, fmt.Errorf("%w: end frame does not match the header", ErrSnapshotCorrupt)
+	}
+	sum := sr.h.Sum(nil)
+	want := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(sr.r, want); err != nil {
+		return nil, fmt.Errorf("%w: missing checksum", ErrSnapshotCorrupt)
+	}
+	if !bytes.Equal(sum, want) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+	if _, err := sr.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%w: trailing data after the checksum", ErrSnapshotCorrupt)
+	}
+	info.SHA256 = fmt.Sprintf("%x", sum)
+	return info, nil
+}
+
+// parseModel returns the schema of model
+func parseModel(db *gorm.DB, model any) (*schema.Schema, error) {
+	s, err := schema.Parse(model, &sync.Map{}, db.NamingStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("parse schema of %T: %w", model, err)
+	}
+	return s, nil
+}
+
+// primaryOrder orders by the primary key of s
+func primaryOrder(s *schema.Schema) []clause.OrderByColumn {
+	var cols []clause.OrderByColumn
+	for _, name := range s.PrimaryFieldDBNames {
+		cols = append(cols, clause.OrderByColumn{Column: clause.Column{Name: name}})
+	}
+	return cols
+}
+
+// snapshotWriter writes frames, hashing everything written
+type snapshotWriter struct {
+	w   *bufio.Writer
+	h   hash.Hash
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	return &snapshotWriter{w: bufio.NewWriter(w), h: sha256.New()}
+}
+
+func (sw *snapshotWriter) raw(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.h.Write(p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) frame(payload []byte) {
+	sw.raw(binary.AppendUvarint(nil, uint64(len(payload))))
+	sw.raw(payload)
+}
+
+// close writes the checksum and flushes, returning the checksum
+func (sw *snapshotWriter) close() ([]byte, error) {
+	sum := sw.h.Sum(nil)
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(sum)
+	}
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sum, sw.err
+}
+
+// snapshotEncoder builds the payload of a frame
+type snapshotEncoder struct {
+	buf []byte
+}
+
+func (e *snapshotEncoder) kind(k byte)      { e.buf = append(e.buf, k) }
+func (e *snapshotEncoder) uvarint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+func (e *snapshotEncoder) varint(v int64)   { e.buf = binary.AppendVarint(e.buf, v) }
+
+func (e *snapshotEncoder) str(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *snapshotEncoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// time keeps the instant and the offset it was stored with, so the text
+// SQLite stores is the same after an import
+func (e *snapshotEncoder) time(t time.Time) {
+	_, offset := t.Zone()
+	e.varint(t.Unix())
+	e.uvarint(uint64(t.Nanosecond()))
+	e.varint(int64(offset))
+}
+
+// value encodes a column value as the database driver returned it
+func (e *snapshotEncoder) value(v any) error {
+	switch x := v.(type) {
+	case nil:
+		e.kind(valueNull)
+	case int64:
+		e.kind(valueInt)
+		e.varint(x)
+	case float64:
+		e.kind(valueFloat)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(x))
+	case string:
+		e.kind(valueString)
+		e.str(x)
+	case []byte:
+		e.kind(valueBytes)
+		e.bytes(x)
+	case time.Time:
+		e.kind(valueTime)
+		e.time(x)
+	case bool:
+		if x {
+			e.kind(valueTrue)
+		} else {
+			e.kind(valueFalse)
+		}
+	case sql.RawBytes:
+		e.kind(valueBytes)
+		e.bytes(x)
+	default:
+		return fmt.Errorf("unsupported column value %T", v)
+	}
+	return nil
+}
+
+// snapshotReader reads frames, hashing everything read
+type snapshotReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{r: bufio.NewReader(r), h: sha256.New()}
+}
+
+func (sr *snapshotReader) raw(p []byte) error {
+	if _, err := io.ReadFull(sr.r, p); err != nil {
+		return fmt.Errorf("%w: truncated: %w", ErrSnapshotCorrupt, err)
+	}
+	sr.h.Write(p)
+	return nil
+}
+
+// frame reads the next frame, which must be of kind
+func (sr *snapshotReader) frame(kind byte) (*snapshotDecoder, error) {
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated: %w", ErrSnapshotCorrupt, err)
+	}
+	if n == 0 || n > maxFrame {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrSnapshotCorrupt, n)
+	}
+	sr.h.Write(binary.AppendUvarint(nil, n))
+	payload := make([]byte, n)
+	if err := sr.raw(payload); err != nil {
+		return nil, err
+	}
+	if payload[0] != kind {
+		return nil, fmt.Errorf("%w: frame %q where %q was expected", ErrSnapshotCorrupt, payload[0], kind)
+	}
+	return &snapshotDecoder{buf: payload[1:]}, nil
+}
+
+// snapshotDecoder decodes the payload of a frame. The first error sticks
+// and is returned by done.
+type snapshotDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *snapshotDecoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: malformed frame", ErrSnapshotCorrupt)
+	}
+	d.buf = nil
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *snapshotDecoder) next(n uint64) []byte {
+	if n > uint64(len(d.buf)) {
+		d.fail()
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *snapshotDecoder) str() string { return string(d.next(d.uvarint())) }
+
+func (d *snapshotDecoder) time() time.Time {
+	sec, nsec, offset := d.varint(), d.uvarint(), d.varint()
+	t := time.Unix(sec, int64(nsec))
+	if offset == 0 {
+		return t.UTC()
+	}
+	return t.In(time.FixedZone("", int(offset)))
+}
+
+func (d *snapshotDecoder) value() any {
+	kind := d.next(1)
+	if kind == nil {
+		return nil
+	}
+	switch kind[0] {
+	case valueNull:
+		return nil
+	case valueInt:
+		return d.varint()
+	case valueFloat:
+		b := d.next(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	case valueString:
+		return d.str()
+	case valueBytes:
+		return bytes.Clone(d.next(d.uvarint()))
+	case valueTime:
+		return d.time()
+	case valueFalse:
+		return false
+	case valueTrue:
+		return true
+	}
+	d.fail()
+	return nil
+}
+
+// done returns the first decoding error, or one when bytes are left over
+func (d *snapshotDecoder) done() error {
+	if d.err == nil && len(d.buf) > 0 {
+		d.fail()
+	}
+	return d.err
+}
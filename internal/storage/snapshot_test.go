@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"gorm.io/gorm/clause"
+)
+
+// fillSnapshotDB writes rows to every table a snapshot carries
+func fillSnapshotDB(t *testing.T, store *Storage) {
+	t.Helper()
+	base := time.Date(2024, 6, 10, 8, 0, 0, 123456789, time.UTC)
+	for i, rec := range []FileRecord{
+		{SHA256: "a", Name: "a.csv", Path: "/in/acme/a.csv", RelPath: "acme/a.csv", Size: 10, Status: status.Ingested, ProcessedAt: base, Tags: Tags{"tier": "bulk"}, Sequence: 1},
+		{SHA256: "b", Name: "b.csv", Path: "/in/globex/b.csv", RelPath: "globex/b.csv", Size: 20, Status: status.Ingested, ProcessedAt: base.Add(time.Hour), IdempotencyKey: "42", Sequence: 2},
+		{SHA256: "c", Name: "c.csv", Path: "/in/acme/c.csv", RelPath: "acme/c.csv", Size: 30, Status: status.Adopted, ProcessedAt: base.Add(2 * time.Hour)},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent(%d) error = %v", i, err)
+		}
+	}
+	original, _ := store.FindBySHA256("a")
+	if err := store.RecordDuplicate(DuplicateRecord{DetectedAt: base.Add(time.Minute), Source: "acme", Path: "/in/acme/a2.csv", SHA256: "a", Size: 10, Dedup: "content", Original: original}); err != nil {
+		t.Fatalf("RecordDuplicate() error = %v", err)
+	}
+	if err := store.RecordAttempts([]Attempt{{Path: "/in/acme/a.csv", SHA256: "a", StartedAt: base, EndedAt: base.Add(time.Second), Outcome: "ingested", Instance: "host:1"}}); err != nil {
+		t.Fatalf("RecordAttempts() error = %v", err)
+	}
+	if err := store.AppendManifestEntries(manifest.Entry{SchemaVersion: manifest.CurrentSchemaVersion, SHA256: "a", Name: "a.csv", SourcePath: "/in/acme/a.csv", DestPath: "/warehouse/acme/a.csv", Size: 10, ProcessedAt: base, Status: status.Ingested, Sequence: 1, SelfTest: true}); err != nil {
+		t.Fatalf("AppendManifestEntries() error = %v", err)
+	}
+	if err := store.EnqueueOutbox(&OutboxMessage{CreatedAt: base, Kind: "ingested", Payload: `{"sha256":"a"}`, Ready: true}); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+	if err := store.RecordCompletion("/in/acme/done.csv", base); err != nil {
+		t.Fatalf("RecordCompletion() error = %v", err)
+	}
+	if _, err := store.NextVersion("acme/a.csv", 0); err != nil {
+		t.Fatalf("NextVersion() error = %v", err)
+	}
+	if err := store.RecordManagedDir("/warehouse", "warehouse", base); err != nil {
+		t.Fatalf("RecordManagedDir() error = %v", err)
+	}
+	if err := store.HoldFile(&HeldFile{Path: "/in/acme/d.csv", RelPath: "acme/d.csv", SHA256: "d", Size: 1, PreviousSHA256: "a", PreviousSize: 10, HeldAt: base}); err != nil {
+		t.Fatalf("HoldFile() error = %v", err)
+	}
+	if err := store.RecordSLAMiss(&SLAMiss{Rule: "daily", Deadline: base, WindowStart: base.Add(-24 * time.Hour), Severity: SLASeverityWarning, MissedAt: base}, nil); err != nil {
+		t.Fatalf("RecordSLAMiss() error = %v", err)
+	}
+	if _, err := store.ReserveSequence(10, base); err != nil {
+		t.Fatalf("ReserveSequence() error = %v", err)
+	}
+}
+
+// dumpTables renders every row of the tables a snapshot carries
+func dumpTables(t *testing.T, store *Storage) map[string]string {
+	t.Helper()
+	dumps := make(map[string]string)
+	for _, model := range snapshotModels {
+		s, err := parseModel(store.db, model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []map[string]any
+		q := store.db.Table(s.Table).Order(clause.OrderBy{Columns: primaryOrder(s)})
+		if s.Table == "meta" {
+			q = q.Where("key NOT IN ?", snapshotSkipMeta)
+		}
+		if err := q.Find(&rows).Error; err != nil {
+			t.Fatalf("dump %s: %v", s.Table, err)
+		}
+		dumps[s.Table] = fmt.Sprint(rows)
+	}
+	return dumps
+}
+
+// describeFiles renders files with the values their pointers refer to
+func describeFiles(files []File) string {
+	var b strings.Builder
+	for _, f := range files {
+		key := ""
+		if f.IdempotencyKey != nil {
+			key = *f.IdempotencyKey
+		}
+		f.IdempotencyKey = nil
+		fmt.Fprintf(&b, "%+v key=%q\n", f, key)
+	}
+	return b.String()
+}
+
+func exportSnapshot(t *testing.T, store *Storage) ([]byte, *SnapshotInfo) {
+	t.Helper()
+	var buf bytes.Buffer
+	info, err := store.ExportSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("ExportSnapshot() error = %v", err)
+	}
+	return buf.Bytes(), info
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	src, cleanup := setupTestDB(t)
+	defer cleanup()
+	fillSnapshotDB(t, src)
+	m, err := src.BeginMaintenance("test", time.Hour)
+	if err != nil {
+		t.Fatalf("BeginMaintenance() error = %v", err)
+	}
+	defer func() { _ = m.End() }()
+
+	data, info := exportSnapshot(t, src)
+	if info.Version != SnapshotVersion || info.Schema != migrations[len(migrations)-1].ID || len(info.Tables) != len(snapshotModels) {
+		t.Fatalf("ExportSnapshot() = %+v, want every table at the latest schema", info)
+	}
+	verified, err := VerifySnapshot(bytes.NewReader(data))
+	if err != nil || verified.SHA256 != info.SHA256 {
+		t.Fatalf("VerifySnapshot() = %+v, %v, want checksum %s", verified, err, info.SHA256)
+	}
+
+	// Into a database that was never migrated
+	dst := New(openTestDB(t))
+	var progress int
+	imported, err := dst.ImportSnapshot(bytes.NewReader(data), ImportOptions{BatchSize: 2, Progress: func(string, int64, int64) { progress++ }})
+	if err != nil {
+		t.Fatalf("ImportSnapshot() error = %v", err)
+	}
+	for i, table := range imported {
+		if table.Inserted != info.Tables[i].Rows || table.Skipped != 0 {
+			t.Errorf("imported %+v, want the %d rows of the snapshot", table, info.Tables[i].Rows)
+		}
+	}
+	if progress == 0 {
+		t.Error("import reported no progress")
+	}
+
+	want, got := dumpTables(t, src), dumpTables(t, dst)
+	for table := range want {
+		if got[table] != want[table] {
+			t.Errorf("table %s after import =\n%s\nwant\n%s", table, got[table], want[table])
+		}
+	}
+
+	// Queries answer the same
+	for _, sha := range []string{"a", "b", "z"} {
+		w, _ := src.FileExistsSince(sha, time.Time{})
+		g, err := dst.FileExistsSince(sha, time.Time{})
+		if err != nil || g != w {
+			t.Errorf("FileExistsSince(%s) = %v, %v after import, want %v", sha, g, err, w)
+		}
+	}
+	wantFiles, _ := src.ListFiles(FileFilter{})
+	gotFiles, err := dst.ListFiles(FileFilter{})
+	if err != nil || describeFiles(gotFiles) != describeFiles(wantFiles) {
+		t.Errorf("ListFiles() after import = %+v, %v, want %+v", gotFiles, err, wantFiles)
+	}
+	from, to := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 11, 0, 0, 0, 0, time.UTC)
+	wantStats, _ := src.IngestionStats(from, to, 0)
+	gotStats, err := dst.IngestionStats(from, to, 0)
+	if err != nil || fmt.Sprint(gotStats) != fmt.Sprint(wantStats) {
+		t.Errorf("IngestionStats() after import = %+v, %v, want %+v", gotStats, err, wantStats)
+	}
+	wantDups, _ := src.DuplicateStats(from, to, 0)
+	gotDups, err := dst.DuplicateStats(from, to, 0)
+	if err != nil || fmt.Sprint(gotDups) != fmt.Sprint(wantDups) {
+		t.Errorf("DuplicateStats() after import = %+v, %v, want %+v", gotDups, err, wantDups)
+	}
+	wantSeq, _ := src.LoadSequence()
+	if seq, err := dst.LoadSequence(); err != nil || seq != wantSeq {
+		t.Errorf("LoadSequence() after import = %+v, %v, want %+v", seq, err, wantSeq)
+	}
+
+	// The lock held while exporting stays behind
+	if lock, err := dst.MaintenanceStatus(); err != nil || lock != nil {
+		t.Errorf("MaintenanceStatus() after import = %+v, %v, want no lock", lock, err)
+	}
+
+	// Equal databases export equal tables
+	again, _ := exportSnapshot(t, dst)
+	if dump, _ := VerifySnapshot(bytes.NewReader(again)); fmt.Sprint(dump.Tables) != fmt.Sprint(info.Tables) {
+		t.Errorf("re-exported tables = %+v, want %+v", dump.Tables, info.Tables)
+	}
+}
+
+func TestSnapshot_ResumeSkipsExisting(t *testing.T) {
+	src, cleanup := setupTestDB(t)
+	defer cleanup()
+	fillSnapshotDB(t, src)
+	data, info := exportSnapshot(t, src)
+
+	dst, cleanupDst := setupTestDB(t)
+	defer cleanupDst()
+	// An earlier import stopped after the first file
+	if _, _, err := dst.CreateFileIfAbsent(FileRecord{SHA256: "a", Name: "a.csv", Path: "/in/acme/a.csv", RelPath: "acme/a.csv", Size: 10, Status: status.Ingested}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+	imported, err := dst.ImportSnapshot(bytes.NewReader(data), ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportSnapshot() error = %v", err)
+	}
+	if files := imported[0]; files.Name != "files" || files.Inserted != info.Tables[0].Rows-1 || files.Skipped != 1 {
+		t.Errorf("imported files = %+v, want the existing one skipped", files)
+	}
+	if _, err := dst.ImportSnapshot(bytes.NewReader(data), ImportOptions{}); err != nil {
+		t.Fatalf("ImportSnapshot() again error = %v", err)
+	}
+	counts, err := dst.CountByStatus()
+	if err != nil || counts[status.Ingested] != 2 || counts[status.Adopted] != 1 {
+		t.Errorf("CountByStatus() after two imports = %v, %v, want each file once", counts, err)
+	}
+}
+
+func TestSnapshot_Corrupt(t *testing.T) {
+	src, cleanup := setupTestDB(t)
+	defer cleanup()
+	fillSnapshotDB(t, src)
+	data, _ := exportSnapshot(t, src)
+
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+	for name, corrupt := range map[string][]byte{
+		"truncated":    data[:len(data)-10],
+		"flipped":      flipped,
+		"not snapshot": []byte("SQLite format 3\x00"),
+		"trailing":     append(bytes.Clone(data), 0),
+	} {
+		if _, err := VerifySnapshot(bytes.NewReader(corrupt)); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Errorf("VerifySnapshot(%s) error = %v, want ErrSnapshotCorrupt", name, err)
+		}
+	}
+
+	// Nothing is written from a snapshot failing its checksum
+	dst, cleanupDst := setupTestDB(t)
+	defer cleanupDst()
+	if _, err := dst.ImportSnapshot(bytes.NewReader(flipped), ImportOptions{}); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Fatalf("ImportSnapshot() error = %v, want ErrSnapshotCorrupt", err)
+	}
+	if counts, _ := dst.CountByStatus(); len(counts) != 0 {
+		t.Errorf("CountByStatus() after a failed import = %v, want no files", counts)
+	}
+}
+
+// Every table of the schema must be carried or deliberately left out
+func TestSnapshot_CoversEveryTable(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+	tables, err := store.db.Migrator().GetTables()
+	if err != nil {
+		t.Fatalf("GetTables() error = %v", err)
+	}
+	carried := map[string]bool{"schema_migrations": true, "sqlite_sequence": true}
+	for _, model := range snapshotModels {
+		s, _ := parseModel(store.db, model)
+		carried[s.Table] = true
+	}
+	for _, table := range tables {
+		if !carried[table] {
+			t.Errorf("table %s is not carried by snapshots", table)
+		}
+	}
+}
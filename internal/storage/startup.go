@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Meta keys of strict startup: the inconsistencies that blocked the last
+// start and the operator's sign-off of them
+const (
+	startupBlockKey = "startup_block"
+	startupAckKey   = "startup_ack"
+)
+
+// ErrNoStartupBlock is returned when acknowledging while no start was
+// blocked
+var ErrNoStartupBlock = errors.New("no blocked startup to acknowledge")
+
+// StartupBlock records the inconsistencies that made a strict start refuse
+// to run
+type StartupBlock struct {
+	// Fingerprint identifies the set of inconsistencies found
+	Fingerprint string    `json:"fingerprint"`
+	Anomalies   int       `json:"anomalies"`
+	FoundAt     time.Time `json:"found_at"`
+}
+
+// StartupAck is an operator's sign-off of the inconsistencies of a blocked
+// start. A strict start proceeds while the inconsistencies it finds have the
+// acknowledged fingerprint.
+type StartupAck struct {
+	Fingerprint string    `json:"fingerprint"`
+	Anomalies   int       `json:"anomalies"`
+	By          string    `json:"by"`
+	Note        string    `json:"note"`
+	At          time.Time `json:"at"`
+}
+
+// BlockStartup records the inconsistencies of a refused start, replacing
+// those of an earlier one
+func (s *Storage) BlockStartup(block StartupBlock) error {
+	block.FoundAt = block.FoundAt.UTC()
+	if err := s.putMeta(startupBlockKey, block); err != nil {
+		return fmt.Errorf("record startup block: %w", err)
+	}
+	return nil
+}
+
+// StartupBlock returns the inconsistencies of the last refused start, or nil
+// when none was refused
+func (s *Storage) StartupBlock() (*StartupBlock, error) {
+	var block StartupBlock
+	found, err := s.getMeta(startupBlockKey, &block)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// AcknowledgeStartup signs off the inconsistencies of the last refused start
+// as by with note, returning the sign-off. It fails with ErrNoStartupBlock
+// when no start was refused.
+func (s *Storage) AcknowledgeStartup(by, note string, at time.Time) (*StartupAck, error) {
+	var ack *StartupAck
+	err := s.Transaction(func(tx *Storage) error {
+		block, err := tx.StartupBlock()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return ErrNoStartupBlock
+		}
+		ack = &StartupAck{
+			Fingerprint: block.Fingerprint,
+			Anomalies:   block.Anomalies,
+			By:          by,
+			Note:        note,
+			At:          at.UTC(),
+		}
+		if err := tx.putMeta(startupAckKey, ack); err != nil {
+			return err
+		}
+		return tx.db.Where("key = ?", startupBlockKey).Delete(&Meta{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrNoStartupBlock) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("acknowledge startup: %w", err)
+	}
+	return ack, nil
+}
+
+// StartupAck returns the latest sign-off, or nil when there was none
+func (s *Storage) StartupAck() (*StartupAck, error) {
+	var ack StartupAck
+	found, err := s.getMeta(startupAckKey, &ack)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &ack, nil
+}
+
+// putMeta stores v as JSON under key
+func (s *Storage) putMeta(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Meta{Key: key, Value: string(data)}).Error
+}
+
+// getMeta decodes the JSON stored under key into v, reporting whether it
+// was found
+func (s *Storage) getMeta(key string, v any) (bool, error) {
+	var meta Meta
+	err := s.db.Where("key = ?", key).First(&meta).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("query %s: %w", key, err)
+	}
+	if err := json.Unmarshal([]byte(meta.Value), v); err != nil {
+		return false, fmt.Errorf("decode %s: %w", key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcknowledgeStartup(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	if _, err := store.AcknowledgeStartup("alice", "checked", at); !errors.Is(err, ErrNoStartupBlock) {
+		t.Fatalf("AcknowledgeStartup() error = %v without a block, want ErrNoStartupBlock", err)
+	}
+
+	if err := store.BlockStartup(StartupBlock{Fingerprint: "abc", Anomalies: 2, FoundAt: at}); err != nil {
+		t.Fatalf("BlockStartup() error = %v", err)
+	}
+	block, err := store.StartupBlock()
+	if err != nil || block == nil || block.Fingerprint != "abc" || block.Anomalies != 2 {
+		t.Fatalf("StartupBlock() = %+v, %v", block, err)
+	}
+
+	ack, err := store.AcknowledgeStartup("alice", "temp files from the 3am crash", at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AcknowledgeStartup() error = %v", err)
+	}
+	want := StartupAck{Fingerprint: "abc", Anomalies: 2, By: "alice", Note: "temp files from the 3am crash", At: at.Add(time.Hour)}
+	if *ack != want {
+		t.Errorf("AcknowledgeStartup() = %+v, want %+v", *ack, want)
+	}
+	if got, err := store.StartupAck(); err != nil || got == nil || *got != want {
+		t.Errorf("StartupAck() = %+v, %v, want %+v", got, err, want)
+	}
+	if block, _ := store.StartupBlock(); block != nil {
+		t.Errorf("StartupBlock() = %+v after acknowledging, want none", block)
+	}
+}
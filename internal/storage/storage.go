@@ -1,41 +1,196 @@
 package storage
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// suspectKeyFormat renames the SHA256 of a suspect record, keeping the hash
+// as a prefix
+const suspectKeyFormat = "%s.suspect.%d"
+
+// restoredKeyFormat renames the SHA256 of a missing_restored record, keeping
+// the hash as a prefix
+const restoredKeyFormat = "%s.restored.%d"
+
+// supersededKeyFormat renames the SHA256 of a superseded record, keeping the
+// hash as a prefix
+const supersededKeyFormat = "%s.superseded.%d"
+
+// File is an ingested file. Records are never soft-deleted: DeleteFile removes
+// the row, which releases its SHA256 for re-ingestion, and every query sees
+// every row. gorm.Model is deliberately not embedded, since its DeletedAt
+// would hide rows from queries while they still held the unique SHA256 index.
 type File struct {
-	gorm.Model
+	ID uint `gorm:"primaryKey"`
+	// CreatedAt bounds the scope of the dedup window, which
+	// idx_files_sha_created serves
+	CreatedAt time.Time `gorm:"index:idx_files_sha_created,priority:2"`
+	UpdatedAt time.Time
 
-	SHA256 string `gorm:"uniqueIndex;not null"`
-	Name   string
-	Path   string
-	Size   int64
+	SHA256       string `gorm:"uniqueIndex;not null;index:idx_files_sha_created,priority:1"`
+	Name         string
+	OriginalName string
+	Path         string
+	Size         int64
+	Status       status.Status
+	DestPath     string
+	ProcessedAt  time.Time `gorm:"index:idx_files_processed_source,priority:1"`
+	Tags         Tags      `gorm:"type:text"`
+
+	// RelPath is the source path relative to the input directory, as
+	// rewritten by any rename rule
+	RelPath string `gorm:"index"`
+	// Version numbers successive files arriving under the same RelPath
+	// when versioning is enabled, starting at 1; 0 means unversioned
+	Version int
+	// PreviousSHA256 is the content of the version this one replaced
+	PreviousSHA256 string
+	// IdempotencyKey is the producer-supplied key of the file. Like the
+	// hash it may be ingested once; files without a key store NULL, which
+	// the unique index does not compare.
+	IdempotencyKey *string `gorm:"uniqueIndex"`
+	// AllocatedSize is the bytes the source occupied on disk when it
+	// differed materially from Size, or 0
+	AllocatedSize int64
+	// Sequence is the sequence number of the manifest entry of the file,
+	// which orders records when ProcessedAt does not. Zero for records
+	// created before sequence numbers or never stamped, such as adopted
+	// files.
+	Sequence int64 `gorm:"index"`
+	// Source is the top-level directory of the file below the input
+	// directory, "." for files directly in it; the duplicate digest
+	// groups by it
+	Source string `gorm:"index:idx_files_processed_source,priority:2"`
 }
 
-type Storage struct {
-	db *gorm.DB
+// ContentSHA256 returns the hash of the content of f: its SHA256 without the
+// suffix MarkSuspect, MarkMissingRestored or MarkSuperseded moved it to
+func (f File) ContentSHA256() string {
+	hash, _, _ := strings.Cut(f.SHA256, ".")
+	return hash
 }
 
-func New(db *gorm.DB) *Storage {
-	return &Storage{db: db}
+// Tags are key/value labels attached at ingest time, stored as a JSON object
+type Tags map[string]string
+
+// Value implements driver.Valuer
+func (t Tags) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]string(t))
+	if err != nil {
+		return nil, fmt.Errorf("encode tags: %w", err)
+	}
+	return string(data), nil
 }
 
-// AutoMigrate runs database migrations
-func (s *Storage) AutoMigrate() error {
-	if err := s.db.AutoMigrate(&File{}); err != nil {
-		return fmt.Errorf("auto migrate file table: %w", err)
+// Scan implements sql.Scanner
+func (t *Tags) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("scan tags: unsupported type %T", value)
+	}
+	if len(data) == 0 {
+		*t = nil
+		return nil
+	}
+	if err := json.Unmarshal(data, (*map[string]string)(t)); err != nil {
+		return fmt.Errorf("decode tags: %w", err)
 	}
 	return nil
 }
 
-// FileExists checks if a file with the given SHA256 already exists
-func (s *Storage) FileExists(sha256 string) (bool, error) {
+// Reader is the read-only subset of the storage API. Commands that only
+// inspect state depend on it so they cannot reach a write method.
+type Reader interface {
+	FileExistsSince(sha256 string, cutoff time.Time) (bool, error)
+	FindBySHA256(sha256 string) (*File, error)
+	FindByDestPath(destPath string) (*File, error)
+	FindHold(path string) (*HeldFile, error)
+	FindByIdempotencyKey(key string) (*File, error)
+	FindByRelPathSize(relPath string, size int64) (*File, error)
+	LastByPath(relPath string) (*File, error)
+	LatestVersion(relPath string) (*File, error)
+	ListFiles(filter FileFilter) ([]File, error)
+	CountByStatus() (map[status.Status]int64, error)
+	ManifestEntries(from, to time.Time) ([]manifest.Entry, error)
+	DuplicateStats(from, to time.Time, top int) (*DuplicateSummary, error)
+	IngestionStats(from, to time.Time, top int) (*IngestionSummary, error)
+	Attempts(pathOrSHA256 string) ([]Attempt, error)
+	RetriedFiles(since time.Time, limit int) ([]RetriedFile, error)
+	ConfigHistory() ([]ConfigRecord, error)
+	IngestedBetween(source string, from, to time.Time) ([]File, error)
+	FindSLAMiss(rule string, deadline time.Time) (*SLAMiss, error)
+	SLAMisses(since time.Time) ([]SLAMiss, error)
+	UnresolvedSLAMisses(rule string) ([]SLAMiss, error)
+	PendingManifestEntries() ([]PendingManifestEntry, error)
+	SlowFiles(from, to time.Time, limit int) ([]Timing, error)
+	ProcessedBetween(from, to time.Time, statuses ...status.Status) ([]File, error)
+	ProcessedSpan(statuses ...status.Status) (time.Time, time.Time, error)
+}
+
+// FileFilter narrows ListFiles. Zero fields match everything.
+type FileFilter struct {
+	Status status.Status
+	// RelPath selects the versions of one input path
+	RelPath string
+	// Tags must all be present with the given values
+	Tags map[string]string
+}
+
+// queries implements Reader and is shared by Storage and ReadOnly
+type queries struct {
+	db *gorm.DB
+}
+
+// busyTimeoutMillis is how long a connection waits for another one holding
+// the state database lock, such as the attempt history writer, before it
+// fails with "database is locked"
+const busyTimeoutMillis = 5000
+
+// DSN returns the SQLite data source name of the state database at path.
+// Transactions take the write lock when they begin, so one that reads
+// before it writes waits out a concurrent writer rather than failing.
+func DSN(path string) string {
+	return fmt.Sprintf("%s?_busy_timeout=%d&_txlock=immediate", path, busyTimeoutMillis)
+}
+
+type Storage struct {
+	queries
+}
+
+func New(db *gorm.DB) *Storage {
+	return &Storage{queries{db: db}}
+}
+
+// FileExistsSince checks if a file with the given SHA256 was recorded at or
+// after cutoff. A zero cutoff considers every record.
+func (q queries) FileExistsSince(sha256 string, cutoff time.Time) (bool, error) {
+	query := q.db.Where("sha256 = ?", sha256)
+	if !cutoff.IsZero() {
+		query = query.Where("created_at >= ?", cutoff.UTC())
+	}
 	var file File
-	err := s.db.Where("sha256 = ?", sha256).First(&file).Error
+	err := query.First(&file).Error
 	if err == gorm.ErrRecordNotFound {
 		return false, nil
 	}
@@ -45,16 +200,172 @@ func (s *Storage) FileExists(sha256 string) (bool, error) {
 	return true, nil
 }
 
+// FindBySHA256 returns the record with the given SHA256, or nil when there is none
+func (q queries) FindBySHA256(sha256 string) (*File, error) {
+	var file File
+	err := q.db.Where("sha256 = ?", sha256).First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query file by sha256: %w", err)
+	}
+	return &file, nil
+}
+
+// FindByDestPath returns the record ingested to destPath, or nil when there is none
+func (q queries) FindByDestPath(destPath string) (*File, error) {
+	var file File
+	err := q.db.Where("dest_path = ?", destPath).First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query file by destination: %w", err)
+	}
+	return &file, nil
+}
+
+// FindByIdempotencyKey returns the record ingested with key, or nil when
+// there is none
+func (q queries) FindByIdempotencyKey(key string) (*File, error) {
+	var file File
+	err := q.db.Where("idempotency_key = ?", key).First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query file by idempotency key: %w", err)
+	}
+	return &file, nil
+}
+
+// FindByRelPathSize returns the latest record of a file of size ingested or
+// adopted from relPath whose content is still recorded under its hash, or
+// nil when there is none. A match is only as good as the name and size: the
+// content may differ.
+func (q queries) FindByRelPathSize(relPath string, size int64) (*File, error) {
+	var file File
+	err := q.db.Where("rel_path = ? AND size = ?", relPath, size).
+		Where("status IN ?", []status.Status{status.Ingested, status.Adopted}).
+		Order("id DESC").First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query file by path and size: %w", err)
+	}
+	return &file, nil
+}
+
+// LastByPath returns the latest record ingested or adopted from relPath
+// whose content is still recorded under its hash, or nil when there is none
+func (q queries) LastByPath(relPath string) (*File, error) {
+	var file File
+	err := q.db.Where("rel_path = ?", relPath).
+		Where("status IN ?", []status.Status{status.Ingested, status.Adopted}).
+		Order("id DESC").First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query file by path: %w", err)
+	}
+	return &file, nil
+}
+
+// ListFiles returns the records matching filter ordered by ID
+func (q queries) ListFiles(filter FileFilter) ([]File, error) {
+	query := q.db.Order("id")
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.RelPath != "" {
+		query = query.Where("rel_path = ?", filter.RelPath)
+	}
+
+	// Sorted keys keep the generated SQL stable
+	keys := make([]string, 0, len(filter.Tags))
+	for k := range filter.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		path, err := json.Marshal(k)
+		if err != nil {
+			return nil, fmt.Errorf("encode tag key: %w", err)
+		}
+		query = query.Where("json_extract(tags, ?) = ?", "$."+string(path), filter.Tags[k])
+	}
+
+	var files []File
+	if err := query.Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	return files, nil
+}
+
+// ProcessedBetween returns the records of the given statuses, every status
+// when none is given, processed between from and to inclusive, oldest first.
+// Records keep the local time the daemon stamped them with, and SQLite
+// compares times as text, so the bounds are local too.
+func (q queries) ProcessedBetween(from, to time.Time, statuses ...status.Status) ([]File, error) {
+	query := q.db.Where("processed_at >= ? AND processed_at <= ?", from.Local(), to.Local())
+	if len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+	var files []File
+	if err := query.Order("processed_at").Order("id").Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("list files processed between %s and %s: %w", from, to, err)
+	}
+	return files, nil
+}
+
+// ProcessedSpan returns when the oldest and the newest records of the given
+// statuses, every status when none is given, were processed, both zero
+// without records
+func (q queries) ProcessedSpan(statuses ...status.Status) (time.Time, time.Time, error) {
+	var first, last File
+	query := func() *gorm.DB {
+		if len(statuses) == 0 {
+			return q.db.Model(&File{})
+		}
+		return q.db.Model(&File{}).Where("status IN ?", statuses)
+	}
+	if err := query().Order("processed_at").Limit(1).Find(&first).Error; err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("find oldest file: %w", err)
+	}
+	if err := query().Order("processed_at DESC").Limit(1).Find(&last).Error; err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("find newest file: %w", err)
+	}
+	return first.ProcessedAt, last.ProcessedAt, nil
+}
+
+// CountByStatus returns the number of records for each status
+func (q queries) CountByStatus() (map[status.Status]int64, error) {
+	var rows []struct {
+		Status status.Status
+		Count  int64
+	}
+	if err := q.db.Model(&File{}).Select("status, count(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("count files by status: %w", err)
+	}
+	counts := make(map[status.Status]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
 // CreateFile stores a new file record in the database
 func (s *Storage) CreateFile(sha256, name, path string, size int64) error {
 	file := File{
-		Model: gorm.Model{
-			CreatedAt: time.Now(),
-		},
-		SHA256: sha256,
-		Name:   name,
-		Path:   path,
-		Size:   size,
+		CreatedAt: time.Now().UTC(),
+		SHA256:    sha256,
+		Name:      name,
+		Path:      path,
+		Size:      size,
+		Status:    status.Ingested,
 	}
 	if err := s.db.Create(&file).Error; err != nil {
 		return fmt.Errorf("create file record: %w", err)
@@ -62,10 +373,199 @@ func (s *Storage) CreateFile(sha256, name, path string, size int64) error {
 	return nil
 }
 
+// FileRecord holds the fields of a new file record
+type FileRecord struct {
+	SHA256       string
+	Name         string
+	OriginalName string
+	Path         string
+	Size         int64
+	Status       status.Status
+	DestPath     string
+	ProcessedAt  time.Time
+	Tags         Tags
+
+	RelPath        string
+	Version        int
+	PreviousSHA256 string
+	// IdempotencyKey is empty when the producer supplied none
+	IdempotencyKey string
+	AllocatedSize  int64
+	Sequence       int64
+	// Source defaults to the source of RelPath
+	Source string
+	// CreatedAt is when the record is created, against which the dedup
+	// window is measured; zero means now
+	CreatedAt time.Time
+}
+
+// CreateFileIfAbsent inserts a record unless one with the same SHA256, or the
+// same idempotency key, already exists. The insert uses ON CONFLICT DO
+// NOTHING (supported by both SQLite and PostgreSQL), so when two callers race
+// on identical content exactly one of them gets created == true and the other
+// receives the winning record. A record matching the hash is returned before
+// one matching only the key.
+func (s *Storage) CreateFileIfAbsent(rec FileRecord) (bool, *File, error) {
+	file := File{
+		CreatedAt:    rec.CreatedAt.UTC(),
+		SHA256:       rec.SHA256,
+		Name:         rec.Name,
+		OriginalName: rec.OriginalName,
+		Path:         rec.Path,
+		Size:         rec.Size,
+		Status:       rec.Status,
+		DestPath:     rec.DestPath,
+		ProcessedAt:  rec.ProcessedAt,
+		Tags:         rec.Tags,
+
+		RelPath:        rec.RelPath,
+		Version:        rec.Version,
+		PreviousSHA256: rec.PreviousSHA256,
+		AllocatedSize:  rec.AllocatedSize,
+		Sequence:       rec.Sequence,
+		Source:         rec.Source,
+	}
+	if file.Source == "" {
+		file.Source = SourceOf(rec.RelPath)
+	}
+	// In UTC, which the dedup window's cutoff compares in
+	if rec.CreatedAt.IsZero() {
+		file.CreatedAt = time.Now().UTC()
+	}
+	if rec.IdempotencyKey != "" {
+		file.IdempotencyKey = &rec.IdempotencyKey
+	}
+	// No conflict target, so a clash on either unique column is skipped
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&file)
+	if result.Error != nil {
+		return false, nil, fmt.Errorf("create file record: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return true, nil, nil
+	}
+
+	existing, err := s.FindBySHA256(rec.SHA256)
+	if err != nil {
+		return false, nil, err
+	}
+	if existing == nil && rec.IdempotencyKey != "" {
+		if existing, err = s.FindByIdempotencyKey(rec.IdempotencyKey); err != nil {
+			return false, nil, err
+		}
+	}
+	if existing == nil {
+		return false, nil, fmt.Errorf("conflicting record for sha256 %s not found", rec.SHA256)
+	}
+	return false, existing, nil
+}
+
+// UpdateSize records the final logical and allocated sizes of the file with
+// the given SHA256, in its record and in the manifest entry committed with
+// it
+func (s *Storage) UpdateSize(sha256 string, size, allocated int64) error {
+	return s.Transaction(func(tx *Storage) error {
+		file, err := tx.FindBySHA256(sha256)
+		if err != nil || file == nil {
+			return err
+		}
+		err = tx.db.Model(&File{}).
+			Where("id = ?", file.ID).
+			Updates(map[string]any{"size": size, "allocated_size": allocated}).Error
+		if err != nil {
+			return fmt.Errorf("update file size: %w", err)
+		}
+		err = tx.committedEntries(file).
+			Updates(map[string]any{"size": size, "allocated_size": allocated}).Error
+		if err != nil {
+			return fmt.Errorf("update manifest entry size: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateLinkCount records the link count of the warehouse copy of the file
+// with the given SHA256 on its committed manifest entries, once the copy
+// turned out to be hard-linked to its source
+func (s *Storage) UpdateLinkCount(sha256 string, links int) error {
+	file, err := s.FindBySHA256(sha256)
+	if err != nil || file == nil {
+		return err
+	}
+	if err := s.committedEntries(file).Update("link_count", links).Error; err != nil {
+		return fmt.Errorf("update manifest entry link count: %w", err)
+	}
+	return nil
+}
+
+// DeleteFile permanently removes the record with the given SHA256, releasing
+// the hash so the content can be ingested again
+func (s *Storage) DeleteFile(sha256 string) error {
+	if err := s.db.Where("sha256 = ?", sha256).Delete(&File{}).Error; err != nil {
+		return fmt.Errorf("delete file record: %w", err)
+	}
+	return nil
+}
+
+// MarkSuspect flags file as suspect and moves its record off the content
+// hash to <sha256>.suspect.<id>, so the verified content can be recorded
+// under the hash while the suspect record stays listed. Its idempotency key
+// is released for the same reason. Marking a record that is already suspect
+// is a no-op.
+func (s *Storage) MarkSuspect(file *File) error {
+	err := s.db.Model(&File{}).
+		Where("id = ? AND sha256 = ?", file.ID, file.SHA256).
+		Updates(map[string]any{
+			"status":          status.Suspect,
+			"sha256":          fmt.Sprintf(suspectKeyFormat, file.SHA256, file.ID),
+			"idempotency_key": nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("mark file record suspect: %w", err)
+	}
+	return nil
+}
+
+// MarkMissingRestored flags file as missing_restored and, like MarkSuspect,
+// moves its record off the content hash to <sha256>.restored.<id> and
+// releases its idempotency key, so the arrival replacing the missing copy
+// can be recorded under them. Marking a record already moved is a no-op.
+func (s *Storage) MarkMissingRestored(file *File) error {
+	err := s.db.Model(&File{}).
+		Where("id = ? AND sha256 = ?", file.ID, file.SHA256).
+		Updates(map[string]any{
+			"status":          status.MissingRestored,
+			"sha256":          fmt.Sprintf(restoredKeyFormat, file.SHA256, file.ID),
+			"idempotency_key": nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("mark file record missing_restored: %w", err)
+	}
+	return nil
+}
+
+// MarkSuperseded flags file as superseded and, like MarkMissingRestored,
+// moves its record off the content hash to <sha256>.superseded.<id> and
+// releases its idempotency key, so the arrival ingested after the dedup
+// window can be recorded under them. Marking a record already moved is a
+// no-op.
+func (s *Storage) MarkSuperseded(file *File) error {
+	err := s.db.Model(&File{}).
+		Where("id = ? AND sha256 = ?", file.ID, file.SHA256).
+		Updates(map[string]any{
+			"status":          status.Superseded,
+			"sha256":          fmt.Sprintf(supersededKeyFormat, file.SHA256, file.ID),
+			"idempotency_key": nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("mark file record superseded: %w", err)
+	}
+	return nil
+}
+
 // Transaction wraps operations in a database transaction
 func (s *Storage) Transaction(fn func(*Storage) error) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		txStorage := &Storage{db: tx}
+		txStorage := New(tx)
 		return fn(txStorage)
 	})
 }
@@ -1,13 +1,18 @@
 package storage
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
 )
 
 func setupTestDB(t *testing.T) (*Storage, func()) {
@@ -68,9 +73,9 @@ func TestCreateFile(t *testing.T) {
 	}
 
 	// Verify file exists
-	exists, err := store.FileExists("abc123")
+	exists, err := store.FileExistsSince("abc123", time.Time{})
 	if err != nil {
-		t.Fatalf("FileExists failed: %v", err)
+		t.Fatalf("FileExistsSince failed: %v", err)
 	}
 	if !exists {
 		t.Error("file should exist after creation")
@@ -95,20 +100,20 @@ func TestCreateFile_Duplicate(t *testing.T) {
 	}
 }
 
-func TestFileExists_NotFound(t *testing.T) {
+func TestFileExistsSince_NotFound(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
 
-	exists, err := store.FileExists("nonexistent")
+	exists, err := store.FileExistsSince("nonexistent", time.Time{})
 	if err != nil {
-		t.Fatalf("FileExists failed: %v", err)
+		t.Fatalf("FileExistsSince failed: %v", err)
 	}
 	if exists {
 		t.Error("file should not exist")
 	}
 }
 
-func TestFileExists_Found(t *testing.T) {
+func TestFileExistsSince_Found(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -118,15 +123,45 @@ func TestFileExists_Found(t *testing.T) {
 		t.Fatalf("CreateFile failed: %v", err)
 	}
 
-	exists, err := store.FileExists(sha256)
+	exists, err := store.FileExistsSince(sha256, time.Time{})
 	if err != nil {
-		t.Fatalf("FileExists failed: %v", err)
+		t.Fatalf("FileExistsSince failed: %v", err)
 	}
 	if !exists {
 		t.Error("file should exist")
 	}
 }
 
+func TestFileExistsSince_Cutoff(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	recorded := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "quarter123", Name: "q1.csv", Status: status.Ingested, CreatedAt: recorded}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		cutoff time.Time
+		want   bool
+	}{
+		{time.Time{}, true},
+		{recorded.Add(-time.Hour), true},
+		{recorded, true},
+		{recorded.Add(time.Hour), false},
+		// Cutoffs in other zones compare by instant
+		{recorded.Add(-time.Minute).In(time.FixedZone("UTC+3", 3*3600)), true},
+	} {
+		exists, err := store.FileExistsSince("quarter123", tc.cutoff)
+		if err != nil {
+			t.Fatalf("FileExistsSince failed: %v", err)
+		}
+		if exists != tc.want {
+			t.Errorf("FileExistsSince(cutoff %v) = %v, want %v", tc.cutoff, exists, tc.want)
+		}
+	}
+}
+
 func TestTransaction_Success(t *testing.T) {
 	store, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -139,9 +174,9 @@ func TestTransaction_Success(t *testing.T) {
 	}
 
 	// Verify file was created
-	exists, err := store.FileExists("tx123")
+	exists, err := store.FileExistsSince("tx123", time.Time{})
 	if err != nil {
-		t.Fatalf("FileExists failed: %v", err)
+		t.Fatalf("FileExistsSince failed: %v", err)
 	}
 	if !exists {
 		t.Error("file should exist after successful transaction")
@@ -168,9 +203,9 @@ func TestTransaction_Rollback(t *testing.T) {
 	}
 
 	// Original file should still exist
-	exists, err := store.FileExists("first123")
+	exists, err := store.FileExistsSince("first123", time.Time{})
 	if err != nil {
-		t.Fatalf("FileExists failed: %v", err)
+		t.Fatalf("FileExistsSince failed: %v", err)
 	}
 	if !exists {
 		t.Error("original file should still exist after failed transaction")
@@ -201,12 +236,529 @@ func TestCreateFile_MultipleFiles(t *testing.T) {
 
 	// Verify all files exist
 	for _, f := range files {
-		exists, err := store.FileExists(f.sha256)
+		exists, err := store.FileExistsSince(f.sha256, time.Time{})
 		if err != nil {
-			t.Fatalf("FileExists failed for %s: %v", f.sha256, err)
+			t.Fatalf("FileExistsSince failed for %s: %v", f.sha256, err)
 		}
 		if !exists {
 			t.Errorf("file %s should exist", f.sha256)
 		}
 	}
 }
+
+func TestCreateFileIfAbsent(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	mtime := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	rec := FileRecord{
+		SHA256:      "absent123",
+		Name:        "old.csv",
+		Size:        42,
+		Status:      status.Adopted,
+		DestPath:    "/warehouse/old.csv",
+		ProcessedAt: mtime,
+	}
+
+	created, existing, err := store.CreateFileIfAbsent(rec)
+	if err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+	if !created || existing != nil {
+		t.Errorf("first insert: created = %v existing = %v, want true and nil", created, existing)
+	}
+
+	// Inserting the same content again must return the original record
+	created, existing, err = store.CreateFileIfAbsent(FileRecord{SHA256: "absent123", Name: "copy.csv", DestPath: "/warehouse/copy.csv"})
+	if err != nil {
+		t.Fatalf("second CreateFileIfAbsent failed: %v", err)
+	}
+	if created {
+		t.Error("expected second insert to be skipped")
+	}
+	if existing == nil {
+		t.Fatal("expected existing record on conflict")
+	}
+	if existing.Status != status.Adopted {
+		t.Errorf("Status = %q, want %q", existing.Status, status.Adopted)
+	}
+	if existing.DestPath != "/warehouse/old.csv" {
+		t.Errorf("DestPath = %q, want %q", existing.DestPath, "/warehouse/old.csv")
+	}
+	if !existing.ProcessedAt.Equal(mtime) {
+		t.Errorf("ProcessedAt = %v, want %v", existing.ProcessedAt, mtime)
+	}
+}
+
+func TestCreateFileIfAbsent_IdempotencyKey(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Files without a key never conflict with each other
+	for _, hash := range []string{"nokey1", "nokey2"} {
+		if created, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: hash, Name: hash + ".csv"}); err != nil || !created {
+			t.Fatalf("CreateFileIfAbsent(%s) = %v, %v, want created", hash, created, err)
+		}
+	}
+
+	if created, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "keyed1", Name: "a.csv", IdempotencyKey: "order-7"}); err != nil || !created {
+		t.Fatalf("first keyed insert = %v, %v, want created", created, err)
+	}
+	created, existing, err := store.CreateFileIfAbsent(FileRecord{SHA256: "keyed2", Name: "a.csv", IdempotencyKey: "order-7"})
+	if err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+	if created || existing == nil || existing.SHA256 != "keyed1" {
+		t.Errorf("key conflict: created = %v existing = %+v, want the keyed1 record", created, existing)
+	}
+}
+
+func TestFindByIdempotencyKey(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	file, err := store.FindByIdempotencyKey("missing")
+	if err != nil || file != nil {
+		t.Fatalf("FindByIdempotencyKey(missing) = %+v, %v, want nil", file, err)
+	}
+
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "key123", Name: "a.csv", IdempotencyKey: "batch-1"}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+	file, err = store.FindByIdempotencyKey("batch-1")
+	if err != nil || file == nil || file.SHA256 != "key123" {
+		t.Fatalf("FindByIdempotencyKey(batch-1) = %+v, %v, want the key123 record", file, err)
+	}
+
+	// A suspect record gives its key up, so a good copy can claim it again
+	if err := store.MarkSuspect(file); err != nil {
+		t.Fatalf("MarkSuspect failed: %v", err)
+	}
+	if file, err := store.FindByIdempotencyKey("batch-1"); err != nil || file != nil {
+		t.Errorf("FindByIdempotencyKey after MarkSuspect = %+v, %v, want nil", file, err)
+	}
+}
+
+func TestFindByRelPathSize(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, rec := range []FileRecord{
+		{SHA256: "old", Name: "a.csv", RelPath: "acme/a.csv", Size: 10, Status: status.Ingested},
+		{SHA256: "new", Name: "a.csv", RelPath: "acme/a.csv", Size: 10, Status: status.Ingested},
+		{SHA256: "gone", Name: "b.csv", RelPath: "acme/b.csv", Size: 10, Status: status.Suspect},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent(%s) failed: %v", rec.SHA256, err)
+		}
+	}
+
+	file, err := store.FindByRelPathSize("acme/a.csv", 10)
+	if err != nil || file == nil || file.SHA256 != "new" {
+		t.Errorf("FindByRelPathSize(acme/a.csv) = %+v, %v, want the latest record", file, err)
+	}
+	for _, tt := range []struct {
+		relPath string
+		size    int64
+	}{{"acme/a.csv", 11}, {"acme/b.csv", 10}, {"acme/c.csv", 10}} {
+		if file, err := store.FindByRelPathSize(tt.relPath, tt.size); err != nil || file != nil {
+			t.Errorf("FindByRelPathSize(%s, %d) = %+v, %v, want nil", tt.relPath, tt.size, file, err)
+		}
+	}
+}
+
+func TestLastByPath(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, rec := range []FileRecord{
+		{SHA256: "old", Name: "a.csv", RelPath: "acme/a.csv", Size: 100, Status: status.Ingested},
+		{SHA256: "new", Name: "a.csv", RelPath: "acme/a.csv", Size: 10, Status: status.Ingested},
+		{SHA256: "gone", Name: "b.csv", RelPath: "acme/b.csv", Size: 10, Status: status.Suspect},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent(%s) failed: %v", rec.SHA256, err)
+		}
+	}
+
+	file, err := store.LastByPath("acme/a.csv")
+	if err != nil || file == nil || file.SHA256 != "new" {
+		t.Errorf("LastByPath(acme/a.csv) = %+v, %v, want the latest record", file, err)
+	}
+	for _, relPath := range []string{"acme/b.csv", "acme/c.csv"} {
+		if file, err := store.LastByPath(relPath); err != nil || file != nil {
+			t.Errorf("LastByPath(%s) = %+v, %v, want nil", relPath, file, err)
+		}
+	}
+}
+
+func TestCreateFileIfAbsent_Concurrent(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	const racers = 2
+	for round := 0; round < 20; round++ {
+		hash := fmt.Sprintf("race%d", round)
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		created := make([]bool, racers)
+		existing := make([]*File, racers)
+		errs := make([]error, racers)
+
+		for i := 0; i < racers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				created[i], existing[i], errs[i] = store.CreateFileIfAbsent(FileRecord{
+					SHA256: hash,
+					Name:   fmt.Sprintf("racer%d.csv", i),
+					Status: status.Ingested,
+				})
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		winners := 0
+		for i := 0; i < racers; i++ {
+			if errs[i] != nil {
+				t.Fatalf("round %d racer %d failed: %v", round, i, errs[i])
+			}
+			if created[i] {
+				winners++
+				continue
+			}
+			if existing[i] == nil || existing[i].SHA256 != hash {
+				t.Errorf("round %d racer %d lost without the winning record", round, i)
+			}
+		}
+		if winners != 1 {
+			t.Errorf("round %d: %d winners, want exactly 1", round, winners)
+		}
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.CreateFile("delete123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+	if err := store.DeleteFile("delete123"); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+
+	exists, err := store.FileExistsSince("delete123", time.Time{})
+	if err != nil {
+		t.Fatalf("FileExistsSince failed: %v", err)
+	}
+	if exists {
+		t.Error("file should not exist after DeleteFile")
+	}
+
+	// The hash must be free for a new record
+	if err := store.CreateFile("delete123", "again.txt", "/path/again.txt", 10); err != nil {
+		t.Errorf("CreateFile after DeleteFile failed: %v", err)
+	}
+}
+
+func TestMarkSuspect(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.CreateFile("suspect123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+	file, err := store.FindBySHA256("suspect123")
+	if err != nil || file == nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if err := store.MarkSuspect(file); err != nil {
+		t.Fatalf("MarkSuspect failed: %v", err)
+	}
+	// A second mark of the stale record changes nothing
+	if err := store.MarkSuspect(file); err != nil {
+		t.Fatalf("MarkSuspect again failed: %v", err)
+	}
+
+	if exists, _ := store.FileExistsSince("suspect123", time.Time{}); exists {
+		t.Error("the hash should be free for the verified content")
+	}
+	suspects, err := store.ListFiles(FileFilter{Status: status.Suspect})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	want := fmt.Sprintf("suspect123.suspect.%d", file.ID)
+	if len(suspects) != 1 || suspects[0].SHA256 != want {
+		t.Errorf("suspects = %+v, want one keyed %s", suspects, want)
+	}
+	if err := store.CreateFile("suspect123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Errorf("CreateFile for the freed hash failed: %v", err)
+	}
+}
+
+func TestMarkMissingRestored(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := store.CreateFile("lost123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+	file, err := store.FindBySHA256("lost123")
+	if err != nil || file == nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if err := store.MarkMissingRestored(file); err != nil {
+		t.Fatalf("MarkMissingRestored failed: %v", err)
+	}
+
+	restored, err := store.ListFiles(FileFilter{Status: status.MissingRestored})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	want := fmt.Sprintf("lost123.restored.%d", file.ID)
+	if len(restored) != 1 || restored[0].SHA256 != want {
+		t.Errorf("restored = %+v, want one keyed %s", restored, want)
+	}
+	if err := store.CreateFile("lost123", "test.txt", "/path/test.txt", 10); err != nil {
+		t.Errorf("CreateFile for the freed hash failed: %v", err)
+	}
+}
+
+func TestMarkSuperseded(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	key := "quarterly"
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "old123", Name: "q1.csv", Status: status.Ingested, IdempotencyKey: key}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+	file, err := store.FindBySHA256("old123")
+	if err != nil || file == nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if err := store.MarkSuperseded(file); err != nil {
+		t.Fatalf("MarkSuperseded failed: %v", err)
+	}
+
+	superseded, err := store.ListFiles(FileFilter{Status: status.Superseded})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	want := fmt.Sprintf("old123.superseded.%d", file.ID)
+	if len(superseded) != 1 || superseded[0].SHA256 != want || superseded[0].IdempotencyKey != nil {
+		t.Errorf("superseded = %+v, want one keyed %s without its idempotency key", superseded, want)
+	}
+	created, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "old123", Name: "q2.csv", Status: status.Ingested, IdempotencyKey: key})
+	if err != nil || !created {
+		t.Errorf("CreateFileIfAbsent for the freed hash and key = %v, %v", created, err)
+	}
+}
+
+func TestFindBySHA256(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	file, err := store.FindBySHA256("missing")
+	if err != nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if file != nil {
+		t.Errorf("expected nil for missing hash, got %+v", file)
+	}
+
+	if err := store.CreateFile("found123", "test.txt", "/path/to/test.txt", 1024); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+
+	file, err = store.FindBySHA256("found123")
+	if err != nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if file == nil {
+		t.Fatal("expected record, got nil")
+	}
+	if file.Path != "/path/to/test.txt" {
+		t.Errorf("Path = %q, want %q", file.Path, "/path/to/test.txt")
+	}
+	if file.Status != status.Ingested {
+		t.Errorf("Status = %q, want %q", file.Status, status.Ingested)
+	}
+}
+
+func TestFindByDestPath(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	file, err := store.FindByDestPath("/warehouse/a.csv")
+	if err != nil {
+		t.Fatalf("FindByDestPath failed: %v", err)
+	}
+	if file != nil {
+		t.Errorf("expected nil for missing destination, got %+v", file)
+	}
+
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{
+		SHA256:   "dest123",
+		Name:     "a.csv",
+		Status:   status.Ingested,
+		DestPath: "/warehouse/a.csv",
+	}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+
+	file, err = store.FindByDestPath("/warehouse/a.csv")
+	if err != nil {
+		t.Fatalf("FindByDestPath failed: %v", err)
+	}
+	if file == nil || file.SHA256 != "dest123" {
+		t.Errorf("FindByDestPath = %+v, want record dest123", file)
+	}
+}
+
+func TestListFiles_TagFilter(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	records := []FileRecord{
+		{SHA256: "t1", Status: status.Ingested, Tags: Tags{"classification": "restricted", "tier": "bulk"}},
+		{SHA256: "t2", Status: status.Ingested, Tags: Tags{"classification": "restricted"}},
+		{SHA256: "t3", Status: status.Adopted},
+	}
+	for _, rec := range records {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter FileFilter
+		want   []string
+	}{
+		{"all", FileFilter{}, []string{"t1", "t2", "t3"}},
+		{"status", FileFilter{Status: status.Adopted}, []string{"t3"}},
+		{"one tag", FileFilter{Tags: map[string]string{"classification": "restricted"}}, []string{"t1", "t2"}},
+		{"two tags", FileFilter{Tags: map[string]string{"classification": "restricted", "tier": "bulk"}}, []string{"t1"}},
+		{"no match", FileFilter{Tags: map[string]string{"tier": "hot"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := store.ListFiles(tt.filter)
+			if err != nil {
+				t.Fatalf("ListFiles failed: %v", err)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, f.SHA256)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("ListFiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	file, err := store.FindBySHA256("t1")
+	if err != nil {
+		t.Fatalf("FindBySHA256 failed: %v", err)
+	}
+	if file.Tags["tier"] != "bulk" {
+		t.Errorf("Tags = %v, want tier=bulk", file.Tags)
+	}
+}
+
+func TestCountByStatus(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, rec := range []FileRecord{
+		{SHA256: "c1", Status: status.Ingested},
+		{SHA256: "c2", Status: status.Ingested},
+		{SHA256: "c3", Status: status.Adopted},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent failed: %v", err)
+		}
+	}
+
+	counts, err := store.CountByStatus()
+	if err != nil {
+		t.Fatalf("CountByStatus failed: %v", err)
+	}
+	if counts[status.Ingested] != 2 || counts[status.Adopted] != 1 || len(counts) != 2 {
+		t.Errorf("CountByStatus = %v, want 2 ingested and 1 adopted", counts)
+	}
+}
+
+func TestDeleteFile_VisibleToNoQuery(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, rec := range []FileRecord{
+		{SHA256: "keep", Status: status.Ingested, DestPath: "/warehouse/keep"},
+		{SHA256: "gone", Status: status.Ingested, DestPath: "/warehouse/gone"},
+	} {
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent failed: %v", err)
+		}
+	}
+	if err := store.DeleteFile("gone"); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+
+	// The row is gone, not hidden
+	var rows int64
+	if err := store.db.Table("files").Where("sha256 = ?", "gone").Count(&rows).Error; err != nil {
+		t.Fatalf("raw count failed: %v", err)
+	}
+	if rows != 0 {
+		t.Errorf("deleted row still stored (%d rows)", rows)
+	}
+
+	if file, err := store.FindByDestPath("/warehouse/gone"); err != nil || file != nil {
+		t.Errorf("FindByDestPath = %v, %v; want nil", file, err)
+	}
+	files, err := store.ListFiles(FileFilter{})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0].SHA256 != "keep" {
+		t.Errorf("ListFiles = %v, want only keep", files)
+	}
+	counts, err := store.CountByStatus()
+	if err != nil {
+		t.Fatalf("CountByStatus failed: %v", err)
+	}
+	if counts[status.Ingested] != 1 {
+		t.Errorf("CountByStatus = %v, want 1 ingested", counts)
+	}
+
+	// Re-ingesting the content claims the hash again
+	created, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "gone", Status: status.Ingested})
+	if err != nil || !created {
+		t.Errorf("CreateFileIfAbsent after delete = %v, %v; want created", created, err)
+	}
+}
+
+func TestUpdateSize(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "grow123", Name: "a.log", Size: 10, AllocatedSize: 8 << 20}); err != nil {
+		t.Fatalf("CreateFileIfAbsent failed: %v", err)
+	}
+	if err := store.UpdateSize("grow123", 25, 0); err != nil {
+		t.Fatalf("UpdateSize failed: %v", err)
+	}
+	file, err := store.FindBySHA256("grow123")
+	if err != nil || file == nil {
+		t.Fatalf("FindBySHA256() = %+v, %v", file, err)
+	}
+	if file.Size != 25 || file.AllocatedSize != 0 {
+		t.Errorf("sizes = %d, %d, want 25, 0", file.Size, file.AllocatedSize)
+	}
+}
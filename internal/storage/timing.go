@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// Timing is the per-stage breakdown of one sampled file, from when it was
+// queued for hashing to when its outcome was recorded. The stages cover
+// that span, so they add up to Total.
+type Timing struct {
+	ID     uint   `gorm:"primaryKey" json:"-"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Outcome is the outcome the file reached, such as ingested
+	Outcome   status.Status `json:"outcome"`
+	StartedAt time.Time     `gorm:"index" json:"started_at"`
+	Total     time.Duration `json:"total_ns"`
+	// Queue is the time spent waiting for a hash and then a copy worker
+	Queue time.Duration `json:"queue_ns"`
+	// Hash covers stat, space reservation and staging as well as hashing
+	Hash  time.Duration `json:"hash_ns"`
+	Dedup time.Duration `json:"dedup_ns"`
+	// Transform covers the steps between dedup and claim: resolving the
+	// destination, validation, compression and tagging
+	Transform time.Duration `json:"transform_ns"`
+	// Commit is the claim transaction of the database record and its
+	// manifest entry and notification
+	Commit time.Duration `json:"commit_ns"`
+	// Copy is placing the file in the warehouse, or in quarantine, less its
+	// fsync
+	Copy     time.Duration `json:"copy_ns"`
+	Fsync    time.Duration `json:"fsync_ns"`
+	Manifest time.Duration `json:"manifest_ns"`
+	// Notify covers the receipt and the outcome's event
+	Notify time.Duration `json:"notify_ns"`
+}
+
+// RecordTimings stores a batch of timings in one insert
+func (s *Storage) RecordTimings(timings []Timing) error {
+	if len(timings) == 0 {
+		return nil
+	}
+	for i := range timings {
+		timings[i].StartedAt = timings[i].StartedAt.UTC()
+	}
+	if err := s.db.Create(&timings).Error; err != nil {
+		return fmt.Errorf("record timings: %w", err)
+	}
+	return nil
+}
+
+// SlowFiles returns up to limit timings of files started within [from, to),
+// the slowest first. A limit that is not positive returns them all.
+func (q queries) SlowFiles(from, to time.Time, limit int) ([]Timing, error) {
+	var timings []Timing
+	query := q.db.
+		Where("started_at >= ? AND started_at < ?", from.UTC(), to.UTC()).
+		Order("total DESC").Order("id")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&timings).Error; err != nil {
+		return nil, fmt.Errorf("query slow files: %w", err)
+	}
+	return timings, nil
+}
+
+// PruneTimings deletes the timings of files started before cutoff and
+// returns how many it deleted
+func (s *Storage) PruneTimings(cutoff time.Time) (int64, error) {
+	res := s.db.Where("started_at < ?", cutoff.UTC()).Delete(&Timing{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("prune timings: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
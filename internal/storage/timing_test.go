@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowFiles(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	at := time.Date(2024, 6, 15, 10, 0, 0, 0, time.UTC)
+	timing := func(path string, minute int, total time.Duration) Timing {
+		return Timing{Path: path, Outcome: "ingested", StartedAt: at.Add(time.Duration(minute) * time.Minute), Total: total, Copy: total}
+	}
+	if err := store.RecordTimings([]Timing{
+		timing("/in/a.csv", 0, time.Second),
+		timing("/in/b.csv", 1, 3*time.Second),
+		timing("/in/c.csv", 2, 2*time.Second),
+		// Outside the range, however slow
+		timing("/in/d.csv", 60, time.Minute),
+	}); err != nil {
+		t.Fatalf("RecordTimings() error = %v", err)
+	}
+
+	got, err := store.SlowFiles(at, at.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatalf("SlowFiles() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Path != "/in/b.csv" || got[1].Path != "/in/c.csv" {
+		t.Fatalf("SlowFiles() = %+v, want b then c", got)
+	}
+	if got[0].Copy != 3*time.Second {
+		t.Errorf("copy = %v, want the recorded breakdown", got[0].Copy)
+	}
+
+	n, err := store.PruneTimings(at.Add(90 * time.Second))
+	if err != nil || n != 2 {
+		t.Errorf("PruneTimings() = %d, %v, want a and b deleted", n, err)
+	}
+	if got, _ := store.SlowFiles(at, at.Add(2*time.Hour), 0); len(got) != 2 || got[0].Path != "/in/d.csv" {
+		t.Errorf("SlowFiles() after prune = %+v, want d and c", got)
+	}
+}
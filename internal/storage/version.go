@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PathSequence is the last version number handed out for a relative input
+// path. Numbers are never reused, so a version whose ingest failed leaves a
+// gap rather than a second file under the same name.
+type PathSequence struct {
+	RelPath   string `gorm:"primaryKey"`
+	Last      int
+	UpdatedAt time.Time
+}
+
+// NextVersion reserves the next version number of relPath. The number is
+// greater than floor, which lets callers account for versions ingested
+// before the sequence existed. Call it inside a Transaction so the
+// reservation and the file record commit together.
+func (s *Storage) NextVersion(relPath string, floor int) (int, error) {
+	var seq PathSequence
+	err := s.db.Where("rel_path = ?", relPath).First(&seq).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("query path sequence: %w", err)
+	}
+
+	seq.RelPath = relPath
+	seq.Last = max(seq.Last, floor) + 1
+	if err := s.db.Save(&seq).Error; err != nil {
+		return 0, fmt.Errorf("save path sequence: %w", err)
+	}
+	return seq.Last, nil
+}
+
+// LatestVersion returns the most recent record ingested from relPath, or nil
+// when there is none
+func (q queries) LatestVersion(relPath string) (*File, error) {
+	var file File
+	err := q.db.Where("rel_path = ?", relPath).Order("version DESC").Order("id DESC").First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query latest version: %w", err)
+	}
+	return &file, nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextVersion(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for want := 1; want <= 3; want++ {
+		got, err := store.NextVersion("latest.csv", 0)
+		if err != nil {
+			t.Fatalf("NextVersion() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("NextVersion() = %d, want %d", got, want)
+		}
+	}
+
+	// Paths are counted independently, and the floor skips taken numbers
+	got, err := store.NextVersion("other.csv", 4)
+	if err != nil {
+		t.Fatalf("NextVersion() error = %v", err)
+	}
+	if got != 5 {
+		t.Errorf("NextVersion() with floor = %d, want 5", got)
+	}
+}
+
+func TestNextVersion_RolledBack(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	errAbort := errors.New("abort")
+	err := store.Transaction(func(tx *Storage) error {
+		if _, err := tx.NextVersion("latest.csv", 0); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Transaction() error = %v, want %v", err, errAbort)
+	}
+
+	got, err := store.NextVersion("latest.csv", 0)
+	if err != nil {
+		t.Fatalf("NextVersion() error = %v", err)
+	}
+	if got != 1 {
+		t.Errorf("NextVersion() after rollback = %d, want 1", got)
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	store, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	latest, err := store.LatestVersion("latest.csv")
+	if err != nil {
+		t.Fatalf("LatestVersion() error = %v", err)
+	}
+	if latest != nil {
+		t.Errorf("LatestVersion() = %+v, want nil", latest)
+	}
+
+	for i, sha := range []string{"v1", "v2", "v3"} {
+		rec := FileRecord{SHA256: sha, RelPath: "latest.csv", Version: i + 1, ProcessedAt: time.Now()}
+		if _, _, err := store.CreateFileIfAbsent(rec); err != nil {
+			t.Fatalf("CreateFileIfAbsent() error = %v", err)
+		}
+	}
+	if _, _, err := store.CreateFileIfAbsent(FileRecord{SHA256: "other", RelPath: "other.csv", Version: 9}); err != nil {
+		t.Fatalf("CreateFileIfAbsent() error = %v", err)
+	}
+
+	latest, err = store.LatestVersion("latest.csv")
+	if err != nil {
+		t.Fatalf("LatestVersion() error = %v", err)
+	}
+	if latest == nil || latest.SHA256 != "v3" {
+		t.Errorf("LatestVersion() = %+v, want v3", latest)
+	}
+
+	chain, err := store.ListFiles(FileFilter{RelPath: "latest.csv"})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if len(chain) != 3 || chain[0].SHA256 != "v1" || chain[2].SHA256 != "v3" {
+		t.Errorf("ListFiles() version chain = %+v", chain)
+	}
+}
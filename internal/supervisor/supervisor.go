@@ -0,0 +1,315 @@
+// Package supervisor keeps the long-lived background loops of the daemon
+// running. Each loop reports progress with Beat; a loop that exits or stops
+// beating is restarted with backoff when that is safe, and otherwise marks
+// the daemon not ready and raises an alert.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Loop states
+const (
+	// StateRunning marks a loop running and beating in time
+	StateRunning = "running"
+	// StateStalled marks a loop that missed its heartbeat
+	StateStalled = "stalled"
+	// StateRestarting marks a loop waiting out its backoff after it exited
+	StateRestarting = "restarting"
+	// StateFailed marks a loop that exited and is not restarted
+	StateFailed = "failed"
+	// StateStopped marks a loop that returned on shutdown
+	StateStopped = "stopped"
+)
+
+// Supervision timing. The backoff between restarts doubles with every exit
+// and starts over once the loop ran for maxBackoff; heartbeats are checked
+// every checkInterval; on shutdown, loops get stopTimeout to return.
+const (
+	defaultMinBackoff    = time.Second
+	defaultMaxBackoff    = time.Minute
+	defaultCheckInterval = 5 * time.Second
+	defaultStopTimeout   = 10 * time.Second
+)
+
+// errReturned is the error of a loop that returned while it should still run
+var errReturned = errors.New("loop returned")
+
+// Loop is a background loop run by the supervisor
+type Loop struct {
+	Name string
+	// Run runs the loop until ctx is canceled, calling Beat(ctx) as it
+	// makes progress
+	Run func(ctx context.Context) error
+	// Restart restarts the loop after it exits or stalls. A loop that is
+	// not safe to start again marks the supervisor not ready instead.
+	Restart bool
+	// Stall is how long the loop may go without a beat before it is
+	// considered stalled; 0 never considers it stalled
+	Stall time.Duration
+}
+
+// LoopStatus is the health of a supervised loop
+type LoopStatus struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Restartable bool   `json:"restartable"`
+	Restarts    int    `json:"restarts"`
+	// Since is when the loop entered its state
+	Since    time.Time `json:"since"`
+	LastBeat time.Time `json:"last_beat"`
+	// Error is why the loop last exited or stalled
+	Error string `json:"error,omitempty"`
+}
+
+// Supervisor runs loops and tracks their health
+type Supervisor struct {
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	checkInterval time.Duration
+	stopTimeout   time.Duration
+
+	mu    sync.Mutex
+	loops []*loop
+}
+
+// loop is the supervision state of one Loop. The fields below mu are
+// guarded by the supervisor's mu.
+type loop struct {
+	Loop
+	lastBeat atomic.Int64
+
+	state    string
+	restarts int
+	since    time.Time
+	err      string
+	// cancel stops the current run of the loop
+	cancel context.CancelFunc
+}
+
+// New creates a Supervisor without loops
+func New() *Supervisor {
+	return &Supervisor{
+		minBackoff:    defaultMinBackoff,
+		maxBackoff:    defaultMaxBackoff,
+		checkInterval: defaultCheckInterval,
+		stopTimeout:   defaultStopTimeout,
+	}
+}
+
+// Add registers a loop. Loops must be added before Run.
+func (s *Supervisor) Add(l Loop) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sl := &loop{Loop: l, state: StateRunning, since: time.Now()}
+	sl.lastBeat.Store(sl.since.UnixNano())
+	s.loops = append(s.loops, sl)
+}
+
+type beatKey struct{}
+
+// Beat records progress of the loop whose context is ctx. Contexts of
+// unsupervised callers are ignored.
+func Beat(ctx context.Context) {
+	if l, ok := ctx.Value(beatKey{}).(*loop); ok {
+		l.lastBeat.Store(time.Now().UnixNano())
+	}
+}
+
+// Run runs every loop and watches their heartbeats until ctx is canceled,
+// then waits up to the stop timeout for the loops to return
+func (s *Supervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	loops := s.loops
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, l := range loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.keep(ctx, l)
+		}()
+	}
+
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			returned := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(returned)
+			}()
+			select {
+			case <-returned:
+			case <-time.After(s.stopTimeout):
+				slog.Warn("background loops did not stop in time", "timeout", s.stopTimeout)
+			}
+			return
+		case now := <-ticker.C:
+			for _, l := range loops {
+				s.checkStall(l, now)
+			}
+		}
+	}
+}
+
+// keep runs l until ctx is canceled, restarting it with backoff when it
+// exits and it is restartable
+func (s *Supervisor) keep(ctx context.Context, l *loop) {
+	backoff := s.minBackoff
+	for {
+		runCtx, cancel := context.WithCancel(context.WithValue(ctx, beatKey{}, l))
+		l.lastBeat.Store(time.Now().UnixNano())
+		s.mu.Lock()
+		l.cancel = cancel
+		if l.state != StateRunning {
+			l.state, l.since = StateRunning, time.Now()
+		}
+		s.mu.Unlock()
+
+		started := time.Now()
+		err := runLoop(runCtx, l.Run)
+		cancel()
+		if ctx.Err() != nil {
+			s.setState(l, StateStopped, nil)
+			return
+		}
+		if err == nil || errors.Is(err, context.Canceled) {
+			// Canceled while the supervisor runs on means it stalled
+			err = errReturned
+			if s.stateOf(l) == StateStalled {
+				err = errors.New("restarted after stalling")
+			}
+		}
+
+		if !l.Restart {
+			s.setState(l, StateFailed, err)
+			slog.Error("background loop failed, not restarting it and marking the daemon not ready",
+				"loop", l.Name,
+				"error", err,
+			)
+			return
+		}
+
+		if time.Since(started) >= s.maxBackoff {
+			backoff = s.minBackoff
+		}
+		s.mu.Lock()
+		l.restarts++
+		s.mu.Unlock()
+		s.setState(l, StateRestarting, err)
+		slog.Error("background loop stopped, restarting",
+			"loop", l.Name,
+			"error", err,
+			"backoff", backoff,
+		)
+		select {
+		case <-ctx.Done():
+			s.setState(l, StateStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.maxBackoff)
+	}
+}
+
+// runLoop runs fn, turning a panic into an error
+func runLoop(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			slog.Error("background loop panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		}
+	}()
+	return fn(ctx)
+}
+
+// checkStall marks l stalled when it missed its heartbeat, canceling it
+// for a restart when it is restartable, and running again once it beats
+func (s *Supervisor) checkStall(l *loop, now time.Time) {
+	if l.Stall <= 0 {
+		return
+	}
+	last := time.Unix(0, l.lastBeat.Load())
+	silent := now.Sub(last)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case l.state == StateRunning && silent > l.Stall:
+		l.state, l.since = StateStalled, now
+		l.err = fmt.Sprintf("no heartbeat for %s", silent.Round(time.Second))
+		if l.Restart {
+			slog.Error("background loop stalled, restarting", "loop", l.Name, "last_beat", last, "stall", l.Stall)
+			if l.cancel != nil {
+				l.cancel()
+			}
+			return
+		}
+		slog.Error("background loop stalled, the daemon is not ready until it beats again",
+			"loop", l.Name,
+			"last_beat", last,
+			"stall", l.Stall,
+		)
+	case l.state == StateStalled && silent <= l.Stall:
+		l.state, l.since = StateRunning, now
+		slog.Info("background loop recovered", "loop", l.Name)
+	}
+}
+
+func (s *Supervisor) setState(l *loop, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l.state, l.since = state, time.Now()
+	if err != nil {
+		l.err = err.Error()
+	}
+}
+
+func (s *Supervisor) stateOf(l *loop) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return l.state
+}
+
+// Status returns the health of every loop in the order they were added
+func (s *Supervisor) Status() []LoopStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]LoopStatus, 0, len(s.loops))
+	for _, l := range s.loops {
+		status = append(status, LoopStatus{
+			Name:        l.Name,
+			State:       l.state,
+			Restartable: l.Restart,
+			Restarts:    l.restarts,
+			Since:       l.since,
+			LastBeat:    time.Unix(0, l.lastBeat.Load()),
+			Error:       l.err,
+		})
+	}
+	return status
+}
+
+// Ready reports whether no loop failed or stalled. A restartable loop
+// waiting out its backoff does not make the daemon unready.
+func (s *Supervisor) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.loops {
+		if l.state == StateFailed || l.state == StateStalled {
+			return false
+		}
+	}
+	return true
+}
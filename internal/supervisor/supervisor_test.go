@@ -0,0 +1,222 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSupervisor returns a supervisor with timing short enough for tests
+func newTestSupervisor() *Supervisor {
+	s := New()
+	s.minBackoff = 10 * time.Millisecond
+	s.maxBackoff = 40 * time.Millisecond
+	s.checkInterval = 5 * time.Millisecond
+	s.stopTimeout = time.Second
+	return s
+}
+
+// start runs s until the test ends
+func start(t *testing.T, s *Supervisor) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// statusOf returns the status of the loop named name
+func statusOf(t *testing.T, s *Supervisor, name string) LoopStatus {
+	t.Helper()
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	t.Fatalf("no loop named %s", name)
+	return LoopStatus{}
+}
+
+// eventually fails the test unless cond holds within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// beat beats every millisecond until ctx is canceled
+func beat(ctx context.Context) error {
+	for {
+		Beat(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// tickingLoop beats until ctx is canceled, counting its runs, and kills the
+// first run with kill after a few milliseconds
+func tickingLoop(runs *atomic.Int64, kill func() error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if runs.Add(1) == 1 {
+			killCtx, cancel := context.WithTimeout(ctx, 3*time.Millisecond)
+			defer cancel()
+			_ = beat(killCtx)
+			return kill()
+		}
+		return beat(ctx)
+	}
+}
+
+func TestSupervisor_RestartsExitedLoop(t *testing.T) {
+	for name, kill := range map[string]func() error{
+		"error":    func() error { return errors.New("channel closed") },
+		"returned": func() error { return nil },
+		"panic":    func() error { panic("edge case") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestSupervisor()
+			var runs atomic.Int64
+			s.Add(Loop{Name: "loop", Run: tickingLoop(&runs, kill), Restart: true, Stall: time.Second})
+			start(t, s)
+
+			eventually(t, "restart", func() bool { return runs.Load() == 2 })
+			eventually(t, "running again", func() bool { return statusOf(t, s, "loop").State == StateRunning })
+			st := statusOf(t, s, "loop")
+			if st.Restarts != 1 || st.Error == "" {
+				t.Errorf("status = %+v, want one restart with its error", st)
+			}
+			if !s.Ready() {
+				t.Error("supervisor not ready after the loop was restarted")
+			}
+		})
+	}
+}
+
+func TestSupervisor_BacksOffBetweenRestarts(t *testing.T) {
+	s := newTestSupervisor()
+	var (
+		runs  atomic.Int64
+		times = make(chan time.Time, 8)
+	)
+	s.Add(Loop{Name: "loop", Restart: true, Run: func(context.Context) error {
+		runs.Add(1)
+		times <- time.Now()
+		return errors.New("crashing")
+	}})
+	start(t, s)
+
+	eventually(t, "four runs", func() bool { return runs.Load() >= 4 })
+	var prev time.Time
+	for i, want := range []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond} {
+		at := <-times
+		if i > 0 && at.Sub(prev) < want {
+			t.Errorf("run %d started %v after the previous one, want at least %v", i+1, at.Sub(prev), want)
+		}
+		prev = at
+	}
+}
+
+func TestSupervisor_UnsafeLoopFailsReadiness(t *testing.T) {
+	s := newTestSupervisor()
+	var runs atomic.Int64
+	s.Add(Loop{Name: "watcher", Run: func(context.Context) error {
+		runs.Add(1)
+		return errors.New("event source closed")
+	}})
+	start(t, s)
+
+	eventually(t, "failure", func() bool { return statusOf(t, s, "watcher").State == StateFailed })
+	if s.Ready() {
+		t.Error("supervisor ready although an unsafe loop exited")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("unsafe loop ran %d times, want 1", n)
+	}
+	if st := statusOf(t, s, "watcher"); st.Restartable || st.Error != "event source closed" {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestSupervisor_RestartsStalledLoop(t *testing.T) {
+	s := newTestSupervisor()
+	var runs atomic.Int64
+	s.Add(Loop{Name: "processor", Restart: true, Stall: 20 * time.Millisecond, Run: func(ctx context.Context) error {
+		if runs.Add(1) == 1 {
+			// Stops beating but still honors cancellation
+			<-ctx.Done()
+			return nil
+		}
+		return beat(ctx)
+	}})
+	start(t, s)
+
+	eventually(t, "restart after the stall", func() bool { return runs.Load() == 2 })
+	eventually(t, "running again", func() bool { return statusOf(t, s, "processor").State == StateRunning })
+	if st := statusOf(t, s, "processor"); st.Restarts != 1 || st.Error != "restarted after stalling" {
+		t.Errorf("status = %+v, want one restart after stalling", st)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !s.Ready() || runs.Load() != 2 {
+		t.Errorf("ready = %v, runs = %d, want the beating restart to stay up", s.Ready(), runs.Load())
+	}
+}
+
+func TestSupervisor_StalledUnsafeLoopRecovers(t *testing.T) {
+	s := newTestSupervisor()
+	resume := make(chan struct{})
+	s.Add(Loop{Name: "watcher", Stall: 20 * time.Millisecond, Run: func(ctx context.Context) error {
+		<-resume
+		return beat(ctx)
+	}})
+	start(t, s)
+
+	eventually(t, "stall", func() bool { return statusOf(t, s, "watcher").State == StateStalled })
+	if s.Ready() {
+		t.Error("supervisor ready although a loop stalled")
+	}
+	close(resume)
+	eventually(t, "recovery", func() bool { return statusOf(t, s, "watcher").State == StateRunning })
+	if !s.Ready() {
+		t.Error("supervisor not ready after the loop beat again")
+	}
+}
+
+func TestSupervisor_StopsLoopsOnShutdown(t *testing.T) {
+	s := newTestSupervisor()
+	s.Add(Loop{Name: "loop", Restart: true, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if st := statusOf(t, s, "loop"); st.State != StateStopped || st.Restarts != 0 {
+		t.Errorf("status = %+v, want stopped without restarts", st)
+	}
+}
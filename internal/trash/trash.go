@@ -0,0 +1,279 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+)
+
+// Dir returns the trash directory for an input root
+func Dir(inputRoot string) string {
+	return filepath.Join(inputRoot, config.TrashDirName)
+}
+
+// Move moves an ingested source into the trash under inputRoot, preserving
+// its path relative to the root. The trashed file's mtime is set to now so
+// the sweeper measures the grace period from the time of ingestion. An
+// existing entry with the same name is kept by suffixing the new one.
+func Move(inputRoot, src string) (string, error) {
+	rel, err := filepath.Rel(inputRoot, src)
+	if err != nil {
+		return "", fmt.Errorf("relative path for %s: %w", src, err)
+	}
+
+	dst := filepath.Join(Dir(inputRoot), rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", fmt.Errorf("create trash directory: %w", err)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		dst = dst + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+
+	// The trash lives under the input root, so this is always a rename
+	if err := os.Rename(src, dst); err != nil {
+		return "", fmt.Errorf("move %s to trash: %w", src, err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(dst, now, now); err != nil {
+		return dst, fmt.Errorf("stamp trash entry %s: %w", dst, err)
+	}
+	return dst, nil
+}
+
+// Stats summarizes one sweep and the space the trash still holds
+type Stats struct {
+	Deleted        int
+	DeletedBytes   int64
+	Remaining      int
+	RemainingBytes int64
+	// ReceiptsDeleted counts ingestion receipts past their retention
+	ReceiptsDeleted int
+}
+
+// Sweeper permanently deletes trash entries older than the grace period and,
+// when enabled, ingestion receipts older than their retention
+type Sweeper struct {
+	root   string
+	dir    string
+	grace  time.Duration
+	dryRun bool
+
+	receiptRetention time.Duration
+}
+
+// NewSweeper creates a sweeper for the trash under inputRoot. In dry-run mode
+// expired entries are only logged. A zero grace leaves the trash alone.
+func NewSweeper(inputRoot string, grace time.Duration, dryRun bool) *Sweeper {
+	return &Sweeper{root: inputRoot, dir: Dir(inputRoot), grace: grace, dryRun: dryRun}
+}
+
+// SetReceiptRetention makes Sweep also delete receipts under the input root
+// older than retention. Zero disables receipt cleanup.
+func (s *Sweeper) SetReceiptRetention(retention time.Duration) {
+	s.receiptRetention = retention
+}
+
+// Run sweeps every interval until ctx is canceled
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			supervisor.Beat(ctx)
+			stats, err := s.Sweep(time.Now())
+			if err != nil {
+				slog.Error("failed to sweep trash", "dir", s.dir, "error", err)
+				continue
+			}
+			slog.Info("trash swept",
+				"dir", s.dir,
+				"deleted", stats.Deleted,
+				"deleted_bytes", stats.DeletedBytes,
+				"remaining", stats.Remaining,
+				"remaining_bytes", stats.RemainingBytes,
+				"receipts_deleted", stats.ReceiptsDeleted,
+				"dry_run", s.dryRun,
+			)
+		}
+	}
+}
+
+// Operation and item actions of the sweeper as a maintenance.Operation
+const (
+	OperationSweep      = "sweep-trash"
+	ActionDeleteEntry   = "delete_trash_entry"
+	ActionDeleteReceipt = "delete_receipt"
+)
+
+// Sweep deletes trash files whose mtime is older than now minus the grace
+// period, removing directories left empty. Symlinks and other special files
+// are never followed or removed. Expired receipts are deleted afterwards.
+func (s *Sweeper) Sweep(now time.Time) (Stats, error) {
+	items, stats, err := s.plan(now)
+	if err != nil {
+		return stats, err
+	}
+	for _, item := range items {
+		if s.dryRun {
+			if item.Action == ActionDeleteReceipt {
+				slog.Info("dry run: would delete receipt", "path", item.Path, "written_at", item.ModTime)
+			} else {
+				slog.Info("dry run: would delete trash entry", "path", item.Path, "size", item.Size, "trashed_at", item.ModTime)
+			}
+		} else if err := s.Apply(item); err != nil {
+			return stats, err
+		}
+		if item.Action == ActionDeleteReceipt {
+			stats.ReceiptsDeleted++
+			continue
+		}
+		stats.Deleted++
+		stats.DeletedBytes += item.Size
+	}
+	return stats, nil
+}
+
+// Name returns OperationSweep
+func (s *Sweeper) Name() string { return OperationSweep }
+
+// Plan lists the trash entries and receipts a Sweep at now deletes
+func (s *Sweeper) Plan(now time.Time) ([]maintenance.Item, error) {
+	items, _, err := s.plan(now)
+	return items, err
+}
+
+// Apply deletes the trash entry or receipt of item, then the directories
+// of the trash it leaves empty. Files outside the trash, or receipts in it,
+// are refused. A file already gone is not an error.
+func (s *Sweeper) Apply(item maintenance.Item) error {
+	path := filepath.Clean(item.Path)
+	switch item.Action {
+	case ActionDeleteEntry:
+		if path == s.dir || !config.Contains(s.dir, path) {
+			return fmt.Errorf("%s is not in the trash %s", path, s.dir)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete trash entry %s: %w", path, err)
+		}
+		slog.Debug("deleted trash entry", "path", path, "size", item.Size)
+		// Deepest first, stopping at the first directory not empty
+		for dir := filepath.Dir(path); dir != s.dir && config.Contains(s.dir, dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	case ActionDeleteReceipt:
+		if !strings.HasSuffix(path, config.ReceiptSuffix) || !config.Contains(s.root, path) || config.Contains(s.dir, path) {
+			return fmt.Errorf("%s is not a receipt under %s", path, s.root)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete receipt %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", item.Action)
+	}
+	return nil
+}
+
+// plan lists the expired trash entries, then the expired receipts, and
+// counts the entries remaining in the trash
+func (s *Sweeper) plan(now time.Time) ([]maintenance.Item, Stats, error) {
+	var items []maintenance.Item
+	var stats Stats
+	if s.grace > 0 {
+		if err := s.planTrash(now, &items, &stats); err != nil {
+			return nil, stats, err
+		}
+	}
+	if s.receiptRetention > 0 {
+		if err := s.planReceipts(now, &items); err != nil {
+			return nil, stats, err
+		}
+	}
+	return items, stats, nil
+}
+
+func (s *Sweeper) planTrash(now time.Time, items *[]maintenance.Item, stats *Stats) error {
+	cutoff := now.Add(-s.grace)
+
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == s.dir {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+		if info.ModTime().After(cutoff) {
+			stats.Remaining++
+			stats.RemainingBytes += info.Size()
+			return nil
+		}
+		*items = append(*items, maintenance.Item{Path: path, Size: info.Size(), ModTime: info.ModTime(), Action: ActionDeleteEntry})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sweep %s: %w", s.dir, err)
+	}
+	return nil
+}
+
+// planReceipts lists the receipts under the input root, outside the trash,
+// whose mtime is older than the receipt retention
+func (s *Sweeper) planReceipts(now time.Time, items *[]maintenance.Item) error {
+	cutoff := now.Add(-s.receiptRetention)
+
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == s.dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), config.ReceiptSuffix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		*items = append(*items, maintenance.Item{Path: path, Size: info.Size(), ModTime: info.ModTime(), Action: ActionDeleteReceipt})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sweep receipts under %s: %w", s.root, err)
+	}
+	return nil
+}
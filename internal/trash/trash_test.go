@@ -0,0 +1,232 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestMove_PreservesRelativePath(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "a", "b", "data.csv")
+	writeFile(t, src, "data")
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	dst, err := Move(root, src)
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	if want := filepath.Join(root, ".ingested-trash", "a", "b", "data.csv"); dst != want {
+		t.Errorf("Move() = %s, want %s", dst, want)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source still exists: %v", err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("trash entry missing: %v", err)
+	}
+	if time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("trash entry mtime = %v, want time of trashing", info.ModTime())
+	}
+}
+
+func TestMove_KeepsExistingEntry(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "data.csv")
+
+	writeFile(t, src, "first")
+	first, err := Move(root, src)
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	writeFile(t, src, "second")
+	second, err := Move(root, src)
+	if err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+
+	if first == second {
+		t.Fatalf("second Move() overwrote %s", first)
+	}
+	data, err := os.ReadFile(first)
+	if err != nil || string(data) != "first" {
+		t.Errorf("first entry = %q, %v; want %q", data, err, "first")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	root := t.TempDir()
+	dir := Dir(root)
+
+	expired := filepath.Join(dir, "old", "expired.csv")
+	fresh := filepath.Join(dir, "fresh.csv")
+	writeFile(t, expired, "12345")
+	writeFile(t, fresh, "123")
+
+	now := time.Now()
+	old := now.Add(-25 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	// A symlink pointing outside the trash must never be followed
+	outside := filepath.Join(root, "outside.csv")
+	writeFile(t, outside, "keep")
+	if err := os.Symlink(outside, filepath.Join(dir, "link.csv")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	// Dry run reports but deletes nothing
+	stats, err := NewSweeper(root, 24*time.Hour, true).Sweep(now)
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if stats.Deleted != 1 || stats.DeletedBytes != 5 {
+		t.Errorf("dry run stats = %+v, want 1 file / 5 bytes", stats)
+	}
+	if _, err := os.Stat(expired); err != nil {
+		t.Errorf("dry run deleted %s: %v", expired, err)
+	}
+
+	stats, err = NewSweeper(root, 24*time.Hour, false).Sweep(now)
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	want := Stats{Deleted: 1, DeletedBytes: 5, Remaining: 1, RemainingBytes: 3}
+	if stats != want {
+		t.Errorf("Sweep() = %+v, want %+v", stats, want)
+	}
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired entry still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(expired)); !os.IsNotExist(err) {
+		t.Errorf("empty trash directory not removed: %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("fresh entry removed: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("symlink target removed: %v", err)
+	}
+}
+
+func TestSweep_MissingTrash(t *testing.T) {
+	stats, err := NewSweeper(t.TempDir(), time.Hour, false).Sweep(time.Now())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if stats != (Stats{}) {
+		t.Errorf("Sweep() = %+v, want zero", stats)
+	}
+}
+
+func TestSweep_Receipts(t *testing.T) {
+	root := t.TempDir()
+
+	expired := filepath.Join(root, "vendor", "_receipts", "old.csv.receipt.json")
+	fresh := filepath.Join(root, "new.csv.receipt.json")
+	data := filepath.Join(root, "old.csv")
+	trashed := filepath.Join(Dir(root), "kept.csv.receipt.json")
+	for _, path := range []string{expired, fresh, data, trashed} {
+		writeFile(t, path, "{}")
+	}
+
+	now := time.Now()
+	old := now.Add(-8 * 24 * time.Hour)
+	for _, path := range []string{expired, data, trashed} {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+
+	// Without a grace period the trash itself is left alone
+	s := NewSweeper(root, 0, false)
+	s.SetReceiptRetention(7 * 24 * time.Hour)
+	stats, err := s.Sweep(now)
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if stats != (Stats{ReceiptsDeleted: 1}) {
+		t.Errorf("Sweep() = %+v, want 1 receipt deleted", stats)
+	}
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("expired receipt still exists: %v", err)
+	}
+	for _, path := range []string{fresh, data, trashed} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed: %v", path, err)
+		}
+	}
+}
+
+func TestApply_RefusesOutsideTrash(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "vendor", "data.csv")
+	writeFile(t, outside, "keep")
+
+	s := NewSweeper(root, time.Hour, false)
+	for _, item := range []maintenance.Item{
+		{Path: outside, Action: ActionDeleteEntry},
+		{Path: Dir(root), Action: ActionDeleteEntry},
+		{Path: outside, Action: ActionDeleteReceipt},
+		{Path: outside, Action: "shred"},
+	} {
+		if err := s.Apply(item); err == nil {
+			t.Errorf("Apply(%+v) succeeded, want error", item)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the trash removed: %v", err)
+	}
+}
+
+func TestSweeper_PlanMatchesSweep(t *testing.T) {
+	root := t.TempDir()
+	expired := filepath.Join(Dir(root), "vendor", "old.csv")
+	writeFile(t, expired, "old")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(Dir(root), "fresh.csv"), "fresh")
+
+	s := NewSweeper(root, time.Hour, false)
+	items, err := s.Plan(time.Now())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(items) != 1 || items[0].Path != expired || items[0].Action != ActionDeleteEntry || items[0].Size != 3 {
+		t.Fatalf("Plan() = %+v, want the expired entry", items)
+	}
+	if err := s.Apply(items[0]); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(expired)); !os.IsNotExist(err) {
+		t.Errorf("directory left empty not removed: %v", err)
+	}
+	if _, err := os.Stat(Dir(root)); err != nil {
+		t.Errorf("trash directory removed: %v", err)
+	}
+}
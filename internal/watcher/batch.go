@@ -0,0 +1,121 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Batch is a directory whose files are ingested as a unit once its marker
+// file appears
+type Batch struct {
+	// Dir is the batch directory
+	Dir string
+	// MarkerPath is the marker file that completed the batch
+	MarkerPath string
+	// MarkerAt is when the marker was seen
+	MarkerAt time.Time
+	// SeenAt is MarkerAt, or zero for batches found by Start
+	SeenAt time.Time
+}
+
+// batches holds back every file below a batch directory and records the
+// directories whose marker has arrived
+type batches struct {
+	root   string
+	dirs   *regexp.Regexp
+	marker string
+
+	mu    sync.Mutex
+	ready map[string]*Batch
+}
+
+// EnableBatches turns on directory batches. dirPattern is a glob matched
+// against directory paths relative to the watch path, e.g. "batch_*" or
+// "drops/**/batch_*"; marker is the name, or name glob, of the file whose
+// creation inside such a directory completes the batch, e.g. "_SUCCESS".
+// Files below a batch directory are never reported by GetFilesToProcess.
+func (w *Watcher) EnableBatches(dirPattern, marker string) error {
+	re, err := glob.Compile(dirPattern)
+	if err != nil {
+		return fmt.Errorf("compile batch directory pattern: %w", err)
+	}
+	if _, err := path.Match(marker, ""); err != nil {
+		return fmt.Errorf("invalid batch marker %q: %w", marker, err)
+	}
+	w.batches = &batches{root: w.watchPath, dirs: re, marker: marker, ready: make(map[string]*Batch)}
+	return nil
+}
+
+// dirOf returns the nearest batch directory containing p
+func (s *batches) dirOf(p string) (string, bool) {
+	rel, err := filepath.Rel(s.root, filepath.Dir(p))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	for {
+		if s.dirs.MatchString(rel) {
+			return filepath.Join(s.root, filepath.FromSlash(rel)), true
+		}
+		i := strings.LastIndex(rel, "/")
+		if i < 0 {
+			return "", false
+		}
+		rel = rel[:i]
+	}
+}
+
+// handle records a marker event and reports whether the event belonged to a
+// batch directory
+func (s *batches) handle(event fsnotify.Event) bool {
+	dir, ok := s.dirOf(event.Name)
+	if !ok {
+		return false
+	}
+
+	name := filepath.Base(event.Name)
+	if matched, _ := path.Match(s.marker, name); matched && filepath.Dir(event.Name) == dir && event.Has(fsnotify.Create) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, exists := s.ready[dir]; !exists {
+			now := time.Now()
+			s.ready[dir] = &Batch{Dir: dir, MarkerPath: event.Name, MarkerAt: now, SeenAt: now}
+		}
+	}
+	return true
+}
+
+// GetBatchesToProcess returns the batches whose marker has arrived
+func (w *Watcher) GetBatchesToProcess() []Batch {
+	if w.batches == nil {
+		return nil
+	}
+
+	w.batches.mu.Lock()
+	defer w.batches.mu.Unlock()
+
+	ready := make([]Batch, 0, len(w.batches.ready))
+	for _, b := range w.batches.ready {
+		ready = append(ready, *b)
+	}
+	return ready
+}
+
+// RemoveBatch stops tracking the batch in dir
+func (w *Watcher) RemoveBatch(dir string) {
+	if w.batches == nil {
+		return
+	}
+
+	w.batches.mu.Lock()
+	defer w.batches.mu.Unlock()
+	delete(w.batches.ready, w.canonical(dir))
+}
@@ -0,0 +1,68 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestEnableBatches_Invalid(t *testing.T) {
+	w, err := New(config.MethodRename, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	if err := w.EnableBatches("batch_*", "["); err == nil {
+		t.Error("EnableBatches with malformed marker succeeded, want error")
+	}
+}
+
+func TestBatches_HoldFilesUntilMarker(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodRename, dir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.EnableBatches("batch_*", config.DefaultBatchMarker); err != nil {
+		t.Fatalf("EnableBatches failed: %v", err)
+	}
+
+	batchDir := filepath.Join(dir, "batch_42")
+	loose := filepath.Join(dir, "loose.csv")
+	for _, path := range []string{
+		filepath.Join(batchDir, "a.csv"),
+		filepath.Join(batchDir, "nested", "b.csv"),
+		// A marker below the batch directory does not complete it
+		filepath.Join(batchDir, "nested", config.DefaultBatchMarker),
+		loose,
+	} {
+		w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+	}
+
+	if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != loose {
+		t.Errorf("GetFilesToProcess = %v, want only %s", files, loose)
+	}
+	if batches := w.GetBatchesToProcess(); len(batches) != 0 {
+		t.Fatalf("got %d batches before marker, want 0", len(batches))
+	}
+
+	marker := filepath.Join(batchDir, config.DefaultBatchMarker)
+	w.handleEvent(fsnotify.Event{Name: marker, Op: fsnotify.Create})
+
+	batches := w.GetBatchesToProcess()
+	if len(batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(batches))
+	}
+	if batches[0].Dir != batchDir || batches[0].MarkerPath != marker {
+		t.Errorf("batch = %+v, want dir %s marker %s", batches[0], batchDir, marker)
+	}
+
+	w.RemoveBatch(batchDir)
+	if batches := w.GetBatchesToProcess(); len(batches) != 0 {
+		t.Errorf("got %d batches after RemoveBatch, want 0", len(batches))
+	}
+}
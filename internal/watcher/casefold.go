@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+)
+
+// caseProbePattern names the file probing whether the input filesystem
+// compares names case-insensitively. It is hidden and temporary, so it is
+// never tracked.
+const caseProbePattern = ".case-probe-*" + config.TempSuffix
+
+// probeCaseInsensitive reports whether names in dir that differ only by
+// case refer to the same file, as on the default filesystems of macOS and
+// Windows, by creating a lowercase file and looking it up in uppercase
+func probeCaseInsensitive(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, caseProbePattern)
+	if err != nil {
+		return false, fmt.Errorf("create case probe in %s: %w", dir, err)
+	}
+	name := f.Name()
+	_ = f.Close()
+	defer func() { _ = os.Remove(name) }()
+
+	_, err = os.Lstat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, fmt.Errorf("look up case probe: %w", err)
+	}
+}
+
+// detectCase folds the case of tracking lookups when the input filesystem
+// is case-insensitive. A failed probe keeps lookups case-sensitive.
+func (w *Watcher) detectCase() {
+	insensitive, err := w.caseProbe(w.watchPath)
+	if err != nil {
+		slog.Warn("failed to probe input filesystem case sensitivity, comparing names by case", "path", w.watchPath, "error", err)
+		return
+	}
+	w.foldCase = insensitive
+	if insensitive {
+		slog.Info("input filesystem is case-insensitive, tracking names regardless of case", "path", w.watchPath)
+	}
+}
+
+// foldKey returns the form two spellings of one tracked file share: path
+// normalized when names are and lowercased when the input filesystem is
+// case-insensitive
+func (w *Watcher) foldKey(path string) string {
+	if w.normalize != "" {
+		path = fileops.NormalizeName(w.normalize, path)
+	}
+	if w.foldCase {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// folds reports whether spellings of a file other than its own can find it
+func (w *Watcher) folds() bool {
+	return w.foldCase || (w.normalize != "" && w.normalize != fileops.NormalizeNone)
+}
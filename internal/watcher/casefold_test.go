@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// newCaseWatcher starts a watcher whose case probe reports insensitive
+func newCaseWatcher(t *testing.T, method string, insensitive bool) (*Watcher, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	w, err := New(method, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	w.caseProbe = func(string) (bool, error) { return insensitive, nil }
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return w, w.Root()
+}
+
+func TestCaseInsensitive_OneEntryPerFile(t *testing.T) {
+	w, root := newCaseWatcher(t, config.MethodStabilityWindow, true)
+
+	first := filepath.Join(root, "data.csv")
+	reupload := filepath.Join(root, "Data.CSV")
+	w.trackModification(first, time.Now().Add(-time.Hour))
+	w.trackModification(reupload, time.Now().Add(-time.Hour))
+
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != first {
+		t.Fatalf("files to process = %v, want one entry spelled as first tracked", files)
+	}
+
+	if !w.Claim(reupload) {
+		t.Fatal("Claim failed")
+	}
+	if w.Claim(first) {
+		t.Error("Claim of another spelling of a claimed file succeeded")
+	}
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process while claimed = %v, want none", files)
+	}
+	w.Release(first)
+
+	w.RemoveFromTracking(reupload)
+	if w.IsTracked(first) {
+		t.Error("file still tracked after removal under another spelling")
+	}
+}
+
+func TestCaseInsensitive_SidecarCompletion(t *testing.T) {
+	w, root := newCaseWatcher(t, config.MethodSidecar, true)
+
+	first := filepath.Join(root, "report.csv")
+	w.markCompleted(first)
+	w.handleEvent(fsnotify.Event{Name: filepath.Join(root, "REPORT.csv") + config.SidecarSuffix, Op: fsnotify.Create})
+
+	if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != first {
+		t.Errorf("files to process = %v, want one entry spelled as first tracked", files)
+	}
+}
+
+func TestCaseSensitive_SpellingsAreDistinct(t *testing.T) {
+	w, root := newCaseWatcher(t, config.MethodStabilityWindow, false)
+
+	w.trackModification(filepath.Join(root, "data.csv"), time.Now().Add(-time.Hour))
+	w.trackModification(filepath.Join(root, "Data.CSV"), time.Now().Add(-time.Hour))
+
+	if files := w.GetFilesToProcess(); len(files) != 2 {
+		t.Errorf("files to process = %v, want both spellings", files)
+	}
+	if w.IsTracked(filepath.Join(root, "DATA.csv")) {
+		t.Error("a third spelling matched on a case-sensitive filesystem")
+	}
+}
+
+func TestDetectCase_ProbeFailureComparesByCase(t *testing.T) {
+	w, err := New(config.MethodStabilityWindow, t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	w.caseProbe = func(string) (bool, error) { return true, errors.New("read-only filesystem") }
+
+	w.detectCase()
+	if w.foldCase {
+		t.Error("a failed probe folded case")
+	}
+}
+
+func TestProbeCaseInsensitive(t *testing.T) {
+	dir := t.TempDir()
+
+	insensitive, err := probeCaseInsensitive(dir)
+	if err != nil {
+		t.Fatalf("probeCaseInsensitive failed: %v", err)
+	}
+	// Temporary directories on Linux are case-sensitive
+	if runtime.GOOS == "linux" && insensitive {
+		t.Error("probe reported a Linux temporary directory case-insensitive")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("probe left %v behind", entries)
+	}
+}
@@ -0,0 +1,99 @@
+package watcher
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// suspendFactor is how many process intervals may pass between two looks
+// for ready files before the gap is taken for a suspend or stall
+const suspendFactor = 30
+
+// fileState is the on-disk size and modification time of a file, sampled to
+// re-verify its stability after a clock jump
+type fileState struct {
+	size  int64
+	mtime time.Time
+}
+
+// instant returns the clock reading at which a file last modified at
+// modTime was modified. Times read from the clock carry Go's monotonic
+// reading and are kept, so stability is measured in elapsed time whatever
+// the wall clock does. A modification time read from disk only has a wall
+// reading; it is converted once, by its age on the wall clock now, into a
+// reading of the monotonic clock.
+func instant(modTime, now time.Time) time.Time {
+	if modTime != modTime.Round(0) {
+		return modTime
+	}
+	return now.Add(modTime.Sub(now.Round(0)))
+}
+
+// stable reports whether the stability window of a file last modified at
+// modTime has elapsed at now
+func stable(modTime time.Time, stabilitySeconds int, now time.Time) bool {
+	return now.Sub(modTime) > time.Duration(stabilitySeconds)*time.Second
+}
+
+// clockJumped reports whether the clock jumped since the previous look for
+// ready files at now: it went back, the wall clock moved unlike the
+// monotonic one, as an NTP step does, or far more time passed than the
+// process interval, as when a VM is suspended
+func (w *Watcher) clockJumped(now time.Time) bool {
+	w.checkMu.Lock()
+	last := w.lastCheck
+	w.lastCheck = now
+	w.checkMu.Unlock()
+	if last.IsZero() {
+		return false
+	}
+
+	elapsed := now.Sub(last)
+	wall := now.Round(0).Sub(last.Round(0))
+	switch {
+	case elapsed < 0 || (wall-elapsed).Abs() > maxClockSkew:
+		slog.Warn("clock jump detected, re-verifying files before declaring them stable",
+			"elapsed", elapsed,
+			"wall_elapsed", wall,
+		)
+		return true
+	case elapsed > suspendFactor*w.checkInterval:
+		slog.Info("long gap between checks, re-verifying files before declaring them stable",
+			"elapsed", elapsed,
+			"expected", w.checkInterval,
+		)
+		return true
+	}
+	return false
+}
+
+// settled reports whether the stability-window file tracked under key,
+// whose window has elapsed, may be declared ready. After a clock jump the
+// elapsed time is not trusted: the file's size and modification time are
+// sampled and it is ready only once a later look finds them unchanged. A
+// change restarts its window at now.
+func (w *Watcher) settled(key string, now time.Time, jumped bool) bool {
+	prev, sampled := w.verifying.Load(key)
+	if !jumped && !sampled {
+		return true
+	}
+	info, err := os.Lstat(key)
+	if err != nil {
+		// Processing reports the file gone
+		w.verifying.Delete(key)
+		return true
+	}
+	state := fileState{size: info.Size(), mtime: info.ModTime()}
+	if jumped {
+		w.verifying.Store(key, state)
+		return false
+	}
+	w.verifying.Delete(key)
+	if p := prev.(fileState); p.size != state.size || !p.mtime.Equal(state.mtime) {
+		slog.Info("file changed across a clock jump, restarting its stability window", "path", key)
+		w.modification.Store(key, now)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// jumpClock is a wall clock without monotonic readings that tests step and
+// jump at will
+type jumpClock struct{ now time.Time }
+
+func (c *jumpClock) Now() time.Time      { return c.now }
+func (c *jumpClock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// newClockWatcher returns a stability-window watcher of a 5 second window on
+// clock, tracking one file written now
+func newClockWatcher(t *testing.T, clock *jumpClock) (*Watcher, string) {
+	t.Helper()
+	return newWindowWatcher(t, clock, 5)
+}
+
+// newWindowWatcher is newClockWatcher with a window of stabilitySeconds
+func newWindowWatcher(t *testing.T, clock *jumpClock, stabilitySeconds int) (*Watcher, string) {
+	t.Helper()
+	dir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, dir, stabilitySeconds)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	w.now = clock.Now
+
+	path := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(path, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	w.trackModification(path, clock.Now())
+	return w, path
+}
+
+// tick advances clock by a process interval and returns the ready files
+func tick(w *Watcher, clock *jumpClock) []string {
+	clock.Add(config.ProcessInterval)
+	return w.GetFilesToProcess()
+}
+
+func TestStability_ConfiguredWindow(t *testing.T) {
+	const step = 250 * time.Millisecond
+	for _, seconds := range []int{1, 3} {
+		window := time.Duration(seconds) * time.Second
+		t.Run(window.String(), func(t *testing.T) {
+			clock := &jumpClock{now: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)}
+			w, path := newWindowWatcher(t, clock, seconds)
+			for elapsed := step; elapsed <= window; elapsed += step {
+				clock.Add(step)
+				if files := w.GetFilesToProcess(); len(files) != 0 {
+					t.Fatalf("file ready %s into a %s window: %v", elapsed, window, files)
+				}
+			}
+			clock.Add(step)
+			if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != path {
+				t.Errorf("file not ready once its %s window elapsed, got %v", window, files)
+			}
+		})
+	}
+}
+
+func TestStability_ForwardJumpMidWrite(t *testing.T) {
+	clock := &jumpClock{now: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)}
+	w, path := newClockWatcher(t, clock)
+	if files := tick(w, clock); len(files) != 0 {
+		t.Fatalf("file ready after 1s: %v", files)
+	}
+
+	// The VM resumes an hour later with the producer still writing
+	clock.Add(time.Hour)
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Fatalf("file declared ready by the clock jump: %v", files)
+	}
+	if err := os.WriteFile(path, []byte("a,b\n1,2\n"), 0o644); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	// The next look finds the change and restarts the window
+	for i := range 6 {
+		if files := tick(w, clock); len(files) != 0 {
+			t.Fatalf("file changed across the jump ready %ds after it: %v", i+1, files)
+		}
+	}
+	if files := tick(w, clock); len(files) != 1 {
+		t.Errorf("file not ready a full window after the jump, got %v", files)
+	}
+}
+
+func TestStability_ForwardJumpSettledFile(t *testing.T) {
+	clock := &jumpClock{now: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)}
+	w, path := newClockWatcher(t, clock)
+	tick(w, clock)
+
+	clock.Add(time.Hour)
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Fatalf("file declared ready by the clock jump without re-verifying: %v", files)
+	}
+	// Unchanged on disk at the next look, so ready
+	if files := tick(w, clock); len(files) != 1 || files[0] != path {
+		t.Errorf("unchanged file not ready after re-verifying, got %v", files)
+	}
+}
+
+func TestStability_BackwardJump(t *testing.T) {
+	clock := &jumpClock{now: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)}
+	w, _ := newClockWatcher(t, clock)
+	tick(w, clock)
+
+	// An NTP step back an hour neither readies the file nor stalls it
+	// until the clock catches up
+	clock.Add(-time.Hour)
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Fatalf("file ready after the clock went back: %v", files)
+	}
+	for i := range 5 {
+		if files := tick(w, clock); len(files) != 0 {
+			t.Fatalf("file ready %ds after the clock went back: %v", i+1, files)
+		}
+	}
+	if files := tick(w, clock); len(files) != 1 {
+		t.Errorf("file still waiting a full window after the clock went back, got %v", files)
+	}
+}
+
+func TestInstant(t *testing.T) {
+	now := time.Now()
+	// A clock reading keeps its monotonic component
+	if got := instant(now.Add(-time.Second), now); got != now.Add(-time.Second) {
+		t.Errorf("instant() of a clock reading = %v, want it unchanged", got)
+	}
+	// An on-disk time becomes the clock reading of the same age
+	disk := now.Round(0).Add(-time.Minute)
+	got := instant(disk, now)
+	if got == got.Round(0) {
+		t.Error("instant() of a wall time has no monotonic reading")
+	}
+	if age := now.Sub(got); age != time.Minute || !got.Equal(disk) {
+		t.Errorf("instant() = %v with age %v, want %v a minute old", got, age, disk)
+	}
+}
@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// CompletionStore durably records the data files whose sidecar was seen
+type CompletionStore interface {
+	RecordCompletion(path string, at time.Time) error
+	DeleteCompletion(path string) error
+	CompletedPaths() ([]string, error)
+}
+
+// PersistCompletions records every sidecar completion in store when it is
+// seen, so a restart before the data file is processed does not lose it, and
+// marks the completions store already holds complete again. Completions of
+// data files that are gone or no longer completed by sidecar are forgotten.
+// Recorded completions are kept until Settle. Call before Start.
+func (w *Watcher) PersistCompletions(store CompletionStore) error {
+	paths, err := store.CompletedPaths()
+	if err != nil {
+		return fmt.Errorf("load completions: %w", err)
+	}
+
+	restored := 0
+	for _, path := range paths {
+		method, _ := w.methodFor(path)
+		if _, err := os.Lstat(path); err != nil || method != config.MethodSidecar {
+			if err := store.DeleteCompletion(path); err != nil {
+				return err
+			}
+			continue
+		}
+		w.see(path)
+		w.completed.Store(path, true)
+		w.persisted.Store(path, struct{}{})
+		restored++
+	}
+	if restored > 0 {
+		slog.Info("restored sidecar completions", "count", restored)
+	}
+
+	w.completions = store
+	return nil
+}
+
+// markCompleted tracks path as completed by its sidecar, recording the
+// completion first when completions are persisted
+func (w *Watcher) markCompleted(path string) {
+	path = w.trackingKey(w.completed, path)
+	if w.completions != nil {
+		if _, ok := w.persisted.LoadOrStore(path, struct{}{}); !ok {
+			if err := w.completions.RecordCompletion(path, time.Now()); err != nil {
+				slog.Warn("failed to persist sidecar completion", "path", path, "error", err)
+				w.persisted.Delete(path)
+			}
+		}
+	}
+	w.see(path)
+	w.completed.Store(path, true)
+}
+
+// Settle forgets the persisted completion of path once the file reached a
+// terminal state. Files without one are left alone.
+func (w *Watcher) Settle(path string) {
+	if w.completions == nil {
+		return
+	}
+	key, ok := w.trackedKey(&w.persisted, w.canonical(path))
+	if !ok {
+		return
+	}
+	if err := w.completions.DeleteCompletion(key); err != nil {
+		slog.Warn("failed to settle sidecar completion", "path", key, "error", err)
+		return
+	}
+	w.persisted.Delete(key)
+}
@@ -0,0 +1,133 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// memCompletions is an in-memory CompletionStore
+type memCompletions struct {
+	mu    sync.Mutex
+	paths map[string]time.Time
+}
+
+func newMemCompletions(paths ...string) *memCompletions {
+	m := &memCompletions{paths: make(map[string]time.Time)}
+	for _, path := range paths {
+		m.paths[path] = time.Now()
+	}
+	return m
+}
+
+func (m *memCompletions) RecordCompletion(path string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.paths[path]; !ok {
+		m.paths[path] = at
+	}
+	return nil
+}
+
+func (m *memCompletions) DeleteCompletion(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.paths, path)
+	return nil
+}
+
+func (m *memCompletions) CompletedPaths() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.paths))
+	for path := range m.paths {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func TestPersistCompletions_RecordsAndSettles(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodSidecar, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	store := newMemCompletions()
+	if err := w.PersistCompletions(store); err != nil {
+		t.Fatalf("PersistCompletions failed: %v", err)
+	}
+
+	data := filepath.Join(dir, "data.csv")
+	w.handleEvent(fsnotify.Event{Name: data + config.SidecarSuffix, Op: fsnotify.Create})
+	if paths, _ := store.CompletedPaths(); !slices.Equal(paths, []string{data}) {
+		t.Fatalf("persisted completions = %v, want %s", paths, data)
+	}
+
+	// Removing the file from tracking keeps its completion until it settles
+	w.RemoveFromTracking(data)
+	if paths, _ := store.CompletedPaths(); len(paths) != 1 {
+		t.Fatalf("persisted completions after RemoveFromTracking = %v, want it kept", paths)
+	}
+	w.Settle(data)
+	if paths, _ := store.CompletedPaths(); len(paths) != 0 {
+		t.Errorf("persisted completions after Settle = %v, want none", paths)
+	}
+}
+
+func TestPersistCompletions_Restores(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present.csv")
+	if err := os.WriteFile(present, []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	gone := filepath.Join(dir, "gone.csv")
+
+	w, err := New(config.MethodSidecar, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	store := newMemCompletions(present, gone)
+	if err := w.PersistCompletions(store); err != nil {
+		t.Fatalf("PersistCompletions failed: %v", err)
+	}
+
+	if files := w.GetFilesToProcess(); !slices.Equal(files, []string{present}) {
+		t.Errorf("GetFilesToProcess() = %v, want %s", files, present)
+	}
+	if paths, _ := store.CompletedPaths(); !slices.Equal(paths, []string{present}) {
+		t.Errorf("persisted completions = %v, want the missing file forgotten", paths)
+	}
+}
+
+func TestPersistCompletions_ForgetsOtherMethods(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(data, []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w, err := New(config.MethodStabilityWindow, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	store := newMemCompletions(data)
+	if err := w.PersistCompletions(store); err != nil {
+		t.Fatalf("PersistCompletions failed: %v", err)
+	}
+	if paths, _ := store.CompletedPaths(); len(paths) != 0 {
+		t.Errorf("persisted completions = %v, want none outside sidecar mode", paths)
+	}
+}
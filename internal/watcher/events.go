@@ -0,0 +1,211 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventBuffer is the capacity of the fsnotify events channel, which absorbs
+// bursts while the event loop handles a batch
+const eventBuffer = 4096
+
+// maxEventBatch bounds the events drained and handled at once
+const maxEventBatch = 1024
+
+// mergeableOps are the operations coalesced into one event per path. A
+// removal or rename ends the run, so the events around it stay apart.
+const mergeableOps = fsnotify.Create | fsnotify.Write | fsnotify.Chmod
+
+// EventStats reports the load of the event loop since Start
+type EventStats struct {
+	// Received counts the events fsnotify delivered
+	Received int64 `json:"received"`
+	// Handled counts the events left after coalescing
+	Handled int64 `json:"handled"`
+	// Batches counts the batches the events were drained in
+	Batches int64 `json:"batches"`
+	// QueueHighWater is the most events ever waiting in the channel
+	QueueHighWater int64 `json:"queue_high_water"`
+	// Restarts counts the times the event loop was restarted after a panic
+	Restarts int64 `json:"restarts"`
+	// Backend is where events come from, fsnotify or poll
+	Backend string `json:"backend"`
+	// Fallbacks counts the switches to polling after a failed probe
+	Fallbacks int64 `json:"fallbacks"`
+	// Suppressed counts the events ignored for files done within the
+	// tombstone window, such as a sidecar touched again
+	Suppressed int64 `json:"suppressed"`
+}
+
+// eventStats holds the counters behind EventStats
+type eventStats struct {
+	received   atomic.Int64
+	handled    atomic.Int64
+	batches    atomic.Int64
+	highWater  atomic.Int64
+	restarts   atomic.Int64
+	suppressed atomic.Int64
+}
+
+// observeDepth records depth events waiting in the channel
+func (s *eventStats) observeDepth(depth int64) {
+	for {
+		high := s.highWater.Load()
+		if depth <= high || s.highWater.CompareAndSwap(high, depth) {
+			return
+		}
+	}
+}
+
+// EventStats returns the event loop counters
+func (w *Watcher) EventStats() EventStats {
+	return EventStats{
+		Received:       w.events.received.Load(),
+		Handled:        w.events.handled.Load(),
+		Batches:        w.events.batches.Load(),
+		QueueHighWater: w.events.highWater.Load(),
+		Restarts:       w.events.restarts.Load(),
+		Backend:        w.Backend(),
+		Fallbacks:      w.fallbacks.Load(),
+		Suppressed:     w.events.suppressed.Load(),
+	}
+}
+
+// Backoff between restarts of a panicking event loop. It doubles with every
+// panic and starts over once the loop ran for maxLoopBackoff.
+const (
+	minLoopBackoff = 100 * time.Millisecond
+	maxLoopBackoff = 30 * time.Second
+)
+
+// runEventLoop runs the event loop of src until src is closed, restarting
+// it with backoff when it panics so the consumers of tracked files are not
+// left waiting forever. The events of the batch that panicked are lost.
+func (w *Watcher) runEventLoop(src eventSource) {
+	backoff := minLoopBackoff
+	for {
+		started := time.Now()
+		r, stack, panicked := w.recoverEventLoop(src)
+		if !panicked {
+			w.eventLoopReturned(src)
+			return
+		}
+		if time.Since(started) >= maxLoopBackoff {
+			backoff = minLoopBackoff
+		}
+		w.events.restarts.Add(1)
+		slog.Error("watcher event loop panicked, restarting",
+			"panic", fmt.Sprint(r),
+			"backoff", backoff,
+			"stack", string(stack),
+		)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxLoopBackoff)
+	}
+}
+
+// eventLoopReturned notes that the event loop of src returned. That is
+// expected on Close and when a fallback replaced src; otherwise src stopped
+// delivering events for good and Supervise reports it.
+func (w *Watcher) eventLoopReturned(src eventSource) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	if w.currentSource() != src {
+		return
+	}
+	w.lostOnce.Do(func() { close(w.lost) })
+}
+
+// Supervise blocks until ctx is canceled, or returns an error as soon as
+// the event loop stops for good while the watcher is open. The loop cannot
+// be started again on its source, so supervisors must not restart it.
+func (w *Watcher) Supervise(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-w.lost:
+		return fmt.Errorf("event source of %s closed, no file system events are received", w.watchPath)
+	}
+}
+
+// recoverEventLoop runs the event loop and returns the panic that ended
+// it, if any
+func (w *Watcher) recoverEventLoop(src eventSource) (r any, stack []byte, panicked bool) {
+	defer func() {
+		if r = recover(); r != nil {
+			stack, panicked = debug.Stack(), true
+		}
+	}()
+	w.eventLoop(src)
+	return nil, nil, false
+}
+
+// drain appends to batch the events already queued behind its first one,
+// up to maxEventBatch, without waiting. It reports false once the channel
+// is closed.
+func drain(batch []fsnotify.Event, events <-chan fsnotify.Event) ([]fsnotify.Event, bool) {
+	for len(batch) < maxEventBatch {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, event)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// coalesce folds the events of a path into the first of them, merging their
+// operations, as long as only creates, writes and chmods come in between.
+// Events keep the order of their first occurrence. events is reused for the
+// result and open, cleared first, maps paths to their pending event.
+func coalesce(events []fsnotify.Event, open map[string]int) []fsnotify.Event {
+	clear(open)
+	out := events[:0]
+	for _, event := range events {
+		mergeable := event.Op&^mergeableOps == 0
+		if i, ok := open[event.Name]; ok && mergeable {
+			out[i].Op |= event.Op
+			continue
+		}
+		if mergeable {
+			open[event.Name] = len(out)
+		} else {
+			delete(open, event.Name)
+		}
+		out = append(out, event)
+	}
+	return out
+}
+
+// handleEvents handles a batch of events received by now. An upload's
+// create and dozens of writes make one tracking update, and the batch one
+// debug line.
+func (w *Watcher) handleEvents(events []fsnotify.Event, open map[string]int, now time.Time) {
+	received := len(events)
+	events = coalesce(events, open)
+	w.events.received.Add(int64(received))
+	w.events.handled.Add(int64(len(events)))
+	w.events.batches.Add(1)
+	slog.Debug("file system events", "received", received, "handled", len(events))
+
+	for _, event := range events {
+		w.observeProbe(event.Name)
+		if w.failpoint != nil {
+			w.failpoint(event)
+		}
+		w.handleEventAt(event, now)
+	}
+}
@@ -0,0 +1,318 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestCoalesce(t *testing.T) {
+	create := func(name string) fsnotify.Event { return fsnotify.Event{Name: name, Op: fsnotify.Create} }
+	write := func(name string) fsnotify.Event { return fsnotify.Event{Name: name, Op: fsnotify.Write} }
+	remove := func(name string) fsnotify.Event { return fsnotify.Event{Name: name, Op: fsnotify.Remove} }
+
+	tests := []struct {
+		name   string
+		events []fsnotify.Event
+		want   []fsnotify.Event
+	}{
+		{
+			name:   "upload",
+			events: []fsnotify.Event{create("a"), write("a"), write("a"), write("a")},
+			want:   []fsnotify.Event{{Name: "a", Op: fsnotify.Create | fsnotify.Write}},
+		},
+		{
+			name:   "interleaved paths keep first occurrence order",
+			events: []fsnotify.Event{write("b"), create("a"), write("b"), write("a")},
+			want:   []fsnotify.Event{write("b"), {Name: "a", Op: fsnotify.Create | fsnotify.Write}},
+		},
+		{
+			name:   "removal ends the run",
+			events: []fsnotify.Event{create("a"), write("a"), remove("a"), create("a"), write("a")},
+			want: []fsnotify.Event{
+				{Name: "a", Op: fsnotify.Create | fsnotify.Write},
+				remove("a"),
+				{Name: "a", Op: fsnotify.Create | fsnotify.Write},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coalesce(tt.events, make(map[string]int))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coalesce() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// syntheticEvents returns n events over a few paths of every kind the
+// watcher tells apart, in a random but reproducible order
+func syntheticEvents(dir string, n int, seed uint64) []fsnotify.Event {
+	names := []string{"a.csv", "b.csv", "c.csv", "c.csv" + config.SidecarSuffix, "d.csv.001", "d.csv.002", "d.csv" + config.FileSetMarkerSuffix, ".hidden", "e.tmp"}
+	ops := []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Write, fsnotify.Write, fsnotify.Chmod, fsnotify.Remove, fsnotify.Rename}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	events := make([]fsnotify.Event, n)
+	for i := range events {
+		events[i] = fsnotify.Event{
+			Name: filepath.Join(dir, names[rng.IntN(len(names))]),
+			Op:   ops[rng.IntN(len(ops))],
+		}
+	}
+	return events
+}
+
+// trackingState is what a watcher tracks, times of file sets excluded since
+// they are taken from the clock
+func trackingState(w *Watcher) ([]TrackedFile, map[string]any) {
+	tracked := w.Tracked()
+	for i := range tracked {
+		if tracked[i].Method == MethodFileSet {
+			tracked[i].Since = time.Time{}
+		}
+	}
+	values := make(map[string]any)
+	for _, m := range []*sync.Map{w.modification, w.completed} {
+		if m == nil {
+			continue
+		}
+		m.Range(func(key, value any) bool {
+			values[key.(string)] = value
+			return true
+		})
+	}
+	return tracked, values
+}
+
+func TestHandleEvents_CoalescingMatchesPerEvent(t *testing.T) {
+	for _, method := range []string{config.MethodStabilityWindow, config.MethodSidecar} {
+		t.Run(method, func(t *testing.T) {
+			dir := t.TempDir()
+			newWatcher := func() *Watcher {
+				w, err := New(method, dir, 1)
+				if err != nil {
+					t.Fatalf("New failed: %v", err)
+				}
+				t.Cleanup(func() { _ = w.Close() })
+				if err := w.EnableFileSets(testPartPattern); err != nil {
+					t.Fatalf("EnableFileSets failed: %v", err)
+				}
+				return w
+			}
+			naive, batched := newWatcher(), newWatcher()
+
+			now := time.Now().Add(-time.Hour)
+			events := syntheticEvents(dir, 5000, 1)
+			for _, event := range events {
+				naive.handleEventAt(event, now)
+			}
+			// Batches of varying size, as the loop drains them
+			open := make(map[string]int)
+			rng := rand.New(rand.NewPCG(2, 2))
+			for rest := events; len(rest) > 0; {
+				n := min(1+rng.IntN(maxEventBatch), len(rest))
+				batched.handleEvents(append([]fsnotify.Event(nil), rest[:n]...), open, now)
+				rest = rest[n:]
+			}
+
+			wantTracked, wantValues := trackingState(naive)
+			gotTracked, gotValues := trackingState(batched)
+			if !reflect.DeepEqual(gotTracked, wantTracked) {
+				t.Errorf("tracked = %+v, want %+v", gotTracked, wantTracked)
+			}
+			if !reflect.DeepEqual(gotValues, wantValues) {
+				t.Errorf("tracking state = %v, want %v", gotValues, wantValues)
+			}
+
+			stats := batched.EventStats()
+			if stats.Received != int64(len(events)) || stats.Handled >= stats.Received || stats.Batches == 0 {
+				t.Errorf("EventStats() = %+v, want %d events received and fewer handled", stats, len(events))
+			}
+		})
+	}
+}
+
+func TestEventLoop_Coalesces(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	path := filepath.Join(dir, "upload.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	for range 200 {
+		if _, err := io.WriteString(f, "row\n"); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	_ = f.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !w.IsTracked(path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !w.IsTracked(path) {
+		t.Fatal("upload was never tracked")
+	}
+	stats := w.EventStats()
+	if stats.Received == 0 || stats.Handled > stats.Received || stats.QueueHighWater == 0 {
+		t.Errorf("EventStats() = %+v", stats)
+	}
+}
+
+func TestEventStats_HighWater(t *testing.T) {
+	var s eventStats
+	for _, depth := range []int64{3, 10, 7} {
+		s.observeDepth(depth)
+	}
+	if got := s.highWater.Load(); got != 10 {
+		t.Errorf("high water = %d, want 10", got)
+	}
+}
+
+// BenchmarkHandleEvents feeds 100k synthetic events through the handler the
+// way the event loop drains them
+func BenchmarkHandleEvents(b *testing.B) {
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	dir := b.TempDir()
+	events := syntheticEvents(dir, 100_000, 1)
+	for _, batch := range []int{1, maxEventBatch} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			w, err := New(config.MethodStabilityWindow, dir, 1)
+			if err != nil {
+				b.Fatalf("New failed: %v", err)
+			}
+			defer func() { _ = w.Close() }()
+
+			open := make(map[string]int)
+			buf := make([]fsnotify.Event, 0, batch)
+			now := time.Now()
+			b.ReportAllocs()
+			for b.Loop() {
+				for rest := events; len(rest) > 0; {
+					n := min(batch, len(rest))
+					w.handleEvents(append(buf[:0], rest[:n]...), open, now)
+					rest = rest[n:]
+				}
+			}
+		})
+	}
+}
+
+func TestEventLoop_RestartsAfterPanic(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodRename, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	bad := filepath.Join(dir, "bad.csv")
+	w.failpoint = func(event fsnotify.Event) {
+		if event.Name == bad {
+			panic("event handler bug")
+		}
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if err := os.WriteFile(bad, []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for w.EventStats().Restarts == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("event loop not restarted after the panic")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The restarted loop keeps tracking new files
+	good := filepath.Join(dir, "good.csv")
+	if err := os.WriteFile(good, []byte("y"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	for !slices.Contains(w.GetFilesToProcess(), good) {
+		if time.Now().After(deadline) {
+			t.Fatalf("files ready = %v, want %s", w.GetFilesToProcess(), good)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSupervise_ReportsLostEventSource(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodRename, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- w.Supervise(context.Background()) }()
+	select {
+	case err := <-errc:
+		t.Fatalf("Supervise returned %v while events flow", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The event channels close under the running watcher
+	if err := w.currentSource().Close(); err != nil {
+		t.Fatalf("failed to close event source: %v", err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("Supervise returned nil for a lost event source")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Supervise did not report the lost event source")
+	}
+}
+
+func TestSupervise_CloseIsNotLoss(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodRename, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := w.Supervise(ctx); err != nil {
+		t.Errorf("Supervise() = %v after Close, want nil", err)
+	}
+}
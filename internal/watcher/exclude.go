@@ -0,0 +1,214 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+)
+
+// excludedDir is a directory whose contents are never watched or tracked
+type excludedDir struct {
+	// path is the resolved directory and abs the absolute path as
+	// configured; event paths may use either spelling
+	path string
+	abs  string
+	// info identifies the directory when it exists, so the same directory
+	// mounted elsewhere inside the input tree is recognized too
+	info os.FileInfo
+}
+
+// ExcludePaths hard-excludes directories such as the warehouse, manifests,
+// quarantine and staging paths. Anything below them is ignored even when they
+// appear inside the input tree after startup, for example through a bind
+// mount. Call before Start.
+func (w *Watcher) ExcludePaths(paths ...string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		resolved, err := config.ResolvePath(path)
+		if err != nil {
+			slog.Warn("failed to resolve excluded path", "path", path, "error", err)
+			continue
+		}
+		abs, _ := filepath.Abs(path)
+		dir := excludedDir{path: resolved, abs: abs}
+		if info, err := os.Stat(resolved); err == nil && info.IsDir() {
+			dir.info = info
+		}
+		w.excluded = append(w.excluded, dir)
+	}
+}
+
+// isExcluded reports whether path lies in an excluded directory
+func (w *Watcher) isExcluded(path string) bool {
+	if w.excludedByPattern(path, false) {
+		return true
+	}
+	if len(w.excluded) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range w.excluded {
+		if config.Contains(dir.path, abs) || (dir.abs != "" && config.Contains(dir.abs, abs)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcludedDir reports whether the directory at path is, or is a mount of,
+// an excluded directory
+func (w *Watcher) isExcludedDir(path string, d fs.DirEntry) bool {
+	if w.isExcluded(path) {
+		return true
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	for _, dir := range w.excluded {
+		if dir.info != nil && os.SameFile(dir.info, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipExcludedDir logs and reports an excluded directory found in the input
+// tree
+func (w *Watcher) skipExcludedDir(path string, d fs.DirEntry) bool {
+	if w.excludedByPattern(path, true) {
+		slog.Debug("excluded directory, not watching", "path", path)
+		return true
+	}
+	if !w.isExcludedDir(path, d) {
+		return false
+	}
+	slog.Warn("excluded directory inside the input tree, not watching", "path", path)
+	return true
+}
+
+// dirPattern is a compiled directory exclusion
+type dirPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// SetExcludeDirs excludes the directories matching the patterns of spec,
+// separated by commas, from watching entirely: they are never watched,
+// scanned or polled, and nothing below them is tracked. Patterns are globs
+// matched against the directory relative to the input directory, so "work"
+// is the top-level work directory and "**/tmp" a tmp directory at any
+// depth. After Start the watches follow the new patterns: newly excluded
+// directories stop being watched and their files tracked, newly included
+// ones are watched and their files tracked as at Start.
+func (w *Watcher) SetExcludeDirs(spec string) error {
+	var patterns []dirPattern
+	for pattern := range strings.SplitSeq(spec, ",") {
+		pattern = strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(pattern)) {
+			return fmt.Errorf("excluded directory pattern %q is not relative to the input directory", pattern)
+		}
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("excluded directory pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, dirPattern{pattern: pattern, re: re})
+	}
+	w.excludeDirs.Store(&patterns)
+	if w.started.Load() {
+		w.rewatch()
+	}
+	return nil
+}
+
+// excludedByPattern reports whether the directory at path, or self aside,
+// one of its parents below the input directory matches an excluded
+// directory pattern
+func (w *Watcher) excludedByPattern(path string, self bool) bool {
+	patterns := w.excludeDirs.Load()
+	if patterns == nil || len(*patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(w.watchPath, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if !self {
+		parts = parts[:len(parts)-1]
+	}
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		for _, p := range *patterns {
+			if p.re.MatchString(dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rewatch brings the watches in line with the excluded directory patterns
+// after they changed
+func (w *Watcher) rewatch() {
+	watched := make(map[string]bool)
+	for _, dir := range w.currentSource().WatchList() {
+		if dir != w.watchPath && w.excludedByPattern(dir, true) {
+			if err := w.removeWatch(dir); err != nil {
+				slog.Warn("failed to stop watching excluded directory", "path", dir, "error", err)
+			}
+			continue
+		}
+		watched[dir] = true
+	}
+	for _, m := range []*sync.Map{w.completed, w.modification} {
+		if m == nil {
+			continue
+		}
+		m.Range(func(key, _ any) bool {
+			if w.excludedByPattern(key.(string), false) {
+				m.Delete(key)
+				w.verifying.Delete(key)
+				w.forget(key.(string))
+			}
+			return true
+		})
+	}
+
+	err := filepath.WalkDir(w.watchPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == w.watchPath {
+			return nil
+		}
+		if ShouldIgnoreFile(path) || w.skipExcludedDir(path, d) {
+			return fs.SkipDir
+		}
+		if !watched[path] {
+			slog.Info("directory no longer excluded, watching it", "path", path)
+			w.addTree(path)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to watch directories after the exclusions changed", "path", w.watchPath, "error", err)
+	}
+}
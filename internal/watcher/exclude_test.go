@@ -0,0 +1,252 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestExcludePaths_NotTracked(t *testing.T) {
+	tmpDir := t.TempDir()
+	warehouse := filepath.Join(tmpDir, "warehouse")
+
+	w, err := New(config.MethodRename, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	// The warehouse does not exist yet and is created inside the input later
+	w.ExcludePaths(warehouse)
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(warehouse, "sub"), 0o755); err != nil {
+		t.Fatalf("failed to create warehouse: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(warehouse, "sub", "ingested.csv"), []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	input := filepath.Join(tmpDir, "new.csv")
+	if err := os.WriteFile(input, []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != input {
+		t.Errorf("expected only %s to be tracked, got %v", input, files)
+	}
+}
+
+func TestExcludePaths_ExistingAtStart(t *testing.T) {
+	tmpDir := t.TempDir()
+	manifests := filepath.Join(tmpDir, "manifests")
+	if err := os.MkdirAll(manifests, 0o755); err != nil {
+		t.Fatalf("failed to create manifests: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(manifests, "manifest.jsonl"), []byte("{}"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w, err := New(config.MethodRename, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	w.ExcludePaths(manifests)
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files in an excluded directory should not be tracked, got %v", files)
+	}
+}
+
+func TestIsExcludedDir_SameDirectoryElsewhere(t *testing.T) {
+	tmpDir := t.TempDir()
+	warehouse := filepath.Join(tmpDir, "warehouse")
+	if err := os.MkdirAll(warehouse, 0o755); err != nil {
+		t.Fatalf("failed to create warehouse: %v", err)
+	}
+	other := filepath.Join(tmpDir, "other")
+	if err := os.MkdirAll(other, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	w, err := New(config.MethodRename, filepath.Join(tmpDir, "input"), 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	w.ExcludePaths(warehouse)
+
+	// A bind mount shows the warehouse directory under another path
+	info, err := os.Stat(warehouse)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !w.isExcludedDir(filepath.Join(tmpDir, "input", "mnt"), fs.FileInfoToDirEntry(info)) {
+		t.Error("the warehouse mounted inside the input should be excluded")
+	}
+
+	otherInfo, err := os.Stat(other)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if w.isExcludedDir(filepath.Join(tmpDir, "input", "other"), fs.FileInfoToDirEntry(otherInfo)) {
+		t.Error("an unrelated directory should not be excluded")
+	}
+}
+
+func TestSetExcludeDirs_Churn(t *testing.T) {
+	for _, backend := range []string{config.WatchBackendFsnotify, config.WatchBackendPoll} {
+		t.Run(backend, func(t *testing.T) {
+			tmpDir, err := config.ResolvePath(t.TempDir())
+			if err != nil {
+				t.Fatalf("failed to resolve temp dir: %v", err)
+			}
+			for _, dir := range []string{"work/scratch", "data/tmp", "data/keep"} {
+				if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0o755); err != nil {
+					t.Fatalf("failed to create %s: %v", dir, err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(tmpDir, "work", "old.csv"), []byte("data"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			w, err := New(config.MethodRename, tmpDir, 1)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer func() { _ = w.Close() }()
+			if err := w.SetBackend(backend, 20*time.Millisecond, 0); err != nil {
+				t.Fatalf("SetBackend failed: %v", err)
+			}
+			if err := w.SetExcludeDirs("work, **/tmp"); err != nil {
+				t.Fatalf("SetExcludeDirs failed: %v", err)
+			}
+			var excludedEvents atomic.Int64
+			w.failpoint = func(event fsnotify.Event) {
+				if w.excludedByPattern(event.Name, false) {
+					excludedEvents.Add(1)
+				}
+			}
+			if err := w.Start(); err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+
+			for _, dir := range w.currentSource().WatchList() {
+				if w.excludedByPattern(dir, true) {
+					t.Errorf("excluded directory %s is watched", dir)
+				}
+			}
+			// Heavy churn in the excluded subtrees
+			for i := range 200 {
+				for _, dir := range []string{"work", "work/scratch", "data/tmp"} {
+					path := filepath.Join(tmpDir, dir, fmt.Sprintf("f%d.csv", i%10))
+					if err := os.WriteFile(path, []byte(strconv.Itoa(i)), 0o644); err != nil {
+						t.Fatalf("failed to write file: %v", err)
+					}
+				}
+			}
+			if err := os.MkdirAll(filepath.Join(tmpDir, "work", "new"), 0o755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+			kept := filepath.Join(tmpDir, "data", "keep", "new.csv")
+			if err := os.WriteFile(kept, []byte("data"), 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != kept {
+				t.Errorf("tracked files = %v, want only %s", files, kept)
+			}
+			if n := excludedEvents.Load(); n != 0 {
+				t.Errorf("%d events handled for excluded directories, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSetExcludeDirs_AfterStart(t *testing.T) {
+	tmpDir, err := config.ResolvePath(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to resolve temp dir: %v", err)
+	}
+	work := filepath.Join(tmpDir, "work")
+	if err := os.MkdirAll(work, 0o755); err != nil {
+		t.Fatalf("failed to create work: %v", err)
+	}
+	old := filepath.Join(work, "old.csv")
+	if err := os.WriteFile(old, []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	w, err := New(config.MethodRename, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if files := w.GetFilesToProcess(); len(files) != 1 {
+		t.Fatalf("tracked files at start = %v, want %s", files, old)
+	}
+
+	// Excluding drops the watch and what is tracked below it
+	if err := w.SetExcludeDirs("work"); err != nil {
+		t.Fatalf("SetExcludeDirs failed: %v", err)
+	}
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("tracked files after excluding = %v, want none", files)
+	}
+	if slices.Contains(w.currentSource().WatchList(), work) {
+		t.Errorf("excluded directory %s still watched", work)
+	}
+
+	// Including it again watches it and tracks its files as at Start
+	if err := w.SetExcludeDirs(""); err != nil {
+		t.Fatalf("SetExcludeDirs failed: %v", err)
+	}
+	if !slices.Contains(w.currentSource().WatchList(), work) {
+		t.Errorf("included directory %s not watched", work)
+	}
+	added := filepath.Join(work, "added.csv")
+	if err := os.WriteFile(added, []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	files := w.GetFilesToProcess()
+	slices.Sort(files)
+	if want := []string{added, old}; !slices.Equal(files, want) {
+		t.Errorf("tracked files after including = %v, want %v", files, want)
+	}
+}
+
+func TestSetExcludeDirs_InvalidPattern(t *testing.T) {
+	w, err := New(config.MethodRename, t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	for _, spec := range []string{"work,../other", "/srv/input/work"} {
+		if err := w.SetExcludeDirs(spec); err == nil {
+			t.Errorf("SetExcludeDirs(%q) accepted a pattern outside the input directory", spec)
+		}
+	}
+}
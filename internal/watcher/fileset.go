@@ -0,0 +1,162 @@
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileSet is a multi-part file whose numbered parts are concatenated into
+// a single warehouse file once its completion marker arrives
+type FileSet struct {
+	// Path is the combined file path, e.g. /input/file.csv
+	Path string
+	// Parts maps each part index to its path
+	Parts map[int]string
+	// MarkerPath is Path with config.FileSetMarkerSuffix
+	MarkerPath string
+	// MarkerAt is when the marker was seen, zero before it arrives
+	MarkerAt time.Time
+	// LastPartAt is the time of the latest event on any part
+	LastPartAt time.Time
+	// SeenAt is when the first part or the marker was seen, zero for sets
+	// found by Start
+	SeenAt time.Time
+}
+
+// fileSets groups part files by stem
+type fileSets struct {
+	pattern *regexp.Regexp
+
+	mu   sync.Mutex
+	sets map[string]*FileSet
+}
+
+// EnableFileSets turns on multi-part grouping. pattern is matched against the
+// file name and must capture the stem and the numeric part index, in that
+// order, e.g. `^(.+)\.(\d+)$` for file.csv.001.
+func (w *Watcher) EnableFileSets(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("compile part pattern: %w", err)
+	}
+	if re.NumSubexp() != 2 {
+		return fmt.Errorf("part pattern %q must have 2 capture groups (stem, index), has %d", pattern, re.NumSubexp())
+	}
+	w.fileSets = &fileSets{pattern: re, sets: make(map[string]*FileSet)}
+	return nil
+}
+
+// handle records a part or marker event and reports whether the event
+// belonged to a file set
+func (s *fileSets) handle(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return s.isSetFile(event.Name)
+	}
+
+	if target, ok := strings.CutSuffix(event.Name, config.FileSetMarkerSuffix); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		set := s.get(target)
+		if set.MarkerAt.IsZero() {
+			set.MarkerAt = time.Now()
+		}
+		return true
+	}
+
+	stem, index, ok := s.parsePart(event.Name)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.get(stem)
+	set.Parts[index] = event.Name
+	set.LastPartAt = time.Now()
+	return true
+}
+
+func (s *fileSets) isSetFile(path string) bool {
+	if strings.HasSuffix(path, config.FileSetMarkerSuffix) {
+		return true
+	}
+	_, _, ok := s.parsePart(path)
+	return ok
+}
+
+// parsePart returns the combined file path and index of a part file
+func (s *fileSets) parsePart(path string) (string, int, bool) {
+	m := s.pattern.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return filepath.Join(filepath.Dir(path), m[1]), index, true
+}
+
+// get returns the set for stem, creating it. Callers hold mu.
+func (s *fileSets) get(stem string) *FileSet {
+	set, ok := s.sets[stem]
+	if !ok {
+		set = &FileSet{
+			Path:       stem,
+			Parts:      make(map[int]string),
+			MarkerPath: stem + config.FileSetMarkerSuffix,
+			SeenAt:     time.Now(),
+		}
+		s.sets[stem] = set
+	}
+	return set
+}
+
+// GetFileSetsToProcess returns a copy of every set whose marker has arrived.
+// In stability window mode the parts must also have been quiet for the
+// window. Whether all parts are present is left to the processor.
+func (w *Watcher) GetFileSetsToProcess() []FileSet {
+	if w.fileSets == nil {
+		return nil
+	}
+
+	w.fileSets.mu.Lock()
+	defer w.fileSets.mu.Unlock()
+
+	ready := make([]FileSet, 0)
+	window := time.Duration(w.stabilitySeconds) * time.Second
+	for _, set := range w.fileSets.sets {
+		if set.MarkerAt.IsZero() {
+			continue
+		}
+		if w.modification != nil && time.Since(set.LastPartAt) < window {
+			continue
+		}
+
+		cp := *set
+		cp.Parts = make(map[int]string, len(set.Parts))
+		for i, p := range set.Parts {
+			cp.Parts[i] = p
+		}
+		ready = append(ready, cp)
+	}
+	return ready
+}
+
+// RemoveFileSet stops tracking the set for the combined file path
+func (w *Watcher) RemoveFileSet(path string) {
+	if w.fileSets == nil {
+		return
+	}
+	w.fileSets.mu.Lock()
+	defer w.fileSets.mu.Unlock()
+	delete(w.fileSets.sets, w.canonical(path))
+}
@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+const testPartPattern = `^(.+)\.(\d+)$`
+
+func TestEnableFileSets_InvalidPattern(t *testing.T) {
+	w, err := New(config.MethodSidecar, t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	for _, pattern := range []string{`(`, `^(.+)$`, `^(.+)\.(\d+)(x)$`} {
+		if err := w.EnableFileSets(pattern); err == nil {
+			t.Errorf("EnableFileSets(%q) succeeded, want error", pattern)
+		}
+	}
+}
+
+func TestFileSets_GroupsOutOfOrderParts(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodSidecar, dir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.EnableFileSets(testPartPattern); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+
+	stem := filepath.Join(dir, "file.csv")
+	for _, name := range []string{"file.csv.003", "file.csv.001", "other.csv.001", "file.csv.002"} {
+		w.handleEvent(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+	}
+
+	if sets := w.GetFileSetsToProcess(); len(sets) != 0 {
+		t.Fatalf("got %d sets before marker, want 0", len(sets))
+	}
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("parts tracked as regular files: %v", files)
+	}
+
+	w.handleEvent(fsnotify.Event{Name: stem + config.FileSetMarkerSuffix, Op: fsnotify.Create})
+
+	sets := w.GetFileSetsToProcess()
+	if len(sets) != 1 {
+		t.Fatalf("got %d sets, want 1", len(sets))
+	}
+	set := sets[0]
+	if set.Path != stem {
+		t.Errorf("Path = %s, want %s", set.Path, stem)
+	}
+	if len(set.Parts) != 3 {
+		t.Errorf("got %d parts, want 3", len(set.Parts))
+	}
+	for i := 1; i <= 3; i++ {
+		if set.Parts[i] == "" {
+			t.Errorf("part %d missing", i)
+		}
+	}
+
+	w.RemoveFileSet(stem)
+	if sets := w.GetFileSetsToProcess(); len(sets) != 0 {
+		t.Errorf("got %d sets after RemoveFileSet, want 0", len(sets))
+	}
+}
+
+func TestFileSets_StabilityWindow(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, dir, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.EnableFileSets(testPartPattern); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+
+	stem := filepath.Join(dir, "file.csv")
+	w.handleEvent(fsnotify.Event{Name: stem + ".001", Op: fsnotify.Write})
+	w.handleEvent(fsnotify.Event{Name: stem + config.FileSetMarkerSuffix, Op: fsnotify.Create})
+
+	// A part written within the window keeps the set pending
+	if sets := w.GetFileSetsToProcess(); len(sets) != 0 {
+		t.Errorf("got %d sets inside stability window, want 0", len(sets))
+	}
+}
@@ -0,0 +1,76 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// NormalizeNames makes tracking lookups treat paths that differ only by
+// Unicode normalization as the same file, so a path spelled composed finds
+// the file tracked under its decomposed name and vice versa. Tracking keys
+// stay the on-disk spelling, which is what must be opened. Call before
+// Start.
+func (w *Watcher) NormalizeNames(form string) {
+	w.normalize = form
+}
+
+// trackedKey returns the key path is tracked under in m
+func (w *Watcher) trackedKey(m *sync.Map, path string) (string, bool) {
+	if _, ok := m.Load(path); ok {
+		return path, true
+	}
+	if !w.folds() {
+		return "", false
+	}
+
+	folded := w.foldKey(path)
+	var found string
+	m.Range(func(key, _ any) bool {
+		if w.foldKey(key.(string)) == folded {
+			found = key.(string)
+			return false
+		}
+		return true
+	})
+	return found, found != ""
+}
+
+// trackingKey returns the key to track path under in m: on a
+// case-insensitive filesystem the key of another spelling of the same file
+// already tracked, so the file keeps one entry with its first spelling, or
+// else path itself. Names differing by normalization are distinct files
+// where they can both be created, so only case is folded here.
+func (w *Watcher) trackingKey(m *sync.Map, path string) string {
+	if !w.foldCase {
+		return path
+	}
+	if key, ok := w.trackedKey(m, path); ok {
+		return key
+	}
+	return path
+}
+
+// onDiskName returns the spelling path has on disk. A sidecar written by a
+// different tool than its data file may spell the name in another
+// normalization form; the data file is found by comparing the names of its
+// siblings.
+func (w *Watcher) onDiskName(path string) string {
+	if !w.folds() {
+		return path
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return path
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return path
+	}
+	name := w.foldKey(filepath.Base(path))
+	for _, e := range entries {
+		if w.foldKey(e.Name()) == name {
+			return filepath.Join(filepath.Dir(path), e.Name())
+		}
+	}
+	return path
+}
@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Spellings of the same name: composed as U+00E9, decomposed as e plus U+0301
+const (
+	composedName   = "caf\u00e9.csv"
+	decomposedName = "cafe\u0301.csv"
+)
+
+func TestRemoveFromTracking_NormalizationForms(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	tracked := filepath.Join(tmpDir, decomposedName)
+	w.modification.Store(tracked, time.Now())
+
+	spelled := filepath.Join(tmpDir, composedName)
+	if w.IsTracked(spelled) {
+		t.Error("lookups should compare bytes when normalization is off")
+	}
+
+	w.NormalizeNames(fileops.NormalizeNFC)
+	if !w.IsTracked(spelled) {
+		t.Error("composed spelling should find the decomposed tracking key")
+	}
+	w.RemoveFromTracking(spelled)
+	if w.IsTracked(tracked) {
+		t.Error("file should no longer be tracked")
+	}
+}
+
+func TestSidecar_MatchesOtherNormalizationForm(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodSidecar, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	w.NormalizeNames(fileops.NormalizeNFC)
+
+	data := filepath.Join(tmpDir, decomposedName)
+	if err := os.WriteFile(data, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	w.handleEvent(fsnotify.Event{Name: filepath.Join(tmpDir, composedName) + config.SidecarSuffix, Op: fsnotify.Create})
+
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != data {
+		t.Errorf("files to process = %q, want the data file as spelled on disk", files)
+	}
+}
@@ -0,0 +1,187 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollEntry is what a scan remembers of a directory entry
+type pollEntry struct {
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// pollSource is the polling backend. It lists every watched directory each
+// interval and turns the differences from the previous listing into
+// fsnotify events: Create for new entries, Write for changed size or
+// modification time and Remove for vanished ones.
+type pollSource struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+
+	mu   sync.Mutex
+	dirs map[string]map[string]pollEntry
+
+	done      chan struct{}
+	closeOnce sync.Once
+	stopped   chan struct{}
+}
+
+func newPollSource(interval time.Duration) *pollSource {
+	s := &pollSource{
+		interval: interval,
+		events:   make(chan fsnotify.Event, eventBuffer),
+		errors:   make(chan error, 1),
+		dirs:     make(map[string]map[string]pollEntry),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Add watches the directory at path. Its entries at the time of the call
+// raise no events, as with fsnotify.
+func (s *pollSource) Add(path string) error {
+	listing, err := listDir(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dirs[path]; !ok {
+		s.dirs[path] = listing
+	}
+	return nil
+}
+
+// Remove stops watching the directory at path
+func (s *pollSource) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dirs, path)
+	return nil
+}
+
+func (s *pollSource) Events() <-chan fsnotify.Event { return s.events }
+func (s *pollSource) Errors() <-chan error          { return s.errors }
+
+// WatchList returns the watched directories
+func (s *pollSource) WatchList() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.dirs))
+}
+
+// Close stops scanning and closes the channels once the scan in flight is
+// over
+func (s *pollSource) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	<-s.stopped
+	return nil
+}
+
+func (s *pollSource) run() {
+	defer close(s.stopped)
+	defer close(s.errors)
+	defer close(s.events)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		events, errs := s.scan()
+		for _, err := range errs {
+			select {
+			case s.errors <- err:
+			case <-s.done:
+				return
+			}
+		}
+		for _, event := range events {
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// scan lists the watched directories and returns the events of what changed
+// since the previous scan, in name order. A directory that vanished is no
+// longer watched.
+func (s *pollSource) scan() ([]fsnotify.Event, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []fsnotify.Event
+	var errs []error
+	for _, dir := range slices.Sorted(maps.Keys(s.dirs)) {
+		prev := s.dirs[dir]
+		listing, err := listDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			delete(s.dirs, dir)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(listing)) {
+			entry, path := listing[name], filepath.Join(dir, name)
+			old, ok := prev[name]
+			switch {
+			case !ok:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			case old.dir != entry.dir:
+				events = append(events,
+					fsnotify.Event{Name: path, Op: fsnotify.Remove},
+					fsnotify.Event{Name: path, Op: fsnotify.Create})
+			case !entry.dir && (old.size != entry.size || !old.modTime.Equal(entry.modTime)):
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(prev)) {
+			if _, ok := listing[name]; !ok {
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+		}
+		s.dirs[dir] = listing
+	}
+	return events, errs
+}
+
+// listDir returns the entries of the directory at path by name
+func listDir(path string) (map[string]pollEntry, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", path, err)
+	}
+	listing := make(map[string]pollEntry, len(entries))
+	for _, d := range entries {
+		info, err := d.Info()
+		if err != nil {
+			// Removed since it was listed
+			continue
+		}
+		listing[d.Name()] = pollEntry{size: info.Size(), modTime: info.ModTime(), dir: d.IsDir()}
+	}
+	return listing, nil
+}
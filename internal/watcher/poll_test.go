@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPollSource_Scan(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.csv")
+	gone := filepath.Join(dir, "gone.csv")
+	for _, path := range []string{kept, gone} {
+		if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	// A long interval leaves scanning to the test
+	s := newPollSource(time.Hour)
+	defer func() { _ = s.Close() }()
+	if err := s.Add(dir); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if events, _ := s.scan(); len(events) != 0 {
+		t.Fatalf("scan() = %v, want no events for entries present at Add", events)
+	}
+
+	added := filepath.Join(dir, "added.csv")
+	if err := os.WriteFile(added, []byte("b"), 0o644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := os.WriteFile(kept, []byte("longer"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+
+	events, errs := s.scan()
+	if len(errs) != 0 {
+		t.Fatalf("scan() errors = %v", errs)
+	}
+	want := []fsnotify.Event{
+		{Name: added, Op: fsnotify.Create},
+		{Name: kept, Op: fsnotify.Write},
+		{Name: gone, Op: fsnotify.Remove},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("scan() = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %v, want %v", i, events[i], want[i])
+		}
+	}
+
+	if events, _ := s.scan(); len(events) != 0 {
+		t.Errorf("scan() = %v on an unchanged directory, want no events", events)
+	}
+}
+
+func TestPollSource_VanishedDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sub")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	s := newPollSource(time.Hour)
+	defer func() { _ = s.Close() }()
+	if err := s.Add(dir); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("failed to remove directory: %v", err)
+	}
+	if _, errs := s.scan(); len(errs) != 0 {
+		t.Fatalf("scan() errors = %v, want a vanished directory dropped quietly", errs)
+	}
+	if dirs := s.WatchList(); len(dirs) != 0 {
+		t.Errorf("WatchList() = %v, want the vanished directory dropped", dirs)
+	}
+}
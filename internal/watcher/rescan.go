@@ -0,0 +1,239 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ErrOutsideRoot is returned by Rescan for a path outside the input
+// directory
+var ErrOutsideRoot = errors.New("path outside the input directory")
+
+// ErrRescanRunning is returned by Rescan while another rescan is running
+var ErrRescanRunning = errors.New("a rescan is already running")
+
+// errClosed ends a rescan waiting for room once the watcher is closed
+var errClosed = errors.New("watcher closed")
+
+// RescanOptions configures a Rescan
+type RescanOptions struct {
+	// ForceReady makes the stability-window files of the directory ready
+	// at once instead of when their window elapses
+	ForceReady bool
+	// Ingested, when set, reports whether the file at path of size was
+	// ingested already; such files are left untracked
+	Ingested func(path string, size int64) bool
+}
+
+// RescanResult counts what a Rescan found
+type RescanResult struct {
+	// Path is the rescanned directory
+	Path string `json:"path"`
+	// Walked counts the files visited
+	Walked int `json:"walked"`
+	// Filtered counts the files the watcher does not track: hidden and
+	// temporary files, receipts, sidecars and excluded paths
+	Filtered int `json:"filtered"`
+	// Added counts the files the rescan tracked
+	Added          int `json:"added"`
+	AlreadyTracked int `json:"already_tracked"`
+	// AlreadyIngested counts the files Ingested reported, left untracked
+	AlreadyIngested int `json:"already_ingested"`
+	// Waiting counts the files left untracked until they complete, such as
+	// those waiting for their sidecar
+	Waiting int `json:"waiting"`
+	// ForcedReady counts the files ForceReady made ready
+	ForcedReady int `json:"forced_ready"`
+}
+
+// Rescan walks the directory at path, relative to the input directory or
+// absolute within it, and tracks the files the watcher missed there as if
+// each had just been created at its on-disk modification time, so a file
+// long stable on disk does not wait its window again. The filters of the
+// watch apply. It is safe to call while the watcher and processor run, one
+// rescan at a time; with EnableIncrementalScan it waits for room in
+// tracking like the scan does, until ctx is done. A rescan of the input
+// directory itself measures InputUsage.
+func (w *Watcher) Rescan(ctx context.Context, path string, opts RescanOptions) (RescanResult, error) {
+	var res RescanResult
+	if !w.rescanMu.TryLock() {
+		return res, ErrRescanRunning
+	}
+	defer w.rescanMu.Unlock()
+
+	dir, err := w.rescanDir(path)
+	if err != nil {
+		return res, err
+	}
+	res.Path = dir
+	// A rescan of the whole tree measures the input usage on the way
+	var tally *usageTally
+	if dir == w.watchPath {
+		tally = &usageTally{}
+	}
+
+	var tracked []string
+	waiting := make(map[string]bool)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != w.watchPath && (ShouldIgnoreFile(path) || w.skipExcludedDir(path, d)) {
+				return fs.SkipDir
+			}
+			// A directory the watch missed is as likely as a file
+			if err := w.addWatch(path); err != nil {
+				return fmt.Errorf("add watch path %s: %w", path, err)
+			}
+			return nil
+		}
+		res.Walked++
+		if !d.Type().IsRegular() {
+			res.Filtered++
+			return nil
+		}
+		if w.isExcluded(path) || ShouldIgnoreFile(path) {
+			res.Filtered++
+			tally.observe(w, path, d, nil)
+			return nil
+		}
+		if w.IsTracked(path) {
+			res.AlreadyTracked++
+			tracked = append(tracked, path)
+			tally.observe(w, path, d, nil)
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Processed and moved away since the directory was read
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if opts.Ingested != nil && opts.Ingested(path, info.Size()) {
+			res.AlreadyIngested++
+			tally.observe(w, path, d, info)
+			return nil
+		}
+		if err := w.waitForRescanRoom(ctx); err != nil {
+			return err
+		}
+
+		w.handleEventAt(fsnotify.Event{Name: path, Op: fsnotify.Create}, info.ModTime())
+		tally.observe(w, path, d, info)
+		switch {
+		case w.IsTracked(path):
+			res.Added++
+			tracked = append(tracked, path)
+		case strings.HasSuffix(path, config.SidecarSuffix):
+			res.Filtered++
+			// The file it completes, walked just before, is tracked now
+			if target := strings.TrimSuffix(path, config.SidecarSuffix); waiting[target] && w.IsTracked(target) {
+				delete(waiting, target)
+				res.Waiting--
+				res.Added++
+				tracked = append(tracked, target)
+			}
+		default:
+			waiting[path] = true
+			res.Waiting++
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("rescan %s: %w", dir, err)
+	}
+	w.recordUsage(tally)
+
+	if opts.ForceReady {
+		for _, path := range tracked {
+			if w.forceReady(path) {
+				res.ForcedReady++
+			}
+		}
+	}
+	return res, nil
+}
+
+// rescanDir returns the canonical directory a Rescan of path walks
+func (w *Watcher) rescanDir(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("empty rescan path")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(w.watchPath, path)
+	}
+	dir, err := config.ResolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	if !config.Contains(w.watchPath, dir) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, path)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+	return dir, nil
+}
+
+// waitForRescanRoom waits while tracking is at the bound of the incremental
+// scan, when one is set
+func (w *Watcher) waitForRescanRoom(ctx context.Context) error {
+	s := w.scan
+	if s == nil {
+		return nil
+	}
+	for w.trackedCount() >= s.maxTracked {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.done:
+			return errClosed
+		case <-s.room:
+		}
+	}
+	return nil
+}
+
+// forceReady makes the tracked stability-window file at path ready now
+// and reports whether it was not yet. A file that left tracking meanwhile
+// stays out.
+func (w *Watcher) forceReady(path string) bool {
+	if w.modification == nil {
+		return false
+	}
+	key, ok := w.trackedKey(w.modification, w.canonical(path))
+	if !ok {
+		return false
+	}
+	value, ok := w.modification.Load(key)
+	if !ok {
+		return false
+	}
+	_, stabilitySeconds := w.methodFor(key)
+	now := w.now()
+	if stable(value.(time.Time), stabilitySeconds, now) {
+		return false
+	}
+	window := time.Duration(stabilitySeconds) * time.Second
+	if !w.modification.CompareAndSwap(key, value, now.Add(-window-time.Second)) {
+		return false
+	}
+	w.verifying.Delete(key)
+	return true
+}
@@ -0,0 +1,122 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// ready returns the tracked files Tracked reports ready by path
+func ready(w *Watcher) map[string]bool {
+	out := make(map[string]bool)
+	for _, f := range w.Tracked() {
+		out[f.Path] = f.Ready
+	}
+	return out
+}
+
+func TestRescan_ForceReady(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, tmpDir, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	dir := filepath.Join(tmpDir, "vendorB", "2024-06-14")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("a,b\n"), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	fresh := write("fresh.csv")
+	settled := write("settled.csv")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(settled, old, old); err != nil {
+		t.Fatal(err)
+	}
+	tracked := write("tracked.csv")
+	w.trackModification(tracked, time.Now())
+	ingested := write("ingested.csv")
+	write(".hidden.csv")
+
+	opts := RescanOptions{Ingested: func(path string, _ int64) bool { return path == ingested }}
+	res, err := w.Rescan(context.Background(), "vendorB/2024-06-14", opts)
+	if err != nil {
+		t.Fatalf("Rescan() error = %v", err)
+	}
+	want := RescanResult{Path: dir, Walked: 5, Filtered: 1, Added: 2, AlreadyTracked: 1, AlreadyIngested: 1}
+	if res != want {
+		t.Errorf("Rescan() = %+v, want %+v", res, want)
+	}
+	got := ready(w)
+	if !got[settled] {
+		t.Error("file long stable on disk is not ready after the rescan")
+	}
+	if r, ok := got[fresh]; !ok || r {
+		t.Errorf("fresh file tracked = %v, ready = %v, want tracked within its window", ok, r)
+	}
+	if _, ok := got[ingested]; ok {
+		t.Error("ingested file is tracked")
+	}
+
+	opts.ForceReady = true
+	res, err = w.Rescan(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Rescan() error = %v", err)
+	}
+	if res.Added != 0 || res.AlreadyTracked != 3 || res.ForcedReady != 2 {
+		t.Errorf("Rescan(ForceReady) = %+v, want the fresh and tracked files forced ready", res)
+	}
+	got = ready(w)
+	for _, path := range []string{fresh, settled, tracked} {
+		if !got[path] {
+			t.Errorf("%s is not ready after a forced rescan", filepath.Base(path))
+		}
+	}
+}
+
+func TestRescan_OutsideRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "input")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "a.csv"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := New(config.MethodStabilityWindow, root, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	for _, path := range []string{"..", "../outside", outside, "link", "/"} {
+		if _, err := w.Rescan(context.Background(), path, RescanOptions{ForceReady: true}); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Rescan(%q) error = %v, want ErrOutsideRoot", path, err)
+		}
+	}
+	if n := w.trackedCount(); n != 0 {
+		t.Errorf("%d files tracked, want none from outside the input directory", n)
+	}
+	if _, err := w.Rescan(context.Background(), "", RescanOptions{}); err == nil {
+		t.Error("Rescan() of an empty path succeeded, want error")
+	}
+}
@@ -0,0 +1,159 @@
+package watcher
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/glob"
+)
+
+// route is a compiled completion rule
+type route struct {
+	pattern          string
+	re               *regexp.Regexp
+	method           string
+	stabilitySeconds int
+}
+
+// SetCompletionRules routes files matching each rule's pattern to its
+// method; unmatched files use the watcher's global method. Rules whose
+// patterns can match the same path but select a different method or window
+// are rejected as ambiguous. Call before Start.
+func (w *Watcher) SetCompletionRules(rules []config.CompletionRule) error {
+	routes := make([]route, 0, len(rules))
+	for i, r := range rules {
+		rt := route{pattern: r.Pattern, method: r.Method, stabilitySeconds: r.Params.StabilitySeconds}
+
+		switch r.Method {
+		case config.MethodStabilityWindow:
+			if rt.stabilitySeconds < 0 {
+				return fmt.Errorf("completion rule %d: negative stability_seconds", i+1)
+			}
+			if rt.stabilitySeconds == 0 {
+				rt.stabilitySeconds = w.stabilitySeconds
+			}
+		case config.MethodSidecar, config.MethodRename:
+			if rt.stabilitySeconds != 0 {
+				return fmt.Errorf("completion rule %d: stability_seconds only applies to %s", i+1, config.MethodStabilityWindow)
+			}
+		default:
+			return fmt.Errorf("completion rule %d: unknown method %q", i+1, r.Method)
+		}
+
+		if r.Pattern == "" {
+			return fmt.Errorf("completion rule %d: empty pattern", i+1)
+		}
+		re, err := glob.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("completion rule %d: pattern %q: %w", i+1, r.Pattern, err)
+		}
+		rt.re = re
+
+		for j, prev := range routes {
+			if prev.method == rt.method && prev.stabilitySeconds == rt.stabilitySeconds {
+				continue
+			}
+			if glob.Overlap(prev.pattern, rt.pattern) {
+				return fmt.Errorf("completion rules %d (%s) and %d (%s) overlap with different methods", j+1, prev.pattern, i+1, rt.pattern)
+			}
+		}
+		routes = append(routes, rt)
+
+		switch rt.method {
+		case config.MethodStabilityWindow:
+			if w.modification == nil {
+				w.modification = &sync.Map{}
+			}
+		default:
+			if w.completed == nil {
+				w.completed = &sync.Map{}
+			}
+		}
+	}
+
+	w.routes = routes
+	return nil
+}
+
+// methodFor returns the completion method and stability window for a path
+func (w *Watcher) methodFor(path string) (string, int) {
+	if len(w.routes) > 0 {
+		if rel, err := filepath.Rel(w.watchPath, path); err == nil {
+			rel = filepath.ToSlash(rel)
+			for _, rt := range w.routes {
+				if rt.re.MatchString(rel) {
+					return rt.method, rt.stabilitySeconds
+				}
+			}
+		}
+	}
+	return w.method, w.stabilitySeconds
+}
+
+// Route is how the watcher treats a file
+type Route struct {
+	// Method and StabilitySeconds are the completion rule of the file
+	Method           string
+	StabilitySeconds int
+	// Skip is why the file is not tracked on its own, empty when it is
+	Skip string
+}
+
+// Reasons a file is not tracked on its own, see Route.Skip
+const (
+	SkipExcluded = "excluded directory"
+	SkipSidecar  = "sidecar marker"
+	SkipIgnored  = "hidden or temp file"
+	SkipBatch    = "batch member"
+	SkipFileSet  = "multi-part file"
+)
+
+// Route returns how the file at path is routed when an event for it
+// arrives. It only reads the configuration, so it also answers for a
+// watcher never started.
+func (w *Watcher) Route(path string) Route {
+	method, stabilitySeconds := w.methodFor(path)
+	r := Route{Method: method, StabilitySeconds: stabilitySeconds}
+
+	switch {
+	case w.isExcluded(path):
+		r.Skip = SkipExcluded
+	case w.isSidecar(path):
+		r.Skip = SkipSidecar
+	case ShouldIgnoreFile(path):
+		r.Skip = SkipIgnored
+	case w.batches != nil && w.batchOf(path):
+		r.Skip = SkipBatch
+	case w.fileSets != nil && w.fileSets.isSetFile(path):
+		r.Skip = SkipFileSet
+	}
+	return r
+}
+
+// isSidecar reports whether path is the sidecar marker of a file completed
+// by one
+func (w *Watcher) isSidecar(path string) bool {
+	target, ok := strings.CutSuffix(path, config.SidecarSuffix)
+	if !ok {
+		return false
+	}
+	method, _ := w.methodFor(w.onDiskName(target))
+	return method == config.MethodSidecar
+}
+
+// batchOf reports whether path lies in a batch directory
+func (w *Watcher) batchOf(path string) bool {
+	_, ok := w.batches.dirOf(path)
+	return ok
+}
+
+// SkipsDir reports whether the directory at path is left unwatched along
+// with everything below it
+func (w *Watcher) SkipsDir(path string, d fs.DirEntry) bool {
+	return ShouldIgnoreFile(path) || w.excludedByPattern(path, true) || w.isExcludedDir(path, d)
+}
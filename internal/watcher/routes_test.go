@@ -0,0 +1,170 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+var mixedRules = []config.CompletionRule{
+	{Pattern: "vendorA/*", Method: config.MethodSidecar},
+	{Pattern: "vendorB/*.csv", Method: config.MethodRename},
+	{Pattern: "vendorC/**", Method: config.MethodStabilityWindow, Params: config.CompletionParams{StabilitySeconds: 5}},
+}
+
+func TestSetCompletionRules_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []config.CompletionRule
+		wantErr bool
+	}{
+		{"disjoint", mixedRules, false},
+		{"same method overlap", []config.CompletionRule{
+			{Pattern: "a/*", Method: config.MethodSidecar},
+			{Pattern: "a/*.csv", Method: config.MethodSidecar},
+		}, false},
+		{"ambiguous overlap", []config.CompletionRule{
+			{Pattern: "a/**", Method: config.MethodSidecar},
+			{Pattern: "**/*.csv", Method: config.MethodRename},
+		}, true},
+		{"different windows overlap", []config.CompletionRule{
+			{Pattern: "a/*", Method: config.MethodStabilityWindow, Params: config.CompletionParams{StabilitySeconds: 5}},
+			{Pattern: "a/*.csv", Method: config.MethodStabilityWindow, Params: config.CompletionParams{StabilitySeconds: 30}},
+		}, true},
+		{"unknown method", []config.CompletionRule{{Pattern: "a/*", Method: "magic"}}, true},
+		{"empty pattern", []config.CompletionRule{{Method: config.MethodSidecar}}, true},
+		{"window on sidecar", []config.CompletionRule{
+			{Pattern: "a/*", Method: config.MethodSidecar, Params: config.CompletionParams{StabilitySeconds: 5}},
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(config.MethodStabilityWindow, t.TempDir(), 1)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			defer func() { _ = w.Close() }()
+
+			err = w.SetCompletionRules(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SetCompletionRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompletionRules_MixedProducers(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.SetCompletionRules(mixedRules); err != nil {
+		t.Fatalf("SetCompletionRules failed: %v", err)
+	}
+
+	path := func(rel string) string { return filepath.Join(dir, filepath.FromSlash(rel)) }
+	create := func(rel string) {
+		w.handleEvent(fsnotify.Event{Name: path(rel), Op: fsnotify.Create})
+	}
+
+	// Team A: data file first, sidecar later
+	create("vendorA/a.csv")
+	// Team B: temp name (ignored) renamed into place
+	create("vendorB/b.csv.tmp")
+	create("vendorB/b.csv")
+	// Team C: stability window of 5s
+	create("vendorC/day1/c.csv")
+	// Unmatched: global stability window of 1s
+	create("other/d.csv")
+
+	got := w.GetFilesToProcess()
+	if len(got) != 1 || got[0] != path("vendorB/b.csv") {
+		t.Errorf("immediately ready = %v, want only the renamed file", got)
+	}
+
+	create("vendorA/a.csv.ok")
+
+	// Backdate so the global window has passed but team C's has not
+	w.modification.Store(path("vendorC/day1/c.csv"), time.Now().Add(-2*time.Second))
+	w.modification.Store(path("other/d.csv"), time.Now().Add(-2*time.Second))
+
+	got = w.GetFilesToProcess()
+	sort.Strings(got)
+	want := []string{path("other/d.csv"), path("vendorA/a.csv"), path("vendorB/b.csv")}
+	if len(got) != len(want) {
+		t.Fatalf("ready = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ready[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	w.modification.Store(path("vendorC/day1/c.csv"), time.Now().Add(-6*time.Second))
+	found := false
+	for _, f := range w.GetFilesToProcess() {
+		if f == path("vendorC/day1/c.csv") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("vendorC file not ready after its own stability window")
+	}
+}
+
+func TestWatcher_WatchesSubdirectories(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "existing"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	w, err := New(config.MethodRename, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	existing := filepath.Join(dir, "existing", "a.csv")
+	if err := os.WriteFile(existing, []byte("a"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	nested := filepath.Join(dir, "new", "deeper")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	created := filepath.Join(nested, "b.csv")
+	if err := os.WriteFile(created, []byte("b"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	ready := make(map[string]bool)
+	for _, f := range w.GetFilesToProcess() {
+		ready[f] = true
+	}
+	for _, f := range []string{existing, created} {
+		if !ready[f] {
+			t.Errorf("%s not detected, ready = %v", f, ready)
+		}
+	}
+	if ready[nested] || ready[filepath.Join(dir, "new")] {
+		t.Error("directories tracked as files")
+	}
+}
@@ -0,0 +1,304 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanSaveEvery is how many files a scan replays between saves of its
+// cursor
+const scanSaveEvery = 1000
+
+// scanLogInterval is how often a running scan logs its progress
+const scanLogInterval = 10 * time.Second
+
+// ScanCursorStore durably records where an incremental scan is, so a
+// restart resumes it. The cursor is a slash-separated path relative to the
+// input directory, empty when no scan is under way.
+type ScanCursorStore interface {
+	LoadScanCursor() (string, error)
+	SaveScanCursor(rel string) error
+}
+
+// ScanProgress reports the incremental scan of the files present at Start
+type ScanProgress struct {
+	// Running is set until every directory was walked once
+	Running bool `json:"running"`
+	// Walked counts the files and directories visited
+	Walked int64 `json:"walked"`
+	// Eligible counts the files handed to tracking
+	Eligible int64 `json:"eligible"`
+	// Deferred counts the times tracking was full and the rest of the tree
+	// was left to a later pass
+	Deferred int64 `json:"deferred"`
+	Passes   int64 `json:"passes"`
+	// Cursor is the next file to look at, relative to the input directory
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// incrementalScan holds the state of the scan started by Start when
+// EnableIncrementalScan is set
+type incrementalScan struct {
+	maxTracked int
+	store      ScanCursorStore
+	// origin is where the scan started, the cursor a restart left behind
+	origin string
+
+	running  atomic.Bool
+	walked   atomic.Int64
+	eligible atomic.Int64
+	deferred atomic.Int64
+	passes   atomic.Int64
+	saved    atomic.Int64
+	mu       sync.Mutex
+	cursor   string
+	// room is signalled when a file leaves tracking
+	room chan struct{}
+	// wg is done when the scan returns
+	wg sync.WaitGroup
+}
+
+// errScanFull ends a pass at the file the tracking bound was reached on
+var errScanFull = errors.New("tracking full")
+
+// EnableIncrementalScan makes Start return once the input directory itself
+// is watched and walk the tree in the background, so files found early are
+// processed while the rest is still being scanned. At most maxTracked files
+// are tracked at a time: once as many are, the scan waits where it is and
+// carries on as files leave tracking. Where it is is saved in store, when
+// set, and a restart resumes there before walking the part it skipped.
+// Call before Start.
+func (w *Watcher) EnableIncrementalScan(maxTracked int, store ScanCursorStore) error {
+	if maxTracked <= 0 {
+		return fmt.Errorf("invalid tracking bound %d", maxTracked)
+	}
+	s := &incrementalScan{maxTracked: maxTracked, store: store, room: make(chan struct{}, 1)}
+	if store != nil {
+		cursor, err := store.LoadScanCursor()
+		if err != nil {
+			return fmt.Errorf("load scan cursor: %w", err)
+		}
+		if cursor != "" && filepath.IsLocal(filepath.FromSlash(cursor)) {
+			s.origin = cursor
+		}
+	}
+	s.cursor = s.origin
+	w.scan = s
+	return nil
+}
+
+// ScanProgress returns the progress of the incremental scan, zero when
+// Start walks the tree before returning
+func (w *Watcher) ScanProgress() ScanProgress {
+	s := w.scan
+	if s == nil {
+		return ScanProgress{}
+	}
+	s.mu.Lock()
+	cursor := s.cursor
+	s.mu.Unlock()
+	return ScanProgress{
+		Running:  s.running.Load(),
+		Walked:   s.walked.Load(),
+		Eligible: s.eligible.Load(),
+		Deferred: s.deferred.Load(),
+		Passes:   s.passes.Load(),
+		Cursor:   cursor,
+	}
+}
+
+// scanning reports whether files present at Start may still be unscanned
+func (w *Watcher) scanning() bool {
+	return w.scan != nil && w.scan.running.Load()
+}
+
+// startScan walks the tree in the background
+func (w *Watcher) startScan() {
+	s := w.scan
+	s.running.Store(true)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		w.runScan(s)
+	}()
+	if s.origin != "" {
+		slog.Info("resuming initial scan", "cursor", s.origin, "max_tracked", s.maxTracked)
+	} else {
+		slog.Info("starting initial scan", "max_tracked", s.maxTracked)
+	}
+}
+
+// runScan walks from the origin to the end of the tree and then, after a
+// resume, from the beginning up to the origin, waiting whenever tracking is
+// full. It returns early when the watcher is closed.
+func (w *Watcher) runScan(s *incrementalScan) {
+	from, until := s.origin, ""
+	wrapped := s.origin == ""
+	lastLog := time.Now()
+	for {
+		s.passes.Add(1)
+		at, err := w.scanPass(s, from, until, &lastLog)
+		switch {
+		case errors.Is(err, errScanFull):
+			s.deferred.Add(1)
+			w.saveCursor(s, at)
+			if !w.waitForRoom(s) {
+				return
+			}
+			from = at
+			continue
+		case err != nil:
+			slog.Error("initial scan failed", "path", w.watchPath, "error", err)
+		}
+		if !wrapped {
+			wrapped = true
+			from, until = "", s.origin
+			continue
+		}
+		break
+	}
+
+	w.saveCursor(s, "")
+	// Files found by the scan may have been seen by an earlier run
+	w.seenBeforeStart()
+	s.running.Store(false)
+	p := w.ScanProgress()
+	slog.Info("initial scan finished", "walked", p.Walked, "eligible", p.Eligible, "deferred", p.Deferred, "passes", p.Passes)
+}
+
+// scanPass walks the tree from the file at from, the beginning when empty,
+// up to the one at until, the end when empty. It watches the directories
+// it enters and replays their files, returning errScanFull with the file
+// it stopped at once maxTracked files are tracked.
+func (w *Watcher) scanPass(s *incrementalScan, from, until string, lastLog *time.Time) (string, error) {
+	var stopped string
+	err := filepath.WalkDir(w.watchPath, func(path string, d fs.DirEntry, err error) error {
+		select {
+		case <-w.done:
+			return fs.SkipAll
+		default:
+		}
+		if err != nil {
+			// Files may leave while they are scanned
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if path == w.watchPath {
+			return nil
+		}
+		rel, err := filepath.Rel(w.watchPath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if until != "" && !walkBefore(rel, until) {
+			return fs.SkipAll
+		}
+
+		if d.IsDir() {
+			// Directories wholly before from were scanned already, and those
+			// holding it entered
+			entered := from != "" && strings.HasPrefix(from, rel+"/")
+			if from != "" && walkBefore(rel, from) && !entered {
+				return fs.SkipDir
+			}
+			if !entered {
+				s.walked.Add(1)
+			}
+			if ShouldIgnoreFile(path) || w.skipExcludedDir(path, d) {
+				return fs.SkipDir
+			}
+			if err := w.addWatch(path); err != nil {
+				return fmt.Errorf("add watch path %s: %w", path, err)
+			}
+			return nil
+		}
+		if from != "" && walkBefore(rel, from) {
+			return nil
+		}
+		if w.trackedCount() >= s.maxTracked {
+			stopped = rel
+			return errScanFull
+		}
+		s.walked.Add(1)
+		if d.Type().IsRegular() && !w.isExcluded(path) {
+			w.replay(path, d)
+			s.eligible.Add(1)
+			if w.onScan != nil {
+				w.onScan(path)
+			}
+			if s.saved.Add(1)%scanSaveEvery == 0 {
+				w.saveCursor(s, rel)
+			}
+		}
+		if time.Since(*lastLog) >= scanLogInterval {
+			*lastLog = time.Now()
+			slog.Info("initial scan progress", "walked", s.walked.Load(), "eligible", s.eligible.Load(), "deferred", s.deferred.Load(), "tracked", w.trackedCount())
+		}
+		return nil
+	})
+	if errors.Is(err, errScanFull) {
+		return stopped, err
+	}
+	return "", err
+}
+
+// saveCursor records rel as the next file to look at
+func (w *Watcher) saveCursor(s *incrementalScan, rel string) {
+	s.mu.Lock()
+	s.cursor = rel
+	s.mu.Unlock()
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SaveScanCursor(rel); err != nil {
+		slog.Warn("failed to save scan cursor", "cursor", rel, "error", err)
+	}
+}
+
+// waitForRoom waits until fewer than maxTracked files are tracked, and
+// reports false when the watcher is closed first
+func (w *Watcher) waitForRoom(s *incrementalScan) bool {
+	for w.trackedCount() >= s.maxTracked {
+		select {
+		case <-w.done:
+			return false
+		case <-s.room:
+		}
+	}
+	return true
+}
+
+// trackedCount returns how many files are tracked
+func (w *Watcher) trackedCount() int {
+	w.seenMu.Lock()
+	defer w.seenMu.Unlock()
+	return len(w.firstSeen)
+}
+
+// walkBefore reports whether filepath.WalkDir visits the slash-separated
+// relative path a before b. Directories are read in name order and walked
+// depth first, so paths compare component by component, a directory before
+// what it contains.
+func walkBefore(a, b string) bool {
+	for {
+		ca, restA, moreA := strings.Cut(a, "/")
+		cb, restB, moreB := strings.Cut(b, "/")
+		if ca != cb {
+			return ca < cb
+		}
+		if !moreA || !moreB {
+			return !moreA && moreB
+		}
+		a, b = restA, restB
+	}
+}
@@ -0,0 +1,201 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// memoryCursors is a ScanCursorStore recording every cursor saved
+type memoryCursors struct {
+	mu     sync.Mutex
+	cursor string
+	saves  int
+}
+
+func (m *memoryCursors) LoadScanCursor() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cursor, nil
+}
+
+func (m *memoryCursors) SaveScanCursor(rel string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cursor = rel
+	m.saves++
+	return nil
+}
+
+// writeTree writes dirs directories of perDir files below root
+func writeTree(t *testing.T, root string, dirs, perDir int) {
+	t.Helper()
+	for d := range dirs {
+		dir := filepath.Join(root, fmt.Sprintf("d%03d", d))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+		for f := range perDir {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%04d.csv", f)), nil, 0o644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+		}
+	}
+}
+
+// waitForScan waits until the incremental scan of w returns
+func waitForScan(t *testing.T, w *Watcher) {
+	t.Helper()
+	finished := make(chan struct{})
+	go func() {
+		w.scan.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("scan did not finish: %+v", w.ScanProgress())
+	}
+}
+
+func TestIncrementalScan_ProcessesWhileScanning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large tree test in short mode")
+	}
+
+	const dirs, perDir, bound = 40, 500, 256
+	tmpDir := t.TempDir()
+	writeTree(t, tmpDir, dirs, perDir)
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	cursors := &memoryCursors{}
+	if err := w.EnableIncrementalScan(bound, cursors); err != nil {
+		t.Fatalf("EnableIncrementalScan() error = %v", err)
+	}
+	var highest atomic.Int64
+	w.onScan = func(string) {
+		if n := int64(w.trackedCount()); n > highest.Load() {
+			highest.Store(n)
+		}
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// Stands in for the processor, taking ready files out of tracking
+	var processed, duringScan atomic.Int64
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			running := w.ScanProgress().Running
+			for _, path := range w.GetFilesToProcess() {
+				w.RemoveFromTracking(path)
+				processed.Add(1)
+				if running {
+					duringScan.Add(1)
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	waitForScan(t, w)
+	p := w.ScanProgress()
+	if p.Running || p.Eligible != dirs*perDir || p.Walked != dirs*perDir+dirs {
+		t.Errorf("progress = %+v, want %d eligible of %d walked and not running", p, dirs*perDir, dirs*perDir+dirs)
+	}
+	if p.Deferred == 0 {
+		t.Errorf("progress = %+v, want the scan to have waited for room", p)
+	}
+	if duringScan.Load() == 0 {
+		t.Error("no file was processed before the scan finished")
+	}
+	if n := highest.Load(); n > bound {
+		t.Errorf("%d files tracked at once, want at most %d", n, bound)
+	}
+	if cursors.cursor != "" || cursors.saves == 0 {
+		t.Errorf("cursor = %q after %d saves, want saved and cleared at the end", cursors.cursor, cursors.saves)
+	}
+}
+
+func TestIncrementalScan_ResumesAtCursor(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeTree(t, tmpDir, 4, 5)
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	// A restart left the scan in the third directory
+	cursors := &memoryCursors{cursor: "d002/f0003.csv"}
+	if err := w.EnableIncrementalScan(100, cursors); err != nil {
+		t.Fatalf("EnableIncrementalScan() error = %v", err)
+	}
+	var order []string
+	w.onScan = func(path string) {
+		rel, _ := filepath.Rel(w.Root(), path)
+		order = append(order, filepath.ToSlash(rel))
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if mark, _ := w.Watermark(); !mark.IsZero() {
+		t.Errorf("watermark = %v while scanning, want zero", mark)
+	}
+	waitForScan(t, w)
+
+	if len(order) != 20 {
+		t.Fatalf("scanned %d files, want 20: %v", len(order), order)
+	}
+	if order[0] != "d002/f0003.csv" || order[len(order)-1] != "d002/f0002.csv" {
+		t.Errorf("scanned %s first and %s last, want the cursor first and the file before it last", order[0], order[len(order)-1])
+	}
+	if got := len(w.GetFilesToProcess()); got != 20 {
+		t.Errorf("%d files ready, want 20", got)
+	}
+	if p := w.ScanProgress(); p.Passes != 2 || p.Cursor != "" {
+		t.Errorf("progress = %+v, want two passes and no cursor", p)
+	}
+	if cursors.cursor != "" {
+		t.Errorf("saved cursor = %q, want cleared", cursors.cursor)
+	}
+}
+
+func TestWalkBefore(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"a", "b", true},
+		{"a", "a/b", true},
+		{"a/b", "a", false},
+		{"a/z", "a-b", true},
+		{"a-b", "a/z", false},
+		{"a/b", "a/c", true},
+		{"a", "a", false},
+	}
+	for _, tt := range tests {
+		if got := walkBefore(tt.a, tt.b); got != tt.want {
+			t.Errorf("walkBefore(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package watcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Methods reported by Tracked for grouped files
+const (
+	// MethodFileSet marks pending multi-part sets
+	MethodFileSet = "file_set"
+	// MethodBatch marks directory batches whose marker has arrived
+	MethodBatch = "batch"
+)
+
+// TrackedFile describes a file the watcher is waiting on or has marked ready
+type TrackedFile struct {
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	// Since is the last write seen for stability-window files and the latest
+	// part of a file set; methods that only mark completion leave it zero
+	Since time.Time `json:"since,omitzero"`
+	Ready bool      `json:"ready"`
+}
+
+// Tracked returns every tracked file and pending file set sorted by path
+func (w *Watcher) Tracked() []TrackedFile {
+	tracked := make([]TrackedFile, 0)
+
+	if w.modification != nil {
+		w.modification.Range(func(key, value any) bool {
+			name := key.(string)
+			mtime := value.(time.Time)
+			method, stabilitySeconds := w.methodFor(name)
+			tracked = append(tracked, TrackedFile{
+				Path:   name,
+				Method: method,
+				Since:  mtime,
+				Ready:  stable(mtime, stabilitySeconds, w.now()),
+			})
+			return true
+		})
+	}
+
+	if w.completed != nil {
+		w.completed.Range(func(key, value any) bool {
+			name := key.(string)
+			method, _ := w.methodFor(name)
+			tracked = append(tracked, TrackedFile{
+				Path:   name,
+				Method: method,
+				Ready:  value.(bool),
+			})
+			return true
+		})
+	}
+
+	if w.fileSets != nil {
+		w.fileSets.mu.Lock()
+		for _, set := range w.fileSets.sets {
+			tracked = append(tracked, TrackedFile{
+				Path:   set.Path,
+				Method: MethodFileSet,
+				Since:  set.LastPartAt,
+				Ready:  !set.MarkerAt.IsZero(),
+			})
+		}
+		w.fileSets.mu.Unlock()
+	}
+
+	if w.batches != nil {
+		w.batches.mu.Lock()
+		for _, b := range w.batches.ready {
+			tracked = append(tracked, TrackedFile{
+				Path:   b.Dir,
+				Method: MethodBatch,
+				Since:  b.MarkerAt,
+				Ready:  true,
+			})
+		}
+		w.batches.mu.Unlock()
+	}
+
+	sort.Slice(tracked, func(i, j int) bool { return tracked[i].Path < tracked[j].Path })
+	return tracked
+}
+
+// IsTracked reports whether path is tracked by the watcher, or is held back
+// as a part or marker of a multi-part set or as a file of a batch directory
+func (w *Watcher) IsTracked(path string) bool {
+	path = w.canonical(path)
+	for _, m := range []*sync.Map{w.modification, w.completed} {
+		if m == nil {
+			continue
+		}
+		if _, ok := w.trackedKey(m, path); ok {
+			return true
+		}
+	}
+	if w.fileSets != nil && w.fileSets.isSetFile(path) {
+		return true
+	}
+	if w.batches != nil {
+		if _, ok := w.batches.dirOf(path); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestTracked(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 5)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.EnableFileSets(`^(.+)\.(\d+)$`); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+
+	settled := filepath.Join(tmpDir, "a.csv")
+	fresh := filepath.Join(tmpDir, "b.csv")
+	w.modification.Store(settled, time.Now().Add(-10*time.Second))
+	w.modification.Store(fresh, time.Now())
+	w.handleEvent(fsnotify.Event{Name: filepath.Join(tmpDir, "c.csv.001"), Op: fsnotify.Create})
+
+	tracked := w.Tracked()
+	if len(tracked) != 3 {
+		t.Fatalf("expected 3 tracked files, got %d: %+v", len(tracked), tracked)
+	}
+
+	want := []struct {
+		path   string
+		method string
+		ready  bool
+	}{
+		{settled, config.MethodStabilityWindow, true},
+		{fresh, config.MethodStabilityWindow, false},
+		{filepath.Join(tmpDir, "c.csv"), MethodFileSet, false},
+	}
+	for i, tt := range want {
+		if tracked[i].Path != tt.path || tracked[i].Method != tt.method || tracked[i].Ready != tt.ready {
+			t.Errorf("tracked[%d] = %+v, want path %q method %q ready %v", i, tracked[i], tt.path, tt.method, tt.ready)
+		}
+	}
+}
+
+func TestIsTracked(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 5)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.EnableFileSets(`^(.+)\.(\d+)$`); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+	if err := w.EnableBatches("batch_*", config.DefaultBatchMarker); err != nil {
+		t.Fatalf("EnableBatches failed: %v", err)
+	}
+	w.modification.Store(filepath.Join(tmpDir, "a.csv"), time.Now())
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.csv", true},
+		{"c.csv.001", true},
+		{"c.csv" + config.FileSetMarkerSuffix, true},
+		{"batch_1/data.csv", true},
+		{"other.log", false},
+	}
+	for _, tt := range tests {
+		if got := w.IsTracked(filepath.Join(tmpDir, tt.name)); got != tt.want {
+			t.Errorf("IsTracked(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
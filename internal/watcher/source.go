@@ -0,0 +1,257 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ProbeTimeout is how long a probe waits for the event of its file
+const ProbeTimeout = 2 * time.Second
+
+// eventProbePrefix names the files probing for events. They are hidden and
+// temporary, so they are never tracked.
+const eventProbePrefix = ".event-probe-"
+
+// ErrNoEvents is returned by Start when the fsnotify backend delivers no
+// event for a probe file
+var ErrNoEvents = errors.New("file system delivers no events")
+
+// eventSource delivers the events of the directories added to it, like
+// fsnotify does: each directory is watched on its own, not recursively
+type eventSource interface {
+	Add(path string) error
+	Remove(path string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+	// WatchList returns the watched directories
+	WatchList() []string
+}
+
+// notifySource is the fsnotify backend
+type notifySource struct {
+	*fsnotify.Watcher
+}
+
+func newNotifySource() (*notifySource, error) {
+	fsWatcher, err := fsnotify.NewBufferedWatcher(eventBuffer)
+	if err != nil {
+		return nil, fmt.Errorf("create fsnotify watcher: %w", err)
+	}
+	return &notifySource{fsWatcher}, nil
+}
+
+func (s *notifySource) Events() <-chan fsnotify.Event { return s.Watcher.Events }
+func (s *notifySource) Errors() <-chan error          { return s.Watcher.Errors }
+
+// SetBackend selects how file system events are received, one of the
+// config.WatchBackend* values. pollInterval is the scan interval of the
+// polling backend and probeInterval how often fsnotify is probed again
+// after Start, so that a remount that stops its events is noticed (0 probes
+// only at Start). Without SetBackend fsnotify is used and never probed.
+// Call before Start.
+func (w *Watcher) SetBackend(backend string, pollInterval, probeInterval time.Duration) error {
+	switch backend {
+	case config.WatchBackendAuto, config.WatchBackendFsnotify, config.WatchBackendPoll:
+	default:
+		return fmt.Errorf("unknown watch backend: %s", backend)
+	}
+	if pollInterval <= 0 {
+		return fmt.Errorf("invalid poll interval %s", pollInterval)
+	}
+	w.backend = backend
+	w.pollInterval = pollInterval
+	w.probeInterval = probeInterval
+	return nil
+}
+
+// Backend returns the backend events are currently received from
+func (w *Watcher) Backend() string {
+	w.sourceMu.RLock()
+	defer w.sourceMu.RUnlock()
+	if _, ok := w.source.(*pollSource); ok {
+		return config.WatchBackendPoll
+	}
+	return config.WatchBackendFsnotify
+}
+
+// currentSource returns the source events are received from
+func (w *Watcher) currentSource() eventSource {
+	w.sourceMu.RLock()
+	defer w.sourceMu.RUnlock()
+	return w.source
+}
+
+// addWatch watches the directory at path with the current source
+func (w *Watcher) addWatch(path string) error {
+	w.sourceMu.RLock()
+	defer w.sourceMu.RUnlock()
+	return w.source.Add(path)
+}
+
+// removeWatch stops watching the directory at path with the current source
+func (w *Watcher) removeWatch(path string) error {
+	w.sourceMu.RLock()
+	defer w.sourceMu.RUnlock()
+	return w.source.Remove(path)
+}
+
+// Unwatch stops watching the directory at path, removed from the input
+// tree. A watch the backend dropped already with the directory is no error,
+// and a directory a producer created again since stays watched.
+func (w *Watcher) Unwatch(path string) error {
+	if err := w.removeWatch(path); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		return w.addWatch(path)
+	}
+	return nil
+}
+
+// verifyEvents probes for events at Start. When none arrives the auto
+// backend switches to polling and the fsnotify backend fails. A probe that
+// cannot be written, as into a read-only input, is skipped.
+func (w *Watcher) verifyEvents() error {
+	ok, err := w.probeEvents(w.probeTimeout)
+	if err != nil {
+		slog.Warn("failed to probe for file system events, assuming they are delivered", "path", w.watchPath, "error", err)
+		return nil
+	}
+	if ok {
+		return nil
+	}
+	if w.backend != config.WatchBackendAuto {
+		return fmt.Errorf("%w: no event for a probe file in %s within %s; the filesystem may not support inotify, such as NFS or FUSE, so run with -watch-backend=auto or poll",
+			ErrNoEvents, w.watchPath, w.probeTimeout)
+	}
+	w.fallBackToPolling()
+	return nil
+}
+
+// probeEvents creates a probe file in the input directory and reports
+// whether the current source delivered its event within timeout
+func (w *Watcher) probeEvents(timeout time.Duration) (bool, error) {
+	name := filepath.Join(w.watchPath, eventProbePrefix+strconv.Itoa(os.Getpid())+"-"+
+		strconv.FormatInt(w.probeSeq.Add(1), 10)+config.TempSuffix)
+	arrived := make(chan struct{})
+	w.probes.Store(name, arrived)
+	defer w.probes.Delete(name)
+
+	if err := os.WriteFile(name, nil, 0o600); err != nil {
+		return false, fmt.Errorf("create event probe: %w", err)
+	}
+	defer func() { _ = os.Remove(name) }()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-arrived:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-w.done:
+		return true, nil
+	}
+}
+
+// observeProbe signals the probe waiting for the event of path, if any
+func (w *Watcher) observeProbe(path string) {
+	if arrived, ok := w.probes.LoadAndDelete(path); ok {
+		close(arrived.(chan struct{}))
+	}
+}
+
+// reprobe probes for events every probeInterval until the watcher is
+// closed or has fallen back to polling
+func (w *Watcher) reprobe() {
+	ticker := time.NewTicker(w.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		ok, err := w.probeEvents(w.probeTimeout)
+		switch {
+		case err != nil:
+			slog.Warn("failed to probe for file system events", "path", w.watchPath, "error", err)
+		case ok:
+		case w.backend == config.WatchBackendAuto:
+			w.fallBackToPolling()
+			return
+		default:
+			slog.Error("file system stopped delivering events, new files are not seen; restart with -watch-backend=auto or poll",
+				"path", w.watchPath,
+				"backend", w.backend,
+			)
+		}
+	}
+}
+
+// fallBackToPolling replaces the fsnotify source with a polling one
+// watching the same directories, then replays the files that arrived
+// unseen
+func (w *Watcher) fallBackToPolling() {
+	slog.Warn("no file system events for a probe file, falling back to polling",
+		"path", w.watchPath,
+		"poll_interval", w.pollInterval,
+	)
+	poll := newPollSource(w.pollInterval)
+
+	w.sourceMu.Lock()
+	select {
+	case <-w.done:
+		w.sourceMu.Unlock()
+		_ = poll.Close()
+		return
+	default:
+	}
+	old := w.source
+	for _, dir := range old.WatchList() {
+		if err := poll.Add(dir); err != nil {
+			slog.Warn("failed to poll directory", "path", dir, "error", err)
+		}
+	}
+	w.source = poll
+	w.sourceMu.Unlock()
+
+	w.fallbacks.Add(1)
+	go w.runEventLoop(poll)
+	if err := old.Close(); err != nil {
+		slog.Warn("failed to close fsnotify watcher", "error", err)
+	}
+	w.replayUntracked()
+}
+
+// replayUntracked replays every file below the input directory that is not
+// tracked, as Start does, for files whose events were lost
+func (w *Watcher) replayUntracked() {
+	err := filepath.WalkDir(w.watchPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != w.watchPath && (ShouldIgnoreFile(path) || w.skipExcludedDir(path, d)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && !w.isExcluded(path) && !w.IsTracked(path) {
+			w.replay(path, d)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to replay files after switching backend", "path", w.watchPath, "error", err)
+	}
+}
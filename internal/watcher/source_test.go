@@ -0,0 +1,189 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeSource forwards the events of a real fsnotify source until it is made
+// dead, as a remount onto NFS would
+type fakeSource struct {
+	*notifySource
+	events chan fsnotify.Event
+	dead   atomic.Bool
+}
+
+func newFakeSource(t *testing.T) *fakeSource {
+	t.Helper()
+	inner, err := newNotifySource()
+	if err != nil {
+		t.Fatalf("newNotifySource failed: %v", err)
+	}
+	s := &fakeSource{notifySource: inner, events: make(chan fsnotify.Event, eventBuffer)}
+	go func() {
+		defer close(s.events)
+		for event := range inner.Watcher.Events {
+			if !s.dead.Load() {
+				s.events <- event
+			}
+		}
+	}()
+	return s
+}
+
+func (s *fakeSource) Events() <-chan fsnotify.Event { return s.events }
+
+// watchWithSource returns a watcher of dir receiving events from src
+func watchWithSource(t *testing.T, dir string, src eventSource, backend string) *Watcher {
+	t.Helper()
+	w, err := New(config.MethodStabilityWindow, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	_ = w.source.Close()
+	w.source = src
+	if err := w.SetBackend(backend, 20*time.Millisecond, 0); err != nil {
+		t.Fatalf("SetBackend failed: %v", err)
+	}
+	w.probeTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// waitTracked waits for path to be tracked
+func waitTracked(t *testing.T, w *Watcher, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !w.IsTracked(path) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !w.IsTracked(path) {
+		t.Fatalf("%s was never tracked", filepath.Base(path))
+	}
+}
+
+func TestStart_ProbeKeepsWorkingFsnotify(t *testing.T) {
+	dir := t.TempDir()
+	w := watchWithSource(t, dir, newFakeSource(t), config.WatchBackendAuto)
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if got := w.Backend(); got != config.WatchBackendFsnotify {
+		t.Errorf("Backend() = %q, want fsnotify while events arrive", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("input holds %d entries after the probe, want the probe file removed", len(entries))
+	}
+}
+
+func TestStart_AutoFallsBackToPolling(t *testing.T) {
+	dir := t.TempDir()
+	src := newFakeSource(t)
+	src.dead.Store(true)
+	w := watchWithSource(t, dir, src, config.WatchBackendAuto)
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatalf("failed to create subdirectory: %v", err)
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	stats := w.EventStats()
+	if stats.Backend != config.WatchBackendPoll || stats.Fallbacks != 1 {
+		t.Fatalf("EventStats() = %+v, want one fallback to poll", stats)
+	}
+
+	path := filepath.Join(sub, "data.csv")
+	if err := os.WriteFile(path, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	waitTracked(t, w, path)
+}
+
+func TestStart_FsnotifyFailsWithoutEvents(t *testing.T) {
+	src := newFakeSource(t)
+	src.dead.Store(true)
+	w := watchWithSource(t, t.TempDir(), src, config.WatchBackendFsnotify)
+
+	if err := w.Start(); !errors.Is(err, ErrNoEvents) {
+		t.Fatalf("Start() = %v, want ErrNoEvents", err)
+	}
+}
+
+func TestReprobe_FallsBackWhenEventsStop(t *testing.T) {
+	dir := t.TempDir()
+	src := newFakeSource(t)
+	w := watchWithSource(t, dir, src, config.WatchBackendAuto)
+	w.probeInterval = 50 * time.Millisecond
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	src.dead.Store(true)
+	// Written while no events arrive, so only the replay after the switch
+	// can find it
+	lost := filepath.Join(dir, "lost.csv")
+	if err := os.WriteFile(lost, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Backend() != config.WatchBackendPoll && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := w.Backend(); got != config.WatchBackendPoll {
+		t.Fatalf("Backend() = %q after events stopped, want poll", got)
+	}
+	waitTracked(t, w, lost)
+}
+
+func TestStart_PollBackend(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, dir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.SetBackend(config.WatchBackendPoll, 20*time.Millisecond, 0); err != nil {
+		t.Fatalf("SetBackend failed: %v", err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if got := w.Backend(); got != config.WatchBackendPoll {
+		t.Errorf("Backend() = %q, want poll", got)
+	}
+
+	path := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(path, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	waitTracked(t, w, path)
+}
+
+func TestSetBackend_Invalid(t *testing.T) {
+	w, err := New(config.MethodStabilityWindow, t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.SetBackend("inotify", time.Second, 0); err == nil {
+		t.Error("SetBackend accepted an unknown backend")
+	}
+	if err := w.SetBackend(config.WatchBackendPoll, 0, 0); err == nil {
+		t.Error("SetBackend accepted a zero poll interval")
+	}
+}
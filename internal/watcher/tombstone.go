@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// SetTombstoneWindow sets how long events for a path are ignored once
+// MarkDone reported it done; 0 ignores none. Call before Start.
+func (w *Watcher) SetTombstoneWindow(d time.Duration) {
+	w.tombstoneWindow = d
+}
+
+// MarkDone stops tracking the file at path, which reached a terminal state
+// and left the input, and ignores events for it for the tombstone window: a
+// producer touching the sidecar again would otherwise mark the file gone
+// completed anew. The tombstone expires so that a later file of the same
+// name is processed.
+func (w *Watcher) MarkDone(path string) {
+	path = w.canonical(path)
+	if w.tombstoneWindow > 0 {
+		// Before the removal, so an event in between is not tracked anew
+		w.tombstones.Store(w.foldKey(path), w.now().Add(w.tombstoneWindow))
+	}
+	w.RemoveFromTracking(path)
+}
+
+// tombstoned reports whether events for path are ignored at now, forgetting
+// its tombstone once expired
+func (w *Watcher) tombstoned(path string, now time.Time) bool {
+	key := w.foldKey(path)
+	value, ok := w.tombstones.Load(key)
+	if !ok {
+		return false
+	}
+	if !now.Before(value.(time.Time)) {
+		w.tombstones.CompareAndDelete(key, value)
+		return false
+	}
+	return true
+}
+
+// suppress reports whether event, which would track the file at path, is
+// ignored for a file done within the tombstone window, counting it if so
+func (w *Watcher) suppress(event fsnotify.Event, path string) bool {
+	if !w.tombstoned(path, w.now()) {
+		return false
+	}
+	w.events.suppressed.Add(1)
+	slog.Debug("ignoring event of a file already done", "event", event.Name, "path", path)
+	return true
+}
+
+// pruneTombstones forgets the tombstones expired at now
+func (w *Watcher) pruneTombstones(now time.Time) {
+	w.tombstones.Range(func(key, value any) bool {
+		if !now.Before(value.(time.Time)) {
+			w.tombstones.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
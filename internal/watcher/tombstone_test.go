@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestMarkDone_IgnoresLateSidecarUntilExpiry(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodSidecar, tmpDir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	clock := &jumpClock{now: time.Date(2024, 6, 14, 12, 0, 0, 0, time.UTC)}
+	w.now = clock.Now
+	w.SetTombstoneWindow(time.Minute)
+
+	data := filepath.Join(tmpDir, "data.csv")
+	sidecar := fsnotify.Event{Name: data + config.SidecarSuffix, Op: fsnotify.Create}
+	w.handleEvent(sidecar)
+	if files := w.GetFilesToProcess(); len(files) != 1 {
+		t.Fatalf("files to process = %v, want the completed file", files)
+	}
+
+	w.MarkDone(data)
+	clock.Add(30 * time.Second)
+	w.handleEvent(sidecar)
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process after a late sidecar = %v, want none", files)
+	}
+	if n := w.EventStats().Suppressed; n != 1 {
+		t.Errorf("suppressed = %d, want 1", n)
+	}
+
+	// A file reusing the name after the window is processed
+	clock.Add(time.Minute)
+	w.handleEvent(sidecar)
+	if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != data {
+		t.Errorf("files to process after the window = %v, want %s", files, data)
+	}
+}
+
+func TestMarkDone_NoWindow(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, tmpDir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	path := filepath.Join(tmpDir, "a.csv")
+	w.trackModification(path, time.Now())
+	w.MarkDone(path)
+	if w.IsTracked(path) {
+		t.Error("file still tracked after MarkDone")
+	}
+	w.handleEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+	if !w.IsTracked(path) || w.EventStats().Suppressed != 0 {
+		t.Error("event ignored without a tombstone window")
+	}
+}
@@ -0,0 +1,146 @@
+package watcher
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// LargestUntracked is how many of the largest untracked files InputUsage
+// lists
+const LargestUntracked = 10
+
+// UsageCount tallies files residing in the input directory
+type UsageCount struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// UntrackedFile is a file residing in the input directory that the watcher
+// does not track, often a partial upload stuck in place
+type UntrackedFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Ignored is set for a file the watcher filters, such as a temporary
+	// upload, and unset for one it does not know about
+	Ignored bool `json:"ignored"`
+}
+
+// InputUsage is what resides in the input directory. It is measured by the
+// walks of the whole tree the watcher makes anyway: the walk at Start and
+// a Rescan of the input directory itself, so it is as old as MeasuredAt.
+// Directories the watch skips are not walked and not counted.
+type InputUsage struct {
+	MeasuredAt time.Time `json:"measured_at"`
+	// Tracked counts the files the watcher tracks
+	Tracked UsageCount `json:"tracked"`
+	// Ignored counts the files it filters: hidden and temporary files,
+	// receipts, sidecars and excluded paths
+	Ignored UsageCount `json:"ignored"`
+	// Unknown counts the rest, such as files waiting for their completion
+	// signal or left in place once ingested
+	Unknown UsageCount `json:"unknown"`
+	// Largest are the largest ignored or unknown files, largest first
+	Largest []UntrackedFile `json:"largest"`
+}
+
+// InputUsage returns what the last walk of the whole tree found in the
+// input directory, nil before one completed
+func (w *Watcher) InputUsage() *InputUsage {
+	u := w.usage.Load()
+	if u == nil {
+		return nil
+	}
+	usage := *u
+	usage.Largest = slices.Clone(u.Largest)
+	return &usage
+}
+
+// usageTally accumulates the InputUsage of a walk. A nil tally, that of a
+// walk of a subdirectory, counts nothing.
+type usageTally struct {
+	usage InputUsage
+	// pending are the files not tracked when walked, which may be once a
+	// sidecar walked later completes them
+	pending []UntrackedFile
+}
+
+// observe counts the regular file at path after the walk handled it. info
+// is fetched from d when nil; a file gone meanwhile is not counted.
+func (t *usageTally) observe(w *Watcher, path string, d fs.DirEntry, info fs.FileInfo) {
+	if t == nil {
+		return
+	}
+	if info == nil {
+		var err error
+		if info, err = d.Info(); err != nil {
+			return
+		}
+	}
+	switch {
+	case w.IsTracked(path):
+		t.usage.Tracked.add(info.Size())
+	case w.filtered(path):
+		t.usage.Ignored.add(info.Size())
+		t.keep(UntrackedFile{Path: path, Size: info.Size(), ModTime: info.ModTime(), Ignored: true})
+	default:
+		t.pending = append(t.pending, UntrackedFile{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+}
+
+// keep adds f to the largest untracked files when it is among them
+func (t *usageTally) keep(f UntrackedFile) {
+	i, _ := slices.BinarySearchFunc(t.usage.Largest, f.Size, func(e UntrackedFile, size int64) int {
+		// Descending by size
+		switch {
+		case e.Size > size:
+			return -1
+		case e.Size < size:
+			return 1
+		}
+		return 0
+	})
+	if i >= LargestUntracked {
+		return
+	}
+	t.usage.Largest = slices.Insert(t.usage.Largest, i, f)
+	if len(t.usage.Largest) > LargestUntracked {
+		t.usage.Largest = t.usage.Largest[:LargestUntracked]
+	}
+}
+
+func (c *UsageCount) add(size int64) {
+	c.Files++
+	c.Bytes += size
+}
+
+// filtered reports whether the watcher never tracks the file at path
+func (w *Watcher) filtered(path string) bool {
+	return ShouldIgnoreFile(path) || strings.HasSuffix(path, config.SidecarSuffix) || w.isExcluded(path)
+}
+
+// recordUsage settles the files of t left pending and makes its usage the
+// one InputUsage reports
+func (w *Watcher) recordUsage(t *usageTally) {
+	if t == nil {
+		return
+	}
+	for _, f := range t.pending {
+		if w.IsTracked(f.Path) {
+			t.usage.Tracked.add(f.Size)
+			continue
+		}
+		t.usage.Unknown.add(f.Size)
+		t.keep(f)
+	}
+	t.pending = nil
+	if t.usage.Largest == nil {
+		t.usage.Largest = make([]UntrackedFile, 0)
+	}
+	t.usage.MeasuredAt = w.now()
+	w.usage.Store(&t.usage)
+}
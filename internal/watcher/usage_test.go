@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// writeSized writes a file of size bytes below root
+func writeSized(t *testing.T, root, rel string, size int) string {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInputUsage_MeasuredAtStart(t *testing.T) {
+	root := t.TempDir()
+	writeSized(t, root, "acme/a.csv", 10)
+	stuck := writeSized(t, root, "acme/upload.csv.part", 300)
+	writeSized(t, root, "acme/.hidden", 5)
+	writeSized(t, root, "b.csv", 20)
+
+	w, err := New(config.MethodStabilityWindow, root, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if w.InputUsage() != nil {
+		t.Error("InputUsage() set before any walk")
+	}
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	u := w.InputUsage()
+	if u == nil || u.MeasuredAt.IsZero() {
+		t.Fatalf("InputUsage() = %+v, want the walk at start measured", u)
+	}
+	if u.Tracked != (UsageCount{Files: 2, Bytes: 30}) || u.Ignored != (UsageCount{Files: 2, Bytes: 305}) || u.Unknown != (UsageCount{}) {
+		t.Errorf("InputUsage() = %+v, want 2 tracked and 2 ignored files", u)
+	}
+	if len(u.Largest) != 2 || u.Largest[0].Path != stuck || !u.Largest[0].Ignored {
+		t.Errorf("largest untracked = %+v, want the partial upload first", u.Largest)
+	}
+}
+
+func TestInputUsage_RootRescan(t *testing.T) {
+	root := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, root, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	writeSized(t, root, "acme/new.csv", 10)
+	kept := writeSized(t, root, "acme/kept.csv", 50)
+	for i := range LargestUntracked + 2 {
+		writeSized(t, root, filepath.Join("junk", strings.Repeat("j", i+1)+".tmp"), i+1)
+	}
+	opts := RescanOptions{Ingested: func(path string, _ int64) bool { return path == kept }}
+
+	// A subdirectory is not the whole tree
+	if _, err := w.Rescan(context.Background(), "acme", opts); err != nil {
+		t.Fatalf("Rescan() error = %v", err)
+	}
+	if u := w.InputUsage(); u != nil {
+		t.Errorf("InputUsage() = %+v after a subdirectory rescan, want none", u)
+	}
+
+	if _, err := w.Rescan(context.Background(), ".", opts); err != nil {
+		t.Fatalf("Rescan() error = %v", err)
+	}
+	u := w.InputUsage()
+	if u == nil {
+		t.Fatal("InputUsage() = nil after a rescan of the input directory")
+	}
+	if u.Tracked != (UsageCount{Files: 1, Bytes: 10}) || u.Unknown != (UsageCount{Files: 1, Bytes: 50}) || u.Ignored.Files != LargestUntracked+2 {
+		t.Errorf("InputUsage() = %+v, want the tracked, kept and junk files", u)
+	}
+	if len(u.Largest) != LargestUntracked || u.Largest[0].Path != kept || u.Largest[0].Ignored {
+		t.Fatalf("largest untracked = %+v, want the %d largest, the kept file first", u.Largest, LargestUntracked)
+	}
+	for i := 1; i < len(u.Largest); i++ {
+		if u.Largest[i].Size > u.Largest[i-1].Size {
+			t.Errorf("largest untracked not ordered by size: %+v", u.Largest)
+		}
+	}
+}
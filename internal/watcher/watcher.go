@@ -2,38 +2,42 @@ package watcher
 
 import (
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1995parham-learning/atomic-ingestor/internal/config"
 	"github.com/fsnotify/fsnotify"
 )
 
-// tempFileSuffixes lists file extensions that indicate temporary/incomplete files
-var tempFileSuffixes = []string{
-	".tmp",
-	".part",
-	".swp",
-	".crdownload",
-	".partial",
-	".download",
-	"~",
-}
-
-// shouldIgnoreFile returns true if the file should be ignored based on its name
-func shouldIgnoreFile(path string) bool {
+// ShouldIgnoreFile returns true if the file should be ignored based on its name
+func ShouldIgnoreFile(path string) bool {
 	name := filepath.Base(path)
 
+	// Ignore anything inside the trash of already-ingested sources
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if part == config.TrashDirName {
+			return true
+		}
+	}
+
 	// Ignore hidden files (starting with .)
 	if strings.HasPrefix(name, ".") {
 		return true
 	}
 
+	// Ignore receipts written back for producers
+	if strings.HasSuffix(name, config.ReceiptSuffix) {
+		return true
+	}
+
 	// Ignore temporary file patterns
-	for _, suffix := range tempFileSuffixes {
+	for _, suffix := range config.TempFileSuffixes {
 		if strings.HasSuffix(name, suffix) {
 			return true
 		}
@@ -42,26 +46,116 @@ func shouldIgnoreFile(path string) bool {
 	return false
 }
 
+// maxClockSkew is how far ahead of the clock a modification time may be
+// before it is treated as bogus
+const maxClockSkew = 5 * time.Second
+
 type Watcher struct {
-	fsWatcher        *fsnotify.Watcher
+	// source delivers the file system events, swapped under sourceMu when
+	// the auto backend falls back to polling
+	sourceMu         sync.RWMutex
+	source           eventSource
 	modification     *sync.Map
 	completed        *sync.Map
 	method           string
 	watchPath        string
 	stabilitySeconds int
+	fileSets         *fileSets
+	batches          *batches
+	routes           []route
+	excluded         []excludedDir
+	// excludeDirs are the excluded directory patterns, swapped whole by
+	// SetExcludeDirs
+	excludeDirs atomic.Pointer[[]dirPattern]
+	// started is set once Start watches the input tree
+	started atomic.Bool
+	// scan walks the tree after Start returns when set; see
+	// EnableIncrementalScan. onScan is a test hook run on each file it
+	// replays.
+	scan   *incrementalScan
+	onScan func(path string)
+	// rescanMu lets one Rescan run at a time
+	rescanMu sync.Mutex
+	// usage is what the last walk of the whole tree found; see InputUsage
+	usage atomic.Pointer[InputUsage]
+	// normalize is the Unicode normalization tracking lookups compare in
+	normalize string
+	// foldCase makes tracking lookups ignore case, set by Start when
+	// caseProbe finds the input filesystem case-insensitive
+	foldCase  bool
+	caseProbe func(dir string) (bool, error)
+	events    eventStats
+	// completions persists sidecar completions, keyed in persisted
+	completions CompletionStore
+	persisted   sync.Map
+	// claimed holds the folded paths a worker is processing, which
+	// GetFilesToProcess leaves out until they are released
+	claimed sync.Map
+	// firstSeen maps each tracking key to when the file was first tracked,
+	// zero for files found by Start. Stamps are taken under seenMu so that
+	// Watermark never misses a file stamped before it read the clock.
+	seenMu    sync.Mutex
+	firstSeen map[string]time.Time
+	// backend is the configured watch backend, empty for fsnotify without
+	// probing; see SetBackend
+	backend       string
+	pollInterval  time.Duration
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+	// probes maps the probe files written by probeEvents to the channel
+	// closed when their event arrives
+	probes    sync.Map
+	probeSeq  atomic.Int64
+	fallbacks atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+	// lost is closed when the event loop of the current source returns
+	// before Close, after which no event is seen; see Supervise
+	lost     chan struct{}
+	lostOnce sync.Once
+	// failpoint is a test hook run before each event is handled
+	failpoint func(event fsnotify.Event)
+	// now is the clock stability is measured on. lastCheck is when
+	// GetFilesToProcess last looked, expected every checkInterval, and
+	// verifying holds the files sampled after a clock jump; see settled.
+	now           func() time.Time
+	checkInterval time.Duration
+	checkMu       sync.Mutex
+	lastCheck     time.Time
+	verifying     sync.Map
+	// tombstones maps the folded paths MarkDone reported done to when
+	// events for them stop being ignored, tombstoneWindow after; see
+	// SetTombstoneWindow
+	tombstoneWindow time.Duration
+	tombstones      sync.Map
 }
 
+// New creates a watcher of watchPath. Paths are tracked in canonical form
+// below the root, absolute with symlinks resolved, however watchPath was
+// spelled.
 func New(method, watchPath string, stabilitySeconds int) (*Watcher, error) {
-	fsWatcher, err := fsnotify.NewWatcher()
+	watchPath, err := config.ResolvePath(watchPath)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newNotifySource()
 	if err != nil {
-		return nil, fmt.Errorf("create fsnotify watcher: %w", err)
+		return nil, err
 	}
 
 	w := &Watcher{
-		fsWatcher:        fsWatcher,
+		source:           source,
 		method:           method,
 		watchPath:        watchPath,
 		stabilitySeconds: stabilitySeconds,
+		caseProbe:        probeCaseInsensitive,
+		firstSeen:        make(map[string]time.Time),
+		pollInterval:     config.DefaultPollInterval,
+		probeTimeout:     ProbeTimeout,
+		done:             make(chan struct{}),
+		lost:             make(chan struct{}),
+		now:              time.Now,
+		checkInterval:    config.ProcessInterval,
 		completed:        nil,
 		modification:     nil,
 	}
@@ -69,61 +163,157 @@ func New(method, watchPath string, stabilitySeconds int) (*Watcher, error) {
 	switch method {
 	case config.MethodStabilityWindow:
 		w.modification = &sync.Map{}
-	case config.MethodSidecar:
+	case config.MethodSidecar, config.MethodRename:
 		w.completed = &sync.Map{}
 	default:
+		_ = source.Close()
 		return nil, fmt.Errorf("unknown watch method: %s", method)
 	}
 
 	return w, nil
 }
 
+// Start begins processing events and watches the input directory and every
+// directory below it. Files already present are tracked as if just created,
+// with stability measured from their on-disk modification time. With a
+// backend set, Start then probes whether file system events arrive. With
+// EnableIncrementalScan the directories below are walked in the background.
 func (w *Watcher) Start() error {
-	go w.eventLoop()
+	w.detectCase()
+	if w.backend == config.WatchBackendPoll {
+		w.sourceMu.Lock()
+		_ = w.source.Close()
+		w.source = newPollSource(w.pollInterval)
+		w.sourceMu.Unlock()
+	}
+	go w.runEventLoop(w.currentSource())
 
-	if err := w.fsWatcher.Add(w.watchPath); err != nil {
+	if err := w.addWatch(w.watchPath); err != nil {
 		return fmt.Errorf("add watch path %s: %w", w.watchPath, err)
 	}
 
+	if w.scan != nil {
+		w.startScan()
+	} else if err := w.walkTree(); err != nil {
+		return err
+	}
+	w.started.Store(true)
+
+	if w.backend == config.WatchBackendAuto || w.backend == config.WatchBackendFsnotify {
+		if err := w.verifyEvents(); err != nil {
+			return err
+		}
+		if w.probeInterval > 0 && w.Backend() == config.WatchBackendFsnotify {
+			go w.reprobe()
+		}
+	}
+
+	return nil
+}
+
+// walkTree watches every directory below the input directory and replays
+// the files inside them before returning
+func (w *Watcher) walkTree() error {
+	tally := &usageTally{}
+	err := filepath.WalkDir(w.watchPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			var info fs.FileInfo
+			if !w.isExcluded(path) {
+				info = w.replay(path, d)
+			}
+			tally.observe(w, path, d, info)
+			return nil
+		}
+		if path == w.watchPath {
+			return nil
+		}
+		if ShouldIgnoreFile(path) || w.skipExcludedDir(path, d) {
+			return fs.SkipDir
+		}
+		if err := w.addWatch(path); err != nil {
+			return fmt.Errorf("add watch path %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("watch subdirectories of %s: %w", w.watchPath, err)
+	}
+	w.recordUsage(tally)
+	w.seenBeforeStart()
 	return nil
 }
 
+// addTree watches a directory created after Start along with its
+// subdirectories. Files already inside were written before the watch existed,
+// so they are replayed as create events.
+func (w *Watcher) addTree(root string) {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if ShouldIgnoreFile(path) || w.skipExcludedDir(path, d) {
+				return fs.SkipDir
+			}
+			return w.addWatch(path)
+		}
+		if d.Type().IsRegular() && !w.isExcluded(path) {
+			w.replay(path, d)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to watch new directory", "path", root, "error", err)
+	}
+}
+
+// replay handles a file written before its directory was watched as a create
+// event dated by the file's modification time, and returns its info, nil
+// when it cannot be stat'ed
+func (w *Watcher) replay(path string, d fs.DirEntry) fs.FileInfo {
+	info, err := d.Info()
+	if err != nil {
+		w.handleEventAt(fsnotify.Event{Name: path, Op: fsnotify.Create}, w.now())
+		return nil
+	}
+	w.handleEventAt(fsnotify.Event{Name: path, Op: fsnotify.Create}, info.ModTime())
+	return info
+}
+
+// Close stops watching and the probes for events
 func (w *Watcher) Close() error {
-	return w.fsWatcher.Close()
+	w.sourceMu.Lock()
+	defer w.sourceMu.Unlock()
+	w.closeOnce.Do(func() { close(w.done) })
+	return w.source.Close()
 }
 
-func (w *Watcher) eventLoop() {
+// eventLoop handles the events of src in batches: every event already
+// queued when one arrives is drained and coalesced with it. It returns when
+// src is closed.
+func (w *Watcher) eventLoop(src eventSource) {
+	batch := make([]fsnotify.Event, 0, maxEventBatch)
+	open := make(map[string]int)
+	events := src.Events()
 	for {
 		select {
-		case event, ok := <-w.fsWatcher.Events:
+		case event, ok := <-events:
 			if !ok {
 				return
 			}
-
-			// Handle .ok sidecar files first (they signal completion of another file)
-			if w.completed != nil && strings.HasSuffix(event.Name, ".ok") {
-				if event.Has(fsnotify.Create) {
-					targetFile := strings.TrimSuffix(event.Name, ".ok")
-					slog.Debug("sidecar file detected", "sidecar", event.Name, "target", targetFile)
-					w.completed.Store(targetFile, true)
-				}
-				continue
-			}
-
-			// Skip files that should be ignored (hidden, temp, etc.)
-			if shouldIgnoreFile(event.Name) {
-				slog.Debug("ignoring file", "path", event.Name, "reason", "hidden or temp file")
-				continue
-			}
-
-			slog.Debug("file system event", "event", event.Op.String(), "path", event.Name)
-
-			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
-				if w.modification != nil {
-					w.modification.Store(event.Name, time.Now())
-				}
+			w.events.observeDepth(int64(1 + len(events)))
+			batch, ok = drain(append(batch[:0], event), events)
+			w.handleEvents(batch, open, w.now())
+			if !ok {
+				return
 			}
-		case err, ok := <-w.fsWatcher.Errors:
+		case err, ok := <-src.Errors():
 			if !ok {
 				return
 			}
@@ -132,15 +322,109 @@ func (w *Watcher) eventLoop() {
 	}
 }
 
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	w.handleEventAt(event, w.now())
+}
+
+// handleEventAt handles an event whose file was last modified at modTime
+func (w *Watcher) handleEventAt(event fsnotify.Event, modTime time.Time) {
+	route := w.Route(event.Name)
+	if route.Skip == SkipExcluded {
+		return
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			if !ShouldIgnoreFile(event.Name) {
+				w.addTree(event.Name)
+			}
+			return
+		}
+	}
+
+	switch route.Skip {
+	case SkipSidecar:
+		// A .ok sidecar signals the completion of another file
+		targetFile := w.onDiskName(strings.TrimSuffix(event.Name, config.SidecarSuffix))
+		if event.Has(fsnotify.Create) && !w.suppress(event, targetFile) {
+			slog.Debug("sidecar file detected", "sidecar", event.Name, "target", targetFile)
+			w.markCompleted(targetFile)
+		}
+		return
+	case SkipIgnored:
+		slog.Debug("ignoring file", "path", event.Name, "reason", "hidden or temp file")
+		return
+	case SkipBatch:
+		// Files of directory batches wait for the batch marker
+		w.batches.handle(event)
+		return
+	case SkipFileSet:
+		// Parts and markers of multi-part sets are grouped instead of tracked
+		w.fileSets.handle(event)
+		return
+	}
+
+	switch route.Method {
+	case config.MethodStabilityWindow:
+		if (event.Has(fsnotify.Create) || event.Has(fsnotify.Write)) && !w.suppress(event, event.Name) {
+			w.trackModification(event.Name, modTime)
+		}
+	case config.MethodRename:
+		// The file was renamed into place complete
+		if event.Has(fsnotify.Create) && !w.suppress(event, event.Name) {
+			key := w.trackingKey(w.completed, event.Name)
+			w.see(key)
+			w.completed.Store(key, true)
+		}
+	}
+}
+
+// trackModification records modTime as the last modification of path for
+// the stability window, as a reading of the monotonic clock; see instant.
+// An old time makes the file stable at once; a time from a producer whose
+// clock runs ahead would keep the file waiting until the clock catches up,
+// so beyond maxClockSkew tracking starts now instead.
+func (w *Watcher) trackModification(path string, modTime time.Time) {
+	now := w.now()
+	modTime = instant(modTime, now)
+	if delta := modTime.Sub(now); delta > maxClockSkew {
+		slog.Warn("modification time in the future, tracking from now",
+			"path", path,
+			"reason", "future_mtime",
+			"delta", delta,
+		)
+		modTime = now
+	}
+	key := w.trackingKey(w.modification, path)
+	w.see(key)
+	w.verifying.Delete(key)
+	w.modification.Store(key, modTime)
+}
+
+// GetFilesToProcess returns the tracked files ready to process and not
+// claimed. Stability-window files are ready once their window has elapsed
+// on the monotonic clock; after a clock jump they are re-verified on disk
+// first.
 func (w *Watcher) GetFilesToProcess() []string {
 	toProcess := make([]string, 0)
+	now := w.now()
+	jumped := w.clockJumped(now)
+	w.pruneTombstones(now)
 
 	if w.modification != nil {
 		w.modification.Range(func(key, value any) bool {
 			name := key.(string)
 			mtime := value.(time.Time)
+			_, stabilitySeconds := w.methodFor(name)
 
-			if mtime.Add(time.Duration(w.stabilitySeconds) * time.Second).Before(time.Now()) {
+			// A modification after now is from before the clock went back;
+			// its window restarts rather than waiting for the clock to
+			// catch up
+			if jumped && mtime.After(now) {
+				mtime = now
+				w.modification.Store(name, now)
+			}
+			if stable(mtime, stabilitySeconds, now) && !w.isClaimed(name) && w.settled(name, now, jumped) {
 				toProcess = append(toProcess, name)
 			}
 
@@ -153,7 +437,7 @@ func (w *Watcher) GetFilesToProcess() []string {
 			name := key.(string)
 			ok := value.(bool)
 
-			if ok {
+			if ok && !w.isClaimed(name) {
 				toProcess = append(toProcess, name)
 			}
 
@@ -164,11 +448,60 @@ func (w *Watcher) GetFilesToProcess() []string {
 	return toProcess
 }
 
+// Claim marks path as being processed and reports whether the caller owns
+// it: GetFilesToProcess leaves a claimed path out, so a pass overlapping
+// the one processing it does not pick it up again, and only one caller can
+// claim it at a time. The owner must Release the claim once done, whatever
+// the outcome.
+func (w *Watcher) Claim(path string) bool {
+	_, loaded := w.claimed.LoadOrStore(w.foldKey(w.canonical(path)), struct{}{})
+	return !loaded
+}
+
+// Release ends the claim on path, making it eligible again while tracked
+func (w *Watcher) Release(path string) {
+	w.claimed.Delete(w.foldKey(w.canonical(path)))
+}
+
+// isClaimed reports whether the tracked path is claimed in any spelling
+func (w *Watcher) isClaimed(path string) bool {
+	_, ok := w.claimed.Load(w.foldKey(path))
+	return ok
+}
+
 func (w *Watcher) RemoveFromTracking(path string) {
-	if w.completed != nil {
-		w.completed.Delete(path)
+	path = w.canonical(path)
+	for _, m := range []*sync.Map{w.completed, w.modification} {
+		if m == nil {
+			continue
+		}
+		if key, ok := w.trackedKey(m, path); ok {
+			m.Delete(key)
+			w.verifying.Delete(key)
+			w.forget(key)
+		}
 	}
-	if w.modification != nil {
-		w.modification.Delete(path)
+}
+
+// Root returns the canonical watched directory, the prefix of every tracked
+// path
+func (w *Watcher) Root() string {
+	return w.watchPath
+}
+
+// canonical returns path in the form paths are tracked in: absolute and
+// cleaned, with the symlinks of its directory resolved. Paths from fsnotify
+// already are; callers may spell them relative to the working directory or
+// through a symlink. The name itself is kept so a symlinked file is tracked
+// under its own name.
+func (w *Watcher) canonical(path string) string {
+	path = filepath.Clean(path)
+	if filepath.IsAbs(path) && config.Contains(w.watchPath, path) {
+		return path
+	}
+	dir, err := config.ResolvePath(filepath.Dir(path))
+	if err != nil {
+		return path
 	}
+	return filepath.Join(dir, filepath.Base(path))
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
 )
 
 func TestShouldIgnoreFile(t *testing.T) {
@@ -38,13 +39,22 @@ func TestShouldIgnoreFile(t *testing.T) {
 		// Edge cases
 		{"tmp in name not suffix", "/path/to/tmp_file.txt", false},
 		{"part in name not suffix", "/path/to/partial_data.csv", false},
+
+		// Trash of ingested sources
+		{"trashed file", "/input/.ingested-trash/data.csv", true},
+		{"nested trashed file", "/input/.ingested-trash/a/b/data.csv", true},
+		{"trash-like name", "/input/ingested-trash/data.csv", false},
+
+		// Receipts written back for producers
+		{"receipt", "/input/data.csv.receipt.json", true},
+		{"receipt in receipts dir", "/input/_receipts/data.csv.receipt.json", true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := shouldIgnoreFile(tt.path)
+			result := ShouldIgnoreFile(tt.path)
 			if result != tt.expected {
-				t.Errorf("shouldIgnoreFile(%q) = %v, want %v", tt.path, result, tt.expected)
+				t.Errorf("ShouldIgnoreFile(%q) = %v, want %v", tt.path, result, tt.expected)
 			}
 		})
 	}
@@ -305,6 +315,34 @@ func TestRemoveFromTracking(t *testing.T) {
 	}
 }
 
+func TestClaim_ExcludesFromFilesToProcess(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	testPath := filepath.Join(tmpDir, "test.txt")
+	w.modification.Store(testPath, time.Now().Add(-2*time.Second))
+
+	if !w.Claim(testPath) {
+		t.Fatal("Claim of an unclaimed path failed")
+	}
+	if w.Claim(testPath) {
+		t.Error("second Claim of a claimed path succeeded")
+	}
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Errorf("files to process while claimed = %v, want none", files)
+	}
+
+	w.Release(testPath)
+	if files := w.GetFilesToProcess(); len(files) != 1 || files[0] != testPath {
+		t.Errorf("files to process after release = %v, want %s", files, testPath)
+	}
+}
+
 func TestRemoveFromTracking_Sidecar(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -351,3 +389,163 @@ func TestClose(t *testing.T) {
 		t.Errorf("Close failed: %v", err)
 	}
 }
+
+func TestTrackModification_OldMtime(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	// rsync -a keeps the producer's mtime, which may be days old
+	testPath := filepath.Join(tmpDir, "old.txt")
+	w.trackModification(testPath, time.Now().Add(-72*time.Hour))
+
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != testPath {
+		t.Errorf("expected old file to be ready at once, got %v", files)
+	}
+}
+
+func TestTrackModification_FutureMtimeClamped(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	testPath := filepath.Join(tmpDir, "future.txt")
+	before := time.Now()
+	w.trackModification(testPath, before.Add(6*time.Hour))
+
+	value, ok := w.modification.Load(testPath)
+	if !ok {
+		t.Fatal("file should be tracked")
+	}
+	if tracked := value.(time.Time); tracked.After(time.Now()) || tracked.Before(before) {
+		t.Errorf("future mtime should be clamped to now, got %v", tracked)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if files := w.GetFilesToProcess(); len(files) != 1 {
+		t.Errorf("expected clamped file to be ready after the window, got %v", files)
+	}
+}
+
+func TestTrackModification_SmallSkewKept(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	testPath := filepath.Join(tmpDir, "skewed.txt")
+	modTime := time.Now().Add(maxClockSkew / 2)
+	w.trackModification(testPath, modTime)
+
+	value, _ := w.modification.Load(testPath)
+	if tracked := value.(time.Time); !tracked.Equal(modTime) {
+		t.Errorf("mtime within the skew allowance should be kept, got %v want %v", tracked, modTime)
+	}
+}
+
+func TestStart_SeedsExistingFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	oldPath := filepath.Join(tmpDir, "old.txt")
+	futurePath := filepath.Join(tmpDir, "future.txt")
+	for _, path := range []string{oldPath, futurePath} {
+		if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(oldPath, old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+	future := time.Now().Add(24 * time.Hour)
+	if err := os.Chtimes(futurePath, future, future); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	w, err := New(config.MethodStabilityWindow, tmpDir, 60)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	files := w.GetFilesToProcess()
+	if len(files) != 1 || files[0] != oldPath {
+		t.Errorf("expected only the old file to be ready, got %v", files)
+	}
+	value, ok := w.modification.Load(futurePath)
+	if !ok {
+		t.Fatal("future file should be tracked")
+	}
+	if tracked := value.(time.Time); tracked.After(time.Now()) {
+		t.Errorf("future mtime should be clamped, got %v", tracked)
+	}
+}
+
+func TestNew_CanonicalRoot(t *testing.T) {
+	base := t.TempDir()
+	input := filepath.Join(base, "input")
+	if err := os.Mkdir(input, 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.Symlink(input, filepath.Join(base, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	t.Chdir(base)
+	want, _ := filepath.EvalSymlinks(input)
+
+	for _, root := range []string{"input", "./input/", "link", input} {
+		w, err := New(config.MethodSidecar, root, 1)
+		if err != nil {
+			t.Fatalf("New(%s) failed: %v", root, err)
+		}
+		if w.Root() != want {
+			t.Errorf("Root() for %s = %s, want %s", root, w.Root(), want)
+		}
+		_ = w.Close()
+	}
+}
+
+func TestRemoveFromTracking_AnySpelling(t *testing.T) {
+	base := t.TempDir()
+	if err := os.Mkdir(filepath.Join(base, "input"), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.Symlink("input", filepath.Join(base, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	t.Chdir(base)
+
+	w, err := New(config.MethodSidecar, "input", 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	for _, spelling := range []string{"input/a.csv", "./input/../input/a.csv", "link/a.csv"} {
+		data := filepath.Join(w.Root(), "a.csv")
+		w.handleEvent(fsnotify.Event{Name: data + config.SidecarSuffix, Op: fsnotify.Create})
+		if !w.IsTracked(spelling) {
+			t.Errorf("IsTracked(%s) = false, want the tracked %s", spelling, data)
+		}
+		w.RemoveFromTracking(spelling)
+		if files := w.GetFilesToProcess(); len(files) != 0 {
+			t.Errorf("RemoveFromTracking(%s) left %v tracked", spelling, files)
+		}
+	}
+}
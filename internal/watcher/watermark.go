@@ -0,0 +1,94 @@
+package watcher
+
+import "time"
+
+// see stamps key as first tracked now, unless it already is
+func (w *Watcher) see(key string) {
+	w.seenMu.Lock()
+	defer w.seenMu.Unlock()
+	if _, ok := w.firstSeen[key]; !ok {
+		w.firstSeen[key] = time.Now()
+	}
+}
+
+// forget drops the stamp of key once it leaves tracking
+func (w *Watcher) forget(key string) {
+	w.seenMu.Lock()
+	delete(w.firstSeen, key)
+	w.seenMu.Unlock()
+	if w.scan != nil {
+		select {
+		case w.scan.room <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// seenBeforeStart clears the stamps of everything tracked so far. Files
+// found by Start may have been seen by an earlier run, whose watermark must
+// not be overtaken while they are pending.
+func (w *Watcher) seenBeforeStart() {
+	w.seenMu.Lock()
+	for key := range w.firstSeen {
+		w.firstSeen[key] = time.Time{}
+	}
+	w.seenMu.Unlock()
+
+	if w.fileSets != nil {
+		w.fileSets.mu.Lock()
+		for _, set := range w.fileSets.sets {
+			set.SeenAt = time.Time{}
+		}
+		w.fileSets.mu.Unlock()
+	}
+	if w.batches != nil {
+		w.batches.mu.Lock()
+		for _, b := range w.batches.ready {
+			b.SeenAt = time.Time{}
+		}
+		w.batches.mu.Unlock()
+	}
+}
+
+// Watermark returns the time before which every file, set and batch the
+// watcher tracked has left tracking, and how many are still tracked: when
+// the oldest of them was first seen, or now when there is none. Anything
+// found by Start and still tracked holds the watermark at zero, as does an
+// incremental scan until it finishes.
+func (w *Watcher) Watermark() (time.Time, int) {
+	// The clock is read before any lock, so a stamp taken after a lock is
+	// released is later than the watermark
+	mark := time.Now()
+	pending := 0
+	hold := func(seen time.Time) {
+		pending++
+		if seen.Before(mark) {
+			mark = seen
+		}
+	}
+
+	w.seenMu.Lock()
+	for _, seen := range w.firstSeen {
+		hold(seen)
+	}
+	w.seenMu.Unlock()
+
+	if w.fileSets != nil {
+		w.fileSets.mu.Lock()
+		for _, set := range w.fileSets.sets {
+			hold(set.SeenAt)
+		}
+		w.fileSets.mu.Unlock()
+	}
+	if w.batches != nil {
+		w.batches.mu.Lock()
+		for _, b := range w.batches.ready {
+			hold(b.SeenAt)
+		}
+		w.batches.mu.Unlock()
+	}
+	if w.scanning() {
+		mark = time.Time{}
+	}
+	return mark, pending
+}
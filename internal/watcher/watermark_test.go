@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatermark_OldestTrackedFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	root := w.Root()
+	first := filepath.Join(root, "a.csv")
+	second := filepath.Join(root, "b.csv")
+
+	before := time.Now()
+	w.handleEvent(fsnotify.Event{Name: first, Op: fsnotify.Create})
+	time.Sleep(20 * time.Millisecond)
+	between := time.Now()
+	w.handleEvent(fsnotify.Event{Name: second, Op: fsnotify.Create})
+	// Writes do not restamp a tracked file
+	w.handleEvent(fsnotify.Event{Name: first, Op: fsnotify.Write})
+
+	mark, pending := w.Watermark()
+	if pending != 2 {
+		t.Errorf("pending = %d, want 2", pending)
+	}
+	if mark.Before(before) || !mark.Before(between) {
+		t.Errorf("watermark = %v, want when a.csv was first seen, in [%v, %v)", mark, before, between)
+	}
+
+	w.RemoveFromTracking(first)
+	mark, pending = w.Watermark()
+	if pending != 1 || mark.Before(between) {
+		t.Errorf("after a.csv left: watermark = %v with %d pending, want b.csv's, after %v", mark, pending, between)
+	}
+
+	w.RemoveFromTracking(second)
+	beforeIdle := time.Now()
+	mark, pending = w.Watermark()
+	if pending != 0 || mark.Before(beforeIdle) {
+		t.Errorf("idle: watermark = %v with %d pending, want now", mark, pending)
+	}
+}
+
+func TestWatermark_FilesFoundAtStartHoldAtZero(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "old.csv"), []byte("data"), 0o644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	w, err := New(config.MethodStabilityWindow, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if mark, pending := w.Watermark(); !mark.IsZero() || pending != 1 {
+		t.Errorf("Watermark() = %v, %d, want zero held by the file found at startup", mark, pending)
+	}
+
+	w.RemoveFromTracking(filepath.Join(w.Root(), "old.csv"))
+	if mark, _ := w.Watermark(); mark.IsZero() {
+		t.Error("watermark still zero after the file found at startup left tracking")
+	}
+}
+
+func TestWatermark_FileSetsAndBatches(t *testing.T) {
+	tmpDir := t.TempDir()
+	w, err := New(config.MethodRename, tmpDir, 1)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := w.EnableFileSets(`^(.+)\.(\d+)$`); err != nil {
+		t.Fatalf("EnableFileSets failed: %v", err)
+	}
+	if err := w.EnableBatches("batch_*", "_SUCCESS"); err != nil {
+		t.Fatalf("EnableBatches failed: %v", err)
+	}
+	root := w.Root()
+	if err := os.Mkdir(filepath.Join(root, "batch_1"), 0o755); err != nil {
+		t.Fatalf("failed to create batch directory: %v", err)
+	}
+
+	before := time.Now()
+	w.handleEvent(fsnotify.Event{Name: filepath.Join(root, "file.csv.001"), Op: fsnotify.Create})
+	time.Sleep(20 * time.Millisecond)
+	w.handleEvent(fsnotify.Event{Name: filepath.Join(root, "batch_1", "_SUCCESS"), Op: fsnotify.Create})
+
+	mark, pending := w.Watermark()
+	if pending != 2 {
+		t.Errorf("pending = %d, want the set and the batch", pending)
+	}
+	if mark.Before(before) || time.Since(mark) < 20*time.Millisecond {
+		t.Errorf("watermark = %v, want when the first part was seen", mark)
+	}
+
+	w.RemoveFileSet(filepath.Join(root, "file.csv"))
+	w.RemoveBatch(filepath.Join(root, "batch_1"))
+	if _, pending := w.Watermark(); pending != 0 {
+		t.Errorf("pending = %d after removing the set and the batch, want 0", pending)
+	}
+}
@@ -2,58 +2,259 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/1995parham-learning/atomic-ingestor/internal/admin"
 	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/dbguard"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/digest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/inputspace"
+	"github.com/1995parham-learning/atomic-ingestor/internal/janitor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/logdedup"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
 	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rename"
+	"github.com/1995parham-learning/atomic-ingestor/internal/report"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rules"
+	"github.com/1995parham-learning/atomic-ingestor/internal/sla"
 	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/supervisor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
 	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func main() {
+	// Dispatch subcommands before parsing daemon flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "adopt":
+			runAdopt(os.Args[2:])
+			return
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "migrate-status":
+			runMigrateStatus(os.Args[2:])
+			return
+		case "migrate-to":
+			runMigrateTo(os.Args[2:])
+			return
+		case "test-rename":
+			runTestRename(os.Args[2:])
+			return
+		case "digest":
+			runDigest(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "acknowledge":
+			runAcknowledge(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "config-history":
+			runConfigHistory(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "force-ingest":
+			runForceIngest(os.Args[2:])
+			return
+		case "sla-status":
+			runSLAStatus(os.Args[2:])
+			return
+		case "state":
+			runState(os.Args[2:])
+			return
+		case "rescan":
+			runRescan(os.Args[2:])
+			return
+		case "janitor":
+			runJanitor(os.Args[2:])
+			return
+		case "sweep-trash":
+			runSweepTrash(os.Args[2:])
+			return
+		case "slow-files":
+			runSlowFiles(os.Args[2:])
+			return
+		case "notify-replay":
+			runNotifyReplay(os.Args[2:])
+			return
+		case "manifest":
+			runManifest(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse command-line flags
 	cfg := &config.Config{}
 
 	flag.StringVar(&cfg.Path, "input", config.DefaultInputPath, "Input directory to monitor")
 	flag.StringVar(&cfg.Destination, "warehouse", config.DefaultWarehousePath, "Warehouse directory for ingested files")
 	flag.StringVar(&cfg.ManifestsPath, "manifests", config.DefaultManifestsPath, "Manifests directory")
-	flag.StringVar(&cfg.Method, "mode", config.DefaultMethod, "Completion detection mode (stability_window or sidecar)")
+	flag.StringVar(&cfg.Method, "mode", config.DefaultMethod, "Completion detection mode (stability_window, sidecar or rename)")
 	flag.IntVar(&cfg.StabilitySeconds, "stability-seconds", config.DefaultStabilitySeconds, "Stability window duration in seconds")
 	flag.StringVar(&cfg.StatePath, "state-path", config.DefaultStatePath, "Path to state database file")
 	flag.StringVar(&cfg.LogLevel, "log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
 	flag.IntVar(&cfg.Concurrency, "concurrency", config.DefaultConcurrency, "Number of concurrent workers")
+	flag.IntVar(&cfg.HashWorkers, "hash-workers", 0, "Number of workers hashing files (0 uses -concurrency)")
+	flag.IntVar(&cfg.CopyWorkers, "copy-workers", 0, "Number of workers copying files into the warehouse (0 uses -concurrency)")
+	flag.IntVar(&cfg.TenantMaxWorkers, "tenant-max-workers", 0, "Maximum files of one tenant (top-level input directory) processed at a time; ready files are interleaved across tenants (0 unlimited)")
 	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Dry run mode (do not actually move files)")
+	flag.StringVar(&cfg.ExcludeDirs, "exclude-dirs", "", "Directories below the input directory never watched or scanned, as globs separated by commas, e.g. \"work,**/.snapshot,**/tmp\"")
+	flag.IntVar(&cfg.ScanMaxTracked, "scan-max-tracked", 0, "Scan the input directory in the background at startup, processing files as they are found, with at most this many tracked at a time; the scan resumes where it stopped after a restart (0 scans the whole tree first)")
+	flag.StringVar(&cfg.Priorities, "priority", "", "Priority classes of ready files as name:pattern=priority separated by commas, e.g. \"urgent:*.xml=10,bulk:*.mp4=1\"; higher priorities are dispatched first and patterns without a slash match the file name")
+	flag.Float64Var(&cfg.PriorityReserve, "priority-reserve", config.DefaultPriorityReserve, "Fraction of the pipeline's slots kept free of files below the highest priority present, so bulk traffic cannot occupy every worker")
+	flag.BoolVar(&cfg.URLLists, "url-lists", false, "Download the files listed in ready *"+config.URLListSuffix+" files, verify their checksums and ingest them in place of the list")
+	flag.IntVar(&cfg.URLConcurrency, "url-concurrency", config.DefaultURLConcurrency, "Maximum downloads of one URL list running at a time")
+	flag.Int64Var(&cfg.URLMaxBytes, "url-max-bytes", config.DefaultURLMaxBytes, "Maximum size in bytes of a file downloaded from a URL list (0 unlimited)")
+	flag.DurationVar(&cfg.URLTimeout, "url-timeout", config.DefaultURLTimeout, "How long a download may go without receiving data before it is retried")
+	flag.IntVar(&cfg.URLRetries, "url-retries", config.DefaultURLRetries, "Times an interrupted download is resumed before the URL fails")
+	flag.BoolVar(&cfg.URLAllowHTTP, "url-allow-http", false, "Also download plain http URLs from URL lists; only https is downloaded otherwise")
+	flag.Float64Var(&cfg.ShrinkPercent, "shrink-hold-percent", 0, "Hold a file arriving more than this percentage smaller than the file last ingested from its path and raise a suspicious_shrink alert, until it is forced in with force-ingest or the admin api (0 disables)")
+	flag.DurationVar(&cfg.ShrinkRelease, "shrink-release-after", 0, "Ingest a file held for shrinking once it was held this long (0 holds it until forced in)")
+	flag.StringVar(&cfg.SyncPolicy, "sync-policy", config.DefaultSyncPolicy, "Destination fsync policy for copies (always, batch, never)")
+	flag.StringVar(&cfg.QuarantinePath, "quarantine", config.DefaultQuarantinePath, "Quarantine directory for files that cannot be ingested")
+	flag.IntVar(&cfg.MaxNameBytes, "max-name-bytes", config.DefaultMaxNameBytes, "Maximum warehouse file name length in bytes (0 disables)")
+	flag.IntVar(&cfg.MaxPathBytes, "max-path-bytes", config.DefaultMaxPathBytes, "Maximum warehouse path length in bytes (0 disables)")
+	flag.BoolVar(&cfg.ShortenPaths, "shorten-long-paths", true, "Shorten over-long destination names instead of quarantining them")
+	flag.StringVar(&cfg.RulesPath, "rules", "", "YAML file with tagging rules applied to ingested files")
+	sourceDelete := flag.Bool("source-delete", true, "Remove sources after ingestion (false leaves them in place and relies on dedup)")
+	keepSource := flag.Bool("keep-source", false, "Copy sources into the warehouse and leave them in place, relying on dedup; the same as -source-delete=false")
+	flag.DurationVar(&cfg.SourceGrace, "source-grace", 0, "Keep ingested sources in the input trash directory for this long before deleting them")
+	flag.StringVar(&cfg.PartPattern, "part-pattern", "", "Regexp capturing stem and index of multi-part files, e.g. ^(.+)\\.(\\d+)$ (empty disables)")
+	flag.DurationVar(&cfg.PartTimeout, "part-timeout", config.DefaultPartTimeout, "How long to wait for missing parts after the completion marker before quarantining the set")
+	flag.StringVar(&cfg.ConfigPath, "config", "", "YAML configuration file (per-pattern completion methods)")
+	flag.StringVar(&cfg.AdminAddr, "admin-addr", "", "Listen address of the admin API and status page at /ui, e.g. :9090 (empty disables)")
+	flag.StringVar(&cfg.AdminToken, "admin-token", os.Getenv("ATOMIC_INGESTOR_ADMIN_TOKEN"), "Bearer token required by mutating admin endpoints (default from ATOMIC_INGESTOR_ADMIN_TOKEN)")
+	flag.StringVar(&cfg.ManifestFormat, "manifest-format", config.DefaultManifestFormat, "Manifest file format (jsonl or parquet)")
+	flag.StringVar(&cfg.ManifestPeriod, "manifest-period", config.DefaultManifestPeriod, "Period covered by each parquet manifest file (hourly or daily)")
+	flag.BoolVar(&cfg.WriteReceipts, "write-receipts", false, "Write a <name>.receipt.json next to each ingested or rejected source for its producer")
+	flag.StringVar(&cfg.ReceiptsDir, "receipts-dir", "", "Subdirectory of each source directory to write receipts into (empty writes them next to the source)")
+	flag.DurationVar(&cfg.ReceiptRetention, "receipt-retention", config.DefaultReceiptRetention, "Delete receipts older than this (0 keeps them)")
+	flag.StringVar(&cfg.BatchDirs, "batch-dirs", "", "Glob of input directories ingested all-or-nothing once their marker appears, e.g. batch_* (empty disables)")
+	flag.StringVar(&cfg.BatchMarker, "batch-marker", config.DefaultBatchMarker, "Name (or name glob) of the file completing a directory batch")
+	flag.BoolVar(&cfg.BatchTar, "batch-tar", false, "Ingest each directory batch as a single deterministic tar named after the directory")
+	flag.StringVar(&cfg.BatchTarSymlinks, "batch-tar-symlinks", config.SymlinksSkip, "Symlinks inside a batch tar: skip leaves them out, follow archives the files they point to")
+	flag.BoolVar(&cfg.BatchTarMtimes, "batch-tar-mtimes", false, "Keep member modification times in batch tars (re-sent batches then no longer deduplicate)")
+	flag.DurationVar(&cfg.LogDedupWindow, "log-dedup-window", config.DefaultLogDedupWindow, "Log repeated warnings and errors once per window with a repeat_count (0 disables)")
+	flag.StringVar(&cfg.VersionOnNameConflict, "version-on-name-conflict", "", "Keep every file arriving under an already ingested name as a new version named by timestamp or sequence (empty disables)")
+	flag.BoolVar(&cfg.VersionLatestLink, "version-latest-link", false, "With versioning, store every version suffixed and keep a symlink with the plain name pointing at the newest")
+	flag.BoolVar(&cfg.ParanoidDedup, "paranoid-dedup", false, "Confirm hash matches by comparing with the warehouse copy byte by byte; on mismatch ingest under a new name and mark the original suspect")
+	flag.Int64Var(&cfg.ParanoidDedupRate, "paranoid-dedup-rate", 0, "Maximum bytes per second read from each file by paranoid dedup comparisons (0 unlimited)")
+	flag.BoolVar(&cfg.CopyFirst, "copy-first", false, "Copy each source into the warehouse staging area first and hash the copy, for network mounts whose files may change between reads (needs free space for the largest file in flight)")
+	flag.StringVar(&cfg.UnicodeNormalization, "unicode-normalization", config.DefaultNormalization, "Unicode normalization of warehouse and manifest names (nfc or none)")
+	flag.StringVar(&cfg.IdempotencyKeyPattern, "idempotency-key-pattern", "", "Regexp extracting a producer idempotency key from file names, from its key group or first group; files with an ingested key are duplicates (empty disables)")
+	flag.StringVar(&cfg.IdempotencyKeyField, "idempotency-key-field", "", "Sidecar JSON field holding a producer idempotency key, used when the pattern does not match (empty disables)")
+	flag.BoolVar(&cfg.SelfTest, "self-test", false, "Ingest a probe file end to end, print a JSON report of each stage to stdout and exit 0 on success or 1 on failure")
+	flag.StringVar(&cfg.ManifestRedaction, "manifest-redaction", config.ManifestRedactionNone, "Names written to manifests when the config file sets redaction: none keeps full names, pseudonym writes the pseudonyms logs get")
+	flag.StringVar(&cfg.SizeAccounting, "size-accounting", config.SizeAccountingLogical, "Size of a file counted by space checks: logical bytes, or allocated bytes, which is smaller for sparse files")
+	flag.StringVar(&cfg.ManifestSink, "manifest-sink", config.ManifestSinkFile, "Where manifest entries are written: file to the manifests directory, db to the state database, or both")
+	flag.BoolVar(&cfg.ManifestStrictOrder, "manifest-strict-order", false, "Write manifest entries in sequence order, holding back an entry until the entries stamped before it are written")
+	flag.StringVar(&cfg.WarehouseSharding, "warehouse-sharding", "", "Spread warehouse files over subdirectories: count moves new files into shard-NN directories once a directory holds -shard-fanout entries, hash places files under the first two hex characters of their hash (empty disables)")
+	flag.IntVar(&cfg.ShardFanout, "shard-fanout", config.DefaultShardFanout, "Most entries a warehouse directory holds with count sharding")
+	flag.StringVar(&cfg.NotifyURL, "notify-url", "", "Webhook URL receiving a JSON POST for every file outcome and pause, retried from a durable outbox until accepted (empty disables)")
+	flag.IntVar(&cfg.OutboxLimit, "outbox-limit", config.DefaultOutboxLimit, "Most undelivered notifications kept before the oldest are dropped (0 unlimited)")
+	flag.BoolVar(&cfg.CreateInput, "create-input", false, "Create the input directory at startup when it does not exist")
+	cfg.DirMode = config.DefaultDirMode
+	flag.Func("dir-mode", "Octal mode of directories created at startup (default 0755)", func(s string) error {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode > 0o777 {
+			return fmt.Errorf("invalid directory mode %q", s)
+		}
+		cfg.DirMode = fs.FileMode(mode)
+		return nil
+	})
+	flag.BoolVar(&cfg.CheckConfig, "check-config", false, "Validate the configuration, create and probe the directories, then exit")
+	flag.DurationVar(&cfg.ClockTolerance, "clock-tolerance", config.DefaultClockTolerance, "How far the clock may fall behind the latest recorded ProcessedAt before it counts as a regression")
+	flag.StringVar(&cfg.OnClockRegression, "on-clock-regression", config.ClockRegressionContinue, "Reaction to a clock regression: continue, ordering entries by their sequence number, or hold processing until the clock catches up")
+	flag.BoolVar(&cfg.FinalizeDays, "finalize-days", false, "Write a _COMPLETE marker with counts and a checksum into each day of manifests once it is over; later entries of the day go to its late folder")
+	flag.DurationVar(&cfg.FinalizeGrace, "finalize-grace", config.DefaultFinalizeGrace, "How long after midnight a day of manifests stays open for entries still being processed")
+	flag.StringVar(&cfg.WatchBackend, "watch-backend", config.WatchBackendAuto, "How file system events are received: auto (fsnotify, falling back to polling when a probe raises no event), fsnotify or poll")
+	flag.DurationVar(&cfg.PollInterval, "poll-interval", config.DefaultPollInterval, "How often the polling backend scans the input directory")
+	flag.DurationVar(&cfg.WatchProbeInterval, "watch-probe-interval", config.DefaultProbeInterval, "How often fsnotify is probed for events after startup, to notice a remount that stops them (0 probes only at startup)")
+	flag.DurationVar(&cfg.TombstoneWindow, "tombstone-window", config.DefaultTombstoneWindow, "How long events for a file are ignored once it was ingested, rejected as a duplicate or quarantined, such as a sidecar touched again (0 ignores none)")
+	flag.BoolVar(&cfg.VerifyDuplicates, "verify-duplicates", true, "Check that the warehouse copy of a duplicate's original still exists; when it is missing ingest the duplicate to restore it and mark the original missing_restored")
+	flag.DurationVar(&cfg.DuplicateCheckCache, "duplicate-check-cache", config.DefaultDuplicateCache, "How long a warehouse copy found present by -verify-duplicates is trusted without checking again (0 checks every duplicate)")
+	flag.BoolVar(&cfg.DestinationProbe, "destination-probe", true, "Check that the warehouse accepts writes before dispatching each pass, holding its files while it does not")
+	flag.DurationVar(&cfg.DestinationProbeCache, "destination-probe-cache", config.DefaultProbeCache, "How long a successful warehouse probe is trusted before the next pass probes again")
+	flag.DurationVar(&cfg.DestinationProbeMaxBackoff, "destination-probe-max-backoff", config.DefaultProbeMaxBackoff, "Longest delay between the probes of an unhealthy warehouse, doubling from one second")
+	flag.DurationVar(&cfg.DigestInterval, "digest-interval", 0, "How often to send a duplicate digest of the past interval to -notify-url, e.g. 168h for weekly (0 disables)")
+	flag.IntVar(&cfg.DigestTop, "digest-top", config.DefaultDigestTop, "How many originals and sources the scheduled duplicate digest lists")
+	flag.DurationVar(&cfg.AttemptRetention, "attempt-retention", config.DefaultAttemptRetention, "Delete the attempt history of files older than this (0 keeps it)")
+	flag.BoolVar(&cfg.DBRecovery, "db-recovery", false, "On a corrupt state database, move it aside, rebuild it from the manifest files and resume, instead of stopping until an operator acts")
+	flag.StringVar(&cfg.AuditLog, "audit-log", defaultMaintenanceAuditLog, "JSON Lines file destructive actions of the daemon, such as a database recovery, are recorded in")
+	flag.DurationVar(&cfg.InputSpaceInterval, "input-space-interval", config.DefaultInputSpaceCheck, "How often to check the free space and inodes of the input filesystem (0 disables)")
+	flag.Float64Var(&cfg.InputMinFreePercent, "input-min-free-percent", config.DefaultInputMinFree, "Alert when the free space of the input filesystem drops below this percentage (0 disables)")
+	flag.Float64Var(&cfg.InputMinFreeInodesPercent, "input-min-free-inodes-percent", config.DefaultInputMinFree, "Alert when the free inodes of the input filesystem drop below this percentage (0 disables)")
+	flag.Float64Var(&cfg.TimingSampleRate, "timing-sample-rate", config.DefaultTimingSampleRate, "Fraction of files whose per-stage timing breakdown is recorded for slow-files, from 0 (none) to 1 (every file)")
+	flag.BoolVar(&cfg.StrictStartup, "strict-startup", false, "Refuse to start, exiting with code 3, while inconsistencies left by an earlier run are not acknowledged with the acknowledge command, instead of repairing them")
+	flag.DurationVar(&cfg.StrictManifestWindow, "strict-manifest-window", config.DefaultStrictWindow, "How far back -strict-startup compares ingested files with the JSON Lines manifest (0 skips the comparison)")
+	flag.BoolVar(&cfg.ForceAdoptWarehouse, "force-adopt-warehouse", false, "Start even though the warehouse marker was written with another layout or manifests directory, taking the warehouse over")
+	flag.DurationVar(&cfg.DedupWindow, "dedup-window", 0, "Only skip content as a duplicate when it was recorded within this long; older content is ingested again and its record marked superseded (0 skips duplicates forever)")
+	flag.StringVar(&cfg.ReportSchedule, "report-schedule", "", "Cron expression (minute hour day-of-month month day-of-week) at which to deliver the ingestion report of the period since the previous firing, e.g. \"0 7 * * *\" (empty disables)")
+	flag.StringVar(&cfg.ReportTimezone, "report-timezone", "UTC", "Time zone the report schedule is evaluated in, e.g. Europe/Berlin")
+	flag.StringVar(&cfg.ReportDir, "report-dir", "", "Directory scheduled reports are written to as JSON and a text table (empty only sends them to -notify-url)")
+	flag.IntVar(&cfg.ReportTop, "report-top", config.DefaultReportTop, "How many sources the ingestion report lists")
+	flag.BoolVar(&cfg.AllowHardlink, "allow-hardlink", false, "Hard-link kept sources into the warehouse when on the same filesystem; the copy then shares the source's data, so in-place writes to the source change it")
+	flag.BoolVar(&cfg.SetXattrs, "set-xattrs", false, "Record the sha256, ingest id, processing time and source of each ingested file in user.ingestor.* extended attributes of its warehouse copy")
+	flag.BoolVar(&cfg.ImmutableWarehouse, "immutable-warehouse", false, "Make ingested files read-only so in-place writes to them, or to sources hard-linked to them, fail")
+	flag.Float64Var(&cfg.StormRatio, "storm-ratio", 0, "Duplicate ratio of a source within the storm window at which it is in a resend storm: files recorded under the same path and size are rejected without hashing and duplicates are summarized (0 disables)")
+	flag.DurationVar(&cfg.StormWindow, "storm-window", config.DefaultStormWindow, "Sliding window the duplicate ratio of a source is measured over")
+	flag.IntVar(&cfg.StormMinFiles, "storm-min-files", config.DefaultStormMinFiles, "Files a source must send within the storm window before its duplicate ratio counts")
+	flag.DurationVar(&cfg.StormSummaryInterval, "storm-summary-interval", config.DefaultStormSummary, "How often the duplicates of a source in a resend storm are written as one summary record")
+	flag.BoolVar(&cfg.StormPause, "storm-pause", false, "Pause a source entering a resend storm until it is resumed through the admin api")
+	flag.BoolVar(&cfg.ConfigDriftAlert, "config-drift-alert", false, "Send an alert to -notify-url when the configuration changed since the previous run")
+	flag.Int64Var(&cfg.DirectThreshold, "direct-io-threshold", config.DefaultDirectThreshold, "Copy files of at least this many bytes without polluting the page cache (0 disables)")
 
 	flag.Parse()
+	cfg.KeepSource = *keepSource || !*sourceDelete
 
-	// Parse log level
-	var logLevel slog.Level
-	switch cfg.LogLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		slog.Error("invalid log level", "level", cfg.LogLevel)
-		os.Exit(1)
+	// Keep stdout clean for the self-test report
+	logOutput := os.Stdout
+	if cfg.SelfTest {
+		logOutput = os.Stderr
 	}
+	setupLogger(logOutput, cfg.LogLevel)
 
-	// Initialize structured logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	}))
-	slog.SetDefault(logger)
+	// Systemic failures repeat the same error on every tick; collapse them
+	if cfg.LogDedupWindow > 0 {
+		dedup := logdedup.New(slog.Default().Handler(), cfg.LogDedupWindow)
+		slog.SetDefault(slog.New(dedup))
+		defer dedup.Close()
+	}
 
 	slog.Info("starting atomic ingestor",
 		"input", cfg.Path,
@@ -64,25 +265,468 @@ func main() {
 		"state_path", cfg.StatePath,
 		"log_level", cfg.LogLevel,
 		"concurrency", cfg.Concurrency,
+		"hash_workers", cfg.HashWorkers,
+		"copy_workers", cfg.CopyWorkers,
+		"tenant_max_workers", cfg.TenantMaxWorkers,
+		"priority", cfg.Priorities,
+		"exclude_dirs", cfg.ExcludeDirs,
+		"scan_max_tracked", cfg.ScanMaxTracked,
+		"priority_reserve", cfg.PriorityReserve,
+		"url_lists", cfg.URLLists,
+		"url_concurrency", cfg.URLConcurrency,
+		"url_max_bytes", cfg.URLMaxBytes,
+		"url_timeout", cfg.URLTimeout,
+		"url_retries", cfg.URLRetries,
+		"url_allow_http", cfg.URLAllowHTTP,
+		"shrink_hold_percent", cfg.ShrinkPercent,
+		"shrink_release_after", cfg.ShrinkRelease,
 		"dry_run", cfg.DryRun,
+		"sync_policy", cfg.SyncPolicy,
+		"direct_io_threshold", cfg.DirectThreshold,
+		"quarantine", cfg.QuarantinePath,
+		"max_name_bytes", cfg.MaxNameBytes,
+		"max_path_bytes", cfg.MaxPathBytes,
+		"shorten_long_paths", cfg.ShortenPaths,
+		"rules", cfg.RulesPath,
+		"keep_source", cfg.KeepSource,
+		"source_grace", cfg.SourceGrace,
+		"part_pattern", cfg.PartPattern,
+		"part_timeout", cfg.PartTimeout,
+		"config", cfg.ConfigPath,
+		"admin_addr", cfg.AdminAddr,
+		"manifest_format", cfg.ManifestFormat,
+		"manifest_period", cfg.ManifestPeriod,
+		"write_receipts", cfg.WriteReceipts,
+		"receipts_dir", cfg.ReceiptsDir,
+		"receipt_retention", cfg.ReceiptRetention,
+		"batch_dirs", cfg.BatchDirs,
+		"batch_marker", cfg.BatchMarker,
+		"batch_tar", cfg.BatchTar,
+		"batch_tar_symlinks", cfg.BatchTarSymlinks,
+		"batch_tar_mtimes", cfg.BatchTarMtimes,
+		"log_dedup_window", cfg.LogDedupWindow,
+		"version_on_name_conflict", cfg.VersionOnNameConflict,
+		"version_latest_link", cfg.VersionLatestLink,
+		"paranoid_dedup", cfg.ParanoidDedup,
+		"paranoid_dedup_rate", cfg.ParanoidDedupRate,
+		"unicode_normalization", cfg.UnicodeNormalization,
+		"copy_first", cfg.CopyFirst,
+		"idempotency_key_pattern", cfg.IdempotencyKeyPattern,
+		"idempotency_key_field", cfg.IdempotencyKeyField,
+		"self_test", cfg.SelfTest,
+		"manifest_redaction", cfg.ManifestRedaction,
+		"size_accounting", cfg.SizeAccounting,
+		"manifest_sink", cfg.ManifestSink,
+		"manifest_strict_order", cfg.ManifestStrictOrder,
+		"warehouse_sharding", cfg.WarehouseSharding,
+		"shard_fanout", cfg.ShardFanout,
+		"notify", cfg.NotifyURL != "",
+		"outbox_limit", cfg.OutboxLimit,
+		"create_input", cfg.CreateInput,
+		"dir_mode", fmt.Sprintf("%04o", cfg.DirMode),
+		"clock_tolerance", cfg.ClockTolerance,
+		"on_clock_regression", cfg.OnClockRegression,
+		"finalize_days", cfg.FinalizeDays,
+		"finalize_grace", cfg.FinalizeGrace,
+		"watch_backend", cfg.WatchBackend,
+		"poll_interval", cfg.PollInterval,
+		"watch_probe_interval", cfg.WatchProbeInterval,
+		"tombstone_window", cfg.TombstoneWindow,
+		"verify_duplicates", cfg.VerifyDuplicates,
+		"duplicate_check_cache", cfg.DuplicateCheckCache,
+		"destination_probe", cfg.DestinationProbe,
+		"destination_probe_cache", cfg.DestinationProbeCache,
+		"destination_probe_max_backoff", cfg.DestinationProbeMaxBackoff,
+		"digest_interval", cfg.DigestInterval,
+		"digest_top", cfg.DigestTop,
+		"attempt_retention", cfg.AttemptRetention,
+		"timing_sample_rate", cfg.TimingSampleRate,
+		"db_recovery", cfg.DBRecovery,
+		"audit_log", cfg.AuditLog,
+		"input_space_interval", cfg.InputSpaceInterval,
+		"input_min_free_percent", cfg.InputMinFreePercent,
+		"input_min_free_inodes_percent", cfg.InputMinFreeInodesPercent,
+		"strict_startup", cfg.StrictStartup,
+		"strict_manifest_window", cfg.StrictManifestWindow,
+		"force_adopt_warehouse", cfg.ForceAdoptWarehouse,
+		"dedup_window", cfg.DedupWindow,
+		"report_schedule", cfg.ReportSchedule,
+		"report_timezone", cfg.ReportTimezone,
+		"report_dir", cfg.ReportDir,
+		"report_top", cfg.ReportTop,
+		"allow_hardlink", cfg.AllowHardlink,
+		"immutable_warehouse", cfg.ImmutableWarehouse,
+		"set_xattrs", cfg.SetXattrs,
+		"storm_ratio", cfg.StormRatio,
+		"storm_window", cfg.StormWindow,
+		"storm_min_files", cfg.StormMinFiles,
+		"storm_summary_interval", cfg.StormSummaryInterval,
+		"storm_pause", cfg.StormPause,
+		"config_drift_alert", cfg.ConfigDriftAlert,
 	)
 
 	// Validate configuration
-	if cfg.Method != config.MethodStabilityWindow && cfg.Method != config.MethodSidecar {
+	if err := cfg.Validate(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	// Source paths are tracked, stored and made relative against one
+	// spelling of the input directory, however -input was given
+	input, err := config.ResolvePath(cfg.Path)
+	if err != nil {
+		slog.Error("invalid input path", "input", cfg.Path, "error", err)
+		os.Exit(1)
+	}
+	cfg.Path = input
+	switch cfg.Method {
+	case config.MethodStabilityWindow, config.MethodSidecar, config.MethodRename:
+	default:
 		slog.Error("invalid method name", "method", cfg.Method)
 		os.Exit(1)
 	}
+	if cfg.MaxNameBytes != 0 && cfg.MaxNameBytes < config.MinNameBytes {
+		slog.Error("max name bytes too small", "max_name_bytes", cfg.MaxNameBytes, "min", config.MinNameBytes)
+		os.Exit(1)
+	}
+	if cfg.KeepSource && cfg.SourceGrace > 0 {
+		slog.Error("source grace requires source deletion", "source_grace", cfg.SourceGrace)
+		os.Exit(1)
+	}
+	if cfg.ManifestFormat != manifest.FormatJSONL && cfg.ManifestFormat != manifest.FormatParquet {
+		slog.Error("invalid manifest format", "manifest_format", cfg.ManifestFormat)
+		os.Exit(1)
+	}
+	switch cfg.ManifestSink {
+	case config.ManifestSinkFile, config.ManifestSinkBoth:
+	case config.ManifestSinkDB:
+		if cfg.ManifestFormat == manifest.FormatParquet {
+			slog.Error("parquet manifests require a file sink", "manifest_sink", cfg.ManifestSink)
+			os.Exit(1)
+		}
+	default:
+		slog.Error("invalid manifest sink", "manifest_sink", cfg.ManifestSink)
+		os.Exit(1)
+	}
+	switch cfg.VersionOnNameConflict {
+	case "", config.VersionTimestamp, config.VersionSequence:
+	default:
+		slog.Error("invalid versioning mode", "version_on_name_conflict", cfg.VersionOnNameConflict)
+		os.Exit(1)
+	}
+	if cfg.VersionLatestLink && cfg.VersionOnNameConflict == "" {
+		slog.Error("latest link requires versioning", "version_latest_link", cfg.VersionLatestLink)
+		os.Exit(1)
+	}
+	// Multi-part files and batches, tarred or not, rename over their
+	// destination without reserving a version
+	if cfg.VersionOnNameConflict != "" && (cfg.PartPattern != "" || cfg.BatchDirs != "") {
+		slog.Error("versioning does not support multi-part files or batches",
+			"version_on_name_conflict", cfg.VersionOnNameConflict, "part_pattern", cfg.PartPattern, "batch_dirs", cfg.BatchDirs)
+		os.Exit(1)
+	}
+	switch cfg.WarehouseSharding {
+	case "", config.ShardingHash:
+	case config.ShardingCount:
+		if cfg.ShardFanout < 2 {
+			slog.Error("shard fan-out must be at least 2", "shard_fanout", cfg.ShardFanout)
+			os.Exit(1)
+		}
+	default:
+		slog.Error("invalid warehouse sharding", "warehouse_sharding", cfg.WarehouseSharding)
+		os.Exit(1)
+	}
+	if cfg.NotifyURL != "" {
+		u, err := url.Parse(cfg.NotifyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			slog.Error("notify url must be an http or https url")
+			os.Exit(1)
+		}
+	}
+	if cfg.OutboxLimit < 0 {
+		slog.Error("invalid outbox limit", "outbox_limit", cfg.OutboxLimit)
+		os.Exit(1)
+	}
+	if cfg.ClockTolerance < 0 {
+		slog.Error("invalid clock tolerance", "clock_tolerance", cfg.ClockTolerance)
+		os.Exit(1)
+	}
+	if cfg.OnClockRegression != config.ClockRegressionContinue && cfg.OnClockRegression != config.ClockRegressionHold {
+		slog.Error("invalid clock regression reaction", "on_clock_regression", cfg.OnClockRegression)
+		os.Exit(1)
+	}
+	if cfg.FinalizeGrace < 0 {
+		slog.Error("invalid finalize grace", "finalize_grace", cfg.FinalizeGrace)
+		os.Exit(1)
+	}
+	if cfg.FinalizeDays && cfg.ManifestSink == config.ManifestSinkDB {
+		slog.Error("finalizing manifest days requires a file sink", "manifest_sink", cfg.ManifestSink)
+		os.Exit(1)
+	}
+	switch cfg.WatchBackend {
+	case config.WatchBackendAuto, config.WatchBackendFsnotify, config.WatchBackendPoll:
+	default:
+		slog.Error("invalid watch backend", "watch_backend", cfg.WatchBackend)
+		os.Exit(1)
+	}
+	if cfg.PollInterval <= 0 {
+		slog.Error("invalid poll interval", "poll_interval", cfg.PollInterval)
+		os.Exit(1)
+	}
+	if cfg.WatchProbeInterval < 0 {
+		slog.Error("invalid watch probe interval", "watch_probe_interval", cfg.WatchProbeInterval)
+		os.Exit(1)
+	}
+	if cfg.TombstoneWindow < 0 {
+		slog.Error("invalid tombstone window", "tombstone_window", cfg.TombstoneWindow)
+		os.Exit(1)
+	}
+	if cfg.DuplicateCheckCache < 0 {
+		slog.Error("invalid duplicate check cache", "duplicate_check_cache", cfg.DuplicateCheckCache)
+		os.Exit(1)
+	}
+	if cfg.DestinationProbe && (cfg.DestinationProbeCache <= 0 || cfg.DestinationProbeMaxBackoff <= 0) {
+		slog.Error("invalid destination probe options, want positive durations",
+			"destination_probe_cache", cfg.DestinationProbeCache,
+			"destination_probe_max_backoff", cfg.DestinationProbeMaxBackoff,
+		)
+		os.Exit(1)
+	}
+	if cfg.DigestInterval < 0 || cfg.DigestTop <= 0 {
+		slog.Error("invalid duplicate digest options", "digest_interval", cfg.DigestInterval, "digest_top", cfg.DigestTop)
+		os.Exit(1)
+	}
+	if cfg.ConfigDriftAlert && cfg.NotifyURL == "" {
+		slog.Error("configuration drift alert requires a notify url")
+		os.Exit(1)
+	}
+	if cfg.DigestInterval > 0 && cfg.NotifyURL == "" {
+		slog.Error("duplicate digest requires a notify url", "digest_interval", cfg.DigestInterval)
+		os.Exit(1)
+	}
+	if cfg.AttemptRetention < 0 {
+		slog.Error("invalid attempt retention", "attempt_retention", cfg.AttemptRetention)
+		os.Exit(1)
+	}
+	if cfg.TimingSampleRate < 0 || cfg.TimingSampleRate > 1 {
+		slog.Error("invalid timing sample rate, want a fraction in [0, 1]", "timing_sample_rate", cfg.TimingSampleRate)
+		os.Exit(1)
+	}
+	if cfg.InputSpaceInterval < 0 || cfg.InputMinFreePercent < 0 || cfg.InputMinFreePercent > 100 ||
+		cfg.InputMinFreeInodesPercent < 0 || cfg.InputMinFreeInodesPercent > 100 {
+		slog.Error("invalid input space options, want percentages in [0, 100]",
+			"input_space_interval", cfg.InputSpaceInterval,
+			"input_min_free_percent", cfg.InputMinFreePercent,
+			"input_min_free_inodes_percent", cfg.InputMinFreeInodesPercent,
+		)
+		os.Exit(1)
+	}
+	if cfg.StrictManifestWindow < 0 {
+		slog.Error("invalid strict manifest window", "strict_manifest_window", cfg.StrictManifestWindow)
+		os.Exit(1)
+	}
+	if cfg.DedupWindow < 0 {
+		slog.Error("invalid dedup window", "dedup_window", cfg.DedupWindow)
+		os.Exit(1)
+	}
+	var reportSchedule *report.Schedule
+	if cfg.ReportSchedule != "" {
+		loc, err := time.LoadLocation(cfg.ReportTimezone)
+		if err != nil {
+			slog.Error("invalid report timezone", "report_timezone", cfg.ReportTimezone, "error", err)
+			os.Exit(1)
+		}
+		if reportSchedule, err = report.ParseSchedule(cfg.ReportSchedule, loc); err != nil {
+			slog.Error("invalid report schedule", "report_schedule", cfg.ReportSchedule, "error", err)
+			os.Exit(1)
+		}
+		if cfg.ReportTop <= 0 {
+			slog.Error("invalid report top", "report_top", cfg.ReportTop)
+			os.Exit(1)
+		}
+		if cfg.ReportDir == "" && cfg.NotifyURL == "" {
+			slog.Error("ingestion report requires a report directory or a notify url", "report_schedule", cfg.ReportSchedule)
+			os.Exit(1)
+		}
+	}
+	if cfg.StormRatio < 0 || cfg.StormRatio > 1 {
+		slog.Error("invalid storm ratio, want a ratio between 0 and 1", "storm_ratio", cfg.StormRatio)
+		os.Exit(1)
+	}
+	if cfg.StormRatio > 0 && (cfg.StormWindow <= 0 || cfg.StormMinFiles <= 0 || cfg.StormSummaryInterval <= 0) {
+		slog.Error("invalid resend storm options",
+			"storm_window", cfg.StormWindow,
+			"storm_min_files", cfg.StormMinFiles,
+			"storm_summary_interval", cfg.StormSummaryInterval,
+		)
+		os.Exit(1)
+	}
+	if cfg.ManifestRedaction != config.ManifestRedactionNone && cfg.ManifestRedaction != config.ManifestRedactionPseudonym {
+		slog.Error("invalid manifest redaction", "manifest_redaction", cfg.ManifestRedaction)
+		os.Exit(1)
+	}
+	if cfg.SizeAccounting != config.SizeAccountingLogical && cfg.SizeAccounting != config.SizeAccountingAllocated {
+		slog.Error("invalid size accounting", "size_accounting", cfg.SizeAccounting)
+		os.Exit(1)
+	}
+	if cfg.BatchTarSymlinks != config.SymlinksSkip && cfg.BatchTarSymlinks != config.SymlinksFollow {
+		slog.Error("invalid batch tar symlink handling", "batch_tar_symlinks", cfg.BatchTarSymlinks)
+		os.Exit(1)
+	}
+	if cfg.BatchTar && cfg.BatchDirs == "" {
+		slog.Error("batch tar requires batch directories", "batch_tar", cfg.BatchTar)
+		os.Exit(1)
+	}
+	if cfg.SelfTest && cfg.DryRun {
+		slog.Error("self test cannot run in dry run mode")
+		os.Exit(1)
+	}
+	if cfg.ScanMaxTracked < 0 {
+		slog.Error("invalid scan max tracked", "scan_max_tracked", cfg.ScanMaxTracked)
+		os.Exit(1)
+	}
+	if cfg.TenantMaxWorkers < 0 {
+		slog.Error("invalid tenant worker limit", "tenant_max_workers", cfg.TenantMaxWorkers)
+		os.Exit(1)
+	}
+	if cfg.ParanoidDedupRate < 0 {
+		slog.Error("invalid paranoid dedup rate", "paranoid_dedup_rate", cfg.ParanoidDedupRate)
+		os.Exit(1)
+	}
+	if _, err := fileops.ParseNormalization(cfg.UnicodeNormalization); err != nil {
+		slog.Error("invalid unicode normalization", "unicode_normalization", cfg.UnicodeNormalization, "error", err)
+		os.Exit(1)
+	}
+	if _, err := processor.ParsePriorities(cfg.Priorities); err != nil {
+		slog.Error("invalid priority classes", "priority", cfg.Priorities, "error", err)
+		os.Exit(1)
+	}
+	if cfg.PriorityReserve < 0 || cfg.PriorityReserve >= 1 {
+		slog.Error("invalid priority reserve, want a fraction in [0, 1)", "priority_reserve", cfg.PriorityReserve)
+		os.Exit(1)
+	}
+	if cfg.URLConcurrency < 1 || cfg.URLMaxBytes < 0 || cfg.URLTimeout <= 0 || cfg.URLRetries < 0 {
+		slog.Error("invalid URL list settings",
+			"url_concurrency", cfg.URLConcurrency,
+			"url_max_bytes", cfg.URLMaxBytes,
+			"url_timeout", cfg.URLTimeout,
+			"url_retries", cfg.URLRetries,
+		)
+		os.Exit(1)
+	}
+	if cfg.ShrinkPercent < 0 || cfg.ShrinkPercent >= 100 || cfg.ShrinkRelease < 0 {
+		slog.Error("invalid shrink hold, want a percentage in [0, 100)", "shrink_hold_percent", cfg.ShrinkPercent, "shrink_release_after", cfg.ShrinkRelease)
+		os.Exit(1)
+	}
+	if _, err := fileops.ParseSyncPolicy(cfg.SyncPolicy); err != nil {
+		slog.Error("invalid sync policy", "sync_policy", cfg.SyncPolicy, "error", err)
+		os.Exit(1)
+	}
 
-	// Initialize database
-	db, err := gorm.Open(sqlite.Open(cfg.StatePath), &gorm.Config{})
+	// Create and probe every directory before anything depends on it
+	prepared, err := cfg.Prepare()
 	if err != nil {
-		slog.Error("failed to open database", "error", err)
+		slog.Error("failed to prepare directories", "error", err)
 		os.Exit(1)
 	}
 
-	store := storage.New(db)
-	if err := store.AutoMigrate(); err != nil {
-		slog.Error("failed to migrate database", "error", err)
+	var tagRules *rules.Rules
+	if cfg.RulesPath != "" {
+		r, err := rules.Load(cfg.RulesPath)
+		if err != nil {
+			slog.Error("invalid tagging rules", "rules", cfg.RulesPath, "error", err)
+			os.Exit(1)
+		}
+		tagRules = r
+	}
+
+	fileCfg := &config.File{}
+	if cfg.ConfigPath != "" {
+		f, err := config.LoadFile(cfg.ConfigPath)
+		if err != nil {
+			slog.Error("invalid config file", "config", cfg.ConfigPath, "error", err)
+			os.Exit(1)
+		}
+		fileCfg = f
+	}
+
+	// Pseudonymize sensitive names in everything logged from here on
+	redactor, err := redact.Load(fileCfg.Redaction)
+	if err != nil {
+		slog.Error("invalid redaction configuration", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+	if redactor != nil {
+		slog.SetDefault(slog.New(redact.NewHandler(slog.Default().Handler(), redactor)))
+	} else if cfg.ManifestRedaction == config.ManifestRedactionPseudonym {
+		slog.Error("manifest redaction requires redaction patterns or tenants", "config", cfg.ConfigPath)
+		os.Exit(1)
+	}
+
+	slaRules, err := sla.Compile(fileCfg.SLA)
+	if err != nil {
+		slog.Error("invalid SLA rules", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+
+	renamer, err := rename.New(fileCfg.Rename)
+	if err != nil {
+		slog.Error("invalid rename rules", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+
+	paths, err := layout.Compile(fileCfg.Paths, fileCfg.Sources)
+	if err != nil {
+		slog.Error("invalid path templates", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+	// Parquet manifests keep the layout of their periods
+	if cfg.ManifestFormat == manifest.FormatParquet && paths.Custom(layout.Manifest) {
+		slog.Error("manifest path templates require the JSON Lines manifest format", "config", cfg.ConfigPath, "manifest_format", cfg.ManifestFormat)
+		os.Exit(1)
+	}
+
+	store := openStorage(cfg.StatePath, redactor.Writer(logOutput))
+	for _, d := range prepared {
+		if !d.Created {
+			continue
+		}
+		slog.Info("created directory", "role", d.Role, "path", d.Path)
+		if err := store.RecordManagedDir(d.Path, d.Role, time.Now()); err != nil {
+			slog.Error("failed to record created directory", "path", d.Path, "error", err)
+			os.Exit(1)
+		}
+	}
+	// Moves across filesystems copy, and staging only commits atomically
+	// within the warehouse's
+	topology := checkTopology(cfg, prepared)
+	if cfg.CheckConfig {
+		slog.Info("configuration is valid", "topology_warnings", len(topology.Warnings))
+		os.Exit(0)
+	}
+
+	// Stop, rather than fail every file, once the database is corrupt
+	guard := guardDatabase(cfg, store, redactor)
+
+	// Refuse to run, rather than repair, while inconsistencies are not
+	// acknowledged
+	if cfg.StrictStartup {
+		checkStartup(cfg, store, paths)
+	}
+
+	// A restart with a subtly different flag should not go unnoticed
+	recordConfig(cfg, store)
+
+	// Refuse a warehouse another configuration writes to, and tell whoever
+	// finds it what this one is
+	markWarehouse(cfg, paths)
+
+	// Resolve two-phase uploads interrupted by a crash before any new work
+	if err := recoverStaging(destination.NewLocal(cfg.Destination), cfg.Destination, store); err != nil {
+		slog.Error("failed to recover staging objects", "error", err)
+		os.Exit(1)
+	}
+	if err := clearScratch(cfg.Destination); err != nil {
+		slog.Error("failed to clear scratch copies", "error", err)
 		os.Exit(1)
 	}
 
@@ -98,6 +742,29 @@ func main() {
 		}
 	}()
 
+	routeFiles(w, cfg, fileCfg.Completion)
+
+	w.NormalizeNames(cfg.UnicodeNormalization)
+	w.SetTombstoneWindow(cfg.TombstoneWindow)
+
+	if err := w.SetBackend(cfg.WatchBackend, cfg.PollInterval, cfg.WatchProbeInterval); err != nil {
+		slog.Error("invalid watch backend", "watch_backend", cfg.WatchBackend, "error", err)
+		os.Exit(1)
+	}
+
+	// Sidecar completions seen before a restart are not seen again
+	if err := w.PersistCompletions(store); err != nil {
+		slog.Error("failed to restore sidecar completions", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.ScanMaxTracked > 0 {
+		if err := w.EnableIncrementalScan(cfg.ScanMaxTracked, store); err != nil {
+			slog.Error("failed to set up the initial scan", "scan_max_tracked", cfg.ScanMaxTracked, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	if err := w.Start(); err != nil {
 		slog.Error("failed to start watcher", "path", cfg.Path, "error", err)
 		os.Exit(1)
@@ -116,23 +783,482 @@ func main() {
 		cancel()
 	}()
 
+	// Background loops are restarted when they exit or stall, or mark the
+	// daemon not ready when that is not safe
+	sup := supervisor.New()
+	sup.Add(supervisor.Loop{Name: "watcher", Run: w.Supervise})
+
+	// Permanently delete trashed sources once their grace period is over and
+	// receipts once their retention is
+	receiptRetention := time.Duration(0)
+	if cfg.WriteReceipts {
+		receiptRetention = cfg.ReceiptRetention
+	}
+	if cfg.SourceGrace > 0 || receiptRetention > 0 {
+		sweeper := trash.NewSweeper(cfg.Path, cfg.SourceGrace, cfg.DryRun)
+		sweeper.SetReceiptRetention(receiptRetention)
+		sup.Add(supervisor.Loop{
+			Name:    "trash_sweeper",
+			Run:     func(ctx context.Context) error { sweeper.Run(ctx, config.DefaultSweepInterval); return nil },
+			Restart: true,
+			Stall:   stallTicks * config.DefaultSweepInterval,
+		})
+	}
+
+	// Clean up junk that is never ingested, protecting every pattern
+	// routed to ingestion, and the directories left empty
+	if fileCfg.Janitor != nil && (len(fileCfg.Janitor.Rules) > 0 || fileCfg.Janitor.EmptyDirs != nil) {
+		jan, err := janitor.New(cfg.Path, *fileCfg.Janitor, janitorProtect(fileCfg), w, cfg.DryRun)
+		if err != nil {
+			slog.Error("invalid janitor configuration", "config", cfg.ConfigPath, "error", err)
+			os.Exit(1)
+		}
+		interval := fileCfg.Janitor.Interval
+		if interval <= 0 {
+			interval = config.DefaultJanitorInterval
+		}
+		sup.Add(supervisor.Loop{
+			Name:    "janitor",
+			Run:     func(ctx context.Context) error { jan.Run(ctx, interval); return nil },
+			Restart: true,
+			Stall:   stallTicks * interval,
+		})
+	}
+
 	// Initialize processor
 	proc := processor.New(cfg, store, w)
+	guard.SetProcessing(proc.Pause, proc.Resume)
+	proc.SetLayout(paths)
+	proc.SetRules(tagRules)
+	proc.SetRenamer(renamer)
+	if err := proc.SetPipelines(fileCfg.Pipelines); err != nil {
+		slog.Error("invalid pipelines", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+	if err := proc.SetSources(fileCfg.Sources); err != nil {
+		slog.Error("invalid sources", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+	// A dry run writes nothing to the warehouse, not even a probe file
+	if cfg.DestinationProbe && !cfg.DryRun {
+		proc.SetDestinationHealth(destination.NewHealth(destination.NewLocal(cfg.Destination), destination.HealthOptions{
+			Cache:      cfg.DestinationProbeCache,
+			MaxBackoff: cfg.DestinationProbeMaxBackoff,
+		}))
+	}
+	for _, s := range fileCfg.Sources {
+		if s.ReadOnly {
+			slog.Info("read-only source, copying its files and leaving them in place", "source", s.Name)
+		}
+	}
+	if err := proc.SetIdempotencyKey(cfg.IdempotencyKeyPattern, cfg.IdempotencyKeyField); err != nil {
+		slog.Error("invalid idempotency key options", "error", err)
+		os.Exit(1)
+	}
+	proc.SetContext(ctx)
+
+	// A second instance on the same manifests would interleave its lines
+	// with ours, so it refuses to start instead
+	if cfg.ManifestSink != config.ManifestSinkDB {
+		lock, err := manifest.LockDir(cfg.ManifestsPath)
+		if err != nil {
+			slog.Error("manifests directory is in use", "path", cfg.ManifestsPath, "error", err)
+			os.Exit(1)
+		}
+		// Released after the manifest is closed below
+		defer func() { _ = lock.Release() }()
+	}
+	mw := manifest.NewWriter(cfg.ManifestsPath)
+	if cfg.ManifestFormat == manifest.FormatParquet {
+		if mw, err = manifest.NewParquetWriter(cfg.ManifestsPath, cfg.ManifestPeriod); err != nil {
+			slog.Error("failed to open parquet manifest", "error", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.ManifestSink != config.ManifestSinkFile {
+		mw.SetDatabase(store, cfg.ManifestSink == config.ManifestSinkBoth)
+	}
+	if cfg.FinalizeDays {
+		mw.EnableDayFinalization(cfg.FinalizeGrace)
+	}
+	proc.SetManifest(mw)
+	proc.SetRedactor(redactor, cfg.ManifestRedaction == config.ManifestRedactionPseudonym)
+	// Runs after the loop below returns, when no ProcessFiles is in flight
+	defer func() {
+		if err := proc.Close(); err != nil {
+			slog.Error("failed to close manifest", "error", err)
+		}
+	}()
+
+	if cfg.SelfTest {
+		os.Exit(runSelfTest(ctx, proc))
+	}
+
+	// Append the manifest entries an earlier run committed but failed to
+	// write, and those that fail from now on. A dry run appends no entry, so
+	// it leaves them to the next real run.
+	if mw.WritesFiles() && cfg.DryRun {
+		slog.Warn("dry run: pending manifest entries not retried")
+	} else if mw.WritesFiles() {
+		if err := proc.RecoverManifests(); err != nil {
+			slog.Error("failed to recover pending manifest entries", "error", err)
+			os.Exit(1)
+		}
+		sup.Add(supervisor.Loop{
+			Name:    "manifest_retry",
+			Run:     func(ctx context.Context) error { proc.RetryManifests(ctx); return nil },
+			Restart: true,
+			Stall:   stallTicks * processor.ManifestMaxBackoff,
+		})
+	}
+
+	// Deliver notifications left by an earlier run before new ones. A dry
+	// run ingests nothing, so it notifies nothing.
+	var dispatcher *outbox.Dispatcher
+	if cfg.NotifyURL != "" && cfg.DryRun {
+		slog.Warn("dry run: notifications disabled")
+	} else if cfg.NotifyURL != "" {
+		dispatcher = outbox.New(store, outbox.NewWebhook(cfg.NotifyURL), cfg.OutboxLimit)
+		proc.SetOutbox(dispatcher)
+		if err := proc.RecoverOutbox(); err != nil {
+			slog.Error("failed to recover outbox", "error", err)
+			os.Exit(1)
+		}
+		sup.Add(supervisor.Loop{
+			Name:    "outbox",
+			Run:     func(ctx context.Context) error { dispatcher.Run(ctx); return nil },
+			Restart: true,
+			Stall:   stallTicks * outbox.MaxBackoff,
+		})
+		if cfg.DigestInterval > 0 {
+			scheduler := digest.NewScheduler(store, cfg.DigestInterval, cfg.DigestTop, redactor.Text, dispatcher.Notify)
+			sup.Add(supervisor.Loop{
+				Name:    "digest",
+				Run:     func(ctx context.Context) error { scheduler.Run(ctx); return nil },
+				Restart: true,
+				// Schedulers look for a due digest at least every minute
+				Stall: stallTicks * time.Minute,
+			})
+		}
+	}
+
+	// Report on ingestion at its schedule. A dry run ingests nothing, so it
+	// has nothing to report.
+	if reportSchedule != nil && cfg.DryRun {
+		slog.Warn("dry run: ingestion reports disabled")
+	} else if reportSchedule != nil {
+		var notify func()
+		if dispatcher != nil {
+			notify = dispatcher.Notify
+		}
+		scheduler := report.NewScheduler(store, reportSchedule, report.Options{
+			QuarantinePath: cfg.QuarantinePath,
+			Top:            cfg.ReportTop,
+			Backlog: func() *report.Backlog {
+				return backlogOf(w.Tracked(), proc.Stats().WatermarkLag)
+			},
+		}, cfg.ReportDir, redactor.Text, notify)
+		sup.Add(supervisor.Loop{
+			Name:    "report",
+			Run:     func(ctx context.Context) error { scheduler.Run(ctx); return nil },
+			Restart: true,
+			Stall:   stallTicks * time.Minute,
+		})
+	}
+
+	// Alert on files expected at deadlines that did not arrive. A dry run
+	// ingests nothing, so every deadline would be missed.
+	if len(slaRules) > 0 && cfg.DryRun {
+		slog.Warn("dry run: SLA checks disabled")
+	} else if len(slaRules) > 0 {
+		var notify func()
+		if dispatcher != nil {
+			notify = dispatcher.Notify
+		}
+		scheduler := sla.NewScheduler(store, slaRules, cfg.Path, redactor.Text, notify)
+		sup.Add(supervisor.Loop{
+			Name:    "sla",
+			Run:     func(ctx context.Context) error { scheduler.Run(ctx); return nil },
+			Restart: true,
+			Stall:   stallTicks * time.Minute,
+		})
+	}
+
+	sup.Add(supervisor.Loop{
+		Name:    "database_guard",
+		Run:     func(ctx context.Context) error { guard.Run(ctx); return nil },
+		Restart: true,
+		Stall:   stallTicks * time.Minute,
+	})
+
+	// Warn before producers' uploads fail on a full input filesystem
+	var inputSpace *inputspace.Monitor
+	if cfg.InputSpaceInterval > 0 {
+		var notify func()
+		if dispatcher != nil {
+			notify = dispatcher.Notify
+		}
+		inputSpace = inputspace.New(cfg.Path, inputspace.Thresholds{
+			MinFreePercent:       cfg.InputMinFreePercent,
+			MinFreeInodesPercent: cfg.InputMinFreeInodesPercent,
+		}, store, redactor.Text, notify)
+		sup.Add(supervisor.Loop{
+			Name:    "input_space",
+			Run:     func(ctx context.Context) error { inputSpace.Run(ctx, cfg.InputSpaceInterval); return nil },
+			Restart: true,
+			Stall:   stallTicks * cfg.InputSpaceInterval,
+		})
+	}
+
+	if cfg.AdminAddr != "" {
+		if cfg.AdminToken == "" {
+			slog.Warn("admin token not set, pause and resume are disabled")
+		}
+		srv := admin.New(admin.Options{
+			Processor:      proc,
+			Watcher:        w,
+			Storage:        store,
+			QuarantinePath: cfg.QuarantinePath,
+			Token:          cfg.AdminToken,
+			Redactor:       redactor,
+			Outbox:         dispatcher,
+			Supervisor:     sup,
+			InputSpace:     inputSpace,
+			Topology:       &topology,
+			Database:       guard,
+		})
+		go func() {
+			if err := srv.Run(ctx, cfg.AdminAddr); err != nil {
+				slog.Error("admin api stopped", "error", err)
+			}
+		}()
+		slog.Info("admin api listening", "addr", cfg.AdminAddr)
+	}
 
 	// Process files periodically
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	sup.Add(supervisor.Loop{
+		Name:    "processor",
+		Run:     func(ctx context.Context) error { return processLoop(ctx, proc, mw) },
+		Restart: true,
+		Stall:   processStall,
+	})
 
 	slog.Info("atomic ingestor started, waiting for files")
+	sup.Run(ctx)
+	slog.Info("shutting down gracefully")
+}
+
+// Heartbeat allowances of the supervised loops. A loop beating on a ticker
+// is stalled after missing stallTicks ticks; the processor beats between
+// rounds, which last as long as the largest file takes to ingest.
+const (
+	stallTicks   = 3
+	processStall = 30 * time.Minute
+)
+
+// processLoop processes ready files every ProcessInterval until ctx is
+// canceled
+func processLoop(ctx context.Context, proc *processor.Processor, mw *manifest.Writer) error {
+	ticker := time.NewTicker(config.ProcessInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Info("shutting down gracefully")
-			return
+			return nil
 		case <-ticker.C:
+			supervisor.Beat(ctx)
 			slog.Debug("checking for files to process")
 			proc.ProcessFiles()
+			if _, err := proc.AdvanceWatermark(); err != nil {
+				slog.Warn("failed to publish watermark", "error", err)
+			}
+			if _, err := mw.FinalizeDays(); err != nil {
+				slog.Error("failed to finalize manifest day", "error", err)
+			}
+		}
+	}
+}
+
+// routeFiles configures w to route files as cfg and the completion rules
+// say: what is excluded, which completion method each file follows and
+// which files are grouped into batches and multi-part sets. The plan
+// subcommand shares it so that its report agrees with the daemon.
+func routeFiles(w *watcher.Watcher, cfg *config.Config, completion []config.CompletionRule) {
+	// Never track our own output, even if it is mounted into the input tree later
+	w.ExcludePaths(
+		cfg.Destination,
+		cfg.ManifestsPath,
+		cfg.QuarantinePath,
+		filepath.Join(cfg.Path, config.TrashDirName),
+		filepath.Join(cfg.Destination, destination.StagingPrefix),
+		filepath.Join(cfg.Destination, destination.ScratchPrefix),
+	)
+
+	if err := w.SetExcludeDirs(cfg.ExcludeDirs); err != nil {
+		slog.Error("invalid excluded directories", "exclude_dirs", cfg.ExcludeDirs, "error", err)
+		os.Exit(1)
+	}
+
+	if err := w.SetCompletionRules(completion); err != nil {
+		slog.Error("invalid completion rules", "config", cfg.ConfigPath, "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.PartPattern != "" {
+		if err := w.EnableFileSets(cfg.PartPattern); err != nil {
+			slog.Error("invalid part pattern", "part_pattern", cfg.PartPattern, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.BatchDirs != "" {
+		if err := w.EnableBatches(cfg.BatchDirs, cfg.BatchMarker); err != nil {
+			slog.Error("invalid batch options", "batch_dirs", cfg.BatchDirs, "batch_marker", cfg.BatchMarker, "error", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// recoverStaging completes or deletes staging objects left in dest. A staging
+// object is completed only when the state database has a record for it with
+// its content.
+func recoverStaging(dest destination.Destination, root string, store *storage.Storage) error {
+	res, err := destination.NewTwoPhase(dest).Recover(context.Background(), func(key string) (string, bool, error) {
+		file, err := store.FindByDestPath(filepath.Join(root, filepath.FromSlash(key)))
+		if err != nil || file == nil {
+			return "", false, err
+		}
+		return file.ContentSHA256(), true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if res.Completed > 0 || res.Deleted > 0 || res.Mismatched > 0 {
+		slog.Info("recovered staging objects", "completed", res.Completed, "deleted", res.Deleted, "mismatched", res.Mismatched)
+	}
+	return nil
+}
+
+// clearScratch deletes the working copies a crash left in the scratch area
+// of the warehouse at root. None was recorded, so none is kept.
+func clearScratch(root string) error {
+	dir := filepath.Join(root, filepath.FromSlash(destination.ScratchPrefix))
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	if len(entries) > 0 {
+		slog.Info("deleted scratch copies", "count", len(entries))
+	}
+	return nil
+}
+
+// setupLogger installs the structured JSON logger writing to w as the default,
+// exiting on an unknown level
+func setupLogger(w io.Writer, level string) {
+	var logLevel slog.Level
+	switch level {
+	case "debug":
+		logLevel = slog.LevelDebug
+	case "info":
+		logLevel = slog.LevelInfo
+	case "warn":
+		logLevel = slog.LevelWarn
+	case "error":
+		logLevel = slog.LevelError
+	default:
+		slog.Error("invalid log level", "level", level)
+		os.Exit(1)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+	slog.SetDefault(logger)
+}
+
+// openStorage opens and migrates the state database, exiting on failure.
+// Database warnings are written to logOutput.
+func openStorage(path string, logOutput io.Writer) *storage.Storage {
+	store := openDatabase(path, logOutput)
+	if err := store.AutoMigrate(); err != nil {
+		slog.Error("failed to migrate database", "error", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+// guardDatabase watches store for corruption errors. Alerts go to the
+// webhook directly, as the outbox is in the database; a dry run moves
+// nothing aside.
+func guardDatabase(cfg *config.Config, store *storage.Storage, redactor *redact.Redactor) *dbguard.Guard {
+	opts := dbguard.Options{Path: cfg.StatePath, AuditLog: cfg.AuditLog, Redact: redactor.Text}
+	if cfg.NotifyURL != "" && !cfg.DryRun {
+		opts.Alerts = outbox.NewWebhook(cfg.NotifyURL)
+	}
+	switch {
+	case cfg.DBRecovery && cfg.DryRun:
+		slog.Warn("dry run: database recovery disabled")
+	case cfg.DBRecovery:
+		opts.Recover = dbguard.Recovery{Store: store, Path: cfg.StatePath, ManifestsPath: cfg.ManifestsPath}.Run
+	}
+	guard := dbguard.New(opts)
+	if err := store.OnCorrupt(guard.Observe); err != nil {
+		slog.Error("failed to watch the database for corruption", "error", err)
+		os.Exit(1)
+	}
+	return guard
+}
+
+// checkTopology logs the filesystem of each prepared directory and of the
+// state database and warns about slow placements, exiting when staging is
+// not on the warehouse's filesystem
+func checkTopology(cfg *config.Config, prepared []config.PreparedDir) config.Topology {
+	dirs := slices.Clone(prepared)
+	if state, err := filepath.Abs(cfg.StatePath); err == nil {
+		dirs = append(dirs, config.PreparedDir{Role: "state", Path: filepath.Dir(state)})
+	}
+	topology, err := cfg.Topology(dirs, fileops.DeviceOf)
+	for _, fs := range topology.Filesystems {
+		if fs.Error != "" {
+			slog.Debug("filesystem not identified", "role", fs.Role, "path", fs.Path, "error", fs.Error)
+			continue
 		}
+		slog.Info("filesystem", "role", fs.Role, "path", fs.Path, "device", fs.Device, "fs_type", fs.FSType, "shared_with", fs.SharedWith)
+	}
+	for _, warning := range topology.Warnings {
+		slog.Warn("slow filesystem topology", "warning", warning)
 	}
+	if err != nil {
+		slog.Error("invalid filesystem topology", "error", err)
+		os.Exit(1)
+	}
+	return topology
+}
+
+// openDatabase opens the state database without migrating it, exiting on
+// failure
+func openDatabase(path string, logOutput io.Writer) *storage.Storage {
+	db, err := gorm.Open(sqlite.Open(storage.DSN(path)), &gorm.Config{
+		Logger: logger.New(log.New(logOutput, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      logger.Warn,
+			Colorful:      true,
+		}),
+	})
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+
+	return storage.New(db)
 }
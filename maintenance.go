@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/janitor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/maintenance"
+	"github.com/1995parham-learning/atomic-ingestor/internal/trash"
+)
+
+// defaultMaintenanceAuditLog records what the destructive subcommands
+// executed
+const defaultMaintenanceAuditLog = "maintenance-audit.jsonl"
+
+// runJanitor implements the janitor subcommand, which runs one sweep of the
+// janitor rules of a config file over the input directory
+func runJanitor(args []string) {
+	fs := flag.NewFlagSet("janitor", flag.ExitOnError)
+
+	configPath := fs.String("config", "", "YAML config file the janitor rules are read from")
+	input := fs.String("input", config.DefaultInputPath, "Input directory of the daemon, as passed to it")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+	var opts maintenance.Options
+	opts.RegisterFlags(fs, defaultMaintenanceAuditLog)
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *configPath == "" {
+		slog.Error("janitor requires the -config the janitor rules are in")
+		os.Exit(1)
+	}
+	fileCfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		slog.Error("invalid config file", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	if fileCfg.Janitor == nil || len(fileCfg.Janitor.Rules) == 0 {
+		slog.Error("config file has no janitor rules", "config", *configPath)
+		os.Exit(1)
+	}
+	root := resolveInput(*input)
+	jan, err := janitor.New(root, *fileCfg.Janitor, janitorProtect(fileCfg), nil, false)
+	if err != nil {
+		slog.Error("invalid janitor configuration", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	runMaintenance(jan, opts)
+}
+
+// runSweepTrash implements the sweep-trash subcommand, which deletes the
+// input trash entries past their grace period and, with -receipt-retention,
+// expired receipts
+func runSweepTrash(args []string) {
+	fs := flag.NewFlagSet("sweep-trash", flag.ExitOnError)
+
+	input := fs.String("input", config.DefaultInputPath, "Input directory of the daemon, as passed to it")
+	grace := fs.Duration("source-grace", 0, "Delete trash entries older than this (0 leaves the trash alone)")
+	receiptRetention := fs.Duration("receipt-retention", 0, "Delete receipts older than this (0 keeps them)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+	var opts maintenance.Options
+	opts.RegisterFlags(fs, defaultMaintenanceAuditLog)
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *grace < 0 || *receiptRetention < 0 || (*grace == 0 && *receiptRetention == 0 && opts.ApplyPlan == "") {
+		slog.Error("sweep-trash requires a positive -source-grace or -receipt-retention", "source_grace", *grace, "receipt_retention", *receiptRetention)
+		os.Exit(1)
+	}
+	sweeper := trash.NewSweeper(resolveInput(*input), *grace, false)
+	sweeper.SetReceiptRetention(*receiptRetention)
+	runMaintenance(sweeper, opts)
+}
+
+// runMaintenance runs op through the maintenance harness, printing its
+// report as JSON, and exits on failure
+func runMaintenance(op maintenance.Operation, opts maintenance.Options) {
+	if opts.Yes && opts.YesIfUnder > 0 {
+		slog.Error("-yes and -yes-if-under are exclusive")
+		os.Exit(1)
+	}
+	opts.Invocation = maintenance.CurrentInvocation()
+
+	report, err := maintenance.Run(op, opts, time.Now())
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+
+	var drift *maintenance.DriftError
+	switch {
+	case errors.As(err, &drift):
+		for _, d := range drift.Items {
+			slog.Error("planned item changed", "path", d.Path, "reason", d.Reason)
+		}
+		slog.Error("plan refused, re-plan and review it again", "plan", opts.ApplyPlan, "drifted", len(drift.Items))
+		os.Exit(1)
+	case err != nil:
+		slog.Error(op.Name()+" failed", "applied", report.Applied, "error", err)
+		os.Exit(1)
+	case !report.Executed:
+		slog.Info("dry run, pass -yes to execute the plan", "operation", op.Name(), "count", report.Count, "bytes", report.Bytes)
+	default:
+		slog.Info(op.Name()+" executed", "applied", report.Applied, "applied_bytes", report.AppliedBytes, "audit_log", opts.AuditLog)
+	}
+}
+
+// resolveInput returns the canonical input directory, exiting when it
+// cannot be resolved
+func resolveInput(input string) string {
+	root, err := config.ResolvePath(input)
+	if err != nil {
+		slog.Error("invalid input directory", "input", input, "error", err)
+		os.Exit(1)
+	}
+	return root
+}
+
+// janitorProtect returns the patterns the janitor never removes: every
+// pattern routed to ingestion and the files of read-only sources
+func janitorProtect(fileCfg *config.File) []string {
+	var protect []string
+	for _, r := range fileCfg.Completion {
+		protect = append(protect, r.Pattern)
+	}
+	for _, r := range fileCfg.Pipelines {
+		protect = append(protect, r.Pattern)
+	}
+	for _, s := range fileCfg.Sources {
+		if s.ReadOnly {
+			protect = append(protect, s.Name+"/**")
+		}
+	}
+	return protect
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/backfill"
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+)
+
+// runManifest implements the manifest subcommand, which maintains the
+// manifests of the daemon
+func runManifest(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: atomic-ingestor manifest backfill [flags]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "backfill":
+		runManifestBackfill(args[1:])
+	default:
+		usage()
+	}
+}
+
+// runManifestBackfill writes the manifest entries of the file records that
+// have none, such as those recorded before manifests were written, into the
+// manifest files of the periods they were processed in. It takes the
+// writer lock of the manifests, so the daemon must be stopped.
+func runManifestBackfill(args []string) {
+	fs := flag.NewFlagSet("manifest backfill", flag.ExitOnError)
+
+	from := fs.String("from", "", "Start of the period, a date or RFC 3339 time (default the oldest record)")
+	to := fs.String("to", "", "End of the period, exclusive, a date or RFC 3339 time (default now)")
+	statuses := fs.String("status", string(status.Ingested), "Comma-separated statuses of the records to backfill (empty backfills every record)")
+	input := fs.String("input", config.DefaultInputPath, "Input directory of the daemon, as passed to it")
+	manifestsPath := fs.String("manifests", config.DefaultManifestsPath, "Manifests directory of the daemon")
+	manifestFormat := fs.String("manifest-format", config.DefaultManifestFormat, "Manifest file format of the daemon (jsonl; parquet manifests are backfilled into the database)")
+	manifestSink := fs.String("manifest-sink", config.ManifestSinkFile, "Where the entries are written: file to the manifests directory, db to the state database, or both")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	configPath := fs.String("config", "", "YAML config file of the daemon, for its path layout and redaction")
+	manifestRedaction := fs.String("manifest-redaction", config.ManifestRedactionNone, "Redaction of the daemon's manifests (none or pseudonym)")
+	dryRun := fs.Bool("dry-run", false, "Count the entries that would be backfilled without writing them")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the final count
+	setupLogger(os.Stderr, *logLevel)
+
+	var want []status.Status
+	for name := range strings.SplitSeq(*statuses, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		st, err := status.Parse(name)
+		if err != nil {
+			slog.Error("invalid status", "status", name, "error", err)
+			os.Exit(1)
+		}
+		want = append(want, st)
+	}
+	switch *manifestSink {
+	case config.ManifestSinkFile, config.ManifestSinkBoth:
+		if *manifestFormat != manifest.FormatJSONL {
+			slog.Error("only JSON Lines manifest files are backfilled; backfill parquet manifests into the database sink", "manifest_format", *manifestFormat)
+			os.Exit(1)
+		}
+	case config.ManifestSinkDB:
+	default:
+		slog.Error("invalid manifest sink", "manifest_sink", *manifestSink)
+		os.Exit(1)
+	}
+	if *manifestRedaction != config.ManifestRedactionNone && *manifestRedaction != config.ManifestRedactionPseudonym {
+		slog.Error("invalid manifest redaction", "manifest_redaction", *manifestRedaction)
+		os.Exit(1)
+	}
+
+	fileCfg := &config.File{}
+	if *configPath != "" {
+		var err error
+		if fileCfg, err = config.LoadFile(*configPath); err != nil {
+			slog.Error("invalid config file", "config", *configPath, "error", err)
+			os.Exit(1)
+		}
+	}
+	paths, err := layout.Compile(fileCfg.Paths, fileCfg.Sources)
+	if err != nil {
+		slog.Error("invalid path layout", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	redactor, err := redact.Load(fileCfg.Redaction)
+	if err != nil {
+		slog.Error("invalid redaction configuration", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+
+	requireDatabase(*statePath)
+	store := openStorage(*statePath, os.Stderr)
+
+	end := time.Now()
+	if *to != "" {
+		if end, err = parseDigestTime(*to); err != nil {
+			slog.Error("invalid end of period", "to", *to, "error", err)
+			os.Exit(1)
+		}
+	}
+	var start time.Time
+	if *from != "" {
+		if start, err = parseDigestTime(*from); err != nil {
+			slog.Error("invalid start of period", "from", *from, "error", err)
+			os.Exit(1)
+		}
+	} else {
+		first, _, err := store.ProcessedSpan(want...)
+		if err != nil {
+			slog.Error("failed to find the oldest record", "error", err)
+			os.Exit(1)
+		}
+		if first.IsZero() {
+			fmt.Println("no records to backfill")
+			return
+		}
+		start = first
+	}
+	if !start.Before(end) {
+		slog.Error("start of period must be before its end", "from", start, "to", end)
+		os.Exit(1)
+	}
+
+	// The daemon's lines would interleave with ours
+	if *manifestSink != config.ManifestSinkDB && !*dryRun {
+		lock, err := manifest.LockDir(*manifestsPath)
+		if err != nil {
+			slog.Error("manifests directory is in use; stop the daemon before backfilling", "path", *manifestsPath, "error", err)
+			os.Exit(1)
+		}
+		defer func() { _ = lock.Release() }()
+	}
+	mw := manifest.NewWriter(*manifestsPath)
+	mw.SetLayout(paths)
+	mw.SetSourceRoot(*input)
+	if *manifestSink != config.ManifestSinkFile {
+		mw.SetDatabase(store, *manifestSink == config.ManifestSinkBoth)
+	}
+	if redactor != nil && *manifestRedaction == config.ManifestRedactionPseudonym {
+		mw.SetRedaction(redactor.Text)
+		mw.SetSourceRoot(redactor.Text(*input))
+	}
+
+	slog.Info("backfilling manifest entries",
+		"from", start,
+		"to", end,
+		"statuses", want,
+		"manifest_sink", *manifestSink,
+		"dry_run", *dryRun,
+	)
+	res, err := backfill.Run(store, mw, backfill.Options{
+		From:     start,
+		To:       end.Add(-time.Nanosecond),
+		Statuses: want,
+		DryRun:   *dryRun,
+	})
+	if cerr := mw.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	verb := "backfilled"
+	if *dryRun {
+		verb = "would backfill"
+	}
+	fmt.Printf("%s %d manifest entries for %d records over %d days (%d already covered, %d finalized days refinalized)\n",
+		verb, res.Backfilled, res.Records, res.Days, res.Covered, res.Refinalized)
+	if err != nil {
+		slog.Error("backfill failed", "backfilled", res.Backfilled, "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/destination"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// markerTool names the ingestor in the warehouse marker
+const markerTool = "atomic-ingestor"
+
+// markWarehouse exits when the marker of the warehouse was written with
+// another layout or manifests directory, unless -force-adopt-warehouse is
+// set, and writes the marker of the running configuration. A dry run only
+// checks it.
+func markWarehouse(cfg *config.Config, paths *layout.Resolver) {
+	marker := warehouseMarker(cfg, paths, time.Now())
+	err := destination.CheckMarker(cfg.Destination, marker)
+	var conflict *destination.MarkerConflictError
+	switch {
+	case errors.As(err, &conflict) && cfg.ForceAdoptWarehouse:
+		slog.Warn("taking over a warehouse marked by another configuration", "marker", conflict.Path, "differ", conflict.Fields)
+	case errors.As(err, &conflict):
+		slog.Error("warehouse is marked by another configuration; pass -force-adopt-warehouse to take it over",
+			"marker", conflict.Path,
+			"differ", conflict.Fields,
+		)
+		os.Exit(1)
+	case err != nil && cfg.ForceAdoptWarehouse:
+		slog.Warn("replacing an unreadable warehouse marker", "error", err)
+	case err != nil:
+		slog.Error("failed to check the warehouse marker; pass -force-adopt-warehouse to replace it", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.DryRun {
+		return
+	}
+	if err := destination.WriteMarker(cfg.Destination, marker); err != nil {
+		slog.Error("failed to write the warehouse marker", "error", err)
+		os.Exit(1)
+	}
+}
+
+// warehouseMarker returns the marker of the running configuration
+func warehouseMarker(cfg *config.Config, paths *layout.Resolver, now time.Time) destination.Marker {
+	host, _ := os.Hostname()
+	manifests, err := filepath.Abs(cfg.ManifestsPath)
+	if err != nil {
+		manifests = cfg.ManifestsPath
+	}
+	statePath, err := filepath.Abs(cfg.StatePath)
+	if err != nil {
+		statePath = cfg.StatePath
+	}
+	state, _, _ := strings.Cut(storage.DSN(statePath), "?")
+	warehouse, sources := paths.Templates(layout.Warehouse)
+	if len(sources) == 0 {
+		sources = nil
+	}
+	return destination.Marker{
+		Tool:         markerTool,
+		Version:      toolVersion(),
+		Instance:     host,
+		Layout:       destination.MarkerLayout{Warehouse: warehouse, Sources: sources},
+		Manifests:    manifests,
+		ManifestSink: cfg.ManifestSink,
+		State:        state,
+		StartedAt:    now.UTC(),
+	}
+}
+
+// toolVersion returns the module version the binary was built from
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
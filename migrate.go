@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// runMigrateStatus implements the migrate-status subcommand, which prints
+// every migration of the state database schema and when it was applied
+func runMigrateStatus(args []string) {
+	fs := flag.NewFlagSet("migrate-status", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the status
+	setupLogger(os.Stderr, *logLevel)
+	requireDatabase(*statePath)
+
+	states, err := openDatabase(*statePath, os.Stderr).MigrationStatus()
+	if err != nil {
+		slog.Error("failed to read migration status", "error", err)
+		os.Exit(1)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(states); err != nil {
+		slog.Error("failed to encode migration status", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runMigrateTo implements the migrate-to subcommand, which runs the pending
+// migrations up to and including the one given
+func runMigrateTo(args []string) {
+	fs := flag.NewFlagSet("migrate-to", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if fs.NArg() != 1 {
+		slog.Error("usage: migrate-to [flags] <migration id>")
+		os.Exit(1)
+	}
+	id := fs.Arg(0)
+
+	if err := openDatabase(*statePath, os.Stdout).MigrateTo(id); err != nil {
+		slog.Error("migration failed", "target", id, "error", err)
+		os.Exit(1)
+	}
+	slog.Info("database migrated", "state_path", *statePath, "target", id)
+}
+
+// requireDatabase exits when there is no state database at path, rather
+// than creating an empty one
+func requireDatabase(path string) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", path)
+		os.Exit(1)
+	}
+}
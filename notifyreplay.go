@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/layout"
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+	"github.com/1995parham-learning/atomic-ingestor/internal/outbox"
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/redact"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// replayProgressEvery is how many sends pass between two progress lines
+const replayProgressEvery = 100
+
+// runNotifyReplay implements the notify-replay subcommand, which sends the
+// notifications of the manifest entries of a period again, for a consumer
+// that lost its deliveries
+func runNotifyReplay(args []string) {
+	fs := flag.NewFlagSet("notify-replay", flag.ExitOnError)
+
+	from := fs.String("from", "", "Start of the period, a date or RFC 3339 time (required)")
+	to := fs.String("to", "", "End of the period, exclusive, a date or RFC 3339 time (default now)")
+	statuses := fs.String("status", string(status.Ingested), "Comma-separated statuses of the entries to replay (empty replays every file outcome)")
+	source := fs.String("source", "", "Only replay the entries of this source, a top-level directory of the input")
+	notifyURL := fs.String("notify-url", "", "Webhook URL the notifications are sent to (required)")
+	rate := fs.Float64("rate", 10, "Most notifications sent per second (0 unlimited)")
+	input := fs.String("input", config.DefaultInputPath, "Input directory of the daemon, as passed to it")
+	manifestsPath := fs.String("manifests", config.DefaultManifestsPath, "Manifests directory of the daemon")
+	manifestFormat := fs.String("manifest-format", config.DefaultManifestFormat, "Manifest file format of the daemon (jsonl; parquet manifests are replayed from the database)")
+	manifestSink := fs.String("manifest-sink", config.ManifestSinkFile, "Where the entries are read from: file for the manifests directory or db for the state database")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file, read with -manifest-sink db")
+	configPath := fs.String("config", "", "YAML config file of the daemon, for its path layout and redaction")
+	manifestRedaction := fs.String("manifest-redaction", config.ManifestRedactionNone, "Redaction of the daemon's manifests: none redacts the names sent as its events do, pseudonym sends them as written")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the final count
+	setupLogger(os.Stderr, *logLevel)
+
+	if *from == "" {
+		slog.Error("notify-replay requires the -from of the period")
+		os.Exit(1)
+	}
+	start, err := parseDigestTime(*from)
+	if err != nil {
+		slog.Error("invalid start of period", "from", *from, "error", err)
+		os.Exit(1)
+	}
+	end := time.Now().UTC()
+	if *to != "" {
+		if end, err = parseDigestTime(*to); err != nil {
+			slog.Error("invalid end of period", "to", *to, "error", err)
+			os.Exit(1)
+		}
+	}
+	if !start.Before(end) {
+		slog.Error("start of period must be before its end", "from", start, "to", end)
+		os.Exit(1)
+	}
+	var want []status.Status
+	for name := range strings.SplitSeq(*statuses, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		st, err := status.Parse(name)
+		if err != nil {
+			slog.Error("invalid status", "status", name, "error", err)
+			os.Exit(1)
+		}
+		want = append(want, st)
+	}
+	if u, err := url.Parse(*notifyURL); *notifyURL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		slog.Error("notify-replay requires an http or https -notify-url")
+		os.Exit(1)
+	}
+	if *rate < 0 {
+		slog.Error("invalid rate", "rate", *rate)
+		os.Exit(1)
+	}
+	if *manifestFormat != manifest.FormatJSONL {
+		slog.Error("only JSON Lines manifests are replayed from files; replay parquet manifests from the database sink", "manifest_format", *manifestFormat)
+		os.Exit(1)
+	}
+	if *manifestRedaction != config.ManifestRedactionNone && *manifestRedaction != config.ManifestRedactionPseudonym {
+		slog.Error("invalid manifest redaction", "manifest_redaction", *manifestRedaction)
+		os.Exit(1)
+	}
+
+	fileCfg := &config.File{}
+	if *configPath != "" {
+		if fileCfg, err = config.LoadFile(*configPath); err != nil {
+			slog.Error("invalid config file", "config", *configPath, "error", err)
+			os.Exit(1)
+		}
+	}
+	redactor, err := redact.Load(fileCfg.Redaction)
+	if err != nil {
+		slog.Error("invalid redaction configuration", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	opts := processor.ReplayOptions{
+		From:     start,
+		To:       end.Add(-time.Nanosecond),
+		Statuses: want,
+		Source:   *source,
+		Root:     *input,
+	}
+	if redactor != nil && *manifestRedaction == config.ManifestRedactionNone {
+		opts.Redact = redactor.Text
+	}
+	if *rate > 0 {
+		opts.Interval = time.Duration(float64(time.Second) / *rate)
+	}
+	opts.Progress = func(res processor.ReplayResult) {
+		if res.Sent%replayProgressEvery == 0 {
+			slog.Info("replay progress", "sent", res.Sent, "read", res.Read)
+		}
+	}
+
+	var entries manifest.Reader
+	switch *manifestSink {
+	case config.ManifestSinkFile:
+		paths, err := layout.Compile(fileCfg.Paths, fileCfg.Sources)
+		if err != nil {
+			slog.Error("invalid path layout", "config", *configPath, "error", err)
+			os.Exit(1)
+		}
+		mw := manifest.NewWriter(*manifestsPath)
+		mw.SetLayout(paths)
+		entries = mw
+	case config.ManifestSinkDB:
+		if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+			slog.Error("state database does not exist", "state_path", *statePath)
+			os.Exit(1)
+		}
+		store, err := storage.OpenReadOnly(*statePath)
+		if err != nil {
+			slog.Error("failed to open database", "error", err)
+			os.Exit(1)
+		}
+		defer func() { _ = store.Close() }()
+		entries = store
+	default:
+		slog.Error("invalid manifest sink", "manifest_sink", *manifestSink)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	slog.Info("replaying notifications",
+		"from", start,
+		"to", end,
+		"statuses", want,
+		"source", *source,
+		"manifest_sink", *manifestSink,
+		"rate", *rate,
+	)
+	res, err := processor.Replay(ctx, entries, outbox.NewWebhook(*notifyURL), opts)
+	fmt.Printf("replayed %d notifications of %d matching entries (%d read)\n", res.Sent, res.Matched, res.Read)
+	if err != nil {
+		slog.Error("replay failed", "sent", res.Sent, "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,271 @@
+// Package drop writes files into the input directory of atomic-ingestor the
+// way the ingestor expects them: the content goes to a temporary name the
+// watcher ignores, is fsynced and renamed into place, and in sidecar mode is
+// then completed by its sidecar, itself renamed into place so the ingestor
+// never reads a partial one.
+package drop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// Completion methods of the ingestor, see the -method flag
+const (
+	MethodSidecar         = config.MethodSidecar
+	MethodRename          = config.MethodRename
+	MethodStabilityWindow = config.MethodStabilityWindow
+)
+
+// SidecarSuffix is appended to a data file name to name its sidecar
+const SidecarSuffix = config.SidecarSuffix
+
+// ChecksumField is the metadata field holding the SHA-256 of the content
+// when both WithMetadata and WithChecksum are given
+const ChecksumField = "sha256"
+
+// ErrClosed is returned when writing to a Writer already committed or
+// aborted
+var ErrClosed = errors.New("drop writer closed")
+
+type options struct {
+	method   string
+	checksum bool
+	metadata map[string]any
+	perm     os.FileMode
+}
+
+// Option configures a drop
+type Option func(*options)
+
+// WithMethod selects the completion method the ingestor watching the
+// directory uses; the default is MethodSidecar. A sidecar is only written
+// with MethodSidecar.
+func WithMethod(method string) Option {
+	return func(o *options) { o.method = method }
+}
+
+// WithChecksum writes the SHA-256 of the content to the sidecar, as a
+// sha256sum line or, with WithMetadata, in the ChecksumField field
+func WithChecksum() Option {
+	return func(o *options) { o.checksum = true }
+}
+
+// WithMetadata writes metadata to the sidecar as a JSON object, for example
+// the field the ingestor reads idempotency keys from
+func WithMetadata(metadata map[string]any) Option {
+	return func(o *options) { o.metadata = maps.Clone(metadata) }
+}
+
+// WithPerm sets the permissions of the file and its sidecar, 0o644 by
+// default
+func WithPerm(perm os.FileMode) Option {
+	return func(o *options) { o.perm = perm }
+}
+
+// Writer streams a file into a drop directory. Nothing is visible to the
+// ingestor until Commit; Abort discards what was written.
+type Writer struct {
+	dir  string
+	name string
+	opts options
+	tmp  *os.File
+	sum  hash.Hash
+	done bool
+}
+
+// Create starts writing name, a slash-separated path relative to dir.
+// Missing parent directories are created.
+func Create(dir, name string, opts ...Option) (*Writer, error) {
+	o := options{method: MethodSidecar, perm: 0o644}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch o.method {
+	case MethodSidecar, MethodRename, MethodStabilityWindow:
+	default:
+		return nil, fmt.Errorf("unknown completion method %q", o.method)
+	}
+
+	w := &Writer{dir: dir, name: filepath.FromSlash(name), opts: o, sum: sha256.New()}
+	if err := os.MkdirAll(filepath.Dir(w.path()), 0o755); err != nil {
+		return nil, fmt.Errorf("create directory for %s: %w", name, err)
+	}
+	tmp, err := createTemp(w.path())
+	if err != nil {
+		return nil, err
+	}
+	w.tmp = tmp
+	return w, nil
+}
+
+// Write implements io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, ErrClosed
+	}
+	n, err := w.tmp.Write(p)
+	w.sum.Write(p[:n])
+	return n, err
+}
+
+// Commit fsyncs the file, renames it into place and completes it with its
+// sidecar when the method asks for one
+func (w *Writer) Commit() error {
+	if w.done {
+		return ErrClosed
+	}
+	w.done = true
+	if err := w.tmp.Chmod(w.opts.perm); err != nil {
+		return w.abort(fmt.Errorf("set permissions of %s: %w", w.name, err))
+	}
+	if err := w.tmp.Sync(); err != nil {
+		return w.abort(fmt.Errorf("sync %s: %w", w.name, err))
+	}
+	if err := w.tmp.Close(); err != nil {
+		_ = os.Remove(w.tmp.Name())
+		return fmt.Errorf("close %s: %w", w.name, err)
+	}
+	if err := os.Rename(w.tmp.Name(), w.path()); err != nil {
+		_ = os.Remove(w.tmp.Name())
+		return fmt.Errorf("rename %s into place: %w", w.name, err)
+	}
+	// The file must be durable before the sidecar announces it
+	if err := syncDir(filepath.Dir(w.path())); err != nil || w.opts.method != MethodSidecar {
+		return err
+	}
+
+	sidecar, err := w.sidecar()
+	if err != nil {
+		return err
+	}
+	return writeAtomic(w.path()+SidecarSuffix, sidecar, w.opts.perm)
+}
+
+// Abort discards the file. It does nothing after Commit.
+func (w *Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return w.abort(nil)
+}
+
+func (w *Writer) abort(err error) error {
+	_ = w.tmp.Close()
+	if rerr := os.Remove(w.tmp.Name()); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+// path is the final path of the file
+func (w *Writer) path() string {
+	return filepath.Join(w.dir, w.name)
+}
+
+// sidecar returns the sidecar content: empty, a sha256sum line or the
+// metadata JSON
+func (w *Writer) sidecar() ([]byte, error) {
+	sum := hex.EncodeToString(w.sum.Sum(nil))
+	if w.opts.metadata == nil {
+		if !w.opts.checksum {
+			return nil, nil
+		}
+		return fmt.Appendf(nil, "%s  %s\n", sum, filepath.Base(w.name)), nil
+	}
+
+	metadata := w.opts.metadata
+	if w.opts.checksum {
+		metadata[ChecksumField] = sum
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("encode sidecar metadata of %s: %w", w.name, err)
+	}
+	return data, nil
+}
+
+// Write drops the content of r as name in dir, see Create
+func Write(dir, name string, r io.Reader, opts ...Option) error {
+	w, err := Create(dir, name, opts...)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return errors.Join(fmt.Errorf("write %s: %w", name, err), w.Abort())
+	}
+	return w.Commit()
+}
+
+// WriteFile drops data as name in dir, see Create
+func WriteFile(dir, name string, data []byte, opts ...Option) error {
+	w, err := Create(dir, name, opts...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Join(fmt.Errorf("write %s: %w", name, err), w.Abort())
+	}
+	return w.Commit()
+}
+
+// createTemp creates the temporary file path is written under: hidden and
+// ending in config.TempSuffix, so the watcher ignores it in every mode
+func createTemp(path string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+config.TempSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("create temporary file for %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// writeAtomic writes data to path through a temporary file renamed into
+// place
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs dir so the renames into it survive a crash
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package drop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// startWatcher runs a real watcher over a new drop directory
+func startWatcher(t *testing.T, method string) (string, *watcher.Watcher) {
+	t.Helper()
+	dir := t.TempDir()
+	// A stability window long enough that only the completion method can
+	// make files ready within the test
+	w, err := watcher.New(method, dir, 60)
+	if err != nil {
+		t.Fatalf("watcher.New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	return dir, w
+}
+
+// waitReady waits for the watcher to report exactly want as ready
+func waitReady(t *testing.T, w *watcher.Watcher, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files := w.GetFilesToProcess()
+		slices.Sort(files)
+		if slices.Equal(files, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("files ready = %v, want %v", files, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dirNames lists the names in dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriteFile_Sidecar(t *testing.T) {
+	dir, w := startWatcher(t, MethodSidecar)
+
+	if err := WriteFile(dir, "data.csv", []byte("a,b\n1,2\n")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	waitReady(t, w, filepath.Join(dir, "data.csv"))
+
+	if names := dirNames(t, dir); !slices.Equal(names, []string{"data.csv", "data.csv" + SidecarSuffix}) {
+		t.Errorf("drop directory holds %v, want the file and its sidecar only", names)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "data.csv"+SidecarSuffix)); len(data) != 0 {
+		t.Errorf("plain sidecar = %q, want empty", data)
+	}
+}
+
+func TestWriteFile_Rename(t *testing.T) {
+	dir, w := startWatcher(t, MethodRename)
+
+	if err := WriteFile(dir, "nested/data.csv", []byte("x"), WithMethod(MethodRename)); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	waitReady(t, w, filepath.Join(dir, "nested", "data.csv"))
+
+	if names := dirNames(t, filepath.Join(dir, "nested")); !slices.Equal(names, []string{"data.csv"}) {
+		t.Errorf("drop directory holds %v, want the file only", names)
+	}
+}
+
+func TestCreate_InvisibleUntilCommit(t *testing.T) {
+	dir, w := startWatcher(t, MethodSidecar)
+
+	dw, err := Create(dir, "stream.csv")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for range 3 {
+		if _, err := dw.Write([]byte("row\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if files := w.GetFilesToProcess(); len(files) != 0 {
+		t.Fatalf("files ready before Commit = %v, want none", files)
+	}
+
+	if err := dw.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	waitReady(t, w, filepath.Join(dir, "stream.csv"))
+	if data, _ := os.ReadFile(filepath.Join(dir, "stream.csv")); string(data) != "row\nrow\nrow\n" {
+		t.Errorf("content = %q", data)
+	}
+	if _, err := dw.Write([]byte("late")); err != ErrClosed {
+		t.Errorf("Write after Commit error = %v, want ErrClosed", err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	dir := t.TempDir()
+
+	dw, err := Create(dir, "data.csv")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := dw.Write([]byte("partial")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := dw.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Errorf("drop directory holds %v after Abort, want nothing", names)
+	}
+}
+
+func TestWriteFile_ChecksumSidecar(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("checked")
+
+	if err := WriteFile(dir, "data.csv", content, WithChecksum()); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	want := hex.EncodeToString(sum[:]) + "  data.csv\n"
+	if data, _ := os.ReadFile(filepath.Join(dir, "data.csv"+SidecarSuffix)); string(data) != want {
+		t.Errorf("checksum sidecar = %q, want %q", data, want)
+	}
+}
+
+func TestWriteFile_MetadataSidecar(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("described")
+
+	err := WriteFile(dir, "data.csv", content, WithMetadata(map[string]any{"idempotency_key": "k-1"}), WithChecksum())
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "data.csv"+SidecarSuffix))
+	if err != nil {
+		t.Fatalf("failed to read sidecar: %v", err)
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("sidecar is not JSON: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	if metadata["idempotency_key"] != "k-1" || metadata[ChecksumField] != hex.EncodeToString(sum[:]) {
+		t.Errorf("sidecar metadata = %v", metadata)
+	}
+}
+
+func TestCreate_UnknownMethod(t *testing.T) {
+	if _, err := Create(t.TempDir(), "data.csv", WithMethod("mail")); err == nil {
+		t.Error("Create with an unknown method succeeded")
+	}
+}
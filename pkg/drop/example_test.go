@@ -0,0 +1,75 @@
+package drop_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/1995parham-learning/atomic-ingestor/pkg/drop"
+)
+
+func ExampleWriteFile() {
+	dir, _ := os.MkdirTemp("", "drop")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err := drop.WriteFile(dir, "orders.csv", []byte("id,total\n1,9.99\n")); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		fmt.Println(e.Name())
+	}
+	// Output:
+	// orders.csv
+	// orders.csv.ok
+}
+
+func ExampleWrite() {
+	dir, _ := os.MkdirTemp("", "drop")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// The sidecar carries the key the ingestor deduplicates retries by
+	err := drop.Write(dir, "orders.csv", strings.NewReader("id,total\n1,9.99\n"),
+		drop.WithMetadata(map[string]any{"idempotency_key": "order-export-42"}))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sidecar, _ := os.ReadFile(filepath.Join(dir, "orders.csv"+drop.SidecarSuffix))
+	fmt.Println(string(sidecar))
+	// Output:
+	// {"idempotency_key":"order-export-42"}
+}
+
+func ExampleCreate() {
+	dir, _ := os.MkdirTemp("", "drop")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	w, err := drop.Create(dir, "export/rows.csv", drop.WithMethod(drop.MethodRename))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := range 3 {
+		if _, err := fmt.Fprintf(w, "%d\n", i); err != nil {
+			_ = w.Abort()
+			fmt.Println(err)
+			return
+		}
+	}
+	if err := w.Commit(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "export", "rows.csv"))
+	fmt.Print(string(data))
+	// Output:
+	// 0
+	// 1
+	// 2
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/plan"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// runPlan implements the plan subcommand, which reports what an ingestion
+// run would do right now without moving files or writing any state
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+
+	cfg := &config.Config{}
+	fs.StringVar(&cfg.Path, "input", config.DefaultInputPath, "Input directory to scan")
+	fs.StringVar(&cfg.Destination, "warehouse", config.DefaultWarehousePath, "Warehouse directory, never scanned")
+	fs.StringVar(&cfg.ManifestsPath, "manifests", config.DefaultManifestsPath, "Manifests directory, never scanned")
+	fs.StringVar(&cfg.QuarantinePath, "quarantine", config.DefaultQuarantinePath, "Quarantine directory, never scanned")
+	fs.StringVar(&cfg.Method, "mode", config.DefaultMethod, "Completion detection mode (stability_window, sidecar or rename)")
+	fs.IntVar(&cfg.StabilitySeconds, "stability-seconds", config.DefaultStabilitySeconds, "Stability window duration in seconds")
+	fs.StringVar(&cfg.ConfigPath, "config", "", "YAML configuration file (per-pattern completion methods)")
+	fs.StringVar(&cfg.ExcludeDirs, "exclude-dirs", "", "Directories below the input directory never scanned, as globs separated by commas")
+	fs.StringVar(&cfg.PartPattern, "part-pattern", "", "Regexp capturing stem and index of multi-part files (empty disables)")
+	fs.StringVar(&cfg.BatchDirs, "batch-dirs", "", "Glob of input directories ingested all-or-nothing once their marker appears (empty disables)")
+	fs.StringVar(&cfg.BatchMarker, "batch-marker", config.DefaultBatchMarker, "Name (or name glob) of the file completing a directory batch")
+	fs.DurationVar(&cfg.ShrinkRelease, "shrink-release-after", 0, "How long a file held for shrinking stays held (0 until forced in)")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+	noHash := fs.Bool("no-hash", false, "Only report names and sizes, skipping hashing and duplicate checks")
+	format := fs.String("format", "json", "Report format (json or csv)")
+	out := fs.String("out", "", "Report output file (default stdout)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report when it is written there
+	logOutput := os.Stdout
+	if *out == "" {
+		logOutput = os.Stderr
+	}
+	setupLogger(logOutput, *logLevel)
+
+	if *format != "json" && *format != "csv" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+
+	fileCfg := &config.File{}
+	if cfg.ConfigPath != "" {
+		f, err := config.LoadFile(cfg.ConfigPath)
+		if err != nil {
+			slog.Error("invalid config file", "config", cfg.ConfigPath, "error", err)
+			os.Exit(1)
+		}
+		fileCfg = f
+	}
+
+	// Files are routed by the daemon's own watcher, which is never started
+	router, err := watcher.New(cfg.Method, cfg.Path, cfg.StabilitySeconds)
+	if err != nil {
+		slog.Error("invalid method name", "method", cfg.Method, "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = router.Close()
+	}()
+	routeFiles(router, cfg, fileCfg.Completion)
+
+	var store storage.Reader
+	if !*noHash {
+		store = openStorageReadOnly(*statePath)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := plan.New(store, plan.Options{
+		Root:          router.Root(),
+		Method:        cfg.Method,
+		Router:        router,
+		ShrinkRelease: cfg.ShrinkRelease,
+		NoHash:        *noHash,
+	}).Run(ctx)
+	if err != nil {
+		slog.Error("plan failed", "error", err)
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			slog.Error("failed to create report file", "path", *out, "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				slog.Error("failed to close report file", "path", *out, "error", err)
+			}
+		}()
+		w = file
+	}
+
+	if *format == "csv" {
+		err = plan.WriteCSV(w, report)
+	} else {
+		err = plan.WriteJSON(w, report)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+
+	slog.Info("plan finished",
+		"total_files", report.Summary.TotalFiles,
+		"total_bytes", report.Summary.TotalBytes,
+		"ingest_bytes", report.Summary.IngestBytes,
+		"files", report.Summary.Files,
+	)
+}
+
+// openStorageReadOnly opens the state database without creating or migrating
+// it. A missing database yields nil, meaning no file has been ingested yet.
+func openStorageReadOnly(path string) storage.Reader {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("state database does not exist, treating every file as new", "state_path", path)
+		return nil
+	}
+
+	store, err := storage.OpenReadOnly(path)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	return store
+}
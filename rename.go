@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/rename"
+)
+
+// runTestRename implements the test-rename subcommand, which applies the
+// rename rules of a config file to sample paths, given as arguments or one
+// per line on stdin, and prints the warehouse path each would get before
+// collision handling
+func runTestRename(args []string) {
+	fs := flag.NewFlagSet("test-rename", flag.ExitOnError)
+
+	configPath := fs.String("config", "", "YAML config file holding the rename rules")
+	normalization := fs.String("unicode-normalization", config.DefaultNormalization, "Unicode normalization applied after renaming (nfc or none)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the results
+	setupLogger(os.Stderr, *logLevel)
+
+	if *configPath == "" {
+		slog.Error("usage: test-rename -config <file> [path ...]")
+		os.Exit(1)
+	}
+	form, err := fileops.ParseNormalization(*normalization)
+	if err != nil {
+		slog.Error("invalid unicode normalization", "unicode_normalization", *normalization, "error", err)
+		os.Exit(1)
+	}
+	fileCfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		slog.Error("invalid config file", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	renamer, err := rename.New(fileCfg.Rename)
+	if err != nil {
+		slog.Error("invalid rename rules", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				paths = append(paths, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			slog.Error("failed to read paths", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	for _, p := range paths {
+		renamed, rule, err := renamer.Apply(p)
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Printf("%s\terror: %v\n", p, err)
+		case rule == 0:
+			fmt.Printf("%s\t%s\tno rule\n", p, fileops.NormalizeName(form, renamed))
+		default:
+			fmt.Printf("%s\t%s\trule %d\n", p, fileops.NormalizeName(form, renamed), rule)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
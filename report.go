@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/report"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+	"github.com/1995parham-learning/atomic-ingestor/internal/watcher"
+)
+
+// reportFirings is how many upcoming firings the report subcommand lists
+const reportFirings = 5
+
+// runReport implements the report subcommand. With -now it builds the
+// ingestion report of a period on demand; without, it lists when a
+// schedule fires next, to check a -report-schedule before deploying it.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+
+	now := fs.Bool("now", false, "Build the report of the period now")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	quarantinePath := fs.String("quarantine", config.DefaultQuarantinePath, "Quarantine directory the reasons are read from")
+	from := fs.String("from", "", "Start of the period, a date or RFC 3339 time (default 24 hours before -to)")
+	to := fs.String("to", "", "End of the period, exclusive, a date or RFC 3339 time (default now)")
+	top := fs.Int("top", config.DefaultReportTop, "How many sources to list")
+	format := fs.String("format", "table", "Report format (table or json)")
+	schedule := fs.String("schedule", "", "Cron expression whose next firings to list")
+	timezone := fs.String("timezone", "UTC", "Time zone the schedule is evaluated in")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if !*now {
+		if *schedule == "" {
+			slog.Error("report requires -now or a -schedule to list the firings of")
+			os.Exit(1)
+		}
+		loc, err := time.LoadLocation(*timezone)
+		if err != nil {
+			slog.Error("invalid timezone", "timezone", *timezone, "error", err)
+			os.Exit(1)
+		}
+		s, err := report.ParseSchedule(*schedule, loc)
+		if err != nil {
+			slog.Error("invalid schedule", "schedule", *schedule, "error", err)
+			os.Exit(1)
+		}
+		at := time.Now()
+		for range reportFirings {
+			if at = s.Next(at); at.IsZero() {
+				break
+			}
+			fmt.Println(at.Format(time.RFC3339))
+		}
+		return
+	}
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if *top <= 0 {
+		slog.Error("invalid top", "top", *top)
+		os.Exit(1)
+	}
+	end := time.Now().UTC()
+	if *to != "" {
+		var err error
+		if end, err = parseDigestTime(*to); err != nil {
+			slog.Error("invalid end of period", "to", *to, "error", err)
+			os.Exit(1)
+		}
+	}
+	start := end.Add(-24 * time.Hour)
+	if *from != "" {
+		var err error
+		if start, err = parseDigestTime(*from); err != nil {
+			slog.Error("invalid start of period", "from", *from, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	r, err := report.Build(store, start, end, report.Options{QuarantinePath: *quarantinePath, Top: *top})
+	if err != nil {
+		slog.Error("report failed", "error", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		err = report.WriteJSON(os.Stdout, r)
+	} else {
+		err = report.WriteTable(os.Stdout, r)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// backlogOf summarizes the files the watcher tracks for the ingestion report
+func backlogOf(tracked []watcher.TrackedFile, lag time.Duration) *report.Backlog {
+	b := &report.Backlog{Tracked: len(tracked), WatermarkLag: lag}
+	for _, f := range tracked {
+		if f.Ready {
+			b.Ready++
+		}
+		if !f.Since.IsZero() && (b.Oldest.IsZero() || f.Since.Before(b.Oldest)) {
+			b.Oldest = f.Since
+		}
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+)
+
+// rescanTimeout bounds a rescan request; a large subtree waiting for room
+// in tracking can take a while
+const rescanTimeout = 10 * time.Minute
+
+// runRescan implements the rescan subcommand, which asks the running daemon
+// through its admin API to walk one directory of the input and track the
+// files its watcher missed there
+func runRescan(args []string) {
+	fs := flag.NewFlagSet("rescan", flag.ExitOnError)
+
+	adminURL := fs.String("admin-url", "http://localhost:9090", "Base URL of the daemon's admin API")
+	adminToken := fs.String("admin-token", os.Getenv("ATOMIC_INGESTOR_ADMIN_TOKEN"), "Bearer token of the admin API (default from ATOMIC_INGESTOR_ADMIN_TOKEN)")
+	path := fs.String("path", "", "Directory to rescan, relative to the input directory, e.g. vendorB/2024-06-14")
+	forceReady := fs.Bool("force-ready", false, "Make the files of the directory ready on the next pass, skipping their stability window")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *path == "" {
+		slog.Error("rescan requires -path")
+		os.Exit(1)
+	}
+
+	query := url.Values{"path": {*path}, "force_ready": {strconv.FormatBool(*forceReady)}}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*adminURL, "/")+"/api/rescan?"+query.Encode(), nil)
+	if err != nil {
+		slog.Error("invalid admin url", "admin_url", *adminURL, "error", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Authorization", "Bearer "+*adminToken)
+
+	client := &http.Client{Timeout: rescanTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Error("rescan request failed", "admin_url", *adminURL, "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Error("failed to read rescan response", "error", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(body, &e)
+		slog.Error("rescan refused", "status", resp.StatusCode, "error", e.Error)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		slog.Error("invalid rescan response", "error", err)
+		os.Exit(1)
+	}
+	_, _ = out.WriteTo(os.Stdout)
+}
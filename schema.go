@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/manifest"
+)
+
+// runSchema implements the schema subcommand, which prints the JSON Schema
+// of manifest entries for consumers to generate code against
+func runSchema(_ []string) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest.Schema()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode schema: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+)
+
+// runSelfTest runs the processor self-test, closes the processor and
+// prints the report to stdout, returning the process exit code
+func runSelfTest(ctx context.Context, proc *processor.Processor) int {
+	report := proc.SelfTest(ctx)
+	if err := proc.Close(); err != nil {
+		slog.Error("failed to close manifest", "error", err)
+		report.OK = false
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		slog.Error("failed to write self-test report", "error", err)
+		return 1
+	}
+	if !report.OK {
+		slog.Error("self test failed", "probe", report.Probe)
+		return 1
+	}
+	slog.Info("self test passed", "duration", report.Duration)
+	return 0
+}
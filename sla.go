@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/sla"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// runSLAStatus implements the sla-status subcommand, which prints the state
+// of every SLA rule of a config file at its last deadline
+func runSLAStatus(args []string) {
+	fs := flag.NewFlagSet("sla-status", flag.ExitOnError)
+
+	configPath := fs.String("config", "", "YAML config file the SLA rules are read from")
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	input := fs.String("input", config.DefaultInputPath, "Input directory of the daemon, as passed to it")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if *configPath == "" {
+		slog.Error("sla-status requires the -config the SLA rules are in")
+		os.Exit(1)
+	}
+	fileCfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		slog.Error("invalid config file", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+	rules, err := sla.Compile(fileCfg.SLA)
+	if err != nil {
+		slog.Error("invalid SLA rules", "config", *configPath, "error", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	statuses, err := sla.Status(store, rules, *input, time.Now())
+	if err != nil {
+		slog.Error("sla status failed", "error", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		err = writeHistoryJSON(os.Stdout, statuses)
+	} else {
+		err = writeSLATable(os.Stdout, statuses)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// writeSLATable writes one line per SLA rule
+func writeSLATable(w io.Writer, statuses []sla.RuleStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tSTATE\tLAST DEADLINE\tNEXT DEADLINE\tUNRESOLVED\tFILE")
+	for _, st := range statuses {
+		last := "-"
+		if !st.LastDeadline.IsZero() {
+			last = st.LastDeadline.UTC().Format(time.RFC3339)
+		}
+		state := st.State
+		if state == "" {
+			state = "-"
+		}
+		file := st.File
+		if file != "" {
+			file += " at " + st.IngestedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			st.Rule, state, last, st.NextDeadline.UTC().Format(time.RFC3339), st.Unresolved, file)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write SLA status: %w", err)
+	}
+	return nil
+}
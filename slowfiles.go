@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// runSlowFiles implements the slow-files subcommand, which lists the
+// slowest of the files sampled by -timing-sample-rate within a period with
+// their per-stage breakdown
+func runSlowFiles(args []string) {
+	fs := flag.NewFlagSet("slow-files", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	from := fs.String("from", "", "Start of the period, a date or RFC 3339 time (default 24 hours before -to)")
+	to := fs.String("to", "", "End of the period, exclusive, a date or RFC 3339 time (default now)")
+	limit := fs.Int("limit", 20, "How many files to list (0 lists all)")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if *limit < 0 {
+		slog.Error("invalid limit", "limit", *limit)
+		os.Exit(1)
+	}
+	end := time.Now().UTC()
+	if *to != "" {
+		var err error
+		if end, err = parseDigestTime(*to); err != nil {
+			slog.Error("invalid end of period", "to", *to, "error", err)
+			os.Exit(1)
+		}
+	}
+	start := end.Add(-24 * time.Hour)
+	if *from != "" {
+		var err error
+		if start, err = parseDigestTime(*from); err != nil {
+			slog.Error("invalid start of period", "from", *from, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	timings, err := store.SlowFiles(start, end, *limit)
+	if err != nil {
+		slog.Error("slow-files failed", "error", err)
+		os.Exit(1)
+	}
+	if *format == "json" {
+		err = writeHistoryJSON(os.Stdout, timings)
+	} else {
+		err = writeSlowFilesTable(os.Stdout, timings)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+}
+
+// writeSlowFilesTable writes the breakdown of each timing, one per row
+func writeSlowFilesTable(w io.Writer, timings []storage.Timing) error {
+	us := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tTOTAL\tQUEUE\tHASH\tDEDUP\tTRANSFORM\tCOMMIT\tCOPY\tFSYNC\tMANIFEST\tNOTIFY\tSIZE\tOUTCOME\tPATH")
+	for _, t := range timings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			t.StartedAt.UTC().Format(time.RFC3339), us(t.Total), us(t.Queue), us(t.Hash), us(t.Dedup), us(t.Transform),
+			us(t.Commit), us(t.Copy), us(t.Fsync), us(t.Manifest), us(t.Notify), t.Size, t.Outcome, t.Path)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write slow files: %w", err)
+	}
+	return nil
+}
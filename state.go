@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// importProgressInterval is how often state import logs its progress
+const importProgressInterval = 5 * time.Second
+
+// runState implements the state subcommand, which carries the state
+// database over to another one through a snapshot file
+func runState(args []string) {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: atomic-ingestor state export -out <snapshot> [flags]")
+		fmt.Fprintln(os.Stderr, "       atomic-ingestor state import -in <snapshot> [flags]")
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "export":
+		runStateExport(args[1:])
+	case "import":
+		runStateImport(args[1:])
+	default:
+		usage()
+	}
+}
+
+// runStateExport writes a snapshot of every table of the state database.
+// It reads in one transaction on a read-only connection, so the daemon may
+// keep running.
+func runStateExport(args []string) {
+	fs := flag.NewFlagSet("state export", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	out := fs.String("out", "", "Snapshot file to write (required)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *out == "" {
+		slog.Error("snapshot destination is required", "flag", "out")
+		os.Exit(1)
+	}
+	requireDatabase(*statePath)
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	// Written next to the destination and renamed, so a failed export
+	// leaves no partial snapshot behind
+	start := time.Now()
+	tmp := *out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		slog.Error("failed to create snapshot", "out", *out, "error", err)
+		os.Exit(1)
+	}
+	info, err := store.ExportSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, *out)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		slog.Error("export failed", "error", err)
+		os.Exit(1)
+	}
+
+	for _, t := range info.Tables {
+		slog.Info("exported table", "table", t.Name, "rows", t.Rows)
+	}
+	slog.Info("snapshot written", "state_path", *statePath, "out", *out, "schema", info.Schema, "sha256", info.SHA256, "duration", time.Since(start))
+}
+
+// runStateImport loads a snapshot into a state database, creating its
+// schema first. Running it again after an interruption resumes it.
+func runStateImport(args []string) {
+	fs := flag.NewFlagSet("state import", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file to import into")
+	in := fs.String("in", "", "Snapshot file to read (required)")
+	batch := fs.Int("batch", 1000, "Rows inserted per statement")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	setupLogger(os.Stdout, *logLevel)
+
+	if *in == "" {
+		slog.Error("snapshot to import is required", "flag", "in")
+		os.Exit(1)
+	}
+	if *batch <= 0 {
+		slog.Error("invalid batch size", "batch", *batch)
+		os.Exit(1)
+	}
+	f, err := os.Open(*in)
+	if err != nil {
+		slog.Error("failed to open snapshot", "in", *in, "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = f.Close() }()
+
+	start := time.Now()
+	var logged time.Time
+	tables, err := openDatabase(*statePath, os.Stdout).ImportSnapshot(f, storage.ImportOptions{
+		BatchSize: *batch,
+		Progress: func(table string, done, total int64) {
+			if done < total && time.Since(logged) < importProgressInterval {
+				return
+			}
+			logged = time.Now()
+			slog.Info("importing table", "table", table, "rows", done, "total", total)
+		},
+	})
+	if err != nil {
+		slog.Error("import failed", "in", *in, "error", err)
+		os.Exit(1)
+	}
+
+	for _, t := range tables {
+		slog.Info("imported table", "table", t.Name, "inserted", t.Inserted, "skipped", t.Skipped)
+	}
+	slog.Info("snapshot imported", "state_path", *statePath, "in", *in, "duration", time.Since(start))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/1995parham-learning/atomic-ingestor/internal/config"
+	"github.com/1995parham-learning/atomic-ingestor/internal/fileops"
+	"github.com/1995parham-learning/atomic-ingestor/internal/processor"
+	"github.com/1995parham-learning/atomic-ingestor/internal/status"
+	"github.com/1995parham-learning/atomic-ingestor/internal/storage"
+)
+
+// Problems reported by the verify subcommand
+const (
+	problemMissing       = "missing"
+	problemSizeMismatch  = "size_mismatch"
+	problemHashMismatch  = "hash_mismatch"
+	problemXattrMismatch = "xattr_mismatch"
+)
+
+// verifyProblem is a warehouse file that does not match its record
+type verifyProblem struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	Problem string `json:"problem"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// runVerify implements the verify subcommand, which checks the warehouse
+// copy of every ingested file against its record: that it exists with the
+// recorded size, optionally the recorded hash, and that any extended
+// attributes set by -set-xattrs agree with the database. Files whose
+// manifest entry is still pending are reported as in progress, not as
+// problems.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+
+	statePath := fs.String("state-path", config.DefaultStatePath, "Path to state database file")
+	rehash := fs.Bool("hash", false, "Also hash every warehouse copy and compare it with the recorded sha256")
+	format := fs.String("format", "table", "Report format (table or json)")
+	logLevel := fs.String("log-level", config.DefaultLogLevel, "Log level (debug, info, warn, error)")
+
+	_ = fs.Parse(args)
+
+	// Keep stdout clean for the report
+	setupLogger(os.Stderr, *logLevel)
+
+	if *format != "table" && *format != "json" {
+		slog.Error("invalid report format", "format", *format)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*statePath); errors.Is(err, os.ErrNotExist) {
+		slog.Error("state database does not exist", "state_path", *statePath)
+		os.Exit(1)
+	}
+	store, err := storage.OpenReadOnly(*statePath)
+	if err != nil {
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	files, err := store.ListFiles(storage.FileFilter{Status: status.Ingested})
+	if err != nil {
+		slog.Error("verify failed", "error", err)
+		os.Exit(1)
+	}
+	problems := []verifyProblem{}
+	for _, f := range files {
+		problems = append(problems, verifyFile(f, *rehash)...)
+	}
+	if *format == "json" {
+		err = writeHistoryJSON(os.Stdout, problems)
+	} else {
+		err = writeVerifyTable(os.Stdout, problems)
+	}
+	if err != nil {
+		slog.Error("failed to write report", "error", err)
+		os.Exit(1)
+	}
+	pending, err := store.PendingManifestEntries()
+	if err != nil {
+		slog.Error("verify failed", "error", err)
+		os.Exit(1)
+	}
+	if len(pending) > 0 {
+		slog.Info("manifest entries pending, the daemon appends them once the manifests can be written", "entries", len(pending))
+	}
+	if len(problems) > 0 {
+		slog.Error("warehouse does not match the database", "files", len(files), "problems", len(problems))
+		os.Exit(1)
+	}
+	slog.Info("warehouse matches the database", "files", len(files))
+}
+
+// verifyFile returns the problems of the warehouse copy of f
+func verifyFile(f storage.File, rehash bool) []verifyProblem {
+	problem := func(kind, detail string) verifyProblem {
+		return verifyProblem{Path: f.DestPath, SHA256: f.SHA256, Problem: kind, Detail: detail}
+	}
+
+	info, err := os.Stat(f.DestPath)
+	if err != nil {
+		return []verifyProblem{problem(problemMissing, err.Error())}
+	}
+	var problems []verifyProblem
+	if info.Size() != f.Size {
+		problems = append(problems, problem(problemSizeMismatch, fmt.Sprintf("recorded %d, found %d", f.Size, info.Size())))
+	}
+	if rehash {
+		sum, err := fileops.CalculateSHA256(f.DestPath)
+		if err != nil {
+			slog.Warn("failed to hash warehouse copy", "destination", f.DestPath, "error", err)
+		} else if sum != f.SHA256 {
+			problems = append(problems, problem(problemHashMismatch, "found "+sum))
+		}
+	}
+
+	attrs, err := fileops.GetXattrs(f.DestPath, processor.XattrNames)
+	if err != nil {
+		slog.Warn("failed to read extended attributes", "destination", f.DestPath, "error", err)
+		return problems
+	}
+	want := map[string]string{
+		processor.XattrSHA256:      f.SHA256,
+		processor.XattrIngestID:    processor.IngestID(f.SHA256, f.ProcessedAt, f.Sequence),
+		processor.XattrProcessedAt: processor.FormatProcessedAt(f.ProcessedAt),
+		processor.XattrSource:      f.Source,
+	}
+	// Only the attributes present are checked, files ingested without
+	// -set-xattrs have none
+	for _, name := range processor.XattrNames {
+		if got, ok := attrs[name]; ok && got != want[name] {
+			problems = append(problems, problem(problemXattrMismatch, fmt.Sprintf("%s is %q, recorded %q", name, got, want[name])))
+		}
+	}
+	return problems
+}
+
+// writeVerifyTable writes the problems found
+func writeVerifyTable(w io.Writer, problems []verifyProblem) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROBLEM\tPATH\tSHA256\tDETAIL")
+	for _, p := range problems {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.Problem, p.Path, p.SHA256, p.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("write verify report: %w", err)
+	}
+	return nil
+}